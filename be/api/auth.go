@@ -172,3 +172,21 @@ func DeleteToken(repo *dblayer.DBRepository, tokenString string) error {
 	}
 	return err
 }
+
+// authenticatedRepository builds the repository for the user of the request,
+// responding 401 when the request has no valid token
+func authenticatedRepository(w http.ResponseWriter, r *http.Request) (*dblayer.DBRepository, bool) {
+	claims, err := GetClaimsFromRequest(r)
+	if err != nil {
+		RespondSimpleError(w, ErrUnauthorized, "Unauthorized", http.StatusUnauthorized)
+		return nil, false
+	}
+	dbContext := &dblayer.DBContext{
+		UserID:   claims["user_id"],
+		GroupIDs: strings.Split(claims["groups"], ","),
+		Schema:   dblayer.DbSchema,
+	}
+	repo := dblayer.NewDBRepository(dbContext, dblayer.Factory, dblayer.DbConnection)
+	repo.Verbose = false
+	return repo, true
+}
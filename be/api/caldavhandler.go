@@ -0,0 +1,448 @@
+package api
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"rprj/be/dblayer"
+)
+
+// calDAVPrefix is the root of the CalDAV tree:
+//
+//	/caldav/principals/{login}/         the principal of the user
+//	/caldav/calendars/                  the calendar home
+//	/caldav/calendars/{folder}/         a folder containing events
+//	/caldav/calendars/{folder}/{name}   an event, "<id>.ics" unless named by the client
+const calDAVPrefix = "/caldav"
+
+// icalDateTimeUTC is the format of the calendar-query time-range attributes
+const icalDateTimeUTC = "20060102T150405Z"
+
+var (
+	propResourceType       = xml.Name{Space: davNS, Local: "resourcetype"}
+	propDisplayName        = xml.Name{Space: davNS, Local: "displayname"}
+	propCurrentPrincipal   = xml.Name{Space: davNS, Local: "current-user-principal"}
+	propPrincipalURL       = xml.Name{Space: davNS, Local: "principal-URL"}
+	propPrivilegeSet       = xml.Name{Space: davNS, Local: "current-user-privilege-set"}
+	propGetETag            = xml.Name{Space: davNS, Local: "getetag"}
+	propGetContentType     = xml.Name{Space: davNS, Local: "getcontenttype"}
+	propGetLastModified    = xml.Name{Space: davNS, Local: "getlastmodified"}
+	propCalendarHomeSet    = xml.Name{Space: calDAVNS, Local: "calendar-home-set"}
+	propCalendarData       = xml.Name{Space: calDAVNS, Local: "calendar-data"}
+	propSupportedComponent = xml.Name{Space: calDAVNS, Local: "supported-calendar-component-set"}
+	propGetCTag            = xml.Name{Space: calendarSrvNS, Local: "getctag"}
+)
+
+// calDAVNoUIDConflict is the precondition failed by a PUT with the UID of another event
+var calDAVNoUIDConflict = xml.Name{Space: calDAVNS, Local: "no-uid-conflict"}
+
+// CalDAVHandler godoc
+// @Summary CalDAV server
+// @Description CalDAV (RFC 4791) access to the events: every folder containing events is a calendar collection.
+// @Description Supports OPTIONS, PROPFIND, REPORT (calendar-query, calendar-multiget), GET, PUT and DELETE.
+// @Description Authentication with HTTP Basic (rhobee login and password) or a Bearer token.
+// @Tags events
+// @Success 207 {string} string "Multistatus"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Failure 409 {string} string "PUT of an event with the UID of another event (no-uid-conflict)"
+// @Router /caldav/ [propfind]
+func CalDAVHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.Header().Set("DAV", "1, 3, calendar-access")
+		w.Header().Set("Allow", "OPTIONS, PROPFIND, REPORT, GET, HEAD, PUT, DELETE")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	user, ok := davAuthenticate(w, r)
+	if !ok {
+		return
+	}
+	loc, ok := calendarLocation(w, r)
+	if !ok {
+		return
+	}
+
+	segments := []string{}
+	for _, segment := range strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, calDAVPrefix), "/"), "/") {
+		if segment != "" {
+			segments = append(segments, segment)
+		}
+	}
+	switch {
+	case len(segments) == 0:
+		calDAVRoot(w, r, user)
+	case segments[0] == "principals" && len(segments) == 2:
+		if segments[1] != user.Login {
+			RespondSimpleError(w, ErrObjectNotFound, "Principal not found", http.StatusNotFound)
+			return
+		}
+		calDAVPrincipal(w, r, user)
+	case segments[0] == "calendars" && len(segments) == 1:
+		calDAVHome(w, r, user)
+	case segments[0] == "calendars" && len(segments) == 2:
+		calDAVCollection(w, r, user, normalizeObjectID(segments[1]), loc)
+	case segments[0] == "calendars" && len(segments) == 3:
+		calDAVEvent(w, r, user, normalizeObjectID(segments[1]), segments[2], loc)
+	default:
+		RespondSimpleError(w, ErrObjectNotFound, "Resource not found", http.StatusNotFound)
+	}
+}
+
+// WellKnownCalDAVHandler redirects the service discovery of calendar clients (RFC 6764)
+func WellKnownCalDAVHandler(w http.ResponseWriter, r *http.Request) {
+	http.Redirect(w, r, calDAVPrefix+"/", http.StatusMovedPermanently)
+}
+
+func calDAVPrincipalHref(user *davUser) string {
+	return calDAVPrefix + "/principals/" + url.PathEscape(user.Login) + "/"
+}
+
+func calDAVCollectionHref(folderID string) string {
+	return calDAVPrefix + "/calendars/" + folderID + "/"
+}
+
+// calDAVCommonProps are the properties of every resource
+func calDAVCommonProps(user *davUser, resourceType string, name string) map[xml.Name]string {
+	return map[xml.Name]string{
+		propResourceType:     resourceType,
+		propDisplayName:      davEscape(name),
+		propCurrentPrincipal: davHref(calDAVPrincipalHref(user)),
+	}
+}
+
+func calDAVRoot(w http.ResponseWriter, r *http.Request, user *davUser) {
+	request, ok := davPropfind(w, r)
+	if !ok {
+		return
+	}
+	root := davResource{Href: calDAVPrefix + "/", Props: calDAVCommonProps(user, "<D:collection/>", "rhobee")}
+	root.Props[propCalendarHomeSet] = davHref(calDAVPrefix + "/calendars/")
+	writeMultistatus(w, []davResource{root}, request)
+}
+
+func calDAVPrincipal(w http.ResponseWriter, r *http.Request, user *davUser) {
+	request, ok := davPropfind(w, r)
+	if !ok {
+		return
+	}
+	principal := davResource{
+		Href:  calDAVPrincipalHref(user),
+		Props: calDAVCommonProps(user, "<D:collection/><D:principal/>", user.Login),
+	}
+	principal.Props[propPrincipalURL] = davHref(calDAVPrincipalHref(user))
+	principal.Props[propCalendarHomeSet] = davHref(calDAVPrefix + "/calendars/")
+	writeMultistatus(w, []davResource{principal}, request)
+}
+
+func calDAVHome(w http.ResponseWriter, r *http.Request, user *davUser) {
+	request, ok := davPropfind(w, r)
+	if !ok {
+		return
+	}
+	home := davResource{Href: calDAVPrefix + "/calendars/", Props: calDAVCommonProps(user, "<D:collection/>", "Calendars")}
+	home.Props[propPrivilegeSet] = davPrivileges(true, false)
+	resources := []davResource{home}
+	if davDepth(r, 0) == 1 {
+		folders, err := user.Repo.GetCalendarFolders()
+		if err != nil {
+			log.Printf("calDAVHome: %v", err)
+			RespondSimpleError(w, ErrInternalServer, "Failed to read the calendars", http.StatusInternalServerError)
+			return
+		}
+		for _, folder := range folders {
+			events, err := user.Repo.GetCalendarEvents(folder.GetValue("id").(string), "")
+			if err != nil {
+				log.Printf("calDAVHome: %v", err)
+				continue
+			}
+			resources = append(resources, calDAVCollectionResource(user, folder, events))
+		}
+	}
+	writeMultistatus(w, resources, request)
+}
+
+// calDAVCollectionResource describes a folder as a calendar collection.
+// The ctag changes whenever an event is added, modified or removed.
+func calDAVCollectionResource(user *davUser, folder dblayer.DBEntityInterface, events []dblayer.DBEntityInterface) davResource {
+	folderID, _ := folder.GetValue("id").(string)
+	name, _ := folder.GetValue("name").(string)
+	hash := sha1.New()
+	for _, event := range events {
+		io.WriteString(hash, davObjectETag(event))
+	}
+	ctag := `"` + hex.EncodeToString(hash.Sum(nil)) + `"`
+
+	resource := davResource{
+		Href:  calDAVCollectionHref(folderID),
+		Props: calDAVCommonProps(user, "<D:collection/><C:calendar/>", name),
+	}
+	resource.Props[propGetCTag] = ctag
+	resource.Props[propGetETag] = ctag
+	resource.Props[propSupportedComponent] = `<C:comp name="VEVENT"/>`
+	resource.Props[propPrivilegeSet] = davPrivileges(true, user.Repo.CheckWritePermission(folder))
+	return resource
+}
+
+// calDAVEventResource describes an event; the calendar data is added only when requested
+func calDAVEventResource(user *davUser, folderID string, href string, event dblayer.DBEntityInterface, request *davRequest, uid string, loc *time.Location) davResource {
+	resource := davResource{
+		Href: calDAVCollectionHref(folderID) + url.PathEscape(href),
+		Props: map[xml.Name]string{
+			propResourceType:     "",
+			propGetETag:          davEscape(davObjectETag(event)),
+			propGetContentType:   "text/calendar; charset=utf-8; component=VEVENT",
+			propPrivilegeSet:     davPrivileges(true, user.Repo.CheckWritePermission(event)),
+			propCurrentPrincipal: davHref(calDAVPrincipalHref(user)),
+		},
+	}
+	if modified, ok := event.GetValue("last_modify_date").(string); ok {
+		if t, err := dblayer.ParseDateTimeIn(modified, loc); err == nil {
+			resource.Props[propGetLastModified] = t.UTC().Format(http.TimeFormat)
+		}
+	}
+	for _, name := range request.Props {
+		if name == propCalendarData {
+			if data, err := dblayer.BuildEventICalendar(event, uid, loc); err == nil {
+				resource.Props[propCalendarData] = davEscape(data)
+			}
+		}
+	}
+	return resource
+}
+
+// calDAVFolder loads a readable folder, responding 404 otherwise
+func calDAVFolder(w http.ResponseWriter, user *davUser, folderID string) (dblayer.DBEntityInterface, bool) {
+	folder := user.Repo.GetEntityByID("folders", folderID)
+	if folder == nil || folder.(dblayer.DBObjectInterface).HasDeletedDate() || !user.Repo.CheckReadPermission(folder) {
+		RespondSimpleError(w, ErrObjectNotFound, "Calendar not found", http.StatusNotFound)
+		return nil, false
+	}
+	return folder, true
+}
+
+func calDAVCollection(w http.ResponseWriter, r *http.Request, user *davUser, folderID string, loc *time.Location) {
+	folder, ok := calDAVFolder(w, user, folderID)
+	if !ok {
+		return
+	}
+	if r.Method != "PROPFIND" && r.Method != "REPORT" {
+		w.Header().Set("Allow", "OPTIONS, PROPFIND, REPORT")
+		RespondSimpleError(w, ErrInvalidRequest, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	request, err := parseDAVRequest(r.Body)
+	if err != nil {
+		RespondSimpleError(w, ErrInvalidRequest, "Invalid XML body", http.StatusBadRequest)
+		return
+	}
+	events, err := user.Repo.GetCalendarEvents(folderID, "")
+	if err != nil {
+		log.Printf("calDAVCollection: %v", err)
+		RespondSimpleError(w, ErrInternalServer, "Failed to read events", http.StatusInternalServerError)
+		return
+	}
+	hrefs := user.Repo.GetEventHrefs(events)
+	uids := user.Repo.GetEventUIDs(events)
+
+	if r.Method == "PROPFIND" {
+		// calendar-data is served by GET and REPORT only
+		request.Props = dropProp(request.Props, propCalendarData)
+		resources := []davResource{calDAVCollectionResource(user, folder, events)}
+		if davDepth(r, 0) == 1 {
+			for _, event := range events {
+				id := event.GetValue("id").(string)
+				resources = append(resources, calDAVEventResource(user, folderID, hrefs[id], event, request, uids[id], loc))
+			}
+		}
+		writeMultistatus(w, resources, request)
+		return
+	}
+
+	switch {
+	case request.Root.Space == calDAVNS && request.Root.Local == "calendar-query":
+		from, to, err := calDAVTimeRange(request)
+		if err != nil {
+			RespondError(w, ErrInvalidRequest, "Invalid time-range", map[string]string{"field": "time-range"}, http.StatusBadRequest)
+			return
+		}
+		resources := []davResource{}
+		for _, event := range events {
+			if !from.IsZero() || !to.IsZero() {
+				occurrences, err := dblayer.ExpandEvent(event, from, to, loc)
+				if err != nil || len(occurrences) == 0 {
+					continue
+				}
+			}
+			id := event.GetValue("id").(string)
+			resources = append(resources, calDAVEventResource(user, folderID, hrefs[id], event, request, uids[id], loc))
+		}
+		writeMultistatus(w, resources, request)
+	case request.Root.Space == calDAVNS && request.Root.Local == "calendar-multiget":
+		byHref := make(map[string]dblayer.DBEntityInterface)
+		for _, event := range events {
+			byHref[hrefs[event.GetValue("id").(string)]] = event
+		}
+		resources := []davResource{}
+		missing := []string{}
+		for _, href := range request.Hrefs {
+			name := davResourceName(href)
+			event, found := byHref[name]
+			if !found {
+				missing = append(missing, davStatusResponse(href, http.StatusNotFound))
+				continue
+			}
+			id := event.GetValue("id").(string)
+			resources = append(resources, calDAVEventResource(user, folderID, name, event, request, uids[id], loc))
+		}
+		writeMultistatus(w, resources, request, missing...)
+	default:
+		RespondSimpleError(w, ErrInvalidRequest, "Report not supported", http.StatusNotImplemented)
+	}
+}
+
+// calDAVTimeRange reads the time-range of a calendar-query; zero times are open ends
+func calDAVTimeRange(request *davRequest) (time.Time, time.Time, error) {
+	var from, to time.Time
+	var err error
+	if request.TimeRangeStart != "" {
+		if from, err = time.Parse(icalDateTimeUTC, request.TimeRangeStart); err != nil {
+			return from, to, err
+		}
+	}
+	if request.TimeRangeEnd != "" {
+		if to, err = time.Parse(icalDateTimeUTC, request.TimeRangeEnd); err != nil {
+			return from, to, err
+		}
+	} else if !from.IsZero() {
+		to = from.AddDate(10, 0, 0)
+	}
+	return from, to, nil
+}
+
+func calDAVEvent(w http.ResponseWriter, r *http.Request, user *davUser, folderID string, name string, loc *time.Location) {
+	folder, ok := calDAVFolder(w, user, folderID)
+	if !ok {
+		return
+	}
+	event := user.Repo.FindEventByHref(folderID, name)
+	if event != nil && !user.Repo.CheckReadPermission(event) {
+		davRefuseUnreadable(w, r, "Event not found")
+		return
+	}
+	etag := ""
+	if event != nil {
+		etag = davObjectETag(event)
+	}
+
+	switch r.Method {
+	case "PROPFIND":
+		if event == nil {
+			RespondSimpleError(w, ErrObjectNotFound, "Event not found", http.StatusNotFound)
+			return
+		}
+		request, err := parseDAVRequest(r.Body)
+		if err != nil {
+			RespondSimpleError(w, ErrInvalidRequest, "Invalid XML body", http.StatusBadRequest)
+			return
+		}
+		request.Props = dropProp(request.Props, propCalendarData)
+		uids := user.Repo.GetEventUIDs([]dblayer.DBEntityInterface{event})
+		writeMultistatus(w, []davResource{calDAVEventResource(user, folderID, name, event, request, uids[event.GetValue("id").(string)], loc)}, request)
+
+	case http.MethodGet, http.MethodHead:
+		if event == nil {
+			RespondSimpleError(w, ErrObjectNotFound, "Event not found", http.StatusNotFound)
+			return
+		}
+		uids := user.Repo.GetEventUIDs([]dblayer.DBEntityInterface{event})
+		data, err := dblayer.BuildEventICalendar(event, uids[event.GetValue("id").(string)], loc)
+		if err != nil {
+			log.Printf("calDAVEvent: %v", err)
+			RespondSimpleError(w, ErrInternalServer, "Invalid event", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+		w.Header().Set("ETag", etag)
+		w.WriteHeader(http.StatusOK)
+		if r.Method == http.MethodGet {
+			io.WriteString(w, data)
+		}
+
+	case http.MethodPut:
+		if !davETagMatches(r, etag) {
+			RespondSimpleError(w, ErrInvalidRequest, "Precondition failed", http.StatusPreconditionFailed)
+			return
+		}
+		if event == nil && !user.Repo.CheckWritePermission(folder) {
+			RespondSimpleError(w, ErrForbidden, "Permission denied", http.StatusForbidden)
+			return
+		}
+		if event != nil && !user.Repo.CheckWritePermission(event) {
+			RespondSimpleError(w, ErrForbidden, "Permission denied", http.StatusForbidden)
+			return
+		}
+		data, err := io.ReadAll(io.LimitReader(r.Body, maxDAVBodySize))
+		if err != nil {
+			RespondSimpleError(w, ErrInvalidRequest, "Failed to read the body", http.StatusBadRequest)
+			return
+		}
+		saved, err := user.Repo.SaveCalDAVEvent(folderID, name, string(data), event, loc)
+		var conflict *dblayer.ICalUIDConflictError
+		if errors.As(err, &conflict) {
+			// The href of the event using the UID, when readable
+			href := ""
+			if other := user.Repo.GetEntityByID("events", conflict.EventID); other != nil && user.Repo.CheckReadPermission(other) {
+				otherFolder, _ := other.GetValue("father_id").(string)
+				name := user.Repo.GetEventHrefs([]dblayer.DBEntityInterface{other})[conflict.EventID]
+				href = davHref(calDAVCollectionHref(otherFolder) + url.PathEscape(name))
+			}
+			writeDAVError(w, http.StatusConflict, calDAVNoUIDConflict, href)
+			return
+		}
+		if err != nil {
+			RespondSimpleError(w, ErrInvalidRequest, "Invalid iCalendar data: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if reloaded := user.Repo.GetEntityByID("events", saved.GetValue("id").(string)); reloaded != nil {
+			saved = reloaded
+		}
+		w.Header().Set("ETag", davObjectETag(saved))
+		if event == nil {
+			w.WriteHeader(http.StatusCreated)
+		} else {
+			w.WriteHeader(http.StatusNoContent)
+		}
+
+	case http.MethodDelete:
+		if event == nil {
+			RespondSimpleError(w, ErrObjectNotFound, "Event not found", http.StatusNotFound)
+			return
+		}
+		if !davETagMatches(r, etag) {
+			RespondSimpleError(w, ErrInvalidRequest, "Precondition failed", http.StatusPreconditionFailed)
+			return
+		}
+		if !user.Repo.CheckWritePermission(event) {
+			RespondSimpleError(w, ErrForbidden, "Permission denied", http.StatusForbidden)
+			return
+		}
+		if _, err := user.Repo.Delete(event); err != nil {
+			log.Printf("calDAVEvent: %v", err)
+			RespondSimpleError(w, ErrInternalServer, "Failed to delete the event", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		w.Header().Set("Allow", "OPTIONS, PROPFIND, GET, HEAD, PUT, DELETE")
+		RespondSimpleError(w, ErrInvalidRequest, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,375 @@
+package api
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"rprj/be/dblayer"
+)
+
+// cardDAVPrefix is the root of the CardDAV tree:
+//
+//	/carddav/principals/{login}/            the principal of the user
+//	/carddav/addressbooks/                  the address book home
+//	/carddav/addressbooks/{folder}/         a folder containing people or companies
+//	/carddav/addressbooks/{folder}/{name}   a contact, "<id>.vcf" unless named by the client
+const cardDAVPrefix = "/carddav"
+
+var (
+	propAddressBookHomeSet = xml.Name{Space: cardDAVNS, Local: "addressbook-home-set"}
+	propAddressData        = xml.Name{Space: cardDAVNS, Local: "address-data"}
+	propSupportedAddress   = xml.Name{Space: cardDAVNS, Local: "supported-address-data"}
+)
+
+// CardDAVHandler godoc
+// @Summary CardDAV server
+// @Description CardDAV (RFC 6352) access to the contacts: every folder containing people or companies is an address book.
+// @Description Supports OPTIONS, PROPFIND, REPORT (addressbook-query, addressbook-multiget), GET, PUT and DELETE.
+// @Description The filters of addressbook-query are not evaluated: all the contacts of the address book are returned.
+// @Description Authentication with HTTP Basic (rhobee login and password) or a Bearer token.
+// @Tags contacts
+// @Success 207 {string} string "Multistatus"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Failure 409 {object} ErrorResponse "PUT of a vCard with the UID of another contact"
+// @Router /carddav/ [propfind]
+func CardDAVHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.Header().Set("DAV", "1, 3, addressbook")
+		w.Header().Set("Allow", "OPTIONS, PROPFIND, REPORT, GET, HEAD, PUT, DELETE")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	user, ok := davAuthenticate(w, r)
+	if !ok {
+		return
+	}
+
+	segments := []string{}
+	for _, segment := range strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, cardDAVPrefix), "/"), "/") {
+		if segment != "" {
+			segments = append(segments, segment)
+		}
+	}
+	switch {
+	case len(segments) == 0:
+		cardDAVRoot(w, r, user)
+	case segments[0] == "principals" && len(segments) == 2:
+		if segments[1] != user.Login {
+			RespondSimpleError(w, ErrObjectNotFound, "Principal not found", http.StatusNotFound)
+			return
+		}
+		cardDAVPrincipal(w, r, user)
+	case segments[0] == "addressbooks" && len(segments) == 1:
+		cardDAVHome(w, r, user)
+	case segments[0] == "addressbooks" && len(segments) == 2:
+		cardDAVCollection(w, r, user, normalizeObjectID(segments[1]))
+	case segments[0] == "addressbooks" && len(segments) == 3:
+		cardDAVContact(w, r, user, normalizeObjectID(segments[1]), segments[2])
+	default:
+		RespondSimpleError(w, ErrObjectNotFound, "Resource not found", http.StatusNotFound)
+	}
+}
+
+// WellKnownCardDAVHandler redirects the service discovery of address book clients (RFC 6764)
+func WellKnownCardDAVHandler(w http.ResponseWriter, r *http.Request) {
+	http.Redirect(w, r, cardDAVPrefix+"/", http.StatusMovedPermanently)
+}
+
+func cardDAVPrincipalHref(user *davUser) string {
+	return cardDAVPrefix + "/principals/" + url.PathEscape(user.Login) + "/"
+}
+
+func cardDAVCollectionHref(folderID string) string {
+	return cardDAVPrefix + "/addressbooks/" + folderID + "/"
+}
+
+// cardDAVCommonProps are the properties of every resource
+func cardDAVCommonProps(user *davUser, resourceType string, name string) map[xml.Name]string {
+	return map[xml.Name]string{
+		propResourceType:     resourceType,
+		propDisplayName:      davEscape(name),
+		propCurrentPrincipal: davHref(cardDAVPrincipalHref(user)),
+	}
+}
+
+func cardDAVRoot(w http.ResponseWriter, r *http.Request, user *davUser) {
+	request, ok := davPropfind(w, r)
+	if !ok {
+		return
+	}
+	root := davResource{Href: cardDAVPrefix + "/", Props: cardDAVCommonProps(user, "<D:collection/>", "rhobee")}
+	root.Props[propAddressBookHomeSet] = davHref(cardDAVPrefix + "/addressbooks/")
+	writeMultistatus(w, []davResource{root}, request)
+}
+
+func cardDAVPrincipal(w http.ResponseWriter, r *http.Request, user *davUser) {
+	request, ok := davPropfind(w, r)
+	if !ok {
+		return
+	}
+	principal := davResource{
+		Href:  cardDAVPrincipalHref(user),
+		Props: cardDAVCommonProps(user, "<D:collection/><D:principal/>", user.Login),
+	}
+	principal.Props[propPrincipalURL] = davHref(cardDAVPrincipalHref(user))
+	principal.Props[propAddressBookHomeSet] = davHref(cardDAVPrefix + "/addressbooks/")
+	writeMultistatus(w, []davResource{principal}, request)
+}
+
+func cardDAVHome(w http.ResponseWriter, r *http.Request, user *davUser) {
+	request, ok := davPropfind(w, r)
+	if !ok {
+		return
+	}
+	home := davResource{Href: cardDAVPrefix + "/addressbooks/", Props: cardDAVCommonProps(user, "<D:collection/>", "Address books")}
+	home.Props[propPrivilegeSet] = davPrivileges(true, false)
+	resources := []davResource{home}
+	if davDepth(r, 0) == 1 {
+		folders, err := user.Repo.GetAddressBookFolders()
+		if err != nil {
+			log.Printf("cardDAVHome: %v", err)
+			RespondSimpleError(w, ErrInternalServer, "Failed to read the address books", http.StatusInternalServerError)
+			return
+		}
+		for _, folder := range folders {
+			contacts, err := user.Repo.GetFolderContacts(folder.GetValue("id").(string))
+			if err != nil {
+				log.Printf("cardDAVHome: %v", err)
+				continue
+			}
+			resources = append(resources, cardDAVCollectionResource(user, folder, contacts))
+		}
+	}
+	writeMultistatus(w, resources, request)
+}
+
+// cardDAVCollectionResource describes a folder as an address book.
+// The ctag changes whenever a contact is added, modified or removed.
+func cardDAVCollectionResource(user *davUser, folder dblayer.DBEntityInterface, contacts []dblayer.DBEntityInterface) davResource {
+	folderID, _ := folder.GetValue("id").(string)
+	name, _ := folder.GetValue("name").(string)
+	hash := sha1.New()
+	for _, contact := range contacts {
+		io.WriteString(hash, davObjectETag(contact))
+	}
+	ctag := `"` + hex.EncodeToString(hash.Sum(nil)) + `"`
+
+	resource := davResource{
+		Href:  cardDAVCollectionHref(folderID),
+		Props: cardDAVCommonProps(user, "<D:collection/><CR:addressbook/>", name),
+	}
+	resource.Props[propGetCTag] = ctag
+	resource.Props[propGetETag] = ctag
+	resource.Props[propSupportedAddress] = `<CR:address-data-type content-type="text/vcard" version="3.0"/>` +
+		`<CR:address-data-type content-type="text/vcard" version="4.0"/>`
+	resource.Props[propPrivilegeSet] = davPrivileges(true, user.Repo.CheckWritePermission(folder))
+	return resource
+}
+
+// cardDAVContactResource describes a contact; the vCard is added only when requested
+func cardDAVContactResource(user *davUser, folderID string, href string, contact dblayer.DBEntityInterface, request *davRequest) davResource {
+	resource := davResource{
+		Href: cardDAVCollectionHref(folderID) + url.PathEscape(href),
+		Props: map[xml.Name]string{
+			propResourceType:     "",
+			propGetETag:          davEscape(davObjectETag(contact)),
+			propGetContentType:   "text/vcard; charset=utf-8",
+			propPrivilegeSet:     davPrivileges(true, user.Repo.CheckWritePermission(contact)),
+			propCurrentPrincipal: davHref(cardDAVPrincipalHref(user)),
+		},
+	}
+	if modified, ok := contact.GetValue("last_modify_date").(string); ok {
+		if t, err := dblayer.ParseDateTime(modified); err == nil {
+			resource.Props[propGetLastModified] = t.UTC().Format(http.TimeFormat)
+		}
+	}
+	for _, name := range request.Props {
+		if name == propAddressData {
+			resource.Props[propAddressData] = davEscape(user.Repo.ContactVCard(contact, dblayer.VCardVersion3))
+		}
+	}
+	return resource
+}
+
+// cardDAVFolder loads a readable folder, responding 404 otherwise
+func cardDAVFolder(w http.ResponseWriter, user *davUser, folderID string) (dblayer.DBEntityInterface, bool) {
+	folder := user.Repo.GetEntityByID("folders", folderID)
+	if folder == nil || folder.(dblayer.DBObjectInterface).HasDeletedDate() || !user.Repo.CheckReadPermission(folder) {
+		RespondSimpleError(w, ErrObjectNotFound, "Address book not found", http.StatusNotFound)
+		return nil, false
+	}
+	return folder, true
+}
+
+func cardDAVCollection(w http.ResponseWriter, r *http.Request, user *davUser, folderID string) {
+	folder, ok := cardDAVFolder(w, user, folderID)
+	if !ok {
+		return
+	}
+	if r.Method != "PROPFIND" && r.Method != "REPORT" {
+		w.Header().Set("Allow", "OPTIONS, PROPFIND, REPORT")
+		RespondSimpleError(w, ErrInvalidRequest, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	request, err := parseDAVRequest(r.Body)
+	if err != nil {
+		RespondSimpleError(w, ErrInvalidRequest, "Invalid XML body", http.StatusBadRequest)
+		return
+	}
+	contacts, err := user.Repo.GetFolderContacts(folderID)
+	if err != nil {
+		log.Printf("cardDAVCollection: %v", err)
+		RespondSimpleError(w, ErrInternalServer, "Failed to read contacts", http.StatusInternalServerError)
+		return
+	}
+	hrefs := user.Repo.GetContactHrefs(contacts)
+
+	switch {
+	case r.Method == "PROPFIND":
+		// address-data is served by GET and REPORT only
+		request.Props = dropProp(request.Props, propAddressData)
+		resources := []davResource{cardDAVCollectionResource(user, folder, contacts)}
+		if davDepth(r, 0) == 1 {
+			for _, contact := range contacts {
+				resources = append(resources, cardDAVContactResource(user, folderID, hrefs[contact.GetValue("id").(string)], contact, request))
+			}
+		}
+		writeMultistatus(w, resources, request)
+	case request.Root.Space == cardDAVNS && request.Root.Local == "addressbook-query":
+		resources := []davResource{}
+		for _, contact := range contacts {
+			resources = append(resources, cardDAVContactResource(user, folderID, hrefs[contact.GetValue("id").(string)], contact, request))
+		}
+		writeMultistatus(w, resources, request)
+	case request.Root.Space == cardDAVNS && request.Root.Local == "addressbook-multiget":
+		byHref := make(map[string]dblayer.DBEntityInterface)
+		for _, contact := range contacts {
+			byHref[hrefs[contact.GetValue("id").(string)]] = contact
+		}
+		resources := []davResource{}
+		missing := []string{}
+		for _, href := range request.Hrefs {
+			name := davResourceName(href)
+			contact, found := byHref[name]
+			if !found {
+				missing = append(missing, davStatusResponse(href, http.StatusNotFound))
+				continue
+			}
+			resources = append(resources, cardDAVContactResource(user, folderID, name, contact, request))
+		}
+		writeMultistatus(w, resources, request, missing...)
+	default:
+		RespondSimpleError(w, ErrInvalidRequest, "Report not supported", http.StatusNotImplemented)
+	}
+}
+
+func cardDAVContact(w http.ResponseWriter, r *http.Request, user *davUser, folderID string, name string) {
+	folder, ok := cardDAVFolder(w, user, folderID)
+	if !ok {
+		return
+	}
+	contact := user.Repo.FindContactByHref(folderID, name)
+	if contact != nil && !user.Repo.CheckReadPermission(contact) {
+		davRefuseUnreadable(w, r, "Contact not found")
+		return
+	}
+	etag := ""
+	if contact != nil {
+		etag = davObjectETag(contact)
+	}
+
+	switch r.Method {
+	case "PROPFIND":
+		if contact == nil {
+			RespondSimpleError(w, ErrObjectNotFound, "Contact not found", http.StatusNotFound)
+			return
+		}
+		request, err := parseDAVRequest(r.Body)
+		if err != nil {
+			RespondSimpleError(w, ErrInvalidRequest, "Invalid XML body", http.StatusBadRequest)
+			return
+		}
+		request.Props = dropProp(request.Props, propAddressData)
+		writeMultistatus(w, []davResource{cardDAVContactResource(user, folderID, name, contact, request)}, request)
+
+	case http.MethodGet, http.MethodHead:
+		if contact == nil {
+			RespondSimpleError(w, ErrObjectNotFound, "Contact not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "text/vcard; charset=utf-8")
+		w.Header().Set("ETag", etag)
+		w.WriteHeader(http.StatusOK)
+		if r.Method == http.MethodGet {
+			io.WriteString(w, user.Repo.ContactVCard(contact, dblayer.VCardVersion3))
+		}
+
+	case http.MethodPut:
+		if !davETagMatches(r, etag) {
+			RespondSimpleError(w, ErrInvalidRequest, "Precondition failed", http.StatusPreconditionFailed)
+			return
+		}
+		if contact == nil && !user.Repo.CheckWritePermission(folder) {
+			RespondSimpleError(w, ErrForbidden, "Permission denied", http.StatusForbidden)
+			return
+		}
+		if contact != nil && !user.Repo.CheckWritePermission(contact) {
+			RespondSimpleError(w, ErrForbidden, "Permission denied", http.StatusForbidden)
+			return
+		}
+		data, err := io.ReadAll(io.LimitReader(r.Body, maxDAVBodySize))
+		if err != nil {
+			RespondSimpleError(w, ErrInvalidRequest, "Failed to read the body", http.StatusBadRequest)
+			return
+		}
+		saved, err := user.Repo.SaveCardDAVContact(folderID, name, string(data), contact)
+		if errors.Is(err, dblayer.ErrVCardUIDConflict) {
+			RespondSimpleError(w, ErrInvalidRequest, "The UID belongs to another contact", http.StatusConflict)
+			return
+		}
+		if err != nil {
+			RespondSimpleError(w, ErrInvalidRequest, "Invalid vCard data: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if reloaded := user.Repo.GetEntityByID(saved.GetTableName(), saved.GetValue("id").(string)); reloaded != nil {
+			saved = reloaded
+		}
+		w.Header().Set("ETag", davObjectETag(saved))
+		if contact == nil {
+			w.WriteHeader(http.StatusCreated)
+		} else {
+			w.WriteHeader(http.StatusNoContent)
+		}
+
+	case http.MethodDelete:
+		if contact == nil {
+			RespondSimpleError(w, ErrObjectNotFound, "Contact not found", http.StatusNotFound)
+			return
+		}
+		if !davETagMatches(r, etag) {
+			RespondSimpleError(w, ErrInvalidRequest, "Precondition failed", http.StatusPreconditionFailed)
+			return
+		}
+		if !user.Repo.CheckWritePermission(contact) {
+			RespondSimpleError(w, ErrForbidden, "Permission denied", http.StatusForbidden)
+			return
+		}
+		if _, err := user.Repo.Delete(contact); err != nil {
+			log.Printf("cardDAVContact: %v", err)
+			RespondSimpleError(w, ErrInternalServer, "Failed to delete the contact", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		w.Header().Set("Allow", "OPTIONS, PROPFIND, GET, HEAD, PUT, DELETE")
+		RespondSimpleError(w, ErrInvalidRequest, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,235 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"rprj/be/dblayer"
+
+	"github.com/gorilla/mux"
+)
+
+// DuplicatesResponse godoc
+// @Description Response structure of the duplicate people
+type DuplicatesResponse struct {
+	Success    bool                         `json:"success"`
+	Duplicates []dblayer.DuplicateCandidate `json:"duplicates"`
+}
+
+// ContactMergeRequest godoc
+// @Description Request structure of a merge of two people
+type ContactMergeRequest struct {
+	SurvivorID string `json:"survivor_id"`
+	MergedID   string `json:"merged_id"`
+	// Winner of a field: "survivor" or "merged"; by default the survivor wins unless its value is empty
+	Fields map[string]string `json:"fields"`
+}
+
+// ContactMergeResponse godoc
+// @Description Response structure of a merge of two people
+type ContactMergeResponse struct {
+	Success bool           `json:"success"`
+	Person  map[string]any `json:"person"`
+	Merge   map[string]any `json:"merge"`
+}
+
+// ContactMergesResponse godoc
+// @Description Response structure of the merge history of a person
+type ContactMergesResponse struct {
+	Success bool             `json:"success"`
+	Merges  []map[string]any `json:"merges"`
+}
+
+// CodiceFiscaleResponse godoc
+// @Description Response structure of a decoded codice fiscale
+type CodiceFiscaleResponse struct {
+	Success bool                       `json:"success"`
+	Data    *dblayer.CodiceFiscaleInfo `json:"data"`
+}
+
+// GetDuplicatesHandler godoc
+// @Summary Find duplicate people
+// @Description Returns the pairs of readable people that may be the same person, best first.
+// @Description Pairs are scored from 0 to 100 by codice fiscale, email, phone numbers and name similarity.
+// @Tags contacts
+// @Produce json
+// @Param min_score query int false "Minimum score (default 50)"
+// @Success 200 {object} DuplicatesResponse "Duplicate candidates"
+// @Failure 400 {object} ErrorResponse "Invalid min_score"
+// @Security BearerAuth
+// @Router /contacts/duplicates [get]
+func GetDuplicatesHandler(w http.ResponseWriter, r *http.Request) {
+	repo, ok := authenticatedRepository(w, r)
+	if !ok {
+		return
+	}
+	minScore := dblayer.DefaultDuplicateMinScore
+	if value := r.URL.Query().Get("min_score"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > 100 {
+			RespondError(w, ErrInvalidRequest, "Invalid min_score", map[string]string{"field": "min_score"}, http.StatusBadRequest)
+			return
+		}
+		minScore = parsed
+	}
+	duplicates, err := repo.FindDuplicatePeople(minScore)
+	if err != nil {
+		log.Printf("GetDuplicatesHandler: %v", err)
+		RespondSimpleError(w, ErrInternalServer, "Failed to read people", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(DuplicatesResponse{
+		Success:    true,
+		Duplicates: duplicates,
+	})
+}
+
+// MergeContactsHandler godoc
+// @Summary Merge two people
+// @Description Merges a duplicate person into the survivor: the survivor takes the chosen fields, the references
+// @Description to the merged person (companies of users, events, folders, links, notes, projects, tags...) move to
+// @Description the survivor and the merged person is soft deleted. The merge is kept in the history of both.
+// @Tags contacts
+// @Accept json
+// @Produce json
+// @Param request body ContactMergeRequest true "People to merge and field winners"
+// @Success 200 {object} ContactMergeResponse "The survivor and the merge record"
+// @Failure 400 {object} ErrorResponse "Invalid request"
+// @Failure 403 {object} ErrorResponse "Permission denied"
+// @Failure 404 {object} ErrorResponse "Person not found"
+// @Security BearerAuth
+// @Router /contacts/merge [post]
+func MergeContactsHandler(w http.ResponseWriter, r *http.Request) {
+	repo, ok := authenticatedRepository(w, r)
+	if !ok {
+		return
+	}
+	var request ContactMergeRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		RespondSimpleError(w, ErrInvalidRequest, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	request.SurvivorID = normalizeObjectID(request.SurvivorID)
+	request.MergedID = normalizeObjectID(request.MergedID)
+	if request.SurvivorID == "" {
+		RespondError(w, ErrMissingField, "Field is required", map[string]string{"field": "survivor_id"}, http.StatusBadRequest)
+		return
+	}
+	if request.MergedID == "" {
+		RespondError(w, ErrMissingField, "Field is required", map[string]string{"field": "merged_id"}, http.StatusBadRequest)
+		return
+	}
+	for column, winner := range request.Fields {
+		if winner != dblayer.MergeKeepSurvivor && winner != dblayer.MergeTakeMerged {
+			RespondError(w, ErrInvalidRequest, "Invalid field winner", map[string]string{"field": "fields." + column}, http.StatusBadRequest)
+			return
+		}
+	}
+	for _, id := range []string{request.SurvivorID, request.MergedID} {
+		person := repo.GetEntityByID("people", id)
+		if person == nil || person.(dblayer.DBObjectInterface).HasDeletedDate() || !repo.CheckReadPermission(person) {
+			RespondError(w, ErrObjectNotFound, "Person not found", map[string]string{"id": id}, http.StatusNotFound)
+			return
+		}
+		if !repo.CheckWritePermission(person) {
+			RespondSimpleError(w, ErrForbidden, "Permission denied", http.StatusForbidden)
+			return
+		}
+	}
+
+	survivor, merge, err := repo.MergePeople(request.SurvivorID, request.MergedID, request.Fields)
+	if errors.Is(err, dblayer.ErrMergeNotAllowed) {
+		RespondSimpleError(w, ErrInvalidRequest, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if respondValidationError(w, err) {
+		return
+	}
+	if err != nil {
+		log.Printf("MergeContactsHandler: %v", err)
+		RespondSimpleError(w, ErrInternalServer, "Failed to merge the people", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(ContactMergeResponse{
+		Success: true,
+		Person:  survivor.GetAllValues(),
+		Merge:   merge.GetAllValues(),
+	})
+}
+
+// GetContactMergesHandler godoc
+// @Summary Merge history of a person
+// @Description Returns the merges of a person, as survivor or as merged person, newest first.
+// @Description merged_data, changes and moved_references are JSON documents.
+// @Tags contacts
+// @Produce json
+// @Param id path string true "Person ID"
+// @Success 200 {object} ContactMergesResponse "Merges"
+// @Failure 404 {object} ErrorResponse "Person not found"
+// @Security BearerAuth
+// @Router /contacts/{id}/merges [get]
+func GetContactMergesHandler(w http.ResponseWriter, r *http.Request) {
+	repo, ok := authenticatedRepository(w, r)
+	if !ok {
+		return
+	}
+	personID := normalizeObjectID(mux.Vars(r)["id"])
+	person := repo.GetEntityByID("people", personID)
+	if person == nil || !repo.CheckReadPermission(person) {
+		RespondSimpleError(w, ErrObjectNotFound, "Person not found", http.StatusNotFound)
+		return
+	}
+	merges, err := repo.GetContactMerges(personID)
+	if err != nil {
+		log.Printf("GetContactMergesHandler: %v", err)
+		RespondSimpleError(w, ErrInternalServer, "Failed to read the merges", http.StatusInternalServerError)
+		return
+	}
+	result := make([]map[string]any, 0, len(merges))
+	for _, merge := range merges {
+		result = append(result, merge.GetAllValues())
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(ContactMergesResponse{
+		Success: true,
+		Merges:  result,
+	})
+}
+
+// DecodeCodiceFiscaleHandler godoc
+// @Summary Decode a codice fiscale
+// @Description Validates a codice fiscale and returns its birth date, sex and birthplace code (cadastral code of
+// @Description the municipality, or Z code of the country when born abroad). When birth_date or sex are given,
+// @Description their consistency with the codice fiscale is checked.
+// @Tags contacts
+// @Produce json
+// @Param cf path string true "Codice fiscale"
+// @Param birth_date query string false "Expected birth date (YYYY-MM-DD)"
+// @Param sex query string false "Expected sex (M or F)"
+// @Success 200 {object} CodiceFiscaleResponse "Decoded codice fiscale"
+// @Failure 400 {object} ErrorResponse "Invalid or inconsistent codice fiscale"
+// @Security BearerAuth
+// @Router /contacts/codice-fiscale/{cf} [get]
+func DecodeCodiceFiscaleHandler(w http.ResponseWriter, r *http.Request) {
+	cf := mux.Vars(r)["cf"]
+	query := r.URL.Query()
+	if err := dblayer.CheckCodiceFiscale(cf, query.Get("birth_date"), query.Get("sex")); err != nil {
+		respondValidationError(w, err)
+		return
+	}
+	info, _ := dblayer.DecodeCodiceFiscale(cf)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(CodiceFiscaleResponse{
+		Success: true,
+		Data:    info,
+	})
+}
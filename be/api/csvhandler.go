@@ -0,0 +1,148 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"rprj/be/dblayer"
+)
+
+// maxCSVSize is the largest CSV file accepted by the import
+const maxCSVSize = 20 << 20
+
+// CSVImportResponse godoc
+// @Description Response structure of a CSV import
+type CSVImportResponse struct {
+	Success bool                     `json:"success"`
+	Result  *dblayer.CSVImportReport `json:"result"`
+}
+
+// isAdminRepository tells if the user of the repository is an administrator
+func isAdminRepository(repo *dblayer.DBRepository) bool {
+	return repo.DbContext.IsInGroup("-2")
+}
+
+// ExportObjectsCSVHandler godoc
+// @Summary Export objects as CSV
+// @Description Runs the query of /objects/search and returns the matching objects as CSV. The columns are the
+// @Description columns of the class in their declaration order, a NULL is an empty cell. Without limit every
+// @Description result is exported. Classes that are not DBObjects (users, groups...) are exported by administrators only.
+// @Tags objects
+// @Produce text/csv
+// @Param classname query string true "Class name (e.g., DBCompany, DBNote)"
+// @Param name query string false "Name pattern for search"
+// @Param searchJson query string false "JSON object with additional search parameters"
+// @Param orderBy query string false "Field to order by (e.g., name, creation_date)"
+// @Param limit query int false "Maximum number of results"
+// @Param offset query int false "Offset for pagination"
+// @Param includeDeleted query string false "Include deleted objects"
+// @Param tags query string false "Comma separated list of tags, objects must have all of them"
+// @Success 200 {string} string "CSV file"
+// @Failure 400 {object} ErrorResponse "Invalid request"
+// @Failure 403 {object} ErrorResponse "Permission denied"
+// @Security BearerAuth
+// @Router /objects/export [get]
+func ExportObjectsCSVHandler(w http.ResponseWriter, r *http.Request) {
+	repo, ok := authenticatedRepository(w, r)
+	if !ok {
+		return
+	}
+	instance := repo.GetInstanceByClassName(r.URL.Query().Get("classname"))
+	if instance != nil && !instance.IsDBObject() && !isAdminRepository(repo) {
+		RespondSimpleError(w, ErrForbidden, "Permission denied", http.StatusForbidden)
+		return
+	}
+	search, ok := searchObjects(w, r)
+	if !ok {
+		return
+	}
+	var buffer bytes.Buffer
+	if err := dblayer.WriteCSV(&buffer, instance, search.page()); err != nil {
+		log.Printf("ExportObjectsCSVHandler: %v", err)
+		RespondSimpleError(w, ErrInternalServer, "Failed to write the CSV file", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", "attachment; filename=\""+strings.TrimPrefix(search.classname, "DB")+".csv\"")
+	w.WriteHeader(http.StatusOK)
+	w.Write(buffer.Bytes())
+}
+
+// ImportObjectsCSVHandler godoc
+// @Summary Import objects from CSV
+// @Description Creates or updates objects of any class from a CSV file with a header, comma or semicolon separated.
+// @Description mapping is a JSON object from the CSV fields to the columns of the class; without it the fields named
+// @Description like a column are imported. The rows whose key column matches one existing object (in the folder, when
+// @Description given) update it, the others create new objects in the folder. Empty cells leave the column unchanged.
+// @Description The owner and group_id columns are not imported: new objects belong to the user and its group.
+// @Description With dry_run the rows are validated, including the checks of the class, and nothing is written.
+// @Description The file is the request body (text/csv) or the "file" field of a multipart form.
+// @Tags objects
+// @Accept text/csv
+// @Accept multipart/form-data
+// @Produce json
+// @Param classname query string true "Class name (e.g., DBCompany, DBPerson)"
+// @Param father_id query string false "Folder of the new objects"
+// @Param key query string false "Column matching the existing objects to update"
+// @Param mapping query string false "JSON object: CSV field -> column"
+// @Param dry_run query string false "Validate only: yes or no (default)"
+// @Param file formData file false "CSV file"
+// @Success 200 {object} CSVImportResponse "Import report"
+// @Failure 400 {object} ErrorResponse "Invalid request or CSV file"
+// @Failure 403 {object} ErrorResponse "Permission denied"
+// @Security BearerAuth
+// @Router /objects/import [post]
+func ImportObjectsCSVHandler(w http.ResponseWriter, r *http.Request) {
+	repo, ok := authenticatedRepository(w, r)
+	if !ok {
+		return
+	}
+	classname := r.URL.Query().Get("classname")
+	instance := repo.GetInstanceByClassName(classname)
+	if instance == nil {
+		RespondSimpleError(w, ErrInvalidRequest, "Unknown classname: "+classname, http.StatusBadRequest)
+		return
+	}
+	if instance.GetTypeName() == "DBFile" {
+		RespondSimpleError(w, ErrInvalidRequest, "Files cannot be imported from CSV", http.StatusBadRequest)
+		return
+	}
+	if !instance.IsDBObject() && !isAdminRepository(repo) {
+		RespondSimpleError(w, ErrForbidden, "Permission denied", http.StatusForbidden)
+		return
+	}
+	fatherID, ok := writableFolder(w, repo, normalizeObjectID(r.URL.Query().Get("father_id")))
+	if !ok {
+		return
+	}
+	data, ok := readImportFile(w, r, maxCSVSize)
+	if !ok {
+		return
+	}
+	options := dblayer.CSVImportOptions{
+		ClassName: classname,
+		Key:       r.FormValue("key"),
+		FatherID:  fatherID,
+		DryRun:    r.FormValue("dry_run") == "yes" || r.FormValue("dry_run") == "true" || r.FormValue("dry_run") == "1",
+	}
+	if mapping := r.FormValue("mapping"); mapping != "" {
+		if err := json.Unmarshal([]byte(mapping), &options.Mapping); err != nil {
+			RespondError(w, ErrInvalidRequest, "Invalid mapping", map[string]string{"field": "mapping"}, http.StatusBadRequest)
+			return
+		}
+	}
+	result, err := repo.ImportCSV(data, options)
+	if err != nil {
+		RespondSimpleError(w, ErrInvalidRequest, "Invalid CSV import: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(CSVImportResponse{
+		Success: true,
+		Result:  result,
+	})
+}
@@ -0,0 +1,384 @@
+package api
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"rprj/be/dblayer"
+)
+
+// WebDAV namespaces used by CalDAV and CardDAV
+const (
+	davNS         = "DAV:"
+	calDAVNS      = "urn:ietf:params:xml:ns:caldav"
+	cardDAVNS     = "urn:ietf:params:xml:ns:carddav"
+	calendarSrvNS = "http://calendarserver.org/ns/"
+)
+
+// davPrefixes are the prefixes declared on the multistatus element
+var davPrefixes = map[string]string{
+	davNS:         "D",
+	calDAVNS:      "C",
+	cardDAVNS:     "CR",
+	calendarSrvNS: "CS",
+}
+
+// maxDAVBodySize limits the XML requests and the uploaded resources
+const maxDAVBodySize = 10 << 20
+
+// davUser is the user authenticated on a DAV request
+type davUser struct {
+	Login string
+	Repo  *dblayer.DBRepository
+}
+
+// davAuthenticate checks HTTP Basic credentials against the rhobee users,
+// or a Bearer token as the rest of the API. Responds 401 with a Basic
+// challenge, so that calendar and address book clients ask for a password.
+func davAuthenticate(w http.ResponseWriter, r *http.Request) (*davUser, bool) {
+	adminContext := &dblayer.DBContext{
+		UserID:   "-1",
+		GroupIDs: []string{"-2"},
+		Schema:   dblayer.DbSchema,
+	}
+	lookup := dblayer.NewDBRepository(adminContext, dblayer.Factory, dblayer.DbConnection)
+	lookup.Verbose = false
+
+	var userID, login string
+	if claims, err := GetClaimsFromRequest(r); err == nil {
+		userID, login = claims["user_id"], claims["login"]
+	} else if username, password, ok := r.BasicAuth(); ok {
+		search := lookup.GetInstanceByTableName("users")
+		search.SetValue("login", username)
+		users, err := lookup.Search(search, false, true, "")
+		if err == nil && len(users) == 1 {
+			if user, ok := users[0].(*dblayer.DBUser); ok && user.VerifyPassword(password) {
+				userID, _ = user.GetValue("id").(string)
+				login = username
+			}
+		}
+	}
+	if userID == "" {
+		w.Header().Set("WWW-Authenticate", `Basic realm="rhobee", charset="UTF-8"`)
+		RespondSimpleError(w, ErrUnauthorized, "Unauthorized", http.StatusUnauthorized)
+		return nil, false
+	}
+
+	groupIDs, err := lookup.GetUserGroupIDs(userID)
+	if err != nil {
+		RespondSimpleError(w, ErrInternalServer, "Failed to read user groups", http.StatusInternalServerError)
+		return nil, false
+	}
+	repo := dblayer.NewDBRepository(&dblayer.DBContext{
+		UserID:   userID,
+		GroupIDs: groupIDs,
+		Schema:   dblayer.DbSchema,
+	}, dblayer.Factory, dblayer.DbConnection)
+	repo.Verbose = false
+	return &davUser{Login: login, Repo: repo}, true
+}
+
+// davResource is a resource of a multistatus response: its properties are
+// inner XML fragments using the prefixes of davPrefixes
+type davResource struct {
+	Href  string
+	Props map[xml.Name]string
+}
+
+// davRequest is a parsed PROPFIND or REPORT body
+type davRequest struct {
+	Root    xml.Name
+	AllProp bool
+	Props   []xml.Name
+	Hrefs   []string
+	// calendar-query time-range, iCalendar UTC datetimes
+	TimeRangeStart string
+	TimeRangeEnd   string
+}
+
+// parseDAVRequest reads the requested properties, the hrefs and the time range
+// of a PROPFIND or REPORT body. An empty body is an allprop PROPFIND.
+func parseDAVRequest(body io.Reader) (*davRequest, error) {
+	data, err := io.ReadAll(io.LimitReader(body, maxDAVBodySize))
+	if err != nil {
+		return nil, err
+	}
+	request := &davRequest{}
+	if len(bytes.TrimSpace(data)) == 0 {
+		request.AllProp = true
+		return request, nil
+	}
+
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	path := []xml.Name{}
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			if len(path) == 0 {
+				request.Root = t.Name
+			}
+			parent := xml.Name{}
+			if len(path) > 0 {
+				parent = path[len(path)-1]
+			}
+			switch {
+			case parent.Space == davNS && parent.Local == "prop" && len(path) == 2:
+				request.Props = append(request.Props, t.Name)
+			case t.Name.Space == davNS && t.Name.Local == "allprop":
+				request.AllProp = true
+			case t.Name.Space == calDAVNS && t.Name.Local == "time-range":
+				for _, attr := range t.Attr {
+					switch attr.Name.Local {
+					case "start":
+						request.TimeRangeStart = attr.Value
+					case "end":
+						request.TimeRangeEnd = attr.Value
+					}
+				}
+			}
+			path = append(path, t.Name)
+		case xml.EndElement:
+			path = path[:len(path)-1]
+		case xml.CharData:
+			if len(path) == 2 && path[1].Space == davNS && path[1].Local == "href" {
+				if href := strings.TrimSpace(string(t)); href != "" {
+					request.Hrefs = append(request.Hrefs, href)
+				}
+			}
+		}
+	}
+	if len(request.Props) == 0 {
+		request.AllProp = true
+	}
+	return request, nil
+}
+
+// davPropfind parses a PROPFIND body, responding 400 when invalid
+func davPropfind(w http.ResponseWriter, r *http.Request) (*davRequest, bool) {
+	if r.Method != "PROPFIND" {
+		w.Header().Set("Allow", "OPTIONS, PROPFIND")
+		RespondSimpleError(w, ErrInvalidRequest, "Method not allowed", http.StatusMethodNotAllowed)
+		return nil, false
+	}
+	request, err := parseDAVRequest(r.Body)
+	if err != nil {
+		RespondSimpleError(w, ErrInvalidRequest, "Invalid XML body", http.StatusBadRequest)
+		return nil, false
+	}
+	return request, true
+}
+
+// dropProp removes a property from the requested ones
+func dropProp(props []xml.Name, name xml.Name) []xml.Name {
+	result := []xml.Name{}
+	for _, prop := range props {
+		if prop != name {
+			result = append(result, prop)
+		}
+	}
+	return result
+}
+
+// davResourceName returns the last segment of the href of a multiget
+func davResourceName(href string) string {
+	name := href
+	if u, err := url.Parse(href); err == nil {
+		name = u.Path
+	}
+	return name[strings.LastIndex(name, "/")+1:]
+}
+
+// davEscape escapes a text for an XML fragment
+func davEscape(text string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(text))
+	return buf.String()
+}
+
+// davHref is a DAV:href fragment
+func davHref(href string) string {
+	return "<D:href>" + davEscape(href) + "</D:href>"
+}
+
+// davElement returns an element with its content, declaring its namespace when unknown
+func davElement(name xml.Name, content string) string {
+	prefix, ok := davPrefixes[name.Space]
+	if !ok {
+		attr := ""
+		if name.Space != "" {
+			attr = ` xmlns:X="` + davEscape(name.Space) + `"`
+		}
+		if content == "" {
+			return "<X:" + name.Local + attr + "/>"
+		}
+		return "<X:" + name.Local + attr + ">" + content + "</X:" + name.Local + ">"
+	}
+	if content == "" {
+		return "<" + prefix + ":" + name.Local + "/>"
+	}
+	return "<" + prefix + ":" + name.Local + ">" + content + "</" + prefix + ":" + name.Local + ">"
+}
+
+// davNamespaces declares the namespaces of davPrefixes
+func davNamespaces() string {
+	namespaces := make([]string, 0, len(davPrefixes))
+	for ns := range davPrefixes {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Strings(namespaces)
+	declarations := ""
+	for _, ns := range namespaces {
+		declarations += " xmlns:" + davPrefixes[ns] + `="` + ns + `"`
+	}
+	return declarations
+}
+
+// writeMultistatus sends a 207 response with the requested properties of the
+// resources: found ones with 200, the others with 404. extra are responses
+// built with davStatusResponse.
+func writeMultistatus(w http.ResponseWriter, resources []davResource, request *davRequest, extra ...string) {
+	var sb strings.Builder
+	sb.WriteString(`<?xml version="1.0" encoding="utf-8"?>` + "\n")
+	sb.WriteString("<D:multistatus" + davNamespaces() + ">\n")
+
+	for _, resource := range resources {
+		sb.WriteString("<D:response>" + davHref(resource.Href))
+		found := []string{}
+		missing := []string{}
+		if request.AllProp {
+			names := make([]xml.Name, 0, len(resource.Props))
+			for name := range resource.Props {
+				names = append(names, name)
+			}
+			sort.Slice(names, func(i, j int) bool { return names[i].Space+names[i].Local < names[j].Space+names[j].Local })
+			for _, name := range names {
+				found = append(found, davElement(name, resource.Props[name]))
+			}
+		} else {
+			for _, name := range request.Props {
+				if value, ok := resource.Props[name]; ok {
+					found = append(found, davElement(name, value))
+				} else {
+					missing = append(missing, davElement(name, ""))
+				}
+			}
+		}
+		if len(found) > 0 {
+			sb.WriteString("<D:propstat><D:prop>" + strings.Join(found, "") + "</D:prop><D:status>HTTP/1.1 200 OK</D:status></D:propstat>")
+		}
+		if len(missing) > 0 {
+			sb.WriteString("<D:propstat><D:prop>" + strings.Join(missing, "") + "</D:prop><D:status>HTTP/1.1 404 Not Found</D:status></D:propstat>")
+		}
+		sb.WriteString("</D:response>\n")
+	}
+	for _, response := range extra {
+		sb.WriteString(response)
+	}
+	sb.WriteString("</D:multistatus>\n")
+
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(http.StatusMultiStatus)
+	io.WriteString(w, sb.String())
+}
+
+// writeDAVError sends a DAV:error with the precondition that failed (RFC 4918, 16)
+func writeDAVError(w http.ResponseWriter, status int, condition xml.Name, content string) {
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(status)
+	io.WriteString(w, `<?xml version="1.0" encoding="utf-8"?>`+"\n"+
+		`<D:error`+davNamespaces()+`>`+davElement(condition, content)+"</D:error>\n")
+}
+
+// davRefuseUnreadable answers for a resource that exists but that the user
+// cannot read: 404 to the reads, 403 to the writes, so that its name is not
+// taken for a free one
+func davRefuseUnreadable(w http.ResponseWriter, r *http.Request, notFound string) {
+	switch r.Method {
+	case "PROPFIND", http.MethodGet, http.MethodHead:
+		RespondSimpleError(w, ErrObjectNotFound, notFound, http.StatusNotFound)
+	default:
+		RespondSimpleError(w, ErrForbidden, "Permission denied", http.StatusForbidden)
+	}
+}
+
+// davStatusResponse is a response with a status only, e.g. a missing resource of a multiget
+func davStatusResponse(href string, status int) string {
+	return "<D:response>" + davHref(href) + "<D:status>HTTP/1.1 " +
+		strconv.Itoa(status) + " " + http.StatusText(status) + "</D:status></D:response>\n"
+}
+
+// davObjectETag derives the ETag of a DBObject from its id and last_modify_date
+func davObjectETag(dbe dblayer.DBEntityInterface) string {
+	id, _ := dbe.GetValue("id").(string)
+	modified, _ := dbe.GetValue("last_modify_date").(string)
+	if t, err := dblayer.ParseDateTime(modified); err == nil {
+		return fmt.Sprintf(`"%s-%d"`, id, t.Unix())
+	}
+	return `"` + id + `"`
+}
+
+// davDepth reads the Depth header: 0 or 1 ("infinity" is served as 1)
+func davDepth(r *http.Request, defaultDepth int) int {
+	switch r.Header.Get("Depth") {
+	case "0":
+		return 0
+	case "1", "infinity":
+		return 1
+	}
+	return defaultDepth
+}
+
+// davPrivileges returns the current-user-privilege-set of a resource
+func davPrivileges(canRead bool, canWrite bool) string {
+	privileges := ""
+	if canRead {
+		privileges += "<D:privilege><D:read/></D:privilege><D:privilege><D:read-current-user-privilege-set/></D:privilege>"
+	}
+	if canWrite {
+		privileges += "<D:privilege><D:write/></D:privilege><D:privilege><D:write-content/></D:privilege>" +
+			"<D:privilege><D:bind/></D:privilege><D:privilege><D:unbind/></D:privilege>"
+	}
+	return privileges
+}
+
+// davETagMatches evaluates If-Match and If-None-Match against the ETag of a
+// resource ("" when it does not exist)
+func davETagMatches(r *http.Request, etag string) bool {
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" {
+		if etag == "" {
+			return false
+		}
+		if ifMatch != "*" && !davETagListContains(ifMatch, etag) {
+			return false
+		}
+	}
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" && etag != "" {
+		if ifNoneMatch == "*" || davETagListContains(ifNoneMatch, etag) {
+			return false
+		}
+	}
+	return true
+}
+
+func davETagListContains(list string, etag string) bool {
+	for _, candidate := range strings.Split(list, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(candidate), "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
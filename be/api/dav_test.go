@@ -0,0 +1,173 @@
+package api
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseDAVRequest(t *testing.T) {
+	body := `<?xml version="1.0"?>
+<C:calendar-query xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav">
+  <D:prop><D:getetag/><C:calendar-data/></D:prop>
+  <C:filter><C:comp-filter name="VCALENDAR"><C:comp-filter name="VEVENT">
+    <C:time-range start="20240101T000000Z" end="20240201T000000Z"/>
+  </C:comp-filter></C:comp-filter></C:filter>
+</C:calendar-query>`
+	request, err := parseDAVRequest(strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if request.Root != (xml.Name{Space: calDAVNS, Local: "calendar-query"}) {
+		t.Errorf("root: %v", request.Root)
+	}
+	if request.AllProp || len(request.Props) != 2 || request.Props[1] != propCalendarData {
+		t.Errorf("props: %v allprop=%v", request.Props, request.AllProp)
+	}
+	if request.TimeRangeStart != "20240101T000000Z" || request.TimeRangeEnd != "20240201T000000Z" {
+		t.Errorf("time-range: %s %s", request.TimeRangeStart, request.TimeRangeEnd)
+	}
+
+	multiget := `<C:calendar-multiget xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav">
+  <D:prop><D:getetag/></D:prop>
+  <D:href>/caldav/calendars/1/a.ics</D:href>
+  <D:href> /caldav/calendars/1/b.ics </D:href>
+</C:calendar-multiget>`
+	request, err = parseDAVRequest(strings.NewReader(multiget))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(request.Hrefs) != 2 || request.Hrefs[1] != "/caldav/calendars/1/b.ics" {
+		t.Errorf("hrefs: %v", request.Hrefs)
+	}
+
+	request, err = parseDAVRequest(strings.NewReader(""))
+	if err != nil || !request.AllProp {
+		t.Errorf("empty body: %v %v", request, err)
+	}
+	if _, err := parseDAVRequest(strings.NewReader("<D:propfind xmlns:D=\"DAV:\">")); err == nil {
+		t.Error("truncated body: expected an error")
+	}
+}
+
+func TestWriteMultistatus(t *testing.T) {
+	request := &davRequest{Props: []xml.Name{propGetETag, {Space: "urn:x", Local: "color"}}}
+	resources := []davResource{{
+		Href:  "/caldav/calendars/1/a b.ics",
+		Props: map[xml.Name]string{propGetETag: davEscape(`"1-2"`), propDisplayName: "A"},
+	}}
+	w := httptest.NewRecorder()
+	writeMultistatus(w, resources, request, davStatusResponse("/caldav/calendars/1/x.ics", http.StatusNotFound))
+
+	if w.Code != http.StatusMultiStatus {
+		t.Errorf("status: %d", w.Code)
+	}
+	body := w.Body.String()
+	for _, expected := range []string{
+		"<D:href>/caldav/calendars/1/a b.ics</D:href>",
+		"<D:getetag>&#34;1-2&#34;</D:getetag>",
+		`<X:color xmlns:X="urn:x"/>`,
+		"HTTP/1.1 404 Not Found",
+		"<D:href>/caldav/calendars/1/x.ics</D:href><D:status>HTTP/1.1 404 Not Found</D:status>",
+	} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("missing %q in\n%s", expected, body)
+		}
+	}
+	if strings.Contains(body, "displayname") {
+		t.Errorf("unrequested property in\n%s", body)
+	}
+	// The response must be well formed
+	decoder := xml.NewDecoder(strings.NewReader(body))
+	for {
+		if _, err := decoder.Token(); err != nil {
+			if err.Error() != "EOF" {
+				t.Errorf("invalid XML: %v", err)
+			}
+			break
+		}
+	}
+}
+
+func TestWriteDAVError(t *testing.T) {
+	w := httptest.NewRecorder()
+	writeDAVError(w, http.StatusConflict, calDAVNoUIDConflict, davHref("/caldav/calendars/1/a.ics"))
+	if w.Code != http.StatusConflict {
+		t.Errorf("status: %d", w.Code)
+	}
+	body := w.Body.String()
+	if !strings.Contains(body, "<C:no-uid-conflict><D:href>/caldav/calendars/1/a.ics</D:href></C:no-uid-conflict></D:error>") {
+		t.Errorf("missing the precondition in\n%s", body)
+	}
+	var davError struct {
+		XMLName   xml.Name
+		Condition struct {
+			XMLName xml.Name
+			Href    string `xml:"DAV: href"`
+		} `xml:",any"`
+	}
+	if err := xml.Unmarshal(w.Body.Bytes(), &davError); err != nil {
+		t.Fatalf("invalid XML: %v", err)
+	}
+	if davError.XMLName != (xml.Name{Space: davNS, Local: "error"}) || davError.Condition.XMLName != calDAVNoUIDConflict ||
+		davError.Condition.Href != "/caldav/calendars/1/a.ics" {
+		t.Errorf("got %+v", davError)
+	}
+}
+
+func TestDAVRefuseUnreadable(t *testing.T) {
+	for method, status := range map[string]int{
+		"PROPFIND":        http.StatusNotFound,
+		http.MethodGet:    http.StatusNotFound,
+		http.MethodHead:   http.StatusNotFound,
+		http.MethodPut:    http.StatusForbidden,
+		http.MethodDelete: http.StatusForbidden,
+	} {
+		w := httptest.NewRecorder()
+		davRefuseUnreadable(w, httptest.NewRequest(method, "/caldav/calendars/1/a.ics", nil), "Event not found")
+		if w.Code != status {
+			t.Errorf("%s: expected %d, got %d", method, status, w.Code)
+		}
+	}
+}
+
+func TestDAVETagMatches(t *testing.T) {
+	cases := []struct {
+		header string
+		value  string
+		etag   string
+		match  bool
+	}{
+		{"", "", `"1-2"`, true},
+		{"If-Match", `"1-2"`, `"1-2"`, true},
+		{"If-Match", `"1-3", W/"1-2"`, `"1-2"`, true},
+		{"If-Match", `"1-3"`, `"1-2"`, false},
+		{"If-Match", "*", "", false},
+		{"If-None-Match", "*", "", true},
+		{"If-None-Match", "*", `"1-2"`, false},
+		{"If-None-Match", `"1-3"`, `"1-2"`, true},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("PUT", "/caldav/calendars/1/a.ics", nil)
+		if c.header != "" {
+			r.Header.Set(c.header, c.value)
+		}
+		if got := davETagMatches(r, c.etag); got != c.match {
+			t.Errorf("%s: %s with etag %s: got %v", c.header, c.value, c.etag, got)
+		}
+	}
+}
+
+func TestDAVResourceName(t *testing.T) {
+	for href, want := range map[string]string{
+		"/carddav/addressbooks/f1/abc.vcf":               "abc.vcf",
+		"https://host/carddav/addressbooks/f1/a%20b.vcf": "a b.vcf",
+		"c1.vcf": "c1.vcf",
+	} {
+		if got := davResourceName(href); got != want {
+			t.Errorf("%s: got %q, want %q", href, got, want)
+		}
+	}
+}
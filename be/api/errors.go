@@ -2,7 +2,10 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
+
+	"rprj/be/dblayer"
 )
 
 // APIError represents a structured error response with i18n support
@@ -30,6 +33,21 @@ const (
 	ErrMissingAuthorization = "MISSING_AUTHORIZATION"
 
 	ErrObjectNotFound = "OBJECT_NOT_FOUND"
+
+	ErrQuotaExceeded = "QUOTA_EXCEEDED"
+
+	ErrFileInfected       = "FILE_INFECTED"
+	ErrScannerUnavailable = "SCANNER_UNAVAILABLE"
+
+	ErrTimerAlreadyRunning = "TIMER_ALREADY_RUNNING"
+	ErrNoTimerRunning      = "NO_TIMER_RUNNING"
+
+	ErrInvalidCodiceFiscale  = dblayer.ValidationInvalidCodiceFiscale
+	ErrCodiceFiscaleMismatch = dblayer.ValidationCodiceFiscaleMismatch
+	ErrInvalidPartitaIVA     = dblayer.ValidationInvalidPartitaIVA
+
+	ErrInvalidImage      = dblayer.ValidationInvalidImage
+	ErrInvalidExifPolicy = dblayer.ValidationInvalidExifPolicy
 )
 
 // RespondError sends a structured error response
@@ -47,3 +65,33 @@ func RespondError(w http.ResponseWriter, code string, message string, params map
 func RespondSimpleError(w http.ResponseWriter, code string, message string, httpStatus int) {
 	RespondError(w, code, message, nil, httpStatus)
 }
+
+// respondValidationError sends the ValidationError of an entity hook as a 400
+// with the invalid field in the params. Returns false for other errors.
+func respondValidationError(w http.ResponseWriter, err error) bool {
+	var validationError *dblayer.ValidationError
+	if !errors.As(err, &validationError) {
+		return false
+	}
+	RespondError(w, validationError.Code, validationError.Message, map[string]string{"field": validationError.Field}, http.StatusBadRequest)
+	return true
+}
+
+// respondScanError sends the error of the scan of an upload: FILE_INFECTED
+// (422) with the signature found, SCANNER_UNAVAILABLE (503) when the upload
+// could not be scanned
+func respondScanError(w http.ResponseWriter, err error) bool {
+	var infected *dblayer.InfectedFileError
+	if errors.As(err, &infected) {
+		RespondError(w, ErrFileInfected, infected.Error(), map[string]string{
+			"filename":  infected.Filename,
+			"signature": infected.Signature,
+		}, http.StatusUnprocessableEntity)
+		return true
+	}
+	if errors.Is(err, dblayer.ErrScanFailed) {
+		RespondSimpleError(w, ErrScannerUnavailable, "The file could not be scanned, try again later", http.StatusServiceUnavailable)
+		return true
+	}
+	return false
+}
@@ -0,0 +1,113 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"rprj/be/dblayer"
+)
+
+// maxEventsRange is the widest range accepted by /events
+const maxEventsRange = 3 * 366 * 24 * time.Hour
+
+// EventsResponse godoc
+// @Description Response structure for the calendar: event occurrences in a range
+type EventsResponse struct {
+	Success     bool                      `json:"success"`
+	From        string                    `json:"from"`
+	To          string                    `json:"to"`
+	TimeZone    string                    `json:"tz"`
+	Occurrences []dblayer.EventOccurrence `json:"occurrences"`
+}
+
+// GetEventsHandler godoc
+// @Summary Calendar events in a range
+// @Description Expands the readable events (recurring ones included) into their occurrences overlapping [from, to).
+// @Description Without authentication only public events are returned.
+// @Tags events
+// @Produce json
+// @Param from query string false "Start of the range, e.g. 2024-01-01 (default: first day of the current month)"
+// @Param to query string false "End of the range, exclusive (default: one month after from)"
+// @Param folder query string false "Only events in this folder"
+// @Param tz query string false "IANA timezone of the event times, e.g. Europe/Rome (default: server timezone)"
+// @Success 200 {object} EventsResponse "Event occurrences"
+// @Failure 400 {object} ErrorResponse "Invalid request"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /events [get]
+func GetEventsHandler(w http.ResponseWriter, r *http.Request) {
+	claims, err := GetClaimsFromRequest(r)
+
+	var dbContext dblayer.DBContext
+	if err == nil {
+		dbContext = dblayer.DBContext{
+			UserID:   claims["user_id"],
+			GroupIDs: strings.Split(claims["groups"], ","),
+			Schema:   dblayer.DbSchema,
+		}
+	} else {
+		dbContext = dblayer.DBContext{
+			UserID:   "-7",           // Anonymous user
+			GroupIDs: []string{"-4"}, // Guests group
+			Schema:   dblayer.DbSchema,
+		}
+	}
+	repo := dblayer.NewDBRepository(&dbContext, dblayer.Factory, dblayer.DbConnection)
+	repo.Verbose = false
+
+	query := r.URL.Query()
+	loc := time.Local
+	if tz := query.Get("tz"); tz != "" {
+		loc, err = time.LoadLocation(tz)
+		if err != nil {
+			RespondError(w, ErrInvalidRequest, "Invalid timezone", map[string]string{"field": "tz"}, http.StatusBadRequest)
+			return
+		}
+	}
+
+	now := time.Now().In(loc)
+	from := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, loc)
+	if value := query.Get("from"); value != "" {
+		from, err = dblayer.ParseDateTimeIn(value, loc)
+		if err != nil {
+			RespondError(w, ErrInvalidRequest, "Invalid date", map[string]string{"field": "from"}, http.StatusBadRequest)
+			return
+		}
+	}
+	to := from.AddDate(0, 1, 0)
+	if value := query.Get("to"); value != "" {
+		to, err = dblayer.ParseDateTimeIn(value, loc)
+		if err != nil {
+			RespondError(w, ErrInvalidRequest, "Invalid date", map[string]string{"field": "to"}, http.StatusBadRequest)
+			return
+		}
+	}
+	if !to.After(from) || to.Sub(from) > maxEventsRange {
+		RespondError(w, ErrInvalidRequest, "The range must be positive and at most 3 years", map[string]string{"field": "to"}, http.StatusBadRequest)
+		return
+	}
+
+	folderID := query.Get("folder")
+	if len(folderID) == 18 {
+		folderID = strings.ReplaceAll(folderID, "-", "")
+	}
+
+	occurrences, err := repo.GetEventOccurrences(from, to, folderID, loc)
+	if err != nil {
+		log.Printf("GetEventsHandler: %v", err)
+		RespondSimpleError(w, ErrInternalServer, "Failed to read events", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(EventsResponse{
+		Success:     true,
+		From:        from.Format(time.RFC3339),
+		To:          to.Format(time.RFC3339),
+		TimeZone:    loc.String(),
+		Occurrences: occurrences,
+	})
+}
@@ -0,0 +1,192 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"rprj/be/dblayer"
+)
+
+// FacetNames are the facets computed on search results.
+// A facet is selected with the query parameter "facet_<name>", a comma
+// separated list of values (e.g. facet_classname=DBPage,DBNews&facet_year=2024).
+// camera and taken (the year of capture) are the metadata of the images.
+var FacetNames = []string{"classname", "language", "owner", "mime", "year", "camera", "taken"}
+
+// FacetValue is a value of a facet with the number of results having it
+type FacetValue struct {
+	Value string `json:"value"`
+	Label string `json:"label,omitempty"`
+	Count int    `json:"count"`
+}
+
+// ParseFacetFilters reads the selected facets from the request
+func ParseFacetFilters(r *http.Request) map[string][]string {
+	selected := make(map[string][]string)
+	for _, name := range FacetNames {
+		param := r.URL.Query().Get("facet_" + name)
+		if param == "" {
+			continue
+		}
+		values := []string{}
+		for _, v := range strings.Split(param, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				values = append(values, v)
+			}
+		}
+		if len(values) > 0 {
+			selected[name] = values
+		}
+	}
+	return selected
+}
+
+// ParseRangeParams reads the bounds of the columns from the request:
+// _from_<column> and _to_<column> (e.g. _from_taken_date=2024-07-01), as
+// the "from" and "to" metadata of a search entity (see DBRepository.Search).
+// Unknown columns are ignored by the search.
+func ParseRangeParams(query url.Values) map[string]map[string]string {
+	ranges := make(map[string]map[string]string)
+	for param, values := range query {
+		for _, metadata := range []string{"from", "to"} {
+			column, ok := strings.CutPrefix(param, "_"+metadata+"_")
+			value := strings.TrimSpace(values[0])
+			if !ok || column == "" || value == "" {
+				continue
+			}
+			if ranges[metadata] == nil {
+				ranges[metadata] = make(map[string]string)
+			}
+			ranges[metadata][column] = value
+		}
+	}
+	return ranges
+}
+
+// EntityFacetValues returns the value of each facet for an entity.
+// Facets not applicable to the entity (e.g. mime for a page) are missing.
+func EntityFacetValues(entity dblayer.DBEntityInterface) map[string]string {
+	values := make(map[string]string)
+	if classname, ok := entity.GetMetadata("classname").(string); ok && classname != "" {
+		values["classname"] = classname
+	} else {
+		values["classname"] = entity.GetTypeName()
+	}
+	if language, ok := entity.GetValue("language").(string); ok && language != "" {
+		values["language"] = language
+	}
+	if owner, ok := entity.GetValue("owner").(string); ok && owner != "" {
+		values["owner"] = owner
+	}
+	// MIME type family: image/png -> image
+	if mime, ok := entity.GetValue("mime").(string); ok && mime != "" {
+		values["mime"] = strings.SplitN(mime, "/", 2)[0]
+	}
+	if date := entity.GetValue("last_modify_date"); date != nil {
+		if year := fmt.Sprint(date); len(year) >= 4 {
+			values["year"] = year[:4]
+		}
+	}
+	if camera, ok := entity.GetValue("camera").(string); ok && camera != "" {
+		values["camera"] = camera
+	}
+	if date := entity.GetValue("taken_date"); date != nil {
+		if year := fmt.Sprint(date); len(year) >= 4 {
+			values["taken"] = year[:4]
+		}
+	}
+	return values
+}
+
+// facetMatches tells if the facet values satisfy all the selected facets but skip.
+// Values of the same facet are in OR, different facets are in AND.
+func facetMatches(values map[string]string, selected map[string][]string, skip string) bool {
+	for name, accepted := range selected {
+		if name == skip {
+			continue
+		}
+		found := false
+		for _, a := range accepted {
+			if values[name] == a {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// ApplyFacets filters the (already permission filtered) entities by the
+// selected facets and computes the facet counts.
+// The counts of a facet take into account the selection of the other facets
+// only, so that the UI can still offer the alternatives of a selected facet.
+func ApplyFacets(entities []dblayer.DBEntityInterface, selected map[string][]string) ([]bool, map[string][]FacetValue) {
+	keep := make([]bool, len(entities))
+	counts := make(map[string]map[string]int)
+	for _, name := range FacetNames {
+		counts[name] = make(map[string]int)
+	}
+
+	for i, entity := range entities {
+		values := EntityFacetValues(entity)
+		keep[i] = facetMatches(values, selected, "")
+		for _, name := range FacetNames {
+			value, ok := values[name]
+			if !ok || !facetMatches(values, selected, name) {
+				continue
+			}
+			counts[name][value]++
+		}
+	}
+
+	facets := make(map[string][]FacetValue)
+	for _, name := range FacetNames {
+		list := []FacetValue{}
+		for value, count := range counts[name] {
+			list = append(list, FacetValue{Value: value, Count: count})
+		}
+		sort.Slice(list, func(i, j int) bool {
+			if list[i].Count != list[j].Count {
+				return list[i].Count > list[j].Count
+			}
+			return list[i].Value < list[j].Value
+		})
+		facets[name] = list
+	}
+	return keep, facets
+}
+
+// labelOwnerFacet sets the user full name (or login) as label of the owner facet values
+func labelOwnerFacet(repo *dblayer.DBRepository, facets map[string][]FacetValue) {
+	for i, fv := range facets["owner"] {
+		user := repo.GetEntityByID("users", fv.Value)
+		if user == nil {
+			continue
+		}
+		if fullname, ok := user.GetValue("fullname").(string); ok && fullname != "" {
+			facets["owner"][i].Label = fullname
+		} else if login, ok := user.GetValue("login").(string); ok {
+			facets["owner"][i].Label = login
+		}
+	}
+}
+
+// needsFullObjectForFacets tells if a lightweight search result (common DBObject
+// columns only) must be read in full to compute its facets
+func needsFullObjectForFacets(repo *dblayer.DBRepository, entity dblayer.DBEntityInterface) bool {
+	if entity.GetValue("language") != nil || entity.GetValue("mime") != nil {
+		return false
+	}
+	classname, _ := entity.GetMetadata("classname").(string)
+	instance := repo.GetInstanceByClassName(classname)
+	if instance == nil {
+		return false
+	}
+	return instance.GetColumnType("language") != "" || instance.GetColumnType("mime") != ""
+}
@@ -0,0 +1,69 @@
+package api
+
+import (
+	"net/url"
+	"testing"
+
+	"rprj/be/dblayer"
+)
+
+func TestApplyFacets(t *testing.T) {
+	newObject := func(classname, language, mime, date string) dblayer.DBEntityInterface {
+		var obj dblayer.DBEntityInterface
+		switch classname {
+		case "DBPage":
+			obj = dblayer.NewDBPage()
+			obj.SetValue("language", language)
+		case "DBFile":
+			obj = dblayer.NewDBFile()
+			obj.SetValue("mime", mime)
+		}
+		obj.SetMetadata("classname", classname)
+		obj.SetValue("owner", "1")
+		obj.SetValue("last_modify_date", date)
+		return obj
+	}
+	entities := []dblayer.DBEntityInterface{
+		newObject("DBPage", "it_it", "", "2024-01-10 10:00:00"),
+		newObject("DBPage", "en_us", "", "2023-05-01 10:00:00"),
+		newObject("DBFile", "", "image/png", "2024-02-01 10:00:00"),
+		newObject("DBFile", "", "application/pdf", "2024-03-01 10:00:00"),
+	}
+
+	keep, facets := ApplyFacets(entities, map[string][]string{"year": {"2024"}, "classname": {"DBPage"}})
+	if !keep[0] || keep[1] || keep[2] || keep[3] {
+		t.Errorf("ApplyFacets: keep=%v", keep)
+	}
+	// classname counts ignore the classname selection, but not the year one
+	expected := map[string]int{"DBFile": 2, "DBPage": 1}
+	for _, fv := range facets["classname"] {
+		if expected[fv.Value] != fv.Count {
+			t.Errorf("classname facet %s: got %d, expected %d", fv.Value, fv.Count, expected[fv.Value])
+		}
+	}
+	// year counts ignore the year selection: both the pages
+	if len(facets["year"]) != 2 {
+		t.Errorf("year facet: %v", facets["year"])
+	}
+	if len(facets["mime"]) != 0 {
+		t.Errorf("mime facet should be empty with classname=DBPage: %v", facets["mime"])
+	}
+}
+
+func TestImageFacetsAndRanges(t *testing.T) {
+	photo := dblayer.NewDBFile()
+	photo.SetMetadata("classname", "DBFile")
+	photo.SetValue("mime", "image/jpeg")
+	photo.SetValue("camera", "Canon EOS 5D")
+	photo.SetValue("taken_date", "2024-07-14 10:30:00")
+	values := EntityFacetValues(photo)
+	if values["camera"] != "Canon EOS 5D" || values["taken"] != "2024" {
+		t.Errorf("EntityFacetValues: %v", values)
+	}
+
+	query, _ := url.ParseQuery("_from_taken_date=2024-07-01&_to_taken_date=2024-07-31&_to_=x&_from_size=&name=a")
+	ranges := ParseRangeParams(query)
+	if len(ranges) != 2 || len(ranges["from"]) != 1 || ranges["from"]["taken_date"] != "2024-07-01" || len(ranges["to"]) != 1 || ranges["to"]["taken_date"] != "2024-07-31" {
+		t.Errorf("ParseRangeParams: %v", ranges)
+	}
+}
@@ -0,0 +1,328 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"rprj/be/dblayer"
+
+	"github.com/gorilla/mux"
+)
+
+// FileMigrationResponse godoc
+// @Description Response structure of a run of the files layout migration
+type FileMigrationResponse struct {
+	Success bool                         `json:"success"`
+	Result  *dblayer.FileMigrationReport `json:"result"`
+}
+
+// MigrateFileLayoutHandler godoc
+// @Summary Migrate the files to the configured layout
+// @Description Moves the files stored in the legacy <father_id>/ layout, with their thumbnails, to the sharded
+// @Description layout configured in files_layout (XX/YY/ from the id or the checksum). Every file is verified against
+// @Description its checksum. At most limit files are moved per call: call again while remaining is greater than the
+// @Description number of failed files. An interrupted run is resumed by the next one. With the content layout the
+// @Description files of every layout, and the archived revisions, are deduplicated by checksum. Admin only.
+// @Tags files
+// @Produce json
+// @Param limit query int false "Maximum number of files to move (default 500, 0 = all)"
+// @Success 200 {object} FileMigrationResponse "Migration report"
+// @Failure 400 {object} ErrorResponse "Legacy layout configured"
+// @Failure 403 {object} ErrorResponse "Forbidden"
+// @Security BearerAuth
+// @Router /files/storage/migrate [post]
+func MigrateFileLayoutHandler(w http.ResponseWriter, r *http.Request) {
+	repo, ok := authenticatedRepository(w, r)
+	if !ok {
+		return
+	}
+	if !isAdminRepository(repo) {
+		RespondSimpleError(w, ErrForbidden, "Only administrators can migrate the files", http.StatusForbidden)
+		return
+	}
+	limit := 500
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 {
+			RespondError(w, ErrInvalidRequest, "Invalid limit", map[string]string{"field": "limit"}, http.StatusBadRequest)
+			return
+		}
+		limit = parsed
+	}
+	result, err := repo.MigrateFileLayout(limit)
+	if errors.Is(err, dblayer.ErrLegacyFileLayout) {
+		RespondSimpleError(w, ErrInvalidRequest, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("MigrateFileLayoutHandler: %v", err)
+		RespondSimpleError(w, ErrInternalServer, "Failed to migrate the files", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(FileMigrationResponse{
+		Success: true,
+		Result:  result,
+	})
+}
+
+// serveBlob streams a blob of the file storage; handler names the caller in the logs.
+// It answers the conditional requests (If-None-Match, If-Modified-Since) with
+// 304 and serves a single byte Range, honoring If-Range.
+func serveBlob(w http.ResponseWriter, r *http.Request, handler string, key string, mime string, filename string, cache blobCache) {
+	serveBlobFrom(w, r, dblayer.FileStorage, handler, key, mime, filename, cache)
+}
+
+// serveBlobFrom is serveBlob for a blob of the given storage
+func serveBlobFrom(w http.ResponseWriter, r *http.Request, storage dblayer.BlobStorage, handler string, key string, mime string, filename string, cache blobCache) {
+	// Get file info for size
+	fileInfo, err := storage.Stat(key)
+	if os.IsNotExist(err) {
+		log.Printf("%s: File %s not found: %v", handler, key, err)
+		RespondSimpleError(w, ErrObjectNotFound, "File not found on disk", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("%s: Failed to stat file %s: %v", handler, key, err)
+		RespondSimpleError(w, ErrInternalServer, "Failed to read file info", http.StatusInternalServerError)
+		return
+	}
+
+	if cache.modified.IsZero() {
+		cache.modified = fileInfo.ModTime
+	}
+	cache.writeHeaders(w)
+	if cache.notModified(r) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	// A single range of the blob
+	offset, length, partial := int64(0), fileInfo.Size, false
+	if header := r.Header.Get("Range"); header != "" && r.Method == http.MethodGet && cache.rangeApplies(r) {
+		var err error
+		offset, length, partial, err = parseByteRange(header, fileInfo.Size)
+		if errors.Is(err, errRangeNotSatisfiable) {
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", fileInfo.Size))
+			RespondSimpleError(w, ErrInvalidRequest, "Range not satisfiable", http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		if !partial {
+			offset, length = 0, fileInfo.Size
+		}
+	}
+
+	// Set headers
+	w.Header().Set("Content-Type", mime)
+	w.Header().Set("Content-Length", fmt.Sprintf("%d", length))
+
+	// For images, display inline; for other files, force download
+	if strings.HasPrefix(mime, "image/") {
+		w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=\"%s\"", filename))
+	} else {
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
+	}
+	if r.Method == http.MethodHead {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	// Open file from the storage
+	var file io.ReadCloser
+	if partial {
+		file, err = storage.GetRange(key, offset, length)
+	} else {
+		file, err = storage.Get(key)
+	}
+	if err != nil {
+		log.Printf("%s: Failed to open file %s: %v", handler, key, err)
+		RespondSimpleError(w, ErrObjectNotFound, "File not found on disk", http.StatusNotFound)
+		return
+	}
+	defer file.Close()
+
+	if partial {
+		w.Header().Set("Content-Range", contentRange(offset, length, fileInfo.Size))
+		w.WriteHeader(http.StatusPartialContent)
+	}
+
+	// Stream file to response
+	if _, err := io.Copy(w, file); err != nil {
+		log.Printf("%s: Failed to stream file %s: %v", handler, key, err)
+	}
+}
+
+// FileRevisionsResponse godoc
+// @Description Response structure of the revisions of a file
+type FileRevisionsResponse struct {
+	Success   bool             `json:"success"`
+	Revisions []map[string]any `json:"revisions"`
+}
+
+// readableFile loads a file readable by the user, responding 404 otherwise
+func readableFile(w http.ResponseWriter, repo *dblayer.DBRepository, fileID string) (*dblayer.DBFile, bool) {
+	entity := repo.GetEntityByID("files", fileID)
+	dbFile, ok := entity.(*dblayer.DBFile)
+	if !ok || !repo.CheckReadPermission(dbFile) {
+		RespondSimpleError(w, ErrObjectNotFound, "File not found", http.StatusNotFound)
+		return nil, false
+	}
+	return dbFile, true
+}
+
+// revisionParam parses the revision number in the path
+func revisionParam(w http.ResponseWriter, r *http.Request) (int, bool) {
+	revision, err := strconv.Atoi(mux.Vars(r)["revision"])
+	if err != nil || revision < 1 {
+		RespondError(w, ErrInvalidRequest, "Invalid revision", map[string]string{"field": "revision"}, http.StatusBadRequest)
+		return 0, false
+	}
+	return revision, true
+}
+
+// GetFileRevisionsHandler godoc
+// @Summary Revisions of a file
+// @Description Returns the revisions of a file, newest first: revision, filename, checksum, mime, size, uploader
+// @Description and upload_date. The current revision has an empty storage_key.
+// @Tags files
+// @Produce json
+// @Param id path string true "File ID"
+// @Success 200 {object} FileRevisionsResponse "Revisions"
+// @Failure 404 {object} ErrorResponse "File not found"
+// @Security BearerAuth
+// @Router /files/{id}/revisions [get]
+func GetFileRevisionsHandler(w http.ResponseWriter, r *http.Request) {
+	repo, ok := authenticatedRepository(w, r)
+	if !ok {
+		return
+	}
+	fileID := normalizeObjectID(mux.Vars(r)["id"])
+	if _, ok := readableFile(w, repo, fileID); !ok {
+		return
+	}
+	revisions, err := repo.GetFileRevisions(fileID)
+	if err != nil {
+		log.Printf("GetFileRevisionsHandler: %v", err)
+		RespondSimpleError(w, ErrInternalServer, "Failed to read the revisions", http.StatusInternalServerError)
+		return
+	}
+	result := make([]map[string]any, 0, len(revisions))
+	for _, revision := range revisions {
+		result = append(result, revision.GetAllValues())
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(FileRevisionsResponse{
+		Success:   true,
+		Revisions: result,
+	})
+}
+
+// DownloadFileRevisionHandler godoc
+// @Summary Download a revision of a file
+// @Description Downloads the content of a revision of a file
+// @Tags files
+// @Produce octet-stream
+// @Param id path string true "File ID"
+// @Param revision path int true "Revision number"
+// @Success 200 {file} file "File content"
+// @Failure 404 {object} ErrorResponse "File or revision not found"
+// @Security BearerAuth
+// @Router /files/{id}/revisions/{revision}/download [get]
+func DownloadFileRevisionHandler(w http.ResponseWriter, r *http.Request) {
+	repo, ok := authenticatedRepository(w, r)
+	if !ok {
+		return
+	}
+	fileID := normalizeObjectID(mux.Vars(r)["id"])
+	dbFile, ok := readableFile(w, repo, fileID)
+	if !ok {
+		return
+	}
+	number, ok := revisionParam(w, r)
+	if !ok {
+		return
+	}
+	revision := repo.GetFileRevision(fileID, number)
+	if revision == nil {
+		RespondSimpleError(w, ErrObjectNotFound, "Revision not found", http.StatusNotFound)
+		return
+	}
+	mime, _ := revision.GetValue("mime").(string)
+	if mime == "" {
+		mime = "application/octet-stream"
+	}
+	filename, _ := revision.GetValue("filename").(string)
+	// A revision never changes: its upload date is its last modification
+	checksum, _ := revision.GetValue("checksum").(string)
+	uploadDate, _ := revision.GetValue("upload_date").(string)
+	cache := newBlobCache(checksum, "", uploadDate, isPublicObject(dbFile))
+	serveBlob(w, r, "DownloadFileRevisionHandler", revision.GetBlobKey(dbFile), mime, filename, cache)
+}
+
+// PromoteFileRevisionHandler godoc
+// @Summary Promote a revision of a file
+// @Description Makes an old revision the current content of the file. A copy of the revision is uploaded as a
+// @Description new revision: the history is kept. The retention of the folder applies.
+// @Tags files
+// @Produce json
+// @Param id path string true "File ID"
+// @Param revision path int true "Revision number"
+// @Success 200 {object} ObjectResponse "Updated file"
+// @Failure 400 {object} ErrorResponse "Current revision"
+// @Failure 403 {object} ErrorResponse "Forbidden"
+// @Failure 404 {object} ErrorResponse "File or revision not found"
+// @Failure 413 {object} ErrorResponse "Storage quota exceeded"
+// @Security BearerAuth
+// @Router /files/{id}/revisions/{revision}/promote [post]
+func PromoteFileRevisionHandler(w http.ResponseWriter, r *http.Request) {
+	repo, ok := authenticatedRepository(w, r)
+	if !ok {
+		return
+	}
+	fileID := normalizeObjectID(mux.Vars(r)["id"])
+	dbFile, ok := readableFile(w, repo, fileID)
+	if !ok {
+		return
+	}
+	if !repo.CheckWritePermission(dbFile) {
+		RespondSimpleError(w, ErrForbidden, "You cannot modify this file", http.StatusForbidden)
+		return
+	}
+	number, ok := revisionParam(w, r)
+	if !ok {
+		return
+	}
+	updated, err := repo.PromoteFileRevision(fileID, number)
+	switch {
+	case errors.Is(err, dblayer.ErrFileRevisionNotFound):
+		RespondSimpleError(w, ErrObjectNotFound, "Revision not found", http.StatusNotFound)
+		return
+	case errors.Is(err, dblayer.ErrCurrentFileRevision):
+		RespondSimpleError(w, ErrInvalidRequest, err.Error(), http.StatusBadRequest)
+		return
+	case respondQuotaError(w, err):
+		return
+	case err != nil:
+		log.Printf("PromoteFileRevisionHandler: %v", err)
+		RespondSimpleError(w, ErrInternalServer, "Failed to promote the revision", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(ObjectResponse{
+		Success: true,
+		Data:    updated.GetAllValues(),
+		Metadata: map[string]interface{}{
+			"classname": "DBFile",
+		},
+	})
+}
@@ -0,0 +1,170 @@
+package api
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"rprj/be/dblayer"
+)
+
+/*
+HTTP caching and ranges of the downloads.
+
+The ETag of a blob is its checksum, with a suffix for the variants of the
+same content (like the thumbnail): it changes only when a new content is
+uploaded. Last-Modified comes from the last_modify_date of the object.
+The objects readable by everybody may be stored by shared caches, the
+others only by the browser, which has to revalidate them (a cheap 304).
+*/
+
+// Cache-Control of the downloads
+const (
+	cacheControlPublic  = "public, max-age=3600"
+	cacheControlPrivate = "private, no-cache"
+)
+
+// blobCache holds the validators and the cache policy of a served blob
+type blobCache struct {
+	// ETag, quoted, "" when the checksum is unknown
+	etag string
+	// Last modification, the modification time of the blob when zero
+	modified time.Time
+	// Readable by everybody
+	public bool
+}
+
+// newBlobCache returns the cache information of a blob. variant tells the
+// representations of the same checksum apart (e.g. "thumb"); modified is a
+// DB datetime.
+func newBlobCache(checksum string, variant string, modified string, public bool) blobCache {
+	cache := blobCache{public: public}
+	// The checksum of a missing blob is an error message
+	if _, err := hex.DecodeString(checksum); err == nil && len(checksum) == 40 {
+		cache.etag = checksum
+		if variant != "" {
+			cache.etag += "-" + variant
+		}
+		cache.etag = `"` + cache.etag + `"`
+	}
+	if t, err := dblayer.ParseDateTime(modified); err == nil {
+		cache.modified = t
+	}
+	return cache
+}
+
+// isPublicObject tells if an object is readable by everybody
+func isPublicObject(dbe dblayer.DBEntityInterface) bool {
+	permissions, _ := dbe.GetValue("permissions").(string)
+	return len(permissions) == 9 && permissions[6] == 'r'
+}
+
+// writeHeaders sets the validators and the Cache-Control of the response
+func (cache blobCache) writeHeaders(w http.ResponseWriter) {
+	if cache.etag != "" {
+		w.Header().Set("ETag", cache.etag)
+	}
+	if !cache.modified.IsZero() {
+		w.Header().Set("Last-Modified", cache.modified.UTC().Format(http.TimeFormat))
+	}
+	if cache.public {
+		w.Header().Set("Cache-Control", cacheControlPublic)
+	} else {
+		w.Header().Set("Cache-Control", cacheControlPrivate)
+	}
+	w.Header().Set("Accept-Ranges", "bytes")
+}
+
+// notModified tells if the copy of the client is still valid: If-None-Match
+// first, If-Modified-Since when there is no If-None-Match
+func (cache blobCache) notModified(r *http.Request) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		if cache.etag == "" {
+			return false
+		}
+		for _, tag := range strings.Split(inm, ",") {
+			tag = strings.TrimSpace(tag)
+			// Weak comparison
+			if tag == "*" || strings.TrimPrefix(tag, "W/") == cache.etag {
+				return true
+			}
+		}
+		return false
+	}
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" && !cache.modified.IsZero() {
+		t, err := http.ParseTime(ims)
+		return err == nil && !cache.modified.Truncate(time.Second).After(t)
+	}
+	return false
+}
+
+// rangeApplies tells if the Range of the request is to be honored: without
+// If-Range, or when If-Range matches the current version of the blob
+func (cache blobCache) rangeApplies(r *http.Request) bool {
+	ifRange := r.Header.Get("If-Range")
+	if ifRange == "" {
+		return true
+	}
+	if strings.HasPrefix(ifRange, `"`) || strings.HasPrefix(ifRange, "W/") {
+		// Strong comparison
+		return cache.etag != "" && ifRange == cache.etag
+	}
+	t, err := http.ParseTime(ifRange)
+	return err == nil && !cache.modified.IsZero() && cache.modified.Truncate(time.Second).Equal(t)
+}
+
+// errRangeNotSatisfiable is a Range outside of the blob
+var errRangeNotSatisfiable = errors.New("range not satisfiable")
+
+// parseByteRange parses the Range header of a blob of the given size. Only a
+// single byte range is served: ok is false when the whole blob is to be sent
+// (no range, multiple ranges or an invalid header, which is ignored).
+func parseByteRange(header string, size int64) (offset int64, length int64, ok bool, err error) {
+	spec, found := strings.CutPrefix(header, "bytes=")
+	if !found || strings.Contains(spec, ",") {
+		return 0, 0, false, nil
+	}
+	first, last, found := strings.Cut(strings.TrimSpace(spec), "-")
+	if !found {
+		return 0, 0, false, nil
+	}
+	if first == "" {
+		// Suffix: the last bytes
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n < 0 {
+			return 0, 0, false, nil
+		}
+		if n == 0 || size == 0 {
+			return 0, 0, false, errRangeNotSatisfiable
+		}
+		n = min(n, size)
+		return size - n, n, true, nil
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return 0, 0, false, nil
+	}
+	end := size - 1
+	if last != "" {
+		if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+			return 0, 0, false, nil
+		}
+		end = min(end, size-1)
+	}
+	if start >= size {
+		return 0, 0, false, errRangeNotSatisfiable
+	}
+	return start, end - start + 1, true, nil
+}
+
+// contentRange returns the Content-Range of a partial response
+func contentRange(offset int64, length int64, size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", offset, offset+length-1, size)
+}
@@ -0,0 +1,126 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"rprj/be/dblayer"
+)
+
+func TestParseByteRange(t *testing.T) {
+	for _, c := range []struct {
+		header         string
+		offset, length int64
+		ok             bool
+		unsatisfiable  bool
+	}{
+		{"bytes=0-4", 0, 5, true, false},
+		{"bytes=6-", 6, 5, true, false},
+		{"bytes=-3", 8, 3, true, false},
+		{"bytes=-100", 0, 11, true, false},
+		{"bytes=5-100", 5, 6, true, false},
+		{"bytes=11-", 0, 0, false, true},
+		{"bytes=-0", 0, 0, false, true},
+		{"bytes=0-1,3-4", 0, 0, false, false},
+		{"bytes=4-2", 0, 0, false, false},
+		{"items=0-1", 0, 0, false, false},
+	} {
+		offset, length, ok, err := parseByteRange(c.header, 11)
+		if offset != c.offset || length != c.length || ok != c.ok || (err != nil) != c.unsatisfiable {
+			t.Errorf("%s: got %d %d %v %v", c.header, offset, length, ok, err)
+		}
+	}
+}
+
+func TestServeBlob(t *testing.T) {
+	previous := dblayer.FileStorage
+	defer func() { dblayer.FileStorage = previous }()
+	dblayer.FileStorage = dblayer.NewLocalStorage(t.TempDir())
+	if err := dblayer.FileStorage.Put("ab/cd/blob", strings.NewReader("hello world"), 11); err != nil {
+		t.Fatal(err)
+	}
+	checksum := "2aae6c35c94fcfb415dbe95f408b9ce91ee846ed"
+	cache := newBlobCache(checksum, "", "2024-03-01 10:00:00", false)
+
+	serve := func(headers map[string]string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/files/1/download", nil)
+		for k, v := range headers {
+			r.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		serveBlob(w, r, "TestServeBlob", "ab/cd/blob", "text/plain", "hello.txt", cache)
+		return w
+	}
+
+	w := serve(nil)
+	if w.Code != http.StatusOK || w.Body.String() != "hello world" {
+		t.Fatalf("got %d %q", w.Code, w.Body.String())
+	}
+	if w.Header().Get("ETag") != `"`+checksum+`"` || w.Header().Get("Cache-Control") != cacheControlPrivate || w.Header().Get("Last-Modified") == "" {
+		t.Errorf("got %v", w.Header())
+	}
+	lastModified := w.Header().Get("Last-Modified")
+
+	if w := serve(map[string]string{"If-None-Match": `W/"x", "` + checksum + `"`}); w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Errorf("If-None-Match: got %d", w.Code)
+	}
+	if w := serve(map[string]string{"If-None-Match": `"other"`, "If-Modified-Since": lastModified}); w.Code != http.StatusOK {
+		t.Errorf("If-None-Match wins over If-Modified-Since: got %d", w.Code)
+	}
+	if w := serve(map[string]string{"If-Modified-Since": lastModified}); w.Code != http.StatusNotModified {
+		t.Errorf("If-Modified-Since: got %d", w.Code)
+	}
+
+	w = serve(map[string]string{"Range": "bytes=6-"})
+	if w.Code != http.StatusPartialContent || w.Body.String() != "world" || w.Header().Get("Content-Range") != "bytes 6-10/11" {
+		t.Errorf("Range: got %d %q %s", w.Code, w.Body.String(), w.Header().Get("Content-Range"))
+	}
+	if w := serve(map[string]string{"Range": "bytes=0-4", "If-Range": `"` + checksum + `"`}); w.Code != http.StatusPartialContent || w.Body.String() != "hello" {
+		t.Errorf("If-Range: got %d %q", w.Code, w.Body.String())
+	}
+	if w := serve(map[string]string{"Range": "bytes=0-4", "If-Range": `"other"`}); w.Code != http.StatusOK || w.Body.String() != "hello world" {
+		t.Errorf("stale If-Range: got %d", w.Code)
+	}
+	if w := serve(map[string]string{"Range": "bytes=20-"}); w.Code != http.StatusRequestedRangeNotSatisfiable || w.Header().Get("Content-Range") != "bytes */11" {
+		t.Errorf("unsatisfiable: got %d", w.Code)
+	}
+
+	// Public objects and thumbnails
+	cache = newBlobCache(checksum, "thumb", "", true)
+	w = serve(nil)
+	if w.Header().Get("ETag") != `"`+checksum+`-thumb"` || w.Header().Get("Cache-Control") != cacheControlPublic {
+		t.Errorf("got %v", w.Header())
+	}
+	if cache := newBlobCache("File 'a.txt' not found!", "", "", false); cache.etag != "" {
+		t.Errorf("got %s", cache.etag)
+	}
+}
+
+func TestParseRendition(t *testing.T) {
+	for _, c := range []struct {
+		query     string
+		expected  dblayer.Rendition
+		requested bool
+		invalid   bool
+	}{
+		{"", dblayer.Rendition{}, false, false},
+		{"token=abc", dblayer.Rendition{}, false, false},
+		{"w=480", dblayer.Rendition{Width: 480}, true, false},
+		{"w=200&h=100&fit=cover&format=JPG", dblayer.Rendition{Width: 200, Height: 100, Fit: "cover", Format: "jpeg"}, true, false},
+		{"format=webp", dblayer.Rendition{Format: "webp"}, true, false},
+		{"w=0", dblayer.Rendition{}, true, true},
+		{"h=99999", dblayer.Rendition{}, true, true},
+		{"w=abc", dblayer.Rendition{}, true, true},
+		{"w=10&fit=fill", dblayer.Rendition{Width: 10}, true, true},
+		{"format=gif", dblayer.Rendition{}, true, true},
+	} {
+		query, _ := url.ParseQuery(c.query)
+		rendition, requested, err := parseRendition(query)
+		if requested != c.requested || (err != nil) != c.invalid || (err == nil && rendition != c.expected) {
+			t.Errorf("%s: got %+v %v %v", c.query, rendition, requested, err)
+		}
+	}
+}
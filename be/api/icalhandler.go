@@ -0,0 +1,296 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"rprj/be/dblayer"
+
+	"github.com/gorilla/mux"
+)
+
+// maxICalendarSize is the largest iCalendar file accepted by the import
+const maxICalendarSize = 10 << 20
+
+// CalendarTokenResponse godoc
+// @Description Response structure with the secret token of the private calendar feed
+type CalendarTokenResponse struct {
+	Success bool   `json:"success"`
+	Token   string `json:"token"`
+	FeedURL string `json:"feed_url"`
+}
+
+// CalendarImportResponse godoc
+// @Description Response structure of an iCalendar import
+type CalendarImportResponse struct {
+	Success bool                      `json:"success"`
+	Result  *dblayer.ICalImportResult `json:"result"`
+}
+
+// calendarLocation reads the tz parameter, defaulting to the server timezone
+func calendarLocation(w http.ResponseWriter, r *http.Request) (*time.Location, bool) {
+	tz := r.URL.Query().Get("tz")
+	if tz == "" {
+		return time.Local, true
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		RespondError(w, ErrInvalidRequest, "Invalid timezone", map[string]string{"field": "tz"}, http.StatusBadRequest)
+		return nil, false
+	}
+	return loc, true
+}
+
+// writeCalendarFeed sends the events of the folder/category readable in dbContext as iCalendar
+func writeCalendarFeed(w http.ResponseWriter, r *http.Request, dbContext *dblayer.DBContext) {
+	repo := dblayer.NewDBRepository(dbContext, dblayer.Factory, dblayer.DbConnection)
+	repo.Verbose = false
+
+	loc, ok := calendarLocation(w, r)
+	if !ok {
+		return
+	}
+	folderID := normalizeObjectID(r.URL.Query().Get("folder"))
+	category := r.URL.Query().Get("category")
+
+	events, err := repo.GetCalendarEvents(folderID, category)
+	if err != nil {
+		log.Printf("writeCalendarFeed: %v", err)
+		RespondSimpleError(w, ErrInternalServer, "Failed to read events", http.StatusInternalServerError)
+		return
+	}
+
+	name := "rhobee"
+	if category != "" {
+		name = category
+	}
+	if folderID != "" {
+		if folder := repo.ObjectByID(folderID, true); folder != nil && repo.CheckReadPermission(folder) {
+			name, _ = folder.GetValue("name").(string)
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Disposition", "inline; filename=\"calendar.ics\"")
+	w.WriteHeader(http.StatusOK)
+	io.WriteString(w, dblayer.BuildICalendar(name, events, repo.GetEventUIDs(events), loc))
+}
+
+// PublicCalendarFeedHandler godoc
+// @Summary Public iCalendar feed
+// @Description Public events of a folder and/or category as iCalendar (RFC 5545), to subscribe from calendar clients
+// @Tags events
+// @Produce text/calendar
+// @Param folder query string false "Only events in this folder"
+// @Param category query string false "Only events of this category"
+// @Param tz query string false "IANA timezone of the stored event times (default: server timezone)"
+// @Success 200 {string} string "iCalendar feed"
+// @Failure 400 {object} ErrorResponse "Invalid request"
+// @Router /calendar/feed.ics [get]
+func PublicCalendarFeedHandler(w http.ResponseWriter, r *http.Request) {
+	// Always anonymous, even with a session: the feed URL is meant to be shared
+	writeCalendarFeed(w, r, &dblayer.DBContext{
+		UserID:   "-7",           // Anonymous user
+		GroupIDs: []string{"-4"}, // Guests group
+		Schema:   dblayer.DbSchema,
+	})
+}
+
+// PrivateCalendarFeedHandler godoc
+// @Summary Private iCalendar feed
+// @Description Events readable by the owner of the secret token, as iCalendar (RFC 5545)
+// @Tags events
+// @Produce text/calendar
+// @Param token path string true "Secret token from /calendar/token"
+// @Param folder query string false "Only events in this folder"
+// @Param category query string false "Only events of this category"
+// @Param tz query string false "IANA timezone of the stored event times (default: server timezone)"
+// @Success 200 {string} string "iCalendar feed"
+// @Failure 404 {object} ErrorResponse "Unknown token"
+// @Router /calendar/feed/{token}.ics [get]
+func PrivateCalendarFeedHandler(w http.ResponseWriter, r *http.Request) {
+	lookup := dblayer.NewDBRepository(&dblayer.DBContext{
+		UserID:   "-1",
+		GroupIDs: []string{"-2"},
+		Schema:   dblayer.DbSchema,
+	}, dblayer.Factory, dblayer.DbConnection)
+	lookup.Verbose = false
+
+	userID := lookup.GetUserIDByCalendarToken(mux.Vars(r)["token"])
+	if userID == "" {
+		RespondSimpleError(w, ErrObjectNotFound, "Unknown calendar", http.StatusNotFound)
+		return
+	}
+	groupIDs, err := lookup.GetUserGroupIDs(userID)
+	if err != nil {
+		log.Printf("PrivateCalendarFeedHandler: %v", err)
+		RespondSimpleError(w, ErrInternalServer, "Failed to read user groups", http.StatusInternalServerError)
+		return
+	}
+	writeCalendarFeed(w, r, &dblayer.DBContext{
+		UserID:   userID,
+		GroupIDs: groupIDs,
+		Schema:   dblayer.DbSchema,
+	})
+}
+
+// respondCalendarToken sends the token with the URL of the private feed
+func respondCalendarToken(w http.ResponseWriter, r *http.Request, token string) {
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(CalendarTokenResponse{
+		Success: true,
+		Token:   token,
+		FeedURL: scheme + "://" + r.Host + "/calendar/feed/" + token + ".ics",
+	})
+}
+
+// GetCalendarTokenHandler godoc
+// @Summary Get the private feed token
+// @Description Returns the secret token of the private calendar feed of the current user, creating it on first use
+// @Tags events
+// @Produce json
+// @Success 200 {object} CalendarTokenResponse "Token and feed URL"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Security BearerAuth
+// @Router /calendar/token [get]
+func GetCalendarTokenHandler(w http.ResponseWriter, r *http.Request) {
+	repo, ok := authenticatedRepository(w, r)
+	if !ok {
+		return
+	}
+	token, err := repo.GetCalendarToken()
+	if err != nil {
+		log.Printf("GetCalendarTokenHandler: %v", err)
+		RespondSimpleError(w, ErrInternalServer, "Failed to create the calendar token", http.StatusInternalServerError)
+		return
+	}
+	respondCalendarToken(w, r, token)
+}
+
+// RegenerateCalendarTokenHandler godoc
+// @Summary Regenerate the private feed token
+// @Description Replaces the secret token of the private calendar feed: subscriptions with the old URL stop working
+// @Tags events
+// @Produce json
+// @Success 200 {object} CalendarTokenResponse "New token and feed URL"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Security BearerAuth
+// @Router /calendar/token [post]
+func RegenerateCalendarTokenHandler(w http.ResponseWriter, r *http.Request) {
+	repo, ok := authenticatedRepository(w, r)
+	if !ok {
+		return
+	}
+	token, err := repo.RegenerateCalendarToken()
+	if err != nil {
+		log.Printf("RegenerateCalendarTokenHandler: %v", err)
+		RespondSimpleError(w, ErrInternalServer, "Failed to create the calendar token", http.StatusInternalServerError)
+		return
+	}
+	respondCalendarToken(w, r, token)
+}
+
+// importFolder reads the folder parameter of an import, checking that the
+// folder is writable. Responds 403/404 otherwise.
+func importFolder(w http.ResponseWriter, r *http.Request, repo *dblayer.DBRepository) (string, bool) {
+	return writableFolder(w, repo, normalizeObjectID(r.URL.Query().Get("folder")))
+}
+
+// writableFolder checks that the folder of an import, when given, is
+// writable. Responds 403/404 otherwise.
+func writableFolder(w http.ResponseWriter, repo *dblayer.DBRepository, folderID string) (string, bool) {
+	if folderID == "" {
+		return "", true
+	}
+	folder := repo.ObjectByID(folderID, true)
+	if folder == nil {
+		RespondSimpleError(w, ErrObjectNotFound, "Folder not found", http.StatusNotFound)
+		return "", false
+	}
+	if !repo.CheckWritePermission(folder) {
+		RespondSimpleError(w, ErrForbidden, "You don't have permission to write in this folder", http.StatusForbidden)
+		return "", false
+	}
+	return folderID, true
+}
+
+// readImportFile reads the file of an import: the request body, or the "file"
+// field of a multipart form. Responds 400 when it cannot be read.
+func readImportFile(w http.ResponseWriter, r *http.Request, maxSize int64) ([]byte, bool) {
+	var reader io.Reader = http.MaxBytesReader(w, r.Body, maxSize)
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		if err := r.ParseMultipartForm(maxSize); err != nil {
+			RespondSimpleError(w, ErrInvalidRequest, "Invalid multipart form", http.StatusBadRequest)
+			return nil, false
+		}
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			RespondError(w, ErrMissingField, "Field is required", map[string]string{"field": "file"}, http.StatusBadRequest)
+			return nil, false
+		}
+		defer file.Close()
+		reader = io.LimitReader(file, maxSize)
+	}
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		RespondSimpleError(w, ErrInvalidRequest, "Failed to read the file", http.StatusBadRequest)
+		return nil, false
+	}
+	return data, true
+}
+
+// ImportCalendarHandler godoc
+// @Summary Import an iCalendar file
+// @Description Creates or updates events from an iCalendar file, matching them by UID. New events are created in the folder.
+// @Description The file is the request body (text/calendar) or the "file" field of a multipart form.
+// @Tags events
+// @Accept text/calendar
+// @Accept multipart/form-data
+// @Produce json
+// @Param folder query string false "Folder of the new events"
+// @Param tz query string false "IANA timezone of the stored event times (default: server timezone)"
+// @Param file formData file false "iCalendar file"
+// @Success 200 {object} CalendarImportResponse "Import summary"
+// @Failure 400 {object} ErrorResponse "Invalid iCalendar file"
+// @Failure 403 {object} ErrorResponse "Folder not writable"
+// @Security BearerAuth
+// @Router /calendar/import [post]
+func ImportCalendarHandler(w http.ResponseWriter, r *http.Request) {
+	repo, ok := authenticatedRepository(w, r)
+	if !ok {
+		return
+	}
+	loc, ok := calendarLocation(w, r)
+	if !ok {
+		return
+	}
+	folderID, ok := importFolder(w, r, repo)
+	if !ok {
+		return
+	}
+	data, ok := readImportFile(w, r, maxICalendarSize)
+	if !ok {
+		return
+	}
+
+	result, err := repo.ImportICalendar(string(data), folderID, loc)
+	if err != nil {
+		RespondSimpleError(w, ErrInvalidRequest, "Invalid iCalendar file: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(CalendarImportResponse{
+		Success: true,
+		Result:  result,
+	})
+}
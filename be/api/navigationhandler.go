@@ -404,14 +404,26 @@ func GetCountriesHandler(w http.ResponseWriter, r *http.Request) {
 
 // NavigationSearchHandler godoc
 //
-//	@Summary searches navigation objects by name pattern
-//	@Description Searches navigation objects whose name or description matches the given pattern
+//	@Summary full-text search of navigation objects
+//	@Description Searches navigation objects whose name, description or HTML body contains all the words of the query.
+//	@Description Words are matched by stem (en, it, de, fr) ignoring accents, results are ordered by relevance and carry a score.
+//	@Description When the full-text index has no match, falls back to a substring search on name and description.
+//	@Description The facets (classname, language, owner, mime family, year, camera, year of capture) count the readable results; the facet_* parameters narrow them.
+//	@Description Each result has a highlighted_name and snippets of the description and body, HTML escaped, with the matched words wrapped in <mark> tags.
 //	@Tags navigation
 //	@Produce json
 //	@Param token header string false "Temporary JWT token for access"
-//	@Param name query string true "Name pattern to search for (at least 2 characters)"
-//	@Param orderBy query string false "Field to order results by (default: name)"
-//	@Success 200 {object} map[string]interface{} "List of matching objects"
+//	@Param name query string false "Name pattern to search for (at least 2 characters, required if no tags)"
+//	@Param orderBy query string false "Field to order results by when not ordered by relevance (default: name)"
+//	@Param tags query string false "Comma separated list of tags, objects must have all of them"
+//	@Param facet_classname query string false "Comma separated list of classnames to narrow the results"
+//	@Param facet_language query string false "Comma separated list of languages to narrow the results"
+//	@Param facet_owner query string false "Comma separated list of owner IDs to narrow the results"
+//	@Param facet_mime query string false "Comma separated list of MIME type families (e.g. image) to narrow the results"
+//	@Param facet_year query string false "Comma separated list of years of last modification to narrow the results"
+//	@Param facet_camera query string false "Comma separated list of cameras of the images to narrow the results"
+//	@Param facet_taken query string false "Comma separated list of years of capture of the images to narrow the results"
+//	@Success 200 {object} map[string]interface{} "List of matching objects and facet counts"
 //	@Failure 400 {object} ErrorResponse "Invalid request"
 //	@Router /nav/search [get]
 func NavigationSearchHandler(w http.ResponseWriter, r *http.Request) {
@@ -437,7 +449,9 @@ func NavigationSearchHandler(w http.ResponseWriter, r *http.Request) {
 
 	// classname := "DBObject"
 	namePattern := strings.TrimSpace(r.URL.Query().Get("name"))
-	if namePattern == "" || len(namePattern) < 2 {
+	tags := ParseTagsParam(r.URL.Query().Get("tags"))
+	// Without a pattern the tags alone are enough
+	if (namePattern != "" || len(tags) == 0) && len(namePattern) < 2 {
 		RespondSimpleError(w, ErrInvalidRequest, "Name pattern must be at least 2 characters", http.StatusBadRequest)
 		return
 	}
@@ -449,19 +463,46 @@ func NavigationSearchHandler(w http.ResponseWriter, r *http.Request) {
 		orderBy = "name"
 	}
 
-	results := repo.SearchByNameAndDescription(namePattern, orderBy, true)
+	var results []dblayer.DBEntityInterface
+	if namePattern == "" {
+		// Only tags
+		results = repo.SearchByTags(tags, orderBy, true)
+	} else {
+		// Full-text search, ordered by relevance
+		results = repo.SearchFullText(namePattern, true)
+		if len(results) == 0 {
+			// Nothing in the index (e.g. stopwords only or a partial word): plain substring search
+			results = repo.SearchByNameAndDescription(namePattern, orderBy, true)
+		}
+		results = repo.FilterByTags(results, tags)
+	}
 
-	var resultList []map[string]interface{}
+	// Query words used for highlighting
+	queryWords := dblayer.ParseFullTextQuery(namePattern)
+
+	var visible []dblayer.DBEntityInterface
+	var scores []interface{}
 	for i := 0; i < len(results); i++ {
 		entity := results[i]
-		if entity.HasMetadata("classname") && entity.GetMetadata("classname") == "DBFile" {
-			// read the full object to get file metadata, so we can display an image preview
+		classname, _ := entity.GetMetadata("classname").(string)
+		// read the full object to get file metadata, so we can display an image preview,
+		// the HTML body of pages and news for the snippets and the fields used by the facets
+		needsFullObject := classname == "DBFile" || needsFullObjectForFacets(repo, entity)
+		if len(queryWords) > 0 {
+			if instance := repo.GetInstanceByClassName(classname); instance != nil && instance.GetColumnType("html") != "" {
+				needsFullObject = true
+			}
+		}
+		if needsFullObject {
 			entity = repo.FullObjectById(entity.GetValue("id").(string), true)
 			if entity == nil {
 				// It has been soft deleted
 				log.Printf("NavigationSearchHandler: It has been soft deleted ID=%s", results[i].GetValue("id").(string))
 				continue
 			}
+			if !entity.HasMetadata("classname") {
+				entity.SetMetadata("classname", classname)
+			}
 		}
 
 		// Check read permission
@@ -469,6 +510,19 @@ func NavigationSearchHandler(w http.ResponseWriter, r *http.Request) {
 			log.Printf("NavigationSearchHandler: No read permission for object ID=%s", entity.GetValue("id").(string))
 			continue
 		}
+		visible = append(visible, entity)
+		scores = append(scores, results[i].GetMetadata("score"))
+	}
+
+	// Facets are computed on the readable objects, then the selected ones narrow the results
+	keep, facets := ApplyFacets(visible, ParseFacetFilters(r))
+	labelOwnerFacet(repo, facets)
+
+	var resultList []map[string]interface{}
+	for i, entity := range visible {
+		if !keep[i] {
+			continue
+		}
 
 		resultMap := make(map[string]interface{})
 		resultMap["id"] = entity.GetValue("id")
@@ -478,11 +532,22 @@ func NavigationSearchHandler(w http.ResponseWriter, r *http.Request) {
 		}
 
 		resultMap["classname"] = entity.GetMetadata("classname")
+		if scores[i] != nil {
+			resultMap["score"] = scores[i]
+		}
 
 		// Include mime type for DBFile objects (useful for filtering images)
 		if mime := entity.GetValue("mime"); mime != nil {
 			resultMap["mime"] = mime
 		}
+
+		// Highlighted name and snippets of description and body
+		if len(queryWords) > 0 {
+			if name, ok := entity.GetValue("name").(string); ok {
+				resultMap["highlighted_name"], _ = dblayer.HighlightText(name, queryWords)
+			}
+			resultMap["snippets"] = dblayer.BuildSearchSnippets(entity, queryWords)
+		}
 		// log.Print("SearchObjectsHandler: resultMap=", resultMap)
 
 		resultList = append(resultList, resultMap)
@@ -493,5 +558,6 @@ func NavigationSearchHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"success": true,
 		"objects": resultList,
+		"facets":  facets,
 	})
 }
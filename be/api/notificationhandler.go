@@ -0,0 +1,242 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"rprj/be/dblayer"
+
+	"github.com/gorilla/mux"
+)
+
+// NotificationsResponse godoc
+// @Description Response structure with the in-app notifications of the user
+type NotificationsResponse struct {
+	Success       bool                     `json:"success"`
+	Notifications []map[string]interface{} `json:"notifications"`
+}
+
+// NotificationResponse godoc
+// @Description Response structure with a notification
+type NotificationResponse struct {
+	Success      bool                   `json:"success"`
+	Notification map[string]interface{} `json:"notification"`
+}
+
+// NotificationChannelInfo godoc
+// @Description A reminder channel with the setting of the user and the resolved destination
+type NotificationChannelInfo struct {
+	Channel string `json:"channel"`
+	Address string `json:"address"` // as set by the user, empty for the default
+	Enabled bool   `json:"enabled"`
+	Target  string `json:"target"` // where the reminders are delivered (the user id for in_app), empty when the user cannot be reached
+}
+
+// NotificationChannelsResponse godoc
+// @Description Response structure with the reminder channels of the user
+type NotificationChannelsResponse struct {
+	Success  bool                      `json:"success"`
+	Channels []NotificationChannelInfo `json:"channels"`
+}
+
+// NotificationChannelRequest godoc
+// @Description Request structure to configure a reminder channel
+type NotificationChannelRequest struct {
+	Address string `json:"address"`
+	Enabled bool   `json:"enabled"`
+}
+
+// GetNotificationsHandler godoc
+// @Summary List notifications
+// @Description Returns the in-app notifications of the current user (e.g. event reminders), newest first
+// @Tags notifications
+// @Produce json
+// @Param unread query bool false "Only unread notifications"
+// @Param limit query int false "Maximum number of notifications (default 50)"
+// @Success 200 {object} NotificationsResponse "Notifications"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Security BearerAuth
+// @Router /notifications [get]
+func GetNotificationsHandler(w http.ResponseWriter, r *http.Request) {
+	repo, ok := authenticatedRepository(w, r)
+	if !ok {
+		return
+	}
+	unread, _ := strconv.ParseBool(r.URL.Query().Get("unread"))
+	limit := 50
+	if value := r.URL.Query().Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			RespondError(w, ErrInvalidRequest, "Invalid limit", map[string]string{"field": "limit"}, http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	notifications, err := repo.GetNotifications(unread, limit)
+	if err != nil {
+		log.Printf("GetNotificationsHandler: %v", err)
+		RespondSimpleError(w, ErrInternalServer, "Failed to read notifications", http.StatusInternalServerError)
+		return
+	}
+	ret := make([]map[string]interface{}, 0, len(notifications))
+	for _, notification := range notifications {
+		ret = append(ret, notification.GetAllValues())
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(NotificationsResponse{
+		Success:       true,
+		Notifications: ret,
+	})
+}
+
+// MarkNotificationReadHandler godoc
+// @Summary Mark a notification as read
+// @Tags notifications
+// @Produce json
+// @Param id path string true "Notification ID"
+// @Success 200 {object} NotificationResponse "Notification"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Failure 404 {object} ErrorResponse "Notification not found"
+// @Security BearerAuth
+// @Router /notifications/{id}/read [post]
+func MarkNotificationReadHandler(w http.ResponseWriter, r *http.Request) {
+	repo, ok := authenticatedRepository(w, r)
+	if !ok {
+		return
+	}
+	notification, err := repo.MarkNotificationRead(mux.Vars(r)["id"])
+	if errors.Is(err, dblayer.ErrNotificationNotFound) {
+		RespondSimpleError(w, ErrObjectNotFound, "Notification not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("MarkNotificationReadHandler: %v", err)
+		RespondSimpleError(w, ErrInternalServer, "Failed to update the notification", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(NotificationResponse{
+		Success:      true,
+		Notification: notification.GetAllValues(),
+	})
+}
+
+// MarkAllNotificationsReadHandler godoc
+// @Summary Mark all notifications as read
+// @Tags notifications
+// @Produce json
+// @Success 200 {object} NotificationsResponse "Empty list"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Security BearerAuth
+// @Router /notifications/read [post]
+func MarkAllNotificationsReadHandler(w http.ResponseWriter, r *http.Request) {
+	repo, ok := authenticatedRepository(w, r)
+	if !ok {
+		return
+	}
+	if err := repo.MarkAllNotificationsRead(); err != nil {
+		log.Printf("MarkAllNotificationsReadHandler: %v", err)
+		RespondSimpleError(w, ErrInternalServer, "Failed to update the notifications", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(NotificationsResponse{
+		Success:       true,
+		Notifications: []map[string]interface{}{},
+	})
+}
+
+// writeNotificationChannels sends the registered channels with the settings of the current user
+func writeNotificationChannels(w http.ResponseWriter, repo *dblayer.DBRepository) {
+	userID := repo.DbContext.UserID
+	user := repo.GetEntityByID("users", userID)
+	if user == nil {
+		RespondSimpleError(w, ErrUserNotFound, "User not found", http.StatusNotFound)
+		return
+	}
+	settings := repo.GetNotificationChannels(userID)
+
+	reminderChannelsMutex.Lock()
+	channels := append([]ReminderChannel{}, reminderChannels...)
+	reminderChannelsMutex.Unlock()
+
+	infos := make([]NotificationChannelInfo, 0, len(channels))
+	for _, channel := range channels {
+		setting := settings[channel.Name()]
+		address, enabled := settingAddress(setting)
+		info := NotificationChannelInfo{
+			Channel: channel.Name(),
+			Address: address,
+			Enabled: enabled,
+			Target:  channel.Address(user, setting),
+		}
+		infos = append(infos, info)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(NotificationChannelsResponse{
+		Success:  true,
+		Channels: infos,
+	})
+}
+
+// GetNotificationChannelsHandler godoc
+// @Summary List reminder channels
+// @Description Returns the channels the event reminders can be delivered on (in_app, email, telegram, as configured on the server) with the settings of the current user
+// @Tags notifications
+// @Produce json
+// @Success 200 {object} NotificationChannelsResponse "Channels"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Security BearerAuth
+// @Router /notifications/channels [get]
+func GetNotificationChannelsHandler(w http.ResponseWriter, r *http.Request) {
+	repo, ok := authenticatedRepository(w, r)
+	if !ok {
+		return
+	}
+	writeNotificationChannels(w, repo)
+}
+
+// SetNotificationChannelHandler godoc
+// @Summary Configure a reminder channel
+// @Description Enables or disables a channel for the current user and sets its destination: an email address, or the Telegram chat id. An empty address uses the default (the email of the user).
+// @Tags notifications
+// @Accept json
+// @Produce json
+// @Param channel path string true "Channel name"
+// @Param setting body NotificationChannelRequest true "Channel setting"
+// @Success 200 {object} NotificationChannelsResponse "Channels"
+// @Failure 400 {object} ErrorResponse "Invalid request"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Failure 404 {object} ErrorResponse "Unknown channel"
+// @Security BearerAuth
+// @Router /notifications/channels/{channel} [put]
+func SetNotificationChannelHandler(w http.ResponseWriter, r *http.Request) {
+	repo, ok := authenticatedRepository(w, r)
+	if !ok {
+		return
+	}
+	channel := mux.Vars(r)["channel"]
+	if !slices.Contains(ReminderChannelNames(), channel) {
+		RespondError(w, ErrObjectNotFound, "Unknown channel", map[string]string{"channel": channel}, http.StatusNotFound)
+		return
+	}
+	var request NotificationChannelRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		RespondSimpleError(w, ErrInvalidRequest, "Invalid request format", http.StatusBadRequest)
+		return
+	}
+	if _, err := repo.SetNotificationChannel(channel, request.Address, request.Enabled); err != nil {
+		log.Printf("SetNotificationChannelHandler: %v", err)
+		RespondSimpleError(w, ErrInternalServer, "Failed to save the channel", http.StatusInternalServerError)
+		return
+	}
+	writeNotificationChannels(w, repo)
+}
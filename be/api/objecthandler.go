@@ -3,10 +3,8 @@ package api
 import (
 	"encoding/json"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
-	"os"
 	"path/filepath"
 	"slices"
 	"sort"
@@ -53,6 +51,7 @@ type ObjectResponse struct {
 type ObjectsSearchResponse struct {
 	Success bool                     `json:"success"`
 	Objects []map[string]interface{} `json:"objects"`
+	Facets  map[string][]FacetValue  `json:"facets,omitempty"`
 }
 
 // CreateObjectHandler godoc
@@ -65,6 +64,7 @@ type ObjectsSearchResponse struct {
 // @Success 201 {object} ObjectResponse "Created object data"
 // @Failure 400 {object} ErrorResponse "Invalid request"
 // @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Failure 413 {object} ErrorResponse "Storage quota exceeded"
 // @Failure 500 {object} ErrorResponse "Internal server error"
 // @Security BearerAuth
 // @Router /objects [post]
@@ -113,33 +113,19 @@ func CreateObjectHandler(w http.ResponseWriter, r *http.Request) {
 		if err == nil {
 			defer file.Close()
 
-			// Create files directory if it doesn't exist
-			filesDir := filepath.Join(dbFiles_root_directory, dbFiles_dest_directory)
-			log.Print("CreateObjectHandler: filesDir=", filesDir)
-			if err := os.MkdirAll(filesDir, 0755); err != nil {
-				log.Printf("CreateObjectHandler: Failed to create files directory: %v", err)
-				RespondSimpleError(w, ErrInternalServer, "Failed to create storage directory", http.StatusInternalServerError)
+			// The file counts for the user and for the group of its folder
+			folderID, _ := requestData["father_id"].(string)
+			if !checkStorageQuota(w, repo, dbContext.UserID, uploadGroupID(repo, normalizeObjectID(folderID)), header.Size) {
 				return
 			}
 
 			// Generate filename with r_{id}_ prefix
 			baseFilename := filepath.Base(header.Filename)
 			savedFilename := baseFilename //"r_" + newID + "_" + baseFilename
-			filePath := filepath.Join(filesDir, savedFilename)
 
-			// Create destination file
-			dst, err := os.Create(filePath)
-			if err != nil {
-				log.Printf("CreateObjectHandler: Failed to create file %s: %v", filePath, err)
-				RespondSimpleError(w, ErrInternalServer, "Failed to save file", http.StatusInternalServerError)
-				return
-			}
-			defer dst.Close()
-
-			// Copy uploaded file to destination
-			if _, err := io.Copy(dst, file); err != nil {
-				log.Printf("CreateObjectHandler: Failed to copy file data: %v", err)
-				os.Remove(filePath) // Clean up partial file
+			// Store the upload at the root of the file storage, the DBFile hooks move it to its place
+			if err := dblayer.FileStorage.Put(savedFilename, file, header.Size); err != nil {
+				log.Printf("CreateObjectHandler: Failed to store file %s: %v", savedFilename, err)
 				RespondSimpleError(w, ErrInternalServer, "Failed to save file data", http.StatusInternalServerError)
 				return
 			}
@@ -218,6 +204,9 @@ func CreateObjectHandler(w http.ResponseWriter, r *http.Request) {
 	created, err := repo.CreateObject(tableName, requestData, metadataValues)
 	if err != nil {
 		log.Printf("CreateObjectHandler: Failed to create object: %v", err)
+		if respondValidationError(w, err) || respondScanError(w, err) || respondQuotaError(w, err) {
+			return
+		}
 		RespondSimpleError(w, ErrInternalServer, "Failed to create object: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -309,6 +298,7 @@ func GetObjectHandler(w http.ResponseWriter, r *http.Request) {
 // @Failure 401 {object} ErrorResponse "Unauthorized"
 // @Failure 403 {object} ErrorResponse "Forbidden"
 // @Failure 404 {object} ErrorResponse "Object not found"
+// @Failure 413 {object} ErrorResponse "Storage quota exceeded"
 // @Failure 500 {object} ErrorResponse "Internal server error"
 // @Security BearerAuth
 // @Router /objects/{id} [put]
@@ -397,33 +387,22 @@ func UpdateObjectHandler(w http.ResponseWriter, r *http.Request) {
 		if err == nil {
 			defer file.Close()
 
-			// Create files directory if it doesn't exist
-			filesDir := filepath.Join(dbFiles_root_directory, dbFiles_dest_directory)
-			log.Print("UpdateObjectHandler: filesDir=", filesDir)
-			if err := os.MkdirAll(filesDir, 0755); err != nil {
-				log.Printf("UpdateObjectHandler: Failed to create files directory: %v", err)
-				RespondSimpleError(w, ErrInternalServer, "Failed to create storage directory", http.StatusInternalServerError)
-				return
+			// The new content replaces the current one in the usage of the owner and of the group
+			if dbFile, ok := fullObj.(*dblayer.DBFile); ok {
+				owner, _ := dbFile.GetValue("owner").(string)
+				groupID, _ := dbFile.GetValue("group_id").(string)
+				if !checkStorageQuota(w, repo, owner, groupID, header.Size-dbFile.Size()) {
+					return
+				}
 			}
 
 			// Generate filename with r_{id}_ prefix
 			baseFilename := filepath.Base(header.Filename)
 			savedFilename := "r_" + objectID + "_" + baseFilename
-			filePath := filepath.Join(filesDir, savedFilename)
 
-			// Create destination file
-			dst, err := os.Create(filePath)
-			if err != nil {
-				log.Printf("UpdateObjectHandler: Failed to create file %s: %v", filePath, err)
-				RespondSimpleError(w, ErrInternalServer, "Failed to save file", http.StatusInternalServerError)
-				return
-			}
-			defer dst.Close()
-
-			// Copy uploaded file to destination
-			if _, err := io.Copy(dst, file); err != nil {
-				log.Printf("UpdateObjectHandler: Failed to copy file data: %v", err)
-				os.Remove(filePath) // Clean up partial file
+			// Store the upload at the root of the file storage, the DBFile hooks move it to its place
+			if err := dblayer.FileStorage.Put(savedFilename, file, header.Size); err != nil {
+				log.Printf("UpdateObjectHandler: Failed to store file %s: %v", savedFilename, err)
 				RespondSimpleError(w, ErrInternalServer, "Failed to save file data", http.StatusInternalServerError)
 				return
 			}
@@ -432,7 +411,6 @@ func UpdateObjectHandler(w http.ResponseWriter, r *http.Request) {
 			updateValues["filename"] = savedFilename
 			updateValues["mime"] = header.Header.Get("Content-Type")
 			// updateValues["path"] = filePath
-			metadataValues["path"] = filePath
 
 			log.Printf("UpdateObjectHandler: File saved successfully: %s (%s)", savedFilename, header.Header.Get("Content-Type"))
 		}
@@ -470,6 +448,9 @@ func UpdateObjectHandler(w http.ResponseWriter, r *http.Request) {
 	updated, err := repo.UpdateObject(tableName, objectID, updateValues, metadataValues)
 	if err != nil {
 		log.Printf("UpdateObjectHandler: Failed to update object: %v", err)
+		if respondValidationError(w, err) || respondScanError(w, err) || respondQuotaError(w, err) {
+			return
+		}
 		RespondSimpleError(w, ErrInternalServer, "Failed to update object: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -702,11 +683,85 @@ func GetCreatableTypesHandler(w http.ResponseWriter, r *http.Request) {
 // @Param offset query int false "Offset for pagination"
 // @Param type query string false "Filter type (e.g., 'link' for linkable objects)"
 // @Param includeDeleted query string false "Include deleted objects"
+// @Param tags query string false "Comma separated list of tags, objects must have all of them"
+// @Param facet_classname query string false "Comma separated list of classnames to narrow the results"
+// @Param facet_language query string false "Comma separated list of languages to narrow the results"
+// @Param facet_owner query string false "Comma separated list of owner IDs to narrow the results"
+// @Param facet_mime query string false "Comma separated list of MIME type families (e.g. image) to narrow the results"
+// @Param facet_year query string false "Comma separated list of years of last modification to narrow the results"
+// @Param facet_camera query string false "Comma separated list of cameras of the images to narrow the results"
+// @Param facet_taken query string false "Comma separated list of years of capture of the images to narrow the results"
+// @Param _from_<column> query string false "Lower bound of a column, included (e.g. _from_taken_date=2024-07-01); not with classname DBObject"
+// @Param _to_<column> query string false "Upper bound of a column, included (e.g. _to_taken_date=2024-07-31); not with classname DBObject"
 // @Success 200 {object} ObjectsSearchResponse "List of matching objects"
 // @Failure 400 {object} ErrorResponse "Invalid request"
 // @Failure 500 {object} ErrorResponse "Internal error"
 // @Router /objects/search [get]
 func SearchObjectsHandler(w http.ResponseWriter, r *http.Request) {
+	search, ok := searchObjects(w, r)
+	if !ok {
+		return
+	}
+
+	returnList := []map[string]interface{}{}
+	for _, entity := range search.page() {
+		resultMap := make(map[string]interface{})
+		resultMap["id"] = entity.GetValue("id")
+		resultMap["name"] = entity.GetValue("name")
+		if desc := entity.GetValue("description"); desc != nil {
+			resultMap["description"] = desc
+		}
+
+		if search.searchJson != "" {
+			// Include all fields in searchJson mode
+			for key, val := range entity.GetAllValues() {
+				resultMap[key] = val
+			}
+		}
+
+		resultMap["classname"] = entity.GetMetadata("classname")
+
+		// Include mime type for DBFile objects (useful for filtering images)
+		if mime := entity.GetValue("mime"); mime != nil {
+			resultMap["mime"] = mime
+		}
+
+		returnList = append(returnList, resultMap)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(ObjectsSearchResponse{
+		Success: true,
+		Objects: returnList,
+		Facets:  search.facets,
+	})
+}
+
+// objectSearch is the outcome of the query of SearchObjectsHandler
+type objectSearch struct {
+	repo       *dblayer.DBRepository
+	classname  string
+	searchJson string
+	// Readable objects matching the query and the facets, before offset and limit
+	results    []dblayer.DBEntityInterface
+	offset     int
+	maxResults int
+	facets     map[string][]FacetValue
+}
+
+// page returns the results in the offset and limit of the query
+func (search *objectSearch) page() []dblayer.DBEntityInterface {
+	page := []dblayer.DBEntityInterface{}
+	for i := search.offset; len(page) < search.maxResults && i < len(search.results); i++ {
+		page = append(page, search.results[i])
+	}
+	return page
+}
+
+// searchObjects runs the query of SearchObjectsHandler. Responds with an
+// error and returns false when the query is invalid or fails.
+func searchObjects(w http.ResponseWriter, r *http.Request) (*objectSearch, bool) {
 	claims, err := GetClaimsFromRequest(r)
 
 	var dbContext dblayer.DBContext
@@ -730,7 +785,7 @@ func SearchObjectsHandler(w http.ResponseWriter, r *http.Request) {
 	classname := r.URL.Query().Get("classname")
 	if classname == "" {
 		RespondSimpleError(w, ErrInvalidRequest, "Missing classname parameter", http.StatusBadRequest)
-		return
+		return nil, false
 	}
 
 	namePattern := strings.TrimSpace(r.URL.Query().Get("name"))
@@ -770,12 +825,16 @@ func SearchObjectsHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	// type
 	searchType := r.URL.Query().Get("type") // optional, "link" to filter only linkable objects i.e. objects I can write
+	// tags
+	tags := ParseTagsParam(r.URL.Query().Get("tags"))
+	// ranges
+	ranges := ParseRangeParams(r.URL.Query())
 
 	// Get instance for the requested classname
 	searchInstance := repo.GetInstanceByClassName(classname)
 	if searchInstance == nil {
 		RespondSimpleError(w, ErrInvalidRequest, "Unknown classname: "+classname, http.StatusBadRequest)
-		return
+		return nil, false
 	}
 	// searchParams has key "$or" ?
 	if _, ok := searchParams["$or"]; ok {
@@ -789,6 +848,10 @@ func SearchObjectsHandler(w http.ResponseWriter, r *http.Request) {
 	// 	return
 	// }
 	searchInstanceDescription := repo.GetInstanceByClassName(classname)
+	for metadata, bounds := range ranges {
+		searchInstance.SetMetadata(metadata, bounds)
+		searchInstanceDescription.SetMetadata(metadata, bounds)
+	}
 
 	// Set search criteria
 	if searchJson == "" {
@@ -814,7 +877,7 @@ func SearchObjectsHandler(w http.ResponseWriter, r *http.Request) {
 		if err != nil {
 			log.Printf("SearchObjectsHandler: Search failed: %v", err)
 			RespondSimpleError(w, ErrInternalServer, "Search failed: "+err.Error(), http.StatusInternalServerError)
-			return
+			return nil, false
 		}
 		var resultsDescription []dblayer.DBEntityInterface
 		if searchJson == "" {
@@ -823,7 +886,7 @@ func SearchObjectsHandler(w http.ResponseWriter, r *http.Request) {
 		if err != nil {
 			log.Printf("SearchObjectsHandler: Search failed: %v", err)
 			RespondSimpleError(w, ErrInternalServer, "Search failed: "+err.Error(), http.StatusInternalServerError)
-			return
+			return nil, false
 		}
 		// Merge results
 		resultsIDs := make(map[string]bool)
@@ -855,7 +918,7 @@ func SearchObjectsHandler(w http.ResponseWriter, r *http.Request) {
 		if err != nil {
 			log.Printf("SearchObjectsHandler: Search failed: %v", err)
 			RespondSimpleError(w, ErrInternalServer, "Search failed: "+err.Error(), http.StatusInternalServerError)
-			return
+			return nil, false
 		}
 		log.Print("SearchObjectsHandler: Search results=", len(results))
 		// IF !includeDeleted, filter out deleted objects
@@ -878,6 +941,12 @@ func SearchObjectsHandler(w http.ResponseWriter, r *http.Request) {
 		log.Print("SearchObjectsHandler: SearchByNameAndDescription results=", len(results))
 	}
 
+	// Filter by tags, if any
+	if len(tags) > 0 {
+		results = repo.FilterByTags(results, tags)
+		log.Print("SearchObjectsHandler: tags=", tags, " results=", len(results))
+	}
+
 	// Apply limit if specified
 	maxResults := len(results)
 	log.Print("SearchObjectsHandler: limit=", limit)
@@ -893,17 +962,22 @@ func SearchObjectsHandler(w http.ResponseWriter, r *http.Request) {
 	log.Print("SearchObjectsHandler: results=", len(results))
 	log.Print("SearchObjectsHandler: classname=", classname)
 	// Convert results to map array
-	var resultList []map[string]interface{}
+	var visible []dblayer.DBEntityInterface
 	for i := 0; i < len(results); i++ {
 		entity := results[i]
-		if entity.HasMetadata("classname") && entity.GetMetadata("classname") == "DBFile" {
-			// read the full object to get file metadata, so we can display an image preview
+		if (entity.HasMetadata("classname") && entity.GetMetadata("classname") == "DBFile") || needsFullObjectForFacets(repo, entity) {
+			// read the full object to get file metadata, so we can display an image preview,
+			// and the fields used by the facets
+			classnameMeta := entity.GetMetadata("classname")
 			entity = repo.FullObjectById(entity.GetValue("id").(string), !includeDeleted)
 			// TODO verify that this should be redundant with the includeDeleted above
 			if entity == nil {
 				log.Printf("SearchObjectsHandler: It has been soft deleted ID=%s", results[i].GetValue("id").(string))
 				continue
 			}
+			if !entity.HasMetadata("classname") {
+				entity.SetMetadata("classname", classnameMeta)
+			}
 		}
 		// IF searched classname is != DBObject, then filter other classnames
 		// TODO: this should not happen, verify and remove if confirmed
@@ -923,57 +997,55 @@ func SearchObjectsHandler(w http.ResponseWriter, r *http.Request) {
 			log.Printf("SearchObjectsHandler: No write permission for object ID=%s (type=link)", entity.GetValue("id").(string))
 			continue
 		}
+		visible = append(visible, entity)
+	}
 
-		resultMap := make(map[string]interface{})
-		resultMap["id"] = entity.GetValue("id")
-		resultMap["name"] = entity.GetValue("name")
-		if desc := entity.GetValue("description"); desc != nil {
-			resultMap["description"] = desc
-		}
-
-		if searchJson != "" {
-			// Include all fields in searchJson mode
-			for key, val := range entity.GetAllValues() {
-				resultMap[key] = val
-			}
-		}
-
-		resultMap["classname"] = entity.GetMetadata("classname")
-
-		// Include mime type for DBFile objects (useful for filtering images)
-		if mime := entity.GetValue("mime"); mime != nil {
-			resultMap["mime"] = mime
-		}
+	// Facets are computed on the readable objects, then the selected ones narrow the results
+	keep, facets := ApplyFacets(visible, ParseFacetFilters(r))
+	labelOwnerFacet(repo, facets)
 
-		resultList = append(resultList, resultMap)
+	search := &objectSearch{
+		repo:       repo,
+		classname:  classname,
+		searchJson: searchJson,
+		offset:     offset,
+		maxResults: maxResults,
+		facets:     facets,
 	}
-
-	// Apply offset and limit to resultList
-	returnList := []map[string]interface{}{}
-	for i := offset; len(returnList) < maxResults && i < len(resultList); i++ {
-		returnList = append(returnList, resultList[i])
+	for i, entity := range visible {
+		if keep[i] {
+			search.results = append(search.results, entity)
+		}
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(ObjectsSearchResponse{
-		Success: true,
-		Objects: returnList,
-	})
+	return search, true
 }
 
 // DownloadFileHandler godoc
 // @Summary Download file
-// @Description Downloads the file content for a given DBFile object ID
+// @Description Downloads the file content for a given DBFile object ID. The ETag is the checksum of the file,
+// @Description Last-Modified its last_modify_date; the files readable by everybody are publicly cacheable.
+// @Description With w, h, fit or format an image is served as a rendition: resized (never enlarged), turned
+// @Description upright from its EXIF orientation and converted, cached on the server.
 // @Tags files
 // @Produce octet-stream
 // @Param token header string false "Temporary JWT token for access"
 // @Param id path string true "File ID"
 // @Param preview query string false "Set to 'yes' or 'true' to get thumbnail preview if available"
+// @Param w query int false "Width of a rendition of an image, at most 2048"
+// @Param h query int false "Height of a rendition of an image, at most 2048"
+// @Param fit query string false "Fit of a rendition in w x h: contain (default) or cover (cropped)"
+// @Param format query string false "Format of a rendition: jpeg, png or webp (default: from the image)"
+// @Param Range header string false "A single byte range (bytes=start-end), honored with If-Range"
+// @Param If-None-Match header string false "ETag of the cached copy (the checksum of the file)"
 // @Success 200 {file} file "File content"
+// @Success 206 {file} file "Partial content"
+// @Success 304 "Not modified"
+// @Failure 400 {object} ErrorResponse "Invalid rendition, or not an image"
 // @Failure 401 {object} ErrorResponse "Unauthorized"
 // @Failure 404 {object} ErrorResponse "File not found"
+// @Failure 416 {object} ErrorResponse "Range not satisfiable"
 // @Failure 500 {object} ErrorResponse "Internal error"
+// @Failure 503 {object} ErrorResponse "Too many renditions in progress"
 // @Router /files/{id}/download [get]
 func DownloadFileHandler(w http.ResponseWriter, r *http.Request) {
 	claims, err := GetClaimsFromRequest(r)
@@ -1059,47 +1131,31 @@ func DownloadFileHandler(w http.ResponseWriter, r *http.Request) {
 		mime = "application/octet-stream"
 	}
 
-	filePath := dbFile.GetFullpath(nil)
-	// In future, a thumbnail could be provided also for non-image files
-	// e.g. PDF first page preview, video snapshot, etc.
-	if previewParam == "yes" || previewParam == "true" {
-		filePath = dbFile.GetThumbnailFullpath(nil)
-		// log.Print("DownloadFileHandler: thumbnail filePath=", filePath)
-	}
+	checksum, _ := dbFile.GetValue("checksum").(string)
+	lastModifyDate, _ := dbFile.GetValue("last_modify_date").(string)
+	cache := newBlobCache(checksum, "", lastModifyDate, isPublicObject(dbFile))
 
-	// Open file from disk
-	file, err := os.Open(filePath)
-	if err != nil {
-		log.Printf("DownloadFileHandler: Failed to open file %s: %v", filePath, err)
-		RespondSimpleError(w, ErrObjectNotFound, "File not found on disk", http.StatusNotFound)
-		return
-	}
-	defer file.Close()
-
-	// Get file info for size
-	fileInfo, err := file.Stat()
-	if err != nil {
-		log.Printf("DownloadFileHandler: Failed to stat file %s: %v", filePath, err)
-		RespondSimpleError(w, ErrInternalServer, "Failed to read file info", http.StatusInternalServerError)
+	// A rendition of an image
+	if rendition, requested, err := parseRendition(r.URL.Query()); requested {
+		if respondValidationError(w, err) {
+			return
+		}
+		serveRendition(w, r, dbFile, rendition, filename.(string))
 		return
 	}
 
-	// Set headers
-	w.Header().Set("Content-Type", mime.(string))
-	w.Header().Set("Content-Length", fmt.Sprintf("%d", fileInfo.Size()))
-
-	// For images, display inline; for other files, force download
-	if strings.HasPrefix(mime.(string), "image/") {
-		w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=\"%s\"", filename))
-	} else {
-		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
+	filePath := dbFile.GetBlobKey()
+	// In future, a thumbnail could be provided also for non-image files
+	// e.g. PDF first page preview, video snapshot, etc.
+	if previewParam == "yes" || previewParam == "true" {
+		filePath = dbFile.GetThumbnailBlobKey()
+		// log.Print("DownloadFileHandler: thumbnail filePath=", filePath)
+		// The thumbnails are JPEG, a variant of the same checksum
+		mime = "image/jpeg"
+		cache = newBlobCache(checksum, "thumb", lastModifyDate, isPublicObject(dbFile))
 	}
 
-	// Stream file to response
-	if _, err := io.Copy(w, file); err != nil {
-		log.Printf("DownloadFileHandler: Failed to stream file %s: %v", filePath, err)
-		return
-	}
+	serveBlob(w, r, "DownloadFileHandler", filePath, mime.(string), filename.(string), cache)
 
 	// log.Printf("DownloadFileHandler: Served file %s (%s)", filename, mime)
 }
@@ -0,0 +1,207 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"rprj/be/dblayer"
+
+	"github.com/gorilla/mux"
+)
+
+// ProjectMemberRequest associates a person, company or project to a project
+type ProjectMemberRequest struct {
+	Kind     string `json:"kind"` // people, companies or projects
+	MemberID string `json:"member_id"`
+	RoleID   string `json:"role_id"`
+}
+
+// ProjectMembersResponse is the list of members of a project
+type ProjectMembersResponse struct {
+	Success bool                    `json:"success"`
+	Members []dblayer.ProjectMember `json:"members"`
+}
+
+// GetProjectMembersHandler godoc
+// @Summary Get project members
+// @Description Returns the people, companies and projects associated to a project, with their role. Members the user cannot read are omitted.
+// @Tags projects
+// @Produce json
+// @Param id path string true "Project ID"
+// @Param kind query string false "Only members of this kind: people, companies or projects"
+// @Success 200 {object} ProjectMembersResponse "Project members"
+// @Failure 400 {object} ErrorResponse "Invalid request"
+// @Failure 403 {object} ErrorResponse "Forbidden"
+// @Failure 404 {object} ErrorResponse "Project not found"
+// @Security BearerAuth
+// @Router /projects/{id}/members [get]
+func GetProjectMembersHandler(w http.ResponseWriter, r *http.Request) {
+	repo, projectID, ok := projectHandlerLoadProject(w, r, false)
+	if !ok {
+		return
+	}
+
+	members, err := repo.GetProjectMembers(projectID, r.URL.Query().Get("kind"))
+	if err != nil {
+		RespondError(w, ErrInvalidRequest, err.Error(), map[string]string{"field": "kind"}, http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(ProjectMembersResponse{
+		Success: true,
+		Members: members,
+	})
+}
+
+// AddProjectMemberHandler godoc
+// @Summary Add a project member
+// @Description Associates a person, company or project to a project with a role. Requires write permission on the project.
+// @Tags projects
+// @Accept json
+// @Produce json
+// @Param id path string true "Project ID"
+// @Param request body ProjectMemberRequest true "Member and role"
+// @Success 200 {object} ProjectMembersResponse "Project members after the change"
+// @Failure 400 {object} ErrorResponse "Invalid request"
+// @Failure 403 {object} ErrorResponse "Forbidden"
+// @Failure 404 {object} ErrorResponse "Project not found"
+// @Security BearerAuth
+// @Router /projects/{id}/members [post]
+func AddProjectMemberHandler(w http.ResponseWriter, r *http.Request) {
+	repo, projectID, ok := projectHandlerLoadProject(w, r, true)
+	if !ok {
+		return
+	}
+
+	var request ProjectMemberRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		RespondSimpleError(w, ErrInvalidRequest, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if request.Kind == "" {
+		RespondError(w, ErrMissingField, "Field is required", map[string]string{"field": "kind"}, http.StatusBadRequest)
+		return
+	}
+	if request.MemberID == "" {
+		RespondError(w, ErrMissingField, "Field is required", map[string]string{"field": "member_id"}, http.StatusBadRequest)
+		return
+	}
+	if len(request.MemberID) == 18 {
+		request.MemberID = strings.ReplaceAll(request.MemberID, "-", "")
+	}
+	if len(request.RoleID) == 18 {
+		request.RoleID = strings.ReplaceAll(request.RoleID, "-", "")
+	}
+
+	if _, err := repo.AddProjectMember(projectID, request.Kind, request.MemberID, request.RoleID); err != nil {
+		log.Printf("AddProjectMemberHandler: Failed to add member: %v", err)
+		RespondSimpleError(w, ErrInvalidRequest, "Failed to add member: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	members, err := repo.GetProjectMembers(projectID, "")
+	if err != nil {
+		RespondSimpleError(w, ErrInternalServer, "Failed to read members", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(ProjectMembersResponse{
+		Success: true,
+		Members: members,
+	})
+}
+
+// RemoveProjectMemberHandler godoc
+// @Summary Remove a project member
+// @Description Removes a person, company or project from a project. Requires write permission on the project.
+// @Tags projects
+// @Produce json
+// @Param id path string true "Project ID"
+// @Param kind path string true "people, companies or projects"
+// @Param memberId path string true "Member ID"
+// @Param role_id query string false "Remove only this role (default: all the roles of the member)"
+// @Success 200 {object} ProjectMembersResponse "Project members after the change"
+// @Failure 400 {object} ErrorResponse "Invalid request"
+// @Failure 403 {object} ErrorResponse "Forbidden"
+// @Failure 404 {object} ErrorResponse "Project or member not found"
+// @Security BearerAuth
+// @Router /projects/{id}/members/{kind}/{memberId} [delete]
+func RemoveProjectMemberHandler(w http.ResponseWriter, r *http.Request) {
+	repo, projectID, ok := projectHandlerLoadProject(w, r, true)
+	if !ok {
+		return
+	}
+	vars := mux.Vars(r)
+	memberID := vars["memberId"]
+	if len(memberID) == 18 {
+		memberID = strings.ReplaceAll(memberID, "-", "")
+	}
+	roleID := r.URL.Query().Get("role_id")
+	if len(roleID) == 18 {
+		roleID = strings.ReplaceAll(roleID, "-", "")
+	}
+
+	removed, err := repo.RemoveProjectMember(projectID, vars["kind"], memberID, roleID)
+	if err != nil {
+		log.Printf("RemoveProjectMemberHandler: Failed to remove member: %v", err)
+		RespondSimpleError(w, ErrInvalidRequest, "Failed to remove member: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if removed == 0 {
+		RespondSimpleError(w, ErrObjectNotFound, "Member not found", http.StatusNotFound)
+		return
+	}
+
+	members, err := repo.GetProjectMembers(projectID, "")
+	if err != nil {
+		RespondSimpleError(w, ErrInternalServer, "Failed to read members", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(ProjectMembersResponse{
+		Success: true,
+		Members: members,
+	})
+}
+
+// projectHandlerLoadProject builds the repository for the caller and checks
+// that the project in the path exists and is readable (or writable)
+func projectHandlerLoadProject(w http.ResponseWriter, r *http.Request, needsWrite bool) (*dblayer.DBRepository, string, bool) {
+	claims, err := GetClaimsFromRequest(r)
+	if err != nil {
+		RespondSimpleError(w, ErrUnauthorized, "Unauthorized", http.StatusUnauthorized)
+		return nil, "", false
+	}
+	dbContext := &dblayer.DBContext{
+		UserID:   claims["user_id"],
+		GroupIDs: strings.Split(claims["groups"], ","),
+		Schema:   dblayer.DbSchema,
+	}
+	repo := dblayer.NewDBRepository(dbContext, dblayer.Factory, dblayer.DbConnection)
+	repo.Verbose = false
+
+	projectID := mux.Vars(r)["id"]
+	if len(projectID) == 18 {
+		projectID = strings.ReplaceAll(projectID, "-", "")
+	}
+	project := repo.GetEntityByID("projects", projectID)
+	if project == nil || project.(dblayer.DBObjectInterface).HasDeletedDate() {
+		RespondSimpleError(w, ErrObjectNotFound, "Project not found", http.StatusNotFound)
+		return nil, "", false
+	}
+	if needsWrite && !repo.CheckWritePermission(project) {
+		RespondSimpleError(w, ErrForbidden, "You don't have permission to edit this project", http.StatusForbidden)
+		return nil, "", false
+	}
+	if !needsWrite && !repo.CheckReadPermission(project) {
+		RespondSimpleError(w, ErrForbidden, "You don't have permission to view this project", http.StatusForbidden)
+		return nil, "", false
+	}
+	return repo, projectID, true
+}
@@ -0,0 +1,282 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"rprj/be/dblayer"
+	"rprj/be/models"
+)
+
+// reminderLookback is how late an alarm is still delivered, e.g. after a restart
+const reminderLookback = time.Hour
+
+// Reminder is the alarm of an event occurrence to deliver to a user
+type Reminder struct {
+	Alarm   dblayer.EventAlarm
+	UserID  string
+	Address string // destination on the channel: email address, Telegram chat id
+	Subject string
+	Body    string
+}
+
+// ReminderChannel delivers the reminders of the events
+type ReminderChannel interface {
+	// Name identifies the channel in the user settings and in the reminders log
+	Name() string
+	// Address returns the destination of the user on the channel, "" when the
+	// user cannot be reached. setting is the user setting of the channel, or nil.
+	Address(user dblayer.DBEntityInterface, setting dblayer.DBEntityInterface) string
+	Send(repo *dblayer.DBRepository, reminder *Reminder) error
+}
+
+var (
+	reminderChannelsMutex sync.Mutex
+	reminderChannels      = []ReminderChannel{}
+)
+
+// RegisterReminderChannel adds a channel to the dispatcher, replacing the one with the same name
+func RegisterReminderChannel(channel ReminderChannel) {
+	reminderChannelsMutex.Lock()
+	defer reminderChannelsMutex.Unlock()
+	for i, registered := range reminderChannels {
+		if registered.Name() == channel.Name() {
+			reminderChannels[i] = channel
+			return
+		}
+	}
+	reminderChannels = append(reminderChannels, channel)
+}
+
+// ReminderChannelNames returns the names of the registered channels
+func ReminderChannelNames() []string {
+	reminderChannelsMutex.Lock()
+	defer reminderChannelsMutex.Unlock()
+	names := make([]string, len(reminderChannels))
+	for i, channel := range reminderChannels {
+		names[i] = channel.Name()
+	}
+	return names
+}
+
+// settingAddress returns the address of a channel setting; ok is false when the user disabled the channel
+func settingAddress(setting dblayer.DBEntityInterface) (address string, ok bool) {
+	if setting == nil {
+		return "", true
+	}
+	if enabled := fmt.Sprint(setting.GetValue("enabled")); enabled == "0" || enabled == "false" {
+		return "", false
+	}
+	address, _ = setting.GetValue("address").(string)
+	return strings.TrimSpace(address), true
+}
+
+// InAppChannel stores the reminders as notifications, shown by the frontend
+type InAppChannel struct{}
+
+func (InAppChannel) Name() string { return "in_app" }
+
+func (InAppChannel) Address(user dblayer.DBEntityInterface, setting dblayer.DBEntityInterface) string {
+	if _, ok := settingAddress(setting); !ok {
+		return ""
+	}
+	userID, _ := user.GetValue("id").(string)
+	return userID
+}
+
+func (InAppChannel) Send(repo *dblayer.DBRepository, reminder *Reminder) error {
+	_, err := repo.CreateNotification(reminder.UserID, reminder.Subject, reminder.Body, reminder.Alarm.EventID)
+	return err
+}
+
+// SMTPChannel sends the reminders by email, to the address of the setting or of the user
+type SMTPChannel struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+}
+
+func (SMTPChannel) Name() string { return "email" }
+
+func (SMTPChannel) Address(user dblayer.DBEntityInterface, setting dblayer.DBEntityInterface) string {
+	address, ok := settingAddress(setting)
+	if !ok {
+		return ""
+	}
+	if address == "" {
+		address, _ = user.GetValue("email").(string)
+	}
+	return strings.TrimSpace(address)
+}
+
+func (c SMTPChannel) Send(repo *dblayer.DBRepository, reminder *Reminder) error {
+	port := c.Port
+	if port == 0 {
+		port = 587
+	}
+	var auth smtp.Auth
+	if c.Username != "" {
+		auth = smtp.PlainAuth("", c.Username, c.Password, c.Host)
+	}
+	return smtp.SendMail(c.Host+":"+strconv.Itoa(port), auth, c.From, []string{reminder.Address}, buildReminderEmail(c.From, reminder))
+}
+
+// buildReminderEmail returns the RFC 5322 message of a reminder
+func buildReminderEmail(from string, reminder *Reminder) []byte {
+	var sb strings.Builder
+	sb.WriteString("From: " + from + "\r\n")
+	sb.WriteString("To: " + reminder.Address + "\r\n")
+	sb.WriteString("Subject: " + mime.QEncoding.Encode("utf-8", reminder.Subject) + "\r\n")
+	sb.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	sb.WriteString("MIME-Version: 1.0\r\n")
+	sb.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	sb.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	sb.WriteString(strings.ReplaceAll(reminder.Body, "\n", "\r\n") + "\r\n")
+	return []byte(sb.String())
+}
+
+// TelegramChannel sends the reminders with the configured bot. The chat id is
+// the address of the setting or, for users logged in with Telegram without a
+// username, the id in their login.
+type TelegramChannel struct {
+	BotToken string
+	APIURL   string // default https://api.telegram.org
+}
+
+func (TelegramChannel) Name() string { return "telegram" }
+
+func (TelegramChannel) Address(user dblayer.DBEntityInterface, setting dblayer.DBEntityInterface) string {
+	address, ok := settingAddress(setting)
+	if !ok {
+		return ""
+	}
+	if address == "" {
+		login, _ := user.GetValue("login").(string)
+		address, _ = strings.CutPrefix(login, "telegram:")
+		if address == login {
+			return ""
+		}
+	}
+	return address
+}
+
+func (c TelegramChannel) Send(repo *dblayer.DBRepository, reminder *Reminder) error {
+	apiURL := c.APIURL
+	if apiURL == "" {
+		apiURL = "https://api.telegram.org"
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.PostForm(apiURL+"/bot"+c.BotToken+"/sendMessage", url.Values{
+		"chat_id": {reminder.Address},
+		"text":    {reminder.Subject + "\n" + reminder.Body},
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("telegram: %s", resp.Status)
+	}
+	return nil
+}
+
+// formatReminder returns the subject and the body of the reminder of an alarm
+func formatReminder(alarm dblayer.EventAlarm, loc *time.Location) (string, string) {
+	when := alarm.OccurrenceStart.In(loc).Format("2006-01-02 15:04")
+	if alarm.AllDay {
+		when = alarm.OccurrenceStart.In(loc).Format(time.DateOnly)
+	}
+	body := alarm.Name + "\n" + when
+	if alarm.Description != "" {
+		body += "\n\n" + alarm.Description
+	}
+	return "Reminder: " + alarm.Name, body
+}
+
+// DispatchReminders delivers the alarms due at now on every channel the owner
+// of the event can be reached on. Each reminder is claimed before the delivery,
+// so that it fires once even with several backends or overlapping runs.
+func DispatchReminders(now time.Time, loc *time.Location) {
+	repo := dblayer.NewDBRepository(&dblayer.DBContext{
+		UserID:   "-1",
+		GroupIDs: []string{"-2"},
+		Schema:   dblayer.DbSchema,
+	}, dblayer.Factory, dblayer.DbConnection)
+	repo.Verbose = false
+
+	alarms, err := repo.GetDueEventAlarms(now.Add(-reminderLookback), now, loc)
+	if err != nil {
+		log.Printf("DispatchReminders: %v", err)
+		return
+	}
+	reminderChannelsMutex.Lock()
+	channels := append([]ReminderChannel{}, reminderChannels...)
+	reminderChannelsMutex.Unlock()
+
+	for _, alarm := range alarms {
+		user := repo.GetEntityByID("users", alarm.Owner)
+		if user == nil {
+			continue
+		}
+		settings := repo.GetNotificationChannels(alarm.Owner)
+		subject, body := formatReminder(alarm, loc)
+		for _, channel := range channels {
+			address := channel.Address(user, settings[channel.Name()])
+			if address == "" || !repo.ClaimEventReminder(alarm, alarm.Owner, channel.Name(), loc) {
+				continue
+			}
+			sendErr := channel.Send(repo, &Reminder{Alarm: alarm, UserID: alarm.Owner, Address: address, Subject: subject, Body: body})
+			if sendErr != nil {
+				log.Printf("DispatchReminders: event %s, channel %s: %v", alarm.EventID, channel.Name(), sendErr)
+			}
+			if err := repo.CompleteEventReminder(alarm, alarm.Owner, channel.Name(), loc, sendErr); err != nil {
+				log.Printf("DispatchReminders: %v", err)
+			}
+		}
+	}
+}
+
+// StartReminderDispatcher registers the channels available in the configuration
+// and checks the due alarms in background
+func StartReminderDispatcher(config models.Config) {
+	if config.ReminderInterval < 0 {
+		log.Print("Reminder dispatcher disabled")
+		return
+	}
+	RegisterReminderChannel(InAppChannel{})
+	if config.SMTPHost != "" && config.SMTPFrom != "" {
+		RegisterReminderChannel(SMTPChannel{
+			Host:     config.SMTPHost,
+			Port:     config.SMTPPort,
+			Username: config.SMTPUsername,
+			Password: config.SMTPPassword,
+			From:     config.SMTPFrom,
+		})
+	}
+	if config.TelegramBotToken != "" {
+		RegisterReminderChannel(TelegramChannel{BotToken: config.TelegramBotToken})
+	}
+	interval := time.Duration(config.ReminderInterval) * time.Second
+	if interval == 0 {
+		interval = time.Minute
+	}
+	log.Printf("Reminder dispatcher started: channels %v, every %v", ReminderChannelNames(), interval)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			DispatchReminders(time.Now(), time.Local)
+			<-ticker.C
+		}
+	}()
+}
@@ -0,0 +1,90 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"rprj/be/dblayer"
+)
+
+func TestReminderChannelAddress(t *testing.T) {
+	user := dblayer.NewDBUser()
+	user.SetValue("id", "u1")
+	user.SetValue("login", "telegram:12345")
+	user.SetValue("email", "user@example.com")
+
+	setting := func(address, enabled string) dblayer.DBEntityInterface {
+		s := dblayer.NewNotificationChannel()
+		s.SetValue("address", address)
+		s.SetValue("enabled", enabled)
+		return s
+	}
+	cases := []struct {
+		channel ReminderChannel
+		setting dblayer.DBEntityInterface
+		want    string
+	}{
+		{InAppChannel{}, nil, "u1"},
+		{InAppChannel{}, setting("", "0"), ""},
+		{SMTPChannel{}, nil, "user@example.com"},
+		{SMTPChannel{}, setting("other@example.com", "1"), "other@example.com"},
+		{SMTPChannel{}, setting("other@example.com", "0"), ""},
+		{TelegramChannel{}, nil, "12345"},
+		{TelegramChannel{}, setting("999", "1"), "999"},
+	}
+	for _, c := range cases {
+		if got := c.channel.Address(user, c.setting); got != c.want {
+			t.Errorf("%s: got %q, want %q", c.channel.Name(), got, c.want)
+		}
+	}
+
+	user.SetValue("login", "mario")
+	if got := (TelegramChannel{}).Address(user, nil); got != "" {
+		t.Errorf("telegram without chat id: got %q", got)
+	}
+}
+
+func TestReminderDelivery(t *testing.T) {
+	alarm := dblayer.EventAlarm{
+		EventID:         "ev1",
+		Name:            "Riunione è",
+		Description:     "Sala 1",
+		OccurrenceStart: time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC),
+	}
+	subject, body := formatReminder(alarm, time.UTC)
+	if subject != "Reminder: Riunione è" || body != "Riunione è\n2024-03-01 09:00\n\nSala 1" {
+		t.Errorf("formatReminder: %q %q", subject, body)
+	}
+	reminder := &Reminder{Alarm: alarm, UserID: "u1", Address: "user@example.com", Subject: subject, Body: body}
+
+	email := string(buildReminderEmail("rhobee@example.com", reminder))
+	for _, expected := range []string{"To: user@example.com\r\n", "Subject: =?utf-8?q?Reminder:_Riunione_=C3=A8?=\r\n", "\r\n\r\nRiunione è\r\n2024-03-01 09:00\r\n"} {
+		if !strings.Contains(email, expected) {
+			t.Errorf("missing %q in\n%s", expected, email)
+		}
+	}
+
+	var chatID, text string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/botTOKEN/sendMessage" {
+			http.NotFound(w, r)
+			return
+		}
+		r.ParseForm()
+		chatID, text = r.PostForm.Get("chat_id"), r.PostForm.Get("text")
+	}))
+	defer server.Close()
+	reminder.Address = "12345"
+	if err := (TelegramChannel{BotToken: "TOKEN", APIURL: server.URL}).Send(nil, reminder); err != nil {
+		t.Fatal(err)
+	}
+	if chatID != "12345" || !strings.HasPrefix(text, "Reminder: Riunione è\n") {
+		t.Errorf("telegram: %q %q", chatID, text)
+	}
+	if err := (TelegramChannel{BotToken: "WRONG", APIURL: server.URL}).Send(nil, reminder); err == nil {
+		t.Error("telegram: expected an error on 404")
+	}
+}
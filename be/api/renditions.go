@@ -0,0 +1,95 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"rprj/be/dblayer"
+)
+
+/*
+Renditions of the images on the download endpoint: ?w=&h=&fit=&format=
+(see dblayer/renditions.go). A rendition is a variant of the checksum of
+the file for the HTTP caches, like the thumbnail.
+*/
+
+// parseRendition reads the rendition parameters of a download. requested is
+// false when there are none; an invalid parameter is a *dblayer.ValidationError.
+func parseRendition(query url.Values) (rendition dblayer.Rendition, requested bool, err error) {
+	invalid := func(field string, message string) error {
+		return &dblayer.ValidationError{Code: ErrInvalidRequest, Field: field, Message: message}
+	}
+	for _, field := range []string{"w", "h"} {
+		value := query.Get(field)
+		if value == "" {
+			continue
+		}
+		requested = true
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > dblayer.RenditionMaxDimension {
+			return rendition, true, invalid(field, fmt.Sprintf("must be between 1 and %d", dblayer.RenditionMaxDimension))
+		}
+		if field == "w" {
+			rendition.Width = n
+		} else {
+			rendition.Height = n
+		}
+	}
+	switch fit := query.Get("fit"); fit {
+	case "":
+	case dblayer.RenditionFitContain, dblayer.RenditionFitCover:
+		requested = true
+		rendition.Fit = fit
+	default:
+		return rendition, true, invalid("fit", "must be cover or contain")
+	}
+	switch format := strings.ToLower(query.Get("format")); format {
+	case "":
+	case "jpg", dblayer.RenditionFormatJPEG:
+		requested = true
+		rendition.Format = dblayer.RenditionFormatJPEG
+	case dblayer.RenditionFormatPNG, dblayer.RenditionFormatWebP:
+		requested = true
+		rendition.Format = format
+	default:
+		return rendition, true, invalid("format", "must be jpeg, png or webp")
+	}
+	return rendition, requested, nil
+}
+
+// serveRendition serves a rendition of an image, generating it when not cached
+func serveRendition(w http.ResponseWriter, r *http.Request, dbFile *dblayer.DBFile, rendition dblayer.Rendition, filename string) {
+	rendition, key, err := dblayer.Renditions.Get(dbFile, rendition)
+	switch {
+	case errors.Is(err, dblayer.ErrRenditionUnsupported):
+		RespondSimpleError(w, ErrInvalidRequest, "Renditions are available for images only", http.StatusBadRequest)
+		return
+	case errors.Is(err, dblayer.ErrRenditionTooLarge):
+		RespondSimpleError(w, ErrInvalidRequest, "Image too large for a rendition", http.StatusBadRequest)
+		return
+	case errors.Is(err, dblayer.ErrRenditionBusy):
+		w.Header().Set("Retry-After", "5")
+		RespondSimpleError(w, ErrInternalServer, "Too many renditions in progress, retry later", http.StatusServiceUnavailable)
+		return
+	case errors.Is(err, fs.ErrNotExist):
+		RespondSimpleError(w, ErrObjectNotFound, "File not found on disk", http.StatusNotFound)
+		return
+	case err != nil:
+		log.Printf("serveRendition: %v", err)
+		RespondSimpleError(w, ErrInternalServer, "Failed to render the image", http.StatusInternalServerError)
+		return
+	}
+
+	checksum, _ := dbFile.GetValue("checksum").(string)
+	lastModifyDate, _ := dbFile.GetValue("last_modify_date").(string)
+	cache := newBlobCache(checksum, rendition.Variant(), lastModifyDate, isPublicObject(dbFile))
+	filename = strings.TrimSuffix(filename, filepath.Ext(filename)) + rendition.Ext()
+	serveBlobFrom(w, r, dblayer.Renditions.Storage(), "serveRendition", key, rendition.Mime(), filename, cache)
+}
@@ -0,0 +1,124 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"rprj/be/dblayer"
+)
+
+// FileRescanResponse godoc
+// @Description Response structure of a batch of the rescan of the stored files
+type FileRescanResponse struct {
+	Success bool                    `json:"success"`
+	Result  *dblayer.FileScanReport `json:"result"`
+}
+
+// FileScansResponse godoc
+// @Description Response structure of the infected files found by the scanner
+type FileScansResponse struct {
+	Success bool                   `json:"success"`
+	Scans   []dblayer.FileScanInfo `json:"scans"`
+}
+
+// queryLimit reads the limit parameter, def when missing. Responds 400 when invalid.
+func queryLimit(w http.ResponseWriter, r *http.Request, def int) (int, bool) {
+	value := r.URL.Query().Get("limit")
+	if value == "" {
+		return def, true
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit < 0 {
+		RespondError(w, ErrInvalidRequest, "Invalid limit", map[string]string{"field": "limit"}, http.StatusBadRequest)
+		return 0, false
+	}
+	return limit, true
+}
+
+// RescanFilesHandler godoc
+// @Summary Rescan the stored files
+// @Description Scans the blobs of the files already stored, with their archived revisions, for malware. The files are
+// @Description scanned in order of id, at most limit per call: call again with after set to the next of the result
+// @Description until it is empty. Each infected blob is recorded in the file scans; with the quarantine scan_action it
+// @Description is moved to the quarantine, and its files cannot be downloaded anymore. Admin only.
+// @Tags files
+// @Produce json
+// @Param after query string false "Id of the last file scanned by the previous call"
+// @Param limit query int false "Maximum number of files to scan (default 200, 0 = all)"
+// @Success 200 {object} FileRescanResponse "Rescan report"
+// @Failure 403 {object} ErrorResponse "Forbidden"
+// @Failure 503 {object} ErrorResponse "No scanner configured, or the scanner is unavailable"
+// @Security BearerAuth
+// @Router /files/storage/rescan [post]
+func RescanFilesHandler(w http.ResponseWriter, r *http.Request) {
+	repo, ok := authenticatedRepository(w, r)
+	if !ok {
+		return
+	}
+	if !isAdminRepository(repo) {
+		RespondSimpleError(w, ErrForbidden, "Only administrators can rescan the files", http.StatusForbidden)
+		return
+	}
+	limit, ok := queryLimit(w, r, 200)
+	if !ok {
+		return
+	}
+	result, err := repo.RescanFiles(r.URL.Query().Get("after"), limit)
+	if errors.Is(err, dblayer.ErrScanFailed) {
+		log.Printf("RescanFilesHandler: %v", err)
+		RespondSimpleError(w, ErrScannerUnavailable, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		log.Printf("RescanFilesHandler: %v", err)
+		RespondSimpleError(w, ErrInternalServer, "Failed to rescan the files", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(FileRescanResponse{
+		Success: true,
+		Result:  result,
+	})
+}
+
+// GetFileScansHandler godoc
+// @Summary List the infected files
+// @Description Returns the infected files found by the scanner, the most recent first: the uploads rejected or
+// @Description quarantined, and the stored files found by a rescan. Admin only.
+// @Tags files
+// @Produce json
+// @Param limit query int false "Maximum number of entries (default 100, 0 = all)"
+// @Success 200 {object} FileScansResponse "Infected files"
+// @Failure 403 {object} ErrorResponse "Forbidden"
+// @Security BearerAuth
+// @Router /files/storage/scans [get]
+func GetFileScansHandler(w http.ResponseWriter, r *http.Request) {
+	repo, ok := authenticatedRepository(w, r)
+	if !ok {
+		return
+	}
+	if !isAdminRepository(repo) {
+		RespondSimpleError(w, ErrForbidden, "Only administrators can read the file scans", http.StatusForbidden)
+		return
+	}
+	limit, ok := queryLimit(w, r, 100)
+	if !ok {
+		return
+	}
+	scans, err := repo.GetFileScans(limit)
+	if err != nil {
+		log.Printf("GetFileScansHandler: %v", err)
+		RespondSimpleError(w, ErrInternalServer, "Failed to read the file scans", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(FileScansResponse{
+		Success: true,
+		Scans:   scans,
+	})
+}
@@ -0,0 +1,61 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+
+	"rprj/be/dblayer"
+)
+
+// SearchReindexResponse is the result of a full-text index rebuild
+type SearchReindexResponse struct {
+	Success bool `json:"success"`
+	Indexed int  `json:"indexed"`
+}
+
+// SearchReindexHandler godoc
+// @Summary Rebuild the full-text index
+// @Description Rebuilds the full-text index of all the objects. The index is kept current on every write, this is needed only after importing data directly in the database. Admin only.
+// @Tags navigation
+// @Produce json
+// @Success 200 {object} SearchReindexResponse "Number of indexed objects"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Failure 403 {object} ErrorResponse "Forbidden"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Security BearerAuth
+// @Router /search/reindex [post]
+func SearchReindexHandler(w http.ResponseWriter, r *http.Request) {
+	claims, err := GetClaimsFromRequest(r)
+	if err != nil {
+		RespondSimpleError(w, ErrUnauthorized, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	dbContext := &dblayer.DBContext{
+		UserID:   claims["user_id"],
+		GroupIDs: strings.Split(claims["groups"], ","),
+		Schema:   dblayer.DbSchema,
+	}
+	if !slices.Contains(dbContext.GroupIDs, "-2") {
+		RespondSimpleError(w, ErrForbidden, "Only administrators can rebuild the search index", http.StatusForbidden)
+		return
+	}
+	repo := dblayer.NewDBRepository(dbContext, dblayer.Factory, dblayer.DbConnection)
+	repo.Verbose = false
+
+	count, err := repo.ReindexAll()
+	if err != nil {
+		log.Printf("SearchReindexHandler: Failed to rebuild the index: %v", err)
+		RespondSimpleError(w, ErrInternalServer, "Failed to rebuild the search index", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(SearchReindexResponse{
+		Success: true,
+		Indexed: count,
+	})
+}
@@ -0,0 +1,375 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"rprj/be/dblayer"
+
+	"github.com/gorilla/mux"
+)
+
+// TagsRequest godoc
+// @Description Request structure to tag/untag an object
+type TagsRequest struct {
+	Tags []string `json:"tags"`
+}
+
+// TagRenameRequest godoc
+// @Description Request structure to rename a tag
+type TagRenameRequest struct {
+	Name string `json:"name"`
+}
+
+// TagMergeRequest godoc
+// @Description Request structure to merge tags into a target tag
+type TagMergeRequest struct {
+	SourceIDs []string `json:"source_ids"`
+	TargetID  string   `json:"target_id"`
+}
+
+// TagsResponse godoc
+// @Description Response structure for tag operations
+type TagsResponse struct {
+	Success bool                     `json:"success"`
+	Tags    []map[string]interface{} `json:"tags"`
+}
+
+// TagCloudResponse godoc
+// @Description Response structure for the tag cloud
+type TagCloudResponse struct {
+	Success bool               `json:"success"`
+	Tags    []dblayer.TagCount `json:"tags"`
+}
+
+// ParseTagsParam splits a comma separated list of tags, ignoring empty entries
+func ParseTagsParam(tagsParam string) []string {
+	tags := []string{}
+	for _, tag := range strings.Split(tagsParam, ",") {
+		tag = dblayer.NormalizeTagName(tag)
+		if tag != "" && !slices.Contains(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+func tagsToMaps(tags []dblayer.DBEntityInterface) []map[string]interface{} {
+	ret := make([]map[string]interface{}, 0, len(tags))
+	for _, tag := range tags {
+		ret = append(ret, tag.GetAllValues())
+	}
+	return ret
+}
+
+// GetAllTagsHandler godoc
+// @Summary List all tags
+// @Description Returns the tags ordered by name: all of them for the administrators, the tags of the objects
+// @Description the caller can read for the others
+// @Tags tags
+// @Produce json
+// @Success 200 {object} TagsResponse "List of tags"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Security BearerAuth
+// @Router /tags [get]
+func GetAllTagsHandler(w http.ResponseWriter, r *http.Request) {
+	repo, ok := authenticatedRepository(w, r)
+	if !ok {
+		return
+	}
+
+	var tags []dblayer.DBEntityInterface
+	if isAdminRepository(repo) {
+		tags = repo.GetAllTags()
+	} else {
+		tags = repo.GetReadableTags()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(TagsResponse{
+		Success: true,
+		Tags:    tagsToMaps(tags),
+	})
+}
+
+// GetTagCloudHandler godoc
+// @Summary Tag cloud
+// @Description Returns the tags with the number of objects the caller can read
+// @Tags tags
+// @Produce json
+// @Param limit query int false "Maximum number of tags"
+// @Success 200 {object} TagCloudResponse "Tag cloud"
+// @Router /tags/cloud [get]
+func GetTagCloudHandler(w http.ResponseWriter, r *http.Request) {
+	claims, err := GetClaimsFromRequest(r)
+
+	var dbContext dblayer.DBContext
+	if err == nil {
+		dbContext = dblayer.DBContext{
+			UserID:   claims["user_id"],
+			GroupIDs: strings.Split(claims["groups"], ","),
+			Schema:   dblayer.DbSchema,
+		}
+	} else {
+		dbContext = dblayer.DBContext{
+			UserID:   "-7",           // Anonymous user
+			GroupIDs: []string{"-4"}, // Guests group
+			Schema:   dblayer.DbSchema,
+		}
+	}
+
+	repo := dblayer.NewDBRepository(&dbContext, dblayer.Factory, dblayer.DbConnection)
+	repo.Verbose = false
+
+	cloud := repo.GetTagCloud()
+	if cloud == nil {
+		cloud = []dblayer.TagCount{}
+	}
+	if limit, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && limit > 0 && limit < len(cloud) {
+		cloud = cloud[:limit]
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(TagCloudResponse{
+		Success: true,
+		Tags:    cloud,
+	})
+}
+
+// GetObjectTagsHandler godoc
+// @Summary List the tags of an object
+// @Description Returns the tags associated to a DBObject
+// @Tags tags
+// @Produce json
+// @Param id path string true "Object ID"
+// @Success 200 {object} TagsResponse "Tags of the object"
+// @Failure 403 {object} ErrorResponse "Forbidden"
+// @Failure 404 {object} ErrorResponse "Object not found"
+// @Security BearerAuth
+// @Router /objects/{id}/tags [get]
+func GetObjectTagsHandler(w http.ResponseWriter, r *http.Request) {
+	repo, objectID, ok := tagHandlerLoadObject(w, r, false)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(TagsResponse{
+		Success: true,
+		Tags:    tagsToMaps(repo.GetObjectTags(objectID)),
+	})
+}
+
+// TagObjectHandler godoc
+// @Summary Tag an object
+// @Description Adds one or more tags to a DBObject, creating the missing tags
+// @Tags tags
+// @Accept json
+// @Produce json
+// @Param id path string true "Object ID"
+// @Param request body TagsRequest true "Tags to add"
+// @Success 200 {object} TagsResponse "Tags of the object"
+// @Failure 400 {object} ErrorResponse "Invalid request"
+// @Failure 403 {object} ErrorResponse "Forbidden"
+// @Failure 404 {object} ErrorResponse "Object not found"
+// @Security BearerAuth
+// @Router /objects/{id}/tags [post]
+func TagObjectHandler(w http.ResponseWriter, r *http.Request) {
+	repo, objectID, ok := tagHandlerLoadObject(w, r, true)
+	if !ok {
+		return
+	}
+
+	var request TagsRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || len(request.Tags) == 0 {
+		RespondError(w, ErrMissingField, "Field is required", map[string]string{"field": "tags"}, http.StatusBadRequest)
+		return
+	}
+
+	tags, err := repo.TagObject(objectID, request.Tags)
+	if err != nil {
+		log.Printf("TagObjectHandler: Failed to tag object %s: %v", objectID, err)
+		RespondSimpleError(w, ErrInternalServer, "Failed to tag object: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(TagsResponse{
+		Success: true,
+		Tags:    tagsToMaps(tags),
+	})
+}
+
+// UntagObjectHandler godoc
+// @Summary Untag an object
+// @Description Removes a tag from a DBObject
+// @Tags tags
+// @Produce json
+// @Param id path string true "Object ID"
+// @Param tag path string true "Tag name"
+// @Success 200 {object} TagsResponse "Tags of the object"
+// @Failure 403 {object} ErrorResponse "Forbidden"
+// @Failure 404 {object} ErrorResponse "Object not found"
+// @Security BearerAuth
+// @Router /objects/{id}/tags/{tag} [delete]
+func UntagObjectHandler(w http.ResponseWriter, r *http.Request) {
+	repo, objectID, ok := tagHandlerLoadObject(w, r, true)
+	if !ok {
+		return
+	}
+
+	tags, err := repo.UntagObject(objectID, []string{mux.Vars(r)["tag"]})
+	if err != nil {
+		log.Printf("UntagObjectHandler: Failed to untag object %s: %v", objectID, err)
+		RespondSimpleError(w, ErrInternalServer, "Failed to untag object: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(TagsResponse{
+		Success: true,
+		Tags:    tagsToMaps(tags),
+	})
+}
+
+// RenameTagHandler godoc
+// @Summary Rename a tag
+// @Description Renames a tag; if a tag with the new name exists, the two are merged. Admin only.
+// @Tags tags
+// @Accept json
+// @Produce json
+// @Param id path string true "Tag ID"
+// @Param request body TagRenameRequest true "New name"
+// @Success 200 {object} TagsResponse "The resulting tag"
+// @Failure 400 {object} ErrorResponse "Invalid request"
+// @Failure 403 {object} ErrorResponse "Forbidden"
+// @Security BearerAuth
+// @Router /tags/{id} [put]
+func RenameTagHandler(w http.ResponseWriter, r *http.Request) {
+	repo, ok := tagHandlerAdminRepo(w, r)
+	if !ok {
+		return
+	}
+
+	var request TagRenameRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || dblayer.NormalizeTagName(request.Name) == "" {
+		RespondError(w, ErrMissingField, "Field is required", map[string]string{"field": "name"}, http.StatusBadRequest)
+		return
+	}
+
+	tag, err := repo.RenameTag(mux.Vars(r)["id"], request.Name)
+	if err != nil {
+		log.Printf("RenameTagHandler: Failed to rename tag: %v", err)
+		RespondSimpleError(w, ErrInvalidRequest, "Failed to rename tag: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(TagsResponse{
+		Success: true,
+		Tags:    tagsToMaps([]dblayer.DBEntityInterface{tag}),
+	})
+}
+
+// MergeTagsHandler godoc
+// @Summary Merge tags
+// @Description Moves all the objects of the source tags to the target tag and deletes the sources. Admin only.
+// @Tags tags
+// @Accept json
+// @Produce json
+// @Param request body TagMergeRequest true "Source and target tags"
+// @Success 200 {object} TagsResponse "The target tag"
+// @Failure 400 {object} ErrorResponse "Invalid request"
+// @Failure 403 {object} ErrorResponse "Forbidden"
+// @Security BearerAuth
+// @Router /tags/merge [post]
+func MergeTagsHandler(w http.ResponseWriter, r *http.Request) {
+	repo, ok := tagHandlerAdminRepo(w, r)
+	if !ok {
+		return
+	}
+
+	var request TagMergeRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		RespondSimpleError(w, ErrInvalidRequest, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if request.TargetID == "" {
+		RespondError(w, ErrMissingField, "Field is required", map[string]string{"field": "target_id"}, http.StatusBadRequest)
+		return
+	}
+	if len(request.SourceIDs) == 0 {
+		RespondError(w, ErrMissingField, "Field is required", map[string]string{"field": "source_ids"}, http.StatusBadRequest)
+		return
+	}
+
+	tag, err := repo.MergeTags(request.SourceIDs, request.TargetID)
+	if err != nil {
+		log.Printf("MergeTagsHandler: Failed to merge tags: %v", err)
+		RespondSimpleError(w, ErrInvalidRequest, "Failed to merge tags: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(TagsResponse{
+		Success: true,
+		Tags:    tagsToMaps([]dblayer.DBEntityInterface{tag}),
+	})
+}
+
+// tagHandlerLoadObject builds the repository for the caller and checks
+// the read (or write) permission on the object in the path
+func tagHandlerLoadObject(w http.ResponseWriter, r *http.Request, needsWrite bool) (*dblayer.DBRepository, string, bool) {
+	repo, ok := authenticatedRepository(w, r)
+	if !ok {
+		return nil, "", false
+	}
+
+	objectID := mux.Vars(r)["id"]
+	if objectID == "" {
+		RespondSimpleError(w, ErrInvalidRequest, "Missing object ID", http.StatusBadRequest)
+		return nil, "", false
+	}
+	if len(objectID) == 18 {
+		objectID = strings.ReplaceAll(objectID, "-", "")
+	}
+
+	obj := repo.ObjectByID(objectID, true)
+	if obj == nil {
+		RespondSimpleError(w, ErrObjectNotFound, "Object not found", http.StatusNotFound)
+		return nil, "", false
+	}
+	if needsWrite && !repo.CheckWritePermission(obj) {
+		RespondSimpleError(w, ErrForbidden, "You don't have permission to edit this object", http.StatusForbidden)
+		return nil, "", false
+	}
+	if !needsWrite && !repo.CheckReadPermission(obj) {
+		RespondSimpleError(w, ErrForbidden, "You don't have permission to view this object", http.StatusForbidden)
+		return nil, "", false
+	}
+	return repo, objectID, true
+}
+
+// tagHandlerAdminRepo builds the repository for the caller, who must be an admin
+func tagHandlerAdminRepo(w http.ResponseWriter, r *http.Request) (*dblayer.DBRepository, bool) {
+	repo, ok := authenticatedRepository(w, r)
+	if !ok {
+		return nil, false
+	}
+	if !isAdminRepository(repo) {
+		RespondSimpleError(w, ErrForbidden, "Only administrators can rename or merge tags", http.StatusForbidden)
+		return nil, false
+	}
+	return repo, true
+}
@@ -0,0 +1,470 @@
+package api
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"rprj/be/dblayer"
+)
+
+// TimesheetEntryRequest godoc
+// @Description Request structure to log time or start a timer.
+// @Description Client is the id of a person, company or project; the interval is start..end,
+// @Description or start + hours when end is missing. Timers ignore start, end and hours.
+type TimesheetEntryRequest struct {
+	Name        string  `json:"name"`
+	Description string  `json:"description"`
+	Client      string  `json:"client"`
+	Project     string  `json:"project"`
+	Start       string  `json:"start"`
+	End         string  `json:"end"`
+	Hours       float64 `json:"hours"`
+	Km          int     `json:"km"`
+	CostPerHour float64 `json:"cost_per_hour"`
+	Currency    string  `json:"currency"`
+}
+
+// TimesheetEntriesResponse godoc
+// @Description Response structure for the timesheet entries
+type TimesheetEntriesResponse struct {
+	Success bool                     `json:"success"`
+	Entries []dblayer.TimesheetEntry `json:"entries"`
+	Hours   float64                  `json:"hours"`
+}
+
+// TimesheetEntryResponse godoc
+// @Description Response structure for a single timesheet entry (e.g. the running timer)
+type TimesheetEntryResponse struct {
+	Success bool                    `json:"success"`
+	Entry   *dblayer.TimesheetEntry `json:"entry"`
+}
+
+// TimesheetReportResponse godoc
+// @Description Response structure for the aggregated timesheet report
+type TimesheetReportResponse struct {
+	Success bool                   `json:"success"`
+	From    string                 `json:"from,omitempty"`
+	To      string                 `json:"to,omitempty"`
+	GroupBy []string               `json:"group_by"`
+	Period  string                 `json:"period,omitempty"`
+	Rows    []dblayer.TimesheetRow `json:"rows"`
+	Hours   float64                `json:"hours"`
+}
+
+// normalizeObjectID strips the dashes of the 18 chars object ids
+func normalizeObjectID(id string) string {
+	if len(id) == 18 {
+		return strings.ReplaceAll(id, "-", "")
+	}
+	return id
+}
+
+// parseTimesheetFilter reads from, to, person, client and project from the query.
+// A "to" date without time includes the whole day.
+func parseTimesheetFilter(r *http.Request) (dblayer.TimesheetFilter, string, error) {
+	query := r.URL.Query()
+	filter := dblayer.TimesheetFilter{
+		Person:  normalizeObjectID(query.Get("person")),
+		Client:  normalizeObjectID(query.Get("client")),
+		Project: normalizeObjectID(query.Get("project")),
+	}
+	if from := query.Get("from"); from != "" {
+		t, err := dblayer.ParseDateTime(from)
+		if err != nil {
+			return filter, "from", err
+		}
+		filter.From = t
+	}
+	if to := query.Get("to"); to != "" {
+		t, err := dblayer.ParseDateTime(to)
+		if err != nil {
+			return filter, "to", err
+		}
+		if len(to) == len(time.DateOnly) {
+			t = t.AddDate(0, 0, 1)
+		}
+		filter.To = t
+	}
+	return filter, "", nil
+}
+
+// wantsCSV tells if the client asked for CSV with format=csv or the Accept header
+func wantsCSV(r *http.Request) bool {
+	format := r.URL.Query().Get("format")
+	if format != "" {
+		return strings.EqualFold(format, "csv")
+	}
+	return strings.Contains(r.Header.Get("Accept"), "text/csv")
+}
+
+// writeCSV sends the records as a CSV attachment
+func writeCSV(w http.ResponseWriter, filename string, records [][]string) {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w.WriteHeader(http.StatusOK)
+	writer := csv.NewWriter(w)
+	if err := writer.WriteAll(records); err != nil {
+		log.Printf("writeCSV: %v", err)
+	}
+}
+
+func formatHours(hours float64) string {
+	return strconv.FormatFloat(hours, 'f', 2, 64)
+}
+
+// GetTimesheetEntriesHandler godoc
+// @Summary List timesheet entries
+// @Description Returns the timetracks readable by the user, ordered by start time. Running timers count zero hours.
+// @Tags timesheet
+// @Produce json
+// @Produce text/csv
+// @Param from query string false "Start date (inclusive), e.g. 2024-01-01"
+// @Param to query string false "End date (inclusive when a date, exclusive when a datetime)"
+// @Param person query string false "User who logged the time"
+// @Param client query string false "Person, company or project billed"
+// @Param project query string false "Project"
+// @Param format query string false "json (default) or csv"
+// @Success 200 {object} TimesheetEntriesResponse "Timesheet entries"
+// @Failure 400 {object} ErrorResponse "Invalid request"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Security BearerAuth
+// @Router /timesheet/entries [get]
+func GetTimesheetEntriesHandler(w http.ResponseWriter, r *http.Request) {
+	repo, ok := authenticatedRepository(w, r)
+	if !ok {
+		return
+	}
+	filter, field, err := parseTimesheetFilter(r)
+	if err != nil {
+		RespondError(w, ErrInvalidRequest, err.Error(), map[string]string{"field": field}, http.StatusBadRequest)
+		return
+	}
+
+	entries, err := repo.GetTimesheetEntries(filter)
+	if err != nil {
+		log.Printf("GetTimesheetEntriesHandler: %v", err)
+		RespondSimpleError(w, ErrInternalServer, "Failed to read timesheet", http.StatusInternalServerError)
+		return
+	}
+
+	if wantsCSV(r) {
+		records := [][]string{{"id", "start", "end", "person", "client", "project", "name", "description", "hours", "travel_hours", "km", "cost_per_hour", "currency"}}
+		for _, e := range entries {
+			records = append(records, []string{
+				e.ID, e.Start, e.End, e.PersonName, e.ClientName, e.ProjectName, e.Name, e.Description,
+				formatHours(e.Hours), formatHours(e.TravelHours), strconv.Itoa(e.Km),
+				strconv.FormatFloat(e.CostPerHour, 'f', -1, 64), e.Currency,
+			})
+		}
+		writeCSV(w, "timesheet.csv", records)
+		return
+	}
+
+	total := 0.0
+	for _, e := range entries {
+		total += e.Hours
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(TimesheetEntriesResponse{
+		Success: true,
+		Entries: entries,
+		Hours:   math.Round(total*100) / 100,
+	})
+}
+
+// newTimetrackFromRequest fills a DBTimetrack with the common fields of the request
+func newTimetrackFromRequest(request TimesheetEntryRequest) *dblayer.DBTimetrack {
+	timetrack := dblayer.NewDBTimetrack()
+	name := strings.TrimSpace(request.Name)
+	if name == "" {
+		name = "Timetrack"
+	}
+	timetrack.SetValue("name", name)
+	timetrack.SetValue("description", request.Description)
+	if client := normalizeObjectID(request.Client); client != "" {
+		timetrack.SetValue("fk_obj_id", client)
+	}
+	if project := normalizeObjectID(request.Project); project != "" {
+		timetrack.SetValue("fk_progetto", project)
+	}
+	timetrack.SetValue("km_viaggio", request.Km)
+	timetrack.SetValue("costo_per_ora", request.CostPerHour)
+	if request.Currency != "" {
+		timetrack.SetValue("costo_valuta", request.Currency)
+	}
+	return timetrack
+}
+
+// respondTimetrack sends a timetrack as a TimesheetEntry
+func respondTimetrack(w http.ResponseWriter, timetrack dblayer.DBEntityInterface, status int) {
+	var entry *dblayer.TimesheetEntry
+	if timetrack != nil {
+		e := dblayer.NewTimesheetEntry(timetrack)
+		entry = &e
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(TimesheetEntryResponse{
+		Success: true,
+		Entry:   entry,
+	})
+}
+
+// CreateTimesheetEntryHandler godoc
+// @Summary Log time
+// @Description Logs a timetrack for the current user. Give start and end, or start and hours.
+// @Tags timesheet
+// @Accept json
+// @Produce json
+// @Param request body TimesheetEntryRequest true "Time to log"
+// @Success 201 {object} TimesheetEntryResponse "Logged entry"
+// @Failure 400 {object} ErrorResponse "Invalid request"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Security BearerAuth
+// @Router /timesheet/entries [post]
+func CreateTimesheetEntryHandler(w http.ResponseWriter, r *http.Request) {
+	repo, ok := authenticatedRepository(w, r)
+	if !ok {
+		return
+	}
+	var request TimesheetEntryRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		RespondSimpleError(w, ErrInvalidRequest, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if request.Start == "" {
+		RespondError(w, ErrMissingField, "Field is required", map[string]string{"field": "start"}, http.StatusBadRequest)
+		return
+	}
+	start, err := dblayer.ParseDateTime(request.Start)
+	if err != nil {
+		RespondError(w, ErrInvalidRequest, err.Error(), map[string]string{"field": "start"}, http.StatusBadRequest)
+		return
+	}
+	var end time.Time
+	switch {
+	case request.End != "":
+		end, err = dblayer.ParseDateTime(request.End)
+		if err != nil {
+			RespondError(w, ErrInvalidRequest, err.Error(), map[string]string{"field": "end"}, http.StatusBadRequest)
+			return
+		}
+	case request.Hours > 0:
+		end = start.Add(time.Duration(request.Hours * float64(time.Hour)))
+	default:
+		RespondError(w, ErrMissingField, "Field is required", map[string]string{"field": "end"}, http.StatusBadRequest)
+		return
+	}
+
+	timetrack := newTimetrackFromRequest(request)
+	timetrack.SetValue("dalle_ore", start.Format(time.DateTime))
+	timetrack.SetValue("alle_ore", end.Format(time.DateTime))
+	created, err := repo.LogTimetrack(timetrack)
+	if err != nil {
+		log.Printf("CreateTimesheetEntryHandler: %v", err)
+		RespondSimpleError(w, ErrInvalidRequest, "Failed to log time: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	respondTimetrack(w, created, http.StatusCreated)
+}
+
+// GetTimerHandler godoc
+// @Summary Get the running timer
+// @Description Returns the running timer of the current user; entry is null when no timer is running
+// @Tags timesheet
+// @Produce json
+// @Success 200 {object} TimesheetEntryResponse "Running timer"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Security BearerAuth
+// @Router /timesheet/timer [get]
+func GetTimerHandler(w http.ResponseWriter, r *http.Request) {
+	repo, ok := authenticatedRepository(w, r)
+	if !ok {
+		return
+	}
+	respondTimetrack(w, repo.GetRunningTimer(), http.StatusOK)
+}
+
+// StartTimerHandler godoc
+// @Summary Start a timer
+// @Description Starts a timer for the current user. Only one timer per user can run.
+// @Tags timesheet
+// @Accept json
+// @Produce json
+// @Param request body TimesheetEntryRequest false "Name, client and project of the timer"
+// @Success 201 {object} TimesheetEntryResponse "Started timer"
+// @Failure 400 {object} ErrorResponse "Invalid request"
+// @Failure 409 {object} ErrorResponse "A timer is already running"
+// @Security BearerAuth
+// @Router /timesheet/timer/start [post]
+func StartTimerHandler(w http.ResponseWriter, r *http.Request) {
+	repo, ok := authenticatedRepository(w, r)
+	if !ok {
+		return
+	}
+	var request TimesheetEntryRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			RespondSimpleError(w, ErrInvalidRequest, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+	started, err := repo.StartTimer(newTimetrackFromRequest(request))
+	if errors.Is(err, dblayer.ErrTimerRunning) {
+		RespondSimpleError(w, ErrTimerAlreadyRunning, "A timer is already running", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("StartTimerHandler: %v", err)
+		RespondSimpleError(w, ErrInvalidRequest, "Failed to start timer: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	respondTimetrack(w, started, http.StatusCreated)
+}
+
+// StopTimerHandler godoc
+// @Summary Stop the running timer
+// @Description Stops the running timer of the current user, logging the elapsed time
+// @Tags timesheet
+// @Produce json
+// @Success 200 {object} TimesheetEntryResponse "Stopped timer"
+// @Failure 404 {object} ErrorResponse "No timer running"
+// @Security BearerAuth
+// @Router /timesheet/timer/stop [post]
+func StopTimerHandler(w http.ResponseWriter, r *http.Request) {
+	repo, ok := authenticatedRepository(w, r)
+	if !ok {
+		return
+	}
+	stopped, err := repo.StopTimer()
+	if errors.Is(err, dblayer.ErrNoTimerRunning) {
+		RespondSimpleError(w, ErrNoTimerRunning, "No timer running", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("StopTimerHandler: %v", err)
+		RespondSimpleError(w, ErrInternalServer, "Failed to stop timer", http.StatusInternalServerError)
+		return
+	}
+	respondTimetrack(w, stopped, http.StatusOK)
+}
+
+// GetTimesheetReportHandler godoc
+// @Summary Timesheet report
+// @Description Sums the hours of the readable timetracks by person, client, project and/or period. Rows are split by currency.
+// @Tags timesheet
+// @Produce json
+// @Produce text/csv
+// @Param from query string false "Start date (inclusive), e.g. 2024-01-01"
+// @Param to query string false "End date (inclusive when a date, exclusive when a datetime)"
+// @Param person query string false "User who logged the time"
+// @Param client query string false "Person, company or project billed"
+// @Param project query string false "Project"
+// @Param group_by query string false "Comma separated: person, client, project, period (default: person,client)"
+// @Param period query string false "day, week or month (default: month when grouping by period)"
+// @Param format query string false "json (default) or csv"
+// @Success 200 {object} TimesheetReportResponse "Timesheet report"
+// @Failure 400 {object} ErrorResponse "Invalid request"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Security BearerAuth
+// @Router /timesheet/report [get]
+func GetTimesheetReportHandler(w http.ResponseWriter, r *http.Request) {
+	repo, ok := authenticatedRepository(w, r)
+	if !ok {
+		return
+	}
+	filter, field, err := parseTimesheetFilter(r)
+	if err != nil {
+		RespondError(w, ErrInvalidRequest, err.Error(), map[string]string{"field": field}, http.StatusBadRequest)
+		return
+	}
+	groupBy, err := dblayer.ParseTimesheetGroupBy(r.URL.Query().Get("group_by"))
+	if err != nil {
+		RespondError(w, ErrInvalidRequest, err.Error(), map[string]string{"field": "group_by"}, http.StatusBadRequest)
+		return
+	}
+	period := r.URL.Query().Get("period")
+	if period != "" && !slices.Contains(groupBy, "period") {
+		groupBy = append(groupBy, "period")
+	}
+	if period == "" && slices.Contains(groupBy, "period") {
+		period = "month"
+	}
+
+	entries, err := repo.GetTimesheetEntries(filter)
+	if err != nil {
+		log.Printf("GetTimesheetReportHandler: %v", err)
+		RespondSimpleError(w, ErrInternalServer, "Failed to read timesheet", http.StatusInternalServerError)
+		return
+	}
+	rows, err := dblayer.AggregateTimesheet(entries, groupBy, period)
+	if err != nil {
+		RespondError(w, ErrInvalidRequest, err.Error(), map[string]string{"field": "period"}, http.StatusBadRequest)
+		return
+	}
+
+	if wantsCSV(r) {
+		header := []string{}
+		for _, g := range groupBy {
+			switch g {
+			case "person", "client", "project":
+				header = append(header, g+"_id", g)
+			case "period":
+				header = append(header, "period")
+			}
+		}
+		header = append(header, "currency", "entries", "hours", "travel_hours", "amount")
+		records := [][]string{header}
+		for _, row := range rows {
+			record := []string{}
+			for _, g := range groupBy {
+				switch g {
+				case "person":
+					record = append(record, row.Person, row.PersonName)
+				case "client":
+					record = append(record, row.Client, row.ClientName)
+				case "project":
+					record = append(record, row.Project, row.ProjectName)
+				case "period":
+					record = append(record, row.Period)
+				}
+			}
+			record = append(record, row.Currency, strconv.Itoa(row.Entries),
+				formatHours(row.Hours), formatHours(row.TravelHours), strconv.FormatFloat(row.Amount, 'f', 2, 64))
+			records = append(records, record)
+		}
+		writeCSV(w, "timesheet-report.csv", records)
+		return
+	}
+
+	total := 0.0
+	for _, row := range rows {
+		total += row.Hours
+	}
+	response := TimesheetReportResponse{
+		Success: true,
+		GroupBy: groupBy,
+		Period:  period,
+		Rows:    rows,
+		Hours:   math.Round(total*100) / 100,
+	}
+	if !filter.From.IsZero() {
+		response.From = filter.From.Format(time.DateOnly)
+	}
+	if !filter.To.IsZero() {
+		response.To = filter.To.Format(time.DateTime)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
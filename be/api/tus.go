@@ -0,0 +1,329 @@
+package api
+
+import (
+	"encoding/base64"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"rprj/be/dblayer"
+
+	"github.com/gorilla/mux"
+)
+
+/*
+Resumable uploads with the tus protocol, version 1.0.0 (https://tus.io),
+with the creation, expiration and termination extensions.
+
+POST /files/uploads declares an upload (Upload-Length, Upload-Metadata with
+filename, name, description, father_id and permissions) and returns its
+Location. PATCH sends a chunk at Upload-Offset, HEAD tells the offset
+reached, to resume after a failure. When the last byte arrives the upload
+becomes a DBFile, whose id is returned in Upload-File-Id (also by HEAD, until
+the upload expires).
+*/
+
+// TusVersion is the version of the tus protocol supported
+const TusVersion = "1.0.0"
+
+// tusExtensions are the extensions of the protocol supported
+const tusExtensions = "creation,expiration,termination"
+
+// tusContentType is the content type of the PATCH requests
+const tusContentType = "application/offset+octet-stream"
+
+// parseTusMetadata parses an Upload-Metadata header: comma separated pairs
+// of a key and a base64 value, the value may be missing
+func parseTusMetadata(header string) (map[string]string, error) {
+	metadata := map[string]string{}
+	for _, pair := range strings.Split(header, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, encoded, _ := strings.Cut(pair, " ")
+		value, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil {
+			return nil, errors.New("invalid metadata value for " + key)
+		}
+		metadata[key] = string(value)
+	}
+	return metadata, nil
+}
+
+// tusHeaders sets the headers common to the tus responses
+func tusHeaders(w http.ResponseWriter) {
+	w.Header().Set("Tus-Resumable", TusVersion)
+	w.Header().Set("Cache-Control", "no-store")
+}
+
+// tusUploadHeaders sets the state of an upload in the response
+func tusUploadHeaders(w http.ResponseWriter, upload *dblayer.FileUpload) {
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset(), 10))
+	w.Header().Set("Upload-Expires", upload.Expires().UTC().Format(http.TimeFormat))
+	if fileID := upload.FileID(); fileID != "" {
+		w.Header().Set("Upload-File-Id", fileID)
+	}
+}
+
+// tusResumable checks the version of the protocol of the client, responding
+// 412 when not supported
+func tusResumable(w http.ResponseWriter, r *http.Request) bool {
+	tusHeaders(w)
+	if r.Header.Get("Tus-Resumable") != TusVersion {
+		w.Header().Set("Tus-Version", TusVersion)
+		RespondSimpleError(w, ErrInvalidRequest, "Unsupported tus version", http.StatusPreconditionFailed)
+		return false
+	}
+	return true
+}
+
+// ownUpload loads an upload of the user, responding 404 or 410 otherwise
+func ownUpload(w http.ResponseWriter, r *http.Request, repo *dblayer.DBRepository) (*dblayer.FileUpload, bool) {
+	upload, err := repo.GetFileUpload(mux.Vars(r)["id"])
+	if errors.Is(err, dblayer.ErrFileUploadExpired) {
+		RespondSimpleError(w, ErrObjectNotFound, "Upload expired", http.StatusGone)
+		return nil, false
+	}
+	if err != nil {
+		RespondSimpleError(w, ErrObjectNotFound, "Upload not found", http.StatusNotFound)
+		return nil, false
+	}
+	return upload, true
+}
+
+// finishUpload creates the DBFile of a complete upload, from its metadata
+func finishUpload(w http.ResponseWriter, repo *dblayer.DBRepository, upload *dblayer.FileUpload) bool {
+	header, _ := upload.GetValue("metadata").(string)
+	metadata, _ := parseTusMetadata(header)
+	values := map[string]any{"filename": metadata["filename"]}
+	for _, key := range []string{"name", "description", "permissions"} {
+		if metadata[key] != "" {
+			values[key] = metadata[key]
+		}
+	}
+	if fatherID := normalizeObjectID(metadata["father_id"]); fatherID != "" {
+		values["father_id"] = fatherID
+	}
+	if len(repo.DbContext.GroupIDs) > 0 {
+		values["group_id"] = repo.DbContext.GroupIDs[0]
+	}
+	if _, ok := values["permissions"]; !ok {
+		values["permissions"] = "rwxr-x---"
+	}
+	created, err := repo.FinishFileUpload(upload, values)
+	if err != nil {
+		log.Printf("finishUpload: Failed to create the file of upload %s: %v", upload.GetValue("id"), err)
+		if respondValidationError(w, err) || respondScanError(w, err) || respondQuotaError(w, err) {
+			return false
+		}
+		RespondSimpleError(w, ErrInternalServer, "Failed to create file: "+err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	log.Printf("finishUpload: Created DBFile %s from upload %s", created.GetValue("id"), upload.GetValue("id"))
+	return true
+}
+
+// TusOptionsHandler godoc
+// @Summary Resumable upload capabilities
+// @Description Tells the tus version and extensions supported
+// @Tags files
+// @Success 204 "Capabilities in the Tus-Version and Tus-Extension headers"
+// @Router /files/uploads [options]
+func TusOptionsHandler(w http.ResponseWriter, r *http.Request) {
+	tusHeaders(w)
+	w.Header().Set("Tus-Version", TusVersion)
+	w.Header().Set("Tus-Extension", tusExtensions)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// CreateUploadHandler godoc
+// @Summary Start a resumable upload
+// @Description Creates a tus upload of Upload-Length bytes. Upload-Metadata may carry filename, name, description,
+// @Description father_id and permissions (base64 values) of the DBFile created at the end of the upload.
+// @Tags files
+// @Param Tus-Resumable header string true "1.0.0"
+// @Param Upload-Length header int true "Length of the file"
+// @Param Upload-Metadata header string false "tus metadata"
+// @Success 201 "Created, the URL of the upload is in Location"
+// @Failure 400 {object} ErrorResponse "Invalid Upload-Length or Upload-Metadata"
+// @Failure 403 {object} ErrorResponse "No write permission on the folder"
+// @Failure 412 {object} ErrorResponse "Unsupported tus version"
+// @Failure 413 {object} ErrorResponse "Storage quota exceeded"
+// @Security BearerAuth
+// @Router /files/uploads [post]
+func CreateUploadHandler(w http.ResponseWriter, r *http.Request) {
+	if !tusResumable(w, r) {
+		return
+	}
+	repo, ok := authenticatedRepository(w, r)
+	if !ok {
+		return
+	}
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		RespondSimpleError(w, ErrInvalidRequest, "Invalid or missing Upload-Length", http.StatusBadRequest)
+		return
+	}
+	header := r.Header.Get("Upload-Metadata")
+	metadata, err := parseTusMetadata(header)
+	if err != nil {
+		RespondSimpleError(w, ErrInvalidRequest, err.Error(), http.StatusBadRequest)
+		return
+	}
+	folderID, ok := writableFolder(w, repo, normalizeObjectID(metadata["father_id"]))
+	if !ok {
+		return
+	}
+	if !checkStorageQuota(w, repo, repo.DbContext.UserID, uploadGroupID(repo, folderID), length) {
+		return
+	}
+
+	upload, err := repo.CreateFileUpload(length, header)
+	if err != nil {
+		log.Printf("CreateUploadHandler: %v", err)
+		RespondSimpleError(w, ErrInternalServer, "Failed to create the upload", http.StatusInternalServerError)
+		return
+	}
+	// An empty file is complete already
+	if upload.IsComplete() && !finishUpload(w, repo, upload) {
+		return
+	}
+	// Relative to the URL of the request, whatever the prefix of the proxy
+	w.Header().Set("Location", "uploads/"+upload.GetValue("id").(string))
+	tusUploadHeaders(w, upload)
+	w.WriteHeader(http.StatusCreated)
+}
+
+// HeadUploadHandler godoc
+// @Summary Status of a resumable upload
+// @Description Returns the offset reached by an upload in Upload-Offset, and the id of its DBFile in Upload-File-Id
+// @Description once complete
+// @Tags files
+// @Param id path string true "Upload ID"
+// @Param Tus-Resumable header string true "1.0.0"
+// @Success 200 "Status in the headers"
+// @Failure 404 {object} ErrorResponse "Upload not found"
+// @Failure 410 {object} ErrorResponse "Upload expired"
+// @Security BearerAuth
+// @Router /files/uploads/{id} [head]
+func HeadUploadHandler(w http.ResponseWriter, r *http.Request) {
+	if !tusResumable(w, r) {
+		return
+	}
+	repo, ok := authenticatedRepository(w, r)
+	if !ok {
+		return
+	}
+	upload, ok := ownUpload(w, r, repo)
+	if !ok {
+		return
+	}
+	tusUploadHeaders(w, upload)
+	w.Header().Set("Upload-Length", strconv.FormatInt(upload.Length(), 10))
+	if metadata, _ := upload.GetValue("metadata").(string); metadata != "" {
+		w.Header().Set("Upload-Metadata", metadata)
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// PatchUploadHandler godoc
+// @Summary Send a chunk of a resumable upload
+// @Description Appends the body to the upload, at Upload-Offset. The last chunk creates the DBFile, its id is
+// @Description returned in Upload-File-Id. A chunk interrupted is kept up to the last byte received.
+// @Tags files
+// @Accept application/offset+octet-stream
+// @Param id path string true "Upload ID"
+// @Param Tus-Resumable header string true "1.0.0"
+// @Param Upload-Offset header int true "Offset of the chunk"
+// @Success 204 "Chunk stored, the new offset is in Upload-Offset"
+// @Failure 404 {object} ErrorResponse "Upload not found"
+// @Failure 409 {object} ErrorResponse "Upload-Offset does not match the upload"
+// @Failure 410 {object} ErrorResponse "Upload expired"
+// @Failure 413 {object} ErrorResponse "Chunk beyond Upload-Length, or storage quota exceeded"
+// @Failure 415 {object} ErrorResponse "Invalid Content-Type"
+// @Security BearerAuth
+// @Router /files/uploads/{id} [patch]
+func PatchUploadHandler(w http.ResponseWriter, r *http.Request) {
+	if !tusResumable(w, r) {
+		return
+	}
+	repo, ok := authenticatedRepository(w, r)
+	if !ok {
+		return
+	}
+	if r.Header.Get("Content-Type") != tusContentType {
+		RespondSimpleError(w, ErrInvalidRequest, "Content-Type must be "+tusContentType, http.StatusUnsupportedMediaType)
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		RespondSimpleError(w, ErrInvalidRequest, "Invalid or missing Upload-Offset", http.StatusBadRequest)
+		return
+	}
+	upload, ok := ownUpload(w, r, repo)
+	if !ok {
+		return
+	}
+
+	if upload.FileID() == "" {
+		_, err = repo.AppendFileUpload(upload, offset, r.Body)
+		switch {
+		case errors.Is(err, dblayer.ErrFileUploadOffset):
+			w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset(), 10))
+			RespondSimpleError(w, ErrInvalidRequest, "Upload-Offset does not match the upload", http.StatusConflict)
+			return
+		case errors.Is(err, dblayer.ErrFileUploadTooLarge):
+			RespondSimpleError(w, ErrInvalidRequest, "Chunk beyond Upload-Length", http.StatusRequestEntityTooLarge)
+			return
+		case err != nil:
+			// Resumable from the offset reached
+			log.Printf("PatchUploadHandler: upload %s interrupted at %d: %v", upload.GetValue("id"), upload.Offset(), err)
+			tusUploadHeaders(w, upload)
+			RespondSimpleError(w, ErrInternalServer, "Upload interrupted", http.StatusInternalServerError)
+			return
+		}
+		if upload.IsComplete() && !finishUpload(w, repo, upload) {
+			return
+		}
+	} else if offset != upload.Offset() {
+		// Finished already: only the retry of the last chunk is accepted
+		w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset(), 10))
+		RespondSimpleError(w, ErrInvalidRequest, "Upload-Offset does not match the upload", http.StatusConflict)
+		return
+	}
+	tusUploadHeaders(w, upload)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DeleteUploadHandler godoc
+// @Summary Cancel a resumable upload
+// @Description Deletes an upload and the chunks received (the DBFile of a complete upload is kept)
+// @Tags files
+// @Param id path string true "Upload ID"
+// @Param Tus-Resumable header string true "1.0.0"
+// @Success 204 "Deleted"
+// @Failure 404 {object} ErrorResponse "Upload not found"
+// @Security BearerAuth
+// @Router /files/uploads/{id} [delete]
+func DeleteUploadHandler(w http.ResponseWriter, r *http.Request) {
+	if !tusResumable(w, r) {
+		return
+	}
+	repo, ok := authenticatedRepository(w, r)
+	if !ok {
+		return
+	}
+	upload, ok := ownUpload(w, r, repo)
+	if !ok {
+		return
+	}
+	if err := repo.DeleteFileUpload(upload); err != nil {
+		log.Printf("DeleteUploadHandler: %v", err)
+		RespondSimpleError(w, ErrInternalServer, "Failed to delete the upload", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
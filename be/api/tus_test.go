@@ -0,0 +1,25 @@
+package api
+
+import (
+	"testing"
+)
+
+func TestParseTusMetadata(t *testing.T) {
+	// filename "photo 1.jpg", father_id "f00d", is_draft without value
+	metadata, err := parseTusMetadata("filename cGhvdG8gMS5qcGc=, father_id ZjAwZA==,is_draft")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metadata["filename"] != "photo 1.jpg" || metadata["father_id"] != "f00d" {
+		t.Errorf("got %v", metadata)
+	}
+	if value, ok := metadata["is_draft"]; !ok || value != "" {
+		t.Errorf("got %v", metadata)
+	}
+	if metadata, err := parseTusMetadata(""); err != nil || len(metadata) != 0 {
+		t.Errorf("got %v %v", metadata, err)
+	}
+	if _, err := parseTusMetadata("filename not-base64!"); err == nil {
+		t.Error("expected an error")
+	}
+}
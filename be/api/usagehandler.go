@@ -0,0 +1,266 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"rprj/be/dblayer"
+
+	"github.com/gorilla/mux"
+)
+
+// StorageUsageResponse godoc
+// @Description Response structure of the storage usage of the current user and of their groups
+type StorageUsageResponse struct {
+	Success bool                       `json:"success"`
+	User    dblayer.StorageUsageInfo   `json:"user"`
+	Groups  []dblayer.StorageUsageInfo `json:"groups"`
+}
+
+// StorageUsageListResponse godoc
+// @Description Response structure of the storage usage of every user and group
+type StorageUsageListResponse struct {
+	Success bool                       `json:"success"`
+	Usage   []dblayer.StorageUsageInfo `json:"usage"`
+}
+
+// StorageRecomputeResponse godoc
+// @Description Response structure of a recompute of the storage usage
+type StorageRecomputeResponse struct {
+	Success bool                        `json:"success"`
+	Result  *dblayer.StorageUsageReport `json:"result"`
+}
+
+// StorageQuotaRequest godoc
+// @Description Quota in bytes of a user or a group (0 = unlimited, null = the default of the configuration)
+type StorageQuotaRequest struct {
+	Quota *int64 `json:"quota"`
+}
+
+// storageUsageKinds maps the kinds of the routes to the kinds of StorageUsage
+var storageUsageKinds = map[string]string{
+	"users":  dblayer.StorageUsageUser,
+	"groups": dblayer.StorageUsageGroup,
+}
+
+// respondQuotaError sends a *dblayer.QuotaExceededError as QUOTA_EXCEEDED (413)
+func respondQuotaError(w http.ResponseWriter, err error) bool {
+	var quotaError *dblayer.QuotaExceededError
+	if !errors.As(err, &quotaError) {
+		return false
+	}
+	kind := "user"
+	if quotaError.Kind == dblayer.StorageUsageGroup {
+		kind = "group"
+	}
+	RespondError(w, ErrQuotaExceeded, quotaError.Error(), map[string]string{
+		"kind":  kind,
+		"quota": strconv.FormatInt(quotaError.Quota, 10),
+		"used":  strconv.FormatInt(quotaError.Used, 10),
+		"size":  strconv.FormatInt(quotaError.Size, 10),
+	}, http.StatusRequestEntityTooLarge)
+	return true
+}
+
+// checkStorageQuota checks that additional bytes fit in the quotas of the
+// owner and of the group of a file, before receiving its upload. Responds
+// QUOTA_EXCEEDED (413) otherwise. The hooks of the file check again when
+// storing it.
+func checkStorageQuota(w http.ResponseWriter, repo *dblayer.DBRepository, owner string, groupID string, additional int64) bool {
+	err := repo.CheckStorageQuota(owner, groupID, additional)
+	if err == nil {
+		return true
+	}
+	if !respondQuotaError(w, err) {
+		log.Printf("checkStorageQuota: %v", err)
+		RespondSimpleError(w, ErrInternalServer, "Failed to read the storage usage", http.StatusInternalServerError)
+	}
+	return false
+}
+
+// uploadGroupID returns the group a new file will belong to: the group of its
+// folder, when given, the first group of the user otherwise
+func uploadGroupID(repo *dblayer.DBRepository, fatherID string) string {
+	if fatherID != "" {
+		if father := repo.ObjectByID(fatherID, true); father != nil {
+			if groupID, ok := father.GetValue("group_id").(string); ok {
+				return groupID
+			}
+		}
+	}
+	if len(repo.DbContext.GroupIDs) > 0 {
+		return repo.DbContext.GroupIDs[0]
+	}
+	return ""
+}
+
+// GetStorageUsageHandler godoc
+// @Summary Storage usage of the current user
+// @Description Returns the bytes and the files stored by the current user and by each of their groups, with the
+// @Description quotas in effect (0 = unlimited). Files in the trash count, archived revisions do not.
+// @Tags files
+// @Produce json
+// @Success 200 {object} StorageUsageResponse "Storage usage"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Security BearerAuth
+// @Router /files/storage/usage [get]
+func GetStorageUsageHandler(w http.ResponseWriter, r *http.Request) {
+	repo, ok := authenticatedRepository(w, r)
+	if !ok {
+		return
+	}
+	user, err := repo.GetStorageUsage(dblayer.StorageUsageUser, repo.DbContext.UserID)
+	if err != nil {
+		log.Printf("GetStorageUsageHandler: %v", err)
+		RespondSimpleError(w, ErrInternalServer, "Failed to read the storage usage", http.StatusInternalServerError)
+		return
+	}
+	groups := []dblayer.StorageUsageInfo{}
+	for _, groupID := range repo.DbContext.GroupIDs {
+		if groupID == "" {
+			continue
+		}
+		group, err := repo.GetStorageUsage(dblayer.StorageUsageGroup, groupID)
+		if err != nil {
+			log.Printf("GetStorageUsageHandler: %v", err)
+			RespondSimpleError(w, ErrInternalServer, "Failed to read the storage usage", http.StatusInternalServerError)
+			return
+		}
+		groups = append(groups, group)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(StorageUsageResponse{
+		Success: true,
+		User:    user,
+		Groups:  groups,
+	})
+}
+
+// ListStorageUsageHandler godoc
+// @Summary Storage usage of every user and group
+// @Description Returns the bytes and the files stored by every user and group, largest first, with their quotas.
+// @Description Admin only.
+// @Tags files
+// @Produce json
+// @Success 200 {object} StorageUsageListResponse "Storage usage"
+// @Failure 403 {object} ErrorResponse "Forbidden"
+// @Security BearerAuth
+// @Router /files/storage/usage/all [get]
+func ListStorageUsageHandler(w http.ResponseWriter, r *http.Request) {
+	repo, ok := authenticatedRepository(w, r)
+	if !ok {
+		return
+	}
+	if !isAdminRepository(repo) {
+		RespondSimpleError(w, ErrForbidden, "Only administrators can read the storage usage of everybody", http.StatusForbidden)
+		return
+	}
+	usage, err := repo.ListStorageUsage()
+	if err != nil {
+		log.Printf("ListStorageUsageHandler: %v", err)
+		RespondSimpleError(w, ErrInternalServer, "Failed to read the storage usage", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(StorageUsageListResponse{
+		Success: true,
+		Usage:   usage,
+	})
+}
+
+// SetStorageQuotaHandler godoc
+// @Summary Set the quota of a user or a group
+// @Description Sets the storage quota in bytes of a user or a group: 0 is unlimited, null restores the default of
+// @Description the configuration. Admin only.
+// @Tags files
+// @Accept json
+// @Produce json
+// @Param kind path string true "users or groups"
+// @Param id path string true "User or group ID"
+// @Param quota body StorageQuotaRequest true "Quota"
+// @Success 200 {object} StorageUsageListResponse "Updated storage usage"
+// @Failure 400 {object} ErrorResponse "Invalid request"
+// @Failure 403 {object} ErrorResponse "Forbidden"
+// @Security BearerAuth
+// @Router /files/storage/quota/{kind}/{id} [put]
+func SetStorageQuotaHandler(w http.ResponseWriter, r *http.Request) {
+	repo, ok := authenticatedRepository(w, r)
+	if !ok {
+		return
+	}
+	if !isAdminRepository(repo) {
+		RespondSimpleError(w, ErrForbidden, "Only administrators can set the quotas", http.StatusForbidden)
+		return
+	}
+	vars := mux.Vars(r)
+	kind, ok := storageUsageKinds[vars["kind"]]
+	if !ok {
+		RespondError(w, ErrInvalidRequest, "Invalid kind, users or groups expected", map[string]string{"field": "kind"}, http.StatusBadRequest)
+		return
+	}
+	var request StorageQuotaRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		RespondSimpleError(w, ErrInvalidRequest, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if request.Quota != nil && *request.Quota < 0 {
+		RespondError(w, ErrInvalidRequest, "Invalid quota", map[string]string{"field": "quota"}, http.StatusBadRequest)
+		return
+	}
+	if err := repo.SetStorageQuota(kind, vars["id"], request.Quota); err != nil {
+		log.Printf("SetStorageQuotaHandler: %v", err)
+		RespondSimpleError(w, ErrInternalServer, "Failed to set the quota", http.StatusInternalServerError)
+		return
+	}
+	usage, err := repo.GetStorageUsage(kind, vars["id"])
+	if err != nil {
+		log.Printf("SetStorageQuotaHandler: %v", err)
+		RespondSimpleError(w, ErrInternalServer, "Failed to read the storage usage", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(StorageUsageListResponse{
+		Success: true,
+		Usage:   []dblayer.StorageUsageInfo{usage},
+	})
+}
+
+// RecomputeStorageUsageHandler godoc
+// @Summary Recompute the storage usage
+// @Description Reads the size of every blob into its file, then rebuilds the storage usage of every user and group
+// @Description from the files. Run it after upgrading, for the files stored before the usage was tracked. The quotas
+// @Description are kept. Admin only.
+// @Tags files
+// @Produce json
+// @Success 200 {object} StorageRecomputeResponse "Recompute report"
+// @Failure 403 {object} ErrorResponse "Forbidden"
+// @Security BearerAuth
+// @Router /files/storage/usage/recompute [post]
+func RecomputeStorageUsageHandler(w http.ResponseWriter, r *http.Request) {
+	repo, ok := authenticatedRepository(w, r)
+	if !ok {
+		return
+	}
+	if !isAdminRepository(repo) {
+		RespondSimpleError(w, ErrForbidden, "Only administrators can recompute the storage usage", http.StatusForbidden)
+		return
+	}
+	result, err := repo.RecomputeStorageUsage()
+	if err != nil {
+		log.Printf("RecomputeStorageUsageHandler: %v", err)
+		RespondSimpleError(w, ErrInternalServer, "Failed to recompute the storage usage", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(StorageRecomputeResponse{
+		Success: true,
+		Result:  result,
+	})
+}
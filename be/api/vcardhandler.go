@@ -0,0 +1,173 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"rprj/be/dblayer"
+
+	"github.com/gorilla/mux"
+)
+
+// maxVCardSize is the largest vCard file accepted by the import
+const maxVCardSize = 10 << 20
+
+// ContactsImportResponse godoc
+// @Description Response structure of a vCard import
+type ContactsImportResponse struct {
+	Success bool                       `json:"success"`
+	Result  *dblayer.VCardImportResult `json:"result"`
+}
+
+// vcardVersion reads the version parameter: 3.0 (default) or 4.0
+func vcardVersion(w http.ResponseWriter, r *http.Request) (string, bool) {
+	switch r.URL.Query().Get("version") {
+	case "", "3", dblayer.VCardVersion3:
+		return dblayer.VCardVersion3, true
+	case "4", dblayer.VCardVersion4:
+		return dblayer.VCardVersion4, true
+	}
+	RespondError(w, ErrInvalidRequest, "Invalid vCard version", map[string]string{"field": "version"}, http.StatusBadRequest)
+	return "", false
+}
+
+// writeVCards sends vCards as a download
+func writeVCards(w http.ResponseWriter, filename string, data string) {
+	w.Header().Set("Content-Type", "text/vcard; charset=utf-8")
+	w.Header().Set("Content-Disposition", "attachment; filename=\""+filename+"\"")
+	w.WriteHeader(http.StatusOK)
+	io.WriteString(w, data)
+}
+
+// GetContactVCardHandler godoc
+// @Summary Export a contact as vCard
+// @Description Returns a person or a company as vCard, with the name of its country and of the company of a person
+// @Tags contacts
+// @Produce text/vcard
+// @Param id path string true "Person or company ID"
+// @Param version query string false "vCard version: 3.0 (default) or 4.0"
+// @Success 200 {string} string "vCard"
+// @Failure 400 {object} ErrorResponse "Not a contact"
+// @Failure 404 {object} ErrorResponse "Contact not found"
+// @Security BearerAuth
+// @Router /contacts/{id}/vcard [get]
+func GetContactVCardHandler(w http.ResponseWriter, r *http.Request) {
+	repo, ok := authenticatedRepository(w, r)
+	if !ok {
+		return
+	}
+	version, ok := vcardVersion(w, r)
+	if !ok {
+		return
+	}
+	contact := repo.FullObjectById(normalizeObjectID(mux.Vars(r)["id"]), true)
+	if contact == nil || !repo.CheckReadPermission(contact) {
+		RespondSimpleError(w, ErrObjectNotFound, "Contact not found", http.StatusNotFound)
+		return
+	}
+	if contact.GetTypeName() != "DBPerson" && contact.GetTypeName() != "DBCompany" {
+		RespondSimpleError(w, ErrInvalidRequest, "Not a person or a company", http.StatusBadRequest)
+		return
+	}
+	name, _ := contact.GetValue("name").(string)
+	writeVCards(w, vcardFilename(name), repo.ContactVCard(contact, version))
+}
+
+// vcardFilename returns a safe file name for the vCard of a contact
+func vcardFilename(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r == '"' || r == '/' || r == '\\' || r < ' ' {
+			return '_'
+		}
+		return r
+	}, strings.TrimSpace(name))
+	if name == "" {
+		name = "contact"
+	}
+	return name + ".vcf"
+}
+
+// GetFolderVCardsHandler godoc
+// @Summary Export the contacts of a folder as vCard
+// @Description Returns the readable people and companies of a folder in a single vCard file
+// @Tags contacts
+// @Produce text/vcard
+// @Param folderId path string true "Folder ID"
+// @Param version query string false "vCard version: 3.0 (default) or 4.0"
+// @Success 200 {string} string "vCards"
+// @Failure 404 {object} ErrorResponse "Folder not found"
+// @Security BearerAuth
+// @Router /contacts/folder/{folderId}/vcard [get]
+func GetFolderVCardsHandler(w http.ResponseWriter, r *http.Request) {
+	repo, ok := authenticatedRepository(w, r)
+	if !ok {
+		return
+	}
+	version, ok := vcardVersion(w, r)
+	if !ok {
+		return
+	}
+	folderID := normalizeObjectID(mux.Vars(r)["folderId"])
+	folder := repo.ObjectByID(folderID, true)
+	if folder == nil || !repo.CheckReadPermission(folder) {
+		RespondSimpleError(w, ErrObjectNotFound, "Folder not found", http.StatusNotFound)
+		return
+	}
+	contacts, err := repo.GetFolderContacts(folderID)
+	if err != nil {
+		log.Printf("GetFolderVCardsHandler: %v", err)
+		RespondSimpleError(w, ErrInternalServer, "Failed to read contacts", http.StatusInternalServerError)
+		return
+	}
+	var sb strings.Builder
+	for _, contact := range contacts {
+		sb.WriteString(repo.ContactVCard(contact, version))
+	}
+	name, _ := folder.GetValue("name").(string)
+	writeVCards(w, vcardFilename(name), sb.String())
+}
+
+// ImportVCardsHandler godoc
+// @Summary Import a vCard file
+// @Description Creates or updates people and companies from a vCard (2.1, 3.0 or 4.0) file. Existing contacts are matched by email, new ones are created in the folder.
+// @Description Countries are resolved by name or ISO code, people are linked to their company (ORG) by name.
+// @Description The file is the request body (text/vcard) or the "file" field of a multipart form.
+// @Tags contacts
+// @Accept text/vcard
+// @Accept multipart/form-data
+// @Produce json
+// @Param folder query string false "Folder of the new contacts"
+// @Param file formData file false "vCard file"
+// @Success 200 {object} ContactsImportResponse "Import summary"
+// @Failure 400 {object} ErrorResponse "Invalid vCard file"
+// @Failure 403 {object} ErrorResponse "Folder not writable"
+// @Security BearerAuth
+// @Router /contacts/import [post]
+func ImportVCardsHandler(w http.ResponseWriter, r *http.Request) {
+	repo, ok := authenticatedRepository(w, r)
+	if !ok {
+		return
+	}
+	folderID, ok := importFolder(w, r, repo)
+	if !ok {
+		return
+	}
+	data, ok := readImportFile(w, r, maxVCardSize)
+	if !ok {
+		return
+	}
+	result, err := repo.ImportVCards(string(data), folderID)
+	if err != nil {
+		RespondSimpleError(w, ErrInvalidRequest, "Invalid vCard file: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(ContactsImportResponse{
+		Success: true,
+		Result:  result,
+	})
+}
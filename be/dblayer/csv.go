@@ -0,0 +1,415 @@
+package dblayer
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+/*
+CSV export and import of the entities of any registered class. The CSV
+columns are the columns of the class, in their declaration order.
+*/
+
+// Codes of the errors of the rows of a CSV import
+const (
+	CSVErrorInvalidValue  = "INVALID_VALUE"
+	CSVErrorAmbiguousKey  = "AMBIGUOUS_KEY"
+	CSVErrorNotWritable   = "NOT_WRITABLE"
+	CSVErrorRejected      = "REJECTED"
+	CSVErrorMissingColumn = "MISSING_COLUMN"
+)
+
+// csvReadOnlyColumns are maintained by the repository and never imported: the
+// new objects belong to the importing user and its group
+var csvReadOnlyColumns = []string{"owner", "group_id", "creator", "creation_date", "last_modify", "last_modify_date", "deleted_by", "deleted_date"}
+
+// CSVImportOptions describes a CSV import
+type CSVImportOptions struct {
+	ClassName string
+	// Mapping from the CSV header to the column of the class; when empty, the
+	// headers matching a column name (case insensitive) are imported
+	Mapping map[string]string
+	// Column matching the existing rows to update; when empty, every row is created
+	Key string
+	// Folder of the new rows, and scope of the key lookup
+	FatherID string
+	// Validate the rows and roll back instead of committing
+	DryRun bool
+}
+
+// CSVRowError is a rejected row of a CSV import
+type CSVRowError struct {
+	// Line of the CSV file, the header is line 1
+	Row     int    `json:"row"`
+	Column  string `json:"column,omitempty"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// CSVImportReport summarizes a CSV import, or what it would do when dry run
+type CSVImportReport struct {
+	DryRun  bool              `json:"dry_run"`
+	Rows    int               `json:"rows"`
+	Created int               `json:"created"`
+	Updated int               `json:"updated"`
+	Skipped int               `json:"skipped"`
+	Mapping map[string]string `json:"mapping"`
+	Ignored []string          `json:"ignored"`
+	Errors  []CSVRowError     `json:"errors"`
+}
+
+// CSVHeader returns the CSV header of a class: the names of its columns
+func CSVHeader(instance DBEntityInterface) []string {
+	columns := instance.GetColumns()
+	header := make([]string, 0, len(columns))
+	for _, column := range columns {
+		header = append(header, column.Name)
+	}
+	return header
+}
+
+// WriteCSV writes the entities as CSV with the columns of instance, a NULL is an empty cell
+func WriteCSV(w io.Writer, instance DBEntityInterface, entities []DBEntityInterface) error {
+	writer := csv.NewWriter(w)
+	header := CSVHeader(instance)
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+	record := make([]string, len(header))
+	for _, entity := range entities {
+		for i, column := range header {
+			record[i] = stringValue(entity, column)
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// columnSize returns the size of a column type like varchar(255), 0 when unsized
+func columnSize(columnType string) int {
+	open := strings.IndexByte(columnType, '(')
+	if open < 0 || !strings.HasSuffix(columnType, ")") {
+		return 0
+	}
+	size, _ := strconv.Atoi(columnType[open+1 : len(columnType)-1])
+	return size
+}
+
+// ParseCSVValue validates a CSV cell for a column and returns it in the format
+// of the database: dates and times are normalized, decimal commas accepted
+func ParseCSVValue(column Column, value string) (string, error) {
+	columnType := strings.ToLower(column.Type)
+	baseType := columnType
+	if open := strings.IndexByte(baseType, '('); open >= 0 {
+		baseType = baseType[:open]
+	}
+	switch baseType {
+	case "char", "varchar":
+		if size := columnSize(columnType); size > 0 && utf8.RuneCountInString(value) > size {
+			return "", fmt.Errorf("longer than %d characters", size)
+		}
+	case "tinyint":
+		switch strings.ToLower(value) {
+		case "1", "true", "yes", "y", "si", "sì":
+			return "1", nil
+		case "0", "false", "no", "n":
+			return "0", nil
+		}
+		if _, err := strconv.Atoi(value); err != nil {
+			return "", fmt.Errorf("not a number")
+		}
+	case "int", "smallint", "bigint":
+		if _, err := strconv.Atoi(value); err != nil {
+			return "", fmt.Errorf("not an integer")
+		}
+	case "float", "double", "decimal":
+		value = strings.Replace(value, ",", ".", 1)
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			return "", fmt.Errorf("not a number")
+		}
+	case "date":
+		t, err := ParseDateTime(value)
+		if err != nil {
+			return "", fmt.Errorf("not a date")
+		}
+		return t.Format(time.DateOnly), nil
+	case "datetime":
+		t, err := ParseDateTime(value)
+		if err != nil {
+			return "", fmt.Errorf("not a datetime")
+		}
+		return t.Format(time.DateTime), nil
+	case "time":
+		for _, layout := range []string{time.TimeOnly, "15:04"} {
+			if t, err := time.Parse(layout, value); err == nil {
+				return t.Format(time.TimeOnly), nil
+			}
+		}
+		return "", fmt.Errorf("not a time")
+	}
+	return value, nil
+}
+
+// CSVMapping resolves the mapping of a CSV import against the header of the
+// file and the columns of the class. Returns the column of each CSV field
+// ("" when ignored) and the ignored fields. The folder and the columns
+// maintained by the repository are never imported.
+func CSVMapping(instance DBEntityInterface, header []string, mapping map[string]string) ([]string, []string, error) {
+	columnNames := make(map[string]string)
+	for _, column := range instance.GetColumns() {
+		columnNames[strings.ToLower(column.Name)] = column.Name
+	}
+	fields := make([]string, len(header))
+	ignored := []string{}
+	used := make(map[string]string)
+	for i, name := range header {
+		column := columnNames[strings.ToLower(name)]
+		if len(mapping) > 0 {
+			target, ok := mapping[name]
+			if !ok {
+				ignored = append(ignored, name)
+				continue
+			}
+			if column = columnNames[strings.ToLower(strings.TrimSpace(target))]; column == "" {
+				return nil, nil, fmt.Errorf("unknown column %s of %s", target, instance.GetTypeName())
+			}
+		}
+		if column == "" || column == "father_id" || slices.Contains(csvReadOnlyColumns, column) {
+			ignored = append(ignored, name)
+			continue
+		}
+		if previous, ok := used[column]; ok {
+			return nil, nil, fmt.Errorf("fields %s and %s are both mapped to %s", previous, name, column)
+		}
+		used[column] = name
+		fields[i] = column
+	}
+	for name := range mapping {
+		if !slices.Contains(header, name) {
+			return nil, nil, fmt.Errorf("field %s not found in the CSV header", name)
+		}
+	}
+	if len(used) == 0 {
+		return nil, nil, fmt.Errorf("no CSV field matches a column of %s", instance.GetTypeName())
+	}
+	return fields, ignored, nil
+}
+
+// ImportCSV creates or updates entities of a class from a CSV file with a
+// header. The rows whose key column matches exactly one existing entity
+// (in the folder, when given) update it, the others create a new entity.
+// Empty cells leave the column unchanged. The whole import runs in a
+// transaction: a rejected row is rolled back alone and reported, a dry run
+// rolls everything back so the report shows the outcome without writing.
+func (dbr *DBRepository) ImportCSV(data []byte, options CSVImportOptions) (*CSVImportReport, error) {
+	instance := dbr.GetInstanceByClassName(options.ClassName)
+	if instance == nil {
+		return nil, fmt.Errorf("unknown class %s", options.ClassName)
+	}
+	if options.FatherID != "" && instance.GetColumnType("father_id") == "" {
+		return nil, fmt.Errorf("%s has no folder", options.ClassName)
+	}
+
+	reader := csv.NewReader(strings.NewReader(string(data)))
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	if firstLine, _, _ := strings.Cut(string(data), "\n"); strings.Count(firstLine, ";") > strings.Count(firstLine, ",") {
+		// Spreadsheets with the decimal comma export with semicolons
+		reader.Comma = ';'
+	}
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("invalid CSV header: %w", err)
+	}
+	for i, name := range header {
+		header[i] = strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))
+	}
+	fields, ignored, err := CSVMapping(instance, header, options.Mapping)
+	if err != nil {
+		return nil, err
+	}
+	keyField := -1
+	if options.Key != "" {
+		for i, column := range fields {
+			if column == options.Key {
+				keyField = i
+			}
+		}
+		if keyField < 0 {
+			return nil, fmt.Errorf("key column %s is not imported", options.Key)
+		}
+	}
+
+	report := &CSVImportReport{
+		DryRun:  options.DryRun,
+		Mapping: make(map[string]string),
+		Ignored: ignored,
+		Errors:  []CSVRowError{},
+	}
+	for i, column := range fields {
+		if column != "" {
+			report.Mapping[header[i]] = column
+		}
+	}
+
+	tx, err := dbr.DbConnection.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if len(record) == 1 && strings.TrimSpace(record[0]) == "" {
+			continue
+		}
+		report.Rows++
+		if rowError := dbr.importCSVRow(instance, fields, keyField, record, options, report, tx); rowError != nil {
+			rowError.Row = line
+			report.Skipped++
+			report.Errors = append(report.Errors, *rowError)
+		}
+	}
+
+	if options.DryRun {
+		return report, nil
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// importCSVRow creates or updates the entity of a CSV record in a savepoint,
+// so that a rejected row does not affect the others
+func (dbr *DBRepository) importCSVRow(instance DBEntityInterface, fields []string, keyField int, record []string, options CSVImportOptions, report *CSVImportReport, tx *sql.Tx) *CSVRowError {
+	values := make(map[string]string)
+	for i, column := range fields {
+		if column == "" || i >= len(record) {
+			continue
+		}
+		value := strings.TrimSpace(record[i])
+		if value == "" {
+			continue
+		}
+		parsed, err := ParseCSVValue(columnOf(instance, column), value)
+		if err != nil {
+			return &CSVRowError{Column: column, Code: CSVErrorInvalidValue, Message: err.Error()}
+		}
+		values[column] = parsed
+	}
+
+	var entity DBEntityInterface
+	if keyField >= 0 {
+		keyValue, ok := values[options.Key]
+		if !ok {
+			return &CSVRowError{Column: options.Key, Code: CSVErrorMissingColumn, Message: "the key is empty"}
+		}
+		search := dbr.GetInstanceByClassName(options.ClassName)
+		search.SetValue(options.Key, keyValue)
+		if options.FatherID != "" {
+			search.SetValue("father_id", options.FatherID)
+		}
+		results, err := dbr.searchWithTx(search, false, false, "", tx)
+		if err != nil {
+			return &CSVRowError{Column: options.Key, Code: CSVErrorRejected, Message: err.Error()}
+		}
+		matches := []DBEntityInterface{}
+		for _, result := range results {
+			if object, ok := result.(DBObjectInterface); ok && object.HasDeletedDate() {
+				continue
+			}
+			matches = append(matches, result)
+		}
+		if len(matches) > 1 {
+			return &CSVRowError{Column: options.Key, Code: CSVErrorAmbiguousKey, Message: fmt.Sprintf("%d rows match %s", len(matches), keyValue)}
+		}
+		if len(matches) == 1 {
+			entity = matches[0]
+			if !dbr.CheckReadPermission(entity) || !dbr.CheckWritePermission(entity) {
+				return &CSVRowError{Column: options.Key, Code: CSVErrorNotWritable, Message: "the matching row is not writable"}
+			}
+		}
+	}
+	existing := entity != nil
+	if !existing {
+		entity = dbr.GetInstanceByClassName(options.ClassName)
+		if entity.IsDBObject() {
+			// The id of a new object is generated
+			delete(values, "id")
+			if _, ok := values["permissions"]; !ok {
+				entity.SetValue("permissions", "rwxr-x---")
+			}
+			if dbr.DbContext != nil {
+				entity.SetValue("owner", dbr.DbContext.UserID)
+				if len(dbr.DbContext.GroupIDs) > 0 {
+					entity.SetValue("group_id", dbr.DbContext.GroupIDs[0])
+				}
+			}
+		}
+		if options.FatherID != "" {
+			entity.SetValue("father_id", options.FatherID)
+		}
+	}
+	for column, value := range values {
+		if existing && entity.IsPrimaryKey(column) {
+			continue
+		}
+		entity.SetValue(column, value)
+	}
+
+	if _, err := tx.Exec("SAVEPOINT csv_row"); err != nil {
+		return &CSVRowError{Code: CSVErrorRejected, Message: err.Error()}
+	}
+	var err error
+	if existing {
+		_, err = dbr.updateWithTx(entity, tx)
+	} else {
+		_, err = dbr.insertWithTx(entity, tx)
+	}
+	if err != nil {
+		tx.Exec("ROLLBACK TO SAVEPOINT csv_row")
+		rowError := &CSVRowError{Code: CSVErrorRejected, Message: err.Error()}
+		var validationError *ValidationError
+		if errors.As(err, &validationError) {
+			rowError.Column = validationError.Field
+			rowError.Code = validationError.Code
+			rowError.Message = validationError.Message
+		}
+		return rowError
+	}
+	tx.Exec("RELEASE SAVEPOINT csv_row")
+	if existing {
+		report.Updated++
+	} else {
+		report.Created++
+	}
+	return nil
+}
+
+// columnOf returns the definition of a column of an entity
+func columnOf(dbe DBEntityInterface, name string) Column {
+	for _, column := range dbe.GetColumns() {
+		if column.Name == name {
+			return column
+		}
+	}
+	return Column{Name: name}
+}
@@ -0,0 +1,114 @@
+package dblayer
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestGetColumnsOrder(t *testing.T) {
+	header := CSVHeader(NewDBPerson().NewInstance())
+	if len(header) < 10 || !slices.Equal(header[:3], []string{"id", "owner", "group_id"}) {
+		t.Errorf("got %v", header)
+	}
+	if !slices.Equal(CSVHeader(NewDBCountry().NewInstance()), CSVHeader(NewDBCountry())) {
+		t.Error("NewInstance changed the column order")
+	}
+}
+
+func TestParseCSVValue(t *testing.T) {
+	cases := []struct {
+		columnType string
+		value      string
+		want       string
+		ok         bool
+	}{
+		{"varchar(5)", "città", "città", true},
+		{"varchar(5)", "città!", "", false},
+		{"int(11)", "42", "42", true},
+		{"int(11)", "4.2", "", false},
+		{"float", "3,5", "3.5", true},
+		{"tinyint(1)", "Yes", "1", true},
+		{"date", "2024-03-01", "2024-03-01", true},
+		{"datetime", "2024-03-01T10:30", "2024-03-01 10:30:00", true},
+		{"datetime", "01/03/2024", "", false},
+		{"time", "9:05", "09:05:00", true},
+		{"text", "anything", "anything", true},
+	}
+	for _, c := range cases {
+		got, err := ParseCSVValue(Column{Name: "c", Type: c.columnType}, c.value)
+		if (err == nil) != c.ok || got != c.want {
+			t.Errorf("%s %q: got %q, %v", c.columnType, c.value, got, err)
+		}
+	}
+}
+
+func TestCSVMapping(t *testing.T) {
+	person := NewDBPerson()
+	fields, ignored, err := CSVMapping(person, []string{"Name", "EMAIL", "notes", "creation_date", "father_id", "Owner", "group_id"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(fields, []string{"name", "email", "", "", "", "", ""}) ||
+		!slices.Equal(ignored, []string{"notes", "creation_date", "father_id", "Owner", "group_id"}) {
+		t.Errorf("got %v %v", fields, ignored)
+	}
+
+	fields, ignored, err = CSVMapping(person, []string{"Nome", "Cellulare", "Altro"}, map[string]string{"Nome": "name", "Cellulare": "mobile"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(fields, []string{"name", "mobile", ""}) || !slices.Equal(ignored, []string{"Altro"}) {
+		t.Errorf("got %v %v", fields, ignored)
+	}
+
+	for _, mapping := range []map[string]string{
+		{"Nome": "nickname"},
+		{"Nome": "name", "Cellulare": "name"},
+		{"Telefono": "phone"},
+	} {
+		if _, _, err := CSVMapping(person, []string{"Nome", "Cellulare"}, mapping); err == nil {
+			t.Errorf("%v: expected an error", mapping)
+		}
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	country := NewDBCountry()
+	country.SetValue("id", "IT")
+	country.SetValue("Common_Name", "Italia, Repubblica")
+	var sb strings.Builder
+	if err := WriteCSV(&sb, NewDBCountry(), []DBEntityInterface{country}); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(sb.String()), "\n")
+	if len(lines) != 2 || lines[0] != strings.Join(CSVHeader(country), ",") || !strings.Contains(lines[1], `"Italia, Repubblica"`) {
+		t.Errorf("got %q", sb.String())
+	}
+}
+
+func TestImportCSVOwner(t *testing.T) {
+	repo := setupTestRepo(t)
+	folder := createTestFolder(t, repo, map[string]any{"name": "CSV Owner"}, nil)
+	defer hardDeleteForTests(repo, folder.(DBObjectInterface))
+	folderID := stringValue(folder, "id")
+
+	data := "name,owner,group_id\nCSV Owned,-999,-999\n"
+	report, err := repo.ImportCSV([]byte(data), CSVImportOptions{ClassName: "DBPerson", FatherID: folderID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Created != 1 || !slices.Equal(report.Ignored, []string{"owner", "group_id"}) {
+		t.Errorf("got %+v", report)
+	}
+	search := NewDBPerson()
+	search.SetValue("father_id", folderID)
+	people, err := repo.Search(search, false, false, "")
+	if err != nil || len(people) != 1 {
+		t.Fatalf("expected the imported person, got %d %v", len(people), err)
+	}
+	defer hardDeleteForTests(repo, people[0].(DBObjectInterface))
+	if stringValue(people[0], "owner") != "-1" || stringValue(people[0], "group_id") != "-2" {
+		t.Errorf("expected the user and its group, got %s %s", people[0].GetValue("owner"), people[0].GetValue("group_id"))
+	}
+}
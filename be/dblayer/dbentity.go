@@ -33,6 +33,7 @@ type Column struct {
 
 type DBEntityInterface interface {
 	NewInstance() DBEntityInterface
+	GetColumns() []Column
 	GetColumnType(columnName string) string
 	GetTypeName() string
 	GetTableName() string
@@ -76,6 +77,7 @@ type DBEntity struct {
 	typename    string
 	tablename   string
 	columns     map[string]Column
+	columnNames []string // Declaration order of the columns
 	keys        []string
 	foreignKeys []ForeignKey
 	dictionary  map[string]any
@@ -84,13 +86,16 @@ type DBEntity struct {
 
 func NewDBEntity(typename string, tablename string, columns []Column, keys []string, foreignKeys []ForeignKey, dictionary map[string]any) *DBEntity {
 	columnsMap := make(map[string]Column)
+	columnNames := make([]string, 0, len(columns))
 	for _, col := range columns {
 		columnsMap[col.Name] = col
+		columnNames = append(columnNames, col.Name)
 	}
 	return &DBEntity{
 		typename:    typename,
 		tablename:   tablename,
 		columns:     columnsMap,
+		columnNames: columnNames,
 		keys:        keys,
 		foreignKeys: foreignKeys,
 		dictionary:  dictionary,
@@ -99,11 +104,16 @@ func NewDBEntity(typename string, tablename string, columns []Column, keys []str
 
 /* Override */
 func (dbEntity *DBEntity) NewInstance() DBEntityInterface {
-	columns := make([]Column, 0, len(dbEntity.columns))
-	for _, col := range dbEntity.columns {
-		columns = append(columns, col)
+	return NewDBEntity(dbEntity.typename, dbEntity.tablename, dbEntity.GetColumns(), dbEntity.keys, dbEntity.foreignKeys, make(map[string]any))
+}
+
+// GetColumns returns the columns in their declaration order
+func (dbEntity *DBEntity) GetColumns() []Column {
+	columns := make([]Column, 0, len(dbEntity.columnNames))
+	for _, name := range dbEntity.columnNames {
+		columns = append(columns, dbEntity.columns[name])
 	}
-	return NewDBEntity(dbEntity.typename, dbEntity.tablename, columns, dbEntity.keys, dbEntity.foreignKeys, make(map[string]any))
+	return columns
 }
 
 func (dbEntity *DBEntity) GetColumnType(columnName string) string {
@@ -28,6 +28,24 @@ func InitDBLayer(config models.Config) {
 	log.Print("DB Schema:", DbSchema)
 	dbFiles_root_directory = config.RootDirectory
 	dbFiles_dest_directory = config.FilesDirectory
+	if err := SetFileLayout(config.FilesLayout); err != nil {
+		log.Fatal(err)
+	}
+	storage, storageErr := NewBlobStorage(config)
+	if storageErr != nil {
+		log.Fatal(storageErr)
+	}
+	FileStorage = storage
+	SetStorageQuotas(config.UserQuota, config.GroupQuota)
+	SetRenditionCache(config.RenditionCacheDirectory, config.RenditionCacheSize)
+	scanner, scannerErr := NewScanner(config)
+	if scannerErr != nil {
+		log.Fatal(scannerErr)
+	}
+	FileScanner = scanner
+	if err := SetScanAction(config.ScanAction); err != nil {
+		log.Fatal(err)
+	}
 
 	log.Print("Initializing DBEFactory...")
 
@@ -43,14 +61,44 @@ func InitDBLayer(config models.Config) {
 	Factory.Register(NewDBCountry())
 	Factory.Register(NewDBCompany())
 	Factory.Register(NewDBPerson())
+	Factory.Register(NewContactUID())
+	Factory.Register(NewContactMerge())
 	// CMS
 	Factory.Register(NewDBEvent())
 	Factory.Register(NewDBFile())
+	Factory.Register(NewFileRevision())
+	Factory.Register(NewFileBlob())
+	Factory.Register(NewFileUpload())
+	Factory.Register(NewStorageUsage())
+	Factory.Register(NewFileScan())
 	Factory.Register(NewDBFolder())
 	Factory.Register(NewDBLink())
 	Factory.Register(NewDBNote())
 	Factory.Register(NewDBPage())
 	Factory.Register(NewDBNews())
+	// Projects
+	Factory.Register(NewDBProject())
+	Factory.Register(NewDBProjectPeopleRole())
+	Factory.Register(NewDBProjectCompanyRole())
+	Factory.Register(NewDBProjectProjectRole())
+	Factory.Register(NewProjectPerson())
+	Factory.Register(NewProjectCompany())
+	Factory.Register(NewProjectProject())
+	Factory.Register(NewDBTimetrack())
+	Factory.Register(NewDBTodoTipo())
+	Factory.Register(NewDBTodo())
+	// Tags
+	Factory.Register(NewDBTag())
+	Factory.Register(NewObjectTag())
+	// Search
+	Factory.Register(NewDBFullTextTerm())
+	// Calendar
+	Factory.Register(NewEventUID())
+	Factory.Register(NewDBCalendarToken())
+	// Reminders
+	Factory.Register(NewEventReminder())
+	Factory.Register(NewDBNotification())
+	Factory.Register(NewNotificationChannel())
 	// Process foreign keys after all registrations
 	Factory.ProcessForeignKeys()
 
@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
+	"slices"
 	"strings"
 )
 
@@ -129,6 +130,10 @@ func (dbr *DBRepository) Search(dbe DBEntityInterface, useLike bool, caseSensiti
 	return dbr.searchWithTx(dbe, useLike, caseSensitive, orderBy, nil)
 }
 
+// searchRanges are the metadata of a search entity with the lower ("from")
+// and upper ("to") bounds of its columns: maps of column -> value
+var searchRanges = []struct{ metadata, operator string }{{"from", ">="}, {"to", "<="}}
+
 // searchWithTx is an internal method that performs the search using an existing transaction (if provided)
 func (dbr *DBRepository) searchWithTx(dbe DBEntityInterface, useLike bool, caseSensitive bool, orderBy string, tx *sql.Tx) ([]DBEntityInterface, error) {
 	if dbr.Verbose {
@@ -158,6 +163,23 @@ func (dbr *DBRepository) searchWithTx(dbe DBEntityInterface, useLike bool, caseS
 		}
 	}
 
+	// Ranges, both bounds included
+	for _, r := range searchRanges {
+		bounds, _ := dbe.GetMetadata(r.metadata).(map[string]string)
+		for column, bound := range bounds {
+			columnType := dbe.GetColumnType(column)
+			if columnType == "" {
+				continue
+			}
+			if r.operator == "<=" && columnType == "datetime" && len(bound) == len("2006-01-02") {
+				// A date includes the whole day
+				bound += " 23:59:59"
+			}
+			clauses = append(clauses, column+" "+r.operator+" ?")
+			args = append(args, bound)
+		}
+	}
+
 	// Default search: AND all populated fields
 	for key, value := range dbe.getDictionary() {
 		if value == nil {
@@ -402,6 +424,11 @@ func (dbr *DBRepository) insertWithTx(dbe DBEntityInterface, tx *sql.Tx) (DBEnti
 		return nil, err
 	}
 
+	// Keep the full-text index current
+	if dbe.IsDBObject() {
+		dbr.reindexObjectWithTx(dbe, tx)
+	}
+
 	return dbe, nil
 }
 
@@ -614,6 +641,11 @@ func (dbr *DBRepository) updateWithTx(dbe DBEntityInterface, tx *sql.Tx) (DBEnti
 		return nil, err
 	}
 
+	// Keep the full-text index current
+	if dbe.IsDBObject() {
+		dbr.reindexObjectWithTx(dbe, tx)
+	}
+
 	return dbe, nil
 }
 
@@ -646,6 +678,29 @@ func (dbr *DBRepository) GetCurrentUser() DBEntityInterface {
 	return foundUsers[0]
 }
 
+// GetUserGroupIDs returns the groups of a user: the ones in users_groups
+// plus the primary group of the user
+func (dbr *DBRepository) GetUserGroupIDs(userID string) ([]string, error) {
+	userGroup := dbr.GetInstanceByTableName("users_groups")
+	userGroup.SetValue("user_id", userID)
+	userGroups, err := dbr.Search(userGroup, false, false, "")
+	if err != nil {
+		return nil, err
+	}
+	groupIDs := []string{}
+	for _, ug := range userGroups {
+		if groupID, ok := ug.GetValue("group_id").(string); ok && groupID != "" {
+			groupIDs = append(groupIDs, groupID)
+		}
+	}
+	if user := dbr.GetEntityByID("users", userID); user != nil {
+		if groupID, ok := user.GetValue("group_id").(string); ok && groupID != "" && !slices.Contains(groupIDs, groupID) {
+			groupIDs = append(groupIDs, groupID)
+		}
+	}
+	return groupIDs, nil
+}
+
 // **** Objects Management ****
 
 func (dbr *DBRepository) ObjectByID(objectID string, ignoreDeleted bool) DBEntityInterface {
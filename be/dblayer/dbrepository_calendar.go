@@ -0,0 +1,359 @@
+package dblayer
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// GetCalendarEvents returns the readable events of a folder and/or category
+// (all the readable events when both are empty), ordered by start
+func (dbr *DBRepository) GetCalendarEvents(folderID string, category string) ([]DBEntityInterface, error) {
+	query := "SELECT * FROM " + dbr.buildTableName(NewDBEvent()) + " WHERE deleted_date IS NULL"
+	args := []any{}
+	if folderID != "" {
+		query += " AND father_id = ?"
+		args = append(args, folderID)
+	}
+	if category != "" {
+		query += " AND category = ?"
+		args = append(args, category)
+	}
+	query += " ORDER BY start_date"
+	events := dbr.Select("DBEvent", query, args...)
+	if events == nil {
+		return nil, fmt.Errorf("failed to read events")
+	}
+	return dbr.FilterByReadPermission(events), nil
+}
+
+// getEventUIDMappings returns the UID mappings of the given events
+func (dbr *DBRepository) getEventUIDMappings(events []DBEntityInterface) []DBEntityInterface {
+	if len(events) == 0 {
+		return nil
+	}
+	placeholders := make([]string, len(events))
+	args := make([]any, len(events))
+	for i, event := range events {
+		placeholders[i] = "?"
+		args[i] = stringValue(event, "id")
+	}
+	query := "SELECT * FROM " + dbr.buildTableName(NewEventUID()) +
+		" WHERE event_id IN (" + strings.Join(placeholders, ",") + ")"
+	return dbr.Select("EventUID", query, args...)
+}
+
+// GetEventUIDs returns the iCalendar UIDs of the imported events among the given ones
+func (dbr *DBRepository) GetEventUIDs(events []DBEntityInterface) map[string]string {
+	uids := make(map[string]string)
+	for _, mapping := range dbr.getEventUIDMappings(events) {
+		uids[stringValue(mapping, "event_id")] = stringValue(mapping, "uid")
+	}
+	return uids
+}
+
+// EventHref returns the CalDAV resource name of an event without a client chosen name
+func EventHref(eventID string) string {
+	return eventID + ".ics"
+}
+
+// GetEventHrefs returns the CalDAV resource names of the given events
+func (dbr *DBRepository) GetEventHrefs(events []DBEntityInterface) map[string]string {
+	hrefs := make(map[string]string)
+	for _, event := range events {
+		hrefs[stringValue(event, "id")] = EventHref(stringValue(event, "id"))
+	}
+	for _, mapping := range dbr.getEventUIDMappings(events) {
+		if href := stringValue(mapping, "href"); href != "" {
+			hrefs[stringValue(mapping, "event_id")] = href
+		}
+	}
+	return hrefs
+}
+
+// GetCalendarFolders returns the readable folders containing events: the CalDAV calendars
+func (dbr *DBRepository) GetCalendarFolders() ([]DBEntityInterface, error) {
+	folder := dbr.GetInstanceByTableName("folders")
+	query := "SELECT * FROM " + dbr.buildTableName(folder) +
+		" WHERE deleted_date IS NULL AND id IN (SELECT DISTINCT father_id FROM " + dbr.buildTableName(NewDBEvent()) +
+		" WHERE deleted_date IS NULL) ORDER BY name"
+	folders := dbr.Select(folder.GetTypeName(), query)
+	if folders == nil {
+		return nil, fmt.Errorf("failed to read the calendar folders")
+	}
+	return dbr.FilterByReadPermission(folders), nil
+}
+
+// FindEventByHref returns the event of a folder with the given CalDAV resource name, deleted ones excluded
+func (dbr *DBRepository) FindEventByHref(folderID string, href string) DBEntityInterface {
+	search := NewEventUID()
+	search.SetValue("href", href)
+	mappings, err := dbr.Search(search, false, true, "")
+	candidates := []string{}
+	if err == nil {
+		for _, mapping := range mappings {
+			candidates = append(candidates, stringValue(mapping, "event_id"))
+		}
+	}
+	if eventID, found := strings.CutSuffix(href, ".ics"); found {
+		candidates = append(candidates, eventID)
+	}
+	for _, eventID := range candidates {
+		event := dbr.GetEntityByID("events", eventID)
+		if event == nil || stringValue(event, "father_id") != folderID || event.(DBObjectInterface).HasDeletedDate() {
+			continue
+		}
+		return event
+	}
+	return nil
+}
+
+// ICalUIDConflictError is returned when the UID of an uploaded event belongs to another event
+type ICalUIDConflictError struct {
+	UID     string
+	EventID string
+}
+
+func (e *ICalUIDConflictError) Error() string {
+	return fmt.Sprintf("the UID %s belongs to the event %s", e.UID, e.EventID)
+}
+
+// checkICalUID returns an *ICalUIDConflictError if uid belongs to an event
+// other than existing. The UID of a deleted event is released.
+func (dbr *DBRepository) checkICalUID(uid string, existing DBEntityInterface) error {
+	existingID := ""
+	if existing != nil {
+		existingID = stringValue(existing, "id")
+	}
+	if eventID, found := strings.CutSuffix(uid, "@"+ICalUIDDomain); found && eventID != existingID &&
+		dbr.GetEntityByID("events", eventID) != nil {
+		return &ICalUIDConflictError{UID: uid, EventID: eventID}
+	}
+	search := NewEventUID()
+	search.SetValue("uid", uid)
+	mappings, err := dbr.Search(search, false, true, "")
+	if err != nil {
+		return err
+	}
+	if len(mappings) == 0 || stringValue(mappings[0], "event_id") == existingID {
+		return nil
+	}
+	eventID := stringValue(mappings[0], "event_id")
+	if event := dbr.GetEntityByID("events", eventID); event != nil && !event.(DBObjectInterface).HasDeletedDate() {
+		return &ICalUIDConflictError{UID: uid, EventID: eventID}
+	}
+	_, err = dbr.ExecuteSQL("DELETE FROM "+dbr.buildTableName(NewEventUID())+" WHERE uid = ? AND event_id = ?", uid, eventID)
+	return err
+}
+
+// SaveCalDAVEvent creates or updates the event uploaded by a CalDAV client
+// at folderID/href. existing is the event currently at href, if any.
+// Only the master VEVENT is stored: exceptions of recurring events are ignored.
+// Returns an *ICalUIDConflictError if the UID belongs to another event.
+func (dbr *DBRepository) SaveCalDAVEvent(folderID string, href string, data string, existing DBEntityInterface, loc *time.Location) (DBEntityInterface, error) {
+	icalEvents, err := ParseICalendar(data, loc)
+	if err != nil {
+		return nil, err
+	}
+	var icalEvent *ICalEvent
+	for i := range icalEvents {
+		if icalEvents[i].RecurrenceID == "" {
+			icalEvent = &icalEvents[i]
+			break
+		}
+	}
+	if icalEvent == nil {
+		return nil, fmt.Errorf("no VEVENT found")
+	}
+	if existing == nil && icalEvent.UID != "" {
+		// Same UID under another name, e.g. imported from an ICS file
+		if event := dbr.FindEventByICalUID(icalEvent.UID); event != nil &&
+			stringValue(event, "father_id") == folderID && !event.(DBObjectInterface).HasDeletedDate() {
+			existing = event
+		}
+	}
+	if icalEvent.UID != "" {
+		if err := dbr.checkICalUID(icalEvent.UID, existing); err != nil {
+			return nil, err
+		}
+	}
+
+	var saved DBEntityInterface
+	if existing != nil {
+		if !dbr.CheckWritePermission(existing) {
+			return nil, fmt.Errorf("permission denied")
+		}
+		icalEvent.ApplyTo(existing, loc)
+		saved, err = dbr.Update(existing)
+	} else {
+		event := NewDBEvent()
+		event.SetValue("permissions", "rwxr-x---")
+		event.SetValue("father_id", folderID)
+		icalEvent.ApplyTo(event, loc)
+		saved, err = dbr.Insert(event)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	eventID := stringValue(saved, "id")
+	uid := icalEvent.UID
+	if uid == "" {
+		uid = EventICalUID(eventID)
+	}
+	if uid == EventICalUID(eventID) && href == EventHref(eventID) {
+		return saved, nil
+	}
+	// Keep a single mapping per event
+	if _, err := dbr.ExecuteSQL("DELETE FROM "+dbr.buildTableName(NewEventUID())+" WHERE event_id = ?", eventID); err != nil {
+		return nil, err
+	}
+	mapping := NewEventUID()
+	mapping.SetValue("uid", uid)
+	mapping.SetValue("event_id", eventID)
+	mapping.SetValue("href", href)
+	if _, err := dbr.Insert(mapping); err != nil {
+		return nil, err
+	}
+	return saved, nil
+}
+
+// FindEventByICalUID returns the event with the given UID, deleted ones included
+func (dbr *DBRepository) FindEventByICalUID(uid string) DBEntityInterface {
+	if eventID, found := strings.CutSuffix(uid, "@"+ICalUIDDomain); found {
+		if event := dbr.GetEntityByID("events", eventID); event != nil {
+			return event
+		}
+	}
+	search := NewEventUID()
+	search.SetValue("uid", uid)
+	mappings, err := dbr.Search(search, false, true, "")
+	if err != nil || len(mappings) == 0 {
+		return nil
+	}
+	return dbr.GetEntityByID("events", stringValue(mappings[0], "event_id"))
+}
+
+// ICalImportResult summarizes an iCalendar import
+type ICalImportResult struct {
+	Created  int      `json:"created"`
+	Updated  int      `json:"updated"`
+	Skipped  int      `json:"skipped"`
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// ImportICalendar creates or updates the events of an iCalendar stream, matching them by UID.
+// New events are created in folderID; events the user cannot write, or deleted, are skipped.
+func (dbr *DBRepository) ImportICalendar(data string, folderID string, loc *time.Location) (*ICalImportResult, error) {
+	icalEvents, err := ParseICalendar(data, loc)
+	if err != nil {
+		return nil, err
+	}
+	result := &ICalImportResult{Warnings: []string{}}
+	for _, icalEvent := range icalEvents {
+		if icalEvent.RecurrenceID != "" {
+			result.Skipped++
+			result.Warnings = append(result.Warnings, fmt.Sprintf("%s: exceptions of recurring events are not supported", icalEvent.UID))
+			continue
+		}
+		var existing DBEntityInterface
+		if icalEvent.UID != "" {
+			existing = dbr.FindEventByICalUID(icalEvent.UID)
+		}
+
+		if existing != nil {
+			if existing.(DBObjectInterface).HasDeletedDate() || !dbr.CheckWritePermission(existing) {
+				result.Skipped++
+				result.Warnings = append(result.Warnings, fmt.Sprintf("%s: deleted or not writable", icalEvent.UID))
+				continue
+			}
+			if warning := icalEvent.ApplyTo(existing, loc); warning != "" {
+				result.Warnings = append(result.Warnings, fmt.Sprintf("%s: %s", icalEvent.UID, warning))
+			}
+			if _, err := dbr.Update(existing); err != nil {
+				result.Skipped++
+				result.Warnings = append(result.Warnings, fmt.Sprintf("%s: %v", icalEvent.UID, err))
+				continue
+			}
+			result.Updated++
+			continue
+		}
+
+		event := NewDBEvent()
+		event.SetValue("permissions", "rwxr-x---")
+		if folderID != "" {
+			event.SetValue("father_id", folderID)
+		}
+		if warning := icalEvent.ApplyTo(event, loc); warning != "" {
+			result.Warnings = append(result.Warnings, fmt.Sprintf("%s: %s", icalEvent.UID, warning))
+		}
+		created, err := dbr.Insert(event)
+		if err != nil {
+			result.Skipped++
+			result.Warnings = append(result.Warnings, fmt.Sprintf("%s: %v", icalEvent.UID, err))
+			continue
+		}
+		result.Created++
+		if icalEvent.UID == "" {
+			continue
+		}
+		mapping := NewEventUID()
+		mapping.SetValue("uid", icalEvent.UID)
+		mapping.SetValue("event_id", stringValue(created, "id"))
+		if _, err := dbr.Insert(mapping); err != nil {
+			log.Print("DBRepository::ImportICalendar: error saving the UID ", icalEvent.UID, ": ", err)
+		}
+	}
+	return result, nil
+}
+
+// currentCalendarToken returns the token entity of the current user, nil if none
+func (dbr *DBRepository) currentCalendarToken() DBEntityInterface {
+	search := NewDBCalendarToken()
+	search.SetValue("user_id", dbr.DbContext.UserID)
+	results, err := dbr.Search(search, false, true, "")
+	if err != nil || len(results) == 0 {
+		return nil
+	}
+	return results[0]
+}
+
+// GetCalendarToken returns the private feed token of the current user, creating it if needed
+func (dbr *DBRepository) GetCalendarToken() (string, error) {
+	if token := dbr.currentCalendarToken(); token != nil {
+		return stringValue(token, "token"), nil
+	}
+	token := NewDBCalendarToken()
+	token.SetValue("user_id", dbr.DbContext.UserID)
+	created, err := dbr.Insert(token)
+	if err != nil {
+		return "", err
+	}
+	return stringValue(created, "token"), nil
+}
+
+// RegenerateCalendarToken replaces the private feed token of the current user,
+// invalidating the subscriptions made with the old one
+func (dbr *DBRepository) RegenerateCalendarToken() (string, error) {
+	if existing := dbr.currentCalendarToken(); existing != nil {
+		if _, err := dbr.Delete(existing); err != nil {
+			return "", err
+		}
+	}
+	return dbr.GetCalendarToken()
+}
+
+// GetUserIDByCalendarToken returns the user owning a private feed token, "" if unknown
+func (dbr *DBRepository) GetUserIDByCalendarToken(token string) string {
+	if token == "" {
+		return ""
+	}
+	search := NewDBCalendarToken()
+	search.SetValue("token", token)
+	results, err := dbr.Search(search, false, true, "")
+	if err != nil || len(results) != 1 {
+		return ""
+	}
+	return stringValue(results[0], "user_id")
+}
@@ -0,0 +1,564 @@
+package dblayer
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// VCardImportResult summarizes an import of vCards
+type VCardImportResult struct {
+	Created  int      `json:"created"`
+	Updated  int      `json:"updated"`
+	Skipped  int      `json:"skipped"`
+	Warnings []string `json:"warnings"`
+}
+
+// GetFolderContacts returns the readable people and companies of a folder, companies first
+func (dbr *DBRepository) GetFolderContacts(folderID string) ([]DBEntityInterface, error) {
+	contacts := []DBEntityInterface{}
+	for _, table := range []string{"companies", "people"} {
+		instance := dbr.GetInstanceByTableName(table)
+		query := "SELECT * FROM " + dbr.buildTableName(instance) +
+			" WHERE deleted_date IS NULL AND father_id = ? ORDER BY name"
+		results := dbr.Select(instance.GetTypeName(), query, folderID)
+		if results == nil {
+			return nil, fmt.Errorf("failed to read %s", table)
+		}
+		contacts = append(contacts, dbr.FilterByReadPermission(results)...)
+	}
+	return contacts, nil
+}
+
+// ContactVCard returns the vCard of a person or company, with the name of its
+// country and, for people, of their company when readable
+func (dbr *DBRepository) ContactVCard(contact DBEntityInterface, version string) string {
+	country := ""
+	if countryID := stringValue(contact, "fk_countrylist_id"); countryID != "" {
+		if found := dbr.GetEntityByID("countrylist", countryID); found != nil {
+			country = stringValue(found, "Common_Name")
+		}
+	}
+	organization := ""
+	if companyID := stringValue(contact, "fk_companies_id"); companyID != "" {
+		if company := dbr.GetEntityByID("companies", companyID); company != nil && dbr.CheckReadPermission(company) {
+			organization = stringValue(company, "name")
+		}
+	}
+	uids := dbr.GetContactUIDs([]DBEntityInterface{contact})
+	return ContactToVCard(contact, version, uids[stringValue(contact, "id")], country, organization)
+}
+
+// getContactUIDMappings returns the UID mappings of the given contacts
+func (dbr *DBRepository) getContactUIDMappings(contacts []DBEntityInterface) []DBEntityInterface {
+	if len(contacts) == 0 {
+		return nil
+	}
+	placeholders := make([]string, len(contacts))
+	args := make([]any, len(contacts))
+	for i, contact := range contacts {
+		placeholders[i] = "?"
+		args[i] = stringValue(contact, "id")
+	}
+	query := "SELECT * FROM " + dbr.buildTableName(NewContactUID()) +
+		" WHERE contact_id IN (" + strings.Join(placeholders, ",") + ")"
+	return dbr.Select("ContactUID", query, args...)
+}
+
+// GetContactUIDs returns the vCard UIDs chosen by CardDAV clients among the given contacts
+func (dbr *DBRepository) GetContactUIDs(contacts []DBEntityInterface) map[string]string {
+	uids := make(map[string]string)
+	for _, mapping := range dbr.getContactUIDMappings(contacts) {
+		uids[stringValue(mapping, "contact_id")] = stringValue(mapping, "uid")
+	}
+	return uids
+}
+
+// ContactHref returns the CardDAV resource name of a contact without a client chosen name
+func ContactHref(contactID string) string {
+	return contactID + ".vcf"
+}
+
+// GetContactHrefs returns the CardDAV resource names of the given contacts
+func (dbr *DBRepository) GetContactHrefs(contacts []DBEntityInterface) map[string]string {
+	hrefs := make(map[string]string)
+	for _, contact := range contacts {
+		hrefs[stringValue(contact, "id")] = ContactHref(stringValue(contact, "id"))
+	}
+	for _, mapping := range dbr.getContactUIDMappings(contacts) {
+		if href := stringValue(mapping, "href"); href != "" {
+			hrefs[stringValue(mapping, "contact_id")] = href
+		}
+	}
+	return hrefs
+}
+
+// GetAddressBookFolders returns the readable folders containing people or companies: the CardDAV address books
+func (dbr *DBRepository) GetAddressBookFolders() ([]DBEntityInterface, error) {
+	folder := dbr.GetInstanceByTableName("folders")
+	query := "SELECT * FROM " + dbr.buildTableName(folder) +
+		" WHERE deleted_date IS NULL AND (id IN (SELECT DISTINCT father_id FROM " + dbr.buildTableName(NewDBPerson()) +
+		" WHERE deleted_date IS NULL) OR id IN (SELECT DISTINCT father_id FROM " + dbr.buildTableName(NewDBCompany()) +
+		" WHERE deleted_date IS NULL)) ORDER BY name"
+	folders := dbr.Select(folder.GetTypeName(), query)
+	if folders == nil {
+		return nil, fmt.Errorf("failed to read the address book folders")
+	}
+	return dbr.FilterByReadPermission(folders), nil
+}
+
+// findContactByID returns the person or company with the given id, deleted ones included
+func (dbr *DBRepository) findContactByID(contactID string) DBEntityInterface {
+	for _, table := range []string{"people", "companies"} {
+		if contact := dbr.GetEntityByID(table, contactID); contact != nil {
+			return contact
+		}
+	}
+	return nil
+}
+
+// FindContactByHref returns the contact of a folder with the given CardDAV resource name, deleted ones excluded
+func (dbr *DBRepository) FindContactByHref(folderID string, href string) DBEntityInterface {
+	search := NewContactUID()
+	search.SetValue("href", href)
+	mappings, err := dbr.Search(search, false, true, "")
+	candidates := []string{}
+	if err == nil {
+		for _, mapping := range mappings {
+			candidates = append(candidates, stringValue(mapping, "contact_id"))
+		}
+	}
+	if contactID, found := strings.CutSuffix(href, ".vcf"); found {
+		candidates = append(candidates, contactID)
+	}
+	for _, contactID := range candidates {
+		contact := dbr.findContactByID(contactID)
+		if contact == nil || stringValue(contact, "father_id") != folderID || contact.(DBObjectInterface).HasDeletedDate() {
+			continue
+		}
+		return contact
+	}
+	return nil
+}
+
+// FindContactByVCardUID returns the contact with the given vCard UID, deleted ones included
+func (dbr *DBRepository) FindContactByVCardUID(uid string) DBEntityInterface {
+	if contactID, found := strings.CutSuffix(uid, "@"+ICalUIDDomain); found {
+		if contact := dbr.findContactByID(contactID); contact != nil {
+			return contact
+		}
+	}
+	search := NewContactUID()
+	search.SetValue("uid", uid)
+	mappings, err := dbr.Search(search, false, true, "")
+	if err != nil || len(mappings) == 0 {
+		return nil
+	}
+	return dbr.findContactByID(stringValue(mappings[0], "contact_id"))
+}
+
+// resolveVCardReferences sets the country and, for people, the company of a
+// contact from the names of a vCard. countries caches the countries already
+// resolved. Returns the warnings about the names not found.
+func (dbr *DBRepository) resolveVCardReferences(card *VCardContact, contact DBEntityInterface, countries map[string]string) []string {
+	warnings := []string{}
+	if card.Country != "" {
+		countryID, resolved := countries[card.Country]
+		if !resolved {
+			countryID = dbr.FindCountry(card.Country)
+			countries[card.Country] = countryID
+		}
+		if countryID != "" {
+			contact.SetValue("fk_countrylist_id", countryID)
+		} else {
+			warnings = append(warnings, fmt.Sprintf("%s: unknown country %s", card.Name, card.Country))
+		}
+	}
+	if contact.GetTypeName() == "DBPerson" && card.Organization != "" {
+		if company := dbr.findCompanyByName(card.Organization); company != nil {
+			contact.SetValue("fk_companies_id", stringValue(company, "id"))
+		} else {
+			warnings = append(warnings, fmt.Sprintf("%s: unknown company %s", card.Name, card.Organization))
+		}
+	}
+	return warnings
+}
+
+// ErrVCardUIDConflict is returned when the UID of an uploaded vCard belongs to another contact
+var ErrVCardUIDConflict = errors.New("the UID belongs to another contact")
+
+// checkVCardUID returns ErrVCardUIDConflict if uid belongs to a contact other
+// than existing. The UID of a deleted contact is released.
+func (dbr *DBRepository) checkVCardUID(uid string, existing DBEntityInterface) error {
+	existingID := ""
+	if existing != nil {
+		existingID = stringValue(existing, "id")
+	}
+	if contactID, found := strings.CutSuffix(uid, "@"+ICalUIDDomain); found && contactID != existingID &&
+		dbr.findContactByID(contactID) != nil {
+		return ErrVCardUIDConflict
+	}
+	search := NewContactUID()
+	search.SetValue("uid", uid)
+	mappings, err := dbr.Search(search, false, true, "")
+	if err != nil {
+		return err
+	}
+	if len(mappings) == 0 || stringValue(mappings[0], "contact_id") == existingID {
+		return nil
+	}
+	contactID := stringValue(mappings[0], "contact_id")
+	if contact := dbr.findContactByID(contactID); contact != nil && !contact.(DBObjectInterface).HasDeletedDate() {
+		return ErrVCardUIDConflict
+	}
+	_, err = dbr.ExecuteSQL("DELETE FROM "+dbr.buildTableName(NewContactUID())+" WHERE uid = ? AND contact_id = ?", uid, contactID)
+	return err
+}
+
+// SaveCardDAVContact creates or updates the contact uploaded by a CardDAV
+// client at folderID/href. existing is the contact currently at href, if any.
+// The vCard replaces the contact: fields missing in it are cleared.
+// Returns ErrVCardUIDConflict if the UID of the vCard belongs to another contact.
+func (dbr *DBRepository) SaveCardDAVContact(folderID string, href string, data string, existing DBEntityInterface) (DBEntityInterface, error) {
+	cards, err := ParseVCards(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(cards) != 1 {
+		return nil, fmt.Errorf("a single VCARD is expected, found %d", len(cards))
+	}
+	card := &cards[0]
+	if card.Name == "" {
+		return nil, fmt.Errorf("contact without a name")
+	}
+	if existing == nil && card.UID != "" {
+		// Same UID under another name, e.g. exported as vCard and imported again
+		if contact := dbr.FindContactByVCardUID(card.UID); contact != nil &&
+			stringValue(contact, "father_id") == folderID && !contact.(DBObjectInterface).HasDeletedDate() {
+			existing = contact
+		}
+	}
+
+	if card.UID != "" {
+		if err := dbr.checkVCardUID(card.UID, existing); err != nil {
+			return nil, err
+		}
+	}
+
+	contact := existing
+	if contact == nil {
+		contact = dbr.GetInstanceByTableName(card.TableName())
+		contact.SetValue("permissions", "rwxr-x---")
+		contact.SetValue("father_id", folderID)
+	} else if !dbr.CheckWritePermission(contact) {
+		return nil, fmt.Errorf("permission denied")
+	}
+	card.Replace(contact)
+	contact.SetValue("fk_countrylist_id", nil)
+	if contact.GetTypeName() == "DBPerson" {
+		contact.SetValue("fk_companies_id", nil)
+	}
+	for _, warning := range dbr.resolveVCardReferences(card, contact, make(map[string]string)) {
+		log.Print("DBRepository::SaveCardDAVContact: ", warning)
+	}
+
+	var saved DBEntityInterface
+	if existing != nil {
+		saved, err = dbr.Update(contact)
+	} else {
+		saved, err = dbr.Insert(contact)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	contactID := stringValue(saved, "id")
+	uid := card.UID
+	if uid == "" {
+		uid = ContactVCardUID(contactID)
+	}
+	if uid == ContactVCardUID(contactID) && href == ContactHref(contactID) {
+		return saved, nil
+	}
+	// Keep a single mapping per contact
+	if _, err := dbr.ExecuteSQL("DELETE FROM "+dbr.buildTableName(NewContactUID())+" WHERE contact_id = ?", contactID); err != nil {
+		return nil, err
+	}
+	mapping := NewContactUID()
+	mapping.SetValue("uid", uid)
+	mapping.SetValue("contact_id", contactID)
+	mapping.SetValue("href", href)
+	if _, err := dbr.Insert(mapping); err != nil {
+		return nil, err
+	}
+	return saved, nil
+}
+
+// FindCountry returns the id of the country with the given name or ISO 3166 code, "" if unknown
+func (dbr *DBRepository) FindCountry(name string) string {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return ""
+	}
+	query := "SELECT * FROM " + dbr.buildTableName(NewDBCountry()) +
+		" WHERE Common_Name = ? OR Formal_Name = ? OR ISO_3166_1_2_Letter_Code = ? OR ISO_3166_1_3_Letter_Code = ?"
+	countries := dbr.Select("DBCountry", query, name, name, name, name)
+	if len(countries) == 0 {
+		return ""
+	}
+	return stringValue(countries[0], "id")
+}
+
+// FindContactByEmail returns the first readable, non deleted contact of a table
+// (people or companies) with one of the given emails
+func (dbr *DBRepository) FindContactByEmail(tableName string, emails []string) DBEntityInterface {
+	instance := dbr.GetInstanceByTableName(tableName)
+	for _, email := range emails {
+		query := "SELECT * FROM " + dbr.buildTableName(instance) +
+			" WHERE deleted_date IS NULL AND LOWER(email) = LOWER(?)"
+		for _, contact := range dbr.Select(instance.GetTypeName(), query, strings.TrimSpace(email)) {
+			if dbr.CheckReadPermission(contact) {
+				return contact
+			}
+		}
+	}
+	return nil
+}
+
+// findCompanyByName returns the first readable, non deleted company with the given name
+func (dbr *DBRepository) findCompanyByName(name string) DBEntityInterface {
+	query := "SELECT * FROM " + dbr.buildTableName(NewDBCompany()) + " WHERE deleted_date IS NULL AND name = ?"
+	for _, company := range dbr.Select("DBCompany", query, name) {
+		if dbr.CheckReadPermission(company) {
+			return company
+		}
+	}
+	return nil
+}
+
+// ImportVCards creates or updates people and companies from a vCard stream.
+// Existing contacts are matched by email; new ones are created in folderID.
+// Companies are imported first, so that people can be linked to the companies
+// of the same file through ORG.
+func (dbr *DBRepository) ImportVCards(data string, folderID string) (*VCardImportResult, error) {
+	cards, err := ParseVCards(data)
+	if err != nil {
+		return nil, err
+	}
+	ordered := []VCardContact{}
+	for _, card := range cards {
+		if card.Company {
+			ordered = append(ordered, card)
+		}
+	}
+	for _, card := range cards {
+		if !card.Company {
+			ordered = append(ordered, card)
+		}
+	}
+
+	result := &VCardImportResult{Warnings: []string{}}
+	countries := make(map[string]string)
+	for _, card := range ordered {
+		if card.Name == "" {
+			result.Skipped++
+			result.Warnings = append(result.Warnings, "contact without a name")
+			continue
+		}
+		contact := dbr.FindContactByEmail(card.TableName(), card.Emails)
+		existing := contact != nil
+		if existing && !dbr.CheckWritePermission(contact) {
+			result.Skipped++
+			result.Warnings = append(result.Warnings, fmt.Sprintf("%s: not writable", card.Name))
+			continue
+		}
+		if !existing {
+			contact = dbr.GetInstanceByTableName(card.TableName())
+			contact.SetValue("permissions", "rwxr-x---")
+			if folderID != "" {
+				contact.SetValue("father_id", folderID)
+			}
+		}
+		card.ApplyTo(contact)
+		result.Warnings = append(result.Warnings, dbr.resolveVCardReferences(&card, contact, countries)...)
+
+		if existing {
+			_, err = dbr.Update(contact)
+		} else {
+			_, err = dbr.Insert(contact)
+		}
+		if err != nil {
+			result.Skipped++
+			result.Warnings = append(result.Warnings, fmt.Sprintf("%s: %v", card.Name, err))
+			continue
+		}
+		if existing {
+			result.Updated++
+		} else {
+			result.Created++
+		}
+	}
+	return result, nil
+}
+
+// FindDuplicatePeople returns the pairs of readable, non deleted people
+// scoring at least minScore as duplicates
+func (dbr *DBRepository) FindDuplicatePeople(minScore int) ([]DuplicateCandidate, error) {
+	query := "SELECT * FROM " + dbr.buildTableName(NewDBPerson()) + " WHERE deleted_date IS NULL"
+	people := dbr.Select("DBPerson", query)
+	if people == nil {
+		return nil, fmt.Errorf("failed to read people")
+	}
+	return FindDuplicates(dbr.FilterByReadPermission(people), minScore), nil
+}
+
+// ErrMergeNotAllowed is returned when two people cannot be merged
+var ErrMergeNotAllowed = errors.New("merge not allowed")
+
+// MergePeople merges a duplicate person into the survivor: the survivor takes
+// the fields chosen by winners (see MergePersonFields), the references to the
+// merged person (foreign keys of every registered class, tags) move to the
+// survivor, then the merged person is soft deleted. The merge is recorded in
+// the ContactMerge history.
+func (dbr *DBRepository) MergePeople(survivorID string, mergedID string, winners map[string]string) (DBEntityInterface, DBEntityInterface, error) {
+	if survivorID == mergedID {
+		return nil, nil, fmt.Errorf("%w: a person cannot be merged with itself", ErrMergeNotAllowed)
+	}
+	survivor := dbr.GetEntityByID("people", survivorID)
+	merged := dbr.GetEntityByID("people", mergedID)
+	if survivor == nil || merged == nil ||
+		survivor.(DBObjectInterface).HasDeletedDate() || merged.(DBObjectInterface).HasDeletedDate() {
+		return nil, nil, fmt.Errorf("%w: person not found", ErrMergeNotAllowed)
+	}
+	if !dbr.CheckWritePermission(survivor) || !dbr.CheckWritePermission(merged) {
+		return nil, nil, fmt.Errorf("%w: permission denied", ErrMergeNotAllowed)
+	}
+	mergedData, err := json.Marshal(merged.GetAllValues())
+	if err != nil {
+		return nil, nil, err
+	}
+	changes := MergePersonFields(survivor, merged, winners)
+
+	tx, err := dbr.DbConnection.Begin()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	if len(changes) > 0 {
+		if _, err := dbr.updateWithTx(survivor, tx); err != nil {
+			log.Print("DBRepository::MergePeople: error updating the survivor:", err)
+			return nil, nil, err
+		}
+	}
+	moved, err := dbr.movePersonReferencesWithTx(survivor, merged, tx)
+	if err != nil {
+		log.Print("DBRepository::MergePeople: error moving the references:", err)
+		return nil, nil, err
+	}
+	if _, err := dbr.deleteWithTx(merged, tx); err != nil {
+		log.Print("DBRepository::MergePeople: error deleting the merged person:", err)
+		return nil, nil, err
+	}
+	changesData, _ := json.Marshal(changes)
+	movedData, _ := json.Marshal(moved)
+	history := NewContactMerge()
+	history.SetValue("survivor_id", survivorID)
+	history.SetValue("merged_id", mergedID)
+	history.SetValue("merged_data", string(mergedData))
+	history.SetValue("changes", string(changesData))
+	history.SetValue("moved_references", string(movedData))
+	if _, err := dbr.insertWithTx(history, tx); err != nil {
+		log.Print("DBRepository::MergePeople: error saving the history:", err)
+		return nil, nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, nil, err
+	}
+	if reloaded := dbr.GetEntityByID("people", survivorID); reloaded != nil {
+		survivor = reloaded
+	}
+	return survivor, history, nil
+}
+
+// movePersonReferencesWithTx points to the survivor the foreign keys of every
+// registered class referencing the merged person, and copies its tags.
+// Returns the number of moved references by "table.column".
+func (dbr *DBRepository) movePersonReferencesWithTx(survivor DBEntityInterface, merged DBEntityInterface, tx *sql.Tx) (map[string]int64, error) {
+	survivorID, mergedID := stringValue(survivor, "id"), stringValue(merged, "id")
+	moved := make(map[string]int64)
+	classNames := dbr.factory.GetAllClassNames()
+	sort.Strings(classNames)
+	for _, className := range classNames {
+		instance := dbr.factory.GetInstanceByClassName(className)
+		if instance.GetTypeName() == "ContactMerge" {
+			// The history keeps the id of the merged person
+			continue
+		}
+		for _, fk := range instance.GetForeignKeys() {
+			if fk.RefTable != "people" {
+				continue
+			}
+			tableName := dbr.buildTableName(instance)
+			// Rows of a key already referencing the survivor are skipped by
+			// UPDATE IGNORE, then deleted with the merged person
+			inKey := slices.Contains(instance.GetKeys(), fk.Column)
+			query := "UPDATE " + tableName + " SET " + fk.Column + " = ? WHERE " + fk.Column + " = ?"
+			if inKey {
+				query = "UPDATE IGNORE " + tableName + " SET " + fk.Column + " = ? WHERE " + fk.Column + " = ?"
+			}
+			result, err := tx.Exec(query, survivorID, mergedID)
+			if err != nil {
+				return nil, err
+			}
+			if n, err := result.RowsAffected(); err == nil && n > 0 {
+				moved[instance.GetTableName()+"."+fk.Column] += n
+			}
+			if inKey {
+				if _, err := tx.Exec("DELETE FROM "+tableName+" WHERE "+fk.Column+" = ?", mergedID); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	// The user account now belongs to the survivor only
+	if userID := stringValue(merged, "fk_users_id"); userID != "" && userID == stringValue(survivor, "fk_users_id") {
+		query := "UPDATE " + dbr.buildTableName(merged) + " SET fk_users_id = NULL WHERE id = ?"
+		if _, err := tx.Exec(query, mergedID); err != nil {
+			return nil, err
+		}
+		merged.SetValue("fk_users_id", nil)
+		moved["people.fk_users_id"]++
+	}
+
+	// Tags already on the survivor are skipped by INSERT IGNORE
+	objectsTagsTable := dbr.buildTableName(NewObjectTag())
+	query := "INSERT IGNORE INTO " + objectsTagsTable + " (object_id, tag_id, creator, creation_date)" +
+		" SELECT ?, tag_id, creator, creation_date FROM " + objectsTagsTable + " WHERE object_id = ?"
+	result, err := tx.Exec(query, survivorID, mergedID)
+	if err != nil {
+		return nil, err
+	}
+	if n, err := result.RowsAffected(); err == nil && n > 0 {
+		moved["objects_tags.object_id"] = n
+	}
+	return moved, nil
+}
+
+// GetContactMerges returns the merges of a person, as survivor or as merged person, newest first
+func (dbr *DBRepository) GetContactMerges(personID string) ([]DBEntityInterface, error) {
+	query := "SELECT * FROM " + dbr.buildTableName(NewContactMerge()) +
+		" WHERE survivor_id = ? OR merged_id = ? ORDER BY creation_date DESC"
+	merges := dbr.Select("ContactMerge", query, personID, personID)
+	if merges == nil {
+		return nil, fmt.Errorf("failed to read the merges of %s", personID)
+	}
+	return merges, nil
+}
@@ -0,0 +1,44 @@
+package dblayer
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"time"
+)
+
+// GetEventOccurrences expands the readable events overlapping [from, to) into
+// their occurrences, ordered by start. With a folderID only the events in that
+// folder are returned.
+func (dbr *DBRepository) GetEventOccurrences(from time.Time, to time.Time, folderID string, loc *time.Location) ([]EventOccurrence, error) {
+	tableName := dbr.buildTableName(NewDBEvent())
+	// Recurring events can start before the range: their end conditions are checked while expanding
+	query := "SELECT * FROM " + tableName +
+		" WHERE deleted_date IS NULL AND start_date < ? AND (end_date >= ? OR recurrence = '1')"
+	// One day of margin: stored datetimes have no timezone
+	args := []any{to.AddDate(0, 0, 1).Format(time.DateTime), from.AddDate(0, 0, -1).Format(time.DateTime)}
+	if folderID != "" {
+		query += " AND father_id = ?"
+		args = append(args, folderID)
+	}
+	query += " ORDER BY start_date"
+
+	events := dbr.Select("DBEvent", query, args...)
+	if events == nil {
+		return nil, fmt.Errorf("failed to read events")
+	}
+
+	occurrences := []EventOccurrence{}
+	for _, event := range dbr.FilterByReadPermission(events) {
+		expanded, err := ExpandEvent(event, from, to, loc)
+		if err != nil {
+			log.Print("DBRepository::GetEventOccurrences: ", err)
+			continue
+		}
+		occurrences = append(occurrences, expanded...)
+	}
+	sort.SliceStable(occurrences, func(i, j int) bool {
+		return occurrences[i].start.Before(occurrences[j].start)
+	})
+	return occurrences, nil
+}
@@ -0,0 +1,281 @@
+package dblayer
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strings"
+)
+
+// fullTextFieldWeights are the indexed fields and how much a term found
+// in each of them counts. Fields missing in a class are simply skipped.
+var fullTextFieldWeights = map[string]int{
+	"name":        3,
+	"description": 2,
+	"html":        1,
+}
+
+// fullTextHTMLFields are stripped from the markup before being tokenized
+var fullTextHTMLFields = map[string]bool{
+	"html": true,
+}
+
+// fullTextMaxResults caps the number of objects returned by SearchFullText
+const fullTextMaxResults = 500
+
+// Rows per INSERT statement when writing the index
+const fullTextInsertBatch = 200
+
+// FullTextTerms computes the weighted terms of an object, as stored in the index
+func FullTextTerms(dbe DBEntityInterface) map[string]int {
+	language := ""
+	if lang, ok := dbe.GetValue("language").(string); ok {
+		language = lang
+	}
+	terms := make(map[string]int)
+	for field, weight := range fullTextFieldWeights {
+		text, ok := dbe.GetValue(field).(string)
+		if !ok || text == "" {
+			continue
+		}
+		if fullTextHTMLFields[field] {
+			text = StripHTML(text)
+		}
+		for _, term := range Tokenize(text, language) {
+			terms[term] += weight
+		}
+	}
+	return terms
+}
+
+// deleteObjectIndexWithTx removes an object from the full-text index
+func (dbr *DBRepository) deleteObjectIndexWithTx(objectID string, tx *sql.Tx) error {
+	query := "DELETE FROM " + dbr.buildTableName(NewDBFullTextTerm()) + " WHERE object_id=?"
+	_, err := tx.Exec(query, objectID)
+	return err
+}
+
+// indexObjectWithTx replaces the index entries of the object with its current terms
+func (dbr *DBRepository) indexObjectWithTx(dbe DBEntityInterface, tx *sql.Tx) error {
+	objectID, ok := dbe.GetValue("id").(string)
+	if !ok || objectID == "" {
+		return fmt.Errorf("object without id")
+	}
+	if err := dbr.deleteObjectIndexWithTx(objectID, tx); err != nil {
+		return err
+	}
+
+	terms := FullTextTerms(dbe)
+	if len(terms) == 0 {
+		return nil
+	}
+	sortedTerms := make([]string, 0, len(terms))
+	for term := range terms {
+		sortedTerms = append(sortedTerms, term)
+	}
+	sort.Strings(sortedTerms)
+
+	tableName := dbr.buildTableName(NewDBFullTextTerm())
+	for start := 0; start < len(sortedTerms); start += fullTextInsertBatch {
+		end := min(start+fullTextInsertBatch, len(sortedTerms))
+		placeholders := make([]string, 0, end-start)
+		args := make([]interface{}, 0, (end-start)*3)
+		for _, term := range sortedTerms[start:end] {
+			placeholders = append(placeholders, "(?,?,?)")
+			args = append(args, objectID, term, terms[term])
+		}
+		query := "INSERT INTO " + tableName + " (object_id, term, weight) VALUES " + strings.Join(placeholders, ",")
+		if _, err := tx.Exec(query, args...); err != nil {
+			return err
+		}
+	}
+	if dbr.Verbose {
+		log.Printf("DBRepository::indexObjectWithTx: object %s indexed with %d terms", objectID, len(terms))
+	}
+	return nil
+}
+
+// reindexObjectWithTx reloads the object within the transaction, so that
+// partial updates are indexed with all their fields, and indexes it.
+// Errors are only logged: the index must never block a write.
+func (dbr *DBRepository) reindexObjectWithTx(dbe DBEntityInterface, tx *sql.Tx) {
+	objectID, ok := dbe.GetValue("id").(string)
+	if !ok || objectID == "" {
+		return
+	}
+	tableName := dbe.GetTableName()
+	if tableName == "objects" && dbe.HasMetadata("classname") {
+		if tmp := dbr.GetInstanceByClassName(dbe.GetMetadata("classname").(string)); tmp != nil {
+			tableName = tmp.GetTableName()
+		}
+	}
+	full := dbr.GetEntityByIDWithTx(tableName, objectID, tx)
+	if full == nil {
+		full = dbe
+	}
+	if err := dbr.indexObjectWithTx(full, tx); err != nil {
+		log.Print("DBRepository::reindexObjectWithTx: error indexing object ", objectID, ": ", err)
+	}
+}
+
+// ReindexAll rebuilds the full-text index of all the DBObjects, deleted ones included.
+// Returns the number of indexed objects.
+func (dbr *DBRepository) ReindexAll() (int, error) {
+	count := 0
+	if _, err := dbr.DbConnection.Exec("DELETE FROM " + dbr.buildTableName(NewDBFullTextTerm())); err != nil {
+		return 0, err
+	}
+	for _, className := range dbr.factory.GetAllClassNames() {
+		dbe := dbr.GetInstanceByClassName(className)
+		if dbe == nil || !dbe.IsDBObject() || dbe.GetTableName() == "objects" {
+			continue
+		}
+		objects := dbr.Select(className, "SELECT * FROM "+dbr.buildTableName(dbe))
+
+		tx, err := dbr.DbConnection.Begin()
+		if err != nil {
+			return count, err
+		}
+		for _, obj := range objects {
+			if err := dbr.indexObjectWithTx(obj, tx); err != nil {
+				tx.Rollback()
+				return count, err
+			}
+			count++
+		}
+		if err := tx.Commit(); err != nil {
+			return count, err
+		}
+		log.Printf("DBRepository::ReindexAll: %s: %d objects indexed", className, len(objects))
+	}
+	return count, nil
+}
+
+// SearchFullText searches the DBObjects matching ALL the words of the query
+// in their name, description or body, ordered by relevance.
+// Each word matches its stem in any supported language; the last word
+// also matches as a prefix, to support search-as-you-type.
+// The relevance is a TF-IDF score, stored in the "score" metadata.
+// Read permissions are NOT checked here.
+func (dbr *DBRepository) SearchFullText(query string, ignoreDeleted bool) []DBEntityInterface {
+	words := ParseFullTextQuery(query)
+	if len(words) == 0 {
+		return []DBEntityInterface{}
+	}
+	tableName := dbr.buildTableName(NewDBFullTextTerm())
+
+	var totalDocs int
+	if err := dbr.DbConnection.QueryRow("SELECT COUNT(DISTINCT object_id) FROM " + tableName).Scan(&totalDocs); err != nil {
+		log.Print("DBRepository::SearchFullText: count error:", err)
+		return nil
+	}
+
+	// objectID -> score accumulated over the words matched so far
+	var scores map[string]float64
+	for i, word := range words {
+		variants := QueryTermVariants(word)
+		exact := make(map[string]bool, len(variants))
+		placeholders := make([]string, 0, len(variants))
+		args := make([]interface{}, 0, len(variants)+1)
+		for _, v := range variants {
+			exact[v] = true
+			placeholders = append(placeholders, "?")
+			args = append(args, v)
+		}
+		clause := "term IN (" + strings.Join(placeholders, ",") + ")"
+		if i == len(words)-1 && len(word) >= 3 {
+			clause += " OR term LIKE ?"
+			args = append(args, word+"%")
+		}
+		q := "SELECT object_id, term, weight FROM " + tableName + " WHERE " + clause
+		if dbr.Verbose {
+			log.Print("DBRepository::SearchFullText: query=", q, " args=", args)
+		}
+		rows, err := dbr.DbConnection.Query(q, args...)
+		if err != nil {
+			log.Print("DBRepository::SearchFullText: Query error:", err)
+			return nil
+		}
+		type hit struct {
+			objectID string
+			term     string
+			weight   int
+		}
+		hits := []hit{}
+		docFreq := make(map[string]int)
+		for rows.Next() {
+			var h hit
+			if err := rows.Scan(&h.objectID, &h.term, &h.weight); err != nil {
+				rows.Close()
+				log.Print("DBRepository::SearchFullText: Scan error:", err)
+				return nil
+			}
+			hits = append(hits, h)
+			docFreq[h.term]++
+		}
+		rows.Close()
+
+		// Best matching term of this word for each object
+		wordScores := make(map[string]float64)
+		for _, h := range hits {
+			idf := math.Log(1 + float64(totalDocs)/float64(docFreq[h.term]))
+			score := float64(h.weight) * idf
+			if !exact[h.term] {
+				// Prefix matches count less than the full word
+				score = score / 2
+			}
+			if score > wordScores[h.objectID] {
+				wordScores[h.objectID] = score
+			}
+		}
+
+		// All the words must match
+		if scores == nil {
+			scores = wordScores
+		} else {
+			for objectID, score := range scores {
+				if ws, ok := wordScores[objectID]; ok {
+					scores[objectID] = score + ws
+				} else {
+					delete(scores, objectID)
+				}
+			}
+		}
+		if len(scores) == 0 {
+			return []DBEntityInterface{}
+		}
+	}
+
+	ids := make([]string, 0, len(scores))
+	for objectID := range scores {
+		ids = append(ids, objectID)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if scores[ids[i]] != scores[ids[j]] {
+			return scores[ids[i]] > scores[ids[j]]
+		}
+		return ids[i] < ids[j]
+	})
+	if len(ids) > fullTextMaxResults {
+		ids = ids[:fullTextMaxResults]
+	}
+
+	results := dbr.selectObjectsByIDs(ids, "", ignoreDeleted)
+	for _, obj := range results {
+		objectID, _ := obj.GetValue("id").(string)
+		obj.SetMetadata("score", math.Round(scores[objectID]*1000)/1000)
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		si, _ := results[i].GetMetadata("score").(float64)
+		sj, _ := results[j].GetMetadata("score").(float64)
+		if si != sj {
+			return si > sj
+		}
+		ni, _ := results[i].GetValue("name").(string)
+		nj, _ := results[j].GetValue("name").(string)
+		return strings.ToLower(ni) < strings.ToLower(nj)
+	})
+	return results
+}
@@ -0,0 +1,172 @@
+package dblayer
+
+import (
+	"fmt"
+	"log"
+)
+
+// projectMemberKind describes an association table between a project and
+// its members: people, companies or other projects
+type projectMemberKind struct {
+	newAssociation func() DBEntityInterface
+	memberColumn   string
+	roleColumn     string
+	memberTable    string
+	roleTable      string
+}
+
+var projectMemberKinds = map[string]projectMemberKind{
+	"people": {
+		newAssociation: func() DBEntityInterface { return NewProjectPerson() },
+		memberColumn:   "people_id",
+		roleColumn:     "projects_people_role_id",
+		memberTable:    "people",
+		roleTable:      "projects_people_roles",
+	},
+	"companies": {
+		newAssociation: func() DBEntityInterface { return NewProjectCompany() },
+		memberColumn:   "company_id",
+		roleColumn:     "projects_companies_role_id",
+		memberTable:    "companies",
+		roleTable:      "projects_companies_roles",
+	},
+	"projects": {
+		newAssociation: func() DBEntityInterface { return NewProjectProject() },
+		memberColumn:   "project2_id",
+		roleColumn:     "projects_projects_role_id",
+		memberTable:    "projects",
+		roleTable:      "projects_projects_roles",
+	},
+}
+
+// ProjectMember is a person, company or project associated to a project with a role
+type ProjectMember struct {
+	Kind       string `json:"kind"`
+	MemberID   string `json:"member_id"`
+	MemberName string `json:"member_name"`
+	RoleID     string `json:"role_id"`
+	RoleName   string `json:"role_name"`
+}
+
+// ProjectMemberKinds returns the valid kinds of project members
+func ProjectMemberKinds() []string {
+	return []string{"people", "companies", "projects"}
+}
+
+func getProjectMemberKind(kind string) (projectMemberKind, error) {
+	k, ok := projectMemberKinds[kind]
+	if !ok {
+		return k, fmt.Errorf("invalid member kind: %s", kind)
+	}
+	return k, nil
+}
+
+// GetProjectMembers returns the members of the given kind (all kinds if empty)
+// of a project. Members the current user cannot read are skipped.
+func (dbr *DBRepository) GetProjectMembers(projectID string, kind string) ([]ProjectMember, error) {
+	kinds := ProjectMemberKinds()
+	if kind != "" {
+		if _, err := getProjectMemberKind(kind); err != nil {
+			return nil, err
+		}
+		kinds = []string{kind}
+	}
+
+	members := []ProjectMember{}
+	for _, kindName := range kinds {
+		k := projectMemberKinds[kindName]
+		search := k.newAssociation()
+		search.SetValue("project_id", projectID)
+		associations, err := dbr.Search(search, false, false, "")
+		if err != nil {
+			return nil, err
+		}
+		for _, assoc := range associations {
+			memberID, _ := assoc.GetValue(k.memberColumn).(string)
+			roleID, _ := assoc.GetValue(k.roleColumn).(string)
+			member := dbr.GetEntityByID(k.memberTable, memberID)
+			if member == nil || !dbr.CheckReadPermission(member) {
+				continue
+			}
+			if obj, ok := member.(DBObjectInterface); ok && obj.HasDeletedDate() {
+				continue
+			}
+			pm := ProjectMember{
+				Kind:     kindName,
+				MemberID: memberID,
+				RoleID:   roleID,
+			}
+			pm.MemberName, _ = member.GetValue("name").(string)
+			if roleID != "" {
+				if role := dbr.GetEntityByID(k.roleTable, roleID); role != nil {
+					pm.RoleName, _ = role.GetValue("name").(string)
+				}
+			}
+			members = append(members, pm)
+		}
+	}
+	return members, nil
+}
+
+// AddProjectMember associates a person, company or project to a project with a role.
+// Adding an existing association is not an error.
+func (dbr *DBRepository) AddProjectMember(projectID string, kind string, memberID string, roleID string) (DBEntityInterface, error) {
+	k, err := getProjectMemberKind(kind)
+	if err != nil {
+		return nil, err
+	}
+	if kind == "projects" && memberID == projectID {
+		return nil, fmt.Errorf("a project cannot be associated to itself")
+	}
+	if dbr.GetEntityByID(k.memberTable, memberID) == nil {
+		return nil, fmt.Errorf("%s not found: %s", kind, memberID)
+	}
+	if roleID != "" && dbr.GetEntityByID(k.roleTable, roleID) == nil {
+		return nil, fmt.Errorf("role not found: %s", roleID)
+	}
+
+	assoc := k.newAssociation()
+	assoc.SetValue("project_id", projectID)
+	assoc.SetValue(k.memberColumn, memberID)
+	assoc.SetValue(k.roleColumn, roleID)
+	existing, err := dbr.Search(assoc, false, false, "")
+	if err != nil {
+		return nil, err
+	}
+	// Empty values are ignored by Search: check the role here
+	for _, e := range existing {
+		if e.GetValue(k.roleColumn) == roleID {
+			return e, nil
+		}
+	}
+	if dbr.Verbose {
+		log.Print("DBRepository::AddProjectMember: ", assoc.ToString())
+	}
+	return dbr.Insert(assoc)
+}
+
+// RemoveProjectMember removes the association of a member to a project.
+// With an empty roleID all the roles of the member are removed.
+// Returns the number of removed associations.
+func (dbr *DBRepository) RemoveProjectMember(projectID string, kind string, memberID string, roleID string) (int, error) {
+	k, err := getProjectMemberKind(kind)
+	if err != nil {
+		return 0, err
+	}
+	search := k.newAssociation()
+	search.SetValue("project_id", projectID)
+	search.SetValue(k.memberColumn, memberID)
+	if roleID != "" {
+		search.SetValue(k.roleColumn, roleID)
+	}
+	associations, err := dbr.Search(search, false, false, "")
+	if err != nil {
+		return 0, err
+	}
+	for _, assoc := range associations {
+		if _, err := dbr.Delete(assoc); err != nil {
+			return 0, err
+		}
+	}
+	return len(associations), nil
+}
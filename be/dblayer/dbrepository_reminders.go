@@ -0,0 +1,150 @@
+package dblayer
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// ErrNotificationNotFound is returned for notifications of other users or unknown ones
+var ErrNotificationNotFound = errors.New("notification not found")
+
+// GetDueEventAlarms returns the alarms of the non deleted events firing in (since, until].
+// Permissions are not checked: the dispatcher delivers each alarm to the owner of the event.
+func (dbr *DBRepository) GetDueEventAlarms(since time.Time, until time.Time, loc *time.Location) ([]EventAlarm, error) {
+	query := "SELECT * FROM " + dbr.buildTableName(NewDBEvent()) + " WHERE deleted_date IS NULL AND alarm = 1"
+	events := dbr.Select("DBEvent", query)
+	if events == nil {
+		return nil, fmt.Errorf("failed to read events")
+	}
+	alarms := []EventAlarm{}
+	for _, event := range events {
+		eventAlarms, err := EventAlarms(event, since, until, loc)
+		if err != nil {
+			log.Print("DBRepository::GetDueEventAlarms: ", err)
+			continue
+		}
+		alarms = append(alarms, eventAlarms...)
+	}
+	return alarms, nil
+}
+
+// newEventReminder returns the reminder of an alarm for a user and a channel, keys only
+func newEventReminder(alarm EventAlarm, userID string, channel string, loc *time.Location) *EventReminder {
+	reminder := NewEventReminder()
+	reminder.SetValue("event_id", alarm.EventID)
+	reminder.SetValue("occurrence_date", alarm.OccurrenceStart.In(loc).Format(time.DateTime))
+	reminder.SetValue("user_id", userID)
+	reminder.SetValue("channel", channel)
+	return reminder
+}
+
+// ClaimEventReminder records that the alarm is about to be delivered to the user on
+// the channel. It returns false when the reminder was already claimed, by this or
+// another instance of the backend: the caller must not deliver it.
+func (dbr *DBRepository) ClaimEventReminder(alarm EventAlarm, userID string, channel string, loc *time.Location) bool {
+	existing, err := dbr.Search(newEventReminder(alarm, userID, channel, loc), false, true, "")
+	if err != nil || len(existing) > 0 {
+		return false
+	}
+	reminder := newEventReminder(alarm, userID, channel, loc)
+	reminder.SetValue("alarm_date", alarm.AlarmTime.In(loc).Format(time.DateTime))
+	// The primary key rejects concurrent claims
+	_, err = dbr.Insert(reminder)
+	return err == nil
+}
+
+// CompleteEventReminder records the outcome of the delivery of a claimed reminder
+func (dbr *DBRepository) CompleteEventReminder(alarm EventAlarm, userID string, channel string, loc *time.Location, sendErr error) error {
+	reminder := newEventReminder(alarm, userID, channel, loc)
+	if sendErr != nil {
+		reminder.SetValue("status", ReminderFailed)
+		reminder.SetValue("error", sendErr.Error())
+	} else {
+		reminder.SetValue("status", ReminderSent)
+		reminder.SetValue("sent_date", CurrentDateTimeString())
+	}
+	_, err := dbr.Update(reminder)
+	return err
+}
+
+// CreateNotification stores an in-app notification for a user
+func (dbr *DBRepository) CreateNotification(userID string, title string, message string, objectID string) (DBEntityInterface, error) {
+	notification := NewDBNotification()
+	notification.SetValue("user_id", userID)
+	notification.SetValue("title", title)
+	notification.SetValue("message", message)
+	if objectID != "" {
+		notification.SetValue("object_id", objectID)
+	}
+	return dbr.Insert(notification)
+}
+
+// GetNotifications returns the notifications of the current user, newest first
+func (dbr *DBRepository) GetNotifications(unreadOnly bool, limit int) ([]DBEntityInterface, error) {
+	query := "SELECT * FROM " + dbr.buildTableName(NewDBNotification()) + " WHERE user_id = ?"
+	if unreadOnly {
+		query += " AND read_date IS NULL"
+	}
+	query += " ORDER BY creation_date DESC"
+	if limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", limit)
+	}
+	notifications := dbr.Select("DBNotification", query, dbr.DbContext.UserID)
+	if notifications == nil {
+		return nil, fmt.Errorf("failed to read notifications")
+	}
+	return notifications, nil
+}
+
+// MarkNotificationRead marks a notification of the current user as read
+func (dbr *DBRepository) MarkNotificationRead(notificationID string) (DBEntityInterface, error) {
+	notification := dbr.GetEntityByID("notifications", notificationID)
+	if notification == nil || stringValue(notification, "user_id") != dbr.DbContext.UserID {
+		return nil, ErrNotificationNotFound
+	}
+	if stringValue(notification, "read_date") != "" {
+		return notification, nil
+	}
+	notification.SetValue("read_date", CurrentDateTimeString())
+	return dbr.Update(notification)
+}
+
+// MarkAllNotificationsRead marks all the notifications of the current user as read
+func (dbr *DBRepository) MarkAllNotificationsRead() error {
+	_, err := dbr.ExecuteSQL("UPDATE "+dbr.buildTableName(NewDBNotification())+
+		" SET read_date = ? WHERE user_id = ? AND read_date IS NULL", CurrentDateTimeString(), dbr.DbContext.UserID)
+	return err
+}
+
+// GetNotificationChannels returns the channel settings of a user by channel name
+func (dbr *DBRepository) GetNotificationChannels(userID string) map[string]DBEntityInterface {
+	settings := make(map[string]DBEntityInterface)
+	search := NewNotificationChannel()
+	search.SetValue("user_id", userID)
+	results, err := dbr.Search(search, false, true, "")
+	if err != nil {
+		return settings
+	}
+	for _, setting := range results {
+		settings[stringValue(setting, "channel")] = setting
+	}
+	return settings
+}
+
+// SetNotificationChannel saves the setting of a channel for the current user
+func (dbr *DBRepository) SetNotificationChannel(channel string, address string, enabled bool) (DBEntityInterface, error) {
+	setting := NewNotificationChannel()
+	setting.SetValue("user_id", dbr.DbContext.UserID)
+	setting.SetValue("channel", channel)
+	setting.SetValue("address", address)
+	setting.SetValue("enabled", "0")
+	if enabled {
+		setting.SetValue("enabled", "1")
+	}
+	if _, exists := dbr.GetNotificationChannels(dbr.DbContext.UserID)[channel]; exists {
+		return dbr.Update(setting)
+	}
+	return dbr.Insert(setting)
+}
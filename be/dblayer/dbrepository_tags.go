@@ -0,0 +1,404 @@
+package dblayer
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+)
+
+// TagCount is a single entry of the tag cloud
+type TagCount struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// unionObjectsQuery builds the UNION over all the registered DBObject tables,
+// returning the common DBObject columns plus the classname.
+// The second value is the number of queries in the UNION, i.e. how many times
+// the arguments of the clause have to be repeated.
+func (dbr *DBRepository) unionObjectsQuery(clause string, ignoreDeleted bool) (string, int) {
+	registeredTypes := dbr.factory.GetAllClassNames()
+	var queries []string
+
+	for _, className := range registeredTypes {
+		dbe := dbr.GetInstanceByClassName(className)
+		if dbe == nil {
+			continue
+		}
+		if !dbe.IsDBObject() {
+			continue
+		}
+		query := "SELECT '" + className + "' as classname, id,owner,group_id,permissions,creator," +
+			"creation_date,last_modify,last_modify_date," +
+			"deleted_by,deleted_date," +
+			"father_id,name,description" +
+			" from " + dbr.buildTableName(dbe) +
+			" WHERE " + clause
+		if ignoreDeleted {
+			query += " AND deleted_date IS NULL"
+		}
+		queries = append(queries, query)
+	}
+	return strings.Join(queries, " UNION "), len(queries)
+}
+
+// GetTagByName returns the tag with the given (normalized) name, or nil
+func (dbr *DBRepository) GetTagByName(name string) DBEntityInterface {
+	return dbr.getTagByNameWithTx(name, nil)
+}
+func (dbr *DBRepository) getTagByNameWithTx(name string, tx *sql.Tx) DBEntityInterface {
+	name = NormalizeTagName(name)
+	if name == "" {
+		return nil
+	}
+	search := NewDBTag()
+	search.SetValue("name", name)
+	results, err := dbr.searchWithTx(search, false, false, "", tx)
+	if err != nil || len(results) == 0 {
+		return nil
+	}
+	return results[0]
+}
+
+// GetAllTags returns all the tags ordered by name
+func (dbr *DBRepository) GetAllTags() []DBEntityInterface {
+	results, err := dbr.Search(NewDBTag(), false, false, "name")
+	if err != nil {
+		log.Print("DBRepository::GetAllTags: error:", err)
+		return nil
+	}
+	return results
+}
+
+// GetReadableTags returns, ordered by name, the tags of at least a non
+// deleted object the current user can read
+func (dbr *DBRepository) GetReadableTags() []DBEntityInterface {
+	readable := make(map[string]bool)
+	for _, tc := range dbr.GetTagCloud() {
+		readable[tc.ID] = true
+	}
+	tags := []DBEntityInterface{}
+	for _, tag := range dbr.GetAllTags() {
+		if readable[tag.GetValue("id").(string)] {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// GetObjectTags returns the tags associated to an object, ordered by name
+func (dbr *DBRepository) GetObjectTags(objectID string) []DBEntityInterface {
+	query := "SELECT t.id, t.name, t.creator, t.creation_date" +
+		" FROM " + dbr.buildTableName(NewDBTag()) + " t" +
+		" JOIN " + dbr.buildTableName(NewObjectTag()) + " ot ON ot.tag_id = t.id" +
+		" WHERE ot.object_id = ? ORDER BY t.name"
+	return dbr.Select("DBTag", query, objectID)
+}
+
+// GetObjectTagNames is a convenience wrapper around GetObjectTags
+func (dbr *DBRepository) GetObjectTagNames(objectID string) []string {
+	names := []string{}
+	for _, tag := range dbr.GetObjectTags(objectID) {
+		names = append(names, tag.GetValue("name").(string))
+	}
+	return names
+}
+
+// TagObject associates the given tags to an object, creating the missing tags.
+// Returns the tags of the object after the operation.
+func (dbr *DBRepository) TagObject(objectID string, tagNames []string) ([]DBEntityInterface, error) {
+	tx, err := dbr.DbConnection.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	for _, tagName := range tagNames {
+		if NormalizeTagName(tagName) == "" {
+			continue
+		}
+		tag := dbr.getTagByNameWithTx(tagName, tx)
+		if tag == nil {
+			newTag := NewDBTag()
+			newTag.SetValue("name", tagName)
+			tag, err = dbr.insertWithTx(newTag, tx)
+			if err != nil {
+				log.Print("DBRepository::TagObject: error creating tag:", err)
+				return nil, err
+			}
+		}
+		association := NewObjectTag()
+		association.SetValue("object_id", objectID)
+		association.SetValue("tag_id", tag.GetValue("id"))
+		existing, err := dbr.searchWithTx(association, false, false, "", tx)
+		if err != nil {
+			return nil, err
+		}
+		if len(existing) > 0 {
+			continue
+		}
+		if _, err := dbr.insertWithTx(association, tx); err != nil {
+			log.Print("DBRepository::TagObject: error tagging object:", err)
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return dbr.GetObjectTags(objectID), nil
+}
+
+// UntagObject removes the given tags from an object.
+// Returns the tags of the object after the operation.
+func (dbr *DBRepository) UntagObject(objectID string, tagNames []string) ([]DBEntityInterface, error) {
+	tx, err := dbr.DbConnection.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	for _, tagName := range tagNames {
+		tag := dbr.getTagByNameWithTx(tagName, tx)
+		if tag == nil {
+			continue
+		}
+		association := NewObjectTag()
+		association.SetValue("object_id", objectID)
+		association.SetValue("tag_id", tag.GetValue("id"))
+		if _, err := dbr.deleteWithTx(association, tx); err != nil {
+			log.Print("DBRepository::UntagObject: error untagging object:", err)
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return dbr.GetObjectTags(objectID), nil
+}
+
+// RenameTag renames a tag. If a tag with the new name already exists,
+// the two tags are merged into the existing one.
+func (dbr *DBRepository) RenameTag(tagID string, newName string) (DBEntityInterface, error) {
+	newName = NormalizeTagName(newName)
+	if newName == "" {
+		return nil, fmt.Errorf("tag name cannot be empty")
+	}
+	tag := dbr.GetEntityByID("tags", tagID)
+	if tag == nil {
+		return nil, fmt.Errorf("tag not found: %s", tagID)
+	}
+	existing := dbr.GetTagByName(newName)
+	if existing != nil && existing.GetValue("id") != tagID {
+		return dbr.MergeTags([]string{tagID}, existing.GetValue("id").(string))
+	}
+	tag.SetValue("name", newName)
+	return dbr.Update(tag)
+}
+
+// MergeTags moves all the associations of the source tags to the target tag,
+// then deletes the source tags.
+func (dbr *DBRepository) MergeTags(sourceIDs []string, targetID string) (DBEntityInterface, error) {
+	target := dbr.GetEntityByID("tags", targetID)
+	if target == nil {
+		return nil, fmt.Errorf("tag not found: %s", targetID)
+	}
+
+	tx, err := dbr.DbConnection.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	objectsTagsTable := dbr.buildTableName(NewObjectTag())
+	for _, sourceID := range sourceIDs {
+		if sourceID == targetID {
+			continue
+		}
+		source := dbr.GetEntityByIDWithTx("tags", sourceID, tx)
+		if source == nil {
+			return nil, fmt.Errorf("tag not found: %s", sourceID)
+		}
+		// Objects already tagged with the target are skipped by INSERT IGNORE
+		query := "INSERT IGNORE INTO " + objectsTagsTable + " (object_id, tag_id, creator, creation_date)" +
+			" SELECT object_id, ?, creator, creation_date FROM " + objectsTagsTable + " WHERE tag_id = ?"
+		if _, err := tx.Exec(query, targetID, sourceID); err != nil {
+			log.Print("DBRepository::MergeTags: error moving associations:", err)
+			return nil, err
+		}
+		// beforeDelete removes the remaining associations of the source
+		if _, err := dbr.deleteWithTx(source, tx); err != nil {
+			log.Print("DBRepository::MergeTags: error deleting tag:", err)
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return target, nil
+}
+
+// GetObjectIDsByTags returns the IDs of the objects having ALL the given tags
+func (dbr *DBRepository) GetObjectIDsByTags(tagNames []string) map[string]bool {
+	ret := make(map[string]bool)
+
+	names := make([]string, 0, len(tagNames))
+	args := make([]interface{}, 0, len(tagNames)+1)
+	for _, tagName := range tagNames {
+		name := NormalizeTagName(tagName)
+		if name == "" {
+			continue
+		}
+		names = append(names, "?")
+		args = append(args, name)
+	}
+	if len(names) == 0 {
+		return ret
+	}
+	args = append(args, len(names))
+
+	query := "SELECT ot.object_id" +
+		" FROM " + dbr.buildTableName(NewObjectTag()) + " ot" +
+		" JOIN " + dbr.buildTableName(NewDBTag()) + " t ON t.id = ot.tag_id" +
+		" WHERE t.name IN (" + strings.Join(names, ",") + ")" +
+		" GROUP BY ot.object_id HAVING COUNT(DISTINCT t.id) = ?"
+	if dbr.Verbose {
+		log.Print("DBRepository::GetObjectIDsByTags: query=", query, " args=", args)
+	}
+	rows, err := dbr.DbConnection.Query(query, args...)
+	if err != nil {
+		log.Print("DBRepository::GetObjectIDsByTags: Query error:", err)
+		return ret
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var objectID string
+		if err := rows.Scan(&objectID); err != nil {
+			log.Print("DBRepository::GetObjectIDsByTags: Scan error:", err)
+			return ret
+		}
+		ret[objectID] = true
+	}
+	return ret
+}
+
+// FilterByTags keeps only the entities having ALL the given tags.
+// An empty tag list leaves the entities untouched.
+func (dbr *DBRepository) FilterByTags(entities []DBEntityInterface, tagNames []string) []DBEntityInterface {
+	if len(tagNames) == 0 {
+		return entities
+	}
+	objectIDs := dbr.GetObjectIDsByTags(tagNames)
+	filtered := make([]DBEntityInterface, 0, len(entities))
+	for _, entity := range entities {
+		if id, ok := entity.GetValue("id").(string); ok && objectIDs[id] {
+			filtered = append(filtered, entity)
+		}
+	}
+	return filtered
+}
+
+// SearchByTags returns all the DBObjects having ALL the given tags
+func (dbr *DBRepository) SearchByTags(tagNames []string, orderBy string, ignoreDeleted bool) []DBEntityInterface {
+	objectIDs := dbr.GetObjectIDsByTags(tagNames)
+	ids := make([]string, 0, len(objectIDs))
+	for id := range objectIDs {
+		ids = append(ids, id)
+	}
+	return dbr.selectObjectsByIDs(ids, orderBy, ignoreDeleted)
+}
+
+// selectObjectsByIDs returns the DBObjects (common columns plus classname)
+// with the given ids, whatever their class is
+func (dbr *DBRepository) selectObjectsByIDs(objectIDs []string, orderBy string, ignoreDeleted bool) []DBEntityInterface {
+	if len(objectIDs) == 0 {
+		return []DBEntityInterface{}
+	}
+	placeholders := make([]string, 0, len(objectIDs))
+	ids := make([]interface{}, 0, len(objectIDs))
+	for _, id := range objectIDs {
+		placeholders = append(placeholders, "?")
+		ids = append(ids, id)
+	}
+	clause := "id IN (" + strings.Join(placeholders, ",") + ")"
+	searchString, nQueries := dbr.unionObjectsQuery(clause, ignoreDeleted)
+	// The same arguments are needed by every query of the UNION
+	args := make([]interface{}, 0, len(ids)*nQueries)
+	for i := 0; i < nQueries; i++ {
+		args = append(args, ids...)
+	}
+	if orderBy != "" {
+		searchString += " ORDER BY " + orderBy
+	}
+	if dbr.Verbose {
+		log.Print("DBRepository::selectObjectsByIDs: searchString=", searchString)
+	}
+	return dbr.Select("DBObject", searchString, args...)
+}
+
+// GetTagCloud returns the tags with the number of non deleted objects
+// the current user can read, ordered by count desc and name.
+func (dbr *DBRepository) GetTagCloud() []TagCount {
+	objectsTagsTable := dbr.buildTableName(NewObjectTag())
+
+	// 1. Objects that have at least a tag, filtered by read permission
+	searchString, _ := dbr.unionObjectsQuery("id IN (SELECT object_id FROM "+objectsTagsTable+")", true)
+	readable := make(map[string]bool)
+	for _, obj := range dbr.FilterByReadPermission(dbr.Select("DBObject", searchString)) {
+		readable[obj.GetValue("id").(string)] = true
+	}
+
+	// 2. Count the associations of the readable objects
+	query := "SELECT ot.object_id, t.id, t.name" +
+		" FROM " + objectsTagsTable + " ot" +
+		" JOIN " + dbr.buildTableName(NewDBTag()) + " t ON t.id = ot.tag_id"
+	rows, err := dbr.DbConnection.Query(query)
+	if err != nil {
+		log.Print("DBRepository::GetTagCloud: Query error:", err)
+		return nil
+	}
+	defer rows.Close()
+
+	counts := make(map[string]*TagCount)
+	for rows.Next() {
+		var objectID, tagID, tagName string
+		if err := rows.Scan(&objectID, &tagID, &tagName); err != nil {
+			log.Print("DBRepository::GetTagCloud: Scan error:", err)
+			return nil
+		}
+		if !readable[objectID] {
+			continue
+		}
+		if _, exists := counts[tagID]; !exists {
+			counts[tagID] = &TagCount{ID: tagID, Name: tagName}
+		}
+		counts[tagID].Count++
+	}
+
+	cloud := make([]TagCount, 0, len(counts))
+	for _, tc := range counts {
+		cloud = append(cloud, *tc)
+	}
+	sort.Slice(cloud, func(i, j int) bool {
+		if cloud[i].Count != cloud[j].Count {
+			return cloud[i].Count > cloud[j].Count
+		}
+		return cloud[i].Name < cloud[j].Name
+	})
+	return cloud
+}
+
+// deleteObjectTagsWithTx removes all the tag associations of an object
+func (dbr *DBRepository) deleteObjectTagsWithTx(objectID string, tx *sql.Tx) error {
+	query := "DELETE FROM " + dbr.buildTableName(NewObjectTag()) + " WHERE object_id = ?"
+	if dbr.Verbose {
+		log.Print("DBRepository::deleteObjectTagsWithTx: query=", query, " objectID=", objectID)
+	}
+	_, err := tx.Exec(query, objectID)
+	return err
+}
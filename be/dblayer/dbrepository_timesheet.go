@@ -0,0 +1,183 @@
+package dblayer
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+var (
+	ErrTimerRunning   = errors.New("a timer is already running")
+	ErrNoTimerRunning = errors.New("no timer running")
+)
+
+// TimesheetFilter selects the timetracks of a timesheet.
+// Empty fields are ignored; To is exclusive.
+type TimesheetFilter struct {
+	From    time.Time
+	To      time.Time
+	Person  string
+	Client  string
+	Project string
+}
+
+// timetrackClientTables are the tables fk_obj_id can refer to
+var timetrackClientTables = []string{"companies", "people", "projects"}
+
+// GetTimesheetEntries returns the timetracks matching the filter that the
+// current user can read, ordered by start time.
+// Person, client and project names are set only when readable by the user.
+func (dbr *DBRepository) GetTimesheetEntries(filter TimesheetFilter) ([]TimesheetEntry, error) {
+	tableName := dbr.buildTableName(NewDBTimetrack())
+	query := "SELECT * FROM " + tableName + " WHERE deleted_date IS NULL"
+	args := []any{}
+	if !filter.From.IsZero() {
+		query += " AND dalle_ore >= ?"
+		args = append(args, filter.From.Format(time.DateTime))
+	}
+	if !filter.To.IsZero() {
+		query += " AND dalle_ore < ?"
+		args = append(args, filter.To.Format(time.DateTime))
+	}
+	if filter.Person != "" {
+		query += " AND owner = ?"
+		args = append(args, filter.Person)
+	}
+	if filter.Client != "" {
+		query += " AND fk_obj_id = ?"
+		args = append(args, filter.Client)
+	}
+	if filter.Project != "" {
+		query += " AND fk_progetto = ?"
+		args = append(args, filter.Project)
+	}
+	query += " ORDER BY dalle_ore, id"
+
+	results := dbr.Select("DBTimetrack", query, args...)
+	if results == nil {
+		return nil, fmt.Errorf("failed to read timetracks")
+	}
+
+	names := make(map[string]string)
+	entries := []TimesheetEntry{}
+	for _, timetrack := range dbr.FilterByReadPermission(results) {
+		entry := NewTimesheetEntry(timetrack)
+		entry.PersonName = dbr.timesheetUserName(entry.Person, names)
+		entry.ClientName = dbr.timesheetObjectName(entry.Client, timetrackClientTables, names)
+		entry.ProjectName = dbr.timesheetObjectName(entry.Project, []string{"projects"}, names)
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// timesheetUserName returns the full name (or login) of a user, caching it in names
+func (dbr *DBRepository) timesheetUserName(userID string, names map[string]string) string {
+	if userID == "" {
+		return ""
+	}
+	if name, ok := names[userID]; ok {
+		return name
+	}
+	name := ""
+	if user := dbr.GetEntityByID("users", userID); user != nil {
+		name = stringValue(user, "fullname")
+		if name == "" {
+			name = stringValue(user, "login")
+		}
+	}
+	names[userID] = name
+	return name
+}
+
+// timesheetObjectName returns the name of the readable object with the given id
+// in one of the tables, caching it in names
+func (dbr *DBRepository) timesheetObjectName(objectID string, tables []string, names map[string]string) string {
+	if objectID == "" {
+		return ""
+	}
+	if name, ok := names[objectID]; ok {
+		return name
+	}
+	name := ""
+	for _, table := range tables {
+		obj := dbr.GetEntityByID(table, objectID)
+		if obj == nil {
+			continue
+		}
+		if dbr.CheckReadPermission(obj) {
+			name = stringValue(obj, "name")
+		}
+		break
+	}
+	names[objectID] = name
+	return name
+}
+
+// checkTimetrackReferences verifies that client and project of a timetrack exist
+func (dbr *DBRepository) checkTimetrackReferences(timetrack DBEntityInterface) error {
+	if client := stringValue(timetrack, "fk_obj_id"); client != "" {
+		found := false
+		for _, table := range timetrackClientTables {
+			if dbr.GetEntityByID(table, client) != nil {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("client not found: %s", client)
+		}
+	}
+	if project := stringValue(timetrack, "fk_progetto"); project != "" {
+		if dbr.GetEntityByID("projects", project) == nil {
+			return fmt.Errorf("project not found: %s", project)
+		}
+	}
+	return nil
+}
+
+// LogTimetrack inserts a timetrack for the current user after checking its references
+func (dbr *DBRepository) LogTimetrack(timetrack DBEntityInterface) (DBEntityInterface, error) {
+	if err := dbr.checkTimetrackReferences(timetrack); err != nil {
+		return nil, err
+	}
+	return dbr.Insert(timetrack)
+}
+
+// GetRunningTimer returns the timer of the current user (a timetrack with
+// dalle_ore but without alle_ore), nil if none is running
+func (dbr *DBRepository) GetRunningTimer() DBEntityInterface {
+	tableName := dbr.buildTableName(NewDBTimetrack())
+	query := "SELECT * FROM " + tableName +
+		" WHERE owner = ? AND deleted_date IS NULL AND dalle_ore IS NOT NULL AND alle_ore IS NULL" +
+		" ORDER BY dalle_ore DESC"
+	results := dbr.Select("DBTimetrack", query, dbr.DbContext.UserID)
+	if len(results) == 0 {
+		return nil
+	}
+	if len(results) > 1 {
+		log.Printf("DBRepository::GetRunningTimer: Warning, %d timers running for user %s", len(results), dbr.DbContext.UserID)
+	}
+	return results[0]
+}
+
+// StartTimer starts a timer for the current user: the timetrack starts now
+// and has no end. Only one timer per user can run.
+func (dbr *DBRepository) StartTimer(timetrack DBEntityInterface) (DBEntityInterface, error) {
+	if dbr.GetRunningTimer() != nil {
+		return nil, ErrTimerRunning
+	}
+	timetrack.SetValue("dalle_ore", CurrentDateTimeString())
+	timetrack.SetValue("alle_ore", nil)
+	return dbr.LogTimetrack(timetrack)
+}
+
+// StopTimer stops the running timer of the current user
+func (dbr *DBRepository) StopTimer() (DBEntityInterface, error) {
+	timer := dbr.GetRunningTimer()
+	if timer == nil {
+		return nil, ErrNoTimerRunning
+	}
+	timer.SetValue("alle_ore", CurrentDateTimeString())
+	return dbr.Update(timer)
+}
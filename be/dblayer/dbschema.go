@@ -659,6 +659,24 @@ func CurrentDateTimeString() string {
 		now.Hour(), now.Minute(), now.Second())
 }
 
+// ParseDateTime parses a datetime as stored in the DB ("2006-01-02 15:04:05")
+// or as sent by the clients (RFC3339, "2006-01-02T15:04", "2006-01-02"),
+// in the local timezone when not specified
+func ParseDateTime(value string) (time.Time, error) {
+	return ParseDateTimeIn(value, time.Local)
+}
+
+// ParseDateTimeIn is ParseDateTime with the given timezone for values without offset
+func ParseDateTimeIn(value string, loc *time.Location) (time.Time, error) {
+	layouts := []string{time.DateTime, time.RFC3339, "2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02 15:04", time.DateOnly}
+	for _, layout := range layouts {
+		if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid datetime: %s", value)
+}
+
 func (dbObject *DBObject) IsDBObject() bool {
 	return true
 }
@@ -775,7 +793,19 @@ func (dbObject *DBObject) beforeUpdate(dbr *DBRepository, tx *sql.Tx) error {
 
 func (dbObject *DBObject) beforeDelete(dbr *DBRepository, tx *sql.Tx) error {
 	if dbObject.HasDeletedDate() {
-		return nil // Already deleted
+		// Already deleted: this is a hard delete, drop the tag associations
+		// and the full-text index entries
+		if objectID, ok := dbObject.GetValue("id").(string); ok && objectID != "" {
+			if err := dbr.deleteObjectTagsWithTx(objectID, tx); err != nil {
+				log.Print("DBObject.beforeDelete: error deleting object tags:", err)
+				return err
+			}
+			if err := dbr.deleteObjectIndexWithTx(objectID, tx); err != nil {
+				log.Print("DBObject.beforeDelete: error deleting full-text index:", err)
+				return err
+			}
+		}
+		return nil
 	}
 	user := dbr.GetCurrentUser()
 	userID := user.GetValue("id").(string)
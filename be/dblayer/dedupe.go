@@ -0,0 +1,240 @@
+package dblayer
+
+import (
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Weights of the signals of a duplicate; scores are capped at 100
+const (
+	duplicateScoreCodiceFiscale = 60
+	duplicateScoreEmail         = 40
+	duplicateScorePhone         = 30
+	duplicateScoreName          = 30
+)
+
+// DefaultDuplicateMinScore is the score from which two people are reported as duplicates
+const DefaultDuplicateMinScore = 50
+
+// minNameSimilarity is the similarity from which two names count as a signal
+const minNameSimilarity = 0.8
+
+// DuplicateCandidate is a pair of people that may be the same person
+type DuplicateCandidate struct {
+	ID1     string   `json:"id1"`
+	Name1   string   `json:"name1"`
+	ID2     string   `json:"id2"`
+	Name2   string   `json:"name2"`
+	Score   int      `json:"score"`
+	Reasons []string `json:"reasons"`
+}
+
+// personMergeColumns are the columns of DBPerson a merge can take from the merged person
+var personMergeColumns = []string{
+	"name", "description", "street", "zip", "city", "state", "fk_countrylist_id", "fk_companies_id",
+	"fk_users_id", "phone", "mobile", "fax", "email", "url", "office_phone", "codice_fiscale", "p_iva",
+}
+
+// normalizeName lowercases a name, drops accents and punctuation and sorts
+// its words, so that "Rossi, Mario" and "mario rossi" are the same
+func normalizeName(name string) string {
+	words := SplitWords(name)
+	sort.Strings(words)
+	return strings.Join(words, " ")
+}
+
+// normalizePhone keeps the digits of a phone number, without the Italian prefix
+func normalizePhone(phone string) string {
+	digits := strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, phone)
+	digits = strings.TrimPrefix(digits, "00")
+	if strings.HasPrefix(digits, "39") && len(digits) > 10 {
+		digits = digits[2:]
+	}
+	if len(digits) < 6 {
+		return ""
+	}
+	return digits
+}
+
+// NameSimilarity returns the similarity of two names between 0 and 1, from
+// the edit distance of their normalized forms
+func NameSimilarity(a string, b string) float64 {
+	ra, rb := []rune(normalizeName(a)), []rune(normalizeName(b))
+	if len(ra) == 0 || len(rb) == 0 {
+		return 0
+	}
+	previous := make([]int, len(rb)+1)
+	current := make([]int, len(rb)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		current[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return 1 - float64(previous[len(rb)])/float64(max(len(ra), len(rb)))
+}
+
+// personPhones returns the normalized phone numbers of a person
+func personPhones(dbe DBEntityInterface) []string {
+	phones := []string{}
+	for _, column := range []string{"phone", "mobile", "office_phone"} {
+		if phone := normalizePhone(stringValue(dbe, column)); phone != "" {
+			phones = append(phones, phone)
+		}
+	}
+	return phones
+}
+
+// ScoreDuplicate scores how likely two people are the same person, from 0 to
+// 100, with the reasons of the score: same codice fiscale, email or phone
+// number, and similar names
+func ScoreDuplicate(a DBEntityInterface, b DBEntityInterface) (int, []string) {
+	score := 0
+	reasons := []string{}
+	if cf := strings.ToUpper(strings.TrimSpace(stringValue(a, "codice_fiscale"))); cf != "" &&
+		cf == strings.ToUpper(strings.TrimSpace(stringValue(b, "codice_fiscale"))) {
+		score += duplicateScoreCodiceFiscale
+		reasons = append(reasons, "codice_fiscale")
+	}
+	if email := strings.ToLower(strings.TrimSpace(stringValue(a, "email"))); email != "" &&
+		email == strings.ToLower(strings.TrimSpace(stringValue(b, "email"))) {
+		score += duplicateScoreEmail
+		reasons = append(reasons, "email")
+	}
+	phonesB := personPhones(b)
+	for _, phone := range personPhones(a) {
+		if slices.Contains(phonesB, phone) {
+			score += duplicateScorePhone
+			reasons = append(reasons, "phone")
+			break
+		}
+	}
+	if similarity := NameSimilarity(stringValue(a, "name"), stringValue(b, "name")); similarity >= minNameSimilarity {
+		score += int(similarity * duplicateScoreName)
+		reasons = append(reasons, "name")
+	}
+	return min(score, 100), reasons
+}
+
+// duplicateBlockingKeys are the keys grouping the people worth comparing:
+// codice fiscale, email, phones and the words of the name
+func duplicateBlockingKeys(dbe DBEntityInterface) []string {
+	keys := []string{}
+	if cf := strings.ToUpper(strings.TrimSpace(stringValue(dbe, "codice_fiscale"))); cf != "" {
+		keys = append(keys, "cf:"+cf)
+	}
+	if email := strings.ToLower(strings.TrimSpace(stringValue(dbe, "email"))); email != "" {
+		keys = append(keys, "email:"+email)
+	}
+	for _, phone := range personPhones(dbe) {
+		keys = append(keys, "phone:"+phone)
+	}
+	for _, word := range strings.Fields(normalizeName(stringValue(dbe, "name"))) {
+		if len(word) >= 3 {
+			keys = append(keys, "name:"+word)
+		}
+	}
+	return keys
+}
+
+// FindDuplicates returns the pairs of people scoring at least minScore, best first.
+// Only the people sharing a codice fiscale, an email, a phone or a word of the
+// name are compared.
+func FindDuplicates(people []DBEntityInterface, minScore int) []DuplicateCandidate {
+	blocks := make(map[string][]int)
+	for i, person := range people {
+		for _, key := range duplicateBlockingKeys(person) {
+			blocks[key] = append(blocks[key], i)
+		}
+	}
+	type pair struct{ i, j int }
+	compared := make(map[pair]bool)
+	candidates := []DuplicateCandidate{}
+	for _, block := range blocks {
+		for x := 0; x < len(block); x++ {
+			for y := x + 1; y < len(block); y++ {
+				p := pair{block[x], block[y]}
+				if p.i == p.j || compared[p] {
+					continue
+				}
+				compared[p] = true
+				score, reasons := ScoreDuplicate(people[p.i], people[p.j])
+				if score < minScore {
+					continue
+				}
+				candidates = append(candidates, DuplicateCandidate{
+					ID1:     stringValue(people[p.i], "id"),
+					Name1:   stringValue(people[p.i], "name"),
+					ID2:     stringValue(people[p.j], "id"),
+					Name2:   stringValue(people[p.j], "name"),
+					Score:   score,
+					Reasons: reasons,
+				})
+			}
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].Score != candidates[j].Score {
+			return candidates[i].Score > candidates[j].Score
+		}
+		return candidates[i].ID1+candidates[i].ID2 < candidates[j].ID1+candidates[j].ID2
+	})
+	return candidates
+}
+
+// Winners of a field in a merge
+const (
+	MergeKeepSurvivor = "survivor"
+	MergeTakeMerged   = "merged"
+)
+
+// MergeFieldChange is a field of the survivor changed by a merge
+type MergeFieldChange struct {
+	Old any `json:"old"`
+	New any `json:"new"`
+}
+
+// MergePersonFields copies to the survivor the fields of the merged person
+// chosen in winners (column -> MergeKeepSurvivor or MergeTakeMerged). For the
+// other columns the survivor wins, unless its value is empty; descriptions are
+// concatenated. Returns the changed fields.
+func MergePersonFields(survivor DBEntityInterface, merged DBEntityInterface, winners map[string]string) map[string]MergeFieldChange {
+	changes := make(map[string]MergeFieldChange)
+	for _, column := range personMergeColumns {
+		oldValue, newValue := stringValue(survivor, column), stringValue(merged, column)
+		value := oldValue
+		switch {
+		case winners[column] == MergeTakeMerged:
+			value = newValue
+		case winners[column] == MergeKeepSurvivor:
+		case oldValue == "":
+			value = newValue
+		case column == "description" && newValue != "" && !strings.Contains(oldValue, newValue):
+			value = oldValue + "\n\n" + newValue
+		}
+		if value == oldValue || (column == "name" && value == "") {
+			continue
+		}
+		changes[column] = MergeFieldChange{Old: survivor.GetValue(column), New: value}
+		if value == "" {
+			survivor.SetValue(column, nil)
+		} else {
+			survivor.SetValue(column, value)
+		}
+	}
+	return changes
+}
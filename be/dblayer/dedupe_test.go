@@ -0,0 +1,155 @@
+package dblayer
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+)
+
+func newTestPerson(id string, values map[string]string) DBEntityInterface {
+	person := NewDBPerson()
+	person.SetValue("id", id)
+	for column, value := range values {
+		person.SetValue(column, value)
+	}
+	return person
+}
+
+func TestNameSimilarity(t *testing.T) {
+	cases := []struct {
+		a, b string
+		min  float64
+		max  float64
+	}{
+		{"Mario Rossi", "Rossi, Mario", 1, 1},
+		{"Nicolò Bianchi", "nicolo bianchi", 1, 1},
+		{"Mario Rossi", "Maria Rossi", 0.9, 0.95},
+		{"Mario Rossi", "Giulia Verdi", 0, 0.5},
+		{"", "Mario", 0, 0},
+	}
+	for _, c := range cases {
+		if got := NameSimilarity(c.a, c.b); got < c.min || got > c.max {
+			t.Errorf("%q %q: got %v, want [%v, %v]", c.a, c.b, got, c.min, c.max)
+		}
+	}
+}
+
+func TestScoreDuplicate(t *testing.T) {
+	a := newTestPerson("1", map[string]string{"name": "Mario Rossi", "email": "Mario@Example.com", "mobile": "+39 333 1234567", "codice_fiscale": "RSSMRA80A01F205X"})
+	b := newTestPerson("2", map[string]string{"name": "Rossi Mario", "email": "mario@example.com ", "phone": "333/1234567", "codice_fiscale": "rssmra80a01f205x"})
+	score, reasons := ScoreDuplicate(a, b)
+	if score != 100 || !slices.Equal(reasons, []string{"codice_fiscale", "email", "phone", "name"}) {
+		t.Errorf("got %d %v", score, reasons)
+	}
+	c := newTestPerson("3", map[string]string{"name": "Giulia Verdi", "email": "giulia@example.com"})
+	if score, reasons := ScoreDuplicate(a, c); score != 0 || len(reasons) != 0 {
+		t.Errorf("got %d %v", score, reasons)
+	}
+}
+
+func TestFindDuplicates(t *testing.T) {
+	people := []DBEntityInterface{
+		newTestPerson("1", map[string]string{"name": "Mario Rossi", "email": "mario@example.com"}),
+		newTestPerson("2", map[string]string{"name": "Giulia Verdi", "phone": "02 1234567"}),
+		newTestPerson("3", map[string]string{"name": "Mario Rossi", "email": "MARIO@example.com"}),
+		newTestPerson("4", map[string]string{"name": "G. Verdi", "office_phone": "+39 02 1234567"}),
+		newTestPerson("5", map[string]string{"name": "Luca Neri"}),
+	}
+	candidates := FindDuplicates(people, duplicateScorePhone)
+	if len(candidates) != 2 {
+		t.Fatalf("got %+v", candidates)
+	}
+	if candidates[0].ID1 != "1" || candidates[0].ID2 != "3" || candidates[0].Score != 70 {
+		t.Errorf("first: %+v", candidates[0])
+	}
+	if candidates[1].ID1 != "2" || candidates[1].ID2 != "4" || !slices.Equal(candidates[1].Reasons, []string{"phone"}) {
+		t.Errorf("second: %+v", candidates[1])
+	}
+}
+
+func TestMergePersonFields(t *testing.T) {
+	survivor := newTestPerson("1", map[string]string{"name": "Mario Rossi", "email": "mario@example.com", "description": "Cliente"})
+	merged := newTestPerson("2", map[string]string{"name": "M. Rossi", "email": "rossi@example.com", "mobile": "333", "description": "Fornitore", "city": "Milano"})
+	changes := MergePersonFields(survivor, merged, map[string]string{"email": MergeTakeMerged, "city": MergeKeepSurvivor})
+	if stringValue(survivor, "name") != "Mario Rossi" || stringValue(survivor, "email") != "rossi@example.com" ||
+		stringValue(survivor, "mobile") != "333" || stringValue(survivor, "city") != "" ||
+		stringValue(survivor, "description") != "Cliente\n\nFornitore" {
+		t.Errorf("survivor: %v", survivor.GetAllValues())
+	}
+	if len(changes) != 3 || changes["email"].Old != "mario@example.com" || changes["email"].New != "rossi@example.com" {
+		t.Errorf("changes: %+v", changes)
+	}
+}
+
+func TestMergePeople(t *testing.T) {
+	repo := setupTestRepo(t)
+	survivor := createTestObject(t, repo, "people", map[string]any{"name": "Mario Rossi", "email": "mario@example.com"}, nil)
+	defer hardDeleteForTests(repo, survivor.(DBObjectInterface))
+	merged := createTestObject(t, repo, "people", map[string]any{"name": "M. Rossi", "mobile": "333"}, nil)
+	defer hardDeleteForTests(repo, merged.(DBObjectInterface))
+	project := createTestObject(t, repo, "projects", map[string]any{"name": "Merge Project"}, nil)
+	defer hardDeleteForTests(repo, project.(DBObjectInterface))
+	manager := createTestObject(t, repo, "projects_people_roles", map[string]any{"name": "Manager"}, nil)
+	defer hardDeleteForTests(repo, manager.(DBObjectInterface))
+	developer := createTestObject(t, repo, "projects_people_roles", map[string]any{"name": "Developer"}, nil)
+	defer hardDeleteForTests(repo, developer.(DBObjectInterface))
+
+	survivorID, mergedID := stringValue(survivor, "id"), stringValue(merged, "id")
+	projectID := stringValue(project, "id")
+	managerID, developerID := stringValue(manager, "id"), stringValue(developer, "id")
+	defer repo.RemoveProjectMember(projectID, "people", survivorID, "")
+	defer repo.RemoveProjectMember(projectID, "people", mergedID, "")
+	// Both managers: the association of the merged person is a duplicate
+	for _, member := range [][2]string{{survivorID, managerID}, {mergedID, managerID}, {mergedID, developerID}} {
+		if _, err := repo.AddProjectMember(projectID, "people", member[0], member[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tag := testTagName(t, "merged")
+	defer deleteTestTag(repo, tag)
+	if _, err := repo.TagObject(mergedID, []string{tag}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := repo.MergePeople(survivorID, survivorID, nil); err == nil {
+		t.Errorf("expected a person not to be merged with itself")
+	}
+	result, history, err := repo.MergePeople(survivorID, mergedID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer repo.ExecuteSQL("DELETE FROM "+repo.buildTableName(NewContactMerge())+" WHERE id = ?", history.GetValue("id"))
+	if stringValue(result, "mobile") != "333" || stringValue(result, "email") != "mario@example.com" {
+		t.Errorf("survivor: %v", result.GetAllValues())
+	}
+	if deleted := repo.GetEntityByID("people", mergedID); deleted == nil || !deleted.(DBObjectInterface).HasDeletedDate() {
+		t.Errorf("expected the merged person to be deleted")
+	}
+
+	// The survivor is manager and developer, the merged person is no member anymore
+	members, err := repo.GetProjectMembers(projectID, "people")
+	if err != nil {
+		t.Fatal(err)
+	}
+	roles := map[string]bool{}
+	for _, member := range members {
+		if member.MemberID != survivorID {
+			t.Errorf("expected only the survivor, got %+v", member)
+		}
+		roles[member.RoleName] = true
+	}
+	if len(members) != 2 || !roles["Manager"] || !roles["Developer"] {
+		t.Errorf("expected the roles Manager and Developer, got %+v", members)
+	}
+	if !slices.Contains(repo.GetObjectTagNames(survivorID), tag) {
+		t.Errorf("expected the tag %q on the survivor, got %v", tag, repo.GetObjectTagNames(survivorID))
+	}
+
+	var moved map[string]int64
+	if err := json.Unmarshal([]byte(stringValue(history, "moved_references")), &moved); err != nil {
+		t.Fatal(err)
+	}
+	if moved["projects_people.people_id"] != 1 || moved["objects_tags.object_id"] != 1 {
+		t.Errorf("moved references: %v", moved)
+	}
+}
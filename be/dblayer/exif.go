@@ -0,0 +1,556 @@
+package dblayer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/draw"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+/*
+EXIF metadata of the JPEG images.
+
+The EXIF block is the APP1 segment of a JPEG, holding a TIFF structure:
+a list of IFDs (directories of tagged values). IFD0 describes the image,
+like its orientation and camera, and points to the Exif IFD (capture time)
+and to the GPS IFD (location). Only the tags used here are read.
+
+The block can be rewritten without some tags (see stripJpegMetadata): the
+IFDs are copied with the values they point to, so the offsets of the
+entries kept stay valid. IFD1 (the embedded thumbnail), the Interop IFD and
+the maker note (whose offsets are private to each vendor) are never copied.
+*/
+
+// EXIF tags
+const (
+	exifTagMake             = 0x010F
+	exifTagModel            = 0x0110
+	exifTagOrientation      = 0x0112
+	exifTagDateTime         = 0x0132
+	exifTagArtist           = 0x013B
+	exifTagHostComputer     = 0x013C
+	exifTagExifIFD          = 0x8769
+	exifTagGPSIFD           = 0x8825
+	exifTagDateTimeOriginal = 0x9003
+	exifTagMakerNote        = 0x927C
+	exifTagUserComment      = 0x9286
+	exifTagXPComment        = 0x9C9C
+	exifTagXPAuthor         = 0x9C9D
+	exifTagInteropIFD       = 0xA005
+	exifTagImageUniqueID    = 0xA420
+	exifTagCameraOwnerName  = 0xA430
+	exifTagBodySerialNumber = 0xA431
+	exifTagLensSerialNumber = 0xA435
+
+	gpsTagLatitudeRef  = 0x0001
+	gpsTagLatitude     = 0x0002
+	gpsTagLongitudeRef = 0x0003
+	gpsTagLongitude    = 0x0004
+)
+
+// exifPrivateTags identify a person or a device: stripped with ExifPolicyPrivate
+var exifPrivateTags = map[uint16]bool{
+	exifTagArtist:           true,
+	exifTagHostComputer:     true,
+	exifTagUserComment:      true,
+	exifTagXPComment:        true,
+	exifTagXPAuthor:         true,
+	exifTagImageUniqueID:    true,
+	exifTagCameraOwnerName:  true,
+	exifTagBodySerialNumber: true,
+	exifTagLensSerialNumber: true,
+}
+
+// TIFF types of the values
+const (
+	tiffByte      = 1
+	tiffASCII     = 2
+	tiffShort     = 3
+	tiffLong      = 4
+	tiffRational  = 5
+	tiffUndefined = 7
+)
+
+// tiffTypeSizes are the sizes in bytes of the TIFF types, by type
+var tiffTypeSizes = map[uint16]uint32{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8}
+
+// exifDateFormat is the format of the dates of the EXIF
+const exifDateFormat = "2006:01:02 15:04:05"
+
+// errNoExif is returned for a JPEG without EXIF
+var errNoExif = errors.New("no EXIF metadata")
+
+// errBadJpeg is returned for a JPEG whose segments cannot be read
+var errBadJpeg = errors.New("malformed JPEG")
+
+// jpegSegment reads the next segment of a JPEG, before the image data.
+// segment is nil for the markers without a length.
+func jpegSegment(br *bufio.Reader) (marker byte, segment []byte, err error) {
+	b, err := br.ReadByte()
+	if err != nil || b != 0xFF {
+		return 0, nil, errBadJpeg
+	}
+	for {
+		marker, err = br.ReadByte()
+		if err != nil {
+			return 0, nil, errBadJpeg
+		}
+		// 0xFF are fill bytes
+		if marker != 0xFF {
+			break
+		}
+	}
+	if marker == 0x01 || marker == 0xD9 || (marker >= 0xD0 && marker <= 0xD7) {
+		// No length
+		return marker, nil, nil
+	}
+	var length [2]byte
+	if _, err := io.ReadFull(br, length[:]); err != nil {
+		return 0, nil, errBadJpeg
+	}
+	n := int(binary.BigEndian.Uint16(length[:])) - 2
+	if n < 0 {
+		return 0, nil, errBadJpeg
+	}
+	segment = make([]byte, n)
+	if _, err := io.ReadFull(br, segment); err != nil {
+		return 0, nil, errBadJpeg
+	}
+	return marker, segment, nil
+}
+
+// isJpeg tells if a blob starts with the SOI of a JPEG
+func isJpeg(head []byte) bool {
+	return len(head) >= 2 && head[0] == 0xFF && head[1] == 0xD8
+}
+
+// jpegExif returns the TIFF structure of the EXIF block of a JPEG, reading
+// the segments before the image data only
+func jpegExif(r io.Reader) ([]byte, error) {
+	br := bufio.NewReader(r)
+	var soi [2]byte
+	if _, err := io.ReadFull(br, soi[:]); err != nil || !isJpeg(soi[:]) {
+		return nil, errNoExif
+	}
+	for {
+		marker, segment, err := jpegSegment(br)
+		if err != nil || marker == 0xD9 || marker == 0xDA {
+			// Malformed, end of image or start of the image data
+			return nil, errNoExif
+		}
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return segment[6:], nil
+		}
+	}
+}
+
+// tiffEntry is an entry of an IFD
+type tiffEntry struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	// The value, or its offset when longer than 4 bytes
+	value []byte
+}
+
+// tiffReader reads the IFDs of a TIFF structure
+type tiffReader struct {
+	data  []byte
+	order binary.ByteOrder
+}
+
+// newTiffReader checks the header of a TIFF structure
+func newTiffReader(data []byte) (*tiffReader, error) {
+	if len(data) < 8 {
+		return nil, errNoExif
+	}
+	t := &tiffReader{data: data}
+	switch string(data[:2]) {
+	case "II":
+		t.order = binary.LittleEndian
+	case "MM":
+		t.order = binary.BigEndian
+	default:
+		return nil, errNoExif
+	}
+	if t.order.Uint16(data[2:4]) != 42 {
+		return nil, errNoExif
+	}
+	return t, nil
+}
+
+// firstIFD returns the offset of IFD0
+func (t *tiffReader) firstIFD() uint32 {
+	return t.order.Uint32(t.data[4:8])
+}
+
+// ifd reads the entries of the IFD at offset
+func (t *tiffReader) ifd(offset uint32) map[uint16]tiffEntry {
+	entries := map[uint16]tiffEntry{}
+	if uint64(offset)+2 > uint64(len(t.data)) {
+		return entries
+	}
+	n := int(t.order.Uint16(t.data[offset:]))
+	for i := 0; i < n; i++ {
+		start := uint64(offset) + 2 + uint64(i)*12
+		if start+12 > uint64(len(t.data)) {
+			break
+		}
+		e := t.data[start : start+12]
+		entries[t.order.Uint16(e[0:2])] = tiffEntry{
+			tag:   t.order.Uint16(e[0:2]),
+			typ:   t.order.Uint16(e[2:4]),
+			count: t.order.Uint32(e[4:8]),
+			value: e[8:12],
+		}
+	}
+	return entries
+}
+
+// uint returns a SHORT or LONG value
+func (t *tiffReader) uint(e tiffEntry) (uint32, bool) {
+	switch e.typ {
+	case tiffShort:
+		return uint32(t.order.Uint16(e.value)), true
+	case tiffLong:
+		return t.order.Uint32(e.value), true
+	}
+	return 0, false
+}
+
+// bytes returns the data of an entry, inline or at its offset
+func (t *tiffReader) bytes(e tiffEntry) ([]byte, bool) {
+	size, ok := tiffTypeSizes[e.typ]
+	if !ok || e.count > uint32(len(t.data)) {
+		return nil, false
+	}
+	n := uint64(size) * uint64(e.count)
+	if n <= 4 {
+		return e.value[:n], true
+	}
+	offset := uint64(t.order.Uint32(e.value))
+	if offset+n > uint64(len(t.data)) {
+		return nil, false
+	}
+	return t.data[offset : offset+n], true
+}
+
+// string returns an ASCII value, without the trailing NULs and spaces
+func (t *tiffReader) string(e tiffEntry) string {
+	if e.typ != tiffASCII {
+		return ""
+	}
+	data, ok := t.bytes(e)
+	if !ok {
+		return ""
+	}
+	if i := bytes.IndexByte(data, 0); i >= 0 {
+		data = data[:i]
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// rationals returns the RATIONAL values of an entry
+func (t *tiffReader) rationals(e tiffEntry) []float64 {
+	if e.typ != tiffRational {
+		return nil
+	}
+	data, ok := t.bytes(e)
+	if !ok {
+		return nil
+	}
+	values := make([]float64, 0, e.count)
+	for i := 0; i+8 <= len(data); i += 8 {
+		numerator, denominator := t.order.Uint32(data[i:]), t.order.Uint32(data[i+4:])
+		if denominator == 0 {
+			return nil
+		}
+		values = append(values, float64(numerator)/float64(denominator))
+	}
+	return values
+}
+
+// subIFD returns the entries of the IFD an entry points to
+func (t *tiffReader) subIFD(e tiffEntry) map[uint16]tiffEntry {
+	offset, ok := t.uint(e)
+	if !ok {
+		return map[uint16]tiffEntry{}
+	}
+	return t.ifd(offset)
+}
+
+// exifOrientation returns the EXIF orientation of a JPEG (1 to 8), 1 when unknown
+func exifOrientation(tiff []byte) int {
+	t, err := newTiffReader(tiff)
+	if err != nil {
+		return 1
+	}
+	if e, ok := t.ifd(t.firstIFD())[exifTagOrientation]; ok {
+		if v, ok := t.uint(e); ok && v >= 1 && v <= 8 {
+			return int(v)
+		}
+	}
+	return 1
+}
+
+// orientImage applies an EXIF orientation, returning the image as it is
+// meant to be seen. Orientations 5 to 8 swap width and height.
+func orientImage(img image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	src := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2: // Mirrored
+				sx, sy = w-1-x, y
+			case 3: // Rotated 180°
+				sx, sy = w-1-x, h-1-y
+			case 4: // Upside down mirrored
+				sx, sy = x, h-1-y
+			case 5: // Transposed
+				sx, sy = y, x
+			case 6: // Rotated 90° clockwise
+				sx, sy = y, h-1-x
+			case 7: // Transversed
+				sx, sy = w-1-y, h-1-x
+			case 8: // Rotated 90° counterclockwise
+				sx, sy = w-1-y, x
+			}
+			i, j := src.PixOffset(sx, sy), dst.PixOffset(x, y)
+			copy(dst.Pix[j:j+4], src.Pix[i:i+4])
+		}
+	}
+	return dst
+}
+
+// exifInfo is the metadata read from an EXIF block
+type exifInfo struct {
+	Orientation int
+	Camera      string
+	// Capture time, the local time of the camera
+	Taken     time.Time
+	Latitude  *float64
+	Longitude *float64
+}
+
+// parseExif reads the metadata of an EXIF block
+func parseExif(tiff []byte) exifInfo {
+	info := exifInfo{Orientation: exifOrientation(tiff)}
+	t, err := newTiffReader(tiff)
+	if err != nil {
+		return info
+	}
+	ifd0 := t.ifd(t.firstIFD())
+
+	// The model often repeats the make: "Canon" "Canon EOS 5D"
+	maker, model := t.string(ifd0[exifTagMake]), t.string(ifd0[exifTagModel])
+	if model != "" && strings.HasPrefix(strings.ToLower(model), strings.ToLower(maker)) {
+		info.Camera = model
+	} else {
+		info.Camera = strings.TrimSpace(maker + " " + model)
+	}
+
+	taken := t.string(t.subIFD(ifd0[exifTagExifIFD])[exifTagDateTimeOriginal])
+	if taken == "" {
+		taken = t.string(ifd0[exifTagDateTime])
+	}
+	if when, err := time.Parse(exifDateFormat, taken); err == nil {
+		info.Taken = when
+	}
+
+	if e, ok := ifd0[exifTagGPSIFD]; ok {
+		gps := t.subIFD(e)
+		latitude, okLatitude := gpsCoordinate(t, gps[gpsTagLatitude], gps[gpsTagLatitudeRef], "S", 90)
+		longitude, okLongitude := gpsCoordinate(t, gps[gpsTagLongitude], gps[gpsTagLongitudeRef], "W", 180)
+		if okLatitude && okLongitude {
+			info.Latitude, info.Longitude = &latitude, &longitude
+		}
+	}
+	return info
+}
+
+// gpsCoordinate converts degrees, minutes and seconds to decimal degrees,
+// negative for the negative reference (south or west)
+func gpsCoordinate(t *tiffReader, value tiffEntry, ref tiffEntry, negative string, limit float64) (float64, bool) {
+	dms := t.rationals(value)
+	if len(dms) != 3 {
+		return 0, false
+	}
+	degrees := dms[0] + dms[1]/60 + dms[2]/3600
+	if math.IsNaN(degrees) || degrees > limit {
+		return 0, false
+	}
+	if t.string(ref) == negative {
+		degrees = -degrees
+	}
+	return degrees, true
+}
+
+// exifFilter tells if a tag of an IFD is kept when rewriting an EXIF block.
+// ifd is 0 for IFD0, the tag pointing to it for the others.
+type exifFilter func(ifd uint16, tag uint16) bool
+
+// rewrite returns a TIFF structure with the tags kept by keep only
+func (t *tiffReader) rewrite(keep exifFilter) []byte {
+	var out bytes.Buffer
+	header := make([]byte, 8)
+	copy(header, t.data[:4])
+	t.order.PutUint32(header[4:], 8)
+	out.Write(header)
+	t.writeIFD(&out, t.firstIFD(), 0, keep)
+	return out.Bytes()
+}
+
+// writeIFD appends an IFD with the entries kept, followed by their values
+// and sub-IFDs, returning its offset. The next IFD is dropped.
+func (t *tiffReader) writeIFD(out *bytes.Buffer, offset uint32, ifd uint16, keep exifFilter) uint32 {
+	var entries []tiffEntry
+	for tag, e := range t.ifd(offset) {
+		switch {
+		case tag == exifTagInteropIFD || tag == exifTagMakerNote || !keep(ifd, tag):
+			continue
+		case tag == exifTagExifIFD || tag == exifTagGPSIFD:
+			// Sub-IFDs of IFD0 only
+			if _, ok := t.uint(e); !ok || ifd != 0 {
+				continue
+			}
+		default:
+			if _, ok := t.bytes(e); !ok {
+				continue
+			}
+		}
+		entries = append(entries, e)
+	}
+	// The entries of an IFD are sorted by tag
+	sort.Slice(entries, func(i, j int) bool { return entries[i].tag < entries[j].tag })
+
+	if out.Len()%2 == 1 {
+		out.WriteByte(0)
+	}
+	start := uint32(out.Len())
+	table := make([]byte, 2+12*len(entries)+4)
+	t.order.PutUint16(table, uint16(len(entries)))
+	out.Write(table)
+	for i, e := range entries {
+		entry := make([]byte, 12)
+		t.order.PutUint16(entry[0:], e.tag)
+		t.order.PutUint16(entry[2:], e.typ)
+		t.order.PutUint32(entry[4:], e.count)
+		if e.tag == exifTagExifIFD || e.tag == exifTagGPSIFD {
+			sub, _ := t.uint(e)
+			t.order.PutUint16(entry[2:], tiffLong)
+			t.order.PutUint32(entry[4:], 1)
+			t.order.PutUint32(entry[8:], t.writeIFD(out, sub, e.tag, keep))
+		} else if data, _ := t.bytes(e); len(data) <= 4 {
+			copy(entry[8:], data)
+		} else {
+			if out.Len()%2 == 1 {
+				out.WriteByte(0)
+			}
+			t.order.PutUint32(entry[8:], uint32(out.Len()))
+			out.Write(data)
+		}
+		position := int(start) + 2 + 12*i
+		copy(out.Bytes()[position:position+12], entry)
+	}
+	return start
+}
+
+// Policies of the EXIF of the JPEG uploaded in a folder (exif_policy)
+const (
+	// The blob is stored as uploaded
+	ExifPolicyKeep = ""
+	// The location is stripped: the GPS IFD and the XMP
+	ExifPolicyLocation = "location"
+	// The location, the data identifying people and devices (serial numbers,
+	// owner, author, comments) and the IPTC are stripped
+	ExifPolicyPrivate = "private"
+)
+
+// ExifPolicies are the valid values of exif_policy
+var ExifPolicies = []string{ExifPolicyKeep, ExifPolicyLocation, ExifPolicyPrivate}
+
+// exifPolicyFilter returns the tags kept by a policy
+func exifPolicyFilter(policy string) exifFilter {
+	return func(ifd uint16, tag uint16) bool {
+		if tag == exifTagGPSIFD {
+			return false
+		}
+		if policy == ExifPolicyPrivate {
+			return !exifPrivateTags[tag]
+		}
+		return true
+	}
+}
+
+// stripJpegMetadata copies a JPEG without the metadata removed by a policy.
+// The image data is copied as is, the orientation and the capture time are kept.
+func stripJpegMetadata(r io.Reader, w io.Writer, policy string) error {
+	br := bufio.NewReader(r)
+	var soi [2]byte
+	if _, err := io.ReadFull(br, soi[:]); err != nil || !isJpeg(soi[:]) {
+		return errBadJpeg
+	}
+	bw := bufio.NewWriter(w)
+	bw.Write(soi[:])
+	write := func(marker byte, segment []byte) error {
+		if len(segment)+2 > 0xFFFF {
+			return errBadJpeg
+		}
+		bw.Write([]byte{0xFF, marker})
+		if segment != nil {
+			bw.Write([]byte{byte((len(segment) + 2) >> 8), byte(len(segment) + 2)})
+			bw.Write(segment)
+		}
+		return nil
+	}
+	for {
+		marker, segment, err := jpegSegment(br)
+		if err != nil {
+			return err
+		}
+		switch {
+		case marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")):
+			t, err := newTiffReader(segment[6:])
+			if err != nil {
+				// Not a TIFF structure: nothing to keep
+				continue
+			}
+			segment = append([]byte("Exif\x00\x00"), t.rewrite(exifPolicyFilter(policy))...)
+		case marker == 0xE1:
+			// XMP, which can hold the location too
+			continue
+		case policy == ExifPolicyPrivate && (marker == 0xED || marker == 0xFE):
+			// IPTC (Photoshop APP13) and comments
+			continue
+		}
+		if err := write(marker, segment); err != nil {
+			return err
+		}
+		if marker == 0xDA || marker == 0xD9 {
+			// Start of the image data (or end of the image): the rest is copied
+			if _, err := io.Copy(bw, br); err != nil {
+				return err
+			}
+			return bw.Flush()
+		}
+	}
+}
@@ -0,0 +1,251 @@
+package dblayer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image/jpeg"
+	"testing"
+)
+
+// testTag is an entry of a test EXIF block
+type testTag struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	data  []byte
+}
+
+func asciiTag(tag uint16, value string) testTag {
+	return testTag{tag, tiffASCII, uint32(len(value) + 1), append([]byte(value), 0)}
+}
+
+func shortTag(tag uint16, value uint16) testTag {
+	return testTag{tag, tiffShort, 1, binary.LittleEndian.AppendUint16(nil, value)}
+}
+
+func rationalsTag(tag uint16, values ...uint32) testTag {
+	var data []byte
+	for _, v := range values {
+		data = binary.LittleEndian.AppendUint32(data, v)
+		data = binary.LittleEndian.AppendUint32(data, 1)
+	}
+	return testTag{tag, tiffRational, uint32(len(values)), data}
+}
+
+// buildTestTiff builds a little endian TIFF structure: IFD0 pointing to the
+// Exif IFD and to the GPS IFD when not empty
+func buildTestTiff(ifd0 []testTag, exif []testTag, gps []testTag) []byte {
+	size := func(tags []testTag) uint32 {
+		n := uint32(2 + 12*len(tags) + 4)
+		for _, tag := range tags {
+			if len(tag.data) > 4 {
+				n += uint32(len(tag.data)+1) &^ 1
+			}
+		}
+		return n
+	}
+	pointers := 0
+	for _, tags := range [][]testTag{exif, gps} {
+		if len(tags) > 0 {
+			pointers++
+		}
+	}
+	exifOffset := 8 + size(ifd0) + uint32(12*pointers)
+	gpsOffset := exifOffset + size(exif)
+	if len(exif) > 0 {
+		ifd0 = append(ifd0, testTag{exifTagExifIFD, tiffLong, 1, binary.LittleEndian.AppendUint32(nil, exifOffset)})
+	}
+	if len(gps) > 0 {
+		if len(exif) == 0 {
+			gpsOffset = exifOffset
+		}
+		ifd0 = append(ifd0, testTag{exifTagGPSIFD, tiffLong, 1, binary.LittleEndian.AppendUint32(nil, gpsOffset)})
+	}
+
+	out := []byte{'I', 'I', 42, 0, 8, 0, 0, 0}
+	for _, tags := range [][]testTag{ifd0, exif, gps} {
+		if len(tags) == 0 {
+			continue
+		}
+		start := uint32(len(out))
+		dataOffset := start + uint32(2+12*len(tags)+4)
+		var data []byte
+		out = binary.LittleEndian.AppendUint16(out, uint16(len(tags)))
+		for _, tag := range tags {
+			out = binary.LittleEndian.AppendUint16(out, tag.tag)
+			out = binary.LittleEndian.AppendUint16(out, tag.typ)
+			out = binary.LittleEndian.AppendUint32(out, tag.count)
+			if len(tag.data) <= 4 {
+				out = append(out, append(tag.data, make([]byte, 4-len(tag.data))...)...)
+				continue
+			}
+			out = binary.LittleEndian.AppendUint32(out, dataOffset+uint32(len(data)))
+			data = append(data, tag.data...)
+			if len(data)%2 == 1 {
+				data = append(data, 0)
+			}
+		}
+		out = append(out, 0, 0, 0, 0)
+		out = append(out, data...)
+	}
+	return out
+}
+
+// testPhoto returns a JPEG with the EXIF of a camera, a location, an XMP
+// block and a comment
+func testPhoto(t *testing.T) []byte {
+	t.Helper()
+	tiff := buildTestTiff(
+		[]testTag{
+			asciiTag(exifTagMake, "Canon"),
+			asciiTag(exifTagModel, "Canon EOS 5D"),
+			shortTag(exifTagOrientation, 6),
+			asciiTag(exifTagArtist, "Jane Doe"),
+		},
+		[]testTag{
+			asciiTag(exifTagDateTimeOriginal, "2024:07:14 10:30:00"),
+			asciiTag(exifTagBodySerialNumber, "0123456789"),
+		},
+		[]testTag{
+			asciiTag(gpsTagLatitudeRef, "N"),
+			rationalsTag(gpsTagLatitude, 45, 30, 0),
+			asciiTag(gpsTagLongitudeRef, "W"),
+			rationalsTag(gpsTagLongitude, 9, 15, 0),
+		},
+	)
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, testHalves(40, 20), nil); err != nil {
+		t.Fatal(err)
+	}
+	data := withExif(buf.Bytes(), tiff)
+	segment := func(marker byte, content string) []byte {
+		n := len(content) + 2
+		return append([]byte{0xFF, marker, byte(n >> 8), byte(n)}, content...)
+	}
+	head := append(data[:2:2], segment(0xE1, "http://ns.adobe.com/xap/1.0/\x00<x:xmpmeta>GPSLatitude</x:xmpmeta>")...)
+	head = append(head, segment(0xFE, "Jane's holiday")...)
+	return append(head, data[2:]...)
+}
+
+func TestParseExif(t *testing.T) {
+	tiff, err := jpegExif(bytes.NewReader(testPhoto(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := parseExif(tiff)
+	if info.Orientation != 6 || info.Camera != "Canon EOS 5D" {
+		t.Errorf("got %+v", info)
+	}
+	if got := info.Taken.Format("2006-01-02 15:04:05"); got != "2024-07-14 10:30:00" {
+		t.Errorf("expected the capture time, got %s", got)
+	}
+	if info.Latitude == nil || *info.Latitude != 45.5 || info.Longitude == nil || *info.Longitude != -9.25 {
+		t.Errorf("expected 45.5 -9.25, got %v %v", info.Latitude, info.Longitude)
+	}
+
+	// Make and model are joined when the model does not repeat the make
+	info = parseExif(buildTestTiff([]testTag{asciiTag(exifTagMake, "NIKON"), asciiTag(exifTagModel, "D750")}, nil, nil))
+	if info.Camera != "NIKON D750" || info.Latitude != nil || !info.Taken.IsZero() {
+		t.Errorf("got %+v", info)
+	}
+}
+
+func TestStripJpegMetadata(t *testing.T) {
+	photo := testPhoto(t)
+	strip := func(data []byte, policy string) []byte {
+		t.Helper()
+		var out bytes.Buffer
+		if err := stripJpegMetadata(bytes.NewReader(data), &out, policy); err != nil {
+			t.Fatalf("%s: %v", policy, err)
+		}
+		return out.Bytes()
+	}
+	exifTags := func(data []byte) (map[uint16]tiffEntry, map[uint16]tiffEntry, exifInfo) {
+		t.Helper()
+		tiff, err := jpegExif(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, _ := newTiffReader(tiff)
+		ifd0 := r.ifd(r.firstIFD())
+		return ifd0, r.subIFD(ifd0[exifTagExifIFD]), parseExif(tiff)
+	}
+
+	for _, policy := range []string{ExifPolicyLocation, ExifPolicyPrivate} {
+		stripped := strip(photo, policy)
+		ifd0, exif, info := exifTags(stripped)
+		if info.Latitude != nil || info.Longitude != nil {
+			t.Errorf("%s: expected no location, got %v %v", policy, info.Latitude, info.Longitude)
+		}
+		if bytes.Contains(stripped, []byte("GPSLatitude")) {
+			t.Errorf("%s: expected no XMP", policy)
+		}
+		// What makes the photo searchable and upright is kept
+		if info.Orientation != 6 || info.Camera != "Canon EOS 5D" || info.Taken.IsZero() {
+			t.Errorf("%s: got %+v", policy, info)
+		}
+		_, artist := ifd0[exifTagArtist]
+		_, serial := exif[exifTagBodySerialNumber]
+		if private := policy == ExifPolicyPrivate; artist == private || serial == private {
+			t.Errorf("%s: artist %v, serial number %v", policy, artist, serial)
+		}
+		if private := policy == ExifPolicyPrivate; bytes.Contains(stripped, []byte("holiday")) == private {
+			t.Errorf("%s: unexpected comment", policy)
+		}
+		// The image is the same
+		img, err := jpeg.Decode(bytes.NewReader(stripped))
+		if err != nil || img.Bounds().Dx() != 40 || !isRed(img.At(5, 5)) {
+			t.Errorf("%s: the image changed: %v", policy, err)
+		}
+		// Stripping again changes nothing, the checksum is stable
+		if again := strip(stripped, policy); !bytes.Equal(again, stripped) {
+			t.Errorf("%s: stripping twice changed the blob", policy)
+		}
+	}
+
+	var out bytes.Buffer
+	if err := stripJpegMetadata(bytes.NewReader([]byte("not a jpeg")), &out, ExifPolicyPrivate); !errors.Is(err, errBadJpeg) {
+		t.Errorf("expected a malformed JPEG, got %v", err)
+	}
+	if err := stripJpegMetadata(bytes.NewReader(photo[:40]), &out, ExifPolicyPrivate); !errors.Is(err, errBadJpeg) {
+		t.Errorf("expected a truncated JPEG, got %v", err)
+	}
+}
+
+func TestReadImageMetadata(t *testing.T) {
+	previous := FileStorage
+	defer func() { FileStorage = previous }()
+	FileStorage = NewLocalStorage(t.TempDir())
+	photo := testPhoto(t)
+	if err := FileStorage.Put("photo.jpg", bytes.NewReader(photo), int64(len(photo))); err != nil {
+		t.Fatal(err)
+	}
+
+	dbFile := NewDBFile()
+	dbFile.SetValue("mime", "image/jpeg")
+	dbFile.setImageMetadata("photo.jpg")
+	// Rotated 90°: 20x40 as seen
+	expected := map[string]any{
+		"image_width":   20,
+		"image_height":  40,
+		"camera":        "Canon EOS 5D",
+		"taken_date":    "2024-07-14 10:30:00",
+		"gps_latitude":  "45.5000000",
+		"gps_longitude": "-9.2500000",
+	}
+	for column, value := range expected {
+		if got := dbFile.GetValue(column); got != value {
+			t.Errorf("%s: expected %v, got %v", column, value, got)
+		}
+	}
+
+	// A new blob that is not an image clears them
+	dbFile.SetValue("mime", "text/plain")
+	dbFile.setImageMetadata("photo.jpg")
+	for column := range expected {
+		if got := dbFile.GetValue(column); got != nil {
+			t.Errorf("%s: expected nil, got %v", column, got)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package dblayer
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"strings"
+)
+
+/*
+Deduplicated blobs of the content layout.
+
+In the content layout a blob is stored once, by checksum, in
+blobs/XX/YY/<sha1>, and the FileBlob row counts its references: the files
+and the archived revisions whose storage_key is the blob. An upload of a
+content already stored only adds a reference. Dropping a reference deletes
+the blob, and its thumbnail, when no reference remains. Migrating to the
+content layout (MigrateFileLayout) is the dedupe pass of the files stored
+before.
+*/
+
+// contentBlobPrefix is the prefix of the keys of the deduplicated blobs
+const contentBlobPrefix = "blobs/"
+
+// contentBlobKey returns the key of the deduplicated blob of a checksum
+func contentBlobKey(checksum string) string {
+	return contentBlobPrefix + ShardedStorageKey(checksum, checksum)
+}
+
+// isContentBlobKey tells if a key is a deduplicated blob
+func isContentBlobKey(key string) bool {
+	return strings.HasPrefix(key, contentBlobPrefix)
+}
+
+// isChecksum tells if s is a SHA1 checksum
+func isChecksum(s string) bool {
+	return len(s) == 40 && isHexString(s)
+}
+
+// acquireContentBlob adds a reference to the blob of a checksum, whose
+// content is stored as key: it becomes the blob when the checksum is new,
+// it is deleted as a duplicate otherwise. Returns the key of the blob.
+func (dbr *DBRepository) acquireContentBlob(key string, checksum string, size int64, tx *sql.Tx) (string, error) {
+	blobKey := contentBlobKey(checksum)
+	query := "INSERT INTO " + dbr.buildTableName(NewFileBlob()) + " (checksum, storage_key, size, refs, creation_date)" +
+		" VALUES (?, ?, ?, 1, ?) ON DUPLICATE KEY UPDATE refs = refs + 1"
+	result, err := tx.Exec(query, checksum, blobKey, size, CurrentDateTimeString())
+	if err != nil {
+		return "", err
+	}
+	if key == blobKey {
+		return blobKey, nil
+	}
+	// MySQL reports 1 row for an insert, 2 for an update
+	if affected, _ := result.RowsAffected(); affected != 1 {
+		if _, err := FileStorage.Stat(blobKey); err == nil {
+			if err := FileStorage.Delete(key); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				log.Printf("DBRepository::acquireContentBlob: error deleting the duplicate %s: %v", key, err)
+			}
+			return blobKey, nil
+		}
+		// The blob is lost: the duplicate replaces it
+		log.Printf("DBRepository::acquireContentBlob: blob %s not found, restored from %s", blobKey, key)
+	}
+	if err := FileStorage.Rename(key, blobKey); err != nil {
+		return "", err
+	}
+	return blobKey, nil
+}
+
+// releaseBlob drops a reference to a blob. A deduplicated blob is deleted,
+// with its thumbnail, when no reference remains; the blobs of the other
+// layouts have a single reference and are always deleted.
+func (dbr *DBRepository) releaseBlob(key string, tx *sql.Tx) error {
+	if key == "" {
+		return nil
+	}
+	if isContentBlobKey(key) {
+		table := dbr.buildTableName(NewFileBlob())
+		checksum := path.Base(key)
+		if _, err := tx.Exec("UPDATE "+table+" SET refs = refs - 1 WHERE checksum = ?", checksum); err != nil {
+			return err
+		}
+		var refs int
+		err := tx.QueryRow("SELECT refs FROM "+table+" WHERE checksum = ?", checksum).Scan(&refs)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		if err == nil && refs > 0 {
+			return nil
+		}
+		if _, err := tx.Exec("DELETE FROM "+table+" WHERE checksum = ?", checksum); err != nil {
+			return err
+		}
+	}
+	for _, blobKey := range []string{key, key + "_thumb.jpg"} {
+		if err := FileStorage.Delete(blobKey); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("DBRepository::releaseBlob: error deleting %s: %v", blobKey, err)
+		}
+	}
+	log.Printf("Deleted blob %s", key)
+	return nil
+}
+
+// migrateContentBlob moves the blob of a file or of an archived revision to
+// the deduplicated blobs, then records the new key in table. The blob is
+// verified against the stored checksum; a blob already moved by an
+// interrupted run is recognized by it.
+func (dbr *DBRepository) migrateContentBlob(table string, id string, sourceKey string, checksum string) error {
+	if !isChecksum(checksum) {
+		checksum = ""
+	}
+	key := sourceKey
+	info, err := FileStorage.Stat(sourceKey)
+	if err == nil {
+		actual, err := blobSHA1(sourceKey)
+		if err != nil {
+			return err
+		}
+		if checksum != "" && actual != checksum {
+			return fmt.Errorf("checksum mismatch: expected %s, found %s", checksum, actual)
+		}
+		checksum = actual
+	} else if checksum != "" {
+		// Moved by an interrupted run?
+		key = contentBlobKey(checksum)
+		if info, err = FileStorage.Stat(key); err != nil {
+			return fmt.Errorf("file not found: %s", sourceKey)
+		}
+	} else {
+		return fmt.Errorf("file not found: %s", sourceKey)
+	}
+
+	tx, err := dbr.DbConnection.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	blobKey, err := dbr.acquireContentBlob(key, checksum, info.Size, tx)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE "+table+" SET storage_key = ? WHERE id = ?", blobKey, id); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	// The thumbnail follows the blob, unless the blob already has one
+	thumbnail := sourceKey + "_thumb.jpg"
+	if _, err := FileStorage.Stat(thumbnail); err == nil {
+		if _, err := FileStorage.Stat(blobKey + "_thumb.jpg"); err == nil {
+			FileStorage.Delete(thumbnail)
+		} else if err := FileStorage.Rename(thumbnail, blobKey+"_thumb.jpg"); err != nil {
+			log.Printf("DBRepository::migrateContentBlob: error moving the thumbnail of %s: %v", id, err)
+		}
+	}
+	// Remove the folder directory once empty
+	if local, ok := FileStorage.(*LocalStorage); ok {
+		local.removeEmptyDir(path.Dir(sourceKey))
+	}
+	return nil
+}
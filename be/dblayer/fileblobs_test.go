@@ -0,0 +1,54 @@
+package dblayer
+
+import (
+	"testing"
+)
+
+func TestDBFileBlobsDedupe(t *testing.T) {
+	repo := setupTestRepo(t)
+	previous := dbFiles_layout
+	defer func() { dbFiles_layout = previous }()
+	if err := SetFileLayout(FileLayoutContent); err != nil {
+		t.Fatal(err)
+	}
+
+	first := createTestFile(t, repo, "testdata/images/test_image.jpg", map[string]any{"name": "First Copy"}, nil)
+	second := createTestFile(t, repo, "testdata/images/test_image.jpg", map[string]any{"name": "Second Copy"}, nil)
+	key := first.GetBlobKey()
+	if !isContentBlobKey(key) || second.GetBlobKey() != key {
+		t.Fatalf("expected a shared blob, got %s and %s", key, second.GetBlobKey())
+	}
+	refs := func() string {
+		search := NewFileBlob()
+		search.SetValue("checksum", stringValue(first, "checksum"))
+		results, err := repo.searchWithTx(search, false, false, "", nil)
+		if err != nil || len(results) == 0 {
+			return "0"
+		}
+		return stringValue(results[0], "refs")
+	}
+	if got := refs(); got != "2" {
+		t.Errorf("expected 2 references, got %s", got)
+	}
+
+	// The blob survives the first delete
+	if err := hardDeleteForTests(repo, first); err != nil {
+		t.Fatalf("Failed to hard delete file: %v", err)
+	}
+	if _, err := FileStorage.Stat(key); err != nil {
+		t.Errorf("expected the shared blob, got %v", err)
+	}
+	if got := refs(); got != "1" {
+		t.Errorf("expected 1 reference, got %s", got)
+	}
+	// and not the last one
+	if err := hardDeleteForTests(repo, second); err != nil {
+		t.Fatalf("Failed to hard delete file: %v", err)
+	}
+	if _, err := FileStorage.Stat(key); err == nil {
+		t.Errorf("expected the blob %s to be deleted", key)
+	}
+	if got := refs(); got != "0" {
+		t.Errorf("expected no references, got %s", got)
+	}
+}
@@ -0,0 +1,288 @@
+package dblayer
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"strconv"
+)
+
+/*
+Revisions of the DBFiles.
+
+Every upload of a file, at insert or at update, records an immutable
+FileRevision with the checksum, mime type, size, uploader and date of the
+blob. The current revision has no storage key: its blob is the blob of the
+file. When a new upload replaces it, the blob is archived under
+revisions/XX/YY/<file_id>_v<revision> instead of being deleted; a
+deduplicated blob (content layout) stays where it is, the revision keeps
+the reference of the file. Promoting an
+old revision uploads a copy of its blob as a new revision, so the history
+is never rewritten. The file_revisions column of the folder of a file
+limits the revisions kept: the oldest archived ones are pruned.
+*/
+
+// ErrFileRevisionNotFound is returned for a missing revision
+var ErrFileRevisionNotFound = errors.New("file revision not found")
+
+// ErrCurrentFileRevision is returned when promoting the current revision
+var ErrCurrentFileRevision = errors.New("the revision is already the current one")
+
+// fileRevisionKey returns the key of an archived revision
+func fileRevisionKey(fileID string, revision int) string {
+	return "revisions/" + ShardedStorageKey(fileID, fmt.Sprintf("%s_v%d", fileID, revision))
+}
+
+// revisionNumber returns the number of a revision
+func revisionNumber(revision DBEntityInterface) int {
+	n, _ := strconv.Atoi(stringValue(revision, "revision"))
+	return n
+}
+
+// GetBlobKey returns the key of the blob of the revision of file
+func (fileRevision *FileRevision) GetBlobKey(file *DBFile) string {
+	if key := stringValue(fileRevision, "storage_key"); key != "" {
+		return key
+	}
+	return file.GetBlobKey()
+}
+
+// fileRevisionsWithTx returns the revisions of a file, newest first
+func (dbr *DBRepository) fileRevisionsWithTx(fileID string, tx *sql.Tx) ([]*FileRevision, error) {
+	search := NewFileRevision()
+	search.SetValue("file_id", fileID)
+	results, err := dbr.searchWithTx(search, false, false, "revision DESC", tx)
+	if err != nil {
+		return nil, err
+	}
+	revisions := make([]*FileRevision, 0, len(results))
+	for _, result := range results {
+		revisions = append(revisions, result.(*FileRevision))
+	}
+	return revisions, nil
+}
+
+// GetFileRevisions returns the revisions of a file, newest first
+func (dbr *DBRepository) GetFileRevisions(fileID string) ([]*FileRevision, error) {
+	return dbr.fileRevisionsWithTx(fileID, nil)
+}
+
+// GetFileRevision returns a revision of a file, nil when missing
+func (dbr *DBRepository) GetFileRevision(fileID string, revision int) *FileRevision {
+	search := NewFileRevision()
+	search.SetValue("file_id", fileID)
+	search.SetValue("revision", revision)
+	results, err := dbr.searchWithTx(search, false, false, "", nil)
+	if err != nil || len(results) == 0 {
+		return nil
+	}
+	return results[0].(*FileRevision)
+}
+
+// recordFileRevision records the blob of the file as its current revision,
+// then prunes the revisions beyond the retention of the folder
+func (dbr *DBRepository) recordFileRevision(dbFile *DBFile, tx *sql.Tx) error {
+	fileID := stringValue(dbFile, "id")
+	info, err := FileStorage.Stat(dbFile.GetBlobKey())
+	if err != nil {
+		return err
+	}
+	revisions, err := dbr.fileRevisionsWithTx(fileID, tx)
+	if err != nil {
+		return err
+	}
+	next := 1
+	if len(revisions) > 0 {
+		next = revisionNumber(revisions[0]) + 1
+	}
+	revision := NewFileRevision()
+	revision.SetValue("file_id", fileID)
+	revision.SetValue("revision", next)
+	revision.SetValue("filename", stringValue(dbFile, "filename"))
+	revision.SetValue("checksum", stringValue(dbFile, "checksum"))
+	revision.SetValue("mime", stringValue(dbFile, "mime"))
+	revision.SetValue("size", info.Size)
+	if _, err := dbr.insertWithTx(revision, tx); err != nil {
+		return err
+	}
+	return dbr.pruneFileRevisions(dbFile, append([]*FileRevision{revision}, revisions...), tx)
+}
+
+// archiveFileRevision moves the blob of the current revision of myself (the
+// stored file) under revisions/, before a new upload replaces it. Files
+// uploaded before the revisions get their revision here.
+func (dbr *DBRepository) archiveFileRevision(myself *DBFile, tx *sql.Tx) error {
+	fileID := stringValue(myself, "id")
+	blobKey := myself.GetBlobKey()
+	info, err := FileStorage.Stat(blobKey)
+	if err != nil {
+		// Nothing to keep
+		log.Printf("DBRepository::archiveFileRevision: %s: %v", fileID, err)
+		return nil
+	}
+	revisions, err := dbr.fileRevisionsWithTx(fileID, tx)
+	if err != nil {
+		return err
+	}
+	var current *FileRevision
+	for _, revision := range revisions {
+		if stringValue(revision, "storage_key") == "" {
+			current = revision
+			break
+		}
+	}
+	if current == nil {
+		next := 1
+		if len(revisions) > 0 {
+			next = revisionNumber(revisions[0]) + 1
+		}
+		current = NewFileRevision()
+		current.SetValue("file_id", fileID)
+		current.SetValue("revision", next)
+		current.SetValue("filename", stringValue(myself, "filename"))
+		current.SetValue("checksum", stringValue(myself, "checksum"))
+		current.SetValue("mime", stringValue(myself, "mime"))
+		current.SetValue("size", info.Size)
+		current.SetValue("uploader", stringValue(myself, "last_modify"))
+		if date := stringValue(myself, "last_modify_date"); date != "" {
+			current.SetValue("upload_date", date)
+		}
+		if _, err := dbr.insertWithTx(current, tx); err != nil {
+			return err
+		}
+	}
+	query := "UPDATE " + dbr.buildTableName(current) + " SET storage_key = ? WHERE id = ?"
+	if isContentBlobKey(blobKey) {
+		// The reference of the file to the deduplicated blob passes to the revision
+		_, err := tx.Exec(query, blobKey, current.GetValue("id"))
+		return err
+	}
+	key := fileRevisionKey(fileID, revisionNumber(current))
+	if err := FileStorage.Rename(blobKey, key); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(query, key, current.GetValue("id")); err != nil {
+		FileStorage.Rename(key, blobKey)
+		return err
+	}
+	return nil
+}
+
+// fileRevisionsRetention returns the revisions kept for the files of a
+// folder, 0 when all of them are kept
+func (dbr *DBRepository) fileRevisionsRetention(fatherID string, tx *sql.Tx) int {
+	if fatherID == "" || fatherID == "0" {
+		return 0
+	}
+	folder := dbr.GetEntityByIDWithTx("folders", fatherID, tx)
+	if folder == nil {
+		return 0
+	}
+	retention, err := strconv.Atoi(stringValue(folder, "file_revisions"))
+	if err != nil || retention < 0 {
+		return 0
+	}
+	return retention
+}
+
+// pruneFileRevisions deletes the archived revisions (newest first) beyond
+// the retention of the folder of the file
+func (dbr *DBRepository) pruneFileRevisions(dbFile *DBFile, revisions []*FileRevision, tx *sql.Tx) error {
+	retention := dbr.fileRevisionsRetention(stringValue(dbFile, "father_id"), tx)
+	if retention == 0 {
+		return nil
+	}
+	for i, revision := range revisions {
+		if i < retention || stringValue(revision, "storage_key") == "" {
+			continue
+		}
+		if err := dbr.deleteFileRevision(revision, tx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteFileRevision deletes an archived revision and its blob
+func (dbr *DBRepository) deleteFileRevision(revision *FileRevision, tx *sql.Tx) error {
+	if _, err := dbr.deleteWithTx(revision, tx); err != nil {
+		return err
+	}
+	key := stringValue(revision, "storage_key")
+	if isContentBlobKey(key) {
+		return dbr.releaseBlob(key, tx)
+	}
+	if key != "" {
+		if err := FileStorage.Delete(key); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("DBRepository::deleteFileRevision: %s: %v", key, err)
+		}
+	}
+	return nil
+}
+
+// deleteFileRevisions deletes all the revisions of a file, at its hard delete
+func (dbr *DBRepository) deleteFileRevisions(dbFile *DBFile, tx *sql.Tx) error {
+	revisions, err := dbr.fileRevisionsWithTx(stringValue(dbFile, "id"), tx)
+	if err != nil {
+		return err
+	}
+	for _, revision := range revisions {
+		if err := dbr.deleteFileRevision(revision, tx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PromoteFileRevision makes an old revision of a file current again: a
+// copy of its blob is uploaded as a new revision
+func (dbr *DBRepository) PromoteFileRevision(fileID string, revision int) (DBEntityInterface, error) {
+	entity := dbr.GetEntityByID("files", fileID)
+	if entity == nil {
+		return nil, fmt.Errorf("file not found: %s", fileID)
+	}
+	dbFile := entity.(*DBFile)
+	old := dbr.GetFileRevision(fileID, revision)
+	if old == nil {
+		return nil, ErrFileRevisionNotFound
+	}
+	key := stringValue(old, "storage_key")
+	if key == "" {
+		return nil, ErrCurrentFileRevision
+	}
+	info, err := FileStorage.Stat(key)
+	if err != nil {
+		return nil, err
+	}
+	blob, err := FileStorage.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	defer blob.Close()
+	// Staged outside the root of the storage, where the legacy blobs without
+	// folder live, in a folder of its own: concurrent promotions of revisions
+	// with the same filename do not overwrite each other
+	stagingID, err := uuid16HexGo()
+	if err != nil {
+		return nil, err
+	}
+	staging := "revisions/uploads/" + stagingID
+	uploaded := staging + "/" + stringValue(old, "filename")
+	if err := FileStorage.Put(uploaded, blob, info.Size); err != nil {
+		return nil, err
+	}
+	dbFile.SetValue("filename", uploaded)
+	updated, err := dbr.Update(dbFile)
+	if err != nil {
+		FileStorage.Delete(uploaded)
+	}
+	if local, ok := FileStorage.(*LocalStorage); ok {
+		local.removeEmptyDir(staging)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
@@ -0,0 +1,76 @@
+package dblayer
+
+import (
+	"os"
+	"testing"
+)
+
+func TestDBFileRevisions(t *testing.T) {
+	repo := setupTestRepo(t)
+
+	folder := createTestFolder(t, repo, map[string]any{"name": "Revisions Folder", "file_revisions": 2}, nil)
+	defer hardDeleteForTests(repo, folder.(DBObjectInterface))
+	file := createTestFile(t, repo, "testdata/images/test_image.jpg", map[string]any{
+		"name":      "Revised File",
+		"father_id": folder.GetValue("id"),
+	}, nil)
+	fileID := file.GetValue("id").(string)
+	firstChecksum := file.GetValue("checksum").(string)
+
+	revisions, err := repo.GetFileRevisions(fileID)
+	if err != nil || len(revisions) != 1 || revisionNumber(revisions[0]) != 1 || stringValue(revisions[0], "checksum") != firstChecksum {
+		t.Fatalf("expected the first revision, got %v %v", revisions, err)
+	}
+
+	// Two more uploads: the first revision is pruned
+	for _, src := range []string{"testdata/files/test_document.txt", "testdata/images/test_image.png"} {
+		prepareTestFile(t, src, "upload_"+fileID)
+		updated, err := repo.UpdateObject("files", fileID, map[string]any{"filename": "upload_" + fileID}, nil)
+		if err != nil {
+			t.Fatalf("Failed to upload %s: %v", src, err)
+		}
+		file = updated.(*DBFile)
+	}
+	revisions, _ = repo.GetFileRevisions(fileID)
+	if len(revisions) != 2 || revisionNumber(revisions[0]) != 3 || revisionNumber(revisions[1]) != 2 {
+		t.Fatalf("expected the revisions 3 and 2, got %d", len(revisions))
+	}
+	if _, err := os.Stat(filesDirectory() + "/" + fileRevisionKey(fileID, 1)); !os.IsNotExist(err) {
+		t.Errorf("expected the blob of the first revision to be pruned")
+	}
+	archived := revisions[1]
+	if _, err := FileStorage.Stat(archived.GetBlobKey(file)); err != nil {
+		t.Errorf("archived blob: %v", err)
+	}
+
+	// Promote the second revision
+	if _, err := repo.PromoteFileRevision(fileID, 3); err != ErrCurrentFileRevision {
+		t.Errorf("expected ErrCurrentFileRevision, got %v", err)
+	}
+	promoted, err := repo.PromoteFileRevision(fileID, 2)
+	if err != nil {
+		t.Fatalf("Failed to promote: %v", err)
+	}
+	if promoted.GetValue("checksum") != archived.GetValue("checksum") {
+		t.Errorf("expected checksum %v, got %v", archived.GetValue("checksum"), promoted.GetValue("checksum"))
+	}
+	// The name of the revision, staged in a folder of its own removed afterwards
+	if stringValue(promoted, "filename") != stringValue(archived, "filename") {
+		t.Errorf("expected the filename %v, got %v", archived.GetValue("filename"), promoted.GetValue("filename"))
+	}
+	if entries, _ := os.ReadDir(filesDirectory() + "/revisions/uploads"); len(entries) != 0 {
+		t.Errorf("expected no staged upload, got %d", len(entries))
+	}
+	revisions, _ = repo.GetFileRevisions(fileID)
+	if len(revisions) != 2 || revisionNumber(revisions[0]) != 4 {
+		t.Fatalf("expected the revisions 4 and 3, got %d", len(revisions))
+	}
+
+	// The hard delete removes the revisions
+	if err := hardDeleteForTests(repo, promoted.(*DBFile)); err != nil {
+		t.Fatalf("Failed to hard delete file: %v", err)
+	}
+	if revisions, _ = repo.GetFileRevisions(fileID); len(revisions) != 0 {
+		t.Errorf("expected no revisions, got %d", len(revisions))
+	}
+}
@@ -0,0 +1,131 @@
+package dblayer
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDBFileScan(t *testing.T) {
+	repo := setupTestRepo(t)
+	previous := FileScanner
+	defer func() { FileScanner = previous }()
+	FileScanner = testScanner{}
+	defer SetScanAction(ScanActionReject)
+
+	dir := t.TempDir()
+	clean := filepath.Join(dir, "notes.txt")
+	infected := filepath.Join(dir, "eicar.com")
+	os.WriteFile(clean, []byte("hello world"), 0644)
+	os.WriteFile(infected, []byte(testEicar), 0644)
+
+	file := createTestFile(t, repo, clean, map[string]any{"name": "Scanned Clean"}, nil)
+	defer hardDeleteForTests(repo, file)
+
+	for _, action := range []string{ScanActionReject, ScanActionQuarantine} {
+		SetScanAction(action)
+		prepareTestFile(t, infected, "eicar.com")
+		_, err := repo.CreateObject("files", map[string]any{"name": "Scanned Infected", "filename": "eicar.com"}, nil)
+		var infectedError *InfectedFileError
+		if !errors.As(err, &infectedError) || infectedError.Signature != "Eicar-Test-Signature" {
+			t.Fatalf("%s: expected the upload to be refused, got %v", action, err)
+		}
+		if infectedError.Quarantined != (action == ScanActionQuarantine) {
+			t.Errorf("%s: quarantined %v", action, infectedError.Quarantined)
+		}
+		if _, err := FileStorage.Stat("eicar.com"); err == nil {
+			t.Errorf("%s: expected the upload to be removed", action)
+		}
+
+		// Recorded, although the insert was rolled back
+		scans, err := repo.GetFileScans(1)
+		if err != nil || len(scans) != 1 {
+			t.Fatalf("%s: expected the scan to be recorded, got %v %v", action, scans, err)
+		}
+		scan := scans[0]
+		if scan.Filename != "eicar.com" || scan.UserID != "-1" || scan.Size != int64(len(testEicar)) {
+			t.Errorf("%s: got %+v", action, scan)
+		}
+		if action == ScanActionQuarantine {
+			if scan.Action != FileScanQuarantined {
+				t.Errorf("expected quarantined, got %s", scan.Action)
+			}
+			if _, err := FileStorage.Stat(scan.QuarantineKey); err != nil {
+				t.Errorf("expected the blob in the quarantine, got %v", err)
+			}
+			FileStorage.Delete(scan.QuarantineKey)
+		} else if scan.Action != FileScanRejected || scan.QuarantineKey != "" {
+			t.Errorf("expected rejected, got %+v", scan)
+		}
+		repo.ExecuteSQL("DELETE FROM "+repo.buildTableName(NewFileScan())+" WHERE id = ?", scan.ID)
+	}
+
+	// An update with an infected content keeps the file as it was
+	prepareTestFile(t, infected, "eicar.com")
+	if _, err := repo.UpdateObject("files", stringValue(file, "id"), map[string]any{"filename": "eicar.com"}, nil); err == nil {
+		t.Errorf("expected the update to be refused")
+	}
+	if scans, _ := repo.GetFileScans(1); len(scans) == 1 {
+		if scans[0].FileID != stringValue(file, "id") {
+			t.Errorf("expected the scan of the file %s, got %+v", file.GetValue("id"), scans[0])
+		}
+		repo.ExecuteSQL("DELETE FROM "+repo.buildTableName(NewFileScan())+" WHERE id = ?", scans[0].ID)
+	}
+	if stored := repo.GetEntityByID("files", stringValue(file, "id")); stringValue(stored, "checksum") != stringValue(file, "checksum") {
+		t.Errorf("expected the content of the file to be kept")
+	}
+}
+
+func TestRescanFiles(t *testing.T) {
+	repo := setupTestRepo(t)
+	previous := FileScanner
+	defer func() { FileScanner = previous }()
+
+	FileScanner = nil
+	if _, err := repo.RescanFiles("", 0); !errors.Is(err, ErrScanFailed) {
+		t.Errorf("expected no scanner, got %v", err)
+	}
+
+	// Stored before the scanner was enabled
+	src := filepath.Join(t.TempDir(), "eicar.com")
+	os.WriteFile(src, []byte(testEicar), 0644)
+	file := createTestFile(t, repo, src, map[string]any{"name": "Rescanned Infected"}, nil)
+	defer hardDeleteForTests(repo, file)
+
+	FileScanner = testScanner{}
+	var found []FileScanInfo
+	files := 0
+	for after := ""; ; {
+		report, err := repo.RescanFiles(after, 50)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files += report.Files
+		found = append(found, report.Detections...)
+		if report.Next == "" {
+			break
+		}
+		after = report.Next
+	}
+	if files == 0 {
+		t.Errorf("expected the files to be read")
+	}
+	var detection *FileScanInfo
+	for i := range found {
+		if found[i].FileID == stringValue(file, "id") {
+			detection = &found[i]
+		}
+	}
+	if detection == nil {
+		t.Fatalf("expected %s to be detected, got %+v", file.GetValue("id"), found)
+	}
+	defer repo.ExecuteSQL("DELETE FROM "+repo.buildTableName(NewFileScan())+" WHERE id = ?", detection.ID)
+	// Reported, left in place with the reject action
+	if detection.Action != FileScanDetected || detection.Signature != "Eicar-Test-Signature" {
+		t.Errorf("got %+v", detection)
+	}
+	if _, err := FileStorage.Stat(file.GetBlobKey()); err != nil {
+		t.Errorf("expected the blob to be kept, got %v", err)
+	}
+}
@@ -0,0 +1,331 @@
+package dblayer
+
+import (
+	"crypto/sha1"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"strings"
+)
+
+/*
+Layout of the blobs of the DBFiles under the files directory.
+
+The legacy layout, inherited from the PHP application, stores a file in
+<files>/<father_id>/<path>/r_<id>_<name>: big folders become huge
+directories and moving a file moves its blob. The sharded layouts store it
+in <files>/XX/YY/r_<id>_<name>, where XX and YY are the first characters of
+the id or of the checksum of the file, and record the relative path in the
+storage_key column: the blob no longer depends on the folder of the file.
+The content layout stores each content once, shared by the files with the
+same checksum (see fileblobs.go). Rows without storage_key are in the
+legacy layout, MigrateFileLayout moves them to the configured layout.
+*/
+
+// Layouts of the files directory
+const (
+	FileLayoutLegacy   = "legacy"
+	FileLayoutID       = "id"
+	FileLayoutChecksum = "checksum"
+	FileLayoutContent  = "content"
+)
+
+// dbFiles_layout is the layout of the new uploads
+var dbFiles_layout = FileLayoutLegacy
+
+// SetFileLayout sets the layout of the new uploads, legacy when empty
+func SetFileLayout(layout string) error {
+	switch layout {
+	case "":
+		dbFiles_layout = FileLayoutLegacy
+	case FileLayoutLegacy, FileLayoutID, FileLayoutChecksum, FileLayoutContent:
+		dbFiles_layout = layout
+	default:
+		return fmt.Errorf("unknown files layout %s", layout)
+	}
+	return nil
+}
+
+// filesDirectory returns the directory of the blobs
+func filesDirectory() string {
+	return dbFiles_root_directory + "/" + dbFiles_dest_directory
+}
+
+// isHexString tells if s contains hexadecimal digits only
+func isHexString(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if !isDigit(s[i]) && (s[i] < 'a' || s[i] > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// ShardedStorageKey returns the relative path of a blob in a sharded
+// layout: XX/YY/filename, with XX and YY taken from key (an id or a
+// checksum). Keys that are not hexadecimal, like the ids of the legacy
+// objects, are hashed first.
+func ShardedStorageKey(key string, filename string) string {
+	key = strings.ToLower(key)
+	if len(key) < 4 || !isHexString(key) {
+		key = fmt.Sprintf("%x", sha1.Sum([]byte(key)))
+	}
+	return key[0:2] + "/" + key[2:4] + "/" + filename
+}
+
+// storageKey returns the relative path of the blob in a sharded layout, "" in the legacy layout
+func (dbFile *DBFile) storageKey() string {
+	return stringValue(dbFile, "storage_key")
+}
+
+// legacyKey returns the key of the blob in the legacy layout
+func (dbFile *DBFile) legacyKey() string {
+	return dbFile.legacyKeyOf(stringValue(dbFile, "filename"))
+}
+
+// legacyKeyOf returns the key of filename in the legacy folder of the file
+func (dbFile *DBFile) legacyKeyOf(filename string) string {
+	if dest_path := dbFile.generateObjectPath(nil); dest_path != "" {
+		return dest_path + "/" + filename
+	}
+	return filename
+}
+
+// GetBlobKey returns the key of the blob in the FileStorage
+func (dbFile *DBFile) GetBlobKey() string {
+	if key := dbFile.storageKey(); key != "" {
+		return key
+	}
+	return dbFile.legacyKey()
+}
+
+// GetThumbnailBlobKey returns the key of the thumbnail in the FileStorage
+func (dbFile *DBFile) GetThumbnailBlobKey() string {
+	return dbFile.getThumbnailFilename(dbFile.GetBlobKey())
+}
+
+// newStorageKey returns the storage key of a blob in the given layout, ""
+// for the legacy layout. The checksum layout reads the checksum of the
+// blob stored as key.
+func (dbFile *DBFile) newStorageKey(layout string, key string, filename string) (string, error) {
+	switch layout {
+	case FileLayoutID:
+		return ShardedStorageKey(stringValue(dbFile, "id"), filename), nil
+	case FileLayoutChecksum:
+		checksum, err := dbFile.computeSHA1(key)
+		if err != nil {
+			return "", err
+		}
+		return ShardedStorageKey(checksum, filename), nil
+	}
+	return "", nil
+}
+
+// storeUpload moves a file uploaded at the root of the FileStorage to its
+// place in the layout of the new uploads, with the given (prefixed) filename.
+// In the content layout the upload becomes a reference to the blob of its
+// checksum.
+func (dbFile *DBFile) storeUpload(dbr *DBRepository, tx *sql.Tx, uploadedKey string, filename string) error {
+	if dbFiles_layout == FileLayoutContent {
+		checksum, err := dbFile.computeSHA1(uploadedKey)
+		if err != nil {
+			return err
+		}
+		info, err := FileStorage.Stat(uploadedKey)
+		if err != nil {
+			return err
+		}
+		key, err := dbr.acquireContentBlob(uploadedKey, checksum, info.Size, tx)
+		if err != nil {
+			return err
+		}
+		dbFile.SetValue("storage_key", key)
+		return nil
+	}
+	key, err := dbFile.newStorageKey(dbFiles_layout, uploadedKey, filename)
+	if err != nil {
+		return err
+	}
+	if key != "" {
+		dbFile.SetValue("storage_key", key)
+	} else {
+		if dbFile.storageKey() != "" {
+			// Back to the legacy layout
+			dbFile.SetValue("storage_key", nil)
+		}
+		dbFile.SetValue("filename", filename)
+		key = dbFile.legacyKey()
+	}
+	return FileStorage.Rename(uploadedKey, key)
+}
+
+// FileMigrationError is a file that could not be moved to the new layout
+type FileMigrationError struct {
+	ID       string `json:"id"`
+	Filename string `json:"filename"`
+	Message  string `json:"message"`
+}
+
+// FileMigrationReport summarizes a run of MigrateFileLayout
+type FileMigrationReport struct {
+	Layout   string               `json:"layout"`
+	Migrated int                  `json:"migrated"`
+	Failed   int                  `json:"failed"`
+	Errors   []FileMigrationError `json:"errors"`
+	// Files still in the legacy layout, including the failed ones
+	Remaining int `json:"remaining"`
+}
+
+// ErrLegacyFileLayout is returned when migrating to the legacy layout
+var ErrLegacyFileLayout = errors.New("the files layout is legacy, nothing to migrate")
+
+// MigrateFileLayout moves at most limit files (all when limit <= 0) from
+// the legacy layout to the configured one, with their thumbnails. The files
+// failing are not counted, so they cannot stall the following batches. Each blob
+// is verified against the stored checksum before and after the move.
+// The migration is resumable: the rows are updated one by one, and a blob
+// already moved by an interrupted run is recognized by its checksum.
+// Files that fail (missing or corrupted blobs) stay in the legacy layout
+// and are reported on each run.
+// Migrating to the content layout is the dedupe pass of the storage: the
+// files of every other layout, and the archived revisions, are moved to the
+// deduplicated blobs and the copies of a content are deleted.
+func (dbr *DBRepository) MigrateFileLayout(limit int) (*FileMigrationReport, error) {
+	if dbFiles_layout == FileLayoutLegacy {
+		return nil, ErrLegacyFileLayout
+	}
+	report := &FileMigrationReport{Layout: dbFiles_layout, Errors: []FileMigrationError{}}
+	fail := func(id string, filename string, err error) {
+		log.Printf("DBRepository::MigrateFileLayout: %s: %v", id, err)
+		report.Failed++
+		report.Remaining++
+		report.Errors = append(report.Errors, FileMigrationError{ID: id, Filename: filename, Message: err.Error()})
+	}
+	where := " WHERE (storage_key IS NULL OR storage_key = '') AND filename <> '' ORDER BY id"
+	if dbFiles_layout == FileLayoutContent {
+		where = " WHERE (storage_key IS NULL OR storage_key NOT LIKE '" + contentBlobPrefix + "%') AND filename <> '' ORDER BY id"
+	}
+	files := dbr.Select("DBFile", "SELECT * FROM "+dbr.buildTableName(NewDBFile())+where)
+	if files == nil {
+		return nil, fmt.Errorf("failed to read the files")
+	}
+	for _, entity := range files {
+		if limit > 0 && report.Migrated >= limit {
+			report.Remaining++
+			continue
+		}
+		dbFile := entity.(*DBFile)
+		var err error
+		if dbFiles_layout == FileLayoutContent {
+			err = dbr.migrateContentBlob(dbr.buildTableName(dbFile), stringValue(dbFile, "id"), dbFile.GetBlobKey(), stringValue(dbFile, "checksum"))
+		} else {
+			err = dbr.migrateFile(dbFile)
+		}
+		if err != nil {
+			fail(stringValue(dbFile, "id"), stringValue(dbFile, "filename"), err)
+			continue
+		}
+		report.Migrated++
+	}
+	if dbFiles_layout != FileLayoutContent {
+		return report, nil
+	}
+
+	// The archived revisions
+	table := dbr.buildTableName(NewFileRevision())
+	revisions := dbr.Select("FileRevision", "SELECT * FROM "+table+
+		" WHERE storage_key <> '' AND storage_key NOT LIKE '"+contentBlobPrefix+"%' ORDER BY file_id, revision")
+	if revisions == nil {
+		return nil, fmt.Errorf("failed to read the file revisions")
+	}
+	for _, revision := range revisions {
+		if limit > 0 && report.Migrated >= limit {
+			report.Remaining++
+			continue
+		}
+		err := dbr.migrateContentBlob(table, stringValue(revision, "id"), stringValue(revision, "storage_key"), stringValue(revision, "checksum"))
+		if err != nil {
+			fail(stringValue(revision, "file_id"), stringValue(revision, "filename"), err)
+			continue
+		}
+		report.Migrated++
+	}
+	return report, nil
+}
+
+// migrateFile moves the blob and the thumbnail of a file from the legacy
+// layout to the configured one, then records the storage key
+func (dbr *DBRepository) migrateFile(dbFile *DBFile) error {
+	checksum := stringValue(dbFile, "checksum")
+	if len(checksum) != 40 || !isHexString(checksum) {
+		checksum = ""
+	}
+	verify := func(key string) error {
+		if checksum == "" {
+			return nil
+		}
+		actual, err := dbFile.computeSHA1(key)
+		if err != nil {
+			return err
+		}
+		if actual != checksum {
+			return fmt.Errorf("checksum mismatch: expected %s, found %s", checksum, actual)
+		}
+		return nil
+	}
+
+	filename := stringValue(dbFile, "filename")
+	sourceKey := dbFile.legacyKey()
+	_, sourceErr := FileStorage.Stat(sourceKey)
+	var key string
+	switch {
+	case sourceErr == nil:
+		if err := verify(sourceKey); err != nil {
+			return err
+		}
+		var err error
+		if key, err = dbFile.newStorageKey(dbFiles_layout, sourceKey, filename); err != nil {
+			return err
+		}
+	case checksum != "" || dbFiles_layout == FileLayoutID:
+		// Moved by an interrupted run?
+		key = ShardedStorageKey(stringValue(dbFile, "id"), filename)
+		if dbFiles_layout == FileLayoutChecksum {
+			key = ShardedStorageKey(checksum, filename)
+		}
+		if _, err := FileStorage.Stat(key); err != nil {
+			return fmt.Errorf("file not found: %s", sourceKey)
+		}
+	default:
+		return fmt.Errorf("file not found: %s", sourceKey)
+	}
+
+	if sourceErr == nil {
+		if err := FileStorage.Rename(sourceKey, key); err != nil {
+			return err
+		}
+	}
+	if err := verify(key); err != nil {
+		if sourceErr == nil {
+			FileStorage.Rename(key, sourceKey)
+		}
+		return err
+	}
+	if _, err := FileStorage.Stat(dbFile.getThumbnailFilename(sourceKey)); err == nil {
+		if err := FileStorage.Rename(dbFile.getThumbnailFilename(sourceKey), dbFile.getThumbnailFilename(key)); err != nil {
+			log.Printf("DBRepository::migrateFile: error moving the thumbnail of %s: %v", dbFile.GetValue("id"), err)
+		}
+	}
+
+	query := "UPDATE " + dbr.buildTableName(dbFile) + " SET storage_key = ? WHERE id = ?"
+	if _, err := dbr.ExecuteSQL(query, key, dbFile.GetValue("id")); err != nil {
+		return err
+	}
+	// Remove the folder directory once empty
+	if local, ok := FileStorage.(*LocalStorage); ok {
+		local.removeEmptyDir(path.Dir(sourceKey))
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package dblayer
+
+import (
+	"os"
+	"testing"
+)
+
+func TestShardedStorageKey(t *testing.T) {
+	if got := ShardedStorageKey("A1B2c3d4e5f60708", "r_a1b2_x.txt"); got != "a1/b2/r_a1b2_x.txt" {
+		t.Errorf("got %s", got)
+	}
+	// Legacy ids are hashed: sha1("42") = 92cfceb3...
+	if got := ShardedStorageKey("42", "f"); got != "92/cf/f" {
+		t.Errorf("got %s", got)
+	}
+	if got := ShardedStorageKey("-10", "f"); len(got) != 7 || got[2] != '/' || got[5] != '/' {
+		t.Errorf("got %s", got)
+	}
+}
+
+// withFilesDirectory runs the test with an empty files directory and the given layout
+func withFilesDirectory(t *testing.T, layout string) {
+	root, dest, previous, storage := dbFiles_root_directory, dbFiles_dest_directory, dbFiles_layout, FileStorage
+	t.Cleanup(func() {
+		dbFiles_root_directory, dbFiles_dest_directory, dbFiles_layout, FileStorage = root, dest, previous, storage
+	})
+	dbFiles_root_directory = t.TempDir()
+	dbFiles_dest_directory = "files"
+	FileStorage = NewLocalStorage(filesDirectory())
+	if err := SetFileLayout(layout); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filesDirectory(), 0755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStoreUpload(t *testing.T) {
+	for _, c := range []struct {
+		layout string
+		key    string
+		path   string
+	}{
+		{FileLayoutLegacy, "", "/files/f00d/r_0123456789abcdef_a.txt"},
+		{FileLayoutID, "01/23/r_0123456789abcdef_a.txt", "/files/01/23/r_0123456789abcdef_a.txt"},
+		// sha1("hello") = aaf4c61d...
+		{FileLayoutChecksum, "aa/f4/r_0123456789abcdef_a.txt", "/files/aa/f4/r_0123456789abcdef_a.txt"},
+	} {
+		withFilesDirectory(t, c.layout)
+		uploaded := filesDirectory() + "/a.txt"
+		if err := os.WriteFile(uploaded, []byte("hello"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		file := NewDBFile()
+		file.SetValue("id", "0123456789abcdef")
+		file.SetValue("father_id", "f00d")
+		file.SetValue("filename", "a.txt")
+		if err := file.storeUpload(nil, nil, "a.txt", file.generateFilename(nil, nil)); err != nil {
+			t.Fatalf("%s: %v", c.layout, err)
+		}
+		file.SetValue("filename", "r_0123456789abcdef_a.txt")
+		if file.storageKey() != c.key || file.GetFullpath(nil) != dbFiles_root_directory+c.path {
+			t.Errorf("%s: got %q %s", c.layout, file.storageKey(), file.GetFullpath(nil))
+		}
+		if _, err := os.Stat(file.GetFullpath(nil)); err != nil {
+			t.Errorf("%s: %v", c.layout, err)
+		}
+	}
+}
+
+func TestSetFileLayout(t *testing.T) {
+	withFilesDirectory(t, "")
+	if dbFiles_layout != FileLayoutLegacy {
+		t.Errorf("got %s", dbFiles_layout)
+	}
+	if err := SetFileLayout("father"); err == nil {
+		t.Error("expected an error")
+	}
+}
+
+func TestContentBlobKey(t *testing.T) {
+	// sha1("hello") = aaf4c61d...
+	checksum := "aaf4c61ddcc5e8a2dabede0f3b482cd9aea9434d"
+	key := contentBlobKey(checksum)
+	if key != "blobs/aa/f4/"+checksum || !isContentBlobKey(key) {
+		t.Errorf("got %s", key)
+	}
+	if isContentBlobKey("aa/f4/r_0123456789abcdef_a.txt") {
+		t.Error("expected a sharded key")
+	}
+	if !isChecksum(checksum) || isChecksum("File 'a.txt' not found!") {
+		t.Error("isChecksum")
+	}
+}
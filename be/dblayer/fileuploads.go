@@ -0,0 +1,326 @@
+package dblayer
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+/*
+Resumable uploads (the tus protocol, see api/tus.go).
+
+An upload declares its length, then receives its content in chunks at
+increasing offsets. Each chunk is stored as a blob, uploads/<id>/<n>: the
+blob storage cannot append, and the received part of an interrupted chunk
+is kept, so the client resumes from the last byte that arrived. When the
+offset reaches the length the chunks are joined and the blob becomes a
+DBFile, through the usual beforeInsert processing. Uploads left
+unfinished expire.
+*/
+
+// FileUploadExpiration is the time an upload may stay idle before expiring
+var FileUploadExpiration = 24 * time.Hour
+
+// ErrFileUploadNotFound is returned for a missing upload, or an upload of another user
+var ErrFileUploadNotFound = errors.New("upload not found")
+
+// ErrFileUploadExpired is returned for an expired upload
+var ErrFileUploadExpired = errors.New("upload expired")
+
+// ErrFileUploadOffset is returned when a chunk does not start at the offset of the upload
+var ErrFileUploadOffset = errors.New("upload offset mismatch")
+
+// ErrFileUploadTooLarge is returned when a chunk exceeds the length of the upload
+var ErrFileUploadTooLarge = errors.New("chunk exceeds the upload length")
+
+// ErrFileUploadIncomplete is returned when finishing an upload still receiving chunks
+var ErrFileUploadIncomplete = errors.New("upload not complete")
+
+// fileUploadChunkKey returns the key of a chunk of an upload
+func fileUploadChunkKey(uploadID string, chunk int) string {
+	return fmt.Sprintf("uploads/%s/%06d", uploadID, chunk)
+}
+
+// fileUploadStagingKey returns the key a chunk is stored under until the
+// offset of the upload is moved
+func fileUploadStagingKey(uploadID string, stagingID string) string {
+	return fmt.Sprintf("uploads/%s/%s.part", uploadID, stagingID)
+}
+
+// int64Value returns a numeric column of an entity, 0 when missing
+func int64Value(dbe DBEntityInterface, column string) int64 {
+	n, _ := strconv.ParseInt(stringValue(dbe, column), 10, 64)
+	return n
+}
+
+// Length returns the declared length of the upload
+func (fileUpload *FileUpload) Length() int64 {
+	return int64Value(fileUpload, "upload_length")
+}
+
+// Offset returns the bytes received so far
+func (fileUpload *FileUpload) Offset() int64 {
+	return int64Value(fileUpload, "upload_offset")
+}
+
+// Expires returns the expiration of the upload
+func (fileUpload *FileUpload) Expires() time.Time {
+	t, _ := ParseDateTime(stringValue(fileUpload, "expires"))
+	return t
+}
+
+// FileID returns the DBFile created by the upload, "" until finished
+func (fileUpload *FileUpload) FileID() string {
+	return stringValue(fileUpload, "file_id")
+}
+
+// IsComplete tells if all the content has been received
+func (fileUpload *FileUpload) IsComplete() bool {
+	return fileUpload.Offset() >= fileUpload.Length()
+}
+
+// fileUploadExpires returns the expiration of an upload touched now
+func fileUploadExpires() string {
+	return time.Now().Add(FileUploadExpiration).Format(time.DateTime)
+}
+
+// CreateFileUpload starts an upload of length bytes. metadata is kept as
+// sent by the client (the Upload-Metadata of tus). The expired uploads are
+// purged on the way.
+func (dbr *DBRepository) CreateFileUpload(length int64, metadata string) (*FileUpload, error) {
+	if length < 0 {
+		return nil, fmt.Errorf("invalid upload length %d", length)
+	}
+	if _, err := dbr.PurgeExpiredFileUploads(); err != nil {
+		log.Printf("DBRepository::CreateFileUpload: error purging the expired uploads: %v", err)
+	}
+	upload := NewFileUpload()
+	upload.SetValue("upload_length", length)
+	upload.SetValue("metadata", metadata)
+	upload.SetValue("expires", fileUploadExpires())
+	if _, err := dbr.Insert(upload); err != nil {
+		return nil, err
+	}
+	return upload, nil
+}
+
+// GetFileUpload returns an upload of the current user
+func (dbr *DBRepository) GetFileUpload(uploadID string) (*FileUpload, error) {
+	entity := dbr.GetEntityByID("files_uploads", uploadID)
+	upload, ok := entity.(*FileUpload)
+	if !ok || dbr.DbContext == nil || stringValue(upload, "owner") != dbr.DbContext.UserID {
+		return nil, ErrFileUploadNotFound
+	}
+	if upload.Expires().Before(time.Now()) {
+		return nil, ErrFileUploadExpired
+	}
+	return upload, nil
+}
+
+// AppendFileUpload stores the content of r as the chunk of the upload at
+// offset and returns the new offset. When r fails, the part received is kept
+// and the error returned with the offset reached.
+func (dbr *DBRepository) AppendFileUpload(upload *FileUpload, offset int64, r io.Reader) (int64, error) {
+	if offset != upload.Offset() {
+		return upload.Offset(), ErrFileUploadOffset
+	}
+	remaining := upload.Length() - offset
+
+	// Spool the chunk: what arrives before a failure is stored anyway
+	tmp, err := os.CreateTemp("", "rprj-upload-*")
+	if err != nil {
+		return offset, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	n, readErr := io.Copy(tmp, io.LimitReader(r, remaining+1))
+	if n > remaining {
+		return offset, ErrFileUploadTooLarge
+	}
+	if n == 0 {
+		return offset, readErr
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return offset, err
+	}
+
+	// The chunk is staged under a key of its own, and takes the key of the
+	// chunk only once the offset is moved: a concurrent request at the same
+	// offset stages its own copy, and deletes only that one when it loses
+	uploadID := stringValue(upload, "id")
+	chunk := int(int64Value(upload, "chunks"))
+	stagingID, err := uuid16HexGo()
+	if err != nil {
+		return offset, err
+	}
+	staging := fileUploadStagingKey(uploadID, stagingID)
+	if err := FileStorage.Put(staging, tmp, n); err != nil {
+		return offset, err
+	}
+	query := "UPDATE " + dbr.buildTableName(upload) +
+		" SET upload_offset = ?, chunks = ?, expires = ? WHERE id = ? AND upload_offset = ?"
+	expires := fileUploadExpires()
+	result, err := dbr.ExecuteSQL(query, offset+n, chunk+1, expires, uploadID, offset)
+	if err == nil {
+		if affected, _ := result.RowsAffected(); affected != 1 {
+			err = ErrFileUploadOffset
+		}
+	}
+	if err == nil {
+		if err = FileStorage.Rename(staging, fileUploadChunkKey(uploadID, chunk)); err != nil {
+			// Give the offset back, unless another chunk followed already
+			query := "UPDATE " + dbr.buildTableName(upload) +
+				" SET upload_offset = ?, chunks = ? WHERE id = ? AND upload_offset = ? AND chunks = ?"
+			if _, undoErr := dbr.ExecuteSQL(query, offset, chunk, uploadID, offset+n, chunk+1); undoErr != nil {
+				log.Printf("DBRepository::AppendFileUpload: error restoring the offset of %s: %v", uploadID, undoErr)
+			}
+		}
+	}
+	if err != nil {
+		if delErr := FileStorage.Delete(staging); delErr != nil && !errors.Is(delErr, fs.ErrNotExist) {
+			log.Printf("DBRepository::AppendFileUpload: error deleting %s: %v", staging, delErr)
+		}
+		return offset, err
+	}
+	upload.SetValue("upload_offset", offset+n)
+	upload.SetValue("chunks", chunk+1)
+	upload.SetValue("expires", expires)
+	return offset + n, readErr
+}
+
+// chunksReader reads the chunks of an upload in sequence, opening each one
+// when the previous is over
+type chunksReader struct {
+	uploadID string
+	chunks   int
+	next     int
+	current  io.ReadCloser
+}
+
+func (c *chunksReader) Read(p []byte) (int, error) {
+	for {
+		if c.current == nil {
+			if c.next >= c.chunks {
+				return 0, io.EOF
+			}
+			chunk, err := FileStorage.Get(fileUploadChunkKey(c.uploadID, c.next))
+			if err != nil {
+				return 0, err
+			}
+			c.current = chunk
+			c.next++
+		}
+		n, err := c.current.Read(p)
+		if err == io.EOF {
+			c.current.Close()
+			c.current = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+func (c *chunksReader) Close() error {
+	if c.current != nil {
+		return c.current.Close()
+	}
+	return nil
+}
+
+// FinishFileUpload turns a complete upload into a DBFile with the given
+// values: the chunks are joined as the blob named by values["filename"],
+// then the DBFile is inserted with the usual processing. The upload keeps
+// the id of the file until it expires, so a client that lost the response
+// can still find it.
+func (dbr *DBRepository) FinishFileUpload(upload *FileUpload, values map[string]any) (DBEntityInterface, error) {
+	if !upload.IsComplete() {
+		return nil, ErrFileUploadIncomplete
+	}
+	uploadID := stringValue(upload, "id")
+	filename, _ := values["filename"].(string)
+	filename = filepath.Base(filename)
+	if filename == "" || filename == "." || filename == "/" {
+		filename = uploadID
+	}
+	key := "uploads/" + uploadID + "/" + filename
+
+	chunks := &chunksReader{uploadID: uploadID, chunks: int(int64Value(upload, "chunks"))}
+	err := FileStorage.Put(key, chunks, upload.Length())
+	chunks.Close()
+	if err != nil {
+		return nil, err
+	}
+	values["filename"] = key
+	created, err := dbr.CreateObject("files", values, nil)
+	if err != nil {
+		// The hooks may have moved it already
+		if err := FileStorage.Delete(key); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("DBRepository::FinishFileUpload: error deleting %s: %v", key, err)
+		}
+		// An infected upload is not resumed
+		var infected *InfectedFileError
+		if errors.As(err, &infected) {
+			dbr.deleteFileUploadChunks(upload)
+		}
+		return nil, err
+	}
+
+	query := "UPDATE " + dbr.buildTableName(upload) + " SET file_id = ? WHERE id = ?"
+	if _, err := dbr.ExecuteSQL(query, created.GetValue("id"), uploadID); err != nil {
+		log.Printf("DBRepository::FinishFileUpload: error recording the file of %s: %v", uploadID, err)
+	}
+	upload.SetValue("file_id", created.GetValue("id"))
+	dbr.deleteFileUploadChunks(upload)
+	return created, nil
+}
+
+// deleteFileUploadChunks deletes the chunks of an upload
+func (dbr *DBRepository) deleteFileUploadChunks(upload *FileUpload) {
+	uploadID := stringValue(upload, "id")
+	for chunk := 0; chunk < int(int64Value(upload, "chunks")); chunk++ {
+		key := fileUploadChunkKey(uploadID, chunk)
+		if err := FileStorage.Delete(key); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("DBRepository::deleteFileUploadChunks: error deleting %s: %v", key, err)
+		}
+	}
+	if local, ok := FileStorage.(*LocalStorage); ok {
+		local.removeEmptyDir("uploads/" + uploadID)
+	}
+}
+
+// DeleteFileUpload deletes an upload and its chunks (the termination of tus)
+func (dbr *DBRepository) DeleteFileUpload(upload *FileUpload) error {
+	if _, err := dbr.Delete(upload); err != nil {
+		return err
+	}
+	if upload.FileID() == "" {
+		dbr.deleteFileUploadChunks(upload)
+	}
+	return nil
+}
+
+// PurgeExpiredFileUploads deletes the expired uploads, of every user
+func (dbr *DBRepository) PurgeExpiredFileUploads() (int, error) {
+	query := "SELECT * FROM " + dbr.buildTableName(NewFileUpload()) + " WHERE expires < ?"
+	uploads := dbr.Select("FileUpload", query, CurrentDateTimeString())
+	if uploads == nil {
+		return 0, fmt.Errorf("failed to read the uploads")
+	}
+	purged := 0
+	for _, entity := range uploads {
+		if err := dbr.DeleteFileUpload(entity.(*FileUpload)); err != nil {
+			return purged, err
+		}
+		purged++
+	}
+	return purged, nil
+}
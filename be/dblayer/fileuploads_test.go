@@ -0,0 +1,152 @@
+package dblayer
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// failingReader returns its content, then an error, like a dropped connection
+type failingReader struct {
+	r io.Reader
+}
+
+func (f *failingReader) Read(p []byte) (int, error) {
+	n, err := f.r.Read(p)
+	if err == io.EOF {
+		return n, errors.New("connection reset")
+	}
+	return n, err
+}
+
+func TestDBFileUploads(t *testing.T) {
+	repo := setupTestRepo(t)
+	content, err := os.ReadFile("testdata/files/test_document.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := int64(len(content))
+
+	upload, err := repo.CreateFileUpload(size, "filename dGVzdF9kb2N1bWVudC50eHQ=")
+	if err != nil {
+		t.Fatalf("Failed to create upload: %v", err)
+	}
+	uploadID := upload.GetValue("id").(string)
+	defer repo.DeleteFileUpload(upload)
+
+	// The first chunk is interrupted: the part received is kept
+	half := size / 2
+	offset, err := repo.AppendFileUpload(upload, 0, &failingReader{bytes.NewReader(content[:half])})
+	if err == nil || offset != half {
+		t.Fatalf("expected an interrupted chunk at %d, got %d %v", half, offset, err)
+	}
+	// A chunk at the wrong offset is refused
+	if _, err := repo.AppendFileUpload(upload, 0, strings.NewReader("x")); err != ErrFileUploadOffset {
+		t.Errorf("expected ErrFileUploadOffset, got %v", err)
+	}
+	if _, err := repo.AppendFileUpload(upload, half, bytes.NewReader(append(content[half:], 'x'))); err != ErrFileUploadTooLarge {
+		t.Errorf("expected ErrFileUploadTooLarge, got %v", err)
+	}
+
+	// Resume from the offset stored
+	upload, err = repo.GetFileUpload(uploadID)
+	if err != nil || upload.Offset() != half {
+		t.Fatalf("expected offset %d, got %v", half, err)
+	}
+	if _, err := repo.FinishFileUpload(upload, map[string]any{"filename": "test_document.txt"}); err != ErrFileUploadIncomplete {
+		t.Errorf("expected ErrFileUploadIncomplete, got %v", err)
+	}
+	if offset, err := repo.AppendFileUpload(upload, half, bytes.NewReader(content[half:])); err != nil || offset != size {
+		t.Fatalf("expected offset %d, got %d %v", size, offset, err)
+	}
+
+	created, err := repo.FinishFileUpload(upload, map[string]any{"filename": "test_document.txt", "name": "Resumed"})
+	if err != nil {
+		t.Fatalf("Failed to finish upload: %v", err)
+	}
+	dbFile := created.(*DBFile)
+	defer hardDeleteForTests(repo, dbFile)
+	if dbFile.GetValue("name") != "Resumed" || !strings.HasSuffix(dbFile.GetValue("filename").(string), "_test_document.txt") {
+		t.Errorf("got %v %v", dbFile.GetValue("name"), dbFile.GetValue("filename"))
+	}
+	checksum, _ := blobSHA1(dbFile.GetBlobKey())
+	if checksum != dbFile.GetValue("checksum") {
+		t.Errorf("expected checksum %v, got %s", dbFile.GetValue("checksum"), checksum)
+	}
+	if _, err := FileStorage.Stat(fileUploadChunkKey(uploadID, 0)); err == nil {
+		t.Error("expected the chunks to be deleted")
+	}
+	if upload, err := repo.GetFileUpload(uploadID); err != nil || upload.FileID() != dbFile.GetValue("id") {
+		t.Errorf("expected the upload to record the file, got %v", err)
+	}
+
+	// Another user cannot see it
+	other := SetupTestRepo(t, "-7", []string{"-4"}, "rprj")
+	if _, err := other.GetFileUpload(uploadID); err != ErrFileUploadNotFound {
+		t.Errorf("expected ErrFileUploadNotFound, got %v", err)
+	}
+}
+
+func TestDBFileUploadsConcurrentChunks(t *testing.T) {
+	repo := setupTestRepo(t)
+	upload, err := repo.CreateFileUpload(4, "")
+	if err != nil {
+		t.Fatalf("Failed to create upload: %v", err)
+	}
+	uploadID := upload.GetValue("id").(string)
+	defer repo.DeleteFileUpload(upload)
+
+	// Two requests send a chunk at the same offset: one only moves it
+	contents := []string{"aaaa", "bbbb"}
+	errs := make([]error, len(contents))
+	var wg sync.WaitGroup
+	for i, content := range contents {
+		concurrent, err := repo.GetFileUpload(uploadID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, errs[i] = repo.AppendFileUpload(concurrent, 0, strings.NewReader(content))
+		}()
+	}
+	wg.Wait()
+
+	winner := -1
+	for i, err := range errs {
+		if err == nil {
+			winner = i
+		} else if err != ErrFileUploadOffset {
+			t.Errorf("expected ErrFileUploadOffset, got %v", err)
+		}
+	}
+	if winner < 0 || errs[1-winner] == nil {
+		t.Fatalf("expected exactly one chunk to be accepted, got %v", errs)
+	}
+
+	// The chunk of the winner survives the loser
+	chunk, err := FileStorage.Get(fileUploadChunkKey(uploadID, 0))
+	if err != nil {
+		t.Fatalf("expected the chunk to be stored: %v", err)
+	}
+	data, _ := io.ReadAll(chunk)
+	chunk.Close()
+	if string(data) != contents[winner] {
+		t.Errorf("expected %q, got %q", contents[winner], data)
+	}
+	upload, err = repo.GetFileUpload(uploadID)
+	if err != nil || upload.Offset() != 4 || int64Value(upload, "chunks") != 1 {
+		t.Fatalf("expected one chunk at offset 4, got %v", err)
+	}
+	if local, ok := FileStorage.(*LocalStorage); ok {
+		dir, _ := local.path("uploads/" + uploadID)
+		if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+			t.Errorf("expected only the chunk to be left, got %d entries", len(entries))
+		}
+	}
+}
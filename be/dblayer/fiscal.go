@@ -0,0 +1,221 @@
+package dblayer
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+/*
+Italian fiscal identifiers: codice fiscale of people and partita IVA of
+people and companies.
+*/
+
+// Codes of the validation errors of the fiscal identifiers, also used as API error codes
+const (
+	ValidationInvalidCodiceFiscale  = "INVALID_CODICE_FISCALE"
+	ValidationCodiceFiscaleMismatch = "CODICE_FISCALE_MISMATCH"
+	ValidationInvalidPartitaIVA     = "INVALID_PARTITA_IVA"
+)
+
+// ValidationError is an invalid value of a column, returned by the hooks of the entities
+type ValidationError struct {
+	Code    string
+	Field   string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Field + ": " + e.Message
+}
+
+// CodiceFiscaleInfo is the data encoded in a codice fiscale
+type CodiceFiscaleInfo struct {
+	CodiceFiscale  string `json:"codice_fiscale"`
+	BirthDate      string `json:"birth_date"`
+	Sex            string `json:"sex"`
+	BirthplaceCode string `json:"birthplace_code"`
+	// Born abroad: the birthplace is a country (Z code), not an Italian municipality
+	Foreign bool `json:"foreign"`
+}
+
+// codiceFiscaleMonths are the letters of the birth months
+const codiceFiscaleMonths = "ABCDEHLMPRST"
+
+// codiceFiscaleOmocodia are the letters replacing the digits 0-9 of the
+// codici fiscali assigned to homonyms
+const codiceFiscaleOmocodia = "LMNPQRSTUV"
+
+// codiceFiscaleDigitPositions are the positions of the digits, possibly replaced by omocodia letters
+var codiceFiscaleDigitPositions = []int{6, 7, 9, 10, 12, 13, 14}
+
+// codiceFiscaleOddValues are the values of the characters in odd positions (1st, 3rd...) for the check character
+var codiceFiscaleOddValues = map[byte]int{
+	'0': 1, '1': 0, '2': 5, '3': 7, '4': 9, '5': 13, '6': 15, '7': 17, '8': 19, '9': 21,
+	'A': 1, 'B': 0, 'C': 5, 'D': 7, 'E': 9, 'F': 13, 'G': 15, 'H': 17, 'I': 19, 'J': 21,
+	'K': 2, 'L': 4, 'M': 18, 'N': 20, 'O': 11, 'P': 3, 'Q': 6, 'R': 8, 'S': 12, 'T': 14,
+	'U': 16, 'V': 10, 'W': 22, 'X': 25, 'Y': 24, 'Z': 23,
+}
+
+// NormalizeCodiceFiscale uppercases a codice fiscale and removes the spaces
+func NormalizeCodiceFiscale(cf string) string {
+	return strings.ToUpper(strings.Join(strings.Fields(cf), ""))
+}
+
+// NormalizePartitaIVA removes the spaces and the IT country prefix of a partita IVA
+func NormalizePartitaIVA(piva string) string {
+	piva = strings.ToUpper(strings.Join(strings.Fields(piva), ""))
+	return strings.TrimPrefix(piva, "IT")
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isUpperLetter(c byte) bool {
+	return c >= 'A' && c <= 'Z'
+}
+
+// codiceFiscaleCheckChar computes the check character of the first 15 characters
+func codiceFiscaleCheckChar(cf string) byte {
+	sum := 0
+	for i := 0; i < 15; i++ {
+		c := cf[i]
+		if i%2 == 0 {
+			sum += codiceFiscaleOddValues[c]
+		} else if isDigit(c) {
+			sum += int(c - '0')
+		} else {
+			sum += int(c - 'A')
+		}
+	}
+	return byte('A' + sum%26)
+}
+
+// codiceFiscaleError is a ValidationError of the codice_fiscale column
+func codiceFiscaleError(format string, args ...any) *ValidationError {
+	return &ValidationError{Code: ValidationInvalidCodiceFiscale, Field: "codice_fiscale", Message: fmt.Sprintf(format, args...)}
+}
+
+// DecodeCodiceFiscale checks the format and the check character of a
+// codice fiscale of a person and returns its birth date, sex and birthplace.
+// Homonym codes (omocodia) are accepted.
+func DecodeCodiceFiscale(cf string) (*CodiceFiscaleInfo, error) {
+	cf = NormalizeCodiceFiscale(cf)
+	if len(cf) != 16 {
+		return nil, codiceFiscaleError("must be 16 characters long")
+	}
+	// Undo the omocodia substitutions
+	digits := []byte(cf)
+	for _, i := range codiceFiscaleDigitPositions {
+		if index := strings.IndexByte(codiceFiscaleOmocodia, digits[i]); index >= 0 {
+			digits[i] = byte('0' + index)
+		}
+	}
+	for i, c := range digits {
+		isDigitPosition := slices.Contains(codiceFiscaleDigitPositions, i)
+		if (isDigitPosition && !isDigit(c)) || (!isDigitPosition && !isUpperLetter(c)) {
+			return nil, codiceFiscaleError("invalid character at position %d", i+1)
+		}
+	}
+	if check := codiceFiscaleCheckChar(cf); cf[15] != check {
+		return nil, codiceFiscaleError("wrong check character")
+	}
+
+	month := strings.IndexByte(codiceFiscaleMonths, digits[8]) + 1
+	if month == 0 {
+		return nil, codiceFiscaleError("invalid birth month")
+	}
+	day := int(digits[9]-'0')*10 + int(digits[10]-'0')
+	sex := "M"
+	if day > 40 {
+		sex = "F"
+		day -= 40
+	}
+	year := 2000 + int(digits[6]-'0')*10 + int(digits[7]-'0')
+	if year > time.Now().Year() {
+		year -= 100
+	}
+	birthDate := time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC)
+	if day < 1 || birthDate.Day() != day {
+		return nil, codiceFiscaleError("invalid birth day")
+	}
+	return &CodiceFiscaleInfo{
+		CodiceFiscale:  cf,
+		BirthDate:      birthDate.Format("2006-01-02"),
+		Sex:            sex,
+		BirthplaceCode: string(digits[11:15]),
+		Foreign:        digits[11] == 'Z',
+	}, nil
+}
+
+// CheckCodiceFiscale validates a codice fiscale and, when given, its
+// consistency with a birth date (YYYY-MM-DD, a time is ignored) and a sex (M or F)
+func CheckCodiceFiscale(cf string, birthDate string, sex string) error {
+	info, err := DecodeCodiceFiscale(cf)
+	if err != nil {
+		return err
+	}
+	if len(birthDate) >= 10 && birthDate[:10] != info.BirthDate {
+		return &ValidationError{Code: ValidationCodiceFiscaleMismatch, Field: "birth_date", Message: "birth date differs from the codice fiscale"}
+	}
+	if sex = strings.ToUpper(strings.TrimSpace(sex)); sex != "" && sex != info.Sex {
+		return &ValidationError{Code: ValidationCodiceFiscaleMismatch, Field: "sex", Message: "sex differs from the codice fiscale"}
+	}
+	return nil
+}
+
+// CheckPartitaIVA checks the format and the check digit of a partita IVA
+func CheckPartitaIVA(piva string) error {
+	piva = NormalizePartitaIVA(piva)
+	if len(piva) != 11 {
+		return &ValidationError{Code: ValidationInvalidPartitaIVA, Field: "p_iva", Message: "must be 11 digits long"}
+	}
+	sum := 0
+	for i := 0; i < 10; i++ {
+		c := piva[i]
+		if !isDigit(c) {
+			return &ValidationError{Code: ValidationInvalidPartitaIVA, Field: "p_iva", Message: "must contain digits only"}
+		}
+		digit := int(c - '0')
+		if i%2 == 1 {
+			digit *= 2
+			if digit > 9 {
+				digit -= 9
+			}
+		}
+		sum += digit
+	}
+	if !isDigit(piva[10]) || int(piva[10]-'0') != (10-sum%10)%10 {
+		return &ValidationError{Code: ValidationInvalidPartitaIVA, Field: "p_iva", Message: "wrong check digit"}
+	}
+	return nil
+}
+
+// validateFiscalIdentifiers normalizes and validates the codice_fiscale and
+// p_iva columns of a DBPerson or DBCompany, when set. A codice fiscale of 11
+// digits (companies, sole traders) is checked as a partita IVA.
+func validateFiscalIdentifiers(dbe DBEntityInterface) error {
+	if cf := NormalizeCodiceFiscale(stringValue(dbe, "codice_fiscale")); cf != "" {
+		var err error
+		if len(cf) == 11 && isDigit(cf[0]) {
+			if err = CheckPartitaIVA(cf); err != nil {
+				err = codiceFiscaleError("%s", err.(*ValidationError).Message)
+			}
+		} else {
+			err = CheckCodiceFiscale(cf, "", "")
+		}
+		if err != nil {
+			return err
+		}
+		dbe.SetValue("codice_fiscale", cf)
+	}
+	if piva := NormalizePartitaIVA(stringValue(dbe, "p_iva")); piva != "" {
+		if err := CheckPartitaIVA(piva); err != nil {
+			return err
+		}
+		dbe.SetValue("p_iva", piva)
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package dblayer
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDecodeCodiceFiscale(t *testing.T) {
+	info, err := DecodeCodiceFiscale(" rssmra85t10a562s ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.CodiceFiscale != "RSSMRA85T10A562S" || info.BirthDate != "1985-12-10" || info.Sex != "M" || info.BirthplaceCode != "A562" || info.Foreign {
+		t.Errorf("got %+v", info)
+	}
+
+	// Woman born abroad, day + 40
+	info, err = DecodeCodiceFiscale(withCheckChar("BNCGLI90A41Z404"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.BirthDate != "1990-01-01" || info.Sex != "F" || info.BirthplaceCode != "Z404" || !info.Foreign {
+		t.Errorf("got %+v", info)
+	}
+
+	// Omocodia: the last digits 6 and 2 of RSSMRA85T10A562 replaced by S and N
+	info, err = DecodeCodiceFiscale(withCheckChar("RSSMRA85T10A5SN"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.BirthDate != "1985-12-10" || info.BirthplaceCode != "A562" {
+		t.Errorf("omocodia: %+v", info)
+	}
+
+	for _, cf := range []string{"RSSMRA85T10A562", "RSSMRA85T10A562X", "RSSMRA8AT10A562S", withCheckChar("RSSMRA85K10A562"), withCheckChar("RSSMRA85B30A562")} {
+		_, err := DecodeCodiceFiscale(cf)
+		var verr *ValidationError
+		if !errors.As(err, &verr) || verr.Code != ValidationInvalidCodiceFiscale || verr.Field != "codice_fiscale" {
+			t.Errorf("%s: got %v", cf, err)
+		}
+	}
+}
+
+// withCheckChar appends the right check character to 15 characters of a codice fiscale
+func withCheckChar(cf string) string {
+	return cf + string(codiceFiscaleCheckChar(cf))
+}
+
+func TestCheckCodiceFiscale(t *testing.T) {
+	if err := CheckCodiceFiscale("RSSMRA85T10A562S", "1985-12-10 00:00:00", "m"); err != nil {
+		t.Error(err)
+	}
+	var verr *ValidationError
+	if err := CheckCodiceFiscale("RSSMRA85T10A562S", "1985-12-11", ""); !errors.As(err, &verr) || verr.Code != ValidationCodiceFiscaleMismatch || verr.Field != "birth_date" {
+		t.Errorf("birth date: got %v", err)
+	}
+	if err := CheckCodiceFiscale("RSSMRA85T10A562S", "", "F"); !errors.As(err, &verr) || verr.Field != "sex" {
+		t.Errorf("sex: got %v", err)
+	}
+}
+
+func TestCheckPartitaIVA(t *testing.T) {
+	for _, piva := range []string{"00743110157", "IT 01114601006"} {
+		if err := CheckPartitaIVA(piva); err != nil {
+			t.Errorf("%s: %v", piva, err)
+		}
+	}
+	for _, piva := range []string{"00743110158", "0074311015", "0074311015A"} {
+		var verr *ValidationError
+		if err := CheckPartitaIVA(piva); !errors.As(err, &verr) || verr.Code != ValidationInvalidPartitaIVA {
+			t.Errorf("%s: got %v", piva, err)
+		}
+	}
+}
+
+func TestValidateFiscalIdentifiers(t *testing.T) {
+	person := NewDBPerson()
+	person.SetValue("codice_fiscale", "rssmra85t10a562s")
+	person.SetValue("p_iva", "IT00743110157")
+	if err := validateFiscalIdentifiers(person); err != nil {
+		t.Fatal(err)
+	}
+	if stringValue(person, "codice_fiscale") != "RSSMRA85T10A562S" || stringValue(person, "p_iva") != "00743110157" {
+		t.Errorf("not normalized: %v", person.GetAllValues())
+	}
+
+	company := NewDBCompany()
+	company.SetValue("p_iva", "12345678901")
+	if err := validateFiscalIdentifiers(company); err == nil {
+		t.Error("expected an error for a wrong check digit")
+	}
+
+	// Numeric codice fiscale of a sole trader
+	person = NewDBPerson()
+	person.SetValue("codice_fiscale", "00743110157")
+	if err := validateFiscalIdentifiers(person); err != nil {
+		t.Error(err)
+	}
+	person.SetValue("codice_fiscale", "00743110158")
+	var verr *ValidationError
+	if err := validateFiscalIdentifiers(person); !errors.As(err, &verr) || verr.Field != "codice_fiscale" {
+		t.Errorf("got %v", err)
+	}
+}
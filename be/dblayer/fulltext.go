@@ -0,0 +1,352 @@
+package dblayer
+
+import (
+	"html"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+/*
+Full-text text processing: HTML stripping, accent folding, tokenization
+and light stemming for the supported languages (en, it, de, fr).
+
+The same pipeline is applied both when indexing an object and when parsing
+a search query, so a query term always reduces to the indexed form.
+The stemmers are "light" stemmers (inflectional suffixes only, in the spirit
+of J. Savoy's light stemmers): they are cheap and predictable, which is
+what we want for a small CMS index.
+*/
+
+// FullTextLanguages are the languages with a dedicated stemmer
+var FullTextLanguages = []string{"en", "it", "de", "fr"}
+
+// FullTextDefaultLanguage is used when an object has no language column
+var FullTextDefaultLanguage = "en"
+
+// Max length of an indexed term (see rprj_fulltext.term)
+const fullTextMaxTermLength = 64
+
+var (
+	htmlScriptStyleRegexp = regexp.MustCompile(`(?is)<(script|style)[^>]*>.*?</(script|style)>`)
+	htmlCommentRegexp     = regexp.MustCompile(`(?s)<!--.*?-->`)
+	htmlTagRegexp         = regexp.MustCompile(`(?s)<[^>]*>`)
+	whitespaceRegexp      = regexp.MustCompile(`\s+`)
+)
+
+// StripHTML removes tags, comments, scripts and styles from an HTML fragment
+// and decodes the entities. Block boundaries become spaces.
+func StripHTML(s string) string {
+	s = htmlScriptStyleRegexp.ReplaceAllString(s, " ")
+	s = htmlCommentRegexp.ReplaceAllString(s, " ")
+	s = htmlTagRegexp.ReplaceAllString(s, " ")
+	s = html.UnescapeString(s)
+	s = strings.ReplaceAll(s, "\u00a0", " ")
+	return strings.TrimSpace(whitespaceRegexp.ReplaceAllString(s, " "))
+}
+
+var accentFolding = map[rune]string{
+	'à': "a", 'á': "a", 'â': "a", 'ã': "a", 'ä': "a", 'å': "a", 'æ': "ae",
+	'ç': "c",
+	'è': "e", 'é': "e", 'ê': "e", 'ë': "e",
+	'ì': "i", 'í': "i", 'î': "i", 'ï': "i",
+	'ñ': "n",
+	'ò': "o", 'ó': "o", 'ô': "o", 'õ': "o", 'ö': "o", 'ø': "o", 'œ': "oe",
+	'ù': "u", 'ú': "u", 'û': "u", 'ü': "u",
+	'ý': "y", 'ÿ': "y",
+	'ß': "ss",
+}
+
+// FoldRune returns the lowercase, accent free form of a rune.
+// The result may be longer than one character (e.g. ß -> ss)
+func FoldRune(r rune) string {
+	r = unicode.ToLower(r)
+	if folded, ok := accentFolding[r]; ok {
+		return folded
+	}
+	return string(r)
+}
+
+// FoldAccents lowercases the string and removes the diacritics
+func FoldAccents(s string) string {
+	var sb strings.Builder
+	sb.Grow(len(s))
+	for _, r := range s {
+		sb.WriteString(FoldRune(r))
+	}
+	return sb.String()
+}
+
+// NormalizeLanguage maps a language column value (e.g. "it_it", "de-DE")
+// to one of FullTextLanguages, falling back to FullTextDefaultLanguage
+func NormalizeLanguage(language string) string {
+	language = strings.ToLower(strings.TrimSpace(language))
+	if len(language) >= 2 {
+		language = language[:2]
+	}
+	for _, l := range FullTextLanguages {
+		if l == language {
+			return l
+		}
+	}
+	return FullTextDefaultLanguage
+}
+
+// SplitWords splits a text into lowercase, accent free words.
+// Stopwords are NOT removed.
+func SplitWords(text string) []string {
+	return strings.FieldsFunc(FoldAccents(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// truncateTerm cuts a term to fullTextMaxTermLength bytes, on a character boundary
+func truncateTerm(term string) string {
+	if len(term) <= fullTextMaxTermLength {
+		return term
+	}
+	n := fullTextMaxTermLength
+	for n > 0 && !utf8.RuneStart(term[n]) {
+		n--
+	}
+	return term[:n]
+}
+
+// Tokenize splits a text into words, drops stopwords and too short words
+// and returns the stemmed terms for the given language
+func Tokenize(text string, language string) []string {
+	language = NormalizeLanguage(language)
+	words := SplitWords(text)
+	terms := make([]string, 0, len(words))
+	for _, w := range words {
+		if len(w) < 2 || IsStopword(w) {
+			continue
+		}
+		terms = append(terms, truncateTerm(Stem(w, language)))
+	}
+	return terms
+}
+
+// QueryTermVariants returns the forms a query word can have in the index:
+// the word itself and its stem in every supported language.
+// The query language is unknown, the objects may be written in any of them.
+func QueryTermVariants(word string) []string {
+	variants := []string{word}
+	for _, l := range FullTextLanguages {
+		stem := Stem(word, l)
+		found := false
+		for _, v := range variants {
+			if v == stem {
+				found = true
+				break
+			}
+		}
+		if !found {
+			variants = append(variants, stem)
+		}
+	}
+	return variants
+}
+
+// ParseFullTextQuery returns the query words (folded, without stopwords)
+func ParseFullTextQuery(query string) []string {
+	words := []string{}
+	seen := map[string]bool{}
+	for _, w := range SplitWords(query) {
+		if len(w) < 2 || IsStopword(w) {
+			continue
+		}
+		w = truncateTerm(w)
+		if seen[w] {
+			continue
+		}
+		seen[w] = true
+		words = append(words, w)
+	}
+	return words
+}
+
+var stopwords = map[string]bool{}
+
+func init() {
+	lists := []string{
+		// en
+		"a an and are as at be but by for from has have he her his if in into is it its of on or our she so than that the their them then there these they this to was we were what when where which who will with you your",
+		// it
+		"al alla alle agli ai anche che chi ci come con da dal dalla dei del della delle di do e ed gli ha hanno il in io la le lo ma mi ne negli nei nel nella non o per piu se si sono su sul sulla tra tu un una uno",
+		// de
+		"aber als am an auch auf aus bei bin bis das dass dem den der des die doch du ein eine einem einen einer er es fur hat ich ihr im ist ja mit nach nicht noch nur oder sich sie sind so um und uns von vor war wie wir zu zum zur",
+		// fr
+		"au aux avec ce ces cette dans de des du elle en est et eux il ils je la le les leur lui ma mais me meme mes moi mon ne nos notre nous ou par pas pour qu que qui sa se ses son sur ta te tes toi ton tu un une vos votre vous",
+	}
+	for _, list := range lists {
+		for _, w := range strings.Fields(list) {
+			stopwords[w] = true
+		}
+	}
+}
+
+// IsStopword tells if a (folded) word is a stopword in any supported language
+func IsStopword(word string) bool {
+	return stopwords[word]
+}
+
+// Stem reduces a folded word to its stem for the given language
+func Stem(word string, language string) string {
+	// Do not stem numbers, codes and very short words
+	for _, r := range word {
+		if unicode.IsDigit(r) {
+			return word
+		}
+	}
+	if len(word) <= 3 {
+		return word
+	}
+	switch NormalizeLanguage(language) {
+	case "it":
+		return stemItalian(word)
+	case "de":
+		return stemGerman(word)
+	case "fr":
+		return stemFrench(word)
+	default:
+		return stemEnglish(word)
+	}
+}
+
+// replaceSuffix replaces suffix with replacement when the remaining stem
+// is at least minStem characters long
+func replaceSuffix(word, suffix, replacement string, minStem int) (string, bool) {
+	if strings.HasSuffix(word, suffix) && len(word)-len(suffix) >= minStem {
+		return word[:len(word)-len(suffix)] + replacement, true
+	}
+	return word, false
+}
+
+func isVowel(b byte) bool {
+	return strings.IndexByte("aeiouy", b) >= 0
+}
+
+func stemEnglish(w string) string {
+	// Plurals
+	switch {
+	case strings.HasSuffix(w, "sses"):
+		w = w[:len(w)-2]
+	case strings.HasSuffix(w, "ies") && len(w) > 4:
+		w = w[:len(w)-3] + "y"
+	case strings.HasSuffix(w, "ss"), strings.HasSuffix(w, "us"), strings.HasSuffix(w, "is"):
+		// keep
+	case strings.HasSuffix(w, "s") && len(w) > 3:
+		w = w[:len(w)-1]
+	}
+	// Derivational suffixes
+	for _, s := range [][2]string{
+		{"ational", "ate"}, {"ization", "ize"}, {"fulness", "ful"},
+		{"iveness", "ive"}, {"ousness", "ous"}, {"ements", ""}, {"ement", ""},
+		{"ments", ""}, {"ment", ""}, {"ness", ""}, {"ingly", ""}, {"edly", ""},
+	} {
+		if r, ok := replaceSuffix(w, s[0], s[1], 3); ok {
+			w = r
+			break
+		}
+	}
+	// Verbal forms
+	for _, s := range []string{"ing", "ed"} {
+		if stem, ok := replaceSuffix(w, s, "", 3); ok {
+			hasVowel := false
+			for i := 0; i < len(stem); i++ {
+				if isVowel(stem[i]) {
+					hasVowel = true
+					break
+				}
+			}
+			if !hasVowel {
+				continue
+			}
+			n := len(stem)
+			// hopping -> hop
+			if n >= 2 && stem[n-1] == stem[n-2] && !strings.ContainsRune("lsz", rune(stem[n-1])) {
+				stem = stem[:n-1]
+			}
+			w = stem
+			break
+		}
+	}
+	// Final y -> i, final e
+	if len(w) > 3 && strings.HasSuffix(w, "y") && !isVowel(w[len(w)-2]) {
+		w = w[:len(w)-1] + "i"
+	}
+	if len(w) > 4 && strings.HasSuffix(w, "e") {
+		w = w[:len(w)-1]
+	}
+	return w
+}
+
+func stemItalian(w string) string {
+	for _, s := range []string{"amente", "mente", "azione", "azioni", "atore", "atori", "amento", "amenti", "imento", "imenti", "abile", "abili", "ibile", "ibili", "ista", "iste", "isti"} {
+		if r, ok := replaceSuffix(w, s, "", 3); ok {
+			w = r
+			break
+		}
+	}
+	// Hard c/g plurals: banche, banchi -> banc (as banca, banco)
+	for _, s := range []string{"chi", "che", "ghi", "ghe"} {
+		if r, ok := replaceSuffix(w, s, s[:1], 2); ok {
+			return r
+		}
+	}
+	// Plurals and gender: remove the final vowel
+	for _, s := range []string{"ii", "ie", "i", "e", "a", "o"} {
+		if r, ok := replaceSuffix(w, s, "", 3); ok {
+			return r
+		}
+	}
+	return w
+}
+
+func stemGerman(w string) string {
+	for _, s := range []string{"ungen", "ung", "heiten", "heit", "keiten", "keit", "lich", "isch"} {
+		if r, ok := replaceSuffix(w, s, "", 4); ok {
+			w = r
+			break
+		}
+	}
+	for _, s := range []string{"ern", "em", "er", "en", "es", "e", "s", "n"} {
+		if r, ok := replaceSuffix(w, s, "", 3); ok {
+			return r
+		}
+	}
+	return w
+}
+
+func stemFrench(w string) string {
+	if r, ok := replaceSuffix(w, "aux", "al", 2); ok {
+		return r
+	}
+	// Plurals
+	if r, ok := replaceSuffix(w, "s", "", 3); ok {
+		w = r
+	} else if r, ok := replaceSuffix(w, "x", "", 3); ok {
+		w = r
+	}
+	for _, s := range []string{"issement", "ement", "ation", "ite", "euse", "eur", "ive", "if"} {
+		if r, ok := replaceSuffix(w, s, "", 3); ok {
+			w = r
+			break
+		}
+	}
+	// Feminine and verb endings
+	for _, s := range []string{"ee", "er", "ez", "e"} {
+		if r, ok := replaceSuffix(w, s, "", 3); ok {
+			w = r
+			break
+		}
+	}
+	// Double consonant
+	n := len(w)
+	if n > 4 && w[n-1] == w[n-2] && !isVowel(w[n-1]) {
+		w = w[:n-1]
+	}
+	return w
+}
@@ -0,0 +1,191 @@
+package dblayer
+
+import (
+	"html"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Words of context in a search snippet
+const snippetWords = 30
+
+// SearchSnippet is a fragment of a field around the matches of a search query.
+// Text is HTML escaped, the matched words are wrapped in <mark></mark>.
+type SearchSnippet struct {
+	Field   string `json:"field"`
+	Text    string `json:"text"`
+	Matches int    `json:"matches"`
+}
+
+// snippetSourceFields are the fields snippets are taken from, in order
+var snippetSourceFields = []string{"description", "html"}
+
+// textWord is a word of a text, with its byte offsets in the original string
+type textWord struct {
+	start  int
+	end    int
+	folded string
+}
+
+func splitTextWords(text string) []textWord {
+	words := []textWord{}
+	start := -1
+	for i, r := range text {
+		isWordRune := unicode.IsLetter(r) || unicode.IsDigit(r)
+		if isWordRune && start < 0 {
+			start = i
+		} else if !isWordRune && start >= 0 {
+			words = append(words, textWord{start: start, end: i, folded: FoldAccents(text[start:i])})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		words = append(words, textWord{start: start, end: len(text), folded: FoldAccents(text[start:])})
+	}
+	return words
+}
+
+// matchesQueryWords tells if a folded text word matches any of the query words:
+// same word, same stem in a supported language, or (last query word only)
+// same prefix, consistently with SearchFullText
+func matchesQueryWords(word string, queryWords []string) bool {
+	for i, q := range queryWords {
+		if word == q {
+			return true
+		}
+		if i == len(queryWords)-1 && len(q) >= 3 && strings.HasPrefix(word, q) {
+			return true
+		}
+		for _, l := range FullTextLanguages {
+			if Stem(word, l) == Stem(q, l) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// markWords escapes text[start:end] and wraps the matching words in <mark> tags
+func markWords(text string, words []textWord, matches []bool, start int, end int) string {
+	var sb strings.Builder
+	pos := start
+	for i, w := range words {
+		if w.start < start || w.end > end || !matches[i] {
+			continue
+		}
+		sb.WriteString(html.EscapeString(text[pos:w.start]))
+		sb.WriteString("<mark>")
+		sb.WriteString(html.EscapeString(text[w.start:w.end]))
+		sb.WriteString("</mark>")
+		pos = w.end
+	}
+	sb.WriteString(html.EscapeString(text[pos:end]))
+	return sb.String()
+}
+
+// HighlightText returns the whole text, HTML escaped, with the words matching
+// the query words (see ParseFullTextQuery) wrapped in <mark> tags,
+// and the number of matches
+func HighlightText(text string, queryWords []string) (string, int) {
+	words := splitTextWords(text)
+	matches := make([]bool, len(words))
+	count := 0
+	for i, w := range words {
+		if matchesQueryWords(w.folded, queryWords) {
+			matches[i] = true
+			count++
+		}
+	}
+	return markWords(text, words, matches, 0, len(text)), count
+}
+
+// BuildSnippet returns the fragment of the text with the most matches of the
+// query words, HTML escaped and highlighted, and the number of matches in it.
+// Returns an empty string when nothing matches.
+func BuildSnippet(text string, queryWords []string) (string, int) {
+	words := splitTextWords(text)
+	matches := make([]bool, len(words))
+	total := 0
+	for i, w := range words {
+		if matchesQueryWords(w.folded, queryWords) {
+			matches[i] = true
+			total++
+		}
+	}
+	if total == 0 {
+		return "", 0
+	}
+
+	// Sliding window of snippetWords words with the most matches
+	bestStart, bestCount, count := 0, 0, 0
+	for i := range words {
+		if matches[i] {
+			count++
+		}
+		if i >= snippetWords && matches[i-snippetWords] {
+			count--
+		}
+		windowStart := max(0, i-snippetWords+1)
+		if count > bestCount {
+			bestStart, bestCount = windowStart, count
+		}
+	}
+	// Center the window on its first match, when the text allows it
+	firstMatch := bestStart
+	for !matches[firstMatch] {
+		firstMatch++
+	}
+	bestStart = max(0, min(firstMatch-snippetWords/4, len(words)-snippetWords))
+	bestEnd := min(len(words), bestStart+snippetWords)
+
+	startByte := words[bestStart].start
+	endByte := words[bestEnd-1].end
+	// Keep the punctuation right after the last word
+	if r, size := utf8.DecodeRuneInString(text[endByte:]); size > 0 && unicode.IsPunct(r) {
+		endByte += size
+	}
+	count = 0
+	for i := bestStart; i < bestEnd; i++ {
+		if matches[i] {
+			count++
+		}
+	}
+
+	snippet := markWords(text, words, matches, startByte, endByte)
+	if bestStart > 0 {
+		snippet = "… " + snippet
+	}
+	if bestEnd < len(words) {
+		snippet += " …"
+	}
+	return snippet, count
+}
+
+// BuildSearchSnippets returns the snippets of the description and of the
+// HTML stripped body of an object, for the given query words
+func BuildSearchSnippets(dbe DBEntityInterface, queryWords []string) []SearchSnippet {
+	snippets := []SearchSnippet{}
+	if len(queryWords) == 0 {
+		return snippets
+	}
+	for _, field := range snippetSourceFields {
+		text, ok := dbe.GetValue(field).(string)
+		if !ok || text == "" {
+			continue
+		}
+		if fullTextHTMLFields[field] {
+			text = StripHTML(text)
+		}
+		snippet, matches := BuildSnippet(text, queryWords)
+		if matches == 0 {
+			continue
+		}
+		snippets = append(snippets, SearchSnippet{
+			Field:   field,
+			Text:    snippet,
+			Matches: matches,
+		})
+	}
+	return snippets
+}
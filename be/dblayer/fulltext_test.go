@@ -0,0 +1,105 @@
+package dblayer
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestStripHTML(t *testing.T) {
+	in := `<p>Hello&nbsp;<b>world</b></p><script>alert("x")</script><!-- note --><style>p{}</style>caf&eacute;`
+	got := StripHTML(in)
+	if got != "Hello world café" {
+		t.Errorf("StripHTML: got %q", got)
+	}
+}
+
+func TestFoldAccents(t *testing.T) {
+	if got := FoldAccents("Città Straße Éclair"); got != "citta strasse eclair" {
+		t.Errorf("FoldAccents: got %q", got)
+	}
+}
+
+func TestTokenizeAndQuery(t *testing.T) {
+	cases := []struct {
+		language string
+		indexed  string
+		query    string
+	}{
+		{"en_us", "The projects were running", "project run"},
+		{"it_it", "Le biblioteche comunali", "biblioteca comunale"},
+		{"de_de", "Die Häuser der Stadt", "haus"},
+		{"fr_fr", "Les journaux régionaux", "journal regional"},
+	}
+	for _, c := range cases {
+		terms := Tokenize(c.indexed, c.language)
+		for _, word := range ParseFullTextQuery(c.query) {
+			found := false
+			for _, v := range QueryTermVariants(word) {
+				if slices.Contains(terms, v) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				t.Errorf("%s: query word %q not matching %v", c.language, word, terms)
+			}
+		}
+	}
+}
+
+func TestFullTextTermsWeights(t *testing.T) {
+	page := NewDBPage()
+	page.SetValue("name", "Rome")
+	page.SetValue("description", "Visiting rome")
+	page.SetValue("html", "<h1>Rome</h1><p>Guide</p>")
+	page.SetValue("language", "en_us")
+	terms := FullTextTerms(page)
+	if terms["rome"] != 3+2+1 {
+		t.Errorf("weight of rome: got %d, terms=%v", terms["rome"], terms)
+	}
+	if _, ok := terms["h1"]; ok {
+		t.Errorf("markup indexed: %v", terms)
+	}
+}
+
+func TestHighlightText(t *testing.T) {
+	got, count := HighlightText("Città di Roma & <dintorni>", ParseFullTextQuery("citta roma"))
+	if count != 2 || got != "<mark>Città</mark> di <mark>Roma</mark> &amp; &lt;dintorni&gt;" {
+		t.Errorf("HighlightText: got %q (%d)", got, count)
+	}
+}
+
+func TestBuildSnippet(t *testing.T) {
+	text := strings.Repeat("lorem ipsum dolor ", 30) + "the running projects. " + strings.Repeat("sit amet ", 30)
+	snippet, count := BuildSnippet(text, ParseFullTextQuery("project run"))
+	if count != 2 {
+		t.Errorf("BuildSnippet: got %d matches in %q", count, snippet)
+	}
+	if !strings.HasPrefix(snippet, "… ") || !strings.HasSuffix(snippet, " …") {
+		t.Errorf("BuildSnippet: missing ellipsis in %q", snippet)
+	}
+	if !strings.Contains(snippet, "<mark>running</mark> <mark>projects</mark>.") {
+		t.Errorf("BuildSnippet: got %q", snippet)
+	}
+	if snippet, count := BuildSnippet(text, ParseFullTextQuery("missing")); snippet != "" || count != 0 {
+		t.Errorf("BuildSnippet: unexpected match %q", snippet)
+	}
+}
+
+func TestTruncateTerm(t *testing.T) {
+	long := strings.Repeat("a", 63) + "яя"
+	if got := truncateTerm(long); got != strings.Repeat("a", 63) {
+		t.Errorf("expected the character to be dropped whole, got %q", got)
+	}
+	cyrillic := strings.Repeat("я", 40)
+	for _, term := range append(Tokenize(cyrillic, "en"), ParseFullTextQuery(cyrillic)...) {
+		if len(term) > fullTextMaxTermLength || !utf8.ValidString(term) {
+			t.Errorf("expected a valid term of at most %d bytes, got %q", fullTextMaxTermLength, term)
+		}
+	}
+	if got := truncateTerm("short"); got != "short" {
+		t.Errorf("expected short, got %q", got)
+	}
+}
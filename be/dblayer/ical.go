@@ -0,0 +1,836 @@
+package dblayer
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// ICalUIDDomain is the domain of the UIDs of the events created in rhobee
+const ICalUIDDomain = "rhobee"
+
+const (
+	icalDateLayout     = "20060102"
+	icalDateTimeLayout = "20060102T150405"
+)
+
+// rruleWeekdays maps time.Weekday to the RFC 5545 day names
+var rruleWeekdays = []string{"SU", "MO", "TU", "WE", "TH", "FR", "SA"}
+
+// ICalEscape escapes a TEXT value
+func ICalEscape(text string) string {
+	text = strings.ReplaceAll(text, "\\", "\\\\")
+	text = strings.ReplaceAll(text, ";", "\\;")
+	text = strings.ReplaceAll(text, ",", "\\,")
+	text = strings.ReplaceAll(text, "\r\n", "\\n")
+	text = strings.ReplaceAll(text, "\r", "\\n")
+	return strings.ReplaceAll(text, "\n", "\\n")
+}
+
+// stripLineBreaks removes the line breaks of a URI value, not escaped like a
+// text: they would start a new property
+func stripLineBreaks(value string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(value)
+}
+
+// ICalUnescape reverses ICalEscape
+func ICalUnescape(text string) string {
+	var sb strings.Builder
+	escaped := false
+	for _, r := range text {
+		if escaped {
+			switch r {
+			case 'n', 'N':
+				sb.WriteRune('\n')
+			default:
+				sb.WriteRune(r)
+			}
+			escaped = false
+			continue
+		}
+		if r == '\\' {
+			escaped = true
+			continue
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
+
+// ICalFoldLine splits a content line in lines of at most 75 octets,
+// without breaking UTF-8 sequences, terminated by CRLF
+func ICalFoldLine(line string) string {
+	var sb strings.Builder
+	limit := 75
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		sb.WriteString(line[:cut])
+		sb.WriteString("\r\n ")
+		line = line[cut:]
+		// The leading space of the continuation counts
+		limit = 74
+	}
+	sb.WriteString(line)
+	sb.WriteString("\r\n")
+	return sb.String()
+}
+
+// icalTimeZone tells how to write the times of loc: UTC when loc has no IANA name
+func icalTimeZone(loc *time.Location) string {
+	name := loc.String()
+	if name == "Local" || name == "UTC" || name == "" {
+		return ""
+	}
+	return name
+}
+
+// icalOffset formats a UTC offset in seconds as +HHMM
+func icalOffset(offset int) string {
+	sign := "+"
+	if offset < 0 {
+		sign, offset = "-", -offset
+	}
+	return fmt.Sprintf("%s%02d%02d", sign, offset/3600, offset%3600/60)
+}
+
+// icalTransitions returns the instants between from and to when the offset of loc changes
+func icalTransitions(loc *time.Location, from time.Time, to time.Time) []time.Time {
+	transitions := []time.Time{}
+	offsetAt := func(t time.Time) int {
+		_, offset := t.In(loc).Zone()
+		return offset
+	}
+	for t := from; t.Before(to); t = t.Add(24 * time.Hour) {
+		next := t.Add(24 * time.Hour)
+		if offsetAt(t) == offsetAt(next) {
+			continue
+		}
+		// The first second with the new offset
+		lo, hi := t, next
+		for hi.Sub(lo) > time.Second {
+			mid := lo.Add(hi.Sub(lo) / 2)
+			if offsetAt(mid) == offsetAt(lo) {
+				lo = mid
+			} else {
+				hi = mid
+			}
+		}
+		transitions = append(transitions, hi.Truncate(time.Second))
+	}
+	return transitions
+}
+
+// icalObservance returns a STANDARD or DAYLIGHT component starting at the
+// transition at, repeated every year with rrule when not empty
+func icalObservance(loc *time.Location, at time.Time, offsetFrom int, rrule string) []string {
+	local := at.In(loc)
+	name, offsetTo := local.Zone()
+	kind := "STANDARD"
+	if local.IsDST() {
+		kind = "DAYLIGHT"
+	}
+	// DTSTART is the wall clock time before the transition
+	lines := []string{
+		"BEGIN:" + kind,
+		"DTSTART:" + at.UTC().Add(time.Duration(offsetFrom)*time.Second).Format(icalDateTimeLayout),
+		"TZOFFSETFROM:" + icalOffset(offsetFrom),
+		"TZOFFSETTO:" + icalOffset(offsetTo),
+	}
+	if rrule != "" {
+		lines = append(lines, "RRULE:"+rrule)
+	}
+	return append(lines, "TZNAME:"+ICalEscape(name), "END:"+kind)
+}
+
+// icalVTimezone returns the VTIMEZONE of the TZID of loc, with the offset
+// changes from fromYear to toYear: those of toYear repeat every year after
+func icalVTimezone(loc *time.Location, fromYear int, toYear int) []string {
+	tzid := icalTimeZone(loc)
+	if tzid == "" {
+		return nil
+	}
+	lines := []string{"BEGIN:VTIMEZONE", "TZID:" + tzid}
+	from := time.Date(fromYear, 1, 1, 0, 0, 0, 0, time.UTC)
+	lastYear := time.Date(toYear, 1, 1, 0, 0, 0, 0, time.UTC)
+	transitions := icalTransitions(loc, from, lastYear.AddDate(1, 0, 0))
+	_, initial := from.In(loc).Zone()
+	if len(transitions) == 0 || transitions[0].After(from) {
+		// The offset in effect at the start
+		lines = append(lines, icalObservance(loc, from, initial, "")...)
+	}
+	offsetFrom := initial
+	for _, at := range transitions {
+		rrule := ""
+		if !at.Before(lastYear) {
+			local := at.Add(time.Duration(offsetFrom) * time.Second).UTC()
+			n := (local.Day()-1)/7 + 1
+			if local.AddDate(0, 0, 7).Month() != local.Month() {
+				n = -1
+			}
+			rrule = "FREQ=YEARLY;BYMONTH=" + strconv.Itoa(int(local.Month())) + ";BYDAY=" + strconv.Itoa(n) + rruleWeekdays[local.Weekday()]
+		}
+		lines = append(lines, icalObservance(loc, at, offsetFrom, rrule)...)
+		_, offsetFrom = at.In(loc).Zone()
+	}
+	return append(lines, "END:VTIMEZONE")
+}
+
+// icalEventYears returns the years spanned by the timed events, to the current year at least
+func icalEventYears(events []DBEntityInterface, loc *time.Location) (int, int, bool) {
+	fromYear, toYear := time.Now().Year(), time.Now().Year()
+	found := false
+	for _, event := range events {
+		if stringValue(event, "all_day") == "1" {
+			continue
+		}
+		for _, column := range []string{"start_date", "end_date"} {
+			if t, err := ParseDateTimeIn(stringValue(event, column), loc); err == nil {
+				fromYear, toYear = min(fromYear, t.Year()), max(toYear, t.Year())
+				found = true
+			}
+		}
+	}
+	return fromYear, toYear, found
+}
+
+// icalDateTimeProperty formats a DTSTART/DTEND like property
+func icalDateTimeProperty(name string, t time.Time, allDay bool, loc *time.Location) string {
+	if allDay {
+		return name + ";VALUE=DATE:" + t.Format(icalDateLayout)
+	}
+	if tzid := icalTimeZone(loc); tzid != "" {
+		return name + ";TZID=" + tzid + ":" + t.In(loc).Format(icalDateTimeLayout)
+	}
+	return name + ":" + t.UTC().Format(icalDateTimeLayout) + "Z"
+}
+
+// RecurrenceRRule converts a recurrence rule to an RRULE value.
+// start is the first occurrence, used for UNTIL of timed events.
+func RecurrenceRRule(rec *Recurrence, start time.Time, allDay bool) string {
+	parts := []string{}
+	switch rec.Type {
+	case RecurrenceDaily:
+		parts = append(parts, "FREQ=DAILY")
+	case RecurrenceWeekly:
+		parts = append(parts, "FREQ=WEEKLY", "BYDAY="+rruleWeekdays[rec.WeekDay])
+	case RecurrenceMonthly:
+		parts = append(parts, "FREQ=MONTHLY")
+		switch {
+		case rec.MonthDay != 0:
+			parts = append(parts, "BYMONTHDAY="+strconv.Itoa(rec.MonthDay))
+		case rec.MonthWeek != 0:
+			parts = append(parts, "BYDAY="+rruleNthWeekday(rec.MonthWeek, rec.MonthWeekDay))
+		default:
+			parts = append(parts, "BYMONTHDAY="+strconv.Itoa(start.Day()))
+		}
+	case RecurrenceYearly:
+		parts = append(parts, "FREQ=YEARLY")
+		switch {
+		case rec.YearMonth > 0 && rec.YearMonth <= 12 && rec.YearMonthDay != 0:
+			parts = append(parts, "BYMONTH="+strconv.Itoa(rec.YearMonth), "BYMONTHDAY="+strconv.Itoa(rec.YearMonthDay))
+		case rec.YearMonth > 0 && rec.YearMonth <= 12 && rec.YearWeek != 0:
+			parts = append(parts, "BYMONTH="+strconv.Itoa(rec.YearMonth), "BYDAY="+rruleNthWeekday(rec.YearWeek, rec.YearWeekDay))
+		case rec.YearDay > 0:
+			parts = append(parts, "BYYEARDAY="+strconv.Itoa(rec.YearDay))
+		}
+	default:
+		return ""
+	}
+	if rec.Every > 1 {
+		parts = append(parts, "INTERVAL="+strconv.Itoa(rec.Every))
+	}
+	if rec.Times > 0 {
+		parts = append(parts, "COUNT="+strconv.Itoa(rec.Times))
+	} else if !rec.Until.IsZero() {
+		if allDay {
+			parts = append(parts, "UNTIL="+rec.Until.Format(icalDateLayout))
+		} else {
+			until := time.Date(rec.Until.Year(), rec.Until.Month(), rec.Until.Day(),
+				start.Hour(), start.Minute(), start.Second(), 0, rec.Until.Location())
+			parts = append(parts, "UNTIL="+until.UTC().Format(icalDateTimeLayout)+"Z")
+		}
+	}
+	return strings.Join(parts, ";")
+}
+
+// rruleNthWeekday formats the n-th weekday of a month, 5 being the last one
+func rruleNthWeekday(n int, weekday time.Weekday) string {
+	if n >= 5 || n < 0 {
+		n = -1
+	}
+	return strconv.Itoa(n) + rruleWeekdays[weekday]
+}
+
+// icalAlarmTrigger returns the TRIGGER of the alarm of an event, "" without alarm.
+// alarm_unit: 0 minutes, 1 hours, 2 days; before_event: 0 before the start, 1 after.
+func icalAlarmTrigger(dbe DBEntityInterface) string {
+	if stringValue(dbe, "alarm") != "1" {
+		return ""
+	}
+	amount := intValue(dbe, "alarm_minute")
+	var duration string
+	switch stringValue(dbe, "alarm_unit") {
+	case "1":
+		duration = fmt.Sprintf("PT%dH", amount)
+	case "2":
+		duration = fmt.Sprintf("P%dD", amount)
+	default:
+		duration = fmt.Sprintf("PT%dM", amount)
+	}
+	if stringValue(dbe, "before_event") == "1" {
+		return duration
+	}
+	return "-" + duration
+}
+
+// EventICalUID returns the UID of an event created in rhobee
+func EventICalUID(eventID string) string {
+	return eventID + "@" + ICalUIDDomain
+}
+
+// EventToICal returns the VEVENT of an event. Stored datetimes are wall clock times in loc.
+func EventToICal(dbe DBEntityInterface, uid string, loc *time.Location) (string, error) {
+	start, err := ParseDateTimeIn(stringValue(dbe, "start_date"), loc)
+	if err != nil {
+		return "", fmt.Errorf("event %s: invalid start_date", stringValue(dbe, "id"))
+	}
+	end, err := ParseDateTimeIn(stringValue(dbe, "end_date"), loc)
+	if err != nil || end.Before(start) {
+		end = start
+	}
+	allDay := stringValue(dbe, "all_day") == "1"
+	if uid == "" {
+		uid = EventICalUID(stringValue(dbe, "id"))
+	}
+
+	lines := []string{"BEGIN:VEVENT", "UID:" + ICalEscape(uid)}
+	stamp := time.Now().UTC()
+	if modified, err := ParseDateTimeIn(stringValue(dbe, "last_modify_date"), loc); err == nil {
+		stamp = modified.UTC()
+		lines = append(lines, "LAST-MODIFIED:"+stamp.Format(icalDateTimeLayout)+"Z")
+	}
+	lines = append(lines, "DTSTAMP:"+stamp.Format(icalDateTimeLayout)+"Z")
+	if created, err := ParseDateTimeIn(stringValue(dbe, "creation_date"), loc); err == nil {
+		lines = append(lines, "CREATED:"+created.UTC().Format(icalDateTimeLayout)+"Z")
+	}
+	if allDay {
+		// DTEND of all day events is exclusive
+		lines = append(lines,
+			icalDateTimeProperty("DTSTART", dateOf(start, loc), true, loc),
+			icalDateTimeProperty("DTEND", dateOf(end, loc).AddDate(0, 0, 1), true, loc))
+	} else {
+		lines = append(lines,
+			icalDateTimeProperty("DTSTART", start, false, loc),
+			icalDateTimeProperty("DTEND", end, false, loc))
+	}
+	lines = append(lines, "SUMMARY:"+ICalEscape(stringValue(dbe, "name")))
+	if description := stringValue(dbe, "description"); description != "" {
+		lines = append(lines, "DESCRIPTION:"+ICalEscape(description))
+	}
+	if url := stringValue(dbe, "url"); url != "" {
+		lines = append(lines, "URL:"+stripLineBreaks(url))
+	}
+	if category := stringValue(dbe, "category"); category != "" {
+		lines = append(lines, "CATEGORIES:"+ICalEscape(category))
+	}
+	if rec := EventRecurrence(dbe, loc); rec != nil {
+		if rrule := RecurrenceRRule(rec, start, allDay); rrule != "" {
+			lines = append(lines, "RRULE:"+rrule)
+		}
+	}
+	if trigger := icalAlarmTrigger(dbe); trigger != "" {
+		lines = append(lines,
+			"BEGIN:VALARM",
+			"ACTION:DISPLAY",
+			"DESCRIPTION:"+ICalEscape(stringValue(dbe, "name")),
+			"TRIGGER:"+trigger,
+			"END:VALARM")
+	}
+	lines = append(lines, "END:VEVENT")
+
+	var sb strings.Builder
+	for _, line := range lines {
+		sb.WriteString(ICalFoldLine(line))
+	}
+	return sb.String(), nil
+}
+
+// BuildICalendar returns a VCALENDAR with the given events.
+// uids maps event ids to the UIDs of imported events.
+func BuildICalendar(name string, events []DBEntityInterface, uids map[string]string, loc *time.Location) string {
+	var sb strings.Builder
+	header := []string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"PRODID:-//rhobee//Calendar//EN",
+		"CALSCALE:GREGORIAN",
+		"METHOD:PUBLISH",
+		"X-WR-CALNAME:" + ICalEscape(name),
+	}
+	if tzid := icalTimeZone(loc); tzid != "" {
+		header = append(header, "X-WR-TIMEZONE:"+tzid)
+	}
+	// The TZID of the times is defined by a VTIMEZONE
+	if fromYear, toYear, timed := icalEventYears(events, loc); timed {
+		header = append(header, icalVTimezone(loc, fromYear, toYear)...)
+	}
+	for _, line := range header {
+		sb.WriteString(ICalFoldLine(line))
+	}
+	for _, event := range events {
+		vevent, err := EventToICal(event, uids[stringValue(event, "id")], loc)
+		if err != nil {
+			continue
+		}
+		sb.WriteString(vevent)
+	}
+	sb.WriteString(ICalFoldLine("END:VCALENDAR"))
+	return sb.String()
+}
+
+// BuildEventICalendar returns a VCALENDAR with a single event, as a CalDAV resource (no METHOD)
+func BuildEventICalendar(event DBEntityInterface, uid string, loc *time.Location) (string, error) {
+	vevent, err := EventToICal(event, uid, loc)
+	if err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	header := []string{"BEGIN:VCALENDAR", "VERSION:2.0", "PRODID:-//rhobee//Calendar//EN", "CALSCALE:GREGORIAN"}
+	if fromYear, toYear, timed := icalEventYears([]DBEntityInterface{event}, loc); timed {
+		header = append(header, icalVTimezone(loc, fromYear, toYear)...)
+	}
+	for _, line := range header {
+		sb.WriteString(ICalFoldLine(line))
+	}
+	sb.WriteString(vevent)
+	sb.WriteString(ICalFoldLine("END:VCALENDAR"))
+	return sb.String(), nil
+}
+
+// ICalProperty is a content line: NAME;PARAM=VALUE:value
+type ICalProperty struct {
+	Name   string
+	Params map[string]string
+	Value  string
+}
+
+// unfoldICal joins the folded lines of an iCalendar (or vCard) stream
+func unfoldICal(data string) []string {
+	data = strings.ReplaceAll(data, "\r\n", "\n")
+	data = strings.ReplaceAll(data, "\r", "\n")
+	lines := []string{}
+	for _, line := range strings.Split(data, "\n") {
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// parseICalProperty splits a content line; the value starts at the first
+// colon not inside a quoted parameter value
+func parseICalProperty(line string) (ICalProperty, bool) {
+	inQuotes := false
+	colon := -1
+	for i, r := range line {
+		if r == '"' {
+			inQuotes = !inQuotes
+		} else if r == ':' && !inQuotes {
+			colon = i
+			break
+		}
+	}
+	if colon < 0 {
+		return ICalProperty{}, false
+	}
+	head := strings.Split(line[:colon], ";")
+	prop := ICalProperty{
+		Name:   strings.ToUpper(strings.TrimSpace(head[0])),
+		Params: make(map[string]string),
+		Value:  line[colon+1:],
+	}
+	// Grouped vCard properties: item1.EMAIL
+	if dot := strings.LastIndex(prop.Name, "."); dot >= 0 {
+		prop.Name = prop.Name[dot+1:]
+	}
+	for _, param := range head[1:] {
+		key, value, found := strings.Cut(param, "=")
+		key = strings.ToUpper(strings.TrimSpace(key))
+		if !found {
+			// vCard 2.1 style: TEL;HOME;VOICE
+			value, key = key, "TYPE"
+		}
+		value = strings.Trim(value, "\"")
+		if existing, ok := prop.Params[key]; ok {
+			value = existing + "," + value
+		}
+		prop.Params[key] = value
+	}
+	return prop, true
+}
+
+// ICalEvent is a VEVENT read from an iCalendar file
+type ICalEvent struct {
+	UID         string
+	Summary     string
+	Description string
+	URL         string
+	Category    string
+	Start       time.Time
+	End         time.Time
+	AllDay      bool
+	RRule       map[string]string
+	// Set on the exceptions of a recurring event, which are not supported
+	RecurrenceID string
+	// Alarm offset from the start of the event, nil without alarm
+	Alarm *time.Duration
+}
+
+// parseICalTime reads a DATE or DATE-TIME value in loc.
+// UTC times ("Z") and TZID times are converted to loc.
+func parseICalTime(prop ICalProperty, loc *time.Location) (time.Time, bool, error) {
+	value := strings.TrimSpace(prop.Value)
+	if prop.Params["VALUE"] == "DATE" || len(value) == len(icalDateLayout) {
+		t, err := time.ParseInLocation(icalDateLayout, value, loc)
+		return t, true, err
+	}
+	if strings.HasSuffix(value, "Z") {
+		t, err := time.Parse(icalDateTimeLayout, strings.TrimSuffix(value, "Z"))
+		return t.In(loc), false, err
+	}
+	tzLoc := loc
+	if tzid := prop.Params["TZID"]; tzid != "" {
+		if l, err := time.LoadLocation(tzid); err == nil {
+			tzLoc = l
+		}
+	}
+	t, err := time.ParseInLocation(icalDateTimeLayout, value, tzLoc)
+	return t.In(loc), false, err
+}
+
+// ParseICalDuration reads an RFC 5545 duration, e.g. -PT15M, P1DT2H, P1W
+func ParseICalDuration(value string) (time.Duration, error) {
+	value = strings.TrimSpace(strings.ToUpper(value))
+	sign := time.Duration(1)
+	if strings.HasPrefix(value, "-") {
+		sign = -1
+		value = value[1:]
+	} else {
+		value = strings.TrimPrefix(value, "+")
+	}
+	if !strings.HasPrefix(value, "P") || len(value) < 3 {
+		return 0, fmt.Errorf("invalid duration: %s", value)
+	}
+	var total time.Duration
+	number := ""
+	inTime := false
+	for _, r := range value[1:] {
+		switch {
+		case r >= '0' && r <= '9':
+			number += string(r)
+			continue
+		case r == 'T':
+			inTime = true
+			continue
+		}
+		n, err := strconv.Atoi(number)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration: %s", value)
+		}
+		number = ""
+		switch {
+		case r == 'W':
+			total += time.Duration(n) * 7 * 24 * time.Hour
+		case r == 'D':
+			total += time.Duration(n) * 24 * time.Hour
+		case r == 'H' && inTime:
+			total += time.Duration(n) * time.Hour
+		case r == 'M' && inTime:
+			total += time.Duration(n) * time.Minute
+		case r == 'S' && inTime:
+			total += time.Duration(n) * time.Second
+		default:
+			return 0, fmt.Errorf("invalid duration: %s", value)
+		}
+	}
+	if number != "" {
+		return 0, fmt.Errorf("invalid duration: %s", value)
+	}
+	return sign * total, nil
+}
+
+// ParseICalendar reads the VEVENTs of an iCalendar stream.
+// Times are converted to loc, the timezone of the stored datetimes.
+func ParseICalendar(data string, loc *time.Location) ([]ICalEvent, error) {
+	events := []ICalEvent{}
+	var current *ICalEvent
+	var duration *time.Duration
+	inAlarm := false
+	foundCalendar := false
+	for _, line := range unfoldICal(data) {
+		prop, ok := parseICalProperty(line)
+		if !ok {
+			continue
+		}
+		value := strings.TrimSpace(prop.Value)
+		switch prop.Name {
+		case "BEGIN":
+			switch strings.ToUpper(value) {
+			case "VCALENDAR":
+				foundCalendar = true
+			case "VEVENT":
+				current = &ICalEvent{}
+				duration = nil
+			case "VALARM":
+				inAlarm = current != nil
+			}
+			continue
+		case "END":
+			switch strings.ToUpper(value) {
+			case "VEVENT":
+				if current != nil {
+					if current.End.IsZero() {
+						current.End = current.Start
+						if duration != nil {
+							current.End = current.Start.Add(*duration)
+						} else if current.AllDay {
+							current.End = current.Start.AddDate(0, 0, 1)
+						}
+					}
+					if current.AllDay && current.End.After(current.Start) {
+						// Stored end date is inclusive
+						current.End = current.End.AddDate(0, 0, -1)
+					}
+					if !current.Start.IsZero() {
+						events = append(events, *current)
+					}
+				}
+				current = nil
+			case "VALARM":
+				inAlarm = false
+			}
+			continue
+		}
+		if current == nil {
+			continue
+		}
+		if inAlarm {
+			// Only alarms relative to the start are supported
+			if prop.Name == "TRIGGER" && current.Alarm == nil && prop.Params["VALUE"] != "DATE-TIME" && prop.Params["RELATED"] != "END" {
+				if d, err := ParseICalDuration(value); err == nil {
+					current.Alarm = &d
+				}
+			}
+			continue
+		}
+		switch prop.Name {
+		case "UID":
+			current.UID = ICalUnescape(value)
+		case "SUMMARY":
+			current.Summary = ICalUnescape(prop.Value)
+		case "DESCRIPTION":
+			current.Description = ICalUnescape(prop.Value)
+		case "URL":
+			current.URL = value
+		case "CATEGORIES":
+			current.Category = ICalUnescape(value)
+		case "DTSTART":
+			t, allDay, err := parseICalTime(prop, loc)
+			if err != nil {
+				return nil, fmt.Errorf("invalid DTSTART %q: %v", value, err)
+			}
+			current.Start, current.AllDay = t, allDay
+		case "DTEND":
+			t, _, err := parseICalTime(prop, loc)
+			if err != nil {
+				return nil, fmt.Errorf("invalid DTEND %q: %v", value, err)
+			}
+			current.End = t
+		case "DURATION":
+			if d, err := ParseICalDuration(value); err == nil {
+				duration = &d
+			}
+		case "RECURRENCE-ID":
+			current.RecurrenceID = value
+		case "RRULE":
+			current.RRule = make(map[string]string)
+			for _, part := range strings.Split(value, ";") {
+				if k, v, found := strings.Cut(part, "="); found {
+					current.RRule[strings.ToUpper(k)] = strings.ToUpper(v)
+				}
+			}
+		}
+	}
+	if !foundCalendar {
+		return nil, fmt.Errorf("not an iCalendar file")
+	}
+	return events, nil
+}
+
+// parseRRuleWeekday reads "MO", "2TU" or "-1FR"
+func parseRRuleWeekday(value string) (int, time.Weekday, bool) {
+	value = strings.TrimSpace(value)
+	if len(value) < 2 {
+		return 0, 0, false
+	}
+	name := value[len(value)-2:]
+	for i, wd := range rruleWeekdays {
+		if wd == name {
+			n := 0
+			if prefix := value[:len(value)-2]; prefix != "" {
+				var err error
+				if n, err = strconv.Atoi(strings.TrimPrefix(prefix, "+")); err != nil {
+					return 0, 0, false
+				}
+			}
+			return n, time.Weekday(i), true
+		}
+	}
+	return 0, 0, false
+}
+
+// toLegacyWeekday converts a time.Weekday to the events table convention (0=monday)
+func toLegacyWeekday(weekday time.Weekday) string {
+	return strconv.Itoa((int(weekday) + 6) % 7)
+}
+
+// ApplyTo copies the event into a DBEvent.
+// Returns a warning when the RRULE cannot be represented exactly.
+func (ev *ICalEvent) ApplyTo(dbe DBEntityInterface, loc *time.Location) string {
+	name := strings.TrimSpace(ev.Summary)
+	if name == "" {
+		name = "(no title)"
+	}
+	dbe.SetValue("name", name)
+	dbe.SetValue("description", ev.Description)
+	dbe.SetValue("url", ev.URL)
+	dbe.SetValue("category", ev.Category)
+	if ev.AllDay {
+		dbe.SetValue("all_day", "1")
+	} else {
+		dbe.SetValue("all_day", "0")
+	}
+	dbe.SetValue("start_date", ev.Start.In(loc).Format(time.DateTime))
+	dbe.SetValue("end_date", ev.End.In(loc).Format(time.DateTime))
+
+	dbe.SetValue("alarm", "0")
+	if ev.Alarm != nil {
+		offset := *ev.Alarm
+		dbe.SetValue("alarm", "1")
+		dbe.SetValue("before_event", "0")
+		if offset > 0 {
+			dbe.SetValue("before_event", "1")
+		} else {
+			offset = -offset
+		}
+		minutes := int(offset.Minutes())
+		switch {
+		case minutes > 0 && minutes%(24*60) == 0:
+			dbe.SetValue("alarm_minute", minutes/(24*60))
+			dbe.SetValue("alarm_unit", "2")
+		case minutes > 0 && minutes%60 == 0:
+			dbe.SetValue("alarm_minute", minutes/60)
+			dbe.SetValue("alarm_unit", "1")
+		default:
+			dbe.SetValue("alarm_minute", minutes)
+			dbe.SetValue("alarm_unit", "0")
+		}
+	}
+
+	// Reset the recurrence columns, then map the RRULE
+	dbe.SetValue("recurrence", "0")
+	for _, column := range []string{"daily_every_x", "weekly_every_x", "monthly_every_x", "monthly_day_of_the_month",
+		"monthly_week_number", "yearly_month_number", "yearly_month_day", "yearly_week_number",
+		"yearly_day_of_the_year", "recurrence_times"} {
+		dbe.SetValue(column, 0)
+	}
+	for _, column := range []string{"recurrence_type", "weekly_day_of_the_week", "monthly_week_day", "yearly_week_day"} {
+		dbe.SetValue(column, "0")
+	}
+	dbe.SetValue("recurrence_end_date", nil)
+	if ev.RRule == nil {
+		return ""
+	}
+
+	warning := ""
+	interval, _ := strconv.Atoi(ev.RRule["INTERVAL"])
+	if interval < 1 {
+		interval = 1
+	}
+	byDay := strings.Split(ev.RRule["BYDAY"], ",")
+	if len(byDay) > 1 {
+		warning = "only the first BYDAY of the recurrence is supported"
+	}
+	n, weekday, hasWeekday := parseRRuleWeekday(byDay[0])
+	byMonthDay, _ := strconv.Atoi(strings.Split(ev.RRule["BYMONTHDAY"], ",")[0])
+	byMonth, _ := strconv.Atoi(strings.Split(ev.RRule["BYMONTH"], ",")[0])
+	if n == -1 {
+		n = 5
+	}
+
+	switch ev.RRule["FREQ"] {
+	case "DAILY":
+		dbe.SetValue("recurrence_type", strconv.Itoa(RecurrenceDaily))
+		dbe.SetValue("daily_every_x", interval)
+	case "WEEKLY":
+		dbe.SetValue("recurrence_type", strconv.Itoa(RecurrenceWeekly))
+		dbe.SetValue("weekly_every_x", interval)
+		if !hasWeekday {
+			weekday = ev.Start.In(loc).Weekday()
+		}
+		dbe.SetValue("weekly_day_of_the_week", toLegacyWeekday(weekday))
+	case "MONTHLY":
+		dbe.SetValue("recurrence_type", strconv.Itoa(RecurrenceMonthly))
+		dbe.SetValue("monthly_every_x", interval)
+		switch {
+		case byMonthDay != 0:
+			dbe.SetValue("monthly_day_of_the_month", byMonthDay)
+		case hasWeekday && n != 0:
+			dbe.SetValue("monthly_week_number", n)
+			dbe.SetValue("monthly_week_day", toLegacyWeekday(weekday))
+		}
+	case "YEARLY":
+		dbe.SetValue("recurrence_type", strconv.Itoa(RecurrenceYearly))
+		if interval > 1 {
+			warning = "yearly recurrences with an interval are not supported"
+		}
+		switch {
+		case ev.RRule["BYYEARDAY"] != "":
+			day, _ := strconv.Atoi(strings.Split(ev.RRule["BYYEARDAY"], ",")[0])
+			dbe.SetValue("yearly_day_of_the_year", day)
+		case byMonth > 0 && hasWeekday && n != 0:
+			dbe.SetValue("yearly_month_number", byMonth)
+			dbe.SetValue("yearly_week_number", n)
+			dbe.SetValue("yearly_week_day", toLegacyWeekday(weekday))
+		case byMonth > 0 && byMonthDay != 0:
+			dbe.SetValue("yearly_month_number", byMonth)
+			dbe.SetValue("yearly_month_day", byMonthDay)
+		}
+	default:
+		return "unsupported recurrence: " + ev.RRule["FREQ"]
+	}
+	dbe.SetValue("recurrence", "1")
+
+	if count, err := strconv.Atoi(ev.RRule["COUNT"]); err == nil && count > 0 {
+		dbe.SetValue("recurrence_times", count)
+	}
+	if until := ev.RRule["UNTIL"]; until != "" {
+		t, _, err := parseICalTime(ICalProperty{Value: until}, loc)
+		if err == nil {
+			dbe.SetValue("recurrence_end_date", dateOf(t, loc).Format(time.DateTime))
+		} else {
+			warning = "invalid UNTIL: " + until
+		}
+	}
+	return warning
+}
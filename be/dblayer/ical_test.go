@@ -0,0 +1,261 @@
+package dblayer
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestICalEscapeAndFold(t *testing.T) {
+	text := "Meeting; room 1, floor 2\nbring \\ slides"
+	if got := ICalUnescape(ICalEscape(text)); got != text {
+		t.Errorf("escape round trip: got %q", got)
+	}
+
+	line := "DESCRIPTION:" + strings.Repeat("àbc", 40)
+	folded := ICalFoldLine(line)
+	for _, part := range strings.Split(strings.TrimSuffix(folded, "\r\n"), "\r\n") {
+		if len(part) > 75 {
+			t.Errorf("folded line longer than 75 octets: %d", len(part))
+		}
+	}
+	if unfolded := unfoldICal(folded); len(unfolded) != 1 || unfolded[0] != line {
+		t.Errorf("unfold: got %q", unfolded)
+	}
+}
+
+func TestEventToICalLineBreaks(t *testing.T) {
+	event := newTestEvent("2024-01-26 17:00:00", "2024-01-26 18:30:00", false, map[string]string{
+		"name": "Review\rDTSTART:20000101", "url": "https://example.com/r\r\nUID:evil\nATTACH:x",
+	})
+	ics := BuildICalendar("Test", []DBEntityInterface{event}, nil, time.UTC)
+	for _, line := range unfoldICal(ics) {
+		if strings.HasPrefix(line, "DTSTART:2000") || strings.HasPrefix(line, "UID:evil") || strings.HasPrefix(line, "ATTACH:") {
+			t.Errorf("injected property %q", line)
+		}
+	}
+	if !strings.Contains(ics, "URL:https://example.com/rUID:evilATTACH:x\r\n") {
+		t.Errorf("expected the URL without line breaks in\n%s", ics)
+	}
+}
+
+func TestRecurrenceRRule(t *testing.T) {
+	loc := time.UTC
+	start := time.Date(2024, 1, 26, 17, 0, 0, 0, loc)
+	tests := []struct {
+		values map[string]string
+		want   string
+	}{
+		{map[string]string{"recurrence_type": "0", "daily_every_x": "3", "recurrence_times": "5"}, "FREQ=DAILY;INTERVAL=3;COUNT=5"},
+		{map[string]string{"recurrence_type": "1", "weekly_day_of_the_week": "4"}, "FREQ=WEEKLY;BYDAY=FR"},
+		{map[string]string{"recurrence_type": "2", "monthly_week_number": "5", "monthly_week_day": "4"}, "FREQ=MONTHLY;BYDAY=-1FR"},
+		{map[string]string{"recurrence_type": "2", "recurrence_end_date": "2024-06-30 00:00:00"}, "FREQ=MONTHLY;BYMONTHDAY=26;UNTIL=20240630T170000Z"},
+		{map[string]string{"recurrence_type": "3", "yearly_month_number": "6", "yearly_month_day": "2"}, "FREQ=YEARLY;BYMONTH=6;BYMONTHDAY=2"},
+		// An invalid month is dropped
+		{map[string]string{"recurrence_type": "3", "yearly_month_number": "13", "yearly_month_day": "2"}, "FREQ=YEARLY"},
+	}
+	for _, test := range tests {
+		test.values["recurrence"] = "1"
+		event := newTestEvent("2024-01-26 17:00:00", "2024-01-26 18:00:00", false, test.values)
+		if got := RecurrenceRRule(EventRecurrence(event, loc), start, false); got != test.want {
+			t.Errorf("RRULE for %v: got %q, want %q", test.values, got, test.want)
+		}
+	}
+}
+
+func TestICalVTimezone(t *testing.T) {
+	rome, err := time.LoadLocation("Europe/Rome")
+	if err != nil {
+		t.Skip("timezone database not available")
+	}
+	vtimezone := strings.Join(icalVTimezone(rome, 2023, 2024), "\n")
+	for _, want := range []string{
+		"BEGIN:VTIMEZONE\nTZID:Europe/Rome\n",
+		// Before the first change of 2023
+		"BEGIN:STANDARD\nDTSTART:20230101T010000\nTZOFFSETFROM:+0100\nTZOFFSETTO:+0100\n",
+		"BEGIN:DAYLIGHT\nDTSTART:20230326T020000\nTZOFFSETFROM:+0100\nTZOFFSETTO:+0200\nTZNAME:CEST\nEND:DAYLIGHT",
+		"BEGIN:STANDARD\nDTSTART:20231029T030000\nTZOFFSETFROM:+0200\nTZOFFSETTO:+0100\nTZNAME:CET\nEND:STANDARD",
+		// The changes of the last year repeat
+		"DTSTART:20240331T020000\nTZOFFSETFROM:+0100\nTZOFFSETTO:+0200\nRRULE:FREQ=YEARLY;BYMONTH=3;BYDAY=-1SU\n",
+		"DTSTART:20241027T030000\nTZOFFSETFROM:+0200\nTZOFFSETTO:+0100\nRRULE:FREQ=YEARLY;BYMONTH=10;BYDAY=-1SU\n",
+	} {
+		if !strings.Contains(vtimezone, want) {
+			t.Errorf("missing %q in\n%s", want, vtimezone)
+		}
+	}
+	if newYork, err := time.LoadLocation("America/New_York"); err == nil {
+		vtimezone = strings.Join(icalVTimezone(newYork, 2024, 2024), "\n")
+		for _, want := range []string{"RRULE:FREQ=YEARLY;BYMONTH=3;BYDAY=2SU", "RRULE:FREQ=YEARLY;BYMONTH=11;BYDAY=1SU", "TZOFFSETTO:-0400"} {
+			if !strings.Contains(vtimezone, want) {
+				t.Errorf("missing %q in\n%s", want, vtimezone)
+			}
+		}
+	}
+	// Without daylight saving time
+	if tokyo, err := time.LoadLocation("Asia/Tokyo"); err == nil {
+		if got := strings.Join(icalVTimezone(tokyo, 2024, 2024), "\n"); strings.Count(got, "BEGIN:STANDARD") != 1 || !strings.Contains(got, "TZOFFSETTO:+0900") {
+			t.Errorf("expected a single observance, got\n%s", got)
+		}
+	}
+	if got := icalVTimezone(time.UTC, 2024, 2024); got != nil {
+		t.Errorf("expected no VTIMEZONE for UTC, got %v", got)
+	}
+
+	// Every TZID used is defined
+	event := newTestEvent("2024-07-05 09:00:00", "2024-07-05 10:00:00", false, map[string]string{"name": "Call"})
+	for _, ics := range []string{BuildICalendar("Test", []DBEntityInterface{event}, nil, rome), mustBuildEventICalendar(t, event, rome)} {
+		if !strings.Contains(ics, "DTSTART;TZID=Europe/Rome:20240705T090000\r\n") || !strings.Contains(ics, "BEGIN:VTIMEZONE\r\nTZID:Europe/Rome\r\n") {
+			t.Errorf("expected the VTIMEZONE of Europe/Rome in\n%s", ics)
+		}
+		if events, err := ParseICalendar(ics, rome); err != nil || len(events) != 1 {
+			t.Errorf("ParseICalendar: %v, %d events", err, len(events))
+		}
+	}
+	allDay := newTestEvent("2024-07-05 00:00:00", "2024-07-05 00:00:00", true, map[string]string{"name": "Holiday"})
+	if ics := BuildICalendar("Test", []DBEntityInterface{allDay}, nil, rome); strings.Contains(ics, "VTIMEZONE") {
+		t.Errorf("expected no VTIMEZONE without timed events")
+	}
+}
+
+func mustBuildEventICalendar(t *testing.T, event DBEntityInterface, loc *time.Location) string {
+	t.Helper()
+	ics, err := BuildEventICalendar(event, "", loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ics
+}
+
+func TestICalendarRoundTrip(t *testing.T) {
+	loc := time.UTC
+	event := newTestEvent("2024-01-26 17:00:00", "2024-01-26 18:30:00", false, map[string]string{
+		"name": "Review, final", "description": "Line 1\nLine 2", "url": "https://example.com/r", "category": "Work",
+		"recurrence": "1", "recurrence_type": "2", "monthly_week_number": "5", "monthly_week_day": "4", "recurrence_times": "4",
+		"alarm": "1", "alarm_minute": "2", "alarm_unit": "1", "before_event": "0",
+	})
+	ics := BuildICalendar("Test", []DBEntityInterface{event}, nil, loc)
+	for _, want := range []string{"UID:ev1@rhobee", "DTSTART:20240126T170000Z", "DTEND:20240126T183000Z",
+		"SUMMARY:Review\\, final", "RRULE:FREQ=MONTHLY;BYDAY=-1FR;COUNT=4", "TRIGGER:-PT2H"} {
+		if !strings.Contains(ics, want+"\r\n") {
+			t.Errorf("missing %q in\n%s", want, ics)
+		}
+	}
+
+	parsed, err := ParseICalendar(ics, loc)
+	if err != nil || len(parsed) != 1 {
+		t.Fatalf("ParseICalendar: %v, %d events", err, len(parsed))
+	}
+	imported := NewDBEvent()
+	if warning := parsed[0].ApplyTo(imported, loc); warning != "" {
+		t.Errorf("unexpected warning: %s", warning)
+	}
+	for column, want := range map[string]string{
+		"name": "Review, final", "description": "Line 1\nLine 2", "url": "https://example.com/r", "category": "Work",
+		"start_date": "2024-01-26 17:00:00", "end_date": "2024-01-26 18:30:00", "all_day": "0",
+		"recurrence": "1", "recurrence_type": "2", "monthly_week_number": "5", "monthly_week_day": "4", "recurrence_times": "4",
+		"alarm": "1", "alarm_minute": "2", "alarm_unit": "1", "before_event": "0",
+	} {
+		if got := stringValue(imported, column); got != want {
+			t.Errorf("%s: got %q, want %q", column, got, want)
+		}
+	}
+}
+
+func TestParseICalendarAllDayAndTimeZones(t *testing.T) {
+	rome, err := time.LoadLocation("Europe/Rome")
+	if err != nil {
+		t.Skip("timezone database not available")
+	}
+	ics := "BEGIN:VCALENDAR\r\nVERSION:2.0\r\n" +
+		"BEGIN:VEVENT\r\nUID:a@example.com\r\nSUMMARY:Holidays\r\nDTSTART;VALUE=DATE:20240805\r\nDTEND;VALUE=DATE:20240810\r\nEND:VEVENT\r\n" +
+		"BEGIN:VEVENT\r\nUID:b@example.com\r\nSUMMARY:Call\r\nDTSTART;TZID=America/New_York:20240705T090000\r\nDURATION:PT45M\r\n" +
+		"RRULE:FREQ=WEEKLY;BYDAY=FR;UNTIL=20240830T130000Z\r\n" +
+		"BEGIN:VALARM\r\nTRIGGER:-PT15M\r\nACTION:DISPLAY\r\nEND:VALARM\r\nEND:VEVENT\r\n" +
+		"END:VCALENDAR\r\n"
+	events, err := ParseICalendar(ics, rome)
+	if err != nil || len(events) != 2 {
+		t.Fatalf("ParseICalendar: %v, %v", err, events)
+	}
+
+	holidays := NewDBEvent()
+	events[0].ApplyTo(holidays, rome)
+	if stringValue(holidays, "start_date") != "2024-08-05 00:00:00" || stringValue(holidays, "end_date") != "2024-08-09 00:00:00" || stringValue(holidays, "all_day") != "1" {
+		t.Errorf("all day: got %s - %s", stringValue(holidays, "start_date"), stringValue(holidays, "end_date"))
+	}
+
+	call := NewDBEvent()
+	events[1].ApplyTo(call, rome)
+	for column, want := range map[string]string{
+		"start_date": "2024-07-05 15:00:00", "end_date": "2024-07-05 15:45:00",
+		"recurrence_type": "1", "weekly_day_of_the_week": "4", "recurrence_end_date": "2024-08-30 00:00:00",
+		"alarm": "1", "alarm_minute": "15", "alarm_unit": "0",
+	} {
+		if got := stringValue(call, column); got != want {
+			t.Errorf("%s: got %q, want %q", column, got, want)
+		}
+	}
+
+	if _, err := ParseICalendar("hello", rome); err == nil {
+		t.Error("expected an error for a non iCalendar stream")
+	}
+}
+
+func TestSaveCalDAVEventUID(t *testing.T) {
+	repo := setupTestRepo(t)
+	folder := createTestFolder(t, repo, map[string]any{"name": "CalDAV Folder"}, nil)
+	defer hardDeleteForTests(repo, folder.(DBObjectInterface))
+	other := createTestFolder(t, repo, map[string]any{"name": "CalDAV Other"}, nil)
+	defer hardDeleteForTests(repo, other.(DBObjectInterface))
+	folderID, otherID := stringValue(folder, "id"), stringValue(other, "id")
+
+	uid, _ := uuid16HexGo()
+	ics := func(uid string, summary string) string {
+		return "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nBEGIN:VEVENT\r\nUID:" + uid + "\r\n" +
+			"DTSTART:20240126T170000Z\r\nDTEND:20240126T180000Z\r\nSUMMARY:" + summary + "\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n"
+	}
+	saved, err := repo.SaveCalDAVEvent(folderID, "review.ics", ics(uid, "Review"), nil, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hardDeleteForTests(repo, saved.(DBObjectInterface))
+	second, err := repo.SaveCalDAVEvent(folderID, "standup.ics", ics("standup-"+uid, "Standup"), nil, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hardDeleteForTests(repo, second.(DBObjectInterface))
+
+	// The UID of another event, by mapping or by default
+	var conflict *ICalUIDConflictError
+	if _, err := repo.SaveCalDAVEvent(otherID, "review.ics", ics(uid, "Copy"), nil, time.UTC); !errors.As(err, &conflict) ||
+		conflict.EventID != stringValue(saved, "id") {
+		t.Errorf("another folder: expected a conflict with %s, got %v", saved.GetValue("id"), err)
+	}
+	if _, err := repo.SaveCalDAVEvent(folderID, "standup.ics", ics(uid, "Standup"), second, time.UTC); !errors.As(err, &conflict) {
+		t.Errorf("another event: expected a conflict, got %v", err)
+	}
+	defaultUID := EventICalUID(stringValue(saved, "id"))
+	if _, err := repo.SaveCalDAVEvent(folderID, "standup.ics", ics(defaultUID, "Standup"), second, time.UTC); !errors.As(err, &conflict) {
+		t.Errorf("default UID: expected a conflict, got %v", err)
+	}
+	// The first event keeps its UID and its href
+	if event := repo.FindEventByICalUID(uid); event == nil || stringValue(event, "id") != stringValue(saved, "id") {
+		t.Errorf("expected %s to be mapped to %s, got %v", uid, saved.GetValue("id"), event)
+	}
+	if event := repo.FindEventByHref(folderID, "review.ics"); event == nil || stringValue(event, "id") != stringValue(saved, "id") {
+		t.Errorf("expected review.ics to be %s, got %v", saved.GetValue("id"), event)
+	}
+
+	// Released by the deletion of the event
+	if _, err := repo.Delete(saved); err != nil {
+		t.Fatal(err)
+	}
+	copied, err := repo.SaveCalDAVEvent(otherID, "review.ics", ics(uid, "Copy"), nil, time.UTC)
+	if err != nil {
+		t.Fatalf("expected the UID of a deleted event to be reused, got %v", err)
+	}
+	defer hardDeleteForTests(repo, copied.(DBObjectInterface))
+	if event := repo.FindEventByICalUID(uid); event == nil || stringValue(event, "id") != stringValue(copied, "id") {
+		t.Errorf("expected %s to be mapped to %s, got %v", uid, copied.GetValue("id"), event)
+	}
+}
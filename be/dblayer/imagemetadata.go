@@ -0,0 +1,155 @@
+package dblayer
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"image"
+	"io"
+	"log"
+	"slices"
+	"strconv"
+)
+
+/*
+Metadata of the uploaded images, in searchable columns of the files:
+image_width and image_height (as the image is seen, after its EXIF
+orientation), camera, taken_date (the capture time, local to the camera)
+and gps_latitude/gps_longitude. They are read from each new blob of a file.
+
+The exif_policy of a folder strips metadata from the JPEG uploaded in it
+before they are stored (see ExifPolicies). The blob is rewritten before its
+checksum is computed: the checksum, the size and the dedupe of the file are
+the ones of the stripped content, and the metadata stripped are not in the
+columns either. The files uploaded before the policy are left as they are.
+*/
+
+// Code of the validation error of a JPEG that cannot be stripped, also used as API error code
+const ValidationInvalidImage = "INVALID_IMAGE"
+
+// Code of the validation error of an unknown exif_policy, also used as API error code
+const ValidationInvalidExifPolicy = "INVALID_EXIF_POLICY"
+
+// imageMetadataColumns are the columns of the files set from the image metadata
+var imageMetadataColumns = []string{"image_width", "image_height", "camera", "taken_date", "gps_latitude", "gps_longitude"}
+
+// imageMetadata are the metadata of an image
+type imageMetadata struct {
+	Width  int
+	Height int
+	exifInfo
+}
+
+// readImageMetadata reads the metadata of an image blob: the headers only
+func readImageMetadata(key string) (imageMetadata, error) {
+	var metadata imageMetadata
+	file, err := FileStorage.Get(key)
+	if err != nil {
+		return metadata, err
+	}
+	defer file.Close()
+	var head bytes.Buffer
+	config, _, err := image.DecodeConfig(io.TeeReader(file, &head))
+	if err != nil {
+		return metadata, err
+	}
+	metadata.Width, metadata.Height = config.Width, config.Height
+	metadata.Orientation = 1
+	if !isJpeg(head.Bytes()) {
+		return metadata, nil
+	}
+	if tiff, err := jpegExif(io.MultiReader(&head, file)); err == nil {
+		metadata.exifInfo = parseExif(tiff)
+	}
+	if metadata.Orientation >= 5 {
+		metadata.Width, metadata.Height = metadata.Height, metadata.Width
+	}
+	return metadata, nil
+}
+
+// setImageMetadata sets the metadata columns from a new blob, clearing them
+// for the files that are not images
+func (dbFile *DBFile) setImageMetadata(key string) {
+	for _, column := range imageMetadataColumns {
+		dbFile.SetValue(column, nil)
+	}
+	if !dbFile.IsImage() {
+		return
+	}
+	metadata, err := readImageMetadata(key)
+	if err != nil {
+		log.Printf("DBFile.setImageMetadata: %s: %v", key, err)
+		return
+	}
+	dbFile.SetValue("image_width", metadata.Width)
+	dbFile.SetValue("image_height", metadata.Height)
+	if metadata.Camera != "" {
+		dbFile.SetValue("camera", metadata.Camera)
+	}
+	if !metadata.Taken.IsZero() {
+		dbFile.SetValue("taken_date", metadata.Taken.Format("2006-01-02 15:04:05"))
+	}
+	if metadata.Latitude != nil && metadata.Longitude != nil {
+		dbFile.SetValue("gps_latitude", strconv.FormatFloat(*metadata.Latitude, 'f', 7, 64))
+		dbFile.SetValue("gps_longitude", strconv.FormatFloat(*metadata.Longitude, 'f', 7, 64))
+	}
+}
+
+// validateExifPolicy checks the exif_policy of a folder
+func validateExifPolicy(folder DBEntityInterface) error {
+	if !folder.HasValue("exif_policy") {
+		return nil
+	}
+	if slices.Contains(ExifPolicies, stringValue(folder, "exif_policy")) {
+		return nil
+	}
+	return &ValidationError{Code: ValidationInvalidExifPolicy, Field: "exif_policy", Message: "must be empty, location or private"}
+}
+
+// folderExifPolicy returns the exif_policy of the files of a folder
+func (dbr *DBRepository) folderExifPolicy(fatherID string, tx *sql.Tx) string {
+	if fatherID == "" || fatherID == "0" {
+		return ExifPolicyKeep
+	}
+	folder := dbr.GetEntityByIDWithTx("folders", fatherID, tx)
+	if folder == nil {
+		return ExifPolicyKeep
+	}
+	return stringValue(folder, "exif_policy")
+}
+
+// stripUploadMetadata applies the exif_policy of the folder of a file to an
+// uploaded JPEG, rewriting it in the storage
+func (dbr *DBRepository) stripUploadMetadata(dbFile *DBFile, uploadedKey string, tx *sql.Tx) error {
+	policy := dbr.folderExifPolicy(stringValue(dbFile, "father_id"), tx)
+	if policy == ExifPolicyKeep {
+		return nil
+	}
+	src, err := FileStorage.Get(uploadedKey)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	head := bytes.NewBuffer(make([]byte, 0, 2))
+	if _, err := io.CopyN(head, src, 2); err != nil || !isJpeg(head.Bytes()) {
+		// Not a JPEG: no EXIF
+		return nil
+	}
+
+	strippedKey := uploadedKey + ".stripped"
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(stripJpegMetadata(io.MultiReader(head, src), pw, policy))
+	}()
+	err = FileStorage.Put(strippedKey, pr, -1)
+	pr.Close()
+	if err != nil {
+		FileStorage.Delete(strippedKey)
+		if errors.Is(err, errBadJpeg) {
+			return &ValidationError{Code: ValidationInvalidImage, Field: "filename", Message: "the metadata of the JPEG cannot be stripped"}
+		}
+		return err
+	}
+	log.Printf("DBRepository.stripUploadMetadata: %s stripped with the %s policy", uploadedKey, policy)
+	return FileStorage.Rename(strippedKey, uploadedKey)
+}
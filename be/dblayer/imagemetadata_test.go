@@ -0,0 +1,68 @@
+package dblayer
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDBFileExifPolicy(t *testing.T) {
+	repo := setupTestRepo(t)
+	src := filepath.Join(t.TempDir(), "photo.jpg")
+	if err := os.WriteFile(src, testPhoto(t), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := repo.CreateObject("folders", map[string]any{"name": "Wrong Policy", "exif_policy": "everything"}, nil); err == nil {
+		t.Errorf("expected an invalid exif_policy")
+	}
+
+	// Without a policy the metadata are stored
+	file := createTestFile(t, repo, src, map[string]any{"name": "Photo As Uploaded"}, nil)
+	defer hardDeleteForTests(repo, file)
+	if stringValue(file, "camera") != "Canon EOS 5D" || file.GetValue("gps_latitude") == nil {
+		t.Errorf("expected the camera and the location, got %v", file.GetAllValues())
+	}
+
+	folder := createTestFolder(t, repo, map[string]any{"name": "Field Photos", "exif_policy": ExifPolicyLocation}, nil)
+	defer hardDeleteForTests(repo, folder.(DBObjectInterface))
+	stripped := createTestFile(t, repo, src, map[string]any{"name": "Photo Stripped", "father_id": folder.GetValue("id")}, nil)
+	defer hardDeleteForTests(repo, stripped)
+	if stripped.GetValue("gps_latitude") != nil || stripped.GetValue("gps_longitude") != nil {
+		t.Errorf("expected no location, got %v %v", stripped.GetValue("gps_latitude"), stripped.GetValue("gps_longitude"))
+	}
+	if stringValue(stripped, "taken_date") != "2024-07-14 10:30:00" {
+		t.Errorf("expected the capture time, got %v", stripped.GetValue("taken_date"))
+	}
+
+	// The checksum and the size are the ones of the stored blob
+	checksum, err := blobSHA1(stripped.GetBlobKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, _ := FileStorage.Stat(stripped.GetBlobKey())
+	if stringValue(stripped, "checksum") != checksum || stripped.Size() != info.Size {
+		t.Errorf("expected checksum %s and size %d, got %v %d", checksum, info.Size, stripped.GetValue("checksum"), stripped.Size())
+	}
+	if stringValue(stripped, "checksum") == stringValue(file, "checksum") {
+		t.Errorf("expected the stripped blob to differ")
+	}
+	// Searchable by capture date
+	search := NewDBFile()
+	search.SetValue("name", "Photo Stripped")
+	search.SetMetadata("from", map[string]string{"taken_date": "2024-07-14"})
+	search.SetMetadata("to", map[string]string{"taken_date": "2024-07-14"})
+	if results, err := repo.Search(search, true, false, ""); err != nil || len(results) != 1 {
+		t.Errorf("expected the photo of 2024-07-14, got %d %v", len(results), err)
+	}
+	search.SetMetadata("from", map[string]string{"taken_date": "2024-07-15"})
+	if results, err := repo.Search(search, true, false, ""); err != nil || len(results) != 0 {
+		t.Errorf("expected no photo after 2024-07-14, got %d %v", len(results), err)
+	}
+
+	blob, _ := FileStorage.Get(stripped.GetBlobKey())
+	defer blob.Close()
+	if tiff, err := jpegExif(blob); err != nil || parseExif(tiff).Latitude != nil {
+		t.Errorf("expected the stored blob without location, got %v", err)
+	}
+}
@@ -0,0 +1,143 @@
+package dblayer
+
+import (
+	"testing"
+)
+
+func TestDBTimetrackInterval(t *testing.T) {
+	for _, c := range []struct {
+		start, end string
+		valid      bool
+	}{
+		{"2024-03-01 09:00:00", "2024-03-01 11:30:00", true},
+		{"2024-03-01 09:00:00", "2024-03-01 09:00:00", true},
+		// A running timer
+		{"2024-03-01 09:00:00", "", true},
+		{"", "", true},
+		{"2024-03-01 11:30:00", "2024-03-01 09:00:00", false},
+		{"2024-03-01 09:00:00", "tomorrow", false},
+		{"yesterday", "2024-03-01 09:00:00", false},
+	} {
+		timetrack := NewDBTimetrack()
+		if c.start != "" {
+			timetrack.SetValue("dalle_ore", c.start)
+		}
+		if c.end != "" {
+			timetrack.SetValue("alle_ore", c.end)
+		}
+		if err := timetrack.checkInterval(); (err == nil) != c.valid {
+			t.Errorf("%q - %q: expected valid %v, got %v", c.start, c.end, c.valid, err)
+		}
+	}
+}
+
+func TestDBTimetrackIntervalHooks(t *testing.T) {
+	repo := setupTestRepo(t)
+	if _, err := repo.CreateObject("timetracks", map[string]any{
+		"name":      "Backwards",
+		"dalle_ore": "2024-03-01 11:30:00",
+		"alle_ore":  "2024-03-01 09:00:00",
+	}, nil); err == nil {
+		t.Errorf("expected an interval ending before its start to be refused")
+	}
+
+	timetrack := createTestObject(t, repo, "timetracks", map[string]any{
+		"name":      "Morning",
+		"dalle_ore": "2024-03-01 09:00:00",
+		"alle_ore":  "2024-03-01 11:30:00",
+	}, nil)
+	defer hardDeleteForTests(repo, timetrack.(DBObjectInterface))
+	if _, err := repo.UpdateObject("timetracks", timetrack.GetValue("id").(string), map[string]any{
+		"dalle_ore": "2024-03-01 12:00:00",
+		"alle_ore":  "2024-03-01 11:30:00",
+	}, nil); err == nil {
+		t.Errorf("expected the update to be refused")
+	}
+}
+
+func TestProjectMembers(t *testing.T) {
+	repo := setupTestRepo(t)
+	project := createTestObject(t, repo, "projects", map[string]any{"name": "Members Project"}, nil)
+	defer hardDeleteForTests(repo, project.(DBObjectInterface))
+	subproject := createTestObject(t, repo, "projects", map[string]any{"name": "Members Subproject"}, nil)
+	defer hardDeleteForTests(repo, subproject.(DBObjectInterface))
+	person := createTestObject(t, repo, "people", map[string]any{"name": "Ada Member"}, nil)
+	defer hardDeleteForTests(repo, person.(DBObjectInterface))
+	company := createTestObject(t, repo, "companies", map[string]any{"name": "Member Company"}, nil)
+	defer hardDeleteForTests(repo, company.(DBObjectInterface))
+	manager := createTestObject(t, repo, "projects_people_roles", map[string]any{"name": "Manager"}, nil)
+	defer hardDeleteForTests(repo, manager.(DBObjectInterface))
+	developer := createTestObject(t, repo, "projects_people_roles", map[string]any{"name": "Developer"}, nil)
+	defer hardDeleteForTests(repo, developer.(DBObjectInterface))
+
+	projectID, personID := project.GetValue("id").(string), person.GetValue("id").(string)
+	managerID, developerID := manager.GetValue("id").(string), developer.GetValue("id").(string)
+	defer repo.RemoveProjectMember(projectID, "people", personID, "")
+	defer repo.RemoveProjectMember(projectID, "companies", company.GetValue("id").(string), "")
+	defer repo.RemoveProjectMember(projectID, "projects", subproject.GetValue("id").(string), "")
+
+	if _, err := repo.AddProjectMember(projectID, "people", personID, managerID); err != nil {
+		t.Fatal(err)
+	}
+	// Adding it again is not an error, a second role is another association
+	if _, err := repo.AddProjectMember(projectID, "people", personID, managerID); err != nil {
+		t.Errorf("expected an existing association to be accepted, got %v", err)
+	}
+	if _, err := repo.AddProjectMember(projectID, "people", personID, developerID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.AddProjectMember(projectID, "companies", company.GetValue("id").(string), ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.AddProjectMember(projectID, "projects", subproject.GetValue("id").(string), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	// Refused
+	if _, err := repo.AddProjectMember(projectID, "projects", projectID, ""); err == nil {
+		t.Errorf("expected a project not to be a member of itself")
+	}
+	if _, err := repo.AddProjectMember(projectID, "people", "missing", ""); err == nil {
+		t.Errorf("expected a missing person")
+	}
+	if _, err := repo.AddProjectMember(projectID, "people", personID, "missing"); err == nil {
+		t.Errorf("expected a missing role")
+	}
+	if _, err := repo.AddProjectMember(projectID, "animals", personID, ""); err == nil {
+		t.Errorf("expected an invalid kind")
+	}
+
+	members, err := repo.GetProjectMembers(projectID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 4 {
+		t.Fatalf("expected 4 members, got %+v", members)
+	}
+	roles := map[string]bool{}
+	for _, member := range members {
+		if member.Kind == "people" {
+			if member.MemberName != "Ada Member" {
+				t.Errorf("expected Ada Member, got %+v", member)
+			}
+			roles[member.RoleName] = true
+		}
+	}
+	if !roles["Manager"] || !roles["Developer"] {
+		t.Errorf("expected the roles Manager and Developer, got %v", roles)
+	}
+	if people, err := repo.GetProjectMembers(projectID, "people"); err != nil || len(people) != 2 {
+		t.Errorf("expected 2 people, got %d %v", len(people), err)
+	}
+
+	// A single role, then all of them
+	if removed, err := repo.RemoveProjectMember(projectID, "people", personID, developerID); err != nil || removed != 1 {
+		t.Errorf("expected 1 association removed, got %d %v", removed, err)
+	}
+	if removed, err := repo.RemoveProjectMember(projectID, "people", personID, ""); err != nil || removed != 1 {
+		t.Errorf("expected 1 association removed, got %d %v", removed, err)
+	}
+	if people, _ := repo.GetProjectMembers(projectID, "people"); len(people) != 0 {
+		t.Errorf("expected no people, got %+v", people)
+	}
+}
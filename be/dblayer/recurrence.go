@@ -0,0 +1,283 @@
+package dblayer
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Recurrence types of DBEvent.recurrence_type
+const (
+	RecurrenceDaily   = 0
+	RecurrenceWeekly  = 1
+	RecurrenceMonthly = 2
+	RecurrenceYearly  = 3
+)
+
+const (
+	// maxRecurrencePeriods stops the expansion of rules that never match
+	maxRecurrencePeriods = 100000
+	// MaxEventOccurrences is the maximum number of occurrences returned for an event
+	MaxEventOccurrences = 5000
+)
+
+// Recurrence is the recurrence rule of a DBEvent
+type Recurrence struct {
+	Type  int
+	Every int // every x days, weeks or months
+	// Weekly
+	WeekDay time.Weekday
+	// Monthly: a day of the month (negative from the end of the month)
+	// or the n-th weekday of the month (5 = the last one)
+	MonthDay     int
+	MonthWeek    int
+	MonthWeekDay time.Weekday
+	// Yearly: a day of a month, the n-th weekday of a month or a day of the year
+	YearMonth    int
+	YearMonthDay int
+	YearWeek     int
+	YearWeekDay  time.Weekday
+	YearDay      int
+	// End conditions: number of occurrences (0 = always) and last day (zero = never)
+	Times int
+	Until time.Time
+}
+
+// EventOccurrence is an instance of an event.
+// All day occurrences have dates ("2006-01-02", end inclusive), the others RFC3339 datetimes.
+type EventOccurrence struct {
+	EventID     string `json:"event_id"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Category    string `json:"category,omitempty"`
+	URL         string `json:"url,omitempty"`
+	FatherID    string `json:"father_id,omitempty"`
+	Start       string `json:"start"`
+	End         string `json:"end"`
+	AllDay      bool   `json:"all_day"`
+	Recurring   bool   `json:"recurring"`
+	Index       int    `json:"index"` // 0 = first occurrence of the event
+
+	start time.Time
+}
+
+func intValue(dbe DBEntityInterface, column string) int {
+	n, _ := strconv.Atoi(stringValue(dbe, column))
+	return n
+}
+
+// legacyWeekday converts the weekdays of the events table (0=monday ... 6=sunday)
+func legacyWeekday(dbe DBEntityInterface, column string) time.Weekday {
+	return time.Weekday((intValue(dbe, column) + 1) % 7)
+}
+
+// dateOf returns the midnight of the day of t in loc
+func dateOf(t time.Time, loc *time.Location) time.Time {
+	t = t.In(loc)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+}
+
+func daysIn(year int, month time.Month, loc *time.Location) int {
+	return time.Date(year, month+1, 0, 0, 0, 0, 0, loc).Day()
+}
+
+// nthWeekday returns the n-th weekday of a month; n >= 5 (or negative) is the last one
+func nthWeekday(year int, month time.Month, n int, weekday time.Weekday, loc *time.Location) (time.Time, bool) {
+	if n == 0 {
+		return time.Time{}, false
+	}
+	if n >= 5 || n < 0 {
+		last := time.Date(year, month, daysIn(year, month, loc), 0, 0, 0, 0, loc)
+		offset := (int(last.Weekday()) - int(weekday) + 7) % 7
+		return last.AddDate(0, 0, -offset), true
+	}
+	first := time.Date(year, month, 1, 0, 0, 0, 0, loc)
+	offset := (int(weekday) - int(first.Weekday()) + 7) % 7
+	return first.AddDate(0, 0, offset+(n-1)*7), true
+}
+
+// dayOfMonth returns the given day of a month, negative days counting from the end
+func dayOfMonth(year int, month time.Month, day int, loc *time.Location) (time.Time, bool) {
+	days := daysIn(year, month, loc)
+	if day < 0 {
+		day = days + day + 1
+	}
+	if day < 1 || day > days {
+		return time.Time{}, false
+	}
+	return time.Date(year, month, day, 0, 0, 0, 0, loc), true
+}
+
+// EventRecurrence reads the recurrence rule of an event, nil when not recurring.
+// recurrence_end_date is read in loc.
+func EventRecurrence(dbe DBEntityInterface, loc *time.Location) *Recurrence {
+	if stringValue(dbe, "recurrence") != "1" {
+		return nil
+	}
+	rec := &Recurrence{
+		Type:         intValue(dbe, "recurrence_type"),
+		WeekDay:      legacyWeekday(dbe, "weekly_day_of_the_week"),
+		MonthDay:     intValue(dbe, "monthly_day_of_the_month"),
+		MonthWeek:    intValue(dbe, "monthly_week_number"),
+		MonthWeekDay: legacyWeekday(dbe, "monthly_week_day"),
+		YearMonth:    intValue(dbe, "yearly_month_number"),
+		YearMonthDay: intValue(dbe, "yearly_month_day"),
+		YearWeek:     intValue(dbe, "yearly_week_number"),
+		YearWeekDay:  legacyWeekday(dbe, "yearly_week_day"),
+		YearDay:      intValue(dbe, "yearly_day_of_the_year"),
+		Times:        intValue(dbe, "recurrence_times"),
+	}
+	switch rec.Type {
+	case RecurrenceDaily:
+		rec.Every = intValue(dbe, "daily_every_x")
+	case RecurrenceWeekly:
+		rec.Every = intValue(dbe, "weekly_every_x")
+	case RecurrenceMonthly:
+		rec.Every = intValue(dbe, "monthly_every_x")
+	}
+	if rec.Every < 1 {
+		rec.Every = 1
+	}
+	// The PHP application stores '0000-00-00 00:00:00' for "never": it does not parse
+	if until, err := ParseDateTimeIn(stringValue(dbe, "recurrence_end_date"), loc); err == nil && until.Year() > 1 {
+		rec.Until = dateOf(until, loc)
+	}
+	return rec
+}
+
+// candidate returns the beginning of the k-th period of the rule and the
+// day of the occurrence in that period, if any
+func (rec *Recurrence) candidate(k int, first time.Time, loc *time.Location) (time.Time, time.Time, bool) {
+	switch rec.Type {
+	case RecurrenceDaily:
+		day := first.AddDate(0, 0, k*rec.Every)
+		return day, day, true
+	case RecurrenceWeekly:
+		monday := first.AddDate(0, 0, -((int(first.Weekday()) + 6) % 7))
+		week := monday.AddDate(0, 0, 7*k*rec.Every)
+		return week, week.AddDate(0, 0, (int(rec.WeekDay)+6)%7), true
+	case RecurrenceMonthly:
+		month := time.Date(first.Year(), first.Month()+time.Month(k*rec.Every), 1, 0, 0, 0, 0, loc)
+		var day time.Time
+		var ok bool
+		switch {
+		case rec.MonthDay != 0:
+			day, ok = dayOfMonth(month.Year(), month.Month(), rec.MonthDay, loc)
+		case rec.MonthWeek != 0:
+			day, ok = nthWeekday(month.Year(), month.Month(), rec.MonthWeek, rec.MonthWeekDay, loc)
+		default:
+			day, ok = dayOfMonth(month.Year(), month.Month(), first.Day(), loc)
+		}
+		return month, day, ok
+	case RecurrenceYearly:
+		year := time.Date(first.Year()+k, time.January, 1, 0, 0, 0, 0, loc)
+		var day time.Time
+		var ok bool
+		switch {
+		case rec.YearMonth > 0 && rec.YearMonth <= 12 && rec.YearMonthDay != 0:
+			day, ok = dayOfMonth(year.Year(), time.Month(rec.YearMonth), rec.YearMonthDay, loc)
+		case rec.YearMonth > 0 && rec.YearMonth <= 12 && rec.YearWeek != 0:
+			day, ok = nthWeekday(year.Year(), time.Month(rec.YearMonth), rec.YearWeek, rec.YearWeekDay, loc)
+		case rec.YearDay > 0:
+			day = year.AddDate(0, 0, rec.YearDay-1)
+			ok = day.Year() == year.Year()
+		default:
+			// Anniversary: February 29th only in leap years
+			day, ok = dayOfMonth(year.Year(), first.Month(), first.Day(), loc)
+		}
+		return year, day, ok
+	}
+	return time.Time{}, time.Time{}, false
+}
+
+// ExpandEvent returns the occurrences of an event overlapping [from, to).
+// Datetimes without an explicit offset are wall clock times in loc, so that
+// a recurring meeting at 9:00 stays at 9:00 across daylight saving changes.
+func ExpandEvent(dbe DBEntityInterface, from time.Time, to time.Time, loc *time.Location) ([]EventOccurrence, error) {
+	start, err := ParseDateTimeIn(stringValue(dbe, "start_date"), loc)
+	if err != nil {
+		return nil, fmt.Errorf("event %s: invalid start_date: %v", stringValue(dbe, "id"), err)
+	}
+	start = start.In(loc)
+	end, err := ParseDateTimeIn(stringValue(dbe, "end_date"), loc)
+	if err != nil || end.Before(start) {
+		end = start
+	}
+	end = end.In(loc)
+	allDay := stringValue(dbe, "all_day") == "1"
+	rec := EventRecurrence(dbe, loc)
+
+	template := EventOccurrence{
+		EventID:     stringValue(dbe, "id"),
+		Name:        stringValue(dbe, "name"),
+		Description: stringValue(dbe, "description"),
+		Category:    stringValue(dbe, "category"),
+		URL:         stringValue(dbe, "url"),
+		FatherID:    stringValue(dbe, "father_id"),
+		AllDay:      allDay,
+		Recurring:   rec != nil,
+	}
+	firstDay := dateOf(start, loc)
+	// Length of the event: in days for all day events, otherwise a duration
+	days := int(dateOf(end, loc).Sub(firstDay).Hours()/24 + 0.5)
+	duration := end.Sub(start)
+
+	// occurrence builds the occurrence on a day and tells if it is in range
+	occurrence := func(day time.Time, index int) (EventOccurrence, bool) {
+		occ := template
+		occ.Index = index
+		var occStart, occEnd time.Time
+		if allDay {
+			occStart = day
+			occEnd = day.AddDate(0, 0, days+1)
+			occ.Start = occStart.Format(time.DateOnly)
+			occ.End = day.AddDate(0, 0, days).Format(time.DateOnly)
+		} else {
+			occStart = time.Date(day.Year(), day.Month(), day.Day(), start.Hour(), start.Minute(), start.Second(), 0, loc)
+			occEnd = occStart.Add(duration)
+			occ.Start = occStart.Format(time.RFC3339)
+			occ.End = occEnd.Format(time.RFC3339)
+		}
+		occ.start = occStart
+		inRange := occStart.Before(to) && (occEnd.After(from) || (occEnd.Equal(occStart) && !occStart.Before(from)))
+		return occ, inRange
+	}
+
+	if rec == nil {
+		occ, inRange := occurrence(firstDay, 0)
+		if !inRange {
+			return []EventOccurrence{}, nil
+		}
+		return []EventOccurrence{occ}, nil
+	}
+
+	occurrences := []EventOccurrence{}
+	count := 0
+	for k := 0; k < maxRecurrencePeriods; k++ {
+		periodStart, day, ok := rec.candidate(k, firstDay, loc)
+		if !periodStart.Before(to) || (!rec.Until.IsZero() && periodStart.After(rec.Until)) {
+			break
+		}
+		if !ok || day.Before(firstDay) {
+			continue
+		}
+		if !rec.Until.IsZero() && day.After(rec.Until) {
+			break
+		}
+		count++
+		if rec.Times > 0 && count > rec.Times {
+			break
+		}
+		occ, inRange := occurrence(day, count-1)
+		if !occ.start.Before(to) {
+			break
+		}
+		if inRange {
+			occurrences = append(occurrences, occ)
+			if len(occurrences) >= MaxEventOccurrences {
+				break
+			}
+		}
+	}
+	return occurrences, nil
+}
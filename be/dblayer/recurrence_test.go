@@ -0,0 +1,129 @@
+package dblayer
+
+import (
+	"testing"
+	"time"
+)
+
+func newTestEvent(start, end string, allDay bool, values map[string]string) DBEntityInterface {
+	event := NewDBEvent()
+	event.SetValue("id", "ev1")
+	event.SetValue("name", "Event")
+	event.SetValue("start_date", start)
+	event.SetValue("end_date", end)
+	if allDay {
+		event.SetValue("all_day", "1")
+	} else {
+		event.SetValue("all_day", "0")
+	}
+	event.SetValue("recurrence_end_date", "0000-00-00 00:00:00")
+	for k, v := range values {
+		event.SetValue(k, v)
+	}
+	return event
+}
+
+func occurrenceStarts(t *testing.T, event DBEntityInterface, from, to string, loc *time.Location) []string {
+	t.Helper()
+	f, _ := ParseDateTimeIn(from, loc)
+	e, _ := ParseDateTimeIn(to, loc)
+	occurrences, err := ExpandEvent(event, f, e, loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	starts := []string{}
+	for _, occ := range occurrences {
+		starts = append(starts, occ.Start)
+	}
+	return starts
+}
+
+func assertStarts(t *testing.T, name string, got []string, want ...string) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Errorf("%s: got %v, want %v", name, got, want)
+		return
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("%s: got %v, want %v", name, got, want)
+			return
+		}
+	}
+}
+
+func TestExpandEventRecurrences(t *testing.T) {
+	loc := time.UTC
+
+	single := newTestEvent("2024-05-10 10:00:00", "2024-05-10 11:00:00", false, nil)
+	assertStarts(t, "single", occurrenceStarts(t, single, "2024-05-01", "2024-06-01", loc), "2024-05-10T10:00:00Z")
+	assertStarts(t, "single out of range", occurrenceStarts(t, single, "2024-06-01", "2024-07-01", loc))
+
+	daily := newTestEvent("2024-05-01 08:00:00", "2024-05-01 09:00:00", false, map[string]string{
+		"recurrence": "1", "recurrence_type": "0", "daily_every_x": "2", "recurrence_times": "3",
+	})
+	assertStarts(t, "daily every 2 days, 3 times", occurrenceStarts(t, daily, "2024-05-02", "2024-06-01", loc),
+		"2024-05-03T08:00:00Z", "2024-05-05T08:00:00Z")
+
+	// Every other week on wednesday (legacy 2), until the 2024-05-29 included
+	weekly := newTestEvent("2024-05-01 18:00:00", "2024-05-01 19:00:00", false, map[string]string{
+		"recurrence": "1", "recurrence_type": "1", "weekly_every_x": "2", "weekly_day_of_the_week": "2",
+		"recurrence_end_date": "2024-05-29 00:00:00",
+	})
+	assertStarts(t, "weekly", occurrenceStarts(t, weekly, "2024-04-01", "2024-07-01", loc),
+		"2024-05-01T18:00:00Z", "2024-05-15T18:00:00Z", "2024-05-29T18:00:00Z")
+
+	lastDay := newTestEvent("2024-01-31", "2024-01-31", true, map[string]string{
+		"recurrence": "1", "recurrence_type": "2", "monthly_day_of_the_month": "-1",
+	})
+	assertStarts(t, "monthly last day", occurrenceStarts(t, lastDay, "2024-01-01", "2024-04-01", loc),
+		"2024-01-31", "2024-02-29", "2024-03-31")
+
+	// Plain monthly on the 31st skips the shorter months
+	the31st := newTestEvent("2024-01-31", "2024-01-31", true, map[string]string{"recurrence": "1", "recurrence_type": "2"})
+	assertStarts(t, "monthly 31st", occurrenceStarts(t, the31st, "2024-01-01", "2024-06-01", loc),
+		"2024-01-31", "2024-03-31", "2024-05-31")
+
+	// Last friday (legacy 4) of the month
+	lastFriday := newTestEvent("2024-01-01 17:00:00", "2024-01-01 18:00:00", false, map[string]string{
+		"recurrence": "1", "recurrence_type": "2", "monthly_week_number": "5", "monthly_week_day": "4",
+	})
+	assertStarts(t, "monthly last friday", occurrenceStarts(t, lastFriday, "2024-01-01", "2024-03-01", loc),
+		"2024-01-26T17:00:00Z", "2024-02-23T17:00:00Z")
+
+	// First monday (legacy 0) of june
+	yearly := newTestEvent("2023-06-05", "2023-06-05", true, map[string]string{
+		"recurrence": "1", "recurrence_type": "3", "yearly_month_number": "6", "yearly_week_number": "1", "yearly_week_day": "0",
+	})
+	assertStarts(t, "yearly first monday of june", occurrenceStarts(t, yearly, "2023-01-01", "2026-01-01", loc),
+		"2023-06-05", "2024-06-03", "2025-06-02")
+
+	leap := newTestEvent("2024-02-29", "2024-02-29", true, map[string]string{"recurrence": "1", "recurrence_type": "3"})
+	assertStarts(t, "yearly anniversary", occurrenceStarts(t, leap, "2024-01-01", "2029-01-01", loc), "2024-02-29", "2028-02-29")
+
+	dayOfYear := newTestEvent("2024-01-01", "2024-01-01", true, map[string]string{
+		"recurrence": "1", "recurrence_type": "3", "yearly_day_of_the_year": "256",
+	})
+	assertStarts(t, "yearly day of the year", occurrenceStarts(t, dayOfYear, "2024-01-01", "2026-01-01", loc), "2024-09-12", "2025-09-13")
+}
+
+func TestExpandEventAllDayAndTimeZones(t *testing.T) {
+	rome, err := time.LoadLocation("Europe/Rome")
+	if err != nil {
+		t.Skip("timezone database not available")
+	}
+
+	// A 3 days all day event overlapping the beginning of the range
+	trip := newTestEvent("2024-04-29 00:00:00", "2024-05-01 00:00:00", true, nil)
+	occurrences, _ := ExpandEvent(trip, time.Date(2024, 5, 1, 0, 0, 0, 0, rome), time.Date(2024, 6, 1, 0, 0, 0, 0, rome), rome)
+	if len(occurrences) != 1 || occurrences[0].Start != "2024-04-29" || occurrences[0].End != "2024-05-01" {
+		t.Errorf("all day: got %+v", occurrences)
+	}
+
+	// The wall clock time is kept across the daylight saving change of 2024-03-31
+	standup := newTestEvent("2024-03-29 09:00:00", "2024-03-29 09:15:00", false, map[string]string{
+		"recurrence": "1", "recurrence_type": "0",
+	})
+	assertStarts(t, "daily across DST", occurrenceStarts(t, standup, "2024-03-30", "2024-04-02", rome),
+		"2024-03-30T09:00:00+01:00", "2024-03-31T09:00:00+02:00", "2024-04-01T09:00:00+02:00")
+}
@@ -0,0 +1,69 @@
+package dblayer
+
+import (
+	"time"
+)
+
+// EventAlarm is the alarm of an occurrence of an event
+type EventAlarm struct {
+	EventID         string
+	Name            string
+	Description     string
+	Owner           string
+	AllDay          bool
+	OccurrenceStart time.Time
+	AlarmTime       time.Time
+}
+
+// EventAlarmOffset returns the time of the alarm relative to the start of
+// the event, false when the event has no alarm.
+// alarm_unit: 0 minutes, 1 hours, 2 days; before_event: 0 before the start, 1 after.
+func EventAlarmOffset(dbe DBEntityInterface) (time.Duration, bool) {
+	if stringValue(dbe, "alarm") != "1" {
+		return 0, false
+	}
+	amount := time.Duration(intValue(dbe, "alarm_minute"))
+	var offset time.Duration
+	switch stringValue(dbe, "alarm_unit") {
+	case "1":
+		offset = amount * time.Hour
+	case "2":
+		offset = amount * 24 * time.Hour
+	default:
+		offset = amount * time.Minute
+	}
+	if stringValue(dbe, "before_event") == "1" {
+		return offset, true
+	}
+	return -offset, true
+}
+
+// EventAlarms returns the alarms of the occurrences of an event firing in (since, until]
+func EventAlarms(dbe DBEntityInterface, since time.Time, until time.Time, loc *time.Location) ([]EventAlarm, error) {
+	offset, ok := EventAlarmOffset(dbe)
+	if !ok {
+		return []EventAlarm{}, nil
+	}
+	// The occurrences starting in (since-offset, until-offset]
+	occurrences, err := ExpandEvent(dbe, since.Add(-offset), until.Add(-offset+time.Nanosecond), loc)
+	if err != nil {
+		return nil, err
+	}
+	alarms := []EventAlarm{}
+	for _, occ := range occurrences {
+		alarmTime := occ.start.Add(offset)
+		if !alarmTime.After(since) || alarmTime.After(until) {
+			continue
+		}
+		alarms = append(alarms, EventAlarm{
+			EventID:         occ.EventID,
+			Name:            occ.Name,
+			Description:     occ.Description,
+			Owner:           stringValue(dbe, "owner"),
+			AllDay:          occ.AllDay,
+			OccurrenceStart: occ.start,
+			AlarmTime:       alarmTime,
+		})
+	}
+	return alarms, nil
+}
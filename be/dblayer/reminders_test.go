@@ -0,0 +1,87 @@
+package dblayer
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEventAlarmOffset(t *testing.T) {
+	cases := []struct {
+		values map[string]string
+		offset time.Duration
+		ok     bool
+	}{
+		{map[string]string{"alarm": "0", "alarm_minute": "15"}, 0, false},
+		{map[string]string{"alarm": "1", "alarm_minute": "15", "alarm_unit": "0", "before_event": "0"}, -15 * time.Minute, true},
+		{map[string]string{"alarm": "1", "alarm_minute": "2", "alarm_unit": "1", "before_event": "0"}, -2 * time.Hour, true},
+		{map[string]string{"alarm": "1", "alarm_minute": "1", "alarm_unit": "2", "before_event": "0"}, -24 * time.Hour, true},
+		{map[string]string{"alarm": "1", "alarm_minute": "10", "alarm_unit": "0", "before_event": "1"}, 10 * time.Minute, true},
+	}
+	for _, c := range cases {
+		event := newTestEvent("2024-03-01 09:00:00", "2024-03-01 10:00:00", false, c.values)
+		offset, ok := EventAlarmOffset(event)
+		if offset != c.offset || ok != c.ok {
+			t.Errorf("%v: got %v %v, want %v %v", c.values, offset, ok, c.offset, c.ok)
+		}
+	}
+}
+
+func TestEventAlarms(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/Rome")
+	if err != nil {
+		t.Skip("Europe/Rome not available")
+	}
+	at := func(value string) time.Time {
+		parsed, _ := ParseDateTimeIn(value, loc)
+		return parsed
+	}
+
+	// Weekly on fridays at 9:00, 15 minutes before
+	event := newTestEvent("2024-03-01 09:00:00", "2024-03-01 10:00:00", false, map[string]string{
+		"recurrence": "1", "recurrence_type": "1", "weekly_every_x": "1", "weekly_day_of_the_week": "4",
+		"alarm": "1", "alarm_minute": "15", "alarm_unit": "0", "before_event": "0",
+	})
+	alarms, err := EventAlarms(event, at("2024-03-29 08:00:00"), at("2024-03-29 09:00:00"), loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Across the DST change of March 31st the alarm stays at 8:45 local time
+	if len(alarms) != 1 || !alarms[0].AlarmTime.Equal(at("2024-03-29 08:45:00")) || !alarms[0].OccurrenceStart.Equal(at("2024-03-29 09:00:00")) {
+		t.Errorf("got %+v", alarms)
+	}
+	alarms, _ = EventAlarms(event, at("2024-04-05 08:45:00"), at("2024-04-05 09:30:00"), loc)
+	if len(alarms) != 0 {
+		t.Errorf("the window excludes its start: got %+v", alarms)
+	}
+	alarms, _ = EventAlarms(event, at("2024-04-05 08:44:00"), at("2024-04-05 08:45:00"), loc)
+	if len(alarms) != 1 || alarms[0].AlarmTime.Hour() != 8 || alarms[0].AlarmTime.Minute() != 45 {
+		t.Errorf("the window includes its end: got %+v", alarms)
+	}
+	alarms, _ = EventAlarms(event, at("2024-03-01 00:00:00"), at("2024-03-31 00:00:00"), loc)
+	if len(alarms) != 5 {
+		t.Errorf("march: got %d alarms", len(alarms))
+	}
+
+	// The day before an all day event, i.e. at midnight of the day before
+	allDay := newTestEvent("2024-05-10 00:00:00", "2024-05-10 00:00:00", true, map[string]string{
+		"alarm": "1", "alarm_minute": "1", "alarm_unit": "2", "before_event": "0",
+	})
+	alarms, _ = EventAlarms(allDay, at("2024-05-08 23:00:00"), at("2024-05-09 01:00:00"), loc)
+	if len(alarms) != 1 || !alarms[0].AlarmTime.Equal(at("2024-05-09 00:00:00")) || !alarms[0].AllDay {
+		t.Errorf("all day: got %+v", alarms)
+	}
+
+	// After the start of the event
+	after := newTestEvent("2024-05-10 09:00:00", "2024-05-10 11:00:00", false, map[string]string{
+		"alarm": "1", "alarm_minute": "30", "alarm_unit": "0", "before_event": "1",
+	})
+	alarms, _ = EventAlarms(after, at("2024-05-10 09:00:00"), at("2024-05-10 10:00:00"), loc)
+	if len(alarms) != 1 || !alarms[0].AlarmTime.Equal(at("2024-05-10 09:30:00")) {
+		t.Errorf("after the start: got %+v", alarms)
+	}
+
+	noAlarm := newTestEvent("2024-05-10 09:00:00", "2024-05-10 11:00:00", false, nil)
+	if alarms, _ := EventAlarms(noAlarm, at("2024-05-01 00:00:00"), at("2024-06-01 00:00:00"), loc); len(alarms) != 0 {
+		t.Errorf("without alarm: got %+v", alarms)
+	}
+}
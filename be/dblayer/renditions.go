@@ -0,0 +1,396 @@
+package dblayer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/fs"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/HugoSmits86/nativewebp"
+	"github.com/nfnt/resize"
+	_ "golang.org/x/image/webp"
+)
+
+/*
+Renditions of the images: resized and converted copies served by the
+download endpoint (?w=&h=&fit=&format=).
+
+A rendition is generated from the blob, with the EXIF orientation applied,
+and cached on the local disk keyed by the checksum of the blob and the
+parameters: the files with the same content share it, and it never goes
+stale because a new content has a new checksum. The cache is trimmed,
+least recently used first, when it grows beyond its size.
+
+The limits bound the work a request may ask for: the size of a rendition,
+the pixels of the source image (a small file may decode to a huge image)
+and the renditions generated at the same time. The images are never
+enlarged.
+*/
+
+// Fits of a rendition in its box
+const (
+	// The whole image within the box
+	RenditionFitContain = "contain"
+	// The box filled, the excess cropped around the center
+	RenditionFitCover = "cover"
+)
+
+// Formats of the renditions
+const (
+	RenditionFormatJPEG = "jpeg"
+	RenditionFormatPNG  = "png"
+	RenditionFormatWebP = "webp"
+)
+
+// RenditionMaxDimension is the largest width and height of a rendition
+var RenditionMaxDimension = 2048
+
+// RenditionMaxPixels is the largest source image, in pixels
+var RenditionMaxPixels = 50_000_000
+
+// RenditionWait is the time a request waits for a free slot
+var RenditionWait = 30 * time.Second
+
+// renditionSlots bounds the renditions generated at the same time
+var renditionSlots = make(chan struct{}, 4)
+
+// ErrRenditionUnsupported is returned for files that are not images, or not decodable
+var ErrRenditionUnsupported = errors.New("not a supported image")
+
+// ErrRenditionTooLarge is returned for source images beyond RenditionMaxPixels
+var ErrRenditionTooLarge = errors.New("image too large for a rendition")
+
+// ErrRenditionBusy is returned when no slot frees up within RenditionWait
+var ErrRenditionBusy = errors.New("too many renditions in progress")
+
+// Rendition describes a rendition of an image: a box of Width x Height
+// pixels, 0 for a side following the aspect ratio
+type Rendition struct {
+	Width  int
+	Height int
+	// RenditionFitContain or RenditionFitCover, contain when empty
+	Fit string
+	// RenditionFormatJPEG, PNG or WebP, from the source image when empty
+	Format string
+}
+
+// Mime returns the content type of the rendition
+func (r Rendition) Mime() string {
+	return "image/" + r.Format
+}
+
+// Ext returns the file extension of the rendition
+func (r Rendition) Ext() string {
+	if r.Format == RenditionFormatJPEG {
+		return ".jpg"
+	}
+	return "." + r.Format
+}
+
+// Variant tells the renditions of the same checksum apart
+func (r Rendition) Variant() string {
+	return fmt.Sprintf("%dx%d-%s-%s", r.Width, r.Height, r.Fit, r.Format)
+}
+
+// normalize fills the defaults, from the mime type of the source
+func (r Rendition) normalize(mime string) Rendition {
+	r.Width = min(max(r.Width, 0), RenditionMaxDimension)
+	r.Height = min(max(r.Height, 0), RenditionMaxDimension)
+	if r.Width == 0 && r.Height == 0 {
+		r.Width, r.Height = RenditionMaxDimension, RenditionMaxDimension
+	}
+	if r.Fit != RenditionFitCover || r.Width == 0 || r.Height == 0 {
+		r.Fit = RenditionFitContain
+	}
+	if r.Format == "" {
+		switch mime {
+		case "image/png", "image/gif":
+			r.Format = RenditionFormatPNG
+		case "image/webp":
+			r.Format = RenditionFormatWebP
+		default:
+			r.Format = RenditionFormatJPEG
+		}
+	}
+	return r
+}
+
+// RenditionCache stores the renditions in a directory of the local disk
+type RenditionCache struct {
+	dir     string
+	storage *LocalStorage
+	maxSize int64
+
+	mu sync.Mutex
+	// Bytes in the cache, -1 until measured
+	size int64
+	// Renditions being generated, closed when done
+	inflight map[string]chan struct{}
+}
+
+// NewRenditionCache returns a cache in dir, trimmed beyond maxSize bytes
+func NewRenditionCache(dir string, maxSize int64) *RenditionCache {
+	return &RenditionCache{
+		dir:      dir,
+		storage:  NewLocalStorage(dir),
+		maxSize:  maxSize,
+		size:     -1,
+		inflight: map[string]chan struct{}{},
+	}
+}
+
+// Renditions is the cache of the renditions
+var Renditions = NewRenditionCache(filepath.Join(os.TempDir(), "rprj-renditions"), 512<<20)
+
+// SetRenditionCache sets the directory of the renditions, the temporary
+// directory when empty, and its size in MB, 512 when 0
+func SetRenditionCache(dir string, sizeMB int64) {
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), "rprj-renditions")
+	}
+	if sizeMB <= 0 {
+		sizeMB = 512
+	}
+	Renditions = NewRenditionCache(dir, sizeMB<<20)
+}
+
+// Storage returns the storage of the cached renditions
+func (c *RenditionCache) Storage() BlobStorage {
+	return c.storage
+}
+
+// Get returns the rendition of an image, normalized, and its key in the
+// Storage of the cache, generating it when missing
+func (c *RenditionCache) Get(dbFile *DBFile, r Rendition) (Rendition, string, error) {
+	if !dbFile.IsImage() {
+		return r, "", ErrRenditionUnsupported
+	}
+	checksum := stringValue(dbFile, "checksum")
+	if !isChecksum(checksum) {
+		// The checksum of a missing blob is an error message
+		return r, "", fmt.Errorf("rendition of %s: %w", dbFile.GetBlobKey(), fs.ErrNotExist)
+	}
+	r = r.normalize(stringValue(dbFile, "mime"))
+	key := fmt.Sprintf("%s/%s/%s_%s%s", checksum[0:2], checksum[2:4], checksum, r.Variant(), r.Ext())
+
+	for {
+		if _, err := c.storage.Stat(key); err == nil {
+			c.touch(key)
+			return r, key, nil
+		}
+		c.mu.Lock()
+		done, busy := c.inflight[key]
+		if !busy {
+			done = make(chan struct{})
+			c.inflight[key] = done
+		}
+		c.mu.Unlock()
+		if busy {
+			// Generated by another request
+			<-done
+			continue
+		}
+
+		err := c.generate(dbFile.GetBlobKey(), r, key)
+		c.mu.Lock()
+		delete(c.inflight, key)
+		close(done)
+		c.mu.Unlock()
+		if err != nil {
+			return r, "", err
+		}
+		return r, key, nil
+	}
+}
+
+// touch marks a rendition as recently used
+func (c *RenditionCache) touch(key string) {
+	if p, err := c.storage.path(key); err == nil {
+		now := time.Now()
+		os.Chtimes(p, now, now)
+	}
+}
+
+// generate renders the blob as key of the cache
+func (c *RenditionCache) generate(blobKey string, r Rendition, key string) error {
+	select {
+	case renditionSlots <- struct{}{}:
+		defer func() { <-renditionSlots }()
+	case <-time.After(RenditionWait):
+		return ErrRenditionBusy
+	}
+
+	img, orientation, err := decodeImageBlob(blobKey)
+	if err != nil {
+		return err
+	}
+	width, height := r.Width, r.Height
+	if orientation >= 5 {
+		// The blob is stored sideways
+		width, height = height, width
+	}
+	img = orientImage(resizeImage(img, width, height, r.Fit), orientation)
+
+	var out bytes.Buffer
+	if err := encodeImage(&out, img, r.Format); err != nil {
+		return err
+	}
+	size := int64(out.Len())
+	if err := c.storage.Put(key, &out, size); err != nil {
+		return err
+	}
+	c.added(size)
+	return nil
+}
+
+// decodeImageBlob decodes an image of the FileStorage, with its EXIF
+// orientation. Images beyond RenditionMaxPixels are refused before decoding.
+func decodeImageBlob(key string) (image.Image, int, error) {
+	src, err := FileStorage.Get(key)
+	if err != nil {
+		return nil, 1, err
+	}
+	defer src.Close()
+
+	// The header read by DecodeConfig is decoded again with the rest
+	var head bytes.Buffer
+	config, format, err := image.DecodeConfig(io.TeeReader(src, &head))
+	if err != nil {
+		return nil, 1, fmt.Errorf("%s: %w", key, ErrRenditionUnsupported)
+	}
+	if config.Width*config.Height > RenditionMaxPixels {
+		return nil, 1, fmt.Errorf("%s is %dx%d: %w", key, config.Width, config.Height, ErrRenditionTooLarge)
+	}
+	orientation := 1
+	if format == "jpeg" {
+		if tiff, err := jpegExif(bytes.NewReader(head.Bytes())); err == nil {
+			orientation = exifOrientation(tiff)
+		}
+	}
+	img, _, err := image.Decode(io.MultiReader(&head, src))
+	if err != nil {
+		return nil, 1, fmt.Errorf("%s: %w", key, ErrRenditionUnsupported)
+	}
+	return img, orientation, nil
+}
+
+// resizeImage fits an image in a box of width x height, 0 for a side
+// following the aspect ratio. Images are never enlarged: a cover box larger
+// than the image is cropped with the same aspect ratio instead.
+func resizeImage(img image.Image, width int, height int, fit string) image.Image {
+	b := img.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+	if sw == 0 || sh == 0 {
+		return img
+	}
+	if fit != RenditionFitCover || width == 0 || height == 0 {
+		if width == 0 {
+			width = sw
+		}
+		if height == 0 {
+			height = sh
+		}
+		return resize.Thumbnail(uint(width), uint(height), img, resize.Lanczos3)
+	}
+
+	scale := math.Max(float64(width)/float64(sw), float64(height)/float64(sh))
+	if scale > 1 {
+		width = max(int(float64(width)/scale), 1)
+		height = max(int(float64(height)/scale), 1)
+		scale = 1
+	}
+	scaled := img
+	if scale < 1 {
+		scaled = resize.Resize(uint(math.Round(float64(sw)*scale)), uint(math.Round(float64(sh)*scale)), img, resize.Lanczos3)
+	}
+	sb := scaled.Bounds()
+	width, height = min(width, sb.Dx()), min(height, sb.Dy())
+	origin := image.Pt(sb.Min.X+(sb.Dx()-width)/2, sb.Min.Y+(sb.Dy()-height)/2)
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(dst, dst.Bounds(), scaled, origin, draw.Src)
+	return dst
+}
+
+// encodeImage encodes an image in a rendition format
+func encodeImage(w io.Writer, img image.Image, format string) error {
+	switch format {
+	case RenditionFormatPNG:
+		return png.Encode(w, img)
+	case RenditionFormatWebP:
+		// Lossless
+		return nativewebp.Encode(w, img, nil)
+	}
+	// JPEG has no transparency: white background
+	flat := image.NewRGBA(img.Bounds())
+	draw.Draw(flat, flat.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(flat, flat.Bounds(), img, img.Bounds().Min, draw.Over)
+	return jpeg.Encode(w, flat, &jpeg.Options{Quality: 85})
+}
+
+// added accounts a new rendition, trimming the cache when too large
+func (c *RenditionCache) added(size int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.size < 0 {
+		c.size = 0
+		filepath.WalkDir(c.dir, func(_ string, d fs.DirEntry, err error) error {
+			if err == nil && !d.IsDir() {
+				if info, err := d.Info(); err == nil {
+					c.size += info.Size()
+				}
+			}
+			return nil
+		})
+	} else {
+		c.size += size
+	}
+	if c.maxSize > 0 && c.size > c.maxSize {
+		c.trim(c.maxSize * 9 / 10)
+	}
+}
+
+// trim deletes the least recently used renditions down to target bytes
+func (c *RenditionCache) trim(target int64) {
+	type cached struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+	var files []cached
+	var total int64
+	filepath.WalkDir(c.dir, func(p string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			if info, err := d.Info(); err == nil {
+				files = append(files, cached{p, info.Size(), info.ModTime()})
+				total += info.Size()
+			}
+		}
+		return nil
+	})
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.Before(files[j].modTime) })
+	removed := 0
+	for _, f := range files {
+		if total <= target {
+			break
+		}
+		if err := os.Remove(f.path); err == nil {
+			total -= f.size
+			removed++
+		}
+	}
+	c.size = total
+	log.Printf("RenditionCache::trim: %d renditions removed, %d bytes cached", removed, total)
+}
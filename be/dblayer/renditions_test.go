@@ -0,0 +1,198 @@
+package dblayer
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"testing"
+	"time"
+)
+
+// testExif returns a TIFF structure with the given orientation
+func testExif(orientation byte) []byte {
+	return []byte{
+		'I', 'I', 42, 0, 8, 0, 0, 0,
+		1, 0,
+		0x12, 0x01, tiffShort, 0, 1, 0, 0, 0, orientation, 0, 0, 0,
+		0, 0, 0, 0,
+	}
+}
+
+// withExif inserts an EXIF block after the SOI of a JPEG
+func withExif(data []byte, tiff []byte) []byte {
+	segment := append([]byte("Exif\x00\x00"), tiff...)
+	n := len(segment) + 2
+	out := append([]byte{0xFF, 0xD8, 0xFF, 0xE1, byte(n >> 8), byte(n)}, segment...)
+	return append(out, data[2:]...)
+}
+
+// testHalves returns a w x h image, red on the left half and blue on the right
+func testHalves(w int, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if x < w/2 {
+				img.Set(x, y, color.RGBA{255, 0, 0, 255})
+			} else {
+				img.Set(x, y, color.RGBA{0, 0, 255, 255})
+			}
+		}
+	}
+	return img
+}
+
+func isRed(c color.Color) bool {
+	r, g, b, _ := c.RGBA()
+	return r > 0xC000 && g < 0x4000 && b < 0x4000
+}
+
+func TestExifOrientation(t *testing.T) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, testHalves(8, 4), nil); err != nil {
+		t.Fatal(err)
+	}
+	tiff, err := jpegExif(bytes.NewReader(withExif(buf.Bytes(), testExif(6))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := exifOrientation(tiff); got != 6 {
+		t.Errorf("expected orientation 6, got %d", got)
+	}
+	if _, err := jpegExif(bytes.NewReader(buf.Bytes())); err != errNoExif {
+		t.Errorf("expected no EXIF, got %v", err)
+	}
+	if got := exifOrientation([]byte("garbage")); got != 1 {
+		t.Errorf("expected orientation 1, got %d", got)
+	}
+
+	// Red on the left, rotated 90° clockwise: red on top
+	src := testHalves(4, 2)
+	for orientation, redAt := range map[int]image.Point{1: {0, 0}, 2: {3, 0}, 3: {3, 1}, 6: {0, 0}, 8: {0, 3}} {
+		oriented := orientImage(src, orientation)
+		if orientation >= 5 && oriented.Bounds().Dx() != 2 {
+			t.Errorf("%d: expected a 2x4 image, got %v", orientation, oriented.Bounds())
+		}
+		if !isRed(oriented.At(redAt.X, redAt.Y)) {
+			t.Errorf("%d: expected red at %v", orientation, redAt)
+		}
+	}
+}
+
+func TestResizeImage(t *testing.T) {
+	src := testHalves(400, 200)
+	for _, c := range []struct {
+		width, height int
+		fit           string
+		expected      image.Point
+	}{
+		{100, 100, RenditionFitContain, image.Pt(100, 50)},
+		{100, 100, RenditionFitCover, image.Pt(100, 100)},
+		{100, 0, RenditionFitCover, image.Pt(100, 50)},
+		{0, 50, RenditionFitContain, image.Pt(100, 50)},
+		// Never enlarged
+		{800, 800, RenditionFitContain, image.Pt(400, 200)},
+		{800, 400, RenditionFitCover, image.Pt(400, 200)},
+		{1000, 200, RenditionFitCover, image.Pt(400, 80)},
+	} {
+		got := resizeImage(src, c.width, c.height, c.fit).Bounds().Size()
+		if got != c.expected {
+			t.Errorf("%dx%d %s: expected %v, got %v", c.width, c.height, c.fit, c.expected, got)
+		}
+	}
+}
+
+func TestRenditionCache(t *testing.T) {
+	previous := FileStorage
+	defer func() { FileStorage = previous }()
+	FileStorage = NewLocalStorage(t.TempDir())
+	cache := NewRenditionCache(t.TempDir(), 1<<20)
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, testHalves(400, 200), &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatal(err)
+	}
+	data := withExif(buf.Bytes(), testExif(6))
+	if err := FileStorage.Put("ab/cd/photo.jpg", bytes.NewReader(data), int64(len(data))); err != nil {
+		t.Fatal(err)
+	}
+	checksum, err := blobSHA1("ab/cd/photo.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbFile := NewDBFile()
+	dbFile.SetValue("filename", "photo.jpg")
+	dbFile.SetValue("storage_key", "ab/cd/photo.jpg")
+	dbFile.SetValue("checksum", checksum)
+	dbFile.SetValue("mime", "image/jpeg")
+
+	rendition, key, err := cache.Get(dbFile, Rendition{Width: 50, Format: RenditionFormatPNG})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rendition.Fit != RenditionFitContain || rendition.Mime() != "image/png" {
+		t.Errorf("got %+v", rendition)
+	}
+	file, err := cache.Storage().Get(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(file)
+	file.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Upright: 200x400 scaled to a width of 50, red on top
+	if got := img.Bounds().Size(); got != image.Pt(50, 100) {
+		t.Errorf("expected 50x100, got %v", got)
+	}
+	if !isRed(img.At(25, 10)) || isRed(img.At(25, 90)) {
+		t.Errorf("expected red on top")
+	}
+
+	// Cached
+	if _, again, err := cache.Get(dbFile, Rendition{Width: 50, Format: RenditionFormatPNG}); err != nil || again != key {
+		t.Errorf("expected %s, got %s %v", key, again, err)
+	}
+	for _, format := range []string{RenditionFormatJPEG, RenditionFormatWebP} {
+		if _, _, err := cache.Get(dbFile, Rendition{Width: 40, Height: 40, Fit: RenditionFitCover, Format: format}); err != nil {
+			t.Errorf("%s: %v", format, err)
+		}
+	}
+
+	// Limits
+	previousMax := RenditionMaxPixels
+	defer func() { RenditionMaxPixels = previousMax }()
+	RenditionMaxPixels = 1000
+	if _, _, err := cache.Get(dbFile, Rendition{Width: 60}); !errors.Is(err, ErrRenditionTooLarge) {
+		t.Errorf("expected the source to be too large, got %v", err)
+	}
+	dbFile.SetValue("mime", "text/plain")
+	if _, _, err := cache.Get(dbFile, Rendition{Width: 60}); err != ErrRenditionUnsupported {
+		t.Errorf("expected unsupported, got %v", err)
+	}
+}
+
+func TestRenditionCacheTrim(t *testing.T) {
+	dir := t.TempDir()
+	cache := NewRenditionCache(dir, 100)
+	for i, key := range []string{"a", "b", "c"} {
+		if err := cache.storage.Put(key, bytes.NewReader(make([]byte, 40)), 40); err != nil {
+			t.Fatal(err)
+		}
+		// The oldest first
+		p, _ := cache.storage.path(key)
+		when := time.Now().Add(-time.Duration(3-i) * time.Hour)
+		os.Chtimes(p, when, when)
+		cache.added(40)
+	}
+	if _, err := cache.storage.Stat("a"); err == nil {
+		t.Errorf("expected the oldest rendition to be trimmed")
+	}
+	if _, err := cache.storage.Stat("c"); err != nil {
+		t.Errorf("expected the newest rendition to be kept, got %v", err)
+	}
+}
@@ -0,0 +1,346 @@
+package dblayer
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"path"
+
+	"rprj/be/models"
+)
+
+/*
+Malware scanning of the uploads.
+
+When a scanner is configured (scanner_driver, see NewScanner) every new blob
+of a DBFile is scanned by the insert and the update hooks, before its
+metadata are stripped and before it is stored: an upload that cannot be
+scanned is refused, as is an infected one. The scan_action decides what
+happens to an infected upload: reject deletes it, quarantine moves it to
+quarantine/ in the FileStorage, out of reach of the downloads, for the
+admins to inspect.
+
+Each detection is recorded in FileScan, in its own transaction: the audit
+survives the rollback of the upload. RescanFiles scans the blobs already
+stored, the files and their archived revisions, in batches.
+*/
+
+// Drivers of the scanner
+const (
+	ScannerNone  = ""
+	ScannerClamd = "clamd"
+)
+
+// Actions on an infected upload
+const (
+	ScanActionReject     = "reject"
+	ScanActionQuarantine = "quarantine"
+)
+
+// Actions recorded in FileScan
+const (
+	FileScanRejected    = "rejected"
+	FileScanQuarantined = "quarantined"
+	FileScanDetected    = "detected"
+)
+
+// Prefix of the keys of the quarantined blobs in the FileStorage
+const quarantinePrefix = "quarantine/"
+
+// ErrScanFailed is returned when a blob cannot be scanned: the scanner is
+// down or refused the content
+var ErrScanFailed = errors.New("the file could not be scanned")
+
+// Scanner checks a content for malware
+type Scanner interface {
+	// Scan returns the name of the signature found, "" when the content is clean
+	Scan(r io.Reader) (string, error)
+}
+
+// FileScanner scans the uploads, nil when disabled
+var FileScanner Scanner
+
+// scanAction is the action on an infected upload
+var scanAction = ScanActionReject
+
+// NewScanner returns the scanner of the configuration, nil when none is configured
+func NewScanner(config models.Config) (Scanner, error) {
+	switch config.ScannerDriver {
+	case ScannerNone:
+		return nil, nil
+	case ScannerClamd:
+		if config.ClamdAddress == "" {
+			return nil, fmt.Errorf("clamd_address is required by the clamd scanner")
+		}
+		scanner, err := NewClamdScanner(config.ClamdAddress)
+		if err != nil {
+			return nil, err
+		}
+		return scanner, nil
+	default:
+		return nil, fmt.Errorf("unknown scanner driver: %s", config.ScannerDriver)
+	}
+}
+
+// SetScanAction sets the action on an infected upload: reject (the default) or quarantine
+func SetScanAction(action string) error {
+	switch action {
+	case "", ScanActionReject:
+		scanAction = ScanActionReject
+	case ScanActionQuarantine:
+		scanAction = ScanActionQuarantine
+	default:
+		return fmt.Errorf("unknown scan action: %s", action)
+	}
+	return nil
+}
+
+// InfectedFileError is returned when an upload is infected
+type InfectedFileError struct {
+	Filename  string
+	Signature string
+	// Quarantined is true when the upload was moved to the quarantine
+	Quarantined bool
+}
+
+func (e *InfectedFileError) Error() string {
+	return fmt.Sprintf("the file %s is infected: %s", e.Filename, e.Signature)
+}
+
+// scanBlob scans a blob of the FileStorage
+func scanBlob(scanner Scanner, key string) (string, error) {
+	file, err := FileStorage.Get(key)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	signature, err := scanner.Scan(file)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s: %v", ErrScanFailed, key, err)
+	}
+	return signature, nil
+}
+
+// quarantineBlob moves a blob to the quarantine, returning its new key
+func quarantineBlob(key string, scanID string) (string, error) {
+	quarantineKey := quarantinePrefix + scanID + "_" + path.Base(key)
+	if err := FileStorage.Rename(key, quarantineKey); err != nil {
+		return "", err
+	}
+	return quarantineKey, nil
+}
+
+// recordFileScan records a detection, in its own transaction
+func (dbr *DBRepository) recordFileScan(fileScan *FileScan) {
+	if _, err := dbr.Insert(fileScan); err != nil {
+		log.Printf("DBRepository::recordFileScan: error recording %s in %s: %v",
+			fileScan.GetValue("signature"), fileScan.GetValue("filename"), err)
+	}
+}
+
+// scanUpload scans an uploaded blob: an infected one is deleted or
+// quarantined, recorded in FileScan, and an InfectedFileError is returned.
+// fileID is the file updated, "" for a new one.
+func (dbr *DBRepository) scanUpload(uploadedKey string, fileID string) error {
+	scanner := FileScanner
+	if scanner == nil {
+		return nil
+	}
+	signature, err := scanBlob(scanner, uploadedKey)
+	if err != nil || signature == "" {
+		return err
+	}
+	log.Printf("DBRepository::scanUpload: %s infected: %s", uploadedKey, signature)
+
+	scanID, _ := uuid16HexGo()
+	fileScan := NewFileScan()
+	fileScan.SetValue("id", scanID)
+	fileScan.SetValue("filename", path.Base(uploadedKey))
+	fileScan.SetValue("signature", signature)
+	if fileID != "" {
+		fileScan.SetValue("file_id", fileID)
+	}
+	if checksum, err := blobSHA1(uploadedKey); err == nil {
+		fileScan.SetValue("checksum", checksum)
+	}
+	if info, err := FileStorage.Stat(uploadedKey); err == nil {
+		fileScan.SetValue("size", info.Size)
+	}
+
+	infected := &InfectedFileError{Filename: path.Base(uploadedKey), Signature: signature}
+	fileScan.SetValue("action", FileScanRejected)
+	if scanAction == ScanActionQuarantine {
+		if quarantineKey, err := quarantineBlob(uploadedKey, scanID); err == nil {
+			fileScan.SetValue("action", FileScanQuarantined)
+			fileScan.SetValue("quarantine_key", quarantineKey)
+			infected.Quarantined = true
+		} else {
+			log.Printf("DBRepository::scanUpload: error quarantining %s: %v", uploadedKey, err)
+		}
+	}
+	if !infected.Quarantined {
+		if err := FileStorage.Delete(uploadedKey); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("DBRepository::scanUpload: error deleting %s: %v", uploadedKey, err)
+		}
+	}
+	dbr.recordFileScan(fileScan)
+	return infected
+}
+
+// FileScanReport is the report of a rescan of the stored files
+type FileScanReport struct {
+	// Files read in this batch
+	Files int `json:"files"`
+	// Blobs scanned: the files and their archived revisions, a deduplicated blob once
+	Scanned  int `json:"scanned"`
+	Infected int `json:"infected"`
+	Missing  int `json:"missing"`
+	// Id of the last file of the batch, to pass as after to the next one; "" when done
+	Next   string               `json:"next"`
+	Errors []FileMigrationError `json:"errors"`
+	// Detections of this batch
+	Detections []FileScanInfo `json:"detections"`
+}
+
+// FileScanInfo is a detection recorded in FileScan
+type FileScanInfo struct {
+	ID            string `json:"id"`
+	ScanDate      string `json:"scan_date"`
+	UserID        string `json:"user_id,omitempty"`
+	FileID        string `json:"file_id,omitempty"`
+	Filename      string `json:"filename"`
+	Checksum      string `json:"checksum,omitempty"`
+	Size          int64  `json:"size"`
+	Signature     string `json:"signature"`
+	Action        string `json:"action"`
+	QuarantineKey string `json:"quarantine_key,omitempty"`
+}
+
+// fileScanInfo converts a FileScan
+func fileScanInfo(fileScan DBEntityInterface) FileScanInfo {
+	return FileScanInfo{
+		ID:            stringValue(fileScan, "id"),
+		ScanDate:      stringValue(fileScan, "scan_date"),
+		UserID:        stringValue(fileScan, "user_id"),
+		FileID:        stringValue(fileScan, "file_id"),
+		Filename:      stringValue(fileScan, "filename"),
+		Checksum:      stringValue(fileScan, "checksum"),
+		Size:          int64Value(fileScan, "size"),
+		Signature:     stringValue(fileScan, "signature"),
+		Action:        stringValue(fileScan, "action"),
+		QuarantineKey: stringValue(fileScan, "quarantine_key"),
+	}
+}
+
+// GetFileScans returns the most recent detections, at most limit (0 = all)
+func (dbr *DBRepository) GetFileScans(limit int) ([]FileScanInfo, error) {
+	query := "SELECT * FROM " + dbr.buildTableName(NewFileScan()) + " ORDER BY scan_date DESC, id DESC"
+	if limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", limit)
+	}
+	results := dbr.Select("FileScan", query)
+	if results == nil {
+		return nil, fmt.Errorf("failed to read the file scans")
+	}
+	scans := make([]FileScanInfo, 0, len(results))
+	for _, result := range results {
+		scans = append(scans, fileScanInfo(result))
+	}
+	return scans, nil
+}
+
+// RescanFiles scans the blobs of the files stored after the file id after,
+// at most limit files (0 = all), with their archived revisions. Each
+// detection is recorded in FileScan; with the quarantine action the blob is
+// moved to the quarantine and the files using it cannot be downloaded
+// anymore. A blob that cannot be scanned stops the batch: the scanner is
+// down. Returns ErrScanFailed when no scanner is configured.
+func (dbr *DBRepository) RescanFiles(after string, limit int) (*FileScanReport, error) {
+	scanner := FileScanner
+	if scanner == nil {
+		return nil, fmt.Errorf("%w: no scanner configured", ErrScanFailed)
+	}
+	query := "SELECT * FROM " + dbr.buildTableName(NewDBFile()) + " WHERE filename <> '' AND id > ? ORDER BY id"
+	if limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", limit)
+	}
+	files := dbr.Select("DBFile", query, after)
+	if files == nil {
+		return nil, fmt.Errorf("failed to read the files")
+	}
+	report := &FileScanReport{Errors: []FileMigrationError{}, Detections: []FileScanInfo{}}
+	scanned := map[string]bool{}
+	for _, entity := range files {
+		dbFile := entity.(*DBFile)
+		fileID := stringValue(dbFile, "id")
+		report.Files++
+		keys := []string{dbFile.GetBlobKey()}
+		revisions, err := dbr.GetFileRevisions(fileID)
+		if err != nil {
+			return nil, err
+		}
+		for _, revision := range revisions {
+			keys = append(keys, stringValue(revision, "storage_key"))
+		}
+		for _, key := range keys {
+			if key == "" || scanned[key] {
+				continue
+			}
+			scanned[key] = true
+			signature, err := scanBlob(scanner, key)
+			if errors.Is(err, fs.ErrNotExist) {
+				report.Missing++
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			report.Scanned++
+			if signature == "" {
+				continue
+			}
+			log.Printf("DBRepository::RescanFiles: %s of %s infected: %s", key, fileID, signature)
+			report.Infected++
+			detection, err := dbr.recordRescanDetection(dbFile, key, signature)
+			if err != nil {
+				report.Errors = append(report.Errors, FileMigrationError{ID: fileID, Filename: stringValue(dbFile, "name"), Message: err.Error()})
+			}
+			report.Detections = append(report.Detections, detection)
+		}
+		report.Next = fileID
+	}
+	if limit == 0 || len(files) < limit {
+		report.Next = ""
+	}
+	return report, nil
+}
+
+// recordRescanDetection records an infected blob found by a rescan,
+// quarantining it with the quarantine action
+func (dbr *DBRepository) recordRescanDetection(dbFile *DBFile, key string, signature string) (FileScanInfo, error) {
+	scanID, _ := uuid16HexGo()
+	fileScan := NewFileScan()
+	fileScan.SetValue("id", scanID)
+	fileScan.SetValue("file_id", stringValue(dbFile, "id"))
+	fileScan.SetValue("filename", stringValue(dbFile, "name"))
+	fileScan.SetValue("signature", signature)
+	fileScan.SetValue("action", FileScanDetected)
+	if key == dbFile.GetBlobKey() {
+		fileScan.SetValue("checksum", stringValue(dbFile, "checksum"))
+	}
+	if info, err := FileStorage.Stat(key); err == nil {
+		fileScan.SetValue("size", info.Size)
+	}
+	var err error
+	if scanAction == ScanActionQuarantine {
+		var quarantineKey string
+		if quarantineKey, err = quarantineBlob(key, scanID); err == nil {
+			fileScan.SetValue("action", FileScanQuarantined)
+			fileScan.SetValue("quarantine_key", quarantineKey)
+		}
+	}
+	dbr.recordFileScan(fileScan)
+	return fileScanInfo(fileScan), err
+}
@@ -0,0 +1,119 @@
+package dblayer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// Size of the chunks streamed to clamd, below its StreamMaxLength
+const clamdChunkSize = 64 * 1024
+
+// ClamdScanner scans with a ClamAV daemon, over TCP or a unix socket, with
+// the INSTREAM command: the content is streamed, clamd needs no access to the
+// storage
+type ClamdScanner struct {
+	network string
+	address string
+	// Timeout of the connection and of each read and write
+	Timeout time.Duration
+}
+
+// NewClamdScanner returns a scanner connecting to clamd at address:
+// tcp://host:port or host:port, unix:///path or /path
+func NewClamdScanner(address string) (*ClamdScanner, error) {
+	scanner := &ClamdScanner{network: "tcp", address: address, Timeout: 60 * time.Second}
+	switch {
+	case strings.HasPrefix(address, "tcp://"):
+		scanner.address = strings.TrimPrefix(address, "tcp://")
+	case strings.HasPrefix(address, "unix://"):
+		scanner.network, scanner.address = "unix", strings.TrimPrefix(address, "unix://")
+	case strings.HasPrefix(address, "/"):
+		scanner.network = "unix"
+	}
+	if scanner.address == "" {
+		return nil, fmt.Errorf("invalid clamd address: %s", address)
+	}
+	return scanner, nil
+}
+
+// command sends a command to clamd and returns its reply; body, when not
+// nil, is streamed after the command
+func (scanner *ClamdScanner) command(command string, body io.Reader) (string, error) {
+	conn, err := net.DialTimeout(scanner.network, scanner.address, scanner.Timeout)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	deadline := func() { conn.SetDeadline(time.Now().Add(scanner.Timeout)) }
+	deadline()
+	// The z prefix: commands and replies are terminated by \0
+	if _, err := conn.Write([]byte("z" + command + "\x00")); err != nil {
+		return "", err
+	}
+	if body != nil {
+		chunk := make([]byte, clamdChunkSize)
+		for {
+			n, readErr := io.ReadFull(body, chunk)
+			if n > 0 {
+				deadline()
+				frame := binary.BigEndian.AppendUint32(make([]byte, 0, 4+n), uint32(n))
+				if _, err := conn.Write(append(frame, chunk[:n]...)); err != nil {
+					// clamd closes the connection beyond its StreamMaxLength: read why
+					break
+				}
+			}
+			if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+				break
+			}
+			if readErr != nil {
+				return "", readErr
+			}
+		}
+		deadline()
+		conn.Write([]byte{0, 0, 0, 0})
+	}
+	reply, err := bufio.NewReader(conn).ReadBytes(0)
+	if err != nil && len(reply) == 0 {
+		return "", err
+	}
+	return string(bytes.TrimRight(reply, "\x00\n")), nil
+}
+
+// Scan streams the content to clamd
+func (scanner *ClamdScanner) Scan(r io.Reader) (string, error) {
+	reply, err := scanner.command("INSTREAM", r)
+	if err != nil {
+		return "", err
+	}
+	// stream: OK, stream: <signature> FOUND or <message> ERROR
+	result := reply
+	if i := strings.Index(reply, ": "); i >= 0 {
+		result = reply[i+2:]
+	}
+	switch {
+	case result == "OK":
+		return "", nil
+	case strings.HasSuffix(result, " FOUND"):
+		return strings.TrimSuffix(result, " FOUND"), nil
+	default:
+		return "", fmt.Errorf("clamd: %s", reply)
+	}
+}
+
+// Ping checks that clamd is up
+func (scanner *ClamdScanner) Ping() error {
+	reply, err := scanner.command("PING", nil)
+	if err != nil {
+		return err
+	}
+	if reply != "PONG" {
+		return fmt.Errorf("clamd: %s", reply)
+	}
+	return nil
+}
@@ -0,0 +1,200 @@
+package dblayer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"rprj/be/models"
+)
+
+// The EICAR test file: detected by every scanner, harmless
+const testEicar = `X5O!P%@AP[4\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*`
+
+// testScanner detects the EICAR test file
+type testScanner struct{}
+
+func (testScanner) Scan(r io.Reader) (string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+	if bytes.Contains(data, []byte(testEicar)) {
+		return "Eicar-Test-Signature", nil
+	}
+	return "", nil
+}
+
+// stubClamd serves the PING and INSTREAM commands of clamd on a listener,
+// detecting the EICAR test file and refusing streams beyond maxLength
+func stubClamd(t *testing.T, listener net.Listener, maxLength int) {
+	t.Helper()
+	t.Cleanup(func() { listener.Close() })
+	serve := func(conn net.Conn) {
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		command, err := r.ReadString(0)
+		if err != nil {
+			return
+		}
+		switch command {
+		case "zPING\x00":
+			conn.Write([]byte("PONG\x00"))
+		case "zINSTREAM\x00":
+			var stream []byte
+			for {
+				var size uint32
+				if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+					return
+				}
+				if size == 0 {
+					break
+				}
+				if len(stream)+int(size) > maxLength {
+					conn.Write([]byte("INSTREAM size limit exceeded. ERROR\x00"))
+					return
+				}
+				chunk := make([]byte, size)
+				if _, err := io.ReadFull(r, chunk); err != nil {
+					return
+				}
+				stream = append(stream, chunk...)
+			}
+			signature, _ := testScanner{}.Scan(bytes.NewReader(stream))
+			if signature != "" {
+				conn.Write([]byte("stream: " + signature + " FOUND\x00"))
+			} else {
+				conn.Write([]byte("stream: OK\x00"))
+			}
+		default:
+			conn.Write([]byte("UNKNOWN COMMAND\x00"))
+		}
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serve(conn)
+		}
+	}()
+}
+
+func TestNewClamdScanner(t *testing.T) {
+	for address, expected := range map[string][2]string{
+		"tcp://clamav:3310":             {"tcp", "clamav:3310"},
+		"clamav:3310":                   {"tcp", "clamav:3310"},
+		"unix:///run/clamav/clamd.sock": {"unix", "/run/clamav/clamd.sock"},
+		"/run/clamav/clamd.sock":        {"unix", "/run/clamav/clamd.sock"},
+	} {
+		scanner, err := NewClamdScanner(address)
+		if err != nil {
+			t.Errorf("%s: %v", address, err)
+			continue
+		}
+		if scanner.network != expected[0] || scanner.address != expected[1] {
+			t.Errorf("%s: expected %v, got %s %s", address, expected, scanner.network, scanner.address)
+		}
+	}
+	if _, err := NewClamdScanner("unix://"); err == nil {
+		t.Errorf("expected an invalid address")
+	}
+
+	if scanner, err := NewScanner(models.Config{}); scanner != nil || err != nil {
+		t.Errorf("expected no scanner, got %v %v", scanner, err)
+	}
+	if _, err := NewScanner(models.Config{ScannerDriver: ScannerClamd}); err == nil {
+		t.Errorf("expected the clamd address to be required")
+	}
+	if _, err := NewScanner(models.Config{ScannerDriver: "virustotal"}); err == nil {
+		t.Errorf("expected an unknown driver")
+	}
+	defer SetScanAction(ScanActionReject)
+	if err := SetScanAction(ScanActionQuarantine); err != nil || scanAction != ScanActionQuarantine {
+		t.Errorf("expected quarantine, got %s %v", scanAction, err)
+	}
+	if err := SetScanAction("delete"); err == nil {
+		t.Errorf("expected an unknown action")
+	}
+}
+
+func TestClamdScanner(t *testing.T) {
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stubClamd(t, tcp, 1<<20)
+	socket := filepath.Join(t.TempDir(), "clamd.sock")
+	unix, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stubClamd(t, unix, 1<<20)
+
+	for _, address := range []string{"tcp://" + tcp.Addr().String(), "unix://" + socket} {
+		scanner, err := NewClamdScanner(address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := scanner.Ping(); err != nil {
+			t.Errorf("%s: ping: %v", address, err)
+		}
+		if signature, err := scanner.Scan(strings.NewReader("hello world")); signature != "" || err != nil {
+			t.Errorf("%s: expected clean, got %q %v", address, signature, err)
+		}
+		// Across the chunks of the stream
+		infected := strings.Repeat("x", clamdChunkSize-10) + testEicar
+		if signature, err := scanner.Scan(strings.NewReader(infected)); signature != "Eicar-Test-Signature" || err != nil {
+			t.Errorf("%s: expected the EICAR signature, got %q %v", address, signature, err)
+		}
+		if signature, err := scanner.Scan(bytes.NewReader(make([]byte, 2<<20))); signature != "" || err == nil {
+			t.Errorf("%s: expected the size limit error, got %q %v", address, signature, err)
+		}
+	}
+
+	// Down
+	tcp.Close()
+	scanner, _ := NewClamdScanner(tcp.Addr().String())
+	if _, err := scanner.Scan(strings.NewReader("hello world")); err == nil {
+		t.Errorf("expected an error with clamd down")
+	}
+}
+
+func TestScanBlob(t *testing.T) {
+	previous := FileStorage
+	defer func() { FileStorage = previous }()
+	FileStorage = NewLocalStorage(t.TempDir())
+	FileStorage.Put("clean.txt", strings.NewReader("hello world"), -1)
+	FileStorage.Put("eicar.com", strings.NewReader(testEicar), -1)
+
+	if signature, err := scanBlob(testScanner{}, "clean.txt"); signature != "" || err != nil {
+		t.Errorf("expected clean, got %q %v", signature, err)
+	}
+	if signature, err := scanBlob(testScanner{}, "eicar.com"); signature != "Eicar-Test-Signature" || err != nil {
+		t.Errorf("expected the EICAR signature, got %q %v", signature, err)
+	}
+
+	// The errors of the scanner are ErrScanFailed
+	down, _ := NewClamdScanner("unix://" + filepath.Join(t.TempDir(), "missing.sock"))
+	if _, err := scanBlob(down, "clean.txt"); !errors.Is(err, ErrScanFailed) {
+		t.Errorf("expected ErrScanFailed, got %v", err)
+	}
+
+	quarantineKey, err := quarantineBlob("eicar.com", "0123456789abcdef")
+	if err != nil || quarantineKey != "quarantine/0123456789abcdef_eicar.com" {
+		t.Fatalf("got %s %v", quarantineKey, err)
+	}
+	if _, err := FileStorage.Stat("eicar.com"); err == nil {
+		t.Errorf("expected the blob to be moved")
+	}
+	if _, err := FileStorage.Stat(quarantineKey); err != nil {
+		t.Errorf("expected the blob in the quarantine, got %v", err)
+	}
+}
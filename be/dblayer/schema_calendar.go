@@ -0,0 +1,120 @@
+package dblayer
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+)
+
+/*
+CREATE TABLE `rprj_events_uids` (
+
+	`uid` varchar(255) NOT NULL,
+	`event_id` varchar(16) NOT NULL,
+	`href` varchar(255) DEFAULT NULL,
+	`creation_date` datetime DEFAULT NULL,
+	PRIMARY KEY (`uid`),
+	KEY `rprj_events_uids_0` (`event_id`),
+	KEY `rprj_events_uids_1` (`href`)
+
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;
+*/
+// EventUID maps the UID of an imported iCalendar event to its DBEvent.
+// Events created in rhobee use "<id>@rhobee" and need no mapping.
+// href is the resource name chosen by a CalDAV client, "<id>.ics" when empty.
+type EventUID struct {
+	DBEntity
+}
+
+func NewEventUID() *EventUID {
+	columns := []Column{
+		{Name: "uid", Type: "varchar(255)", Constraints: []string{"NOT NULL"}},
+		{Name: "event_id", Type: "varchar(16)", Constraints: []string{"NOT NULL"}},
+		{Name: "href", Type: "varchar(255)", Constraints: []string{}},
+		{Name: "creation_date", Type: "datetime", Constraints: []string{}},
+	}
+	keys := []string{"uid"}
+	foreignKeys := []ForeignKey{
+		{Column: "event_id", RefTable: "events", RefColumn: "id"},
+	}
+	return &EventUID{
+		DBEntity: *NewDBEntity(
+			"EventUID",
+			"events_uids",
+			columns,
+			keys,
+			foreignKeys,
+			make(map[string]any),
+		),
+	}
+}
+func (eventUID *EventUID) NewInstance() DBEntityInterface {
+	return NewEventUID()
+}
+
+func (eventUID *EventUID) beforeInsert(dbr *DBRepository, tx *sql.Tx) error {
+	eventUID.SetValue("creation_date", CurrentDateTimeString())
+	return nil
+}
+
+/*
+CREATE TABLE `rprj_calendar_tokens` (
+
+	`user_id` varchar(16) NOT NULL,
+	`token` varchar(64) NOT NULL,
+	`creation_date` datetime DEFAULT NULL,
+	PRIMARY KEY (`user_id`),
+	UNIQUE KEY `rprj_calendar_tokens_0` (`token`)
+
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;
+*/
+// DBCalendarToken is the secret of the private calendar feeds of a user
+type DBCalendarToken struct {
+	DBEntity
+}
+
+func NewDBCalendarToken() *DBCalendarToken {
+	columns := []Column{
+		{Name: "user_id", Type: "varchar(16)", Constraints: []string{"NOT NULL"}},
+		{Name: "token", Type: "varchar(64)", Constraints: []string{"NOT NULL"}},
+		{Name: "creation_date", Type: "datetime", Constraints: []string{}},
+	}
+	keys := []string{"user_id"}
+	foreignKeys := []ForeignKey{
+		{Column: "user_id", RefTable: "users", RefColumn: "id"},
+	}
+	return &DBCalendarToken{
+		DBEntity: *NewDBEntity(
+			"DBCalendarToken",
+			"calendar_tokens",
+			columns,
+			keys,
+			foreignKeys,
+			make(map[string]any),
+		),
+	}
+}
+func (dbCalendarToken *DBCalendarToken) NewInstance() DBEntityInterface {
+	return NewDBCalendarToken()
+}
+
+// newCalendarSecret returns 32 random bytes as hex
+func newCalendarSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func (dbCalendarToken *DBCalendarToken) beforeInsert(dbr *DBRepository, tx *sql.Tx) error {
+	if token, _ := dbCalendarToken.GetValue("token").(string); token == "" {
+		secret, err := newCalendarSecret()
+		if err != nil {
+			return err
+		}
+		dbCalendarToken.SetValue("token", secret)
+	}
+	dbCalendarToken.SetValue("creation_date", CurrentDateTimeString())
+	return nil
+}
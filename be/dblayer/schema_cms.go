@@ -1,9 +1,8 @@
 package dblayer
 
 import (
-	"crypto/sha1"
+	"bytes"
 	"database/sql"
-	"fmt"
 	"image"
 	_ "image/gif"
 	"image/jpeg"
@@ -173,6 +172,21 @@ func (dbEvent *DBEvent) NewInstance() DBEntityInterface {
 	return NewDBEvent()
 }
 
+func (dbEvent *DBEvent) beforeDelete(dbr *DBRepository, tx *sql.Tx) error {
+	// Hard delete: forget the iCalendar UID of the event
+	hardDelete := dbEvent.HasDeletedDate()
+	err := dbEvent.DBObject.beforeDelete(dbr, tx)
+	if err != nil || !hardDelete {
+		return err
+	}
+	query := "DELETE FROM " + dbr.buildTableName(NewEventUID()) + " WHERE event_id = ?"
+	if _, err := tx.Exec(query, dbEvent.GetValue("id")); err != nil {
+		log.Print("DBEvent::beforeDelete: error deleting the event UID:", err)
+		return err
+	}
+	return nil
+}
+
 /*
 CREATE TABLE IF NOT EXISTS `rra_files` (
 
@@ -206,6 +220,8 @@ CREATE TABLE IF NOT EXISTS `rra_files` (
 */
 type DBFile struct {
 	DBObject
+	// A new blob was stored by beforeInsert/beforeUpdate: afterInsert/afterUpdate record its revision
+	newRevision bool
 }
 
 func NewDBFile() *DBFile {
@@ -227,6 +243,15 @@ func NewDBFile() *DBFile {
 		{Name: "checksum", Type: "varchar(40)", Constraints: []string{}},
 		{Name: "mime", Type: "varchar(255)", Constraints: []string{}},
 		{Name: "alt_link", Type: "varchar(255)", Constraints: []string{"NOT NULL"}},
+		{Name: "storage_key", Type: "varchar(255)", Constraints: []string{}},
+		{Name: "size", Type: "bigint(20)", Constraints: []string{}},
+		// Metadata of the images (see imagemetadata.go)
+		{Name: "image_width", Type: "int(11)", Constraints: []string{}},
+		{Name: "image_height", Type: "int(11)", Constraints: []string{}},
+		{Name: "camera", Type: "varchar(255)", Constraints: []string{}},
+		{Name: "taken_date", Type: "datetime", Constraints: []string{}},
+		{Name: "gps_latitude", Type: "decimal(10,7)", Constraints: []string{}},
+		{Name: "gps_longitude", Type: "decimal(10,7)", Constraints: []string{}},
 		{Name: "deleted_by", Type: "varchar(16)", Constraints: []string{}},
 		{Name: "deleted_date", Type: "datetime", Constraints: []string{}},
 	}
@@ -331,14 +356,8 @@ func (dbFile *DBFile) GetFullpath(a_dbe DBEntityInterface) string {
 	} else {
 		mydbe = dbFile
 	}
-	dest_path := mydbe.generateObjectPath(nil)
-	// $dest_dir=realpath($GLOBALS['root_directory'].'/'.$mydbe->dest_directory);
-	dest_dir := dbFiles_root_directory + "/" + dbFiles_dest_directory
-	if dest_path != "" {
-		dest_dir = dest_dir + "/" + dest_path
-	}
-	ret := dest_dir + "/" + mydbe.GetValue("filename").(string)
-	return ret
+	// Path in the files directory, meaningful with the local storage driver only
+	return filesDirectory() + "/" + mydbe.GetBlobKey()
 }
 
 // function getFullpath($a_dbe=null) {
@@ -380,34 +399,23 @@ func (dbFile *DBFile) IsImage() bool {
 	return false
 }
 
-// computeSHA1 calculates the SHA1 hash of a file
-func (dbFile *DBFile) computeSHA1(filepath string) (string, error) {
-	file, err := os.Open(filepath)
-	if err != nil {
-		return "", err
-	}
-	defer file.Close()
-
-	hash := sha1.New()
-	if _, err := io.Copy(hash, file); err != nil {
-		return "", err
-	}
-
-	return fmt.Sprintf("%x", hash.Sum(nil)), nil
+// computeSHA1 calculates the SHA1 hash of a blob
+func (dbFile *DBFile) computeSHA1(key string) (string, error) {
+	return blobSHA1(key)
 }
 
-// detectMimeType detects the MIME type of a file (equivalent to finfo_open in PHP)
-func (dbFile *DBFile) detectMimeType(filepath string) (string, error) {
-	file, err := os.Open(filepath)
+// detectMimeType detects the MIME type of a blob (equivalent to finfo_open in PHP)
+func (dbFile *DBFile) detectMimeType(key string) (string, error) {
+	// Read first 512 bytes for detection
+	file, err := FileStorage.GetRange(key, 0, 512)
 	if err != nil {
 		return "application/octet-stream", err
 	}
 	defer file.Close()
 
-	// Read first 512 bytes for detection
 	buffer := make([]byte, 512)
-	n, err := file.Read(buffer)
-	if err != nil && err != io.EOF {
+	n, err := io.ReadFull(file, buffer)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
 		return "application/octet-stream", err
 	}
 
@@ -419,16 +427,8 @@ func (dbFile *DBFile) detectMimeType(filepath string) (string, error) {
 func (dbFile *DBFile) createThumbnail(fullpath string) string {
 	thumbPath := fullpath + "_thumb.jpg"
 
-	// Open file
-	file, err := os.Open(fullpath)
-	if err != nil {
-		log.Printf("Error opening file %s: %v\n", fullpath, err)
-		return ""
-	}
-	defer file.Close()
-
-	// Decode image (supports JPEG, PNG, GIF)
-	img, format, err := image.Decode(file)
+	// Decode image (supports JPEG, PNG, GIF, WebP), with its EXIF orientation
+	img, orientation, err := decodeImageBlob(fullpath)
 	if err != nil {
 		log.Printf("Error decoding image %s: %v\n", fullpath, err)
 		return ""
@@ -450,29 +450,27 @@ func (dbFile *DBFile) createThumbnail(fullpath string) string {
 	} else {
 		thumb = img // No need to resize if already small
 	}
-
-	// Create output file
-	out, err := os.Create(thumbPath)
-	if err != nil {
-		log.Printf("Error creating thumbnail file %s: %v\n", thumbPath, err)
-		return ""
-	}
-	defer out.Close()
+	thumb = orientImage(thumb, orientation)
 
 	// Save as JPEG with quality 85
-	err = jpeg.Encode(out, thumb, &jpeg.Options{Quality: 85})
+	var out bytes.Buffer
+	err = jpeg.Encode(&out, thumb, &jpeg.Options{Quality: 85})
 	if err != nil {
 		log.Printf("Error encoding thumbnail %s: %v\n", thumbPath, err)
 		return ""
 	}
+	if err := FileStorage.Put(thumbPath, &out, int64(out.Len())); err != nil {
+		log.Printf("Error creating thumbnail file %s: %v\n", thumbPath, err)
+		return ""
+	}
 
-	log.Printf("Created thumbnail for %s (format: %s) at %s\n", fullpath, format, thumbPath)
+	log.Printf("Created thumbnail for %s at %s\n", fullpath, thumbPath)
 	return thumbPath
 }
 
 func (dbFile *DBFile) deleteThumbnail(fullpath string) {
 	thumbPath := fullpath + "_thumb.jpg"
-	err := os.Remove(thumbPath)
+	err := FileStorage.Delete(thumbPath)
 	if err != nil {
 		log.Printf("Error deleting thumbnail at %s: %v\n", thumbPath, err)
 	} else {
@@ -539,20 +537,22 @@ func (dbFile *DBFile) beforeInsert(dbr *DBRepository, tx *sql.Tx) error {
 	}
 	// Adding prefix to filename
 	if dbFile.GetValue("filename") != nil && dbFile.GetValue("filename").(string) != "" {
-		dest_path := dbFile.generateObjectPath(nil)
-		from_dir := dbFiles_root_directory + "/" + dbFiles_dest_directory
-		dest_dir := dbFiles_root_directory + "/" + dbFiles_dest_directory
-		if dest_path != "" {
-			dest_dir = dest_dir + "/" + dest_path
-		}
-		// Create destination directory if it does not exist
-		os.MkdirAll(dest_dir, os.FileMode(0755))
 		// Using basename equivalent
 		new_filename := dbFile.generateFilename(dbFile.GetValue("id"), filepath.Base(dbFile.GetValue("filename").(string)))
-		err := os.Rename(from_dir+"/"+dbFile.GetValue("filename").(string), dest_dir+"/"+new_filename)
+		if err := dbr.scanUpload(dbFile.GetValue("filename").(string), ""); err != nil {
+			return err
+		}
+		if err := dbr.checkUploadQuotaWithTx(nil, dbFile, dbFile.GetValue("filename").(string), tx); err != nil {
+			return err
+		}
+		if err := dbr.stripUploadMetadata(dbFile, dbFile.GetValue("filename").(string), tx); err != nil {
+			return err
+		}
+		err := dbFile.storeUpload(dbr, tx, dbFile.GetValue("filename").(string), new_filename)
 		if err != nil {
 			return err
 		}
+		dbFile.newRevision = true
 		if dbFile.GetValue("name") == nil || dbFile.GetValue("name").(string) == "" {
 			dbFile.SetValue("name", filepath.Base(dbFile.GetValue("filename").(string)))
 		}
@@ -561,22 +561,25 @@ func (dbFile *DBFile) beforeInsert(dbr *DBRepository, tx *sql.Tx) error {
 	if dbFile.GetValue("filename") == nil || strings.TrimSpace(dbFile.GetValue("filename").(string)) == "" {
 		// return fmt.Errorf("filename cannot be empty after processing")
 		dbFile.SetValue("filename", "")
+		dbFile.SetValue("size", 0)
 		return nil
 	}
-	// Checksum
-	fullpath := dbFile.GetFullpath(nil)
-	if _, err := os.Stat(fullpath); err == nil {
+	// Checksum and size
+	fullpath := dbFile.GetBlobKey()
+	if info, err := FileStorage.Stat(fullpath); err == nil {
 		// File exists
 		checksum, err := dbFile.computeSHA1(fullpath)
 		if err != nil {
 			return err
 		}
 		dbFile.SetValue("checksum", checksum)
+		dbFile.SetValue("size", info.Size)
 	} else {
 		dbFile.SetValue("checksum", "File '"+dbFile.GetValue("filename").(string)+"' not found!")
+		dbFile.SetValue("size", 0)
 	}
 	// Mime type
-	if _, err := os.Stat(fullpath); err == nil {
+	if _, err := FileStorage.Stat(fullpath); err == nil {
 		mimeType, err := dbFile.detectMimeType(fullpath)
 		if err != nil {
 			return err
@@ -589,6 +592,9 @@ func (dbFile *DBFile) beforeInsert(dbr *DBRepository, tx *sql.Tx) error {
 	if dbFile.IsImage() {
 		dbFile.createThumbnail(fullpath)
 	}
+	if dbFile.newRevision {
+		dbFile.setImageMetadata(fullpath)
+	}
 	return nil
 }
 
@@ -676,22 +682,55 @@ func (dbFile *DBFile) beforeUpdate(dbr *DBRepository, tx *sql.Tx) error {
 		return nil
 	}
 	myself_has_a_file := myself.GetValue("filename") != nil && myself.GetValue("filename").(string) != ""
+	// A new upload is waiting in the storage, unless the filename is the blob itself (legacy file without folder)
+	uploaded := stringValue(dbFile, "filename")
+	new_upload := false
+	if uploaded != "" && !(myself_has_a_file && uploaded == myself.GetBlobKey()) {
+		_, err := FileStorage.Stat(uploaded)
+		new_upload = err == nil
+	}
+	// Scanned and checked against the quotas before the current blob is archived
+	if new_upload {
+		if err := dbr.scanUpload(uploaded, stringValue(dbFile, "id")); err != nil {
+			return err
+		}
+		if err := dbr.checkUploadQuotaWithTx(myself, dbFile, uploaded, tx); err != nil {
+			return err
+		}
+	} else if err := dbr.checkStorageQuotaWithTx(myself, dbFile, myself.Size(), tx); err != nil {
+		// Moved to another owner or group
+		return err
+	}
+	// The blob stays where it is unless a new file is uploaded
+	if key := myself.storageKey(); key != "" && !dbFile.HasValue("storage_key") {
+		dbFile.SetValue("storage_key", key)
+	}
 	// dbFile_has_a_file := dbFile.GetValue("filename") != nil && dbFile.GetValue("filename").(string) != ""
 	// If I had a file and now I don't, delete the old one
-	if myself_has_a_file {
+	if myself_has_a_file && new_upload {
+		// Keep the old blob as a revision
+		if err := dbr.archiveFileRevision(myself, tx); err != nil {
+			return err
+		}
+		// A deduplicated blob keeps its thumbnail, other files may share it
+		if myself.IsImage() && !isContentBlobKey(myself.GetBlobKey()) {
+			myself.deleteThumbnail(myself.GetBlobKey())
+		}
+	} else if myself_has_a_file {
 		// TODO very ugly nesting
 		if dbFile.GetValue("filename") != nil && dbFile.GetValue("filename").(string) != "" && myself.GetValue("filename").(string) != dbFile.GetValue("filename").(string) {
 			// Different filenames ==> delete the old one
-			dest_path := myself.generateObjectPath(nil)
-			dest_dir := dbFiles_root_directory + "/" + dbFiles_dest_directory
-			if dest_path != "" {
-				dest_dir = dest_dir + "/" + dest_path
-			}
-			dest_file := dest_dir + "/" + myself.GetValue("filename").(string)
-			if _, err := os.Stat(dest_file); os.IsNotExist(err) {
+			dest_file := myself.GetBlobKey()
+			if isContentBlobKey(dest_file) {
+				// Drop the reference to the deduplicated blob
+				if err := dbr.releaseBlob(dest_file, tx); err != nil {
+					return err
+				}
+				dbFile.SetValue("storage_key", nil)
+			} else if _, err := FileStorage.Stat(dest_file); os.IsNotExist(err) {
 				// Do nothing
 			} else {
-				err := os.Remove(dest_file)
+				err := FileStorage.Delete(dest_file)
 				if err != nil {
 					return err
 				}
@@ -704,42 +743,27 @@ func (dbFile *DBFile) beforeUpdate(dbr *DBRepository, tx *sql.Tx) error {
 	}
 	// Adding prefix to filename
 	if dbFile.GetValue("filename") != nil && dbFile.GetValue("filename").(string) != "" {
-		from_dir := dbFiles_root_directory + "/" + dbFiles_dest_directory
-		dest_path := dbFile.generateObjectPath(nil)
-		dest_dir := dbFiles_root_directory + "/" + dbFiles_dest_directory
-		if dest_path != "" {
-			dest_dir = dest_dir + "/" + dest_path
-		}
-		// Create destination directory if it does not exist
-		os.MkdirAll(dest_dir, os.FileMode(0755))
-		new_filename := dbFile.generateFilename(dbFile.GetValue("id"), filepath.Base(dbFile.GetValue("filename").(string)))
-		log.Print("DBFile.beforeUpdate: moving file from ", from_dir+"/"+myself.GetValue("filename").(string), " to ", dest_dir+"/"+new_filename)
+		new_filename := dbFile.generateFilename(dbFile.GetValue("id"), filepath.Base(uploaded))
+		log.Print("DBFile.beforeUpdate: moving file from ", uploaded, " as ", new_filename)
 		// Move the file only if it exists
-		if _, err := os.Stat(from_dir + "/" + dbFile.GetValue("filename").(string)); err == nil {
-			err := os.Rename(from_dir+"/"+dbFile.GetValue("filename").(string), dest_dir+"/"+new_filename)
+		if new_upload {
+			if err := dbr.stripUploadMetadata(dbFile, uploaded, tx); err != nil {
+				return err
+			}
+			err := dbFile.storeUpload(dbr, tx, uploaded, new_filename)
 			if err != nil {
 				log.Print("DBFile.beforeUpdate: error renaming file: ", err)
 				return err
 			}
+			dbFile.newRevision = true
 		}
 		dbFile.SetValue("filename", new_filename)
 		// }
-	} else if myself_has_a_file && myself.GetValue("path") != dbFile.GetValue("path") {
+	} else if myself_has_a_file && myself.storageKey() == "" && myself.GetValue("path") != dbFile.GetValue("path") {
 		// if myself_has_a_file && myself.GetValue("path") != dbFile.GetValue("path") {
 		// } else if myself.GetValue("filename") != nil && myself.GetValue("filename").(string) != "" && myself.GetValue("path") != dbFile.GetValue("path") {
-		from_path := myself.generateObjectPath(nil)
-		from_dir := dbFiles_root_directory + "/" + dbFiles_dest_directory
-		if from_path != "" {
-			from_dir = from_dir + "/" + from_path
-		}
-		dest_path := dbFile.generateObjectPath(nil)
-		dest_dir := dbFiles_root_directory + "/" + dbFiles_dest_directory
-		if dest_path != "" {
-			dest_dir = dest_dir + "/" + dest_path
-		}
-		// Create destination directory if it does not exist
-		os.MkdirAll(dest_dir, os.FileMode(0755))
-		err := os.Rename(from_dir+"/"+myself.GetValue("filename").(string), dest_dir+"/"+myself.GetValue("filename").(string))
+		filename := myself.GetValue("filename").(string)
+		err := FileStorage.Rename(myself.legacyKeyOf(filename), dbFile.legacyKeyOf(filename))
 		if err != nil {
 			return err
 		}
@@ -750,40 +774,31 @@ func (dbFile *DBFile) beforeUpdate(dbr *DBRepository, tx *sql.Tx) error {
 		dbFile.SetValue("filename", myself.GetValue("filename"))
 	}
 
-	// Check if father_id has changed and move file accordingly
-	if dbFile.GetValue("father_id") != nil && dbFile.GetValue("father_id") != myself.GetValue("father_id") {
-		from_path := myself.generateObjectPath(nil)
-		from_dir := dbFiles_root_directory + "/" + dbFiles_dest_directory
-		if from_path != "" {
-			from_dir = from_dir + "/" + from_path
-		}
-		dest_path := dbFile.generateObjectPath(nil)
-		dest_dir := dbFiles_root_directory + "/" + dbFiles_dest_directory
-		if dest_path != "" {
-			dest_dir = dest_dir + "/" + dest_path
-		}
-		// Create destination directory if it does not exist
-		os.MkdirAll(dest_dir, os.FileMode(0755))
-		err := os.Rename(from_dir+"/"+myself.GetValue("filename").(string), dest_dir+"/"+myself.GetValue("filename").(string))
+	// Check if father_id has changed and move file accordingly (legacy layout only)
+	if myself.storageKey() == "" && dbFile.storageKey() == "" && dbFile.GetValue("father_id") != nil && dbFile.GetValue("father_id") != myself.GetValue("father_id") {
+		filename := myself.GetValue("filename").(string)
+		err := FileStorage.Rename(myself.legacyKeyOf(filename), dbFile.legacyKeyOf(filename))
 		if err != nil {
 			return err
 		}
 	}
 
-	// Checksum
-	fullpath := dbFile.GetFullpath(nil)
-	if _, err := os.Stat(fullpath); err == nil {
+	// Checksum and size
+	fullpath := dbFile.GetBlobKey()
+	if info, err := FileStorage.Stat(fullpath); err == nil {
 		// File exists
 		checksum, err := dbFile.computeSHA1(fullpath)
 		if err != nil {
 			return err
 		}
 		dbFile.SetValue("checksum", checksum)
+		dbFile.SetValue("size", info.Size)
 	} else {
 		dbFile.SetValue("checksum", "File '"+dbFile.GetValue("filename").(string)+"' not found!")
+		dbFile.SetValue("size", 0)
 	}
 	// Mime type
-	if _, err := os.Stat(fullpath); err == nil {
+	if _, err := FileStorage.Stat(fullpath); err == nil {
 		mimeType, err := dbFile.detectMimeType(fullpath)
 		if err != nil {
 			return err
@@ -796,7 +811,11 @@ func (dbFile *DBFile) beforeUpdate(dbr *DBRepository, tx *sql.Tx) error {
 	if dbFile.IsImage() {
 		dbFile.createThumbnail(fullpath)
 	}
-	return nil
+	if dbFile.newRevision {
+		dbFile.setImageMetadata(fullpath)
+	}
+	// Move the bytes to the new owner, group and size
+	return dbr.moveStorageUsage(myself, dbFile, tx)
 }
 
 // function _before_update(&$dbmgr) {
@@ -888,6 +907,28 @@ func (dbFile *DBFile) beforeUpdate(dbr *DBRepository, tx *sql.Tx) error {
 // 		$this->createThumbnail($_fullpath);
 // }
 
+// afterInsert counts the file in the storage usage and records the revision
+// of the uploaded blob
+func (dbFile *DBFile) afterInsert(dbr *DBRepository, tx *sql.Tx) error {
+	if err := dbr.addStorageUsage(dbFile, 1, tx); err != nil {
+		return err
+	}
+	if !dbFile.newRevision {
+		return nil
+	}
+	dbFile.newRevision = false
+	return dbr.recordFileRevision(dbFile, tx)
+}
+
+// afterUpdate records the revision of the uploaded blob
+func (dbFile *DBFile) afterUpdate(dbr *DBRepository, tx *sql.Tx) error {
+	if !dbFile.newRevision {
+		return nil
+	}
+	dbFile.newRevision = false
+	return dbr.recordFileRevision(dbFile, tx)
+}
+
 func (dbFile *DBFile) beforeDelete(dbr *DBRepository, tx *sql.Tx) error {
 	if dbr.Verbose {
 		log.Print("DBFile.beforeDelete called")
@@ -899,23 +940,31 @@ func (dbFile *DBFile) beforeDelete(dbr *DBRepository, tx *sql.Tx) error {
 		// Use current dbFile values (not from DB, as it will be deleted)
 		if dbFile.GetValue("filename") != nil && dbFile.GetValue("filename").(string) != "" {
 			// ==> delete the file
-			dest_path := dbFile.generateObjectPath(nil)
-			dest_dir := dbFiles_root_directory + "/" + dbFiles_dest_directory
-			if dest_path != "" {
-				dest_dir = dest_dir + "/" + dest_path
-			}
-			fullpath := dest_dir + "/" + dbFile.GetValue("filename").(string)
-			err := os.Remove(fullpath)
-			if err != nil && !os.IsNotExist(err) {
-				log.Printf("Error removing file %s: %v", fullpath, err)
+			fullpath := dbFile.GetBlobKey()
+			if isContentBlobKey(fullpath) {
+				// The blob is deleted with its last reference
+				if err := dbr.releaseBlob(fullpath, tx); err != nil {
+					return err
+				}
 			} else {
-				log.Printf("Deleted file at %s", fullpath)
-			}
-			// Image
-			if dbFile.IsImage() {
-				dbFile.deleteThumbnail(fullpath)
+				err := FileStorage.Delete(fullpath)
+				if err != nil && !os.IsNotExist(err) {
+					log.Printf("Error removing file %s: %v", fullpath, err)
+				} else {
+					log.Printf("Deleted file at %s", fullpath)
+				}
+				// Image
+				if dbFile.IsImage() {
+					dbFile.deleteThumbnail(fullpath)
+				}
 			}
 		}
+		if err := dbr.deleteFileRevisions(dbFile, tx); err != nil {
+			return err
+		}
+		if err := dbr.addStorageUsage(dbFile, -1, tx); err != nil {
+			return err
+		}
 	}
 
 	err := dbFile.DBObject.beforeDelete(dbr, tx)
@@ -954,6 +1003,228 @@ func (dbFile *DBFile) beforeDelete(dbr *DBRepository, tx *sql.Tx) error {
 // 	}
 // }
 
+// FileRevision is an upload of a DBFile, see filerevisions.go. The blob of
+// the current revision is the blob of the file (empty storage_key), the
+// older revisions are archived under revisions/.
+type FileRevision struct {
+	DBEntity
+}
+
+func NewFileRevision() *FileRevision {
+	columns := []Column{
+		{Name: "id", Type: "varchar(16)", Constraints: []string{"NOT NULL"}},
+		{Name: "file_id", Type: "varchar(16)", Constraints: []string{"NOT NULL"}},
+		{Name: "revision", Type: "int(11)", Constraints: []string{"NOT NULL"}},
+		{Name: "storage_key", Type: "varchar(255)", Constraints: []string{}},
+		{Name: "filename", Type: "varchar(255)", Constraints: []string{"NOT NULL"}},
+		{Name: "checksum", Type: "char(40)", Constraints: []string{}},
+		{Name: "mime", Type: "varchar(255)", Constraints: []string{}},
+		{Name: "size", Type: "bigint(20)", Constraints: []string{"NOT NULL"}},
+		{Name: "uploader", Type: "varchar(16)", Constraints: []string{}},
+		{Name: "upload_date", Type: "datetime", Constraints: []string{}},
+	}
+	keys := []string{"id"}
+	foreignKeys := []ForeignKey{
+		{Column: "file_id", RefTable: "files", RefColumn: "id"},
+		{Column: "uploader", RefTable: "users", RefColumn: "id"},
+	}
+	return &FileRevision{
+		DBEntity: *NewDBEntity(
+			"FileRevision",
+			"files_revisions",
+			columns,
+			keys,
+			foreignKeys,
+			make(map[string]any),
+		),
+	}
+}
+func (fileRevision *FileRevision) NewInstance() DBEntityInterface {
+	return NewFileRevision()
+}
+
+func (fileRevision *FileRevision) GetOrderBy() []string {
+	return []string{"revision DESC"}
+}
+
+func (fileRevision *FileRevision) beforeInsert(dbr *DBRepository, tx *sql.Tx) error {
+	revisionID, _ := uuid16HexGo()
+	fileRevision.SetValue("id", revisionID)
+	if dbr.DbContext != nil && dbr.DbContext.UserID != "" && !fileRevision.HasValue("uploader") {
+		fileRevision.SetValue("uploader", dbr.DbContext.UserID)
+	}
+	if !fileRevision.HasValue("upload_date") {
+		fileRevision.SetValue("upload_date", CurrentDateTimeString())
+	}
+	return nil
+}
+
+// FileBlob is a deduplicated blob of the content layout, see fileblobs.go:
+// refs counts the files and the archived revisions sharing it
+type FileBlob struct {
+	DBEntity
+}
+
+func NewFileBlob() *FileBlob {
+	columns := []Column{
+		{Name: "checksum", Type: "char(40)", Constraints: []string{"NOT NULL"}},
+		{Name: "storage_key", Type: "varchar(255)", Constraints: []string{"NOT NULL"}},
+		{Name: "size", Type: "bigint(20)", Constraints: []string{"NOT NULL"}},
+		{Name: "refs", Type: "int(11)", Constraints: []string{"NOT NULL"}},
+		{Name: "creation_date", Type: "datetime", Constraints: []string{}},
+	}
+	keys := []string{"checksum"}
+	foreignKeys := []ForeignKey{}
+	return &FileBlob{
+		DBEntity: *NewDBEntity(
+			"FileBlob",
+			"files_blobs",
+			columns,
+			keys,
+			foreignKeys,
+			make(map[string]any),
+		),
+	}
+}
+func (fileBlob *FileBlob) NewInstance() DBEntityInterface {
+	return NewFileBlob()
+}
+
+// FileUpload is a resumable (tus) upload in progress, see fileuploads.go:
+// the received chunks become a DBFile once upload_offset reaches
+// upload_length
+type FileUpload struct {
+	DBEntity
+}
+
+func NewFileUpload() *FileUpload {
+	columns := []Column{
+		{Name: "id", Type: "varchar(16)", Constraints: []string{"NOT NULL"}},
+		{Name: "owner", Type: "varchar(16)", Constraints: []string{"NOT NULL"}},
+		{Name: "upload_length", Type: "bigint(20)", Constraints: []string{"NOT NULL"}},
+		{Name: "upload_offset", Type: "bigint(20)", Constraints: []string{"NOT NULL"}},
+		{Name: "chunks", Type: "int(11)", Constraints: []string{"NOT NULL"}},
+		{Name: "metadata", Type: "text", Constraints: []string{}},
+		{Name: "file_id", Type: "varchar(16)", Constraints: []string{}},
+		{Name: "creation_date", Type: "datetime", Constraints: []string{}},
+		{Name: "expires", Type: "datetime", Constraints: []string{}},
+	}
+	keys := []string{"id"}
+	foreignKeys := []ForeignKey{
+		{Column: "owner", RefTable: "users", RefColumn: "id"},
+		{Column: "file_id", RefTable: "files", RefColumn: "id"},
+	}
+	return &FileUpload{
+		DBEntity: *NewDBEntity(
+			"FileUpload",
+			"files_uploads",
+			columns,
+			keys,
+			foreignKeys,
+			make(map[string]any),
+		),
+	}
+}
+func (fileUpload *FileUpload) NewInstance() DBEntityInterface {
+	return NewFileUpload()
+}
+
+func (fileUpload *FileUpload) beforeInsert(dbr *DBRepository, tx *sql.Tx) error {
+	uploadID, _ := uuid16HexGo()
+	fileUpload.SetValue("id", uploadID)
+	if dbr.DbContext != nil && dbr.DbContext.UserID != "" {
+		fileUpload.SetValue("owner", dbr.DbContext.UserID)
+	}
+	fileUpload.SetValue("upload_offset", 0)
+	fileUpload.SetValue("chunks", 0)
+	fileUpload.SetValue("creation_date", CurrentDateTimeString())
+	return nil
+}
+
+// FileScan is an infected blob found by the scanner, see scanner.go: an
+// upload rejected or quarantined, or a stored blob found by a rescan
+type FileScan struct {
+	DBEntity
+}
+
+func NewFileScan() *FileScan {
+	columns := []Column{
+		{Name: "id", Type: "varchar(16)", Constraints: []string{"NOT NULL"}},
+		{Name: "scan_date", Type: "datetime", Constraints: []string{"NOT NULL"}},
+		{Name: "user_id", Type: "varchar(16)", Constraints: []string{}},
+		{Name: "file_id", Type: "varchar(16)", Constraints: []string{}},
+		{Name: "filename", Type: "varchar(255)", Constraints: []string{"NOT NULL"}},
+		{Name: "checksum", Type: "varchar(40)", Constraints: []string{}},
+		{Name: "size", Type: "bigint(20)", Constraints: []string{}},
+		{Name: "signature", Type: "varchar(255)", Constraints: []string{"NOT NULL"}},
+		{Name: "action", Type: "varchar(16)", Constraints: []string{"NOT NULL"}},
+		{Name: "quarantine_key", Type: "varchar(255)", Constraints: []string{}},
+	}
+	keys := []string{"id"}
+	foreignKeys := []ForeignKey{
+		{Column: "user_id", RefTable: "users", RefColumn: "id"},
+		{Column: "file_id", RefTable: "files", RefColumn: "id"},
+	}
+	return &FileScan{
+		DBEntity: *NewDBEntity(
+			"FileScan",
+			"files_scans",
+			columns,
+			keys,
+			foreignKeys,
+			make(map[string]any),
+		),
+	}
+}
+func (fileScan *FileScan) NewInstance() DBEntityInterface {
+	return NewFileScan()
+}
+
+func (fileScan *FileScan) beforeInsert(dbr *DBRepository, tx *sql.Tx) error {
+	// The id names the quarantined blob too
+	if !fileScan.HasValue("id") {
+		scanID, _ := uuid16HexGo()
+		fileScan.SetValue("id", scanID)
+	}
+	if dbr.DbContext != nil && dbr.DbContext.UserID != "" {
+		fileScan.SetValue("user_id", dbr.DbContext.UserID)
+	}
+	fileScan.SetValue("scan_date", CurrentDateTimeString())
+	return nil
+}
+
+// StorageUsage counts the bytes and the files stored by a user (kind U) or a
+// group (kind G), see storageusage.go: quota overrides the default of the
+// configuration when not NULL
+type StorageUsage struct {
+	DBEntity
+}
+
+func NewStorageUsage() *StorageUsage {
+	columns := []Column{
+		{Name: "kind", Type: "char(1)", Constraints: []string{"NOT NULL"}},
+		{Name: "subject_id", Type: "varchar(16)", Constraints: []string{"NOT NULL"}},
+		{Name: "bytes", Type: "bigint(20)", Constraints: []string{"NOT NULL"}},
+		{Name: "files", Type: "int(11)", Constraints: []string{"NOT NULL"}},
+		{Name: "quota", Type: "bigint(20)", Constraints: []string{}},
+	}
+	keys := []string{"kind", "subject_id"}
+	foreignKeys := []ForeignKey{}
+	return &StorageUsage{
+		DBEntity: *NewDBEntity(
+			"StorageUsage",
+			"storage_usage",
+			columns,
+			keys,
+			foreignKeys,
+			make(map[string]any),
+		),
+	}
+}
+func (storageUsage *StorageUsage) NewInstance() DBEntityInterface {
+	return NewStorageUsage()
+}
+
 /*
 CREATE TABLE IF NOT EXISTS `rra_folders` (
 
@@ -1002,6 +1273,10 @@ func NewDBFolder() *DBFolder {
 		{Name: "description", Type: "text", Constraints: []string{}},
 		{Name: "fk_obj_id", Type: "varchar(16)", Constraints: []string{}},
 		{Name: "childs_sort_order", Type: "text", Constraints: []string{}},
+		// Revisions kept for each file of the folder, NULL or 0 = all
+		{Name: "file_revisions", Type: "int(11)", Constraints: []string{}},
+		// Metadata stripped from the JPEG uploaded in the folder, see ExifPolicies
+		{Name: "exif_policy", Type: "varchar(16)", Constraints: []string{}},
 	}
 	keys := []string{"id"}
 	foreignKeys := []ForeignKey{
@@ -1072,6 +1347,9 @@ func (dbFolder *DBFolder) beforeInsert(dbr *DBRepository, tx *sql.Tx) error {
 	if err != nil {
 		return err
 	}
+	if err := validateExifPolicy(dbFolder); err != nil {
+		return err
+	}
 	// This seems redundant with SetDefaultValues, but keeping it for compatibility
 	// I don't know, it seems to hide the effects of SetDefaultValues... maybe it should be removed?
 	if !dbFolder.HasValue("father_id") || dbFolder.GetValue("father_id") == "" || dbFolder.GetValue("father_id") == "0" {
@@ -1099,6 +1377,9 @@ func (dbFile *DBFolder) beforeUpdate(dbr *DBRepository, tx *sql.Tx) error {
 	if err != nil {
 		return err
 	}
+	if err := validateExifPolicy(dbFile); err != nil {
+		return err
+	}
 	// This seems redundant with SetDefaultValues, but keeping it for compatibility
 	// I don't know, it seems to hide the effects of SetDefaultValues... maybe it should be removed?
 	if !dbFile.HasValue("father_id") || dbFile.GetValue("father_id") == "" || dbFile.GetValue("father_id") == "0" {
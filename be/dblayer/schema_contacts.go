@@ -1,5 +1,10 @@
 package dblayer
 
+import (
+	"database/sql"
+	"log"
+)
+
 /*
 CREATE TABLE IF NOT EXISTS `rra_countrylist` (
 
@@ -60,17 +65,14 @@ func NewDBCountry() DBEntityInterface {
 	}
 }
 func (dbCountry *DBCountry) NewInstance() DBEntityInterface {
-	columns := make([]Column, 0, len(dbCountry.columns))
-	for _, col := range dbCountry.columns {
-		columns = append(columns, col)
-	}
 	return &DBCountry{
 		DBEntity: DBEntity{
-			typename:   dbCountry.typename,
-			tablename:  dbCountry.tablename,
-			columns:    dbCountry.columns,
-			keys:       dbCountry.keys,
-			dictionary: make(map[string]any),
+			typename:    dbCountry.typename,
+			tablename:   dbCountry.tablename,
+			columns:     dbCountry.columns,
+			columnNames: dbCountry.columnNames,
+			keys:        dbCountry.keys,
+			dictionary:  make(map[string]any),
 		},
 	}
 }
@@ -167,6 +169,29 @@ func (dbCompany *DBCompany) NewInstance() DBEntityInterface {
 	return NewDBCompany()
 }
 
+func (dbCompany *DBCompany) beforeInsert(dbr *DBRepository, tx *sql.Tx) error {
+	if err := dbCompany.DBObject.beforeInsert(dbr, tx); err != nil {
+		return err
+	}
+	return validateFiscalIdentifiers(dbCompany)
+}
+
+func (dbCompany *DBCompany) beforeUpdate(dbr *DBRepository, tx *sql.Tx) error {
+	if err := dbCompany.DBObject.beforeUpdate(dbr, tx); err != nil {
+		return err
+	}
+	return validateFiscalIdentifiers(dbCompany)
+}
+
+func (dbCompany *DBCompany) beforeDelete(dbr *DBRepository, tx *sql.Tx) error {
+	hardDelete := dbCompany.HasDeletedDate()
+	err := dbCompany.DBObject.beforeDelete(dbr, tx)
+	if err != nil || !hardDelete {
+		return err
+	}
+	return deleteContactUID(dbr, tx, dbCompany.GetValue("id"))
+}
+
 /*
 CREATE TABLE IF NOT EXISTS `rra_people` (
 
@@ -270,3 +295,154 @@ func NewDBPerson() *DBPerson {
 func (dbPerson *DBPerson) NewInstance() DBEntityInterface {
 	return NewDBPerson()
 }
+
+func (dbPerson *DBPerson) beforeInsert(dbr *DBRepository, tx *sql.Tx) error {
+	if err := dbPerson.DBObject.beforeInsert(dbr, tx); err != nil {
+		return err
+	}
+	return validateFiscalIdentifiers(dbPerson)
+}
+
+func (dbPerson *DBPerson) beforeUpdate(dbr *DBRepository, tx *sql.Tx) error {
+	if err := dbPerson.DBObject.beforeUpdate(dbr, tx); err != nil {
+		return err
+	}
+	return validateFiscalIdentifiers(dbPerson)
+}
+
+func (dbPerson *DBPerson) beforeDelete(dbr *DBRepository, tx *sql.Tx) error {
+	hardDelete := dbPerson.HasDeletedDate()
+	err := dbPerson.DBObject.beforeDelete(dbr, tx)
+	if err != nil || !hardDelete {
+		return err
+	}
+	return deleteContactUID(dbr, tx, dbPerson.GetValue("id"))
+}
+
+/*
+CREATE TABLE `rprj_contacts_uids` (
+
+	`uid` varchar(255) NOT NULL,
+	`contact_id` varchar(16) NOT NULL,
+	`href` varchar(255) DEFAULT NULL,
+	`creation_date` datetime DEFAULT NULL,
+	PRIMARY KEY (`uid`),
+	KEY `rprj_contacts_uids_0` (`contact_id`),
+	KEY `rprj_contacts_uids_1` (`href`)
+
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;
+*/
+// ContactUID maps the vCard UID and the CardDAV resource name chosen by a
+// client to a DBPerson or DBCompany. Contacts created in rhobee use
+// "<id>@rhobee" and "<id>.vcf" and need no mapping.
+type ContactUID struct {
+	DBEntity
+}
+
+func NewContactUID() *ContactUID {
+	columns := []Column{
+		{Name: "uid", Type: "varchar(255)", Constraints: []string{"NOT NULL"}},
+		{Name: "contact_id", Type: "varchar(16)", Constraints: []string{"NOT NULL"}},
+		{Name: "href", Type: "varchar(255)", Constraints: []string{}},
+		{Name: "creation_date", Type: "datetime", Constraints: []string{}},
+	}
+	keys := []string{"uid"}
+	return &ContactUID{
+		DBEntity: *NewDBEntity(
+			"ContactUID",
+			"contacts_uids",
+			columns,
+			keys,
+			[]ForeignKey{},
+			make(map[string]any),
+		),
+	}
+}
+func (contactUID *ContactUID) NewInstance() DBEntityInterface {
+	return NewContactUID()
+}
+
+func (contactUID *ContactUID) beforeInsert(dbr *DBRepository, tx *sql.Tx) error {
+	contactUID.SetValue("creation_date", CurrentDateTimeString())
+	return nil
+}
+
+// deleteContactUID forgets the UID of a hard deleted contact
+func deleteContactUID(dbr *DBRepository, tx *sql.Tx, contactID any) error {
+	query := "DELETE FROM " + dbr.buildTableName(NewContactUID()) + " WHERE contact_id = ?"
+	if _, err := tx.Exec(query, contactID); err != nil {
+		log.Print("deleteContactUID: error deleting the contact UID:", err)
+		return err
+	}
+	return nil
+}
+
+/*
+CREATE TABLE `rprj_contacts_merges` (
+
+	`id` varchar(16) NOT NULL,
+	`survivor_id` varchar(16) NOT NULL,
+	`merged_id` varchar(16) NOT NULL,
+	`merged_data` text,
+	`changes` text,
+	`moved_references` text,
+	`creator` varchar(16) DEFAULT NULL,
+	`creation_date` datetime DEFAULT NULL,
+	PRIMARY KEY (`id`),
+	KEY `rprj_contacts_merges_0` (`survivor_id`),
+	KEY `rprj_contacts_merges_1` (`merged_id`)
+
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;
+*/
+// ContactMerge records the merge of a duplicate person into a survivor:
+// the values of the merged person, the fields changed on the survivor and
+// the references moved to it, all as JSON
+type ContactMerge struct {
+	DBEntity
+}
+
+func NewContactMerge() *ContactMerge {
+	columns := []Column{
+		{Name: "id", Type: "varchar(16)", Constraints: []string{"NOT NULL"}},
+		{Name: "survivor_id", Type: "varchar(16)", Constraints: []string{"NOT NULL"}},
+		{Name: "merged_id", Type: "varchar(16)", Constraints: []string{"NOT NULL"}},
+		{Name: "merged_data", Type: "text", Constraints: []string{}},
+		{Name: "changes", Type: "text", Constraints: []string{}},
+		{Name: "moved_references", Type: "text", Constraints: []string{}},
+		{Name: "creator", Type: "varchar(16)", Constraints: []string{}},
+		{Name: "creation_date", Type: "datetime", Constraints: []string{}},
+	}
+	keys := []string{"id"}
+	foreignKeys := []ForeignKey{
+		{Column: "survivor_id", RefTable: "people", RefColumn: "id"},
+		{Column: "merged_id", RefTable: "people", RefColumn: "id"},
+		{Column: "creator", RefTable: "users", RefColumn: "id"},
+	}
+	return &ContactMerge{
+		DBEntity: *NewDBEntity(
+			"ContactMerge",
+			"contacts_merges",
+			columns,
+			keys,
+			foreignKeys,
+			make(map[string]any),
+		),
+	}
+}
+func (contactMerge *ContactMerge) NewInstance() DBEntityInterface {
+	return NewContactMerge()
+}
+
+func (contactMerge *ContactMerge) GetOrderBy() []string {
+	return []string{"creation_date DESC"}
+}
+
+func (contactMerge *ContactMerge) beforeInsert(dbr *DBRepository, tx *sql.Tx) error {
+	mergeID, _ := uuid16HexGo()
+	contactMerge.SetValue("id", mergeID)
+	if dbr.DbContext != nil && dbr.DbContext.UserID != "" {
+		contactMerge.SetValue("creator", dbr.DbContext.UserID)
+	}
+	contactMerge.SetValue("creation_date", CurrentDateTimeString())
+	return nil
+}
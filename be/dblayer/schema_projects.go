@@ -0,0 +1,601 @@
+package dblayer
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+)
+
+/*
+CREATE TABLE `rprj_projects` (
+
+	`id` varchar(16) NOT NULL,
+	`owner` varchar(16) NOT NULL,
+	`group_id` varchar(16) NOT NULL,
+	`permissions` char(9) NOT NULL DEFAULT 'rwx------',
+	`creator` varchar(16) NOT NULL,
+	`creation_date` datetime DEFAULT NULL,
+	`last_modify` varchar(16) NOT NULL,
+	`last_modify_date` datetime DEFAULT NULL,
+	`deleted_by` varchar(16) DEFAULT NULL,
+	`deleted_date` datetime default null,
+	`father_id` varchar(16) DEFAULT NULL,
+	`name` varchar(255) NOT NULL,
+	`description` text DEFAULT NULL,
+	PRIMARY KEY (`id`),
+	KEY `rprj_projects_0` (`id`),
+	KEY `rprj_projects_1` (`owner`),
+	KEY `rprj_projects_2` (`group_id`),
+	KEY `rprj_projects_3` (`creator`),
+	KEY `rprj_projects_4` (`last_modify`),
+	KEY `rprj_projects_5` (`deleted_by`),
+	KEY `rprj_projects_6` (`father_id`)
+
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;
+*/
+type DBProject struct {
+	DBObject
+}
+
+func NewDBProject() *DBProject {
+	columns := []Column{
+		{Name: "id", Type: "varchar(16)", Constraints: []string{"NOT NULL"}},
+		{Name: "owner", Type: "varchar(16)", Constraints: []string{"NOT NULL"}},
+		{Name: "group_id", Type: "varchar(16)", Constraints: []string{"NOT NULL"}},
+		{Name: "permissions", Type: "varchar(9)", Constraints: []string{"NOT NULL"}},
+		{Name: "creator", Type: "varchar(16)", Constraints: []string{"NOT NULL"}},
+		{Name: "creation_date", Type: "datetime", Constraints: []string{}},
+		{Name: "last_modify", Type: "varchar(16)", Constraints: []string{"NOT NULL"}},
+		{Name: "last_modify_date", Type: "datetime", Constraints: []string{}},
+		{Name: "deleted_by", Type: "varchar(16)", Constraints: []string{}},
+		{Name: "deleted_date", Type: "datetime", Constraints: []string{}},
+		{Name: "father_id", Type: "varchar(16)", Constraints: []string{}},
+		{Name: "name", Type: "varchar(255)", Constraints: []string{"NOT NULL"}},
+		{Name: "description", Type: "text", Constraints: []string{}},
+	}
+	keys := []string{"id"}
+	foreignKeys := []ForeignKey{
+		{Column: "owner", RefTable: "users", RefColumn: "id"},
+		{Column: "group_id", RefTable: "groups", RefColumn: "id"},
+		{Column: "creator", RefTable: "users", RefColumn: "id"},
+		{Column: "last_modify", RefTable: "users", RefColumn: "id"},
+		{Column: "deleted_by", RefTable: "users", RefColumn: "id"},
+		{Column: "father_id", RefTable: "objects", RefColumn: "id"},
+	}
+	return &DBProject{
+		DBObject: DBObject{
+			DBEntity: *NewDBEntity(
+				"DBProject",
+				"projects",
+				columns,
+				keys,
+				foreignKeys,
+				make(map[string]any),
+			),
+		},
+	}
+}
+func (dbProject *DBProject) NewInstance() DBEntityInterface {
+	return NewDBProject()
+}
+
+func (dbProject *DBProject) beforeDelete(dbr *DBRepository, tx *sql.Tx) error {
+	// Hard delete: drop the associations with people, companies and other projects
+	hardDelete := dbProject.HasDeletedDate()
+	err := dbProject.DBObject.beforeDelete(dbr, tx)
+	if err != nil || !hardDelete {
+		return err
+	}
+	projectID, ok := dbProject.GetValue("id").(string)
+	if !ok || projectID == "" {
+		return nil
+	}
+	statements := []struct {
+		query string
+		args  []interface{}
+	}{
+		{"DELETE FROM " + dbr.buildTableName(NewProjectPerson()) + " WHERE project_id=?", []interface{}{projectID}},
+		{"DELETE FROM " + dbr.buildTableName(NewProjectCompany()) + " WHERE project_id=?", []interface{}{projectID}},
+		{"DELETE FROM " + dbr.buildTableName(NewProjectProject()) + " WHERE project_id=? OR project2_id=?", []interface{}{projectID, projectID}},
+	}
+	for _, stmt := range statements {
+		if dbr.Verbose {
+			log.Print("DBProject.beforeDelete: query=", stmt.query)
+		}
+		if _, err := tx.Exec(stmt.query, stmt.args...); err != nil {
+			log.Print("DBProject.beforeDelete: error deleting associations:", err)
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+The role tables share the same structure:
+
+CREATE TABLE `rprj_projects_people_roles` (
+
+	`id` varchar(16) NOT NULL,
+	`owner` varchar(16) NOT NULL,
+	`group_id` varchar(16) NOT NULL,
+	`permissions` char(9) NOT NULL DEFAULT 'rwx------',
+	`creator` varchar(16) NOT NULL,
+	`creation_date` datetime DEFAULT NULL,
+	`last_modify` varchar(16) NOT NULL,
+	`last_modify_date` datetime DEFAULT NULL,
+	`deleted_by` varchar(16) DEFAULT NULL,
+	`deleted_date` datetime default null,
+	`father_id` varchar(16) DEFAULT NULL,
+	`name` varchar(255) NOT NULL,
+	`description` text DEFAULT NULL,
+	`order_position` int(11) DEFAULT 0,
+	PRIMARY KEY (`id`),
+	KEY `rprj_projects_people_roles_0` (`id`)
+
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;
+*/
+func newLookupEntity(typename string, tablename string) DBEntity {
+	columns := []Column{
+		{Name: "id", Type: "varchar(16)", Constraints: []string{"NOT NULL"}},
+		{Name: "owner", Type: "varchar(16)", Constraints: []string{"NOT NULL"}},
+		{Name: "group_id", Type: "varchar(16)", Constraints: []string{"NOT NULL"}},
+		{Name: "permissions", Type: "varchar(9)", Constraints: []string{"NOT NULL"}},
+		{Name: "creator", Type: "varchar(16)", Constraints: []string{"NOT NULL"}},
+		{Name: "creation_date", Type: "datetime", Constraints: []string{}},
+		{Name: "last_modify", Type: "varchar(16)", Constraints: []string{"NOT NULL"}},
+		{Name: "last_modify_date", Type: "datetime", Constraints: []string{}},
+		{Name: "deleted_by", Type: "varchar(16)", Constraints: []string{}},
+		{Name: "deleted_date", Type: "datetime", Constraints: []string{}},
+		{Name: "father_id", Type: "varchar(16)", Constraints: []string{}},
+		{Name: "name", Type: "varchar(255)", Constraints: []string{"NOT NULL"}},
+		{Name: "description", Type: "text", Constraints: []string{}},
+		{Name: "order_position", Type: "int(11)", Constraints: []string{"DEFAULT 0"}},
+	}
+	keys := []string{"id"}
+	// No father_id FK: lookup tables are not children of other objects
+	foreignKeys := []ForeignKey{
+		{Column: "owner", RefTable: "users", RefColumn: "id"},
+		{Column: "group_id", RefTable: "groups", RefColumn: "id"},
+		{Column: "creator", RefTable: "users", RefColumn: "id"},
+		{Column: "last_modify", RefTable: "users", RefColumn: "id"},
+		{Column: "deleted_by", RefTable: "users", RefColumn: "id"},
+	}
+	return *NewDBEntity(typename, tablename, columns, keys, foreignKeys, make(map[string]any))
+}
+
+// DBProjectPeopleRole is the role of a person in a project (e.g. "Project Manager")
+type DBProjectPeopleRole struct {
+	DBObject
+}
+
+func NewDBProjectPeopleRole() *DBProjectPeopleRole {
+	return &DBProjectPeopleRole{
+		DBObject: DBObject{DBEntity: newLookupEntity("DBProjectPeopleRole", "projects_people_roles")},
+	}
+}
+func (dbRole *DBProjectPeopleRole) NewInstance() DBEntityInterface {
+	return NewDBProjectPeopleRole()
+}
+func (dbRole *DBProjectPeopleRole) GetOrderBy() []string {
+	return []string{"order_position", "name"}
+}
+
+// DBProjectCompanyRole is the role of a company in a project (e.g. "Customer")
+type DBProjectCompanyRole struct {
+	DBObject
+}
+
+func NewDBProjectCompanyRole() *DBProjectCompanyRole {
+	return &DBProjectCompanyRole{
+		DBObject: DBObject{DBEntity: newLookupEntity("DBProjectCompanyRole", "projects_companies_roles")},
+	}
+}
+func (dbRole *DBProjectCompanyRole) NewInstance() DBEntityInterface {
+	return NewDBProjectCompanyRole()
+}
+func (dbRole *DBProjectCompanyRole) GetOrderBy() []string {
+	return []string{"order_position", "name"}
+}
+
+// DBProjectProjectRole is the relation between two projects (e.g. "Depends on")
+type DBProjectProjectRole struct {
+	DBObject
+}
+
+func NewDBProjectProjectRole() *DBProjectProjectRole {
+	return &DBProjectProjectRole{
+		DBObject: DBObject{DBEntity: newLookupEntity("DBProjectProjectRole", "projects_projects_roles")},
+	}
+}
+func (dbRole *DBProjectProjectRole) NewInstance() DBEntityInterface {
+	return NewDBProjectProjectRole()
+}
+func (dbRole *DBProjectProjectRole) GetOrderBy() []string {
+	return []string{"order_position", "name"}
+}
+
+// DBTodoTipo is the type of a todo (e.g. "Bug", "Feature")
+type DBTodoTipo struct {
+	DBObject
+}
+
+func NewDBTodoTipo() *DBTodoTipo {
+	return &DBTodoTipo{
+		DBObject: DBObject{DBEntity: newLookupEntity("DBTodoTipo", "todo_tipo")},
+	}
+}
+func (dbTodoTipo *DBTodoTipo) NewInstance() DBEntityInterface {
+	return NewDBTodoTipo()
+}
+func (dbTodoTipo *DBTodoTipo) GetOrderBy() []string {
+	return []string{"order_position", "name"}
+}
+
+/*
+CREATE TABLE `rprj_projects_people` (
+
+	`project_id` varchar(16) NOT NULL DEFAULT '',
+	`people_id` varchar(16) NOT NULL DEFAULT '',
+	`projects_people_role_id` varchar(16) NOT NULL DEFAULT '',
+	PRIMARY KEY (`project_id`,`people_id`,`projects_people_role_id`)
+
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;
+*/
+type ProjectPerson struct {
+	DBEntity
+}
+
+func NewProjectPerson() *ProjectPerson {
+	columns := []Column{
+		{Name: "project_id", Type: "varchar(16)", Constraints: []string{"NOT NULL"}},
+		{Name: "people_id", Type: "varchar(16)", Constraints: []string{"NOT NULL"}},
+		{Name: "projects_people_role_id", Type: "varchar(16)", Constraints: []string{"NOT NULL"}},
+	}
+	keys := []string{"project_id", "people_id", "projects_people_role_id"}
+	foreignKeys := []ForeignKey{
+		{Column: "project_id", RefTable: "projects", RefColumn: "id"},
+		{Column: "people_id", RefTable: "people", RefColumn: "id"},
+		{Column: "projects_people_role_id", RefTable: "projects_people_roles", RefColumn: "id"},
+	}
+	return &ProjectPerson{
+		DBEntity: *NewDBEntity(
+			"ProjectPerson",
+			"projects_people",
+			columns,
+			keys,
+			foreignKeys,
+			make(map[string]any),
+		),
+	}
+}
+func (projectPerson *ProjectPerson) NewInstance() DBEntityInterface {
+	return NewProjectPerson()
+}
+
+/*
+CREATE TABLE `rprj_projects_companies` (
+
+	`project_id` varchar(16) NOT NULL DEFAULT '',
+	`company_id` varchar(16) NOT NULL DEFAULT '',
+	`projects_companies_role_id` varchar(16) NOT NULL DEFAULT '',
+	PRIMARY KEY (`project_id`,`company_id`,`projects_companies_role_id`)
+
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;
+*/
+type ProjectCompany struct {
+	DBEntity
+}
+
+func NewProjectCompany() *ProjectCompany {
+	columns := []Column{
+		{Name: "project_id", Type: "varchar(16)", Constraints: []string{"NOT NULL"}},
+		{Name: "company_id", Type: "varchar(16)", Constraints: []string{"NOT NULL"}},
+		{Name: "projects_companies_role_id", Type: "varchar(16)", Constraints: []string{"NOT NULL"}},
+	}
+	keys := []string{"project_id", "company_id", "projects_companies_role_id"}
+	foreignKeys := []ForeignKey{
+		{Column: "project_id", RefTable: "projects", RefColumn: "id"},
+		{Column: "company_id", RefTable: "companies", RefColumn: "id"},
+		{Column: "projects_companies_role_id", RefTable: "projects_companies_roles", RefColumn: "id"},
+	}
+	return &ProjectCompany{
+		DBEntity: *NewDBEntity(
+			"ProjectCompany",
+			"projects_companies",
+			columns,
+			keys,
+			foreignKeys,
+			make(map[string]any),
+		),
+	}
+}
+func (projectCompany *ProjectCompany) NewInstance() DBEntityInterface {
+	return NewProjectCompany()
+}
+
+/*
+CREATE TABLE `rprj_projects_projects` (
+
+	`project_id` varchar(16) NOT NULL DEFAULT '',
+	`project2_id` varchar(16) NOT NULL DEFAULT '',
+	`projects_projects_role_id` varchar(16) NOT NULL DEFAULT '',
+	PRIMARY KEY (`project_id`,`project2_id`,`projects_projects_role_id`)
+
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;
+*/
+type ProjectProject struct {
+	DBEntity
+}
+
+func NewProjectProject() *ProjectProject {
+	columns := []Column{
+		{Name: "project_id", Type: "varchar(16)", Constraints: []string{"NOT NULL"}},
+		{Name: "project2_id", Type: "varchar(16)", Constraints: []string{"NOT NULL"}},
+		{Name: "projects_projects_role_id", Type: "varchar(16)", Constraints: []string{"NOT NULL"}},
+	}
+	keys := []string{"project_id", "project2_id", "projects_projects_role_id"}
+	foreignKeys := []ForeignKey{
+		{Column: "project_id", RefTable: "projects", RefColumn: "id"},
+		{Column: "project2_id", RefTable: "projects", RefColumn: "id"},
+		{Column: "projects_projects_role_id", RefTable: "projects_projects_roles", RefColumn: "id"},
+	}
+	return &ProjectProject{
+		DBEntity: *NewDBEntity(
+			"ProjectProject",
+			"projects_projects",
+			columns,
+			keys,
+			foreignKeys,
+			make(map[string]any),
+		),
+	}
+}
+func (projectProject *ProjectProject) NewInstance() DBEntityInterface {
+	return NewProjectProject()
+}
+
+/*
+CREATE TABLE `rprj_timetracks` (
+
+	`id` varchar(16) NOT NULL,
+	`owner` varchar(16) NOT NULL,
+	`group_id` varchar(16) NOT NULL,
+	`permissions` char(9) NOT NULL DEFAULT 'rwx------',
+	`creator` varchar(16) NOT NULL,
+	`creation_date` datetime DEFAULT NULL,
+	`last_modify` varchar(16) NOT NULL,
+	`last_modify_date` datetime DEFAULT NULL,
+	`deleted_by` varchar(16) DEFAULT NULL,
+	`deleted_date` datetime default null,
+	`father_id` varchar(16) DEFAULT NULL,
+	`name` varchar(255) NOT NULL,
+	`description` text DEFAULT NULL,
+	`fk_obj_id` varchar(16) DEFAULT NULL,
+	`fk_progetto` varchar(16) DEFAULT NULL,
+	`dalle_ore` datetime DEFAULT NULL,
+	`alle_ore` datetime DEFAULT NULL,
+	`ore_intervento` datetime DEFAULT NULL,
+	`ore_viaggio` datetime DEFAULT NULL,
+	`km_viaggio` int(11) NOT NULL DEFAULT 0,
+	`luogo_di_intervento` int(11) NOT NULL DEFAULT 0,
+	`stato` int(11) NOT NULL DEFAULT 0,
+	`costo_per_ora` float NOT NULL DEFAULT 0,
+	`costo_valuta` varchar(255) DEFAULT NULL,
+	PRIMARY KEY (`id`),
+	...
+
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;
+*/
+type DBTimetrack struct {
+	DBObject
+}
+
+func NewDBTimetrack() *DBTimetrack {
+	columns := []Column{
+		{Name: "id", Type: "varchar(16)", Constraints: []string{"NOT NULL"}},
+		{Name: "owner", Type: "varchar(16)", Constraints: []string{"NOT NULL"}},
+		{Name: "group_id", Type: "varchar(16)", Constraints: []string{"NOT NULL"}},
+		{Name: "permissions", Type: "varchar(9)", Constraints: []string{"NOT NULL"}},
+		{Name: "creator", Type: "varchar(16)", Constraints: []string{"NOT NULL"}},
+		{Name: "creation_date", Type: "datetime", Constraints: []string{}},
+		{Name: "last_modify", Type: "varchar(16)", Constraints: []string{"NOT NULL"}},
+		{Name: "last_modify_date", Type: "datetime", Constraints: []string{}},
+		{Name: "deleted_by", Type: "varchar(16)", Constraints: []string{}},
+		{Name: "deleted_date", Type: "datetime", Constraints: []string{}},
+		{Name: "father_id", Type: "varchar(16)", Constraints: []string{}},
+		{Name: "name", Type: "varchar(255)", Constraints: []string{"NOT NULL"}},
+		{Name: "description", Type: "text", Constraints: []string{}},
+		{Name: "fk_obj_id", Type: "varchar(16)", Constraints: []string{}},
+		{Name: "fk_progetto", Type: "varchar(16)", Constraints: []string{}},
+		{Name: "dalle_ore", Type: "datetime", Constraints: []string{}},
+		{Name: "alle_ore", Type: "datetime", Constraints: []string{}},
+		{Name: "ore_intervento", Type: "datetime", Constraints: []string{}},
+		{Name: "ore_viaggio", Type: "datetime", Constraints: []string{}},
+		{Name: "km_viaggio", Type: "int(11)", Constraints: []string{"NOT NULL", "DEFAULT 0"}},
+		{Name: "luogo_di_intervento", Type: "int(11)", Constraints: []string{"NOT NULL", "DEFAULT 0"}},
+		{Name: "stato", Type: "int(11)", Constraints: []string{"NOT NULL", "DEFAULT 0"}},
+		{Name: "costo_per_ora", Type: "float", Constraints: []string{"NOT NULL", "DEFAULT 0"}},
+		{Name: "costo_valuta", Type: "varchar(255)", Constraints: []string{}},
+	}
+	keys := []string{"id"}
+	foreignKeys := []ForeignKey{
+		{Column: "owner", RefTable: "users", RefColumn: "id"},
+		{Column: "group_id", RefTable: "groups", RefColumn: "id"},
+		{Column: "creator", RefTable: "users", RefColumn: "id"},
+		{Column: "last_modify", RefTable: "users", RefColumn: "id"},
+		{Column: "deleted_by", RefTable: "users", RefColumn: "id"},
+		{Column: "father_id", RefTable: "objects", RefColumn: "id"},
+		{Column: "fk_obj_id", RefTable: "companies", RefColumn: "id"},
+		{Column: "fk_obj_id", RefTable: "people", RefColumn: "id"},
+		{Column: "fk_obj_id", RefTable: "projects", RefColumn: "id"},
+		{Column: "fk_progetto", RefTable: "projects", RefColumn: "id"},
+	}
+	return &DBTimetrack{
+		DBObject: DBObject{
+			DBEntity: *NewDBEntity(
+				"DBTimetrack",
+				"timetracks",
+				columns,
+				keys,
+				foreignKeys,
+				make(map[string]any),
+			),
+		},
+	}
+}
+func (dbTimetrack *DBTimetrack) NewInstance() DBEntityInterface {
+	return NewDBTimetrack()
+}
+func (dbTimetrack *DBTimetrack) GetOrderBy() []string {
+	return []string{"dalle_ore"}
+}
+
+// checkInterval verifies that the end of the interval is not before its start
+func (dbTimetrack *DBTimetrack) checkInterval() error {
+	dalle, ok1 := dbTimetrack.GetValue("dalle_ore").(string)
+	alle, ok2 := dbTimetrack.GetValue("alle_ore").(string)
+	if !ok1 || !ok2 || dalle == "" || alle == "" {
+		return nil
+	}
+	start, err := ParseDateTime(dalle)
+	if err != nil {
+		return fmt.Errorf("invalid dalle_ore: %s", dalle)
+	}
+	end, err := ParseDateTime(alle)
+	if err != nil {
+		return fmt.Errorf("invalid alle_ore: %s", alle)
+	}
+	if end.Before(start) {
+		return fmt.Errorf("alle_ore (%s) is before dalle_ore (%s)", alle, dalle)
+	}
+	return nil
+}
+
+func (dbTimetrack *DBTimetrack) beforeInsert(dbr *DBRepository, tx *sql.Tx) error {
+	err := dbTimetrack.DBObject.beforeInsert(dbr, tx)
+	if err != nil {
+		return err
+	}
+	return dbTimetrack.checkInterval()
+}
+
+func (dbTimetrack *DBTimetrack) beforeUpdate(dbr *DBRepository, tx *sql.Tx) error {
+	err := dbTimetrack.DBObject.beforeUpdate(dbr, tx)
+	if err != nil {
+		return err
+	}
+	return dbTimetrack.checkInterval()
+}
+
+/*
+CREATE TABLE `rprj_todo` (
+
+	`id` varchar(16) NOT NULL,
+	`owner` varchar(16) NOT NULL,
+	`group_id` varchar(16) NOT NULL,
+	`permissions` char(9) NOT NULL DEFAULT 'rwx------',
+	`creator` varchar(16) NOT NULL,
+	`creation_date` datetime DEFAULT NULL,
+	`last_modify` varchar(16) NOT NULL,
+	`last_modify_date` datetime DEFAULT NULL,
+	`deleted_by` varchar(16) DEFAULT NULL,
+	`deleted_date` datetime default null,
+	`father_id` varchar(16) DEFAULT NULL,
+	`name` varchar(255) NOT NULL,
+	`description` text DEFAULT NULL,
+	`priority` int(11) NOT NULL DEFAULT 0,
+	`data_segnalazione` datetime DEFAULT NULL,
+	`fk_segnalato_da` varchar(16) DEFAULT NULL,
+	`fk_cliente` varchar(16) DEFAULT NULL,
+	`fk_progetto` varchar(16) DEFAULT NULL,
+	`fk_funzionalita` varchar(16) DEFAULT NULL,
+	`fk_tipo` varchar(16) DEFAULT NULL,
+	`stato` int(11) NOT NULL DEFAULT 0,
+	`descrizione` text NOT NULL,
+	`intervento` text NOT NULL,
+	`data_chiusura` datetime DEFAULT NULL,
+	PRIMARY KEY (`id`),
+	...
+
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;
+*/
+type DBTodo struct {
+	DBObject
+}
+
+func NewDBTodo() *DBTodo {
+	columns := []Column{
+		{Name: "id", Type: "varchar(16)", Constraints: []string{"NOT NULL"}},
+		{Name: "owner", Type: "varchar(16)", Constraints: []string{"NOT NULL"}},
+		{Name: "group_id", Type: "varchar(16)", Constraints: []string{"NOT NULL"}},
+		{Name: "permissions", Type: "varchar(9)", Constraints: []string{"NOT NULL"}},
+		{Name: "creator", Type: "varchar(16)", Constraints: []string{"NOT NULL"}},
+		{Name: "creation_date", Type: "datetime", Constraints: []string{}},
+		{Name: "last_modify", Type: "varchar(16)", Constraints: []string{"NOT NULL"}},
+		{Name: "last_modify_date", Type: "datetime", Constraints: []string{}},
+		{Name: "deleted_by", Type: "varchar(16)", Constraints: []string{}},
+		{Name: "deleted_date", Type: "datetime", Constraints: []string{}},
+		{Name: "father_id", Type: "varchar(16)", Constraints: []string{}},
+		{Name: "name", Type: "varchar(255)", Constraints: []string{"NOT NULL"}},
+		{Name: "description", Type: "text", Constraints: []string{}},
+		{Name: "priority", Type: "int(11)", Constraints: []string{"NOT NULL", "DEFAULT 0"}},
+		{Name: "data_segnalazione", Type: "datetime", Constraints: []string{}},
+		{Name: "fk_segnalato_da", Type: "varchar(16)", Constraints: []string{}},
+		{Name: "fk_cliente", Type: "varchar(16)", Constraints: []string{}},
+		{Name: "fk_progetto", Type: "varchar(16)", Constraints: []string{}},
+		{Name: "fk_funzionalita", Type: "varchar(16)", Constraints: []string{}},
+		{Name: "fk_tipo", Type: "varchar(16)", Constraints: []string{}},
+		{Name: "stato", Type: "int(11)", Constraints: []string{"NOT NULL", "DEFAULT 0"}},
+		{Name: "descrizione", Type: "text", Constraints: []string{"NOT NULL"}},
+		{Name: "intervento", Type: "text", Constraints: []string{"NOT NULL"}},
+		{Name: "data_chiusura", Type: "datetime", Constraints: []string{}},
+	}
+	keys := []string{"id"}
+	foreignKeys := []ForeignKey{
+		{Column: "owner", RefTable: "users", RefColumn: "id"},
+		{Column: "group_id", RefTable: "groups", RefColumn: "id"},
+		{Column: "creator", RefTable: "users", RefColumn: "id"},
+		{Column: "last_modify", RefTable: "users", RefColumn: "id"},
+		{Column: "deleted_by", RefTable: "users", RefColumn: "id"},
+		{Column: "father_id", RefTable: "todo", RefColumn: "id"},
+		{Column: "fk_segnalato_da", RefTable: "people", RefColumn: "id"},
+		{Column: "fk_cliente", RefTable: "companies", RefColumn: "id"},
+		{Column: "fk_progetto", RefTable: "projects", RefColumn: "id"},
+		{Column: "fk_tipo", RefTable: "todo_tipo", RefColumn: "id"},
+	}
+	return &DBTodo{
+		DBObject: DBObject{
+			DBEntity: *NewDBEntity(
+				"DBTodo",
+				"todo",
+				columns,
+				keys,
+				foreignKeys,
+				make(map[string]any),
+			),
+		},
+	}
+}
+func (dbTodo *DBTodo) NewInstance() DBEntityInterface {
+	return NewDBTodo()
+}
+func (dbTodo *DBTodo) GetOrderBy() []string {
+	return []string{"priority DESC", "data_segnalazione"}
+}
+
+func (dbTodo *DBTodo) beforeInsert(dbr *DBRepository, tx *sql.Tx) error {
+	err := dbTodo.DBObject.beforeInsert(dbr, tx)
+	if err != nil {
+		return err
+	}
+	if !dbTodo.HasValue("data_segnalazione") || dbTodo.GetValue("data_segnalazione") == "" {
+		dbTodo.SetValue("data_segnalazione", CurrentDateTimeString())
+	}
+	// NOT NULL text columns without a default
+	for _, column := range []string{"descrizione", "intervento"} {
+		if !dbTodo.HasValue(column) || dbTodo.GetValue(column) == nil {
+			dbTodo.SetValue(column, "")
+		}
+	}
+	return nil
+}
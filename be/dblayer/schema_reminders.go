@@ -0,0 +1,180 @@
+package dblayer
+
+import (
+	"database/sql"
+)
+
+// Status of an EventReminder
+const (
+	ReminderPending = "pending"
+	ReminderSent    = "sent"
+	ReminderFailed  = "failed"
+)
+
+/*
+CREATE TABLE `rprj_event_reminders` (
+
+	`event_id` varchar(16) NOT NULL,
+	`occurrence_date` datetime NOT NULL,
+	`user_id` varchar(16) NOT NULL,
+	`channel` varchar(32) NOT NULL,
+	`alarm_date` datetime DEFAULT NULL,
+	`status` varchar(16) NOT NULL DEFAULT 'pending',
+	`error` text DEFAULT NULL,
+	`creation_date` datetime DEFAULT NULL,
+	`sent_date` datetime DEFAULT NULL,
+	PRIMARY KEY (`event_id`,`occurrence_date`,`user_id`,`channel`),
+	KEY `rprj_event_reminders_0` (`user_id`)
+
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;
+*/
+// EventReminder records the delivery of the alarm of an event occurrence to
+// a user on a channel: the primary key makes each reminder fire only once.
+type EventReminder struct {
+	DBEntity
+}
+
+func NewEventReminder() *EventReminder {
+	columns := []Column{
+		{Name: "event_id", Type: "varchar(16)", Constraints: []string{"NOT NULL"}},
+		{Name: "occurrence_date", Type: "datetime", Constraints: []string{"NOT NULL"}},
+		{Name: "user_id", Type: "varchar(16)", Constraints: []string{"NOT NULL"}},
+		{Name: "channel", Type: "varchar(32)", Constraints: []string{"NOT NULL"}},
+		{Name: "alarm_date", Type: "datetime", Constraints: []string{}},
+		{Name: "status", Type: "varchar(16)", Constraints: []string{"NOT NULL"}},
+		{Name: "error", Type: "text", Constraints: []string{}},
+		{Name: "creation_date", Type: "datetime", Constraints: []string{}},
+		{Name: "sent_date", Type: "datetime", Constraints: []string{}},
+	}
+	keys := []string{"event_id", "occurrence_date", "user_id", "channel"}
+	foreignKeys := []ForeignKey{
+		{Column: "event_id", RefTable: "events", RefColumn: "id"},
+		{Column: "user_id", RefTable: "users", RefColumn: "id"},
+	}
+	return &EventReminder{
+		DBEntity: *NewDBEntity(
+			"EventReminder",
+			"event_reminders",
+			columns,
+			keys,
+			foreignKeys,
+			make(map[string]any),
+		),
+	}
+}
+func (eventReminder *EventReminder) NewInstance() DBEntityInterface {
+	return NewEventReminder()
+}
+
+func (eventReminder *EventReminder) beforeInsert(dbr *DBRepository, tx *sql.Tx) error {
+	if status, _ := eventReminder.GetValue("status").(string); status == "" {
+		eventReminder.SetValue("status", ReminderPending)
+	}
+	eventReminder.SetValue("creation_date", CurrentDateTimeString())
+	return nil
+}
+
+/*
+CREATE TABLE `rprj_notifications` (
+
+	`id` varchar(16) NOT NULL,
+	`user_id` varchar(16) NOT NULL,
+	`title` varchar(255) NOT NULL,
+	`message` text DEFAULT NULL,
+	`object_id` varchar(16) DEFAULT NULL,
+	`creation_date` datetime DEFAULT NULL,
+	`read_date` datetime DEFAULT NULL,
+	PRIMARY KEY (`id`),
+	KEY `rprj_notifications_0` (`user_id`,`read_date`)
+
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;
+*/
+// DBNotification is an in-app notification of a user, e.g. the reminder of an event
+type DBNotification struct {
+	DBEntity
+}
+
+func NewDBNotification() *DBNotification {
+	columns := []Column{
+		{Name: "id", Type: "varchar(16)", Constraints: []string{"NOT NULL"}},
+		{Name: "user_id", Type: "varchar(16)", Constraints: []string{"NOT NULL"}},
+		{Name: "title", Type: "varchar(255)", Constraints: []string{"NOT NULL"}},
+		{Name: "message", Type: "text", Constraints: []string{}},
+		{Name: "object_id", Type: "varchar(16)", Constraints: []string{}},
+		{Name: "creation_date", Type: "datetime", Constraints: []string{}},
+		{Name: "read_date", Type: "datetime", Constraints: []string{}},
+	}
+	keys := []string{"id"}
+	foreignKeys := []ForeignKey{
+		{Column: "user_id", RefTable: "users", RefColumn: "id"},
+	}
+	return &DBNotification{
+		DBEntity: *NewDBEntity(
+			"DBNotification",
+			"notifications",
+			columns,
+			keys,
+			foreignKeys,
+			make(map[string]any),
+		),
+	}
+}
+func (dbNotification *DBNotification) NewInstance() DBEntityInterface {
+	return NewDBNotification()
+}
+func (dbNotification *DBNotification) GetOrderBy() []string {
+	return []string{"creation_date DESC"}
+}
+
+func (dbNotification *DBNotification) beforeInsert(dbr *DBRepository, tx *sql.Tx) error {
+	if id, _ := dbNotification.GetValue("id").(string); id == "" {
+		notificationID, _ := uuid16HexGo()
+		dbNotification.SetValue("id", notificationID)
+	}
+	dbNotification.SetValue("creation_date", CurrentDateTimeString())
+	return nil
+}
+
+/*
+CREATE TABLE `rprj_notification_channels` (
+
+	`user_id` varchar(16) NOT NULL,
+	`channel` varchar(32) NOT NULL,
+	`address` varchar(255) DEFAULT NULL,
+	`enabled` tinyint(1) NOT NULL DEFAULT 1,
+	PRIMARY KEY (`user_id`,`channel`)
+
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;
+*/
+// NotificationChannel is the setting of a user for a notification channel:
+// the destination address (email, Telegram chat id) and whether it is enabled.
+// Without a row the defaults of the channel apply.
+type NotificationChannel struct {
+	DBEntity
+}
+
+func NewNotificationChannel() *NotificationChannel {
+	columns := []Column{
+		{Name: "user_id", Type: "varchar(16)", Constraints: []string{"NOT NULL"}},
+		{Name: "channel", Type: "varchar(32)", Constraints: []string{"NOT NULL"}},
+		{Name: "address", Type: "varchar(255)", Constraints: []string{}},
+		{Name: "enabled", Type: "tinyint(1)", Constraints: []string{"NOT NULL"}},
+	}
+	keys := []string{"user_id", "channel"}
+	foreignKeys := []ForeignKey{
+		{Column: "user_id", RefTable: "users", RefColumn: "id"},
+	}
+	return &NotificationChannel{
+		DBEntity: *NewDBEntity(
+			"NotificationChannel",
+			"notification_channels",
+			columns,
+			keys,
+			foreignKeys,
+			make(map[string]any),
+		),
+	}
+}
+func (notificationChannel *NotificationChannel) NewInstance() DBEntityInterface {
+	return NewNotificationChannel()
+}
@@ -0,0 +1,41 @@
+package dblayer
+
+/*
+CREATE TABLE `rprj_fulltext` (
+
+	`object_id` varchar(16) NOT NULL,
+	`term` varchar(64) NOT NULL,
+	`weight` int(11) NOT NULL DEFAULT 0,
+	PRIMARY KEY (`object_id`,`term`),
+	KEY `rprj_fulltext_0` (`term`)
+
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;
+*/
+// DBFullTextTerm is an entry of the inverted index used by the full-text search:
+// the weight is the number of occurrences of the term in the object, multiplied
+// by the weight of the field where it was found (see fullTextFieldWeights).
+type DBFullTextTerm struct {
+	DBEntity
+}
+
+func NewDBFullTextTerm() *DBFullTextTerm {
+	columns := []Column{
+		{Name: "object_id", Type: "varchar(16)", Constraints: []string{"NOT NULL"}},
+		{Name: "term", Type: "varchar(64)", Constraints: []string{"NOT NULL"}},
+		{Name: "weight", Type: "int(11)", Constraints: []string{"NOT NULL", "DEFAULT 0"}},
+	}
+	keys := []string{"object_id", "term"}
+	return &DBFullTextTerm{
+		DBEntity: *NewDBEntity(
+			"DBFullTextTerm",
+			"fulltext",
+			columns,
+			keys,
+			[]ForeignKey{},
+			make(map[string]any),
+		),
+	}
+}
+func (dbFullTextTerm *DBFullTextTerm) NewInstance() DBEntityInterface {
+	return NewDBFullTextTerm()
+}
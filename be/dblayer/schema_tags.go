@@ -0,0 +1,161 @@
+package dblayer
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+)
+
+/*
+CREATE TABLE `rprj_tags` (
+
+	`id` varchar(16) NOT NULL,
+	`name` varchar(255) NOT NULL,
+	`creator` varchar(16) DEFAULT NULL,
+	`creation_date` datetime DEFAULT NULL,
+	PRIMARY KEY (`id`),
+	UNIQUE KEY `rprj_tags_0` (`name`)
+
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;
+*/
+type DBTag struct {
+	DBEntity
+}
+
+func NewDBTag() *DBTag {
+	columns := []Column{
+		{Name: "id", Type: "varchar(16)", Constraints: []string{"NOT NULL"}},
+		{Name: "name", Type: "varchar(255)", Constraints: []string{"NOT NULL"}},
+		{Name: "creator", Type: "varchar(16)", Constraints: []string{}},
+		{Name: "creation_date", Type: "datetime", Constraints: []string{}},
+	}
+	keys := []string{"id"}
+	foreignKeys := []ForeignKey{
+		{Column: "creator", RefTable: "users", RefColumn: "id"},
+	}
+	return &DBTag{
+		DBEntity: *NewDBEntity(
+			"DBTag",
+			"tags",
+			columns,
+			keys,
+			foreignKeys,
+			make(map[string]any),
+		),
+	}
+}
+func (dbTag *DBTag) NewInstance() DBEntityInterface {
+	return NewDBTag()
+}
+func (dbTag *DBTag) GetOrderBy() []string {
+	return []string{"name"}
+}
+
+// NormalizeTagName trims and lowercases a tag, collapsing internal whitespace,
+// so that "Go  Lang" and "go lang" end up being the same tag.
+func NormalizeTagName(name string) string {
+	return strings.ToLower(strings.Join(strings.Fields(name), " "))
+}
+
+func (dbTag *DBTag) beforeInsert(dbr *DBRepository, tx *sql.Tx) error {
+	if !dbTag.HasValue("id") || dbTag.GetValue("id") == "" {
+		tagID, _ := uuid16HexGo()
+		dbTag.SetValue("id", tagID)
+	}
+	name, _ := dbTag.GetValue("name").(string)
+	name = NormalizeTagName(name)
+	if name == "" {
+		return fmt.Errorf("tag name cannot be empty")
+	}
+	dbTag.SetValue("name", name)
+	if dbr.DbContext != nil && dbr.DbContext.UserID != "" {
+		dbTag.SetValue("creator", dbr.DbContext.UserID)
+	}
+	dbTag.SetValue("creation_date", CurrentDateTimeString())
+
+	// Check that a tag with the same name does not already exist
+	existingTag := dbTag.NewInstance()
+	existingTag.SetValue("name", name)
+	results, err := dbr.searchWithTx(existingTag, false, false, "", tx)
+	if err != nil {
+		return err
+	}
+	if len(results) > 0 {
+		return fmt.Errorf("tag with name '%s' already exists", name)
+	}
+	return nil
+}
+
+func (dbTag *DBTag) beforeUpdate(dbr *DBRepository, tx *sql.Tx) error {
+	if dbTag.HasValue("name") {
+		name, _ := dbTag.GetValue("name").(string)
+		name = NormalizeTagName(name)
+		if name == "" {
+			return fmt.Errorf("tag name cannot be empty")
+		}
+		dbTag.SetValue("name", name)
+	}
+	return nil
+}
+
+func (dbTag *DBTag) beforeDelete(dbr *DBRepository, tx *sql.Tx) error {
+	// Remove all the associations to this tag
+	query := "DELETE FROM " + dbr.buildTableName(NewObjectTag()) + " WHERE tag_id = ?"
+	if _, err := tx.Exec(query, dbTag.GetValue("id")); err != nil {
+		log.Print("DBTag::beforeDelete: error deleting object tags:", err)
+		return err
+	}
+	return nil
+}
+
+/*
+CREATE TABLE `rprj_objects_tags` (
+
+	`object_id` varchar(16) NOT NULL,
+	`tag_id` varchar(16) NOT NULL,
+	`creator` varchar(16) DEFAULT NULL,
+	`creation_date` datetime DEFAULT NULL,
+	PRIMARY KEY (`object_id`,`tag_id`),
+	KEY `rprj_objects_tags_0` (`object_id`),
+	KEY `rprj_objects_tags_1` (`tag_id`)
+
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;
+*/
+type ObjectTag struct {
+	DBEntity
+}
+
+func NewObjectTag() *ObjectTag {
+	columns := []Column{
+		{Name: "object_id", Type: "varchar(16)", Constraints: []string{"NOT NULL"}},
+		{Name: "tag_id", Type: "varchar(16)", Constraints: []string{"NOT NULL"}},
+		{Name: "creator", Type: "varchar(16)", Constraints: []string{}},
+		{Name: "creation_date", Type: "datetime", Constraints: []string{}},
+	}
+	keys := []string{"object_id", "tag_id"}
+	foreignKeys := []ForeignKey{
+		{Column: "object_id", RefTable: "objects", RefColumn: "id"},
+		{Column: "tag_id", RefTable: "tags", RefColumn: "id"},
+	}
+	return &ObjectTag{
+		DBEntity: *NewDBEntity(
+			"ObjectTag",
+			"objects_tags",
+			columns,
+			keys,
+			foreignKeys,
+			make(map[string]any),
+		),
+	}
+}
+func (objectTag *ObjectTag) NewInstance() DBEntityInterface {
+	return NewObjectTag()
+}
+func (objectTag *ObjectTag) beforeInsert(dbr *DBRepository, tx *sql.Tx) error {
+	if dbr.DbContext != nil && dbr.DbContext.UserID != "" {
+		objectTag.SetValue("creator", dbr.DbContext.UserID)
+	}
+	objectTag.SetValue("creation_date", CurrentDateTimeString())
+	return nil
+}
@@ -0,0 +1,234 @@
+package dblayer
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"rprj/be/models"
+)
+
+/*
+Storage of the blobs of the DBFiles.
+
+The blobs are addressed by a key: a slash separated path relative to the
+files directory, like <father_id>/r_<id>_<name> in the legacy layout or
+XX/YY/r_<id>_<name> in the sharded ones (see filestorage.go). The thumbnail
+of a blob is stored beside it, with the _thumb.jpg suffix. The driver is
+selected with storage_driver: local (the files directory) or s3 (a bucket
+of an S3 compatible service, like MinIO).
+*/
+
+// Storage drivers
+const (
+	StorageDriverLocal = "local"
+	StorageDriverS3    = "s3"
+)
+
+// BlobInfo describes a stored blob
+type BlobInfo struct {
+	Size    int64
+	ModTime time.Time
+}
+
+// BlobStorage stores the blobs of the DBFiles. A missing blob is reported
+// with an error matching fs.ErrNotExist (os.IsNotExist works too).
+type BlobStorage interface {
+	// Put stores the content of r as key, replacing it; size is the length
+	// of the content, -1 when unknown
+	Put(key string, r io.Reader, size int64) error
+	// Get reads a blob
+	Get(key string) (io.ReadCloser, error)
+	// GetRange reads length bytes of a blob from offset, up to its end when
+	// length < 0. A range past the end is empty.
+	GetRange(key string, offset int64, length int64) (io.ReadCloser, error)
+	// Stat returns the size and the modification time of a blob
+	Stat(key string) (BlobInfo, error)
+	// Delete removes a blob
+	Delete(key string) error
+	// Rename moves a blob, replacing the destination
+	Rename(from string, to string) error
+}
+
+// FileStorage is the storage of the blobs of the DBFiles
+var FileStorage BlobStorage = NewLocalStorage(filesDirectory())
+
+// NewBlobStorage returns the storage driver selected in the configuration
+func NewBlobStorage(config models.Config) (BlobStorage, error) {
+	switch config.StorageDriver {
+	case "", StorageDriverLocal:
+		return NewLocalStorage(config.RootDirectory + "/" + config.FilesDirectory), nil
+	case StorageDriverS3:
+		return NewS3Storage(config.S3Endpoint, config.S3Region, config.S3Bucket, config.S3AccessKey, config.S3SecretKey, config.S3Prefix)
+	}
+	return nil, fmt.Errorf("unknown storage driver %s", config.StorageDriver)
+}
+
+// cleanBlobKey validates a key: relative, without . or .. elements
+func cleanBlobKey(key string) (string, error) {
+	cleaned := path.Clean(key)
+	if key == "" || cleaned != key || strings.HasPrefix(key, "/") || key == ".." || strings.HasPrefix(key, "../") {
+		return "", fmt.Errorf("invalid storage key %q", key)
+	}
+	return cleaned, nil
+}
+
+// notExist returns the error of a missing blob
+func notExist(op string, key string) error {
+	return &fs.PathError{Op: op, Path: key, Err: fs.ErrNotExist}
+}
+
+// readCloser pairs a reader with the closer of the underlying stream
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// blobSHA1 calculates the SHA1 hash of a blob
+func blobSHA1(key string) (string, error) {
+	file, err := FileStorage.Get(key)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha1.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%x", hash.Sum(nil)), nil
+}
+
+// LocalStorage stores the blobs in a directory
+type LocalStorage struct {
+	root string
+}
+
+// NewLocalStorage returns a storage rooted at the given directory
+func NewLocalStorage(root string) *LocalStorage {
+	return &LocalStorage{root: root}
+}
+
+// path returns the path of the file of a blob
+func (s *LocalStorage) path(key string) (string, error) {
+	key, err := cleanBlobKey(key)
+	if err != nil {
+		return "", err
+	}
+	return s.root + "/" + key, nil
+}
+
+func (s *LocalStorage) Put(key string, r io.Reader, size int64) error {
+	dest, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), os.FileMode(0755)); err != nil {
+		return err
+	}
+	// Write a temporary file and rename it, readers never see a partial blob
+	tmp, err := os.CreateTemp(filepath.Dir(dest), ".upload-*")
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), os.FileMode(0644)); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), dest); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+func (s *LocalStorage) Get(key string) (io.ReadCloser, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(p)
+}
+
+func (s *LocalStorage) GetRange(key string, offset int64, length int64) (io.ReadCloser, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if length < 0 {
+		return file, nil
+	}
+	return readCloser{io.LimitReader(file, length), file}, nil
+}
+
+func (s *LocalStorage) Stat(key string) (BlobInfo, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return BlobInfo{}, err
+	}
+	info, err := os.Stat(p)
+	if err != nil {
+		return BlobInfo{}, err
+	}
+	if info.IsDir() {
+		return BlobInfo{}, notExist("stat", key)
+	}
+	return BlobInfo{Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+func (s *LocalStorage) Delete(key string) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	return os.Remove(p)
+}
+
+func (s *LocalStorage) Rename(from string, to string) error {
+	source, err := s.path(from)
+	if err != nil {
+		return err
+	}
+	dest, err := s.path(to)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), os.FileMode(0755)); err != nil {
+		return err
+	}
+	return os.Rename(source, dest)
+}
+
+// removeEmptyDir removes the directory of a key when empty
+func (s *LocalStorage) removeEmptyDir(dir string) {
+	if dir == "." || dir == "" {
+		return
+	}
+	if p, err := s.path(dir); err == nil {
+		os.Remove(p)
+	}
+}
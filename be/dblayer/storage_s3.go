@@ -0,0 +1,330 @@
+package dblayer
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// emptyPayloadHash is the SHA256 of an empty body
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// S3Storage stores the blobs in a bucket of an S3 compatible service. The
+// requests use the path-style addressing (<endpoint>/<bucket>/<key>),
+// supported by AWS and by MinIO, and are signed with AWS Signature V4.
+type S3Storage struct {
+	endpoint  *url.URL
+	region    string
+	bucket    string
+	accessKey string
+	secretKey string
+	// prefix of the keys in the bucket
+	prefix string
+	client *http.Client
+}
+
+// NewS3Storage returns a storage on a bucket. The endpoint is a URL like
+// http://localhost:9000 (https when the scheme is missing), the region
+// defaults to us-east-1.
+func NewS3Storage(endpoint string, region string, bucket string, accessKey string, secretKey string, prefix string) (*S3Storage, error) {
+	if endpoint == "" || bucket == "" {
+		return nil, fmt.Errorf("the s3 storage needs an endpoint and a bucket")
+	}
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "https://" + endpoint
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid s3 endpoint %s", endpoint)
+	}
+	if region == "" {
+		region = "us-east-1"
+	}
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix = prefix + "/"
+	}
+	return &S3Storage{
+		endpoint:  u,
+		region:    region,
+		bucket:    bucket,
+		accessKey: accessKey,
+		secretKey: secretKey,
+		prefix:    prefix,
+		client:    &http.Client{},
+	}, nil
+}
+
+// s3Escape encodes a string as required by S3: everything but the
+// unreserved characters, and the slashes unless it is a query value
+func s3Escape(s string, keepSlash bool) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || isDigit(c) ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (keepSlash && c == '/') {
+			sb.WriteByte(c)
+		} else {
+			fmt.Fprintf(&sb, "%%%02X", c)
+		}
+	}
+	return sb.String()
+}
+
+// objectPath returns the path of a key in the bucket
+func (s *S3Storage) objectPath(key string) (string, error) {
+	key, err := cleanBlobKey(key)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(s.endpoint.Path, "/") + "/" + s.bucket + "/" + s.prefix + key, nil
+}
+
+// hmacSHA256 returns the HMAC-SHA256 of data
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// sign adds the AWS Signature V4 to a request. The host, the range and the
+// x-amz-* headers are signed.
+func (s *S3Storage) sign(req *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	headers := map[string]string{"host": host}
+	for name, values := range req.Header {
+		lower := strings.ToLower(name)
+		if lower == "range" || strings.HasPrefix(lower, "x-amz-") {
+			headers[lower] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	query := req.URL.Query()
+	queryKeys := make([]string, 0, len(query))
+	for k := range query {
+		queryKeys = append(queryKeys, k)
+	}
+	sort.Strings(queryKeys)
+	var canonicalQuery []string
+	for _, k := range queryKeys {
+		for _, v := range query[k] {
+			canonicalQuery = append(canonicalQuery, s3Escape(k, false)+"="+s3Escape(v, false))
+		}
+	}
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		strings.Join(canonicalQuery, "&"),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := date + "/" + s.region + "/s3/aws4_request"
+	canonicalHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(canonicalHash[:])
+
+	signingKey := hmacSHA256([]byte("AWS4"+s.secretKey), date)
+	signingKey = hmacSHA256(signingKey, s.region)
+	signingKey = hmacSHA256(signingKey, "s3")
+	signingKey = hmacSHA256(signingKey, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+s.accessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+// do sends a signed request for a key; the body, when present, is not
+// signed (UNSIGNED-PAYLOAD) so it can be streamed
+func (s *S3Storage) do(method string, key string, headers map[string]string, body io.Reader, size int64) (*http.Response, error) {
+	p, err := s.objectPath(key)
+	if err != nil {
+		return nil, err
+	}
+	u := &url.URL{Scheme: s.endpoint.Scheme, Host: s.endpoint.Host, Path: p, RawPath: s3Escape(p, true)}
+	if body == nil {
+		body = http.NoBody
+	}
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	payloadHash := emptyPayloadHash
+	if size > 0 {
+		req.ContentLength = size
+		payloadHash = "UNSIGNED-PAYLOAD"
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	s.sign(req, payloadHash, time.Now())
+	return s.client.Do(req)
+}
+
+// s3Error is the body of an error response
+type s3Error struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+// responseError returns the error of a failed request, fs.ErrNotExist for
+// a missing key
+func responseError(op string, key string, resp *http.Response) error {
+	if resp.StatusCode == http.StatusNotFound {
+		return notExist(op, key)
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	var e s3Error
+	if xml.Unmarshal(data, &e) == nil && e.Code != "" {
+		return fmt.Errorf("s3 %s %s: %s: %s", op, key, e.Code, e.Message)
+	}
+	return fmt.Errorf("s3 %s %s: %s", op, key, resp.Status)
+}
+
+func (s *S3Storage) Put(key string, r io.Reader, size int64) error {
+	if size < 0 {
+		// S3 needs the length of the content: spool it
+		tmp, err := os.CreateTemp("", "rprj-s3-*")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tmp.Name())
+		defer tmp.Close()
+		if size, err = io.Copy(tmp, r); err != nil {
+			return err
+		}
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		r = tmp
+	}
+	if size == 0 {
+		r = nil
+	}
+	resp, err := s.do(http.MethodPut, key, nil, r, size)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return responseError("put", key, resp)
+	}
+	return nil
+}
+
+func (s *S3Storage) Get(key string) (io.ReadCloser, error) {
+	return s.GetRange(key, 0, -1)
+}
+
+func (s *S3Storage) GetRange(key string, offset int64, length int64) (io.ReadCloser, error) {
+	if length == 0 {
+		return io.NopCloser(bytes.NewReader(nil)), nil
+	}
+	var headers map[string]string
+	if length > 0 {
+		headers = map[string]string{"Range": fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)}
+	} else if offset > 0 {
+		headers = map[string]string{"Range": fmt.Sprintf("bytes=%d-", offset)}
+	}
+	resp, err := s.do(http.MethodGet, key, headers, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusPartialContent:
+		return resp.Body, nil
+	case http.StatusRequestedRangeNotSatisfiable:
+		// Past the end of the blob (or an empty blob)
+		resp.Body.Close()
+		return io.NopCloser(bytes.NewReader(nil)), nil
+	}
+	defer resp.Body.Close()
+	return nil, responseError("get", key, resp)
+}
+
+func (s *S3Storage) Stat(key string) (BlobInfo, error) {
+	resp, err := s.do(http.MethodHead, key, nil, nil, 0)
+	if err != nil {
+		return BlobInfo{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return BlobInfo{}, responseError("stat", key, resp)
+	}
+	info := BlobInfo{Size: resp.ContentLength}
+	if modified, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		info.ModTime = modified
+	}
+	return info, nil
+}
+
+func (s *S3Storage) Delete(key string) error {
+	// DELETE succeeds on missing keys: check first
+	if _, err := s.Stat(key); err != nil {
+		return err
+	}
+	return s.remove(key)
+}
+
+// remove deletes a key without checking it exists
+func (s *S3Storage) remove(key string) error {
+	resp, err := s.do(http.MethodDelete, key, nil, nil, 0)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return responseError("delete", key, resp)
+	}
+	return nil
+}
+
+func (s *S3Storage) Rename(from string, to string) error {
+	source, err := s.objectPath(from)
+	if err != nil {
+		return err
+	}
+	source = strings.TrimPrefix(source, strings.TrimSuffix(s.endpoint.Path, "/"))
+	resp, err := s.do(http.MethodPut, to, map[string]string{"x-amz-copy-source": s3Escape(source, true)}, nil, 0)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return responseError("rename", from, resp)
+	}
+	// A copy can fail after the 200 status: the error is in the body
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	var e s3Error
+	if bytes.Contains(data, []byte("<Error>")) && xml.Unmarshal(data, &e) == nil {
+		return fmt.Errorf("s3 rename %s: %s: %s", from, e.Code, e.Message)
+	}
+	return s.remove(from)
+}
@@ -0,0 +1,351 @@
+package dblayer
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"strconv"
+)
+
+/*
+Storage usage and quotas.
+
+The bytes and the files stored by each user and each group are counted in
+StorageUsage, updated by the DBFile hooks in the transaction of the insert,
+the update and the final delete: a file in the trash still counts, the
+archived revisions do not. The bytes come from the size column of the
+DBFile, read from its blob; a deduplicated blob counts for every file.
+
+A quota limits the bytes of a user or a group: the quota of its row when
+set, the default of the configuration otherwise; 0 means unlimited. The
+DBFile hooks check it in their transaction, with the usage rows locked;
+CheckStorageQuota lets the handlers refuse an upload before receiving it.
+RecomputeStorageUsage rebuilds the counters from the files.
+*/
+
+// Kinds of StorageUsage
+const (
+	StorageUsageUser  = "U"
+	StorageUsageGroup = "G"
+)
+
+// Default quotas in bytes, 0 = unlimited
+var storageUserQuota int64
+var storageGroupQuota int64
+
+// SetStorageQuotas sets the default quotas in bytes of users and groups, 0 = unlimited
+func SetStorageQuotas(user int64, group int64) {
+	storageUserQuota = max(user, 0)
+	storageGroupQuota = max(group, 0)
+}
+
+// defaultStorageQuota returns the default quota of a kind
+func defaultStorageQuota(kind string) int64 {
+	if kind == StorageUsageGroup {
+		return storageGroupQuota
+	}
+	return storageUserQuota
+}
+
+// QuotaExceededError is returned when new content would exceed the quota of
+// a user or a group
+type QuotaExceededError struct {
+	Kind      string
+	SubjectID string
+	Quota     int64
+	Used      int64
+	// Bytes that did not fit
+	Size int64
+}
+
+func (e *QuotaExceededError) Error() string {
+	subject := "user"
+	if e.Kind == StorageUsageGroup {
+		subject = "group"
+	}
+	return fmt.Sprintf("storage quota of the %s %s exceeded: %d of %d bytes used", subject, e.SubjectID, e.Used, e.Quota)
+}
+
+// StorageUsageInfo is the usage of a user or a group
+type StorageUsageInfo struct {
+	Kind      string `json:"kind"`
+	SubjectID string `json:"subject_id"`
+	// Login of the user or name of the group
+	Name  string `json:"name,omitempty"`
+	Bytes int64  `json:"bytes"`
+	Files int64  `json:"files"`
+	// Quota in effect, 0 = unlimited
+	Quota int64 `json:"quota"`
+	// Quota set for this user or group, nil when the default applies
+	QuotaOverride *int64 `json:"quota_override"`
+}
+
+// StorageUsageReport is the result of RecomputeStorageUsage
+type StorageUsageReport struct {
+	// Files examined
+	Files int `json:"files"`
+	// Files whose size has been corrected
+	Resized int `json:"resized"`
+	// Files whose blob is missing, counted as empty
+	Missing int   `json:"missing"`
+	Bytes   int64 `json:"bytes"`
+}
+
+// Size returns the size in bytes of the blob of the file
+func (dbFile *DBFile) Size() int64 {
+	return int64Value(dbFile, "size")
+}
+
+// changeStorageUsage adds bytes and files to the usage of a user or a group.
+// The counters never go below zero: files stored before the usage was
+// tracked are counted only by RecomputeStorageUsage.
+func (dbr *DBRepository) changeStorageUsage(kind string, subjectID string, bytes int64, files int, tx *sql.Tx) error {
+	if subjectID == "" || (bytes == 0 && files == 0) {
+		return nil
+	}
+	query := "INSERT INTO " + dbr.buildTableName(NewStorageUsage()) + " (kind, subject_id, bytes, files)" +
+		" VALUES (?, ?, GREATEST(?, 0), GREATEST(?, 0))" +
+		" ON DUPLICATE KEY UPDATE bytes = GREATEST(bytes + ?, 0), files = GREATEST(files + ?, 0)"
+	_, err := tx.Exec(query, kind, subjectID, bytes, files, bytes, files)
+	return err
+}
+
+// addStorageUsage adds (sign 1) or removes (sign -1) a file from the usage
+// of its owner and its group
+func (dbr *DBRepository) addStorageUsage(dbFile *DBFile, sign int, tx *sql.Tx) error {
+	size := int64(sign) * dbFile.Size()
+	if err := dbr.changeStorageUsage(StorageUsageUser, stringValue(dbFile, "owner"), size, sign, tx); err != nil {
+		return err
+	}
+	return dbr.changeStorageUsage(StorageUsageGroup, stringValue(dbFile, "group_id"), size, sign, tx)
+}
+
+// moveStorageUsage moves a file from the usage of its stored version to the
+// usage of the updated one, when the owner, the group or the size change
+func (dbr *DBRepository) moveStorageUsage(myself *DBFile, dbFile *DBFile, tx *sql.Tx) error {
+	if stringValue(myself, "owner") == stringValue(dbFile, "owner") &&
+		stringValue(myself, "group_id") == stringValue(dbFile, "group_id") &&
+		myself.Size() == dbFile.Size() {
+		return nil
+	}
+	if err := dbr.addStorageUsage(myself, -1, tx); err != nil {
+		return err
+	}
+	return dbr.addStorageUsage(dbFile, 1, tx)
+}
+
+// queryStorageUsage reads the usages matching a condition, with the names
+// of their users and groups
+func (dbr *DBRepository) queryStorageUsage(where string, args ...any) ([]StorageUsageInfo, error) {
+	query := "SELECT s.kind, s.subject_id, s.bytes, s.files, s.quota, COALESCE(u.login, g.name, '')" +
+		" FROM " + dbr.buildTableName(NewStorageUsage()) + " s" +
+		" LEFT JOIN " + dbr.buildTableName(NewDBUser()) + " u ON s.kind = 'U' AND u.id = s.subject_id" +
+		" LEFT JOIN " + dbr.buildTableName(NewDBGroup()) + " g ON s.kind = 'G' AND g.id = s.subject_id"
+	if where != "" {
+		query += " WHERE " + where
+	}
+	query += " ORDER BY s.kind DESC, s.bytes DESC"
+	rows, err := dbr.DbConnection.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	usages := []StorageUsageInfo{}
+	for rows.Next() {
+		var usage StorageUsageInfo
+		var quota sql.NullInt64
+		if err := rows.Scan(&usage.Kind, &usage.SubjectID, &usage.Bytes, &usage.Files, &quota, &usage.Name); err != nil {
+			return nil, err
+		}
+		usage.Quota = defaultStorageQuota(usage.Kind)
+		if quota.Valid {
+			usage.QuotaOverride = &quota.Int64
+			usage.Quota = quota.Int64
+		}
+		usages = append(usages, usage)
+	}
+	return usages, rows.Err()
+}
+
+// GetStorageUsage returns the usage of a user or a group
+func (dbr *DBRepository) GetStorageUsage(kind string, subjectID string) (StorageUsageInfo, error) {
+	usages, err := dbr.queryStorageUsage("s.kind = ? AND s.subject_id = ?", kind, subjectID)
+	if err != nil {
+		return StorageUsageInfo{}, err
+	}
+	if len(usages) == 0 {
+		// Nothing stored yet
+		return StorageUsageInfo{Kind: kind, SubjectID: subjectID, Quota: defaultStorageQuota(kind)}, nil
+	}
+	return usages[0], nil
+}
+
+// ListStorageUsage returns the usage of every user and group
+func (dbr *DBRepository) ListStorageUsage() ([]StorageUsageInfo, error) {
+	return dbr.queryStorageUsage("")
+}
+
+// CheckStorageQuota tells if additional bytes fit in the quotas of a user
+// and of a group, returning a *QuotaExceededError when they do not
+func (dbr *DBRepository) CheckStorageQuota(owner string, groupID string, additional int64) error {
+	if additional <= 0 {
+		return nil
+	}
+	for _, subject := range []struct{ kind, id string }{{StorageUsageUser, owner}, {StorageUsageGroup, groupID}} {
+		if subject.id == "" {
+			continue
+		}
+		usage, err := dbr.GetStorageUsage(subject.kind, subject.id)
+		if err != nil {
+			return err
+		}
+		if usage.Quota > 0 && usage.Bytes+additional > usage.Quota {
+			return &QuotaExceededError{Kind: subject.kind, SubjectID: subject.id, Quota: usage.Quota, Used: usage.Bytes, Size: additional}
+		}
+	}
+	return nil
+}
+
+// lockStorageUsage reads the bytes and the quota in effect of a user or a
+// group, locking its row until the end of the transaction
+func (dbr *DBRepository) lockStorageUsage(kind string, subjectID string, tx *sql.Tx) (int64, int64, error) {
+	usageTable := dbr.buildTableName(NewStorageUsage())
+	// Nothing stored yet: the row is created to be locked
+	query := "INSERT IGNORE INTO " + usageTable + " (kind, subject_id, bytes, files) VALUES (?, ?, 0, 0)"
+	if _, err := tx.Exec(query, kind, subjectID); err != nil {
+		return 0, 0, err
+	}
+	var bytes int64
+	var quota sql.NullInt64
+	query = "SELECT bytes, quota FROM " + usageTable + " WHERE kind = ? AND subject_id = ? FOR UPDATE"
+	if err := tx.QueryRow(query, kind, subjectID).Scan(&bytes, &quota); err != nil {
+		return 0, 0, err
+	}
+	if quota.Valid {
+		return bytes, quota.Int64, nil
+	}
+	return bytes, defaultStorageQuota(kind), nil
+}
+
+// checkStorageQuotaWithTx tells if size bytes of a file fit in the quotas of
+// its owner and of its group, returning a *QuotaExceededError when they do
+// not. myself is the stored version of an updated file, nil for a new one:
+// only the growth counts. The usage rows stay locked until the end of the
+// transaction, so concurrent uploads to the same user or group are checked
+// one after the other against the bytes actually stored.
+func (dbr *DBRepository) checkStorageQuotaWithTx(myself *DBFile, dbFile *DBFile, size int64, tx *sql.Tx) error {
+	for _, subject := range []struct{ kind, column string }{{StorageUsageUser, "owner"}, {StorageUsageGroup, "group_id"}} {
+		subjectID := stringValue(dbFile, subject.column)
+		additional := size
+		if myself != nil && stringValue(myself, subject.column) == subjectID {
+			additional -= myself.Size()
+		}
+		if subjectID == "" || additional <= 0 {
+			continue
+		}
+		used, quota, err := dbr.lockStorageUsage(subject.kind, subjectID, tx)
+		if err != nil {
+			return err
+		}
+		if quota > 0 && used+additional > quota {
+			return &QuotaExceededError{Kind: subject.kind, SubjectID: subjectID, Quota: quota, Used: used, Size: additional}
+		}
+	}
+	return nil
+}
+
+// checkUploadQuotaWithTx checks the quotas for the upload of a file at the
+// key uploaded, myself being its stored version (nil for a new file). A
+// refused upload is deleted from the storage.
+func (dbr *DBRepository) checkUploadQuotaWithTx(myself *DBFile, dbFile *DBFile, uploaded string, tx *sql.Tx) error {
+	info, err := FileStorage.Stat(uploaded)
+	if err != nil {
+		return nil
+	}
+	err = dbr.checkStorageQuotaWithTx(myself, dbFile, info.Size, tx)
+	var quotaError *QuotaExceededError
+	if errors.As(err, &quotaError) {
+		if err := FileStorage.Delete(uploaded); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("DBRepository::checkUploadQuotaWithTx: error deleting %s: %v", uploaded, err)
+		}
+	}
+	return err
+}
+
+// SetStorageQuota sets the quota of a user or a group, nil to restore the default
+func (dbr *DBRepository) SetStorageQuota(kind string, subjectID string, quota *int64) error {
+	if kind != StorageUsageUser && kind != StorageUsageGroup {
+		return fmt.Errorf("invalid kind %q", kind)
+	}
+	if quota != nil && *quota < 0 {
+		return fmt.Errorf("invalid quota %d", *quota)
+	}
+	query := "INSERT INTO " + dbr.buildTableName(NewStorageUsage()) + " (kind, subject_id, bytes, files, quota)" +
+		" VALUES (?, ?, 0, 0, ?) ON DUPLICATE KEY UPDATE quota = ?"
+	_, err := dbr.ExecuteSQL(query, kind, subjectID, quota, quota)
+	return err
+}
+
+// RecomputeStorageUsage reads the size of every blob into the files, then
+// rebuilds the usage of users and groups from the files. The quotas set by
+// the admins are kept.
+func (dbr *DBRepository) RecomputeStorageUsage() (*StorageUsageReport, error) {
+	filesTable := dbr.buildTableName(NewDBFile())
+	files := dbr.Select("DBFile", "SELECT * FROM "+filesTable+" WHERE filename <> ''")
+	if files == nil {
+		return nil, fmt.Errorf("failed to read the files")
+	}
+	report := &StorageUsageReport{}
+	for _, entity := range files {
+		dbFile := entity.(*DBFile)
+		report.Files++
+		var size int64
+		if info, err := FileStorage.Stat(dbFile.GetBlobKey()); err == nil {
+			size = info.Size
+		} else if errors.Is(err, fs.ErrNotExist) {
+			report.Missing++
+		} else {
+			return nil, err
+		}
+		report.Bytes += size
+		if stringValue(dbFile, "size") == strconv.FormatInt(size, 10) {
+			continue
+		}
+		if _, err := dbr.ExecuteSQL("UPDATE "+filesTable+" SET size = ? WHERE id = ?", size, dbFile.GetValue("id")); err != nil {
+			return nil, err
+		}
+		report.Resized++
+	}
+
+	tx, err := dbr.DbConnection.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	usageTable := dbr.buildTableName(NewStorageUsage())
+	if _, err := tx.Exec("UPDATE " + usageTable + " SET bytes = 0, files = 0"); err != nil {
+		return nil, err
+	}
+	for kind, column := range map[string]string{StorageUsageUser: "owner", StorageUsageGroup: "group_id"} {
+		// The derived table lets ON DUPLICATE KEY UPDATE refer to the aggregates
+		query := "INSERT INTO " + usageTable + " (kind, subject_id, bytes, files)" +
+			" SELECT * FROM (SELECT ? AS kind, " + column + " AS subject_id, COALESCE(SUM(size), 0) AS bytes, COUNT(*) AS files" +
+			" FROM " + filesTable + " GROUP BY " + column + ") AS t" +
+			" ON DUPLICATE KEY UPDATE bytes = t.bytes, files = t.files"
+		if _, err := tx.Exec(query, kind); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := tx.Exec("DELETE FROM " + usageTable + " WHERE bytes = 0 AND files = 0 AND quota IS NULL"); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	log.Printf("DBRepository::RecomputeStorageUsage: %d files, %d resized, %d missing, %d bytes", report.Files, report.Resized, report.Missing, report.Bytes)
+	return report, nil
+}
@@ -0,0 +1,129 @@
+package dblayer
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDBFileStorageUsage(t *testing.T) {
+	repo := setupTestRepo(t)
+	defer SetStorageQuotas(storageUserQuota, storageGroupQuota)
+
+	usage := func(kind string, subjectID string) StorageUsageInfo {
+		info, err := repo.GetStorageUsage(kind, subjectID)
+		if err != nil {
+			t.Fatalf("Failed to read the usage: %v", err)
+		}
+		return info
+	}
+	before := usage(StorageUsageUser, "-1")
+	beforeGroup := usage(StorageUsageGroup, "-2")
+
+	stat, err := os.Stat("testdata/images/test_image.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := createTestFile(t, repo, "testdata/images/test_image.jpg", map[string]any{"name": "Usage Test"}, nil)
+	if file.Size() != stat.Size() {
+		t.Errorf("expected size %d, got %d", stat.Size(), file.Size())
+	}
+	after := usage(StorageUsageUser, "-1")
+	if after.Bytes != before.Bytes+stat.Size() || after.Files != before.Files+1 {
+		t.Errorf("expected %d bytes in %d files, got %+v", before.Bytes+stat.Size(), before.Files+1, after)
+	}
+	if got := usage(StorageUsageGroup, "-2"); got.Bytes != beforeGroup.Bytes+stat.Size() {
+		t.Errorf("expected %d bytes for the group, got %+v", beforeGroup.Bytes+stat.Size(), got)
+	}
+
+	// Quotas: the default, then an override
+	SetStorageQuotas(after.Bytes+10, 0)
+	if err := repo.CheckStorageQuota("-1", "-2", 10); err != nil {
+		t.Errorf("expected 10 bytes to fit, got %v", err)
+	}
+	var quotaError *QuotaExceededError
+	if err := repo.CheckStorageQuota("-1", "-2", 11); !errors.As(err, &quotaError) || quotaError.Kind != StorageUsageUser {
+		t.Errorf("expected the user quota to be exceeded, got %v", err)
+	}
+	unlimited := int64(0)
+	if err := repo.SetStorageQuota(StorageUsageUser, "-1", &unlimited); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.CheckStorageQuota("-1", "-2", 11); err != nil {
+		t.Errorf("expected no limit, got %v", err)
+	}
+	if err := repo.SetStorageQuota(StorageUsageUser, "-1", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// The trash still counts, the final delete does not
+	if err := hardDeleteForTests(repo, file); err != nil {
+		t.Fatalf("Failed to hard delete file: %v", err)
+	}
+	if got := usage(StorageUsageUser, "-1"); got.Bytes != before.Bytes || got.Files != before.Files {
+		t.Errorf("expected %+v, got %+v", before, got)
+	}
+
+	// The recompute agrees with the hooks
+	if _, err := repo.RecomputeStorageUsage(); err != nil {
+		t.Fatalf("Failed to recompute: %v", err)
+	}
+	createTestFile(t, repo, "testdata/files/test_document.txt", map[string]any{"name": "Usage Recompute"}, nil)
+	counted := usage(StorageUsageUser, "-1")
+	if _, err := repo.RecomputeStorageUsage(); err != nil {
+		t.Fatalf("Failed to recompute: %v", err)
+	}
+	if got := usage(StorageUsageUser, "-1"); got.Bytes != counted.Bytes || got.Files != counted.Files {
+		t.Errorf("expected %+v, got %+v", counted, got)
+	}
+}
+
+func TestDBFileStorageQuota(t *testing.T) {
+	repo := setupTestRepo(t)
+	defer SetStorageQuotas(storageUserQuota, storageGroupQuota)
+
+	dir := t.TempDir()
+	small := filepath.Join(dir, "quota_small.txt")
+	large := filepath.Join(dir, "quota_large.txt")
+	os.WriteFile(small, []byte(strings.Repeat("a", 100)), 0644)
+	os.WriteFile(large, []byte(strings.Repeat("b", 150)), 0644)
+
+	before, err := repo.GetStorageUsage(StorageUsageUser, "-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Room for 100 bytes and a few more
+	SetStorageQuotas(before.Bytes+120, 0)
+	file := createTestFile(t, repo, small, map[string]any{"name": "Quota Small"}, nil)
+	defer hardDeleteForTests(repo, file)
+
+	// Refused by the hooks, the upload is not left in the storage
+	prepareTestFile(t, small, "quota_second.txt")
+	_, err = repo.CreateObject("files", map[string]any{"name": "Quota Second", "filename": "quota_second.txt"}, nil)
+	var quotaError *QuotaExceededError
+	if !errors.As(err, &quotaError) || quotaError.Kind != StorageUsageUser || quotaError.Size != 100 {
+		t.Fatalf("expected the user quota to be exceeded, got %v", err)
+	}
+	if _, err := FileStorage.Stat("quota_second.txt"); err == nil {
+		t.Errorf("expected the refused upload to be deleted")
+	}
+	if got, _ := repo.GetStorageUsage(StorageUsageUser, "-1"); got.Bytes != before.Bytes+100 {
+		t.Errorf("expected %d bytes, got %d", before.Bytes+100, got.Bytes)
+	}
+
+	// An update counts the growth only
+	prepareTestFile(t, small, "quota_update.txt")
+	if _, err := repo.UpdateObject("files", stringValue(file, "id"), map[string]any{"filename": "quota_update.txt"}, nil); err != nil {
+		t.Errorf("expected the same size to fit, got %v", err)
+	}
+	prepareTestFile(t, large, "quota_update.txt")
+	_, err = repo.UpdateObject("files", stringValue(file, "id"), map[string]any{"filename": "quota_update.txt"}, nil)
+	if !errors.As(err, &quotaError) || quotaError.Size != 50 {
+		t.Errorf("expected 50 more bytes not to fit, got %v", err)
+	}
+	if stored := repo.GetEntityByID("files", stringValue(file, "id")); stored.(*DBFile).Size() != 100 {
+		t.Errorf("expected the content of the file to be kept")
+	}
+}
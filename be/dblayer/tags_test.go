@@ -0,0 +1,180 @@
+package dblayer
+
+import (
+	"testing"
+)
+
+func TestNormalizeTagName(t *testing.T) {
+	for name, expected := range map[string]string{
+		"Go":                    "go",
+		"  Machine   Learning ": "machine learning",
+		"\tTo\nDo":              "to do",
+		"già FATTO":             "già fatto",
+		"   ":                   "",
+		"":                      "",
+	} {
+		if got := NormalizeTagName(name); got != expected {
+			t.Errorf("%q: expected %q, got %q", name, expected, got)
+		}
+	}
+}
+
+// testTagName returns a tag name not used by other tests
+func testTagName(t *testing.T, name string) string {
+	t.Helper()
+	suffix, _ := uuid16HexGo()
+	return name + " " + suffix
+}
+
+// deleteTestTag deletes a tag, with its associations, by name
+func deleteTestTag(repo *DBRepository, name string) {
+	if tag := repo.GetTagByName(name); tag != nil {
+		repo.Delete(tag)
+	}
+}
+
+func TestRenameAndMergeTags(t *testing.T) {
+	repo := setupTestRepo(t)
+	first := createTestFolder(t, repo, map[string]any{"name": "Tagged First"}, nil)
+	defer hardDeleteForTests(repo, first.(DBObjectInterface))
+	second := createTestFolder(t, repo, map[string]any{"name": "Tagged Second"}, nil)
+	defer hardDeleteForTests(repo, second.(DBObjectInterface))
+	firstID, secondID := first.GetValue("id").(string), second.GetValue("id").(string)
+
+	colour, color, palette := testTagName(t, "colour"), testTagName(t, "color"), testTagName(t, "palette")
+	for _, name := range []string{colour, color, palette} {
+		defer deleteTestTag(repo, name)
+	}
+	if _, err := repo.TagObject(firstID, []string{colour, palette}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.TagObject(secondID, []string{color}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A rename normalizes the name
+	tag := repo.GetTagByName(palette)
+	renamed, err := repo.RenameTag(tag.GetValue("id").(string), "  PALETTE  "+palette[len("palette "):])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if renamed.GetValue("name").(string) != palette {
+		t.Errorf("expected %q, got %q", palette, renamed.GetValue("name"))
+	}
+	if _, err := repo.RenameTag(tag.GetValue("id").(string), "   "); err == nil {
+		t.Errorf("expected an empty name to be refused")
+	}
+
+	// Renaming to an existing name merges the two tags
+	colourTag := repo.GetTagByName(colour)
+	merged, err := repo.RenameTag(colourTag.GetValue("id").(string), color)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if merged.GetValue("name").(string) != color {
+		t.Errorf("expected the tag %q, got %q", color, merged.GetValue("name"))
+	}
+	if repo.GetTagByName(colour) != nil {
+		t.Errorf("expected %q to be deleted", colour)
+	}
+	for _, objectID := range []string{firstID, secondID} {
+		names := repo.GetObjectTagNames(objectID)
+		found := false
+		for _, name := range names {
+			found = found || name == color
+			if name == colour {
+				t.Errorf("%s: expected no %q, got %v", objectID, colour, names)
+			}
+		}
+		if !found {
+			t.Errorf("%s: expected %q, got %v", objectID, color, names)
+		}
+	}
+
+	// An object tagged with both keeps a single association
+	paletteTag := repo.GetTagByName(palette)
+	if _, err := repo.TagObject(firstID, []string{palette}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.MergeTags([]string{paletteTag.GetValue("id").(string)}, merged.GetValue("id").(string)); err != nil {
+		t.Fatal(err)
+	}
+	if tags := repo.GetObjectTags(firstID); len(tags) != 1 {
+		t.Errorf("expected a single tag, got %d", len(tags))
+	}
+	if _, err := repo.MergeTags([]string{"missing"}, merged.GetValue("id").(string)); err == nil {
+		t.Errorf("expected a missing source tag")
+	}
+}
+
+func TestGetObjectIDsByTags(t *testing.T) {
+	repo := setupTestRepo(t)
+	both := createTestFolder(t, repo, map[string]any{"name": "Tagged Both"}, nil)
+	defer hardDeleteForTests(repo, both.(DBObjectInterface))
+	one := createTestFolder(t, repo, map[string]any{"name": "Tagged One"}, nil)
+	defer hardDeleteForTests(repo, one.(DBObjectInterface))
+	bothID, oneID := both.GetValue("id").(string), one.GetValue("id").(string)
+
+	red, blue := testTagName(t, "red"), testTagName(t, "blue")
+	defer deleteTestTag(repo, red)
+	defer deleteTestTag(repo, blue)
+	if _, err := repo.TagObject(bothID, []string{red, blue}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.TagObject(oneID, []string{red}); err != nil {
+		t.Fatal(err)
+	}
+
+	ids := repo.GetObjectIDsByTags([]string{red})
+	if len(ids) != 2 || !ids[bothID] || !ids[oneID] {
+		t.Errorf("%s: expected both objects, got %v", red, ids)
+	}
+	// ALL the tags, matched after normalization
+	ids = repo.GetObjectIDsByTags([]string{" " + red + " ", "  " + blue})
+	if len(ids) != 1 || !ids[bothID] {
+		t.Errorf("expected %s only, got %v", bothID, ids)
+	}
+	if ids := repo.GetObjectIDsByTags([]string{red, testTagName(t, "missing")}); len(ids) != 0 {
+		t.Errorf("expected no object, got %v", ids)
+	}
+	if ids := repo.GetObjectIDsByTags([]string{" ", ""}); len(ids) != 0 {
+		t.Errorf("expected no object without tags, got %v", ids)
+	}
+
+	// Untagged objects no longer match
+	if _, err := repo.UntagObject(bothID, []string{blue}); err != nil {
+		t.Fatal(err)
+	}
+	if ids := repo.GetObjectIDsByTags([]string{red, blue}); len(ids) != 0 {
+		t.Errorf("expected no object after untagging, got %v", ids)
+	}
+}
+
+func TestGetReadableTags(t *testing.T) {
+	repo := setupTestRepo(t)
+	folder := createTestFolder(t, repo, map[string]any{"name": "Tagged Readable"}, nil)
+	defer hardDeleteForTests(repo, folder.(DBObjectInterface))
+	folderID := folder.GetValue("id").(string)
+
+	used, unused := testTagName(t, "used"), testTagName(t, "unused")
+	defer deleteTestTag(repo, used)
+	defer deleteTestTag(repo, unused)
+	if _, err := repo.TagObject(folderID, []string{used, unused}); err != nil {
+		t.Fatal(err)
+	}
+	// The tag is kept without objects
+	if _, err := repo.UntagObject(folderID, []string{unused}); err != nil {
+		t.Fatal(err)
+	}
+
+	names := map[string]bool{}
+	for _, tag := range repo.GetReadableTags() {
+		names[tag.GetValue("name").(string)] = true
+	}
+	if !names[used] || names[unused] {
+		t.Errorf("expected %s only, got %v", used, names)
+	}
+	if repo.GetTagByName(unused) == nil {
+		t.Errorf("expected %s to exist", unused)
+	}
+}
@@ -0,0 +1,239 @@
+package dblayer
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Timesheet grouping dimensions and periods
+var (
+	TimesheetGroupings = []string{"person", "client", "project", "period"}
+	TimesheetPeriods   = []string{"day", "week", "month"}
+)
+
+// TimesheetEntry is a timetrack as seen by the timesheet API.
+// Person is the user who logged the time (the owner of the timetrack),
+// Client the person, company or project the time is billed to (fk_obj_id).
+type TimesheetEntry struct {
+	ID          string  `json:"id"`
+	Name        string  `json:"name"`
+	Description string  `json:"description,omitempty"`
+	Person      string  `json:"person"`
+	PersonName  string  `json:"person_name,omitempty"`
+	Client      string  `json:"client,omitempty"`
+	ClientName  string  `json:"client_name,omitempty"`
+	Project     string  `json:"project,omitempty"`
+	ProjectName string  `json:"project_name,omitempty"`
+	Start       string  `json:"start"`
+	End         string  `json:"end,omitempty"`
+	Hours       float64 `json:"hours"`
+	TravelHours float64 `json:"travel_hours,omitempty"`
+	Km          int     `json:"km,omitempty"`
+	CostPerHour float64 `json:"cost_per_hour,omitempty"`
+	Currency    string  `json:"currency,omitempty"`
+	Running     bool    `json:"running,omitempty"`
+
+	start time.Time
+}
+
+// TimesheetRow is a line of an aggregated timesheet report.
+// Only the fields of the requested groupings are set.
+type TimesheetRow struct {
+	Person      string  `json:"person,omitempty"`
+	PersonName  string  `json:"person_name,omitempty"`
+	Client      string  `json:"client,omitempty"`
+	ClientName  string  `json:"client_name,omitempty"`
+	Project     string  `json:"project,omitempty"`
+	ProjectName string  `json:"project_name,omitempty"`
+	Period      string  `json:"period,omitempty"`
+	Currency    string  `json:"currency,omitempty"`
+	Entries     int     `json:"entries"`
+	Hours       float64 `json:"hours"`
+	TravelHours float64 `json:"travel_hours"`
+	Amount      float64 `json:"amount"`
+}
+
+// stringValue returns a column value as string, "" when NULL
+func stringValue(dbe DBEntityInterface, column string) string {
+	value := dbe.GetValue(column)
+	if value == nil {
+		return ""
+	}
+	if s, ok := value.(string); ok {
+		return s
+	}
+	return fmt.Sprint(value)
+}
+
+// legacyHours reads the durations stored by the PHP application in the datetime
+// columns ore_intervento and ore_viaggio: only the time part is meaningful
+func legacyHours(value string) float64 {
+	if value == "" {
+		return 0
+	}
+	parts := strings.Fields(value)
+	clock := strings.Split(parts[len(parts)-1], ":")
+	if len(clock) < 2 {
+		return 0
+	}
+	hours, err1 := strconv.Atoi(clock[0])
+	minutes, err2 := strconv.Atoi(clock[1])
+	if err1 != nil || err2 != nil {
+		return 0
+	}
+	seconds := 0
+	if len(clock) > 2 {
+		seconds, _ = strconv.Atoi(clock[2])
+	}
+	return float64(hours) + float64(minutes)/60 + float64(seconds)/3600
+}
+
+// roundHours rounds to the hundredth of hour
+func roundHours(hours float64) float64 {
+	return math.Round(hours*100) / 100
+}
+
+// NewTimesheetEntry converts a DBTimetrack into a TimesheetEntry.
+// The worked hours are the interval dalle_ore..alle_ore; entries without an
+// interval (imported from the PHP application) use ore_intervento instead.
+// A running timer (dalle_ore without alle_ore) counts zero hours.
+func NewTimesheetEntry(dbe DBEntityInterface) TimesheetEntry {
+	entry := TimesheetEntry{
+		ID:          stringValue(dbe, "id"),
+		Name:        stringValue(dbe, "name"),
+		Description: stringValue(dbe, "description"),
+		Person:      stringValue(dbe, "owner"),
+		Client:      stringValue(dbe, "fk_obj_id"),
+		Project:     stringValue(dbe, "fk_progetto"),
+		Start:       stringValue(dbe, "dalle_ore"),
+		End:         stringValue(dbe, "alle_ore"),
+		Currency:    stringValue(dbe, "costo_valuta"),
+		TravelHours: roundHours(legacyHours(stringValue(dbe, "ore_viaggio"))),
+	}
+	entry.Km, _ = strconv.Atoi(stringValue(dbe, "km_viaggio"))
+	entry.CostPerHour, _ = strconv.ParseFloat(stringValue(dbe, "costo_per_ora"), 64)
+
+	if entry.Start != "" {
+		entry.start, _ = ParseDateTime(entry.Start)
+	}
+	if entry.Start != "" && entry.End != "" {
+		if end, err := ParseDateTime(entry.End); err == nil && !entry.start.IsZero() {
+			entry.Hours = roundHours(end.Sub(entry.start).Hours())
+		}
+	} else if entry.Start != "" {
+		entry.Running = true
+	} else {
+		entry.Hours = roundHours(legacyHours(stringValue(dbe, "ore_intervento")))
+	}
+	return entry
+}
+
+// TimesheetPeriod returns the key of the period containing t:
+// day "2006-01-02", ISO week "2006-W01", month "2006-01"
+func TimesheetPeriod(t time.Time, period string) (string, error) {
+	switch period {
+	case "day":
+		return t.Format(time.DateOnly), nil
+	case "week":
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%04d-W%02d", year, week), nil
+	case "month":
+		return t.Format("2006-01"), nil
+	}
+	return "", fmt.Errorf("invalid period: %s", period)
+}
+
+// ParseTimesheetGroupBy validates a comma separated list of groupings.
+// An empty list groups by person and client.
+func ParseTimesheetGroupBy(groupBy string) ([]string, error) {
+	groupings := []string{}
+	for _, g := range strings.Split(groupBy, ",") {
+		g = strings.TrimSpace(strings.ToLower(g))
+		if g == "" {
+			continue
+		}
+		valid := false
+		for _, v := range TimesheetGroupings {
+			valid = valid || v == g
+		}
+		if !valid {
+			return nil, fmt.Errorf("invalid grouping: %s", g)
+		}
+		groupings = append(groupings, g)
+	}
+	if len(groupings) == 0 {
+		groupings = []string{"person", "client"}
+	}
+	return groupings, nil
+}
+
+// AggregateTimesheet sums the entries by the given groupings.
+// Rows are also split by currency, so that amounts are never mixed.
+// When grouping by period, period must be one of TimesheetPeriods.
+func AggregateTimesheet(entries []TimesheetEntry, groupBy []string, period string) ([]TimesheetRow, error) {
+	has := make(map[string]bool)
+	for _, g := range groupBy {
+		has[g] = true
+	}
+	if has["period"] {
+		if _, err := TimesheetPeriod(time.Now(), period); err != nil {
+			return nil, err
+		}
+	}
+
+	rows := make(map[string]*TimesheetRow)
+	keys := []string{}
+	for _, entry := range entries {
+		row := TimesheetRow{Currency: entry.Currency}
+		if has["person"] {
+			row.Person, row.PersonName = entry.Person, entry.PersonName
+		}
+		if has["client"] {
+			row.Client, row.ClientName = entry.Client, entry.ClientName
+		}
+		if has["project"] {
+			row.Project, row.ProjectName = entry.Project, entry.ProjectName
+		}
+		if has["period"] && !entry.start.IsZero() {
+			row.Period, _ = TimesheetPeriod(entry.start, period)
+		}
+		key := strings.Join([]string{row.Person, row.Client, row.Project, row.Period, row.Currency}, "|")
+		existing, ok := rows[key]
+		if !ok {
+			existing = &row
+			rows[key] = existing
+			keys = append(keys, key)
+		}
+		existing.Entries++
+		existing.Hours += entry.Hours
+		existing.TravelHours += entry.TravelHours
+		existing.Amount += entry.Hours * entry.CostPerHour
+	}
+
+	result := make([]TimesheetRow, 0, len(keys))
+	for _, key := range keys {
+		row := rows[key]
+		row.Hours = roundHours(row.Hours)
+		row.TravelHours = roundHours(row.TravelHours)
+		row.Amount = math.Round(row.Amount*100) / 100
+		result = append(result, *row)
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if a.Period != b.Period {
+			return a.Period < b.Period
+		}
+		if a.PersonName+a.Person != b.PersonName+b.Person {
+			return a.PersonName+a.Person < b.PersonName+b.Person
+		}
+		if a.ClientName+a.Client != b.ClientName+b.Client {
+			return a.ClientName+a.Client < b.ClientName+b.Client
+		}
+		return a.ProjectName+a.Project < b.ProjectName+b.Project
+	})
+	return result, nil
+}
@@ -0,0 +1,97 @@
+package dblayer
+
+import (
+	"testing"
+	"time"
+)
+
+func newTestTimetrack(owner, client, start, end string, costPerHour string) DBEntityInterface {
+	timetrack := NewDBTimetrack()
+	timetrack.SetValue("id", owner+start)
+	timetrack.SetValue("owner", owner)
+	timetrack.SetValue("fk_obj_id", client)
+	if start != "" {
+		timetrack.SetValue("dalle_ore", start)
+	}
+	if end != "" {
+		timetrack.SetValue("alle_ore", end)
+	}
+	timetrack.SetValue("costo_per_ora", costPerHour)
+	timetrack.SetValue("costo_valuta", "EUR")
+	return timetrack
+}
+
+func TestNewTimesheetEntryHours(t *testing.T) {
+	entry := NewTimesheetEntry(newTestTimetrack("u1", "c1", "2024-03-01 09:00:00", "2024-03-01 11:30:00", "40"))
+	if entry.Hours != 2.5 || entry.Running {
+		t.Errorf("interval: got hours=%v running=%v, want 2.5 false", entry.Hours, entry.Running)
+	}
+
+	running := NewTimesheetEntry(newTestTimetrack("u1", "c1", "2024-03-01 09:00:00", "", "0"))
+	if running.Hours != 0 || !running.Running {
+		t.Errorf("timer: got hours=%v running=%v, want 0 true", running.Hours, running.Running)
+	}
+
+	legacy := newTestTimetrack("u1", "c1", "", "", "0")
+	legacy.SetValue("ore_intervento", "0000-00-00 01:45:00")
+	legacy.SetValue("ore_viaggio", "1970-01-01 00:30:00")
+	entry = NewTimesheetEntry(legacy)
+	if entry.Hours != 1.75 || entry.TravelHours != 0.5 {
+		t.Errorf("legacy: got hours=%v travel=%v, want 1.75 0.5", entry.Hours, entry.TravelHours)
+	}
+}
+
+func TestTimesheetPeriod(t *testing.T) {
+	day := time.Date(2024, 12, 30, 10, 0, 0, 0, time.Local)
+	tests := map[string]string{"day": "2024-12-30", "week": "2025-W01", "month": "2024-12"}
+	for period, want := range tests {
+		got, err := TimesheetPeriod(day, period)
+		if err != nil || got != want {
+			t.Errorf("TimesheetPeriod(%s) = %q, %v; want %q", period, got, err, want)
+		}
+	}
+	if _, err := TimesheetPeriod(day, "year"); err == nil {
+		t.Error("expected an error for an invalid period")
+	}
+}
+
+func TestAggregateTimesheet(t *testing.T) {
+	entries := []TimesheetEntry{
+		NewTimesheetEntry(newTestTimetrack("u1", "c1", "2024-03-01 09:00:00", "2024-03-01 11:00:00", "50")),
+		NewTimesheetEntry(newTestTimetrack("u1", "c1", "2024-03-02 09:00:00", "2024-03-02 10:00:00", "50")),
+		NewTimesheetEntry(newTestTimetrack("u2", "c1", "2024-04-01 09:00:00", "2024-04-01 09:30:00", "30")),
+		NewTimesheetEntry(newTestTimetrack("u1", "c2", "2024-04-02 09:00:00", "2024-04-02 12:00:00", "50")),
+	}
+
+	rows, err := AggregateTimesheet(entries, []string{"client"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 || rows[0].Client != "c1" || rows[0].Hours != 3.5 || rows[0].Amount != 165 || rows[0].Entries != 3 {
+		t.Errorf("by client: unexpected rows %+v", rows)
+	}
+
+	rows, err = AggregateTimesheet(entries, []string{"person", "period"}, "month")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		period, person string
+		hours          float64
+	}{{"2024-03", "u1", 3}, {"2024-04", "u1", 3}, {"2024-04", "u2", 0.5}}
+	if len(rows) != len(want) {
+		t.Fatalf("by person and month: got %d rows, want %d", len(rows), len(want))
+	}
+	for i, w := range want {
+		if rows[i].Period != w.period || rows[i].Person != w.person || rows[i].Hours != w.hours || rows[i].Client != "" {
+			t.Errorf("row %d: got %+v, want %+v", i, rows[i], w)
+		}
+	}
+
+	if _, err := AggregateTimesheet(entries, []string{"period"}, "year"); err == nil {
+		t.Error("expected an error for an invalid period")
+	}
+	if _, err := ParseTimesheetGroupBy("person,color"); err == nil {
+		t.Error("expected an error for an invalid grouping")
+	}
+}
@@ -0,0 +1,364 @@
+package dblayer
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Supported vCard versions
+const (
+	VCardVersion3 = "3.0"
+	VCardVersion4 = "4.0"
+)
+
+// VCardContact is the data of a person or a company exchanged as vCard
+type VCardContact struct {
+	UID           string
+	Company       bool // KIND:org, or X-ABShowAs:COMPANY of Apple clients
+	Name          string
+	Organization  string
+	Street        string
+	City          string
+	State         string
+	Zip           string
+	Country       string
+	Phone         string
+	Mobile        string
+	OfficePhone   string
+	Fax           string
+	Emails        []string
+	URL           string
+	Note          string
+	CodiceFiscale string
+	PIva          string
+}
+
+// ContactVCardUID returns the UID of a contact of rhobee
+func ContactVCardUID(contactID string) string {
+	return contactID + "@" + ICalUIDDomain
+}
+
+// vcardType formats the TYPE parameter: TYPE=WORK,VOICE in 3.0, TYPE="work,voice" in 4.0
+func vcardType(version string, types ...string) string {
+	if version == VCardVersion4 {
+		value := strings.ToLower(strings.Join(types, ","))
+		if len(types) > 1 {
+			value = `"` + value + `"`
+		}
+		return ";TYPE=" + value
+	}
+	return ";TYPE=" + strings.ToUpper(strings.Join(types, ","))
+}
+
+// splitVCardName splits a full name in the given names and the family name (the last word)
+func splitVCardName(name string) (string, string) {
+	words := strings.Fields(name)
+	if len(words) < 2 {
+		return "", name
+	}
+	return strings.Join(words[:len(words)-1], " "), words[len(words)-1]
+}
+
+// ContactToVCard returns the vCard of a DBPerson or DBCompany.
+// uid is the UID chosen by a CardDAV client, "" for the default one; country is
+// the name of the country of the contact, organization the name of the company
+// of a person.
+func ContactToVCard(dbe DBEntityInterface, version string, uid string, country string, organization string) string {
+	if version != VCardVersion4 {
+		version = VCardVersion3
+	}
+	company := dbe.GetTypeName() == "DBCompany"
+	name := stringValue(dbe, "name")
+	if uid == "" {
+		uid = ContactVCardUID(stringValue(dbe, "id"))
+	}
+
+	lines := []string{
+		"BEGIN:VCARD",
+		"VERSION:" + version,
+		"PRODID:-//rhobee//Contacts//EN",
+		"UID:" + ICalEscape(uid),
+		"FN:" + ICalEscape(name),
+	}
+	if company {
+		if version == VCardVersion4 {
+			lines = append(lines, "KIND:org")
+		} else {
+			lines = append(lines, "X-ABShowAs:COMPANY")
+		}
+		lines = append(lines, "N:"+ICalEscape(name)+";;;;", "ORG:"+ICalEscape(name))
+	} else {
+		given, family := splitVCardName(name)
+		lines = append(lines, "N:"+ICalEscape(family)+";"+ICalEscape(given)+";;;")
+		if organization != "" {
+			lines = append(lines, "ORG:"+ICalEscape(organization))
+		}
+	}
+
+	addressType := "home"
+	phoneType := "home"
+	if company {
+		addressType, phoneType = "work", "work"
+	}
+	street, city, state, zip := stringValue(dbe, "street"), stringValue(dbe, "city"), stringValue(dbe, "state"), stringValue(dbe, "zip")
+	if street != "" || city != "" || state != "" || zip != "" || country != "" {
+		lines = append(lines, "ADR"+vcardType(version, addressType)+":;;"+strings.Join([]string{
+			ICalEscape(street), ICalEscape(city), ICalEscape(state), ICalEscape(zip), ICalEscape(country),
+		}, ";"))
+	}
+	phones := []struct {
+		column string
+		types  []string
+	}{
+		{"phone", []string{phoneType, "voice"}},
+		{"office_phone", []string{"work", "voice"}},
+		{"mobile", []string{"cell", "voice"}},
+		{"fax", []string{phoneType, "fax"}},
+	}
+	for _, phone := range phones {
+		if value := stringValue(dbe, phone.column); value != "" {
+			lines = append(lines, "TEL"+vcardType(version, phone.types...)+":"+ICalEscape(value))
+		}
+	}
+	if email := stringValue(dbe, "email"); email != "" {
+		if version == VCardVersion4 {
+			lines = append(lines, "EMAIL"+vcardType(version, phoneType)+":"+ICalEscape(email))
+		} else {
+			lines = append(lines, "EMAIL"+vcardType(version, "internet", phoneType)+":"+ICalEscape(email))
+		}
+	}
+	if url := stringValue(dbe, "url"); url != "" {
+		lines = append(lines, "URL:"+stripLineBreaks(url))
+	}
+	if note := stringValue(dbe, "description"); note != "" {
+		lines = append(lines, "NOTE:"+ICalEscape(note))
+	}
+	if cf := stringValue(dbe, "codice_fiscale"); cf != "" {
+		lines = append(lines, "X-CODICE-FISCALE:"+ICalEscape(cf))
+	}
+	if piva := stringValue(dbe, "p_iva"); piva != "" {
+		lines = append(lines, "X-PARTITA-IVA:"+ICalEscape(piva))
+	}
+	if modified, err := ParseDateTime(stringValue(dbe, "last_modify_date")); err == nil {
+		if version == VCardVersion4 {
+			lines = append(lines, "REV:"+modified.UTC().Format(icalDateTimeLayout)+"Z")
+		} else {
+			lines = append(lines, "REV:"+modified.UTC().Format("2006-01-02T15:04:05Z"))
+		}
+	}
+	lines = append(lines, "END:VCARD")
+
+	var sb strings.Builder
+	for _, line := range lines {
+		sb.WriteString(ICalFoldLine(line))
+	}
+	return sb.String()
+}
+
+// splitVCardValue splits a structured value (N, ADR, ORG) on the unescaped semicolons
+func splitVCardValue(value string) []string {
+	parts := []string{}
+	var sb strings.Builder
+	escaped := false
+	for _, r := range value {
+		switch {
+		case escaped:
+			sb.WriteRune('\\')
+			sb.WriteRune(r)
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == ';':
+			parts = append(parts, ICalUnescape(sb.String()))
+			sb.Reset()
+		default:
+			sb.WriteRune(r)
+		}
+	}
+	return append(parts, ICalUnescape(sb.String()))
+}
+
+// vcardComponent returns the i-th component of a structured value, "" when missing
+func vcardComponent(parts []string, i int) string {
+	if i < len(parts) {
+		return strings.TrimSpace(parts[i])
+	}
+	return ""
+}
+
+// hasVCardType tells if a TYPE parameter contains the given type
+func hasVCardType(prop ICalProperty, value string) bool {
+	for _, t := range strings.Split(prop.Params["TYPE"], ",") {
+		if strings.EqualFold(strings.TrimSpace(t), value) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseVCards reads the contacts of a vCard 2.1, 3.0 or 4.0 stream
+func ParseVCards(data string) ([]VCardContact, error) {
+	contacts := []VCardContact{}
+	var current *VCardContact
+	var given, family string
+	found := false
+	for _, line := range unfoldICal(data) {
+		prop, ok := parseICalProperty(line)
+		if !ok {
+			continue
+		}
+		switch prop.Name {
+		case "BEGIN":
+			if strings.EqualFold(prop.Value, "VCARD") {
+				current = &VCardContact{Emails: []string{}}
+				given, family = "", ""
+				found = true
+			}
+			continue
+		case "END":
+			if strings.EqualFold(prop.Value, "VCARD") && current != nil {
+				if current.Name == "" {
+					current.Name = strings.TrimSpace(given + " " + family)
+				}
+				if current.Name == "" && current.Company {
+					current.Name = current.Organization
+				}
+				contacts = append(contacts, *current)
+				current = nil
+			}
+			continue
+		}
+		if current == nil {
+			continue
+		}
+		value := strings.TrimSpace(prop.Value)
+		switch prop.Name {
+		case "UID":
+			current.UID = ICalUnescape(value)
+		case "FN":
+			current.Name = strings.TrimSpace(ICalUnescape(value))
+		case "N":
+			parts := splitVCardValue(value)
+			family = vcardComponent(parts, 0)
+			given = strings.TrimSpace(vcardComponent(parts, 1) + " " + vcardComponent(parts, 2))
+		case "KIND":
+			current.Company = strings.EqualFold(value, "org")
+		case "X-ABSHOWAS":
+			current.Company = strings.EqualFold(value, "COMPANY")
+		case "ORG":
+			current.Organization = vcardComponent(splitVCardValue(value), 0)
+		case "ADR":
+			// Keep the first address, or the preferred one
+			if current.Street != "" && !hasVCardType(prop, "pref") && prop.Params["PREF"] == "" {
+				continue
+			}
+			parts := splitVCardValue(value)
+			current.Street = strings.TrimSpace(strings.Join(strings.Fields(vcardComponent(parts, 1)+" "+vcardComponent(parts, 2)), " "))
+			current.City = vcardComponent(parts, 3)
+			current.State = vcardComponent(parts, 4)
+			current.Zip = vcardComponent(parts, 5)
+			current.Country = vcardComponent(parts, 6)
+		case "TEL":
+			number := strings.TrimPrefix(ICalUnescape(value), "tel:")
+			switch {
+			case hasVCardType(prop, "fax"):
+				if current.Fax == "" {
+					current.Fax = number
+				}
+			case hasVCardType(prop, "cell"):
+				if current.Mobile == "" {
+					current.Mobile = number
+				}
+			case hasVCardType(prop, "work") && !current.Company:
+				if current.OfficePhone == "" {
+					current.OfficePhone = number
+				}
+			default:
+				if current.Phone == "" {
+					current.Phone = number
+				}
+			}
+		case "EMAIL":
+			if email := strings.TrimPrefix(ICalUnescape(value), "mailto:"); email != "" {
+				if hasVCardType(prop, "pref") || prop.Params["PREF"] != "" {
+					current.Emails = append([]string{email}, current.Emails...)
+				} else {
+					current.Emails = append(current.Emails, email)
+				}
+			}
+		case "URL":
+			if current.URL == "" {
+				current.URL = ICalUnescape(value)
+			}
+		case "NOTE":
+			current.Note = ICalUnescape(value)
+		case "X-CODICE-FISCALE":
+			current.CodiceFiscale = ICalUnescape(value)
+		case "X-PARTITA-IVA":
+			current.PIva = ICalUnescape(value)
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("no VCARD found")
+	}
+	return contacts, nil
+}
+
+// TableName returns the table of the contact: companies or people
+func (c *VCardContact) TableName() string {
+	if c.Company {
+		return "companies"
+	}
+	return "people"
+}
+
+// columnValues returns the values of the columns of a DBPerson or DBCompany
+// stored in a vCard
+func (c *VCardContact) columnValues(dbe DBEntityInterface) map[string]string {
+	values := map[string]string{
+		"name":        c.Name,
+		"street":      c.Street,
+		"city":        c.City,
+		"state":       c.State,
+		"zip":         c.Zip,
+		"phone":       c.Phone,
+		"fax":         c.Fax,
+		"url":         c.URL,
+		"description": c.Note,
+		"p_iva":       c.PIva,
+	}
+	if len(c.Emails) > 0 {
+		values["email"] = c.Emails[0]
+	}
+	if dbe.GetTypeName() == "DBPerson" {
+		values["mobile"] = c.Mobile
+		values["office_phone"] = c.OfficePhone
+		values["codice_fiscale"] = c.CodiceFiscale
+	} else if values["phone"] == "" {
+		values["phone"] = c.OfficePhone
+	}
+	return values
+}
+
+// ApplyTo copies the non empty fields of the contact to a DBPerson or DBCompany.
+// The country and the company of a person are resolved by the caller.
+func (c *VCardContact) ApplyTo(dbe DBEntityInterface) {
+	for column, value := range c.columnValues(dbe) {
+		if value != "" {
+			dbe.SetValue(column, value)
+		}
+	}
+}
+
+// Replace sets the fields of a DBPerson or DBCompany to the ones of the
+// contact, clearing the missing ones: the vCard is the whole contact, as an
+// upload of a CardDAV client.
+func (c *VCardContact) Replace(dbe DBEntityInterface) {
+	for column, value := range c.columnValues(dbe) {
+		if value != "" {
+			dbe.SetValue(column, value)
+		} else {
+			dbe.SetValue(column, nil)
+		}
+	}
+}
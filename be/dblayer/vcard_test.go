@@ -0,0 +1,227 @@
+package dblayer
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestContactToVCard(t *testing.T) {
+	person := NewDBPerson()
+	person.SetValue("id", "p1")
+	person.SetValue("name", "Mario De Rossi")
+	person.SetValue("street", "Via Roma, 1")
+	person.SetValue("city", "Milano")
+	person.SetValue("zip", "20100")
+	person.SetValue("phone", "02 123")
+	person.SetValue("mobile", "333 456")
+	person.SetValue("email", "mario@example.com")
+	person.SetValue("codice_fiscale", "RSSMRA80A01F205X")
+	person.SetValue("last_modify_date", "2024-03-01 10:00:00")
+
+	card := ContactToVCard(person, VCardVersion3, "", "Italy", "ACME; Srl")
+	for _, expected := range []string{
+		"BEGIN:VCARD\r\nVERSION:3.0\r\n",
+		"UID:p1@rhobee\r\n",
+		"FN:Mario De Rossi\r\n",
+		"N:Rossi;Mario De;;;\r\n",
+		"ORG:ACME\\; Srl\r\n",
+		"ADR;TYPE=HOME:;;Via Roma\\, 1;Milano;;20100;Italy\r\n",
+		"TEL;TYPE=HOME,VOICE:02 123\r\n",
+		"TEL;TYPE=CELL,VOICE:333 456\r\n",
+		"EMAIL;TYPE=INTERNET,HOME:mario@example.com\r\n",
+		"X-CODICE-FISCALE:RSSMRA80A01F205X\r\n",
+		"END:VCARD\r\n",
+	} {
+		if !strings.Contains(card, expected) {
+			t.Errorf("missing %q in\n%s", expected, card)
+		}
+	}
+
+	company := NewDBCompany()
+	company.SetValue("id", "c1")
+	company.SetValue("name", "ACME")
+	company.SetValue("phone", "02 999")
+	card = ContactToVCard(company, VCardVersion4, "work@example.com", "", "")
+	for _, expected := range []string{"VERSION:4.0\r\n", "UID:work@example.com\r\n", "KIND:org\r\n", "ORG:ACME\r\n", "TEL;TYPE=\"work,voice\":02 999\r\n"} {
+		if !strings.Contains(card, expected) {
+			t.Errorf("missing %q in\n%s", expected, card)
+		}
+	}
+}
+
+func TestContactToVCardLineBreaks(t *testing.T) {
+	person := NewDBPerson()
+	person.SetValue("id", "p1")
+	person.SetValue("name", "Mario Rossi")
+	person.SetValue("email", "mario@example.com\r\nTEL:666")
+	person.SetValue("url", "https://example.com/a,b\nUID:evil\rEMAIL:x@example.com")
+	person.SetValue("description", "line 1\rline 2")
+
+	for _, version := range []string{VCardVersion3, VCardVersion4} {
+		card := ContactToVCard(person, version, "", "", "")
+		for _, line := range strings.Split(strings.TrimSuffix(card, "\r\n"), "\r\n") {
+			if strings.ContainsAny(line, "\r\n") {
+				t.Errorf("%s: line break in %q", version, line)
+			}
+			for _, injected := range []string{"TEL:", "UID:evil", "EMAIL:x@"} {
+				if strings.HasPrefix(line, injected) {
+					t.Errorf("%s: injected property %q", version, line)
+				}
+			}
+		}
+		if !strings.Contains(card, "URL:https://example.com/a,bUID:evilEMAIL:x@example.com\r\n") {
+			t.Errorf("%s: expected the URL without line breaks in\n%s", version, card)
+		}
+		cards, err := ParseVCards(card)
+		if err != nil || len(cards) != 1 {
+			t.Fatalf("%s: ParseVCards: %v, %d cards", version, err, len(cards))
+		}
+		if len(cards[0].Emails) != 1 || cards[0].Emails[0] != "mario@example.com\nTEL:666" {
+			t.Errorf("%s: expected the email as stored, got %q", version, cards[0].Emails)
+		}
+	}
+}
+
+func TestParseVCards(t *testing.T) {
+	data := "BEGIN:VCARD\r\n" +
+		"VERSION:3.0\r\n" +
+		"N:Rossi;Mario;;;\r\n" +
+		"ORG:ACME;Sales\r\n" +
+		"item1.EMAIL;TYPE=INTERNET:mario@work.example.com\r\n" +
+		"EMAIL;TYPE=INTERNET,PREF:mario@example.com\r\n" +
+		"TEL;TYPE=CELL:333 456\r\n" +
+		"TEL;TYPE=WORK:02 777\r\n" +
+		"TEL;TYPE=WORK,FAX:02 778\r\n" +
+		"ADR;TYPE=HOME:;;Via Roma\\, 1;Milano;MI;20100;Italia\r\n" +
+		"NOTE:line one\\nline\r\n  two\r\n" +
+		"END:VCARD\r\n" +
+		"BEGIN:VCARD\n" +
+		"VERSION:2.1\n" +
+		"FN:ACME\n" +
+		"ORG:ACME\n" +
+		"X-ABShowAs:COMPANY\n" +
+		"TEL;WORK;VOICE:02 999\n" +
+		"END:VCARD\n"
+	contacts, err := ParseVCards(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(contacts) != 2 {
+		t.Fatalf("got %d contacts", len(contacts))
+	}
+	mario := contacts[0]
+	if mario.Company || mario.Name != "Mario Rossi" || mario.Organization != "ACME" {
+		t.Errorf("person: %+v", mario)
+	}
+	if len(mario.Emails) != 2 || mario.Emails[0] != "mario@example.com" {
+		t.Errorf("emails: %v", mario.Emails)
+	}
+	if mario.Mobile != "333 456" || mario.OfficePhone != "02 777" || mario.Fax != "02 778" || mario.Phone != "" {
+		t.Errorf("phones: %+v", mario)
+	}
+	if mario.Street != "Via Roma, 1" || mario.City != "Milano" || mario.State != "MI" || mario.Zip != "20100" || mario.Country != "Italia" {
+		t.Errorf("address: %+v", mario)
+	}
+	if mario.Note != "line one\nline two" {
+		t.Errorf("note: %q", mario.Note)
+	}
+	acme := contacts[1]
+	if !acme.Company || acme.Name != "ACME" || acme.TableName() != "companies" || acme.Phone != "02 999" {
+		t.Errorf("company: %+v", acme)
+	}
+
+	person := NewDBPerson()
+	person.SetValue("phone", "010 1")
+	mario.ApplyTo(person)
+	if stringValue(person, "email") != "mario@example.com" || stringValue(person, "mobile") != "333 456" || stringValue(person, "phone") != "010 1" {
+		t.Errorf("ApplyTo: %v", person.GetAllValues())
+	}
+
+	mario.Replace(person)
+	if person.GetValue("phone") != nil || stringValue(person, "mobile") != "333 456" || stringValue(person, "name") != "Mario Rossi" {
+		t.Errorf("Replace: %v", person.GetAllValues())
+	}
+
+	if _, err := ParseVCards("hello"); err == nil {
+		t.Error("expected an error without VCARD")
+	}
+}
+
+func TestVCardRoundTrip(t *testing.T) {
+	for _, version := range []string{VCardVersion3, VCardVersion4} {
+		company := NewDBCompany()
+		company.SetValue("id", "c1")
+		company.SetValue("name", "Rossi, Bianchi & C.")
+		company.SetValue("city", "Torino")
+		company.SetValue("phone", "011 1")
+		company.SetValue("fax", "011 2")
+		company.SetValue("email", "info@example.com")
+		company.SetValue("p_iva", "01234567890")
+		contacts, err := ParseVCards(ContactToVCard(company, version, "", "Italy", ""))
+		if err != nil || len(contacts) != 1 {
+			t.Fatalf("%s: %v %v", version, contacts, err)
+		}
+		c := contacts[0]
+		if !c.Company || c.Name != "Rossi, Bianchi & C." || c.City != "Torino" || c.Country != "Italy" ||
+			c.Phone != "011 1" || c.Fax != "011 2" || c.Emails[0] != "info@example.com" || c.PIva != "01234567890" || c.UID != "c1@rhobee" {
+			t.Errorf("%s: %+v", version, c)
+		}
+	}
+}
+
+func TestSaveCardDAVContactUID(t *testing.T) {
+	repo := setupTestRepo(t)
+	folder := createTestFolder(t, repo, map[string]any{"name": "CardDAV Folder"}, nil)
+	defer hardDeleteForTests(repo, folder.(DBObjectInterface))
+	other := createTestFolder(t, repo, map[string]any{"name": "CardDAV Other"}, nil)
+	defer hardDeleteForTests(repo, other.(DBObjectInterface))
+	folderID, otherID := stringValue(folder, "id"), stringValue(other, "id")
+
+	uid, _ := uuid16HexGo()
+	vcard := func(uid string, name string) string {
+		return "BEGIN:VCARD\r\nVERSION:3.0\r\nUID:" + uid + "\r\nFN:" + name + "\r\nN:" + name + ";;;;\r\nEND:VCARD\r\n"
+	}
+	saved, err := repo.SaveCardDAVContact(folderID, "ada.vcf", vcard(uid, "Ada Lovelace"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hardDeleteForTests(repo, saved.(DBObjectInterface))
+	second, err := repo.SaveCardDAVContact(folderID, "alan.vcf", vcard("alan-"+uid, "Alan Turing"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hardDeleteForTests(repo, second.(DBObjectInterface))
+
+	// The UID of another contact, by mapping or by default
+	if _, err := repo.SaveCardDAVContact(otherID, "ada.vcf", vcard(uid, "Ada Copy"), nil); !errors.Is(err, ErrVCardUIDConflict) {
+		t.Errorf("another folder: expected a conflict, got %v", err)
+	}
+	if _, err := repo.SaveCardDAVContact(folderID, "alan.vcf", vcard(uid, "Alan Turing"), second); !errors.Is(err, ErrVCardUIDConflict) {
+		t.Errorf("another contact: expected a conflict, got %v", err)
+	}
+	defaultUID := ContactVCardUID(stringValue(saved, "id"))
+	if _, err := repo.SaveCardDAVContact(folderID, "alan.vcf", vcard(defaultUID, "Alan Turing"), second); !errors.Is(err, ErrVCardUIDConflict) {
+		t.Errorf("default UID: expected a conflict, got %v", err)
+	}
+	// Still mapped to the first contact
+	if contact := repo.FindContactByVCardUID(uid); contact == nil || stringValue(contact, "id") != stringValue(saved, "id") {
+		t.Errorf("expected %s to be mapped to %s, got %v", uid, saved.GetValue("id"), contact)
+	}
+	if contact := repo.FindContactByVCardUID("alan-" + uid); contact == nil || stringValue(contact, "id") != stringValue(second, "id") {
+		t.Errorf("expected the UID of %s to be kept, got %v", second.GetValue("id"), contact)
+	}
+
+	// Released by the deletion of the contact
+	if _, err := repo.Delete(saved); err != nil {
+		t.Fatal(err)
+	}
+	copied, err := repo.SaveCardDAVContact(otherID, "ada.vcf", vcard(uid, "Ada Copy"), nil)
+	if err != nil {
+		t.Fatalf("expected the UID of a deleted contact to be reused, got %v", err)
+	}
+	defer hardDeleteForTests(repo, copied.(DBObjectInterface))
+	if contact := repo.FindContactByVCardUID(uid); contact == nil || stringValue(contact, "id") != stringValue(copied, "id") {
+		t.Errorf("expected %s to be mapped to %s, got %v", uid, copied.GetValue("id"), contact)
+	}
+}
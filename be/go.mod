@@ -3,9 +3,11 @@ module rprj/be
 go 1.25.4
 
 require (
+	github.com/HugoSmits86/nativewebp v0.9.3
 	github.com/go-sql-driver/mysql v1.9.3
 	github.com/golang-jwt/jwt/v5 v5.3.0
 	github.com/gorilla/mux v1.8.1
+	golang.org/x/image v0.33.0
 )
 
 require (
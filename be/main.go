@@ -44,6 +44,24 @@ package main
 // @tag.name ollama
 // @tag.description Endpoints for Ollama AI integration
 
+// @tag.name tags
+// @tag.description Tagging of DBObjects and tag cloud
+
+// @tag.name projects
+// @tag.description Projects and their people, companies and related projects
+
+// @tag.name timesheet
+// @tag.description Time logging, timers and hours reports
+
+// @tag.name events
+// @tag.description Calendar events and their recurrences
+
+// @tag.name notifications
+// @tag.description In-app notifications and event reminder channels
+
+// @tag.name contacts
+// @tag.description People and companies: vCard export and import
+
 /*
 
 Test:
@@ -101,6 +119,7 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 
 	"rprj/be/api"
@@ -153,6 +172,61 @@ func main() {
 	if filesDir := os.Getenv("FILES_DIRECTORY"); filesDir != "" {
 		AppConfig.FilesDirectory = filesDir
 	}
+	if filesLayout := os.Getenv("FILES_LAYOUT"); filesLayout != "" {
+		AppConfig.FilesLayout = filesLayout
+	}
+	// Blob storage: local or s3
+	if storageDriver := os.Getenv("STORAGE_DRIVER"); storageDriver != "" {
+		AppConfig.StorageDriver = storageDriver
+	}
+	if s3Endpoint := os.Getenv("S3_ENDPOINT"); s3Endpoint != "" {
+		AppConfig.S3Endpoint = s3Endpoint
+	}
+	if s3Region := os.Getenv("S3_REGION"); s3Region != "" {
+		AppConfig.S3Region = s3Region
+	}
+	if s3Bucket := os.Getenv("S3_BUCKET"); s3Bucket != "" {
+		AppConfig.S3Bucket = s3Bucket
+	}
+	if s3AccessKey := os.Getenv("S3_ACCESS_KEY"); s3AccessKey != "" {
+		AppConfig.S3AccessKey = s3AccessKey
+	}
+	if s3SecretKey := os.Getenv("S3_SECRET_KEY"); s3SecretKey != "" {
+		AppConfig.S3SecretKey = s3SecretKey
+	}
+	if s3Prefix := os.Getenv("S3_PREFIX"); s3Prefix != "" {
+		AppConfig.S3Prefix = s3Prefix
+	}
+	// Cache of the image renditions
+	if renditionCacheDir := os.Getenv("RENDITION_CACHE_DIRECTORY"); renditionCacheDir != "" {
+		AppConfig.RenditionCacheDirectory = renditionCacheDir
+	}
+	if renditionCacheSize := os.Getenv("RENDITION_CACHE_SIZE"); renditionCacheSize != "" {
+		if size, err := strconv.ParseInt(renditionCacheSize, 10, 64); err == nil {
+			AppConfig.RenditionCacheSize = size
+		}
+	}
+	// Malware scanning of the uploads
+	if scannerDriver := os.Getenv("SCANNER_DRIVER"); scannerDriver != "" {
+		AppConfig.ScannerDriver = scannerDriver
+	}
+	if clamdAddress := os.Getenv("CLAMD_ADDRESS"); clamdAddress != "" {
+		AppConfig.ClamdAddress = clamdAddress
+	}
+	if scanAction := os.Getenv("SCAN_ACTION"); scanAction != "" {
+		AppConfig.ScanAction = scanAction
+	}
+	// Storage quotas in bytes
+	if userQuota := os.Getenv("STORAGE_USER_QUOTA"); userQuota != "" {
+		if quota, err := strconv.ParseInt(userQuota, 10, 64); err == nil {
+			AppConfig.UserQuota = quota
+		}
+	}
+	if groupQuota := os.Getenv("STORAGE_GROUP_QUOTA"); groupQuota != "" {
+		if quota, err := strconv.ParseInt(groupQuota, 10, 64); err == nil {
+			AppConfig.GroupQuota = quota
+		}
+	}
 
 	// OAuth settings from environment variables
 	if googleClientId := os.Getenv("GOOGLE_CLIENT_ID"); googleClientId != "" {
@@ -183,6 +257,24 @@ func main() {
 			AppConfig.TelegramBotID = parts[0]
 		}
 	}
+	// SMTP settings for the email reminders
+	if smtpHost := os.Getenv("SMTP_HOST"); smtpHost != "" {
+		AppConfig.SMTPHost = smtpHost
+	}
+	if smtpPort := os.Getenv("SMTP_PORT"); smtpPort != "" {
+		if port, err := strconv.Atoi(smtpPort); err == nil {
+			AppConfig.SMTPPort = port
+		}
+	}
+	if smtpUsername := os.Getenv("SMTP_USERNAME"); smtpUsername != "" {
+		AppConfig.SMTPUsername = smtpUsername
+	}
+	if smtpPassword := os.Getenv("SMTP_PASSWORD"); smtpPassword != "" {
+		AppConfig.SMTPPassword = smtpPassword
+	}
+	if smtpFrom := os.Getenv("SMTP_FROM"); smtpFrom != "" {
+		AppConfig.SMTPFrom = smtpFrom
+	}
 
 	dblayer.InitDBLayer(AppConfig)
 	// dblayer.EnsureDBSchema()
@@ -190,6 +282,7 @@ func main() {
 
 	api.InitAPI(AppConfig)
 	api.OllamaInit(AppConfig.AppName, AppConfig.OllamaURL, AppConfig.OllamaModel)
+	api.StartReminderDispatcher(AppConfig)
 
 	// Routing
 	r := mux.NewRouter()
@@ -204,6 +297,18 @@ func main() {
 	r.HandleFunc("/nav/{objectId}/indexes", api.GetIndexesHandler).Methods("GET")
 	r.HandleFunc("/nav/search", api.NavigationSearchHandler).Methods("GET")
 
+	// Calendar: event occurrences, public events for anonymous users
+	r.HandleFunc("/events", api.GetEventsHandler).Methods("GET")
+	// iCalendar feeds: public events, or the events readable by the owner of the token
+	r.HandleFunc("/calendar/feed.ics", api.PublicCalendarFeedHandler).Methods("GET")
+	r.HandleFunc("/calendar/feed/{token:[0-9a-f]+}.ics", api.PrivateCalendarFeedHandler).Methods("GET")
+	// CalDAV: folders containing events as calendar collections, HTTP Basic authentication
+	r.HandleFunc("/.well-known/caldav", api.WellKnownCalDAVHandler)
+	r.PathPrefix("/caldav").HandlerFunc(api.CalDAVHandler)
+	// CardDAV: folders containing people or companies as address books, HTTP Basic authentication
+	r.HandleFunc("/.well-known/carddav", api.WellKnownCardDAVHandler)
+	r.PathPrefix("/carddav").HandlerFunc(api.CardDAVHandler)
+
 	// Public Endpoints: login, logout
 	r.HandleFunc("/login", api.LoginHandler).Methods("POST")
 	r.HandleFunc("/logout", api.LogoutHandler).Methods("POST")
@@ -255,17 +360,97 @@ func main() {
 
 	// objectRoutes.HandleFunc("/search", api.SearchObjectsHandler).Methods("GET")
 	objectRoutes.HandleFunc("/creatable-types", api.GetCreatableTypesHandler).Methods("GET")
+	objectRoutes.HandleFunc("/export", api.ExportObjectsCSVHandler).Methods("GET")
+	objectRoutes.HandleFunc("/import", api.ImportObjectsCSVHandler).Methods("POST")
 	objectRoutes.HandleFunc("", api.CreateObjectHandler).Methods("POST")
 	objectRoutes.HandleFunc("/{id}", api.UpdateObjectHandler).Methods("PUT")
 	objectRoutes.HandleFunc("/{id}", api.DeleteObjectHandler).Methods("DELETE")
+	objectRoutes.HandleFunc("/{id}/tags", api.GetObjectTagsHandler).Methods("GET")
+	objectRoutes.HandleFunc("/{id}/tags", api.TagObjectHandler).Methods("POST")
+	objectRoutes.HandleFunc("/{id}/tags/{tag}", api.UntagObjectHandler).Methods("DELETE")
+
+	// Public Endpoint: tag cloud (counts only the objects the caller can read)
+	r.HandleFunc("/tags/cloud", api.GetTagCloudHandler).Methods("GET")
+	// Protected Endpoint: tags management
+	tagRoutes := r.PathPrefix("/tags").Subrouter()
+	tagRoutes.Use(api.AuthMiddleware)
+	tagRoutes.HandleFunc("", api.GetAllTagsHandler).Methods("GET")
+	tagRoutes.HandleFunc("/merge", api.MergeTagsHandler).Methods("POST")
+	tagRoutes.HandleFunc("/{id}", api.RenameTagHandler).Methods("PUT")
+
+	// Protected Endpoint: project members (people, companies and other projects)
+	projectRoutes := r.PathPrefix("/projects").Subrouter()
+	projectRoutes.Use(api.AuthMiddleware)
+	projectRoutes.HandleFunc("/{id}/members", api.GetProjectMembersHandler).Methods("GET")
+	projectRoutes.HandleFunc("/{id}/members", api.AddProjectMemberHandler).Methods("POST")
+	projectRoutes.HandleFunc("/{id}/members/{kind}/{memberId}", api.RemoveProjectMemberHandler).Methods("DELETE")
+
+	// Protected Endpoint: calendar feed token and iCalendar import
+	calendarRoutes := r.PathPrefix("/calendar").Subrouter()
+	calendarRoutes.Use(api.AuthMiddleware)
+	calendarRoutes.HandleFunc("/token", api.GetCalendarTokenHandler).Methods("GET")
+	calendarRoutes.HandleFunc("/token", api.RegenerateCalendarTokenHandler).Methods("POST")
+	calendarRoutes.HandleFunc("/import", api.ImportCalendarHandler).Methods("POST")
+
+	// Protected Endpoint: vCard export and import of people and companies, duplicates and merges, codice fiscale
+	contactRoutes := r.PathPrefix("/contacts").Subrouter()
+	contactRoutes.Use(api.AuthMiddleware)
+	contactRoutes.HandleFunc("/import", api.ImportVCardsHandler).Methods("POST")
+	contactRoutes.HandleFunc("/duplicates", api.GetDuplicatesHandler).Methods("GET")
+	contactRoutes.HandleFunc("/merge", api.MergeContactsHandler).Methods("POST")
+	contactRoutes.HandleFunc("/codice-fiscale/{cf}", api.DecodeCodiceFiscaleHandler).Methods("GET")
+	contactRoutes.HandleFunc("/folder/{folderId}/vcard", api.GetFolderVCardsHandler).Methods("GET")
+	contactRoutes.HandleFunc("/{id}/vcard", api.GetContactVCardHandler).Methods("GET")
+	contactRoutes.HandleFunc("/{id}/merges", api.GetContactMergesHandler).Methods("GET")
+
+	// Protected Endpoint: in-app notifications and reminder channels
+	notificationRoutes := r.PathPrefix("/notifications").Subrouter()
+	notificationRoutes.Use(api.AuthMiddleware)
+	notificationRoutes.HandleFunc("", api.GetNotificationsHandler).Methods("GET")
+	notificationRoutes.HandleFunc("/read", api.MarkAllNotificationsReadHandler).Methods("POST")
+	notificationRoutes.HandleFunc("/channels", api.GetNotificationChannelsHandler).Methods("GET")
+	notificationRoutes.HandleFunc("/channels/{channel}", api.SetNotificationChannelHandler).Methods("PUT")
+	notificationRoutes.HandleFunc("/{id}/read", api.MarkNotificationReadHandler).Methods("POST")
+
+	// Protected Endpoint: timesheet (rprj_timetracks)
+	timesheetRoutes := r.PathPrefix("/timesheet").Subrouter()
+	timesheetRoutes.Use(api.AuthMiddleware)
+	timesheetRoutes.HandleFunc("/entries", api.GetTimesheetEntriesHandler).Methods("GET")
+	timesheetRoutes.HandleFunc("/entries", api.CreateTimesheetEntryHandler).Methods("POST")
+	timesheetRoutes.HandleFunc("/report", api.GetTimesheetReportHandler).Methods("GET")
+	timesheetRoutes.HandleFunc("/timer", api.GetTimerHandler).Methods("GET")
+	timesheetRoutes.HandleFunc("/timer/start", api.StartTimerHandler).Methods("POST")
+	timesheetRoutes.HandleFunc("/timer/stop", api.StopTimerHandler).Methods("POST")
+
+	// Protected Endpoint: full-text index maintenance
+	searchRoutes := r.PathPrefix("/search").Subrouter()
+	searchRoutes.Use(api.AuthMiddleware)
+	searchRoutes.HandleFunc("/reindex", api.SearchReindexHandler).Methods("POST")
 
 	// Protected Endpoint: File download
 	fileRoutes := r.PathPrefix("/files").Subrouter()
 	fileRoutes.Use(api.AuthMiddleware)
 	fileRoutes.HandleFunc("/preview-tokens", api.GenerateFileTokensHandler).Methods("POST")
+	fileRoutes.HandleFunc("/storage/migrate", api.MigrateFileLayoutHandler).Methods("POST")
+	fileRoutes.HandleFunc("/storage/usage", api.GetStorageUsageHandler).Methods("GET")
+	fileRoutes.HandleFunc("/storage/usage/all", api.ListStorageUsageHandler).Methods("GET")
+	fileRoutes.HandleFunc("/storage/usage/recompute", api.RecomputeStorageUsageHandler).Methods("POST")
+	fileRoutes.HandleFunc("/storage/quota/{kind}/{id}", api.SetStorageQuotaHandler).Methods("PUT")
+	fileRoutes.HandleFunc("/storage/rescan", api.RescanFilesHandler).Methods("POST")
+	fileRoutes.HandleFunc("/storage/scans", api.GetFileScansHandler).Methods("GET")
+	fileRoutes.HandleFunc("/{id}/revisions", api.GetFileRevisionsHandler).Methods("GET")
+	fileRoutes.HandleFunc("/{id}/revisions/{revision}/download", api.DownloadFileRevisionHandler).Methods("GET")
+	fileRoutes.HandleFunc("/{id}/revisions/{revision}/promote", api.PromoteFileRevisionHandler).Methods("POST")
+	// Resumable uploads (tus)
+	fileRoutes.HandleFunc("/uploads", api.CreateUploadHandler).Methods("POST")
+	fileRoutes.HandleFunc("/uploads/{id}", api.HeadUploadHandler).Methods("HEAD")
+	fileRoutes.HandleFunc("/uploads/{id}", api.PatchUploadHandler).Methods("PATCH")
+	fileRoutes.HandleFunc("/uploads/{id}", api.DeleteUploadHandler).Methods("DELETE")
 
 	// File download without auth middleware (uses token or permission check)
-	r.HandleFunc("/files/{id}/download", api.DownloadFileHandler).Methods("GET")
+	r.HandleFunc("/files/{id}/download", api.DownloadFileHandler).Methods("GET", "HEAD")
+	// tus capabilities, without auth
+	r.HandleFunc("/files/uploads", api.TusOptionsHandler).Methods("OPTIONS")
 	r.HandleFunc("/objects/search", api.SearchObjectsHandler).Methods("GET")
 
 	// Swagger documentation - only in development
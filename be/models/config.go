@@ -19,6 +19,30 @@ type Config struct {
 	OllamaURL      string `json:"ollama_url"`
 	RootDirectory  string `json:"root_directory"`
 	FilesDirectory string `json:"files_directory"`
+	// Layout of the new uploads in the files directory: legacy (<father_id>/), id or checksum (XX/YY/),
+	// content (blobs/XX/YY/<checksum>, deduplicated)
+	FilesLayout string `json:"files_layout"`
+	// Storage of the blobs: local (the files directory) or s3 (a bucket of an S3 compatible service, like MinIO)
+	StorageDriver string `json:"storage_driver"`
+	S3Endpoint    string `json:"s3_endpoint"`
+	S3Region      string `json:"s3_region"`
+	S3Bucket      string `json:"s3_bucket"`
+	S3AccessKey   string `json:"s3_access_key"`
+	S3SecretKey   string `json:"s3_secret_key"`
+	S3Prefix      string `json:"s3_prefix"`
+	// Default storage quotas in bytes of each user and each group (0 = unlimited),
+	// the admins may set a different quota for a single user or group
+	UserQuota  int64 `json:"user_quota"`
+	GroupQuota int64 `json:"group_quota"`
+	// Cache of the image renditions (?w=&h= on the downloads): a local directory, the temporary
+	// directory when empty, and its size in MB (512 when 0)
+	RenditionCacheDirectory string `json:"rendition_cache_directory"`
+	RenditionCacheSize      int64  `json:"rendition_cache_size"`
+	// Malware scanning of the uploads: clamd (a ClamAV daemon at clamd_address, tcp://host:port or
+	// unix:///path), none when empty. scan_action on an infected upload: reject (default) or quarantine
+	ScannerDriver string `json:"scanner_driver"`
+	ClamdAddress  string `json:"clamd_address"`
+	ScanAction    string `json:"scan_action"`
 	// OAuth configuration
 	GoogleClientID     string `json:"google_client_id"`
 	GoogleClientSecret string `json:"google_client_secret"`
@@ -28,6 +52,14 @@ type Config struct {
 	GitHubRedirectURL  string `json:"github_redirect_url"`
 	TelegramBotToken   string `json:"telegram_bot_token"`
 	TelegramBotID      string `json:"telegram_bot_id"`
+	// Event reminders: SMTP server for the email channel and dispatcher interval
+	// in seconds (0 = every minute, negative = disabled)
+	SMTPHost         string `json:"smtp_host"`
+	SMTPPort         int    `json:"smtp_port"`
+	SMTPUsername     string `json:"smtp_username"`
+	SMTPPassword     string `json:"smtp_password"`
+	SMTPFrom         string `json:"smtp_from"`
+	ReminderInterval int    `json:"reminder_interval"`
 }
 
 func LoadConfig(filename string, config *Config) error {
@@ -79,6 +79,8 @@ func runImport(cmd *cobra.Command, args []string) error {
 
 	// Create API client
 	client := api.NewClient(url, token)
+	// Interrupted uploads are resumed by the next run
+	client.Uploads = api.NewUploadStore(tokenManager.UploadsFile())
 
 	fmt.Printf("Importing %d objects from %s...\n", manifest.TotalObjects, importDir)
 	if importPreserveIDs {
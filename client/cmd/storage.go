@@ -0,0 +1,361 @@
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/echoes1971/r-prj-ng/client/pkg/api"
+	"github.com/echoes1971/r-prj-ng/client/pkg/auth"
+	"github.com/echoes1971/r-prj-ng/client/pkg/models"
+	"github.com/spf13/cobra"
+)
+
+var storageBatch int
+var storageUsageAll bool
+var storageScansLimit int
+
+var storageCmd = &cobra.Command{
+	Use:   "storage",
+	Short: "Maintain the file storage of the server (admin)",
+}
+
+var storageMigrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Move the files to the layout configured on the server",
+	Long: `Move the files stored in the legacy <father_id>/ layout to the layout
+configured on the server with files_layout (FILES_LAYOUT): files/XX/YY/...
+keyed on the object id or on the checksum. With the content layout the
+files of every layout, and their old revisions, are deduplicated: each
+content is stored once, under files/blobs/XX/YY/<checksum>.
+
+The files are moved in batches and verified against their checksum. The
+migration can be interrupted and run again: it resumes where it stopped.
+Files that cannot be moved (missing or corrupted) are reported and left
+in place.
+
+Examples:
+  # Migrate everything
+  rhobee storage migrate
+
+  # Smaller batches
+  rhobee storage migrate --batch 100`,
+	Args: cobra.NoArgs,
+	RunE: runStorageMigrate,
+}
+
+var storageUsageCmd = &cobra.Command{
+	Use:   "usage",
+	Short: "Show the storage used and the quotas",
+	Long: `Show the bytes and the files stored by you and by your groups, with
+their quotas. Files in the trash count until they are deleted for good,
+the old revisions do not.
+
+Examples:
+  # Your usage
+  rhobee storage usage
+
+  # Every user and group (admin)
+  rhobee storage usage --all`,
+	Args: cobra.NoArgs,
+	RunE: runStorageUsage,
+}
+
+var storageQuotaCmd = &cobra.Command{
+	Use:   "quota <users|groups> <id> <bytes|default>",
+	Short: "Set the storage quota of a user or a group (admin)",
+	Long: `Set the storage quota of a user or a group. The size is in bytes, or
+with a K, M, G or T suffix (powers of 1024); 0 is unlimited and
+"default" restores the quota configured on the server (user_quota,
+group_quota).
+
+Examples:
+  rhobee storage quota users 42 10G
+  rhobee storage quota groups 7 0
+  rhobee storage quota users 42 default`,
+	Args: cobra.ExactArgs(3),
+	RunE: runStorageQuota,
+}
+
+var storageRecomputeCmd = &cobra.Command{
+	Use:   "recompute",
+	Short: "Rebuild the storage usage from the files (admin)",
+	Long: `Read the size of every file stored on the server and rebuild the
+storage usage of every user and group. Run it once after upgrading, for
+the files uploaded before the usage was tracked, or whenever the counters
+look wrong. The quotas are kept.`,
+	Args: cobra.NoArgs,
+	RunE: runStorageRecompute,
+}
+
+var storageRescanCmd = &cobra.Command{
+	Use:   "rescan",
+	Short: "Scan the stored files for malware (admin)",
+	Long: `Scan the files already stored on the server, with their old revisions,
+with the scanner configured on the server (scanner_driver). The uploads
+are scanned when they are received: rescan the storage after enabling
+the scanner, or after the signatures were updated.
+
+Every infected file is recorded, see 'rhobee storage scans'. With the
+quarantine scan_action its content is moved to the quarantine and the
+file cannot be downloaded anymore; with reject it is only reported.
+
+Examples:
+  rhobee storage rescan
+  rhobee storage rescan --batch 50`,
+	Args: cobra.NoArgs,
+	RunE: runStorageRescan,
+}
+
+var storageScansCmd = &cobra.Command{
+	Use:   "scans",
+	Short: "List the infected files found by the scanner (admin)",
+	Long: `List the infected files found by the scanner of the server, the most
+recent first: the uploads rejected or quarantined, and the stored files
+found by a rescan.`,
+	Args: cobra.NoArgs,
+	RunE: runStorageScans,
+}
+
+func init() {
+	rootCmd.AddCommand(storageCmd)
+	storageCmd.AddCommand(storageMigrateCmd)
+	storageCmd.AddCommand(storageUsageCmd)
+	storageCmd.AddCommand(storageQuotaCmd)
+	storageCmd.AddCommand(storageRecomputeCmd)
+	storageCmd.AddCommand(storageRescanCmd)
+	storageCmd.AddCommand(storageScansCmd)
+
+	storageMigrateCmd.Flags().IntVar(&storageBatch, "batch", 500, "Files moved per request")
+	storageUsageCmd.Flags().BoolVar(&storageUsageAll, "all", false, "Show every user and group (admin)")
+	storageRescanCmd.Flags().IntVar(&storageBatch, "batch", 200, "Files scanned per request")
+	storageScansCmd.Flags().IntVar(&storageScansLimit, "limit", 100, "Maximum number of entries, 0 = all")
+}
+
+// storageClient returns an API client for the current instance
+func storageClient(cmd *cobra.Command) (*api.Client, error) {
+	tokenManager, err := auth.NewTokenManager()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create token manager: %w", err)
+	}
+
+	instance, _ := cmd.Flags().GetString("instance")
+	url, _, token, err := tokenManager.GetToken(instance)
+	if err != nil {
+		return nil, fmt.Errorf("not logged in. Run 'rhobee login' first: %w", err)
+	}
+
+	return api.NewClient(url, token), nil
+}
+
+func runStorageMigrate(cmd *cobra.Command, args []string) error {
+	if storageBatch < 1 {
+		return fmt.Errorf("--batch must be at least 1")
+	}
+	client, err := storageClient(cmd)
+	if err != nil {
+		return err
+	}
+
+	migrated := 0
+	for {
+		report, err := client.MigrateFileLayout(storageBatch)
+		if err != nil {
+			return err
+		}
+		migrated += report.Migrated
+		fmt.Printf("Layout %s: %d files moved, %d remaining\n", report.Layout, migrated, report.Remaining)
+		// The failed files stay in the legacy layout and are retried by every batch
+		if report.Migrated == 0 || report.Remaining <= report.Failed {
+			for _, e := range report.Errors {
+				fmt.Printf("✗ %s (%s): %s\n", e.ID, e.Filename, e.Message)
+			}
+			break
+		}
+	}
+
+	fmt.Printf("✓ Migration complete: %d files moved\n", migrated)
+	return nil
+}
+
+// formatBytes formats a size with a binary unit
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit && exp < 4; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTP"[exp])
+}
+
+// parseBytes parses a size in bytes, with an optional K, M, G or T suffix
+func parseBytes(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSuffix(strings.TrimSpace(s), "B"))
+	multiplier := int64(1)
+	if i := strings.IndexAny(s, "KMGT"); i >= 0 && i == len(s)-1 {
+		multiplier = int64(1) << (10 * (strings.IndexByte("KMGT", s[i]) + 1))
+		s = s[:i]
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n * multiplier, nil
+}
+
+// printStorageUsage prints a line of usage
+func printStorageUsage(usage models.StorageUsage) {
+	kind := "User "
+	if usage.Kind == "G" {
+		kind = "Group"
+	}
+	name := usage.Name
+	if name == "" {
+		name = usage.SubjectID
+	}
+	quota := "unlimited"
+	if usage.Quota > 0 {
+		quota = fmt.Sprintf("%s (%.0f%%)", formatBytes(usage.Quota), 100*float64(usage.Bytes)/float64(usage.Quota))
+	}
+	if usage.QuotaOverride == nil {
+		quota += ", default"
+	}
+	fmt.Printf("%s %-20s %10s in %5d files, quota %s\n", kind, name, formatBytes(usage.Bytes), usage.Files, quota)
+}
+
+func runStorageUsage(cmd *cobra.Command, args []string) error {
+	client, err := storageClient(cmd)
+	if err != nil {
+		return err
+	}
+
+	if storageUsageAll {
+		usages, err := client.ListStorageUsage()
+		if err != nil {
+			return err
+		}
+		for _, usage := range usages {
+			printStorageUsage(usage)
+		}
+		return nil
+	}
+
+	user, groups, err := client.StorageUsage()
+	if err != nil {
+		return err
+	}
+	printStorageUsage(*user)
+	for _, group := range groups {
+		printStorageUsage(group)
+	}
+	return nil
+}
+
+func runStorageQuota(cmd *cobra.Command, args []string) error {
+	if args[0] != "users" && args[0] != "groups" {
+		return fmt.Errorf("kind must be users or groups")
+	}
+	var quota *int64
+	if args[2] != "default" {
+		n, err := parseBytes(args[2])
+		if err != nil {
+			return err
+		}
+		quota = &n
+	}
+	client, err := storageClient(cmd)
+	if err != nil {
+		return err
+	}
+
+	usage, err := client.SetStorageQuota(args[0], args[1], quota)
+	if err != nil {
+		return err
+	}
+	printStorageUsage(*usage)
+	return nil
+}
+
+func runStorageRecompute(cmd *cobra.Command, args []string) error {
+	client, err := storageClient(cmd)
+	if err != nil {
+		return err
+	}
+
+	report, err := client.RecomputeStorageUsage()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("✓ Storage usage recomputed: %s in %d files, %d sizes corrected\n", formatBytes(report.Bytes), report.Files, report.Resized)
+	if report.Missing > 0 {
+		fmt.Printf("✗ %d files have no content on the server\n", report.Missing)
+	}
+	return nil
+}
+
+func runStorageRescan(cmd *cobra.Command, args []string) error {
+	if storageBatch < 1 {
+		return fmt.Errorf("--batch must be at least 1")
+	}
+	client, err := storageClient(cmd)
+	if err != nil {
+		return err
+	}
+
+	var files, scanned, infected, missing int
+	after := ""
+	for {
+		report, err := client.RescanFiles(after, storageBatch)
+		if err != nil {
+			return err
+		}
+		files += report.Files
+		scanned += report.Scanned
+		infected += report.Infected
+		missing += report.Missing
+		for _, d := range report.Detections {
+			fmt.Printf("✗ %s (%s): %s, %s\n", d.FileID, d.Filename, d.Signature, d.Action)
+		}
+		for _, e := range report.Errors {
+			fmt.Printf("✗ %s (%s): %s\n", e.ID, e.Filename, e.Message)
+		}
+		fmt.Printf("%d files checked, %d contents scanned\n", files, scanned)
+		if report.Next == "" {
+			break
+		}
+		after = report.Next
+	}
+
+	fmt.Printf("✓ Rescan complete: %d contents scanned, %d infected\n", scanned, infected)
+	if missing > 0 {
+		fmt.Printf("✗ %d contents are missing on the server\n", missing)
+	}
+	return nil
+}
+
+func runStorageScans(cmd *cobra.Command, args []string) error {
+	if storageScansLimit < 0 {
+		return fmt.Errorf("--limit must not be negative")
+	}
+	client, err := storageClient(cmd)
+	if err != nil {
+		return err
+	}
+
+	scans, err := client.FileScans(storageScansLimit)
+	if err != nil {
+		return err
+	}
+	if len(scans) == 0 {
+		fmt.Println("No infected files")
+		return nil
+	}
+	for _, s := range scans {
+		fmt.Printf("%s  %-11s  %-30s  %10s  %s\n", s.ScanDate, s.Action, s.Filename, formatBytes(s.Size), s.Signature)
+	}
+	return nil
+}
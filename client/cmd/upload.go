@@ -22,6 +22,10 @@ var uploadCmd = &cobra.Command{
 	Use:   "upload <file> [file2] [file3] ...",
 	Short: "Upload one or more files",
 	Long: `Upload one or more files to ρBee, or update an existing DBFile with new content.
+
+New files are uploaded in chunks: an interrupted upload is resumed from the
+last byte received by the server, also by a later run of the same command
+(until the upload expires on the server, after a day).
 	
 Examples:
   # Upload single file to folder
@@ -85,6 +89,8 @@ func runUpload(cmd *cobra.Command, args []string) error {
 
 	// Create API client
 	client := api.NewClient(url, token)
+	// Interrupted uploads are resumed by the next run
+	client.Uploads = api.NewUploadStore(tokenManager.UploadsFile())
 
 	showProgress := !uploadNoProgress
 
@@ -3,10 +3,12 @@ package api
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"mime/multipart"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"time"
@@ -20,6 +22,9 @@ type Client struct {
 	BaseURL    string
 	Token      string
 	HTTPClient *http.Client
+	// Uploads saves the unfinished uploads to resume, nil to resume them
+	// only within the same call
+	Uploads *UploadStore
 }
 
 // NewClient creates a new API client
@@ -216,8 +221,31 @@ func (c *Client) Delete(objectID string) error {
 	return nil
 }
 
-// UploadFile uploads a file to a folder
+// UploadFile uploads a file to a folder. The upload is resumable: an
+// interrupted transfer continues from the last byte received by the server.
 func (c *Client) UploadFile(filePath, folderID, name, description, permissions string, showProgress bool) (*models.DBObject, error) {
+	if name == "" {
+		name = filepath.Base(filePath)
+	}
+	if permissions == "" {
+		permissions = "rw-r-----"
+	}
+	uploaded, err := c.uploadResumable(filePath, map[string]string{
+		"filename":    filepath.Base(filePath),
+		"name":        name,
+		"father_id":   folderID,
+		"description": description,
+		"permissions": permissions,
+	}, showProgress)
+	if errors.Is(err, errTusUnsupported) {
+		// Older servers
+		return c.uploadMultipart(filePath, folderID, name, description, permissions, showProgress)
+	}
+	return uploaded, err
+}
+
+// uploadMultipart uploads a file to a folder in a single multipart request
+func (c *Client) uploadMultipart(filePath, folderID, name, description, permissions string, showProgress bool) (*models.DBObject, error) {
 	file, err := os.Open(filePath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open file: %w", err)
@@ -627,3 +655,151 @@ func (c *Client) GetAllChildren(folderID string) ([]models.DBObject, error) {
 
 	return allChildren, nil
 }
+
+// MigrateFileLayout moves at most limit files to the layout configured on the server (admin only)
+func (c *Client) MigrateFileLayout(limit int) (*models.FileMigrationReport, error) {
+	req, err := http.NewRequest("POST", fmt.Sprintf("%s/files/storage/migrate?limit=%d", c.BaseURL, limit), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+c.Token)
+
+	// A batch verifies the checksum of every file it moves
+	client := &http.Client{Timeout: 10 * time.Minute}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("migration failed with status %d: %s", resp.StatusCode, string(bodyBytes))
+	}
+
+	var response struct {
+		Success bool                        `json:"success"`
+		Result  *models.FileMigrationReport `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return response.Result, nil
+}
+
+// storageRequest sends a request to the storage endpoints and decodes the response into result
+func (c *Client) storageRequest(method string, path string, body any, result any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to encode request: %w", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, c.BaseURL+path, reader)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+c.Token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	// A recompute reads the size of every blob
+	client := &http.Client{Timeout: 10 * time.Minute}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("request failed with status %d: %s", resp.StatusCode, string(bodyBytes))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+// StorageUsage returns the storage used by the current user and by their groups
+func (c *Client) StorageUsage() (*models.StorageUsage, []models.StorageUsage, error) {
+	var response struct {
+		Success bool                  `json:"success"`
+		User    models.StorageUsage   `json:"user"`
+		Groups  []models.StorageUsage `json:"groups"`
+	}
+	if err := c.storageRequest("GET", "/files/storage/usage", nil, &response); err != nil {
+		return nil, nil, err
+	}
+	return &response.User, response.Groups, nil
+}
+
+// ListStorageUsage returns the storage used by every user and group (admin only)
+func (c *Client) ListStorageUsage() ([]models.StorageUsage, error) {
+	var response struct {
+		Success bool                  `json:"success"`
+		Usage   []models.StorageUsage `json:"usage"`
+	}
+	if err := c.storageRequest("GET", "/files/storage/usage/all", nil, &response); err != nil {
+		return nil, err
+	}
+	return response.Usage, nil
+}
+
+// SetStorageQuota sets the quota in bytes of a user or a group, kind "users" or "groups":
+// 0 is unlimited, nil restores the default of the server (admin only)
+func (c *Client) SetStorageQuota(kind string, id string, quota *int64) (*models.StorageUsage, error) {
+	var response struct {
+		Success bool                  `json:"success"`
+		Usage   []models.StorageUsage `json:"usage"`
+	}
+	body := map[string]*int64{"quota": quota}
+	if err := c.storageRequest("PUT", "/files/storage/quota/"+url.PathEscape(kind)+"/"+url.PathEscape(id), body, &response); err != nil {
+		return nil, err
+	}
+	if len(response.Usage) == 0 {
+		return nil, fmt.Errorf("empty response")
+	}
+	return &response.Usage[0], nil
+}
+
+// RecomputeStorageUsage rebuilds the storage usage from the files (admin only)
+func (c *Client) RecomputeStorageUsage() (*models.StorageUsageReport, error) {
+	var response struct {
+		Success bool                       `json:"success"`
+		Result  *models.StorageUsageReport `json:"result"`
+	}
+	if err := c.storageRequest("POST", "/files/storage/usage/recompute", nil, &response); err != nil {
+		return nil, err
+	}
+	return response.Result, nil
+}
+
+// RescanFiles scans at most limit stored files after the file id after for malware (admin only)
+func (c *Client) RescanFiles(after string, limit int) (*models.FileScanReport, error) {
+	var response struct {
+		Success bool                   `json:"success"`
+		Result  *models.FileScanReport `json:"result"`
+	}
+	path := fmt.Sprintf("/files/storage/rescan?limit=%d&after=%s", limit, url.QueryEscape(after))
+	if err := c.storageRequest("POST", path, nil, &response); err != nil {
+		return nil, err
+	}
+	return response.Result, nil
+}
+
+// FileScans returns the most recent infected files found by the scanner (admin only)
+func (c *Client) FileScans(limit int) ([]models.FileScan, error) {
+	var response struct {
+		Success bool              `json:"success"`
+		Scans   []models.FileScan `json:"scans"`
+	}
+	if err := c.storageRequest("GET", fmt.Sprintf("/files/storage/scans?limit=%d", limit), nil, &response); err != nil {
+		return nil, err
+	}
+	return response.Scans, nil
+}
@@ -0,0 +1,313 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/echoes1971/r-prj-ng/client/pkg/models"
+	"github.com/schollz/progressbar/v3"
+)
+
+// Resumable uploads with the tus protocol: the file is sent in chunks, a
+// failed chunk is resumed from the offset reached on the server, and the
+// URL of an unfinished upload is saved in the UploadStore so that a later
+// run resumes it too.
+
+const (
+	tusVersion = "1.0.0"
+	// tusChunkSize is the size of the chunks sent
+	tusChunkSize = 8 << 20
+	// tusRetries is the number of consecutive failures before giving up
+	tusRetries = 5
+)
+
+// errTusUnsupported is returned by servers without resumable uploads
+var errTusUnsupported = errors.New("resumable uploads not supported by the server")
+
+// errTusUploadGone is returned for an upload expired or deleted on the server
+var errTusUploadGone = errors.New("upload not found on the server")
+
+// UploadStore saves the URLs of the unfinished uploads in a JSON file
+type UploadStore struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewUploadStore returns a store saved in the given file
+func NewUploadStore(path string) *UploadStore {
+	return &UploadStore{path: path}
+}
+
+func (s *UploadStore) load() map[string]string {
+	uploads := map[string]string{}
+	if data, err := os.ReadFile(s.path); err == nil {
+		json.Unmarshal(data, &uploads)
+	}
+	return uploads
+}
+
+func (s *UploadStore) save(uploads map[string]string) error {
+	data, err := json.MarshalIndent(uploads, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.path, data, 0600)
+}
+
+// Get returns the URL of the upload of a fingerprint
+func (s *UploadStore) Get(fingerprint string) (string, bool) {
+	if s == nil {
+		return "", false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	uploadURL, ok := s.load()[fingerprint]
+	return uploadURL, ok
+}
+
+// Set saves the URL of the upload of a fingerprint
+func (s *UploadStore) Set(fingerprint string, uploadURL string) error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	uploads := s.load()
+	uploads[fingerprint] = uploadURL
+	return s.save(uploads)
+}
+
+// Delete forgets the upload of a fingerprint
+func (s *UploadStore) Delete(fingerprint string) error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	uploads := s.load()
+	if _, ok := uploads[fingerprint]; !ok {
+		return nil
+	}
+	delete(uploads, fingerprint)
+	return s.save(uploads)
+}
+
+// tusMetadata encodes the Upload-Metadata header
+func tusMetadata(values map[string]string) string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if values[key] != "" {
+			pairs = append(pairs, key+" "+base64.StdEncoding.EncodeToString([]byte(values[key])))
+		}
+	}
+	return strings.Join(pairs, ",")
+}
+
+// tusRequest builds a request of the tus protocol
+func (c *Client) tusRequest(method, target string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, target, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+c.Token)
+	req.Header.Set("Tus-Resumable", tusVersion)
+	return req, nil
+}
+
+// tusCreate declares an upload, returning its URL and, for an empty file, the id of the DBFile
+func (c *Client) tusCreate(size int64, metadata string) (string, string, error) {
+	endpoint := c.BaseURL + "/files/uploads"
+	req, err := c.tusRequest("POST", endpoint, nil)
+	if err != nil {
+		return "", "", err
+	}
+	req.Header.Set("Upload-Length", strconv.FormatInt(size, 10))
+	req.Header.Set("Upload-Metadata", metadata)
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return "", "", fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusMethodNotAllowed {
+		return "", "", errTusUnsupported
+	}
+	if resp.StatusCode != http.StatusCreated {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return "", "", fmt.Errorf("upload failed with status %d: %s", resp.StatusCode, string(bodyBytes))
+	}
+	base, err := url.Parse(endpoint)
+	if err != nil {
+		return "", "", err
+	}
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || resp.Header.Get("Location") == "" {
+		return "", "", fmt.Errorf("invalid upload location %q", resp.Header.Get("Location"))
+	}
+	return base.ResolveReference(location).String(), resp.Header.Get("Upload-File-Id"), nil
+}
+
+// tusHead returns the offset reached by an upload and, once complete, the id of its DBFile
+func (c *Client) tusHead(uploadURL string) (int64, string, error) {
+	req, err := c.tusRequest("HEAD", uploadURL, nil)
+	if err != nil {
+		return 0, "", err
+	}
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return 0, "", fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNoContent:
+	case http.StatusNotFound, http.StatusGone, http.StatusForbidden:
+		return 0, "", errTusUploadGone
+	default:
+		return 0, "", fmt.Errorf("upload status failed with status %d", resp.StatusCode)
+	}
+	offset, err := strconv.ParseInt(resp.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil {
+		return 0, "", fmt.Errorf("invalid Upload-Offset %q", resp.Header.Get("Upload-Offset"))
+	}
+	return offset, resp.Header.Get("Upload-File-Id"), nil
+}
+
+// tusPatch sends a chunk at offset, returning the new offset and, after the
+// last chunk, the id of the DBFile
+func (c *Client) tusPatch(uploadURL string, offset int64, chunk io.Reader, length int64) (int64, string, error) {
+	req, err := c.tusRequest("PATCH", uploadURL, chunk)
+	if err != nil {
+		return offset, "", err
+	}
+	req.ContentLength = length
+	req.Header.Set("Content-Type", "application/offset+octet-stream")
+	req.Header.Set("Upload-Offset", strconv.FormatInt(offset, 10))
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return offset, "", fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNoContent:
+	case http.StatusNotFound, http.StatusGone:
+		return offset, "", errTusUploadGone
+	default:
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return offset, "", fmt.Errorf("upload failed with status %d: %s", resp.StatusCode, string(bodyBytes))
+	}
+	newOffset, err := strconv.ParseInt(resp.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil {
+		return offset, "", fmt.Errorf("invalid Upload-Offset %q", resp.Header.Get("Upload-Offset"))
+	}
+	return newOffset, resp.Header.Get("Upload-File-Id"), nil
+}
+
+// uploadResumable uploads a file with the tus protocol, resuming the
+// upload of the same file (same path, size and modification time) left
+// unfinished by a previous run
+func (c *Client) uploadResumable(filePath string, metadata map[string]string, showProgress bool) (*models.DBObject, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat file: %w", err)
+	}
+	size := fileInfo.Size()
+	absPath, _ := filepath.Abs(filePath)
+	fingerprint := fmt.Sprintf("%s|%s|%s|%d|%d", c.BaseURL, metadata["father_id"], absPath, size, fileInfo.ModTime().Unix())
+
+	// Increase timeout for large chunks
+	c.HTTPClient.Timeout = 5 * time.Minute
+
+	var offset int64
+	var fileID string
+	uploadURL, resumed := c.Uploads.Get(fingerprint)
+	if resumed {
+		offset, fileID, err = c.tusHead(uploadURL)
+		if errors.Is(err, errTusUploadGone) {
+			c.Uploads.Delete(fingerprint)
+			resumed = false
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	if !resumed {
+		offset = 0
+		uploadURL, fileID, err = c.tusCreate(size, tusMetadata(metadata))
+		if err != nil {
+			return nil, err
+		}
+		if err := c.Uploads.Set(fingerprint, uploadURL); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: cannot save the upload state: %v\n", err)
+		}
+	} else if offset > 0 && fileID == "" && showProgress {
+		fmt.Printf("Resuming upload at %d of %d bytes\n", offset, size)
+	}
+
+	var bar *progressbar.ProgressBar
+	if showProgress {
+		bar = progressbar.DefaultBytes(size, "Uploading")
+		bar.Set64(offset)
+	}
+	failures := 0
+	for fileID == "" {
+		length := min(int64(tusChunkSize), size-offset)
+		var chunk io.Reader = io.NewSectionReader(file, offset, length)
+		if bar != nil {
+			chunk = io.TeeReader(chunk, bar)
+		}
+		newOffset, newFileID, err := c.tusPatch(uploadURL, offset, chunk, length)
+		if errors.Is(err, errTusUploadGone) {
+			c.Uploads.Delete(fingerprint)
+			return nil, fmt.Errorf("upload expired, run again to restart it: %w", err)
+		}
+		if err != nil {
+			failures++
+			if failures > tusRetries {
+				return nil, fmt.Errorf("upload interrupted at %d of %d bytes, run again to resume: %w", offset, size, err)
+			}
+			time.Sleep(time.Duration(failures) * time.Second)
+			// Resume from what the server received
+			if newOffset, newFileID, err = c.tusHead(uploadURL); err != nil {
+				continue
+			}
+		} else {
+			failures = 0
+		}
+		offset, fileID = newOffset, newFileID
+		if bar != nil {
+			bar.Set64(offset)
+		}
+	}
+	if bar != nil {
+		bar.Finish()
+	}
+	c.Uploads.Delete(fingerprint)
+
+	return c.Get(fileID)
+}
@@ -30,6 +30,11 @@ func NewTokenManager() (*TokenManager, error) {
 	}, nil
 }
 
+// UploadsFile returns the file saving the unfinished uploads to resume
+func (tm *TokenManager) UploadsFile() string {
+	return filepath.Join(tm.configDir, "uploads.json")
+}
+
 // SaveToken saves a token for an instance
 func (tm *TokenManager) SaveToken(instance, url, user, token string) error {
 	configFile := filepath.Join(tm.configDir, "config.yaml")
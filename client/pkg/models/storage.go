@@ -0,0 +1,65 @@
+package models
+
+// FileMigrationError is a file the server could not move to the new layout
+type FileMigrationError struct {
+	ID       string `json:"id"`
+	Filename string `json:"filename"`
+	Message  string `json:"message"`
+}
+
+// FileMigrationReport is the result of a run of the files layout migration
+type FileMigrationReport struct {
+	Layout    string               `json:"layout"`
+	Migrated  int                  `json:"migrated"`
+	Failed    int                  `json:"failed"`
+	Errors    []FileMigrationError `json:"errors"`
+	Remaining int                  `json:"remaining"`
+}
+
+// StorageUsage is the storage used by a user (kind U) or a group (kind G)
+type StorageUsage struct {
+	Kind      string `json:"kind"`
+	SubjectID string `json:"subject_id"`
+	Name      string `json:"name"`
+	Bytes     int64  `json:"bytes"`
+	Files     int64  `json:"files"`
+	// Quota in effect, 0 = unlimited
+	Quota int64 `json:"quota"`
+	// Quota set for this user or group, nil when the default of the server applies
+	QuotaOverride *int64 `json:"quota_override"`
+}
+
+// StorageUsageReport is the result of a recompute of the storage usage
+type StorageUsageReport struct {
+	Files   int   `json:"files"`
+	Resized int   `json:"resized"`
+	Missing int   `json:"missing"`
+	Bytes   int64 `json:"bytes"`
+}
+
+// FileScan is an infected file found by the scanner of the server
+type FileScan struct {
+	ID        string `json:"id"`
+	ScanDate  string `json:"scan_date"`
+	UserID    string `json:"user_id"`
+	FileID    string `json:"file_id"`
+	Filename  string `json:"filename"`
+	Checksum  string `json:"checksum"`
+	Size      int64  `json:"size"`
+	Signature string `json:"signature"`
+	// rejected, quarantined or detected (found by a rescan, left in place)
+	Action        string `json:"action"`
+	QuarantineKey string `json:"quarantine_key"`
+}
+
+// FileScanReport is the result of a batch of the rescan of the stored files
+type FileScanReport struct {
+	Files    int `json:"files"`
+	Scanned  int `json:"scanned"`
+	Infected int `json:"infected"`
+	Missing  int `json:"missing"`
+	// Id of the last file of the batch, "" when the rescan is complete
+	Next       string               `json:"next"`
+	Errors     []FileMigrationError `json:"errors"`
+	Detections []FileScan           `json:"detections"`
+}
@@ -0,0 +1,30 @@
+--
+-- Tags: a flat list of labels shared by every DBObject subclass
+--
+
+USE rproject;
+
+DROP TABLE IF EXISTS `rprj_tags`;
+CREATE TABLE `rprj_tags` (
+  `id` varchar(16) NOT NULL,
+  `name` varchar(255) NOT NULL,
+  `creator` varchar(16) DEFAULT NULL,
+  `creation_date` datetime DEFAULT NULL,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `rprj_tags_0` (`name`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;
+
+--
+-- Association between any DBObject (by id) and a tag
+--
+
+DROP TABLE IF EXISTS `rprj_objects_tags`;
+CREATE TABLE `rprj_objects_tags` (
+  `object_id` varchar(16) NOT NULL,
+  `tag_id` varchar(16) NOT NULL,
+  `creator` varchar(16) DEFAULT NULL,
+  `creation_date` datetime DEFAULT NULL,
+  PRIMARY KEY (`object_id`,`tag_id`),
+  KEY `rprj_objects_tags_0` (`object_id`),
+  KEY `rprj_objects_tags_1` (`tag_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;
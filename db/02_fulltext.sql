@@ -0,0 +1,16 @@
+--
+-- Full-text search: inverted index of the DBObjects
+-- (name, description and HTML stripped body), kept current by the repository.
+-- Terms are lowercase, accent free and stemmed.
+--
+
+USE rproject;
+
+DROP TABLE IF EXISTS `rprj_fulltext`;
+CREATE TABLE `rprj_fulltext` (
+  `object_id` varchar(16) NOT NULL,
+  `term` varchar(64) NOT NULL,
+  `weight` int(11) NOT NULL DEFAULT 0,
+  PRIMARY KEY (`object_id`,`term`),
+  KEY `rprj_fulltext_0` (`term`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;
@@ -0,0 +1,30 @@
+--
+-- Calendar: UIDs of the events imported from iCalendar files or CalDAV clients
+-- (events created in rhobee are exported as <id>@rhobee), with the CalDAV resource name
+--
+
+USE rproject;
+
+DROP TABLE IF EXISTS `rprj_events_uids`;
+CREATE TABLE `rprj_events_uids` (
+  `uid` varchar(255) NOT NULL,
+  `event_id` varchar(16) NOT NULL,
+  `href` varchar(255) DEFAULT NULL,
+  `creation_date` datetime DEFAULT NULL,
+  PRIMARY KEY (`uid`),
+  KEY `rprj_events_uids_0` (`event_id`),
+  KEY `rprj_events_uids_1` (`href`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;
+
+--
+-- Secret tokens of the private calendar feeds, one per user
+--
+
+DROP TABLE IF EXISTS `rprj_calendar_tokens`;
+CREATE TABLE `rprj_calendar_tokens` (
+  `user_id` varchar(16) NOT NULL,
+  `token` varchar(64) NOT NULL,
+  `creation_date` datetime DEFAULT NULL,
+  PRIMARY KEY (`user_id`),
+  UNIQUE KEY `rprj_calendar_tokens_0` (`token`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;
@@ -0,0 +1,52 @@
+--
+-- Reminders: the alarms of the events delivered to a user on a channel,
+-- one row per occurrence so that each reminder fires exactly once
+--
+
+USE rproject;
+
+DROP TABLE IF EXISTS `rprj_event_reminders`;
+CREATE TABLE `rprj_event_reminders` (
+  `event_id` varchar(16) NOT NULL,
+  `occurrence_date` datetime NOT NULL,
+  `user_id` varchar(16) NOT NULL,
+  `channel` varchar(32) NOT NULL,
+  `alarm_date` datetime DEFAULT NULL,
+  `status` varchar(16) NOT NULL DEFAULT 'pending',
+  `error` text DEFAULT NULL,
+  `creation_date` datetime DEFAULT NULL,
+  `sent_date` datetime DEFAULT NULL,
+  PRIMARY KEY (`event_id`,`occurrence_date`,`user_id`,`channel`),
+  KEY `rprj_event_reminders_0` (`user_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;
+
+--
+-- In-app notifications
+--
+
+DROP TABLE IF EXISTS `rprj_notifications`;
+CREATE TABLE `rprj_notifications` (
+  `id` varchar(16) NOT NULL,
+  `user_id` varchar(16) NOT NULL,
+  `title` varchar(255) NOT NULL,
+  `message` text DEFAULT NULL,
+  `object_id` varchar(16) DEFAULT NULL,
+  `creation_date` datetime DEFAULT NULL,
+  `read_date` datetime DEFAULT NULL,
+  PRIMARY KEY (`id`),
+  KEY `rprj_notifications_0` (`user_id`,`read_date`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;
+
+--
+-- Notification channels of the users: the destination address (email,
+-- Telegram chat id) and whether reminders are delivered on the channel
+--
+
+DROP TABLE IF EXISTS `rprj_notification_channels`;
+CREATE TABLE `rprj_notification_channels` (
+  `user_id` varchar(16) NOT NULL,
+  `channel` varchar(32) NOT NULL,
+  `address` varchar(255) DEFAULT NULL,
+  `enabled` tinyint(1) NOT NULL DEFAULT 1,
+  PRIMARY KEY (`user_id`,`channel`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;
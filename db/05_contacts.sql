@@ -0,0 +1,36 @@
+--
+-- Contacts: UIDs and CardDAV resource names of the people and companies
+-- created by CardDAV clients (contacts of rhobee are exported as <id>@rhobee)
+--
+
+USE rproject;
+
+DROP TABLE IF EXISTS `rprj_contacts_uids`;
+CREATE TABLE `rprj_contacts_uids` (
+  `uid` varchar(255) NOT NULL,
+  `contact_id` varchar(16) NOT NULL,
+  `href` varchar(255) DEFAULT NULL,
+  `creation_date` datetime DEFAULT NULL,
+  PRIMARY KEY (`uid`),
+  KEY `rprj_contacts_uids_0` (`contact_id`),
+  KEY `rprj_contacts_uids_1` (`href`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;
+
+--
+-- Contacts: history of the merges of duplicate people
+--
+
+DROP TABLE IF EXISTS `rprj_contacts_merges`;
+CREATE TABLE `rprj_contacts_merges` (
+  `id` varchar(16) NOT NULL,
+  `survivor_id` varchar(16) NOT NULL,
+  `merged_id` varchar(16) NOT NULL,
+  `merged_data` text,
+  `changes` text,
+  `moved_references` text,
+  `creator` varchar(16) DEFAULT NULL,
+  `creation_date` datetime DEFAULT NULL,
+  PRIMARY KEY (`id`),
+  KEY `rprj_contacts_merges_0` (`survivor_id`),
+  KEY `rprj_contacts_merges_1` (`merged_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;
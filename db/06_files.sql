@@ -0,0 +1,8 @@
+--
+-- Files: relative path of the blob in the sharded layouts of the files
+-- directory (XX/YY/r_<id>_<name>), NULL for the legacy <father_id>/ layout
+--
+
+USE rproject;
+
+ALTER TABLE `rprj_files` ADD COLUMN `storage_key` varchar(255) DEFAULT NULL AFTER `alt_link`;
@@ -0,0 +1,29 @@
+--
+-- Files: revisions of the uploads. The current revision has no storage_key
+-- (its blob is the blob of the file), the older ones are archived under
+-- revisions/XX/YY/<file_id>_v<revision>
+--
+
+USE rproject;
+
+DROP TABLE IF EXISTS `rprj_files_revisions`;
+CREATE TABLE `rprj_files_revisions` (
+  `id` varchar(16) NOT NULL,
+  `file_id` varchar(16) NOT NULL,
+  `revision` int(11) NOT NULL,
+  `storage_key` varchar(255) DEFAULT NULL,
+  `filename` varchar(255) NOT NULL DEFAULT '',
+  `checksum` char(40) DEFAULT NULL,
+  `mime` varchar(255) DEFAULT NULL,
+  `size` bigint(20) NOT NULL DEFAULT 0,
+  `uploader` varchar(16) DEFAULT NULL,
+  `upload_date` datetime DEFAULT NULL,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `rprj_files_revisions_0` (`file_id`,`revision`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;
+
+--
+-- Folders: revisions kept for each file, NULL or 0 = all
+--
+
+ALTER TABLE `rprj_folders` ADD COLUMN `file_revisions` int(11) DEFAULT NULL AFTER `childs_sort_order`;
@@ -0,0 +1,17 @@
+--
+-- Files: deduplicated blobs of the content layout, stored once under
+-- blobs/XX/YY/<checksum>. refs counts the files and the archived revisions
+-- sharing the blob, which is deleted with its last reference
+--
+
+USE rproject;
+
+DROP TABLE IF EXISTS `rprj_files_blobs`;
+CREATE TABLE `rprj_files_blobs` (
+  `checksum` char(40) NOT NULL,
+  `storage_key` varchar(255) NOT NULL,
+  `size` bigint(20) NOT NULL DEFAULT 0,
+  `refs` int(11) NOT NULL DEFAULT 0,
+  `creation_date` datetime DEFAULT NULL,
+  PRIMARY KEY (`checksum`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;
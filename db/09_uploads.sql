@@ -0,0 +1,21 @@
+--
+-- Files: resumable (tus) uploads in progress. The chunks received are stored
+-- as uploads/<id>/<n>; the complete upload becomes the DBFile file_id
+--
+
+USE rproject;
+
+DROP TABLE IF EXISTS `rprj_files_uploads`;
+CREATE TABLE `rprj_files_uploads` (
+  `id` varchar(16) NOT NULL,
+  `owner` varchar(16) NOT NULL,
+  `upload_length` bigint(20) NOT NULL DEFAULT 0,
+  `upload_offset` bigint(20) NOT NULL DEFAULT 0,
+  `chunks` int(11) NOT NULL DEFAULT 0,
+  `metadata` text,
+  `file_id` varchar(16) DEFAULT NULL,
+  `creation_date` datetime DEFAULT NULL,
+  `expires` datetime DEFAULT NULL,
+  PRIMARY KEY (`id`),
+  KEY `rprj_files_uploads_0` (`expires`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;
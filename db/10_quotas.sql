@@ -0,0 +1,21 @@
+--
+-- Files: size of the blob of each file and storage usage per user (kind U)
+-- and per group (kind G). quota overrides the default of the configuration
+-- when not NULL (0 = unlimited).
+-- The sizes of the existing files are read by the recompute of the usage
+-- (POST /files/storage/usage/recompute or rhobee storage recompute)
+--
+
+USE rproject;
+
+ALTER TABLE `rprj_files` ADD COLUMN `size` bigint(20) DEFAULT NULL AFTER `storage_key`;
+
+DROP TABLE IF EXISTS `rprj_storage_usage`;
+CREATE TABLE `rprj_storage_usage` (
+  `kind` char(1) NOT NULL,
+  `subject_id` varchar(16) NOT NULL,
+  `bytes` bigint(20) NOT NULL DEFAULT 0,
+  `files` int(11) NOT NULL DEFAULT 0,
+  `quota` bigint(20) DEFAULT NULL,
+  PRIMARY KEY (`kind`,`subject_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;
@@ -0,0 +1,20 @@
+--
+-- Files: metadata of the images, read on upload (size as seen after the
+-- EXIF orientation, camera, capture time and location).
+-- Folders: EXIF stripped from the JPEG uploaded in them: NULL or '' = none,
+-- 'location' = the GPS data, 'private' = the location and the data
+-- identifying people and devices
+--
+
+USE rproject;
+
+ALTER TABLE `rprj_files` ADD COLUMN `image_width` int(11) DEFAULT NULL AFTER `size`;
+ALTER TABLE `rprj_files` ADD COLUMN `image_height` int(11) DEFAULT NULL AFTER `image_width`;
+ALTER TABLE `rprj_files` ADD COLUMN `camera` varchar(255) DEFAULT NULL AFTER `image_height`;
+ALTER TABLE `rprj_files` ADD COLUMN `taken_date` datetime DEFAULT NULL AFTER `camera`;
+ALTER TABLE `rprj_files` ADD COLUMN `gps_latitude` decimal(10,7) DEFAULT NULL AFTER `taken_date`;
+ALTER TABLE `rprj_files` ADD COLUMN `gps_longitude` decimal(10,7) DEFAULT NULL AFTER `gps_latitude`;
+ALTER TABLE `rprj_files` ADD KEY `rprj_files_taken_date` (`taken_date`);
+ALTER TABLE `rprj_files` ADD KEY `rprj_files_camera` (`camera`);
+
+ALTER TABLE `rprj_folders` ADD COLUMN `exif_policy` varchar(16) DEFAULT NULL AFTER `file_revisions`;
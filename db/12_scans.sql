@@ -0,0 +1,23 @@
+--
+-- Files: infected blobs found by the scanner (clamd), uploads rejected or
+-- quarantined as quarantine/<id>_<filename>, and stored blobs found by a rescan
+--
+
+USE rproject;
+
+DROP TABLE IF EXISTS `rprj_files_scans`;
+CREATE TABLE `rprj_files_scans` (
+  `id` varchar(16) NOT NULL,
+  `scan_date` datetime NOT NULL,
+  `user_id` varchar(16) DEFAULT NULL,
+  `file_id` varchar(16) DEFAULT NULL,
+  `filename` varchar(255) NOT NULL DEFAULT '',
+  `checksum` varchar(40) DEFAULT NULL,
+  `size` bigint(20) DEFAULT NULL,
+  `signature` varchar(255) NOT NULL DEFAULT '',
+  `action` varchar(16) NOT NULL DEFAULT '',
+  `quarantine_key` varchar(255) DEFAULT NULL,
+  PRIMARY KEY (`id`),
+  KEY `rprj_files_scans_0` (`scan_date`),
+  KEY `rprj_files_scans_1` (`file_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;
@@ -106,9 +106,21 @@ export async function requestFileTokens(fileIDs) {
     }
 }
 
+// Widths of the renditions offered to the browser for embedded images
+const SRCSET_WIDTHS = [480, 960, 1920];
+
+/**
+ * Build a srcset of server-side renditions (?w=) of an embedded image
+ */
+export function responsiveSrcset(baseUrl, token) {
+    const tokenParam = token ? `&token=${token}` : '';
+    return SRCSET_WIDTHS.map(w => `${baseUrl}?w=${w}${tokenParam} ${w}w`).join(', ');
+}
+
 /**
  * Inject tokens into HTML for viewing
  * Adds ?token=... to src/href attributes of elements with data-dbfile-id
+ * and a srcset of renditions to the images
  * Converts Quill classes to inline styles for proper display outside editor
  */
 export function injectTokensForViewing(html, tokens) {
@@ -120,6 +132,12 @@ export function injectTokensForViewing(html, tokens) {
     doc.querySelectorAll('[data-dbfile-id]').forEach(el => {
         const fileId = el.getAttribute('data-dbfile-id');
         const token = tokens[fileId];
+
+        if (el.tagName === 'IMG' && !el.hasAttribute('srcset')) {
+            const baseUrl = (el.getAttribute('src') || '').split('?')[0];
+            el.setAttribute('srcset', responsiveSrcset(baseUrl, token));
+            el.setAttribute('sizes', '(max-width: 960px) 100vw, 960px');
+        }
         
         if (token) {
             if (el.tagName === 'IMG') {
@@ -267,6 +267,7 @@ export function FolderEdit({ data, onSave, onCancel, onDelete, saving, error, da
         fk_obj_id: data.fk_obj_id || '0',
         permissions: data.permissions || 'rwxr-x---',
         childs_sort_order: data.childs_sort_order || '',
+        exif_policy: data.exif_policy || '',
     });
     const [children, setChildren] = useState([]);
     const [loadingChildren, setLoadingChildren] = useState(false);
@@ -503,6 +504,21 @@ export function FolderEdit({ data, onSave, onCancel, onDelete, saving, error, da
                 />
             </Form.Group>
 
+            <Form.Group className="mb-3">
+                <Form.Label>{t('folder.exif_policy')}</Form.Label>
+                <Form.Select
+                    name="exif_policy"
+                    value={formData.exif_policy}
+                    onChange={handleChange}
+                    disabled={saving}
+                >
+                    <option value="">{t('folder.exif_policy_keep')}</option>
+                    <option value="location">{t('folder.exif_policy_location')}</option>
+                    <option value="private">{t('folder.exif_policy_private')}</option>
+                </Form.Select>
+                <Form.Text className="text-secondary">{t('folder.exif_policy_hint')}</Form.Text>
+            </Form.Group>
+
             {/* Index Page Editor */}
             <div className="mb-4 p-3 border rounded">
                 <h5>{t('folder.index_page_editor')}</h5>